@@ -0,0 +1,180 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// MetadataKeyInputOffset records, in snapshots written by an Autosaver, the
+// number of input bytes whose values are contained in the snapshot.
+const MetadataKeyInputOffset = "bloom.input-offset"
+
+// Autosaver periodically writes snapshots of a filter while values from an
+// input are added to it, so that an interrupted ingestion can be resumed with
+// ResumeFilter. Each snapshot records the offset in the input up to which all
+// values were added; the input is expected to be processed in order.
+//
+// Snapshots are only written from Advance, i.e. while values are added, so the
+// filter is never written while it is modified.
+type Autosaver struct {
+	filter      *BloomFilter
+	path        string
+	gzip        bool
+	everyValues uint64
+	every       time.Duration
+	now         func() time.Time
+
+	offset    int64
+	pending   uint64
+	lastSave  time.Time
+	snapshots int
+}
+
+// NewAutosaver returns an Autosaver writing snapshots of the filter to the
+// given path whenever the given number of values was added or the given
+// interval has passed since the last snapshot, starting at the given input
+// offset (e.g. the one returned by ResumeFilter). A zero number of values or
+// interval disables the respective trigger.
+func NewAutosaver(filter *BloomFilter, path string, gzip bool, everyValues uint64, every time.Duration, offset int64) *Autosaver {
+	a := &Autosaver{
+		filter:      filter,
+		path:        path,
+		gzip:        gzip,
+		everyValues: everyValues,
+		every:       every,
+		now:         time.Now,
+		offset:      offset,
+	}
+	a.lastSave = a.now()
+	return a
+}
+
+// Advance records that the values of the input up to the given offset were
+// added to the filter, the given number of them since the previous call, and
+// writes a snapshot if one is due.
+func (a *Autosaver) Advance(offset int64, values uint64) error {
+	a.offset = offset
+	a.pending += values
+	if a.pending == 0 {
+		return nil
+	}
+	if (a.everyValues > 0 && a.pending >= a.everyValues) ||
+		(a.every > 0 && a.now().Sub(a.lastSave) >= a.every) {
+		return a.Save()
+	}
+	return nil
+}
+
+// Save writes a snapshot of the filter and the current offset. The snapshot is
+// written to a temporary file in the same directory first, which then
+// replaces the previous snapshot, so that a crash never leaves a partial
+// snapshot behind.
+func (a *Autosaver) Save() error {
+	if err := writeFilterAtomically(a.filter, a.path, a.gzip,
+		withMetadata(MetadataKeyInputOffset, strconv.FormatInt(a.offset, 10))); err != nil {
+		return err
+	}
+	a.pending = 0
+	a.lastSave = a.now()
+	a.snapshots++
+	return nil
+}
+
+// tempFileWriter returns the writer writeFilterAtomically writes its
+// temporary file with, which tests replace to simulate a full disk.
+var tempFileWriter = func(f *os.File) io.Writer {
+	return f
+}
+
+// writeFilterAtomically writes the filter like WriteFilter to a temporary file
+// in the same directory as path, which is synced to stable storage and then
+// replaces the file at path, so that a crash never leaves a partially written
+// filter behind. If any of the writes, the sync or the rename fails, the file
+// at path is left unaltered and the error is returned.
+func writeFilterAtomically(filter *BloomFilter, path string, gzip bool, opts ...WriteOption) error {
+	wo := newWriteOptions(opts)
+	if err := filter.checkDataSize(wo); err != nil {
+		return err
+	}
+	start := time.Now()
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	var n int64
+	// the permissions of a file created by WriteFilter
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+	} else {
+		n, err = writeFilterFile(tmp, tempFileWriter(tmp), filter, gzip, opts)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	wo.logger.Debug("wrote filter", LogKeyPath, path, LogKeyBytes, n, LogKeyGzip, gzip, LogKeyDuration, time.Since(start))
+	// the rename itself is only durable once the directory is synced
+	return syncDir(filepath.Dir(path))
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir commits the entries of a directory to stable storage. Directories
+// cannot be synced on Windows, where renames are durable without it.
+func syncDir(path string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return syncFile(path)
+}
+
+// Offset returns the input offset recorded by the last call to Advance.
+func (a *Autosaver) Offset() int64 {
+	return a.offset
+}
+
+// Snapshots returns the number of snapshots written.
+func (a *Autosaver) Snapshots() int {
+	return a.snapshots
+}
+
+// ResumeFilter loads a snapshot written by an Autosaver and returns the
+// filter along with the input offset up to which its values were added, from
+// which the ingestion is to be continued. The offset is removed from the
+// metadata of the filter. If the file was not written by an Autosaver, the
+// offset is -1.
+func ResumeFilter(path string, gzip bool, opts ...LoadOption) (*BloomFilter, int64, error) {
+	filter, err := LoadFilter(path, gzip, opts...)
+	if err != nil {
+		return nil, 0, err
+	}
+	v, ok := filter.Metadata(MetadataKeyInputOffset)
+	if !ok {
+		return filter, -1, nil
+	}
+	offset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || offset < 0 {
+		return nil, 0, fmt.Errorf("invalid input offset %q in %s", v, path)
+	}
+	filter.DeleteMetadata(MetadataKeyInputOffset)
+	return filter, offset, nil
+}
@@ -0,0 +1,154 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var errKilled = errors.New("killed")
+
+// ingestLines adds the lines of the input from the given offset, advancing
+// the autosaver after each line, and stops with errKilled after the given
+// number of lines if kill is positive. It returns the added lines.
+func ingestLines(filter *BloomFilter, autosaver *Autosaver, input []byte, offset int64, kill int) ([]string, error) {
+	var added []string
+	for offset < int64(len(input)) {
+		if kill > 0 && len(added) == kill {
+			return added, errKilled
+		}
+		end := bytes.IndexByte(input[offset:], '\n')
+		line := input[offset : offset+int64(end)]
+		filter.Add(line)
+		added = append(added, string(line))
+		offset += int64(end) + 1
+		if err := autosaver.Advance(offset, 1); err != nil {
+			return added, err
+		}
+	}
+	return added, nil
+}
+
+func TestAutosaveResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.bloom")
+
+	var input bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "value-%d\n", i)
+	}
+
+	// the first run is killed after 250 lines, the last snapshot being
+	// written after 200 lines
+	filter := mustNew(10000, 0.001)
+	autosaver := NewAutosaver(filter, path, true, 100, 0, 0)
+	_, err = ingestLines(filter, autosaver, input.Bytes(), 0, 250)
+	if err != errKilled {
+		t.Fatalf("expected the ingestion to be killed, got %v", err)
+	}
+	if autosaver.Snapshots() != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", autosaver.Snapshots())
+	}
+
+	resumed, offset, err := ResumeFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resumed.Metadata(MetadataKeyInputOffset); ok {
+		t.Fatal("offset not removed from the metadata of the resumed filter")
+	}
+	autosaver = NewAutosaver(resumed, path, true, 100, 0, offset)
+	second, err := ingestLines(resumed, autosaver, input.Bytes(), offset, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot covers exactly the first 200 lines, and the second run
+	// continues with the line after them
+	lines := bytes.Split(bytes.TrimSuffix(input.Bytes(), []byte("\n")), []byte("\n"))
+	if len(second) != 800 || second[0] != string(lines[200]) {
+		t.Fatalf("resumed at the wrong line (%d lines, first %q)", len(second), second[0])
+	}
+
+	expected := mustNew(10000, 0.001)
+	for _, line := range lines {
+		expected.Add(line)
+	}
+	if resumed.N != expected.N {
+		t.Fatalf("unexpected count %d vs. %d", resumed.N, expected.N)
+	}
+	for i := range expected.v {
+		if resumed.v[i] != expected.v[i] {
+			t.Fatalf("resumed filter differs at word %d", i)
+		}
+	}
+
+	// the last snapshot covers the complete input
+	_, offset, err = ResumeFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != int64(input.Len()) {
+		t.Fatalf("unexpected offset %d of the last snapshot", offset)
+	}
+}
+
+func TestAutosaveInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.bloom")
+
+	now := time.Unix(0, 0)
+	filter := mustNew(1000, 0.01)
+	autosaver := NewAutosaver(filter, path, false, 0, time.Minute, 0)
+	autosaver.now = func() time.Time { return now }
+	autosaver.lastSave = now
+
+	filter.Add([]byte("foo"))
+	if err := autosaver.Advance(4, 1); err != nil {
+		t.Fatal(err)
+	}
+	if autosaver.Snapshots() != 0 {
+		t.Fatal("unexpected snapshot before the interval passed")
+	}
+	now = now.Add(time.Minute)
+	if err := autosaver.Advance(4, 0); err != nil {
+		t.Fatal(err)
+	}
+	if autosaver.Snapshots() != 1 {
+		t.Fatal("expected a snapshot after the interval passed")
+	}
+	// no snapshot is written without new values
+	now = now.Add(time.Hour)
+	if err := autosaver.Advance(5, 0); err != nil {
+		t.Fatal(err)
+	}
+	if autosaver.Snapshots() != 1 {
+		t.Fatal("unexpected snapshot without new values")
+	}
+
+	if _, offset, err := ResumeFilter(path, false); err != nil || offset != 4 {
+		t.Fatalf("unexpected offset %d (%v)", offset, err)
+	}
+	if err := WriteFilter(filter, path, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, offset, err := ResumeFilter(path, false); err != nil || offset != -1 {
+		t.Fatalf("expected no offset in a regular filter, got %d (%v)", offset, err)
+	}
+}
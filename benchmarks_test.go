@@ -0,0 +1,218 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/bloomtest"
+)
+
+// The benchmarks below compare the hash functions in bloomtest.HashFunctions
+// over the value lengths and filter sizes of
+// bloomtest.DefaultHashBenchConfig. Changes to hashing should include their
+// results, together with the latency report of `bloom bench --compare-hashes`.
+
+func benchmarkHashes(b *testing.B, op func(filter *bloom.BloomFilter, hash uint64, fingerprint []uint64)) {
+	config := bloomtest.DefaultHashBenchConfig
+	for _, bits := range config.FilterBits {
+		filter, err := bloomtest.NewFilterWithBits(bits, 0.01)
+		if err != nil {
+			b.Fatal(err)
+		}
+		fingerprint := make([]uint64, filter.NumHashFuncs())
+		for _, length := range config.ValueLengths {
+			values := bloomtest.RandomValues(1024, length, config.Seed)
+			for _, hf := range bloomtest.HashFunctions {
+				// the values are checked as members, i.e. with all probes
+				for _, value := range values {
+					filter.FingerprintHash(hf.Sum(value), fingerprint)
+					filter.AddFingerprint(fingerprint)
+				}
+				b.Run(fmt.Sprintf("hash=%s/len=%d/bits=%.0e", hf.Name, length, float64(bits)), func(b *testing.B) {
+					b.SetBytes(int64(length))
+					for i := 0; i < b.N; i++ {
+						op(filter, hf.Sum(values[i%len(values)]), fingerprint)
+					}
+				})
+			}
+		}
+	}
+}
+
+func BenchmarkHashAdd(b *testing.B) {
+	benchmarkHashes(b, func(filter *bloom.BloomFilter, hash uint64, fingerprint []uint64) {
+		filter.FingerprintHash(hash, fingerprint)
+		filter.AddFingerprint(fingerprint)
+	})
+}
+
+func BenchmarkHashCheck(b *testing.B) {
+	benchmarkHashes(b, func(filter *bloom.BloomFilter, hash uint64, fingerprint []uint64) {
+		filter.FingerprintHash(hash, fingerprint)
+		filter.CheckFingerprint(fingerprint)
+	})
+}
+
+// BenchmarkConcurrentAdd compares adding values to a filter from many
+// goroutines at once: "mutex" serializes Add with a single lock, "atomic" sets
+// the bits with atomic operations and "striped" takes the lock of the stripe
+// holding each bit, with stripes padded to cache lines.
+func BenchmarkConcurrentAdd(b *testing.B) {
+	values := bloomtest.NewValueStream(bloomtest.ValueStreamConfig{Seed: 1}).Take(1 << 16)
+	modes := []struct {
+		name string
+		add  func(filter *bloom.BloomFilter) func(value []byte)
+	}{
+		{"mutex", func(filter *bloom.BloomFilter) func(value []byte) {
+			var mu sync.Mutex
+			return func(value []byte) {
+				mu.Lock()
+				filter.Add(value)
+				mu.Unlock()
+			}
+		}},
+		{"atomic", func(filter *bloom.BloomFilter) func(value []byte) {
+			return bloom.NewStripedWriter(filter, 1, bloom.WithAtomicStripes()).Add
+		}},
+		{"striped", func(filter *bloom.BloomFilter) func(value []byte) {
+			return bloom.NewStripedWriter(filter, 1024, bloom.WithStripePadding()).Add
+		}},
+	}
+	for _, goroutines := range []int{8, 32, 96} {
+		for _, mode := range modes {
+			b.Run(fmt.Sprintf("goroutines=%d/mode=%s", goroutines, mode.name), func(b *testing.B) {
+				filter, err := bloom.New(10000000, 0.001)
+				if err != nil {
+					b.Fatal(err)
+				}
+				add := mode.add(filter)
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for g := 0; g < goroutines; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := g; i < b.N; i += goroutines {
+							add(values[i%len(values)])
+						}
+					}(g)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// BenchmarkConsumeChannelDedup compares consuming bursty input, in which most
+// values repeat one of the last few, with and without a dedup window.
+func BenchmarkConsumeChannelDedup(b *testing.B) {
+	values := bloomtest.NewValueStream(bloomtest.ValueStreamConfig{
+		Seed:            1,
+		Lengths:         bloomtest.FixedLength(64),
+		DuplicateProb:   0.9,
+		DuplicateWindow: 32,
+	}).Take(1 << 16)
+	for _, window := range []int{0, 64, 1024} {
+		b.Run(fmt.Sprintf("window=%d", window), func(b *testing.B) {
+			filter, err := bloom.New(10000000, 0.001)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ch := make(chan []byte, bloom.DefaultIngestBatchSize)
+			go func() {
+				for i := 0; i < b.N; i++ {
+					ch <- values[i%len(values)]
+				}
+				close(ch)
+			}()
+			b.SetBytes(64)
+			stats, err := filter.ConsumeChannel(context.Background(), ch, bloom.WithDedupWindow(window))
+			if err != nil {
+				b.Fatal(err)
+			}
+			if stats.Consumed > 0 {
+				b.ReportMetric(float64(stats.DedupHits)/float64(stats.Consumed), "hits/op")
+			}
+		})
+	}
+}
+
+// BenchmarkNewTiny measures the memory and allocations of creating many small
+// filters, whose bit arrays of up to 1024 bits are allocated together with
+// the filter, and of slightly larger ones with a separate allocation.
+func BenchmarkNewTiny(b *testing.B) {
+	for _, capacity := range []uint64{10, 100, 200, 1000} {
+		b.Run(fmt.Sprintf("n=%d", capacity), func(b *testing.B) {
+			b.ReportAllocs()
+			filters := make([]*bloom.BloomFilter, 1024)
+			for i := 0; i < b.N; i++ {
+				filter, err := bloom.New(capacity, 0.01)
+				if err != nil {
+					b.Fatal(err)
+				}
+				filters[i%len(filters)] = filter
+			}
+		})
+	}
+}
+
+// holdTimer is a lock recording the longest time it was held.
+type holdTimer struct {
+	mu     sync.Mutex
+	locked time.Time
+	max    time.Duration
+}
+
+func (l *holdTimer) Lock() {
+	l.mu.Lock()
+	l.locked = time.Now()
+}
+
+func (l *holdTimer) Unlock() {
+	if held := time.Since(l.locked); held > l.max {
+		l.max = held
+	}
+	l.mu.Unlock()
+}
+
+// BenchmarkPersisterStall measures how long adding a value can wait for the
+// lock while a Persister copies the filter for a snapshot, which it does in
+// chunks of 256 KiB. Each operation adds a value and writes a snapshot, to a
+// writer discarding it; the longest time the lock was held is reported.
+func BenchmarkPersisterStall(b *testing.B) {
+	values := bloomtest.NewValueStream(bloomtest.ValueStreamConfig{Seed: 1}).Take(1 << 16)
+	for _, capacity := range []uint64{1000000, 100000000} {
+		b.Run(fmt.Sprintf("n=%d", capacity), func(b *testing.B) {
+			filter, err := bloom.New(capacity, 0.001)
+			if err != nil {
+				b.Fatal(err)
+			}
+			lock := &holdTimer{}
+			p := bloom.NewPersister(filter, lock, "", false, time.Hour, 0, bloom.WithPersistWriter(func(*bloom.BloomFilter) error {
+				return nil
+			}))
+			defer p.Close(context.Background())
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				lock.Lock()
+				filter.Add(values[i%len(values)])
+				lock.Unlock()
+				if err := p.Flush(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			lock.Lock()
+			held := lock.max
+			lock.Unlock()
+			b.ReportMetric(float64(held), "max-hold-ns")
+		})
+	}
+}
@@ -1,8 +1,6 @@
 // DCSO go bloom filter
 // Copyright (c) 2017, DCSO GmbH
 
-//Implements a simple and highly efficient variant of the Bloom filter that uses only two hash functions.
-
 package bloom
 
 import (
@@ -13,12 +11,21 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+
+	"github.com/DCSO/bloom/internal/bitset"
 )
 
 // BloomFilter represents a Bloom filter, a data structure for quickly checking
 // for set membership, with a specific desired capacity and false positive
 // probability.
 type BloomFilter struct {
+	//filters must not be copied, see noCopy
+	noCopy noCopy
+
+	//incremented whenever the result of Check may change (accessed
+	//atomically, first word for 64-bit alignment)
+	generation uint64
+
 	//bit array
 	v []uint64
 
@@ -35,85 +42,225 @@ type BloomFilter struct {
 	m uint64
 
 	//number of elements in the filter
+	//
+	//Deprecated: use NumElements and SetNumElements, which keep working when
+	//the way the count is kept changes.
 	N uint64
 
 	//number of 64-bit integers (generated automatically)
 	M uint64
 
-	//arbitrary data that we can attach to the filter
+	//arbitrary data that we can attach to the filter, owned by the filter
+	//(prefer GetData and SetData, which copies it)
 	Data []byte
+
+	//metadata key/value pairs (version 2 of the file format)
+	meta map[string]string
+
+	//line of the comment region (version 2 of the file format)
+	comment string
+
+	//externally stored data, if read with FormatFlagExternalData
+	external *externalData
+
+	//maximum value length (stored in the metadata) and policy for longer values
+	maxValueLength    uint64
+	valueLengthPolicy ValueLengthPolicy
+	exclusions        exclusionSet
+
+	//sidecar for exact counting during construction (not serialized)
+	exactCounter *exactCounter
+
+	//normalizers applied by LineWriter (not serialized)
+	lineNormalizers []Normalizer
+
+	//progress of reading by NewFilterFromTextFile (not serialized)
+	readProgress func(read int64)
+
+	//maximum number of hash functions of New, see WithMaxHashFuncs (not
+	//serialized)
+	hashFuncsLimit *hashFuncsLimit
+
+	//number of modifications, see Changes (not serialized)
+	changes uint64
 }
 
-// Read loads a filter from a reader object.
-func (s *BloomFilter) Read(input io.Reader) error {
-	bs8 := make([]byte, 8)
+// DefaultMaxDataSize is the maximum size in bytes of the Data section that
+// Read accepts and Write emits, unless overridden using the MaxDataSize option.
+// A value of zero or less disables the limit.
+var DefaultMaxDataSize int64 = 256 << 20
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
+// ErrDataTooLarge is returned when the Data section of a filter exceeds the
+// maximum allowed size.
+var ErrDataTooLarge = errors.New("data section exceeds maximum size")
+
+// Read loads a filter from a reader object. The filter owns all of the memory
+// it reads into, so the reader may reuse its buffers afterwards.
+func (s *BloomFilter) Read(input io.Reader, opts ...LoadOption) error {
+	lo := newLoadOptions(opts)
+	defer s.invalidate()
+
+	header := make([]byte, FormatHeaderSize)
+	if _, err := io.ReadFull(input, header[:FormatCapacityOffset]); err != nil {
 		return err
 	}
 
-	flags := binary.LittleEndian.Uint64(bs8)
+	if err := checkVersion(header); err != nil {
+		return err
+	}
 
-	if flags&0xFF != 1 {
-		return fmt.Errorf("Invalid version bit (should be 1)")
+	if _, err := io.ReadFull(input, header[FormatCapacityOffset:]); err != nil {
+		return err
 	}
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
+	size, err := commentSize(header)
+	if err != nil {
 		return err
 	}
+	if size > 0 {
+		header = append(header, make([]byte, size)...)
+		if _, err := io.ReadFull(input, header[FormatCommentOffset:]); err != nil {
+			return err
+		}
+	}
 
-	s.n = binary.LittleEndian.Uint64(bs8)
+	if err := s.parseHeader(header); err != nil {
+		return err
+	}
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
+	if err := readWords(input, s.v); err != nil {
 		return err
 	}
 
-	s.p = math.Float64frombits(binary.LittleEndian.Uint64(bs8))
+	return s.readSections(input, header, lo)
+}
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
+// checkVersion checks the version and the required flags of a header, of
+// which at least the flags field is given.
+func checkVersion(header []byte) error {
+	flags := binary.LittleEndian.Uint64(header[FormatFlagsOffset:])
+	version := int(flags & FormatVersionMask)
+	if version != FormatVersion1 && version != FormatVersion2 {
+		return &UnsupportedVersionError{Found: version, Supported: SupportedFormatVersions()}
+	}
+	if err := checkHashScheme(flags); err != nil {
 		return err
 	}
+	if unknown := flags & FormatRequiredFlagsMask &^ FormatHashSchemeMask &^ knownRequiredFlags; unknown != 0 {
+		return &UnsupportedFlagsError{Flags: unknown}
+	}
+	return nil
+}
 
-	s.k = binary.LittleEndian.Uint64(bs8)
+// parseHeader sets the parameters and the comment of the filter from a
+// complete header, followed by the comment region if any, and allocates its
+// bit array.
+func (s *BloomFilter) parseHeader(header []byte) error {
+	s.comment = decodeComment(header[FormatHeaderSize:])
+	s.n = binary.LittleEndian.Uint64(header[FormatCapacityOffset:])
+	s.p = math.Float64frombits(binary.LittleEndian.Uint64(header[FormatFPPOffset:]))
+	// a NaN would make the filter unjoinable and its estimates meaningless
+	if err := checkFPP(s.p); err != nil {
+		return fmt.Errorf("value of p is invalid: %w", err)
+	}
+	s.k = binary.LittleEndian.Uint64(header[FormatHashFuncsOffset:])
 	maxInt := uint64(int(^uint(0) >> 1))
 	if s.k >= maxInt {
 		return fmt.Errorf("value of k (number of hash functions) is too high (%d), must be less than maximum int (%d)", s.k, maxInt)
 	}
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
+	s.m = binary.LittleEndian.Uint64(header[FormatNumBitsOffset:])
+	s.SetNumElements(binary.LittleEndian.Uint64(header[FormatCountOffset:]))
+
+	if err := checkSize(s.m); err != nil {
 		return err
 	}
 
-	s.m = binary.LittleEndian.Uint64(bs8)
+	s.M = numWords(s.m)
 
-	if _, err := io.ReadFull(input, bs8); err != nil {
-		return err
-	}
+	s.v = allocWords(nil, s.M)
+	return nil
+}
 
-	s.N = binary.LittleEndian.Uint64(bs8)
+// inlineWords is the maximum number of words of a bit array that New
+// allocates together with the filter (1024 bits, e.g. for 100 values at an
+// FP probability of 1%), which saves an allocation for each of many tiny
+// filters.
+const inlineWords = 16
+
+// tinyFilter is a filter allocated together with the storage of a bit array
+// of up to inlineWords words.
+type tinyFilter struct {
+	BloomFilter
+	words [inlineWords]uint64
+}
 
-	s.M = uint64(math.Ceil(float64(s.m) / 64.0))
+// allocFilter returns a new, empty filter and, if a bit array of M words is
+// stored inline, the storage for it allocated together with the filter.
+func allocFilter(M uint64) (*BloomFilter, []uint64) {
+	if M > inlineWords {
+		return &BloomFilter{}, nil
+	}
+	t := &tinyFilter{}
+	return &t.BloomFilter, t.words[:]
+}
 
-	s.v = make([]uint64, s.M)
+// allocWords returns a zeroed bit array of M words, which uses the zeroed
+// storage if it is large enough.
+func allocWords(storage []uint64, M uint64) []uint64 {
+	if M <= uint64(len(storage)) {
+		return storage[:M:M]
+	}
+	return make([]uint64, M)
+}
 
-	for i := uint64(0); i < s.M; i++ {
-		n, err := io.ReadFull(input, bs8)
+// readSections reads the sections following the bit array, i.e. the metadata
+// of version 2 and the Data section, given the header of the filter.
+func (s *BloomFilter) readSections(input io.Reader, header []byte, lo loadOptions) error {
+	s.meta = nil
+	if binary.LittleEndian.Uint64(header[FormatFlagsOffset:])&FormatVersionMask == FormatVersion2 {
+		meta, err := readMetadata(input)
 		if err != nil {
 			return err
 		}
-		if n != 8 {
-			return fmt.Errorf("Cannot read from file: %d, position: %d, %d", n, i*8, len(bs8))
-		}
-		s.v[i] = binary.LittleEndian.Uint64(bs8)
+		s.meta = meta
+	}
+	if err := s.loadValueLengthLimit(); err != nil {
+		return err
+	}
+	if err := s.loadExclusions(); err != nil {
+		return err
 	}
 
-	b, err := ioutil.ReadAll(input)
+	dataReader := input
+	if lo.maxDataSize > 0 {
+		dataReader = io.LimitReader(input, lo.maxDataSize+1)
+	}
+	var b []byte
+	var err error
+	if lo.zeroCopyData && lo.dataSource != nil {
+		// the Data section is the rest of the input, which is only counted
+		var n int64
+		n, err = io.Copy(ioutil.Discard, dataReader)
+		end := len(lo.dataSource)
+		b = lo.dataSource[end-int(n) : end : end]
+	} else {
+		b, err = ioutil.ReadAll(dataReader)
+	}
 
 	if err != nil {
 		return err
 	}
 
-	s.Data = b
+	if lo.maxDataSize > 0 && int64(len(b)) > lo.maxDataSize {
+		return fmt.Errorf("%w (more than %d bytes)", ErrDataTooLarge, lo.maxDataSize)
+	}
+
+	if binary.LittleEndian.Uint64(header[FormatFlagsOffset:])&FormatFlagExternalData != 0 {
+		return s.setExternalData(b, lo)
+	}
+	// b was allocated for the filter, unless requested with ZeroCopyData
+	s.SetDataNoCopy(b)
 
 	return nil
 
@@ -141,47 +288,202 @@ func (s *BloomFilter) FalsePositiveProb() float64 {
 	return s.p
 }
 
+// NumElements returns the number of elements added to the Bloom filter, which
+// is an estimate if CountIsEstimate returns true.
+func (s *BloomFilter) NumElements() uint64 {
+	return s.N
+}
+
+// SetNumElements sets the number of elements added to the Bloom filter, e.g.
+// when it is known from another source.
+func (s *BloomFilter) SetNumElements(n uint64) {
+	s.N = n
+	s.changes++
+}
+
+// Changes returns a counter of the modifications of the filter, which is
+// incremented whenever values are added, filters are joined, or the count,
+// the Data or the metadata are set, e.g. to find out whether the filter
+// changed since it was last written (see Persister). Like the filter, it
+// must not be read while the filter is modified.
+func (s *BloomFilter) Changes() uint64 {
+	return s.changes
+}
+
+// GetData returns the data attached to the Bloom filter, which is owned by
+// the filter and must not be modified. If it is stored externally, it is
+// fetched first with DataLazy, and nil is returned if it was not fetched (see
+// LoadData).
+func (s *BloomFilter) GetData() []byte {
+	if s.external != nil {
+		if s.external.lazy {
+			s.LoadData()
+		}
+		return s.external.data
+	}
+	return s.Data
+}
+
+// SetData attaches a copy of data to the Bloom filter, replacing any previous
+// data, including a reference to externally stored data.
+func (s *BloomFilter) SetData(data []byte) {
+	if data != nil {
+		data = append(make([]byte, 0, len(data)), data...)
+	}
+	s.SetDataNoCopy(data)
+}
+
+// SetDataNoCopy attaches data to the Bloom filter like SetData, but without
+// copying it, so that the filter owns data afterwards and the caller must not
+// modify it.
+func (s *BloomFilter) SetDataNoCopy(data []byte) {
+	s.Data = data
+	s.changes++
+	if s.external != nil {
+		s.external = nil
+		delete(s.meta, MetadataKeyExternalData)
+		delete(s.meta, MetadataKeyExternalDataSHA256)
+		delete(s.meta, MetadataKeyExternalDataSize)
+	}
+}
+
+// DataSize returns the size in bytes of the data attached to the Bloom filter,
+// without fetching it if it is stored externally.
+func (s *BloomFilter) DataSize() uint64 {
+	if s.external != nil {
+		return uint64(s.external.size)
+	}
+	return uint64(len(s.GetData()))
+}
+
+// checkDataSize checks the size of the data written to the Data section,
+// which is empty if it is stored externally.
+func (s *BloomFilter) checkDataSize(wo writeOptions) error {
+	if s.external != nil || wo.externalData != "" {
+		return nil
+	}
+	if wo.maxDataSize > 0 && int64(len(s.GetData())) > wo.maxDataSize {
+		return fmt.Errorf("%w (%d > %d bytes)", ErrDataTooLarge, len(s.GetData()), wo.maxDataSize)
+	}
+	return nil
+}
+
 // Write writes the binary representation of a Bloom filter to an io.Writer.
-func (s *BloomFilter) Write(output io.Writer) error {
-	bs8 := make([]byte, 8)
-
-	// we write the version bit
-	binary.LittleEndian.PutUint64(bs8, 1)
-	output.Write(bs8)
-
-	binary.LittleEndian.PutUint64(bs8, s.n)
-	output.Write(bs8)
-	binary.LittleEndian.PutUint64(bs8, math.Float64bits(s.p))
-	output.Write(bs8)
-	binary.LittleEndian.PutUint64(bs8, s.k)
-	output.Write(bs8)
-	binary.LittleEndian.PutUint64(bs8, s.m)
-	output.Write(bs8)
-	binary.LittleEndian.PutUint64(bs8, s.N)
-	output.Write(bs8)
-
-	for i := uint64(0); i < s.M; i++ {
-		binary.LittleEndian.PutUint64(bs8, s.v[i])
-		n, err := output.Write(bs8)
-		if n != 8 {
-			return errors.New("Cannot write to file!")
+// If the metadata section would exceed the size Read accepts, an error
+// wrapping ErrMetadataTooLarge is returned and nothing is written.
+func (s *BloomFilter) Write(output io.Writer, opts ...WriteOption) error {
+	wo := newWriteOptions(opts)
+	if err := s.checkDataSize(wo); err != nil {
+		return err
+	}
+	external := s.externalMetadata(wo)
+
+	comment := s.comment
+	if wo.comment != nil {
+		comment = s.describe(*wo.comment, wo.reproducible)
+	}
+	// a filter with a comment is written in version 2 anyway, so it records
+	// the default producer
+	producer := wo.producer
+	if producer == "" && comment != "" {
+		producer = wo.defaultProducer
+	}
+
+	meta := s.meta
+	if (wo.reproducible && !s.CountIsEstimate()) || wo.exclusions != nil || wo.metadata != nil || len(meta) > 0 || producer != "" || external != nil {
+		meta = make(map[string]string, len(s.meta)+len(wo.metadata)+3)
+		for k, v := range s.meta {
+			meta[k] = v
 		}
-		if err != nil {
+		for k, v := range wo.metadata {
+			meta[k] = v
+		}
+		if wo.reproducible {
+			meta[MetadataKeyCount] = "estimated"
+		}
+		if wo.exclusions != nil {
+			exclusions, err := s.encodeExclusions(wo.exclusions)
+			if err != nil {
+				return err
+			}
+			meta[MetadataKeyExclusions] = exclusions
+		}
+		for k, v := range external {
+			meta[k] = v
+		}
+		if len(meta) > 0 || producer != "" {
+			if producer == "" {
+				producer = wo.defaultProducer
+			}
+			meta[MetadataKeyProducer] = producerString(producer)
+		}
+	}
+
+	bs8 := make([]byte, FormatWordSize)
+
+	// we write the version bit, filters with metadata or a comment use
+	// version 2
+	version := uint64(FormatVersion1)
+	if len(meta) > 0 || comment != "" {
+		version = FormatVersion2
+	}
+	// the metadata is checked before anything is written, as a larger section
+	// cannot be read back
+	var encoded []byte
+	if version == FormatVersion2 {
+		encoded = encodeMetadata(meta)
+		if len(encoded) > maxMetadataSize {
+			return fmt.Errorf("%w: metadata section of %d bytes (maximum is %d)", ErrMetadataTooLarge, len(encoded), maxMetadataSize)
+		}
+	}
+	flags := version
+	// the data is not written if stored externally, whether newly or as read
+	externalData := external != nil || s.external != nil
+	if externalData {
+		flags |= FormatFlagExternalData
+	}
+	var region []byte
+	if comment != "" {
+		region = encodeComment(comment)
+		flags |= FormatFlagComment | uint64(len(region)/FormatWordSize)<<FormatCommentSizeShift
+	}
+	header := make([]byte, FormatHeaderSize, FormatHeaderSize+len(region))
+	binary.LittleEndian.PutUint64(header[FormatFlagsOffset:], flags)
+	binary.LittleEndian.PutUint64(header[FormatCapacityOffset:], s.n)
+	binary.LittleEndian.PutUint64(header[FormatFPPOffset:], math.Float64bits(s.p))
+	binary.LittleEndian.PutUint64(header[FormatHashFuncsOffset:], s.k)
+	binary.LittleEndian.PutUint64(header[FormatNumBitsOffset:], s.m)
+	if wo.reproducible {
+		wo.logger.Debug("writing estimated element count", LogKeyCount, s.EstimatedNumElements())
+		binary.LittleEndian.PutUint64(header[FormatCountOffset:], s.EstimatedNumElements())
+	} else {
+		binary.LittleEndian.PutUint64(header[FormatCountOffset:], s.NumElements())
+	}
+	output.Write(append(header, region...))
+
+	if err := writeWords(output, s.v); err != nil {
+		return err
+	}
+	if version == FormatVersion2 {
+		binary.LittleEndian.PutUint64(bs8, uint64(len(encoded)))
+		output.Write(bs8)
+		if _, err := output.Write(encoded); err != nil {
 			return err
 		}
 	}
-	if s.Data != nil {
-		output.Write(s.Data)
+	if !externalData && s.GetData() != nil {
+		output.Write(s.GetData())
 	}
 	return nil
 }
 
 // Reset clears the Bloom filter of all elements.
 func (s *BloomFilter) Reset() {
-	for i := uint64(0); i < s.M; i++ {
-		s.v[i] = 0
-	}
-	s.N = 0
+	bitset.Clear(s.v)
+	s.invalidate()
+	s.dropExactCount()
+	s.SetNumElements(0)
+	s.DeleteMetadata(MetadataKeyCount)
 }
 
 // this is the largest prime number < 2^64. As we will probably never encounter
@@ -200,54 +502,207 @@ const g uint64 = 18446744073709550147
 // Fingerprint returns the fingerprint of a given value, as an array of index
 // values.
 func (s *BloomFilter) Fingerprint(value []byte, fingerprint []uint64) {
+	s.probeIndexes(s.Hash(value), fingerprint[:s.k])
+}
+
+// Hash returns the hash of a given value from which its fingerprint is
+// derived, e.g. to be passed to CheckFirstProbeHash.
+func (s *BloomFilter) Hash(value []byte) uint64 {
+	value = s.truncate(value)
 	hv := fnv.New64()
 	hv.Write(value)
-	hn := hv.Sum64() % m
+	return hv.Sum64() % m
+}
+
+// FingerprintHash computes the fingerprint for a hash precomputed with Hash,
+// or for the hash of a value computed by other means, e.g. for comparing hash
+// functions.
+func (s *BloomFilter) FingerprintHash(hash uint64, fingerprint []uint64) {
+	s.probeIndexes(hash%m, fingerprint[:s.k])
+}
 
-	for i := uint64(0); i < s.k; i++ {
+// ProbeIndexes computes the first k index values of the fingerprint of a
+// given value into out, which must hold at least k values.
+func (s *BloomFilter) ProbeIndexes(value []byte, k int, out []uint64) {
+	s.probeIndexes(s.Hash(value), out[:k])
+}
+
+func (s *BloomFilter) probeIndexes(hn uint64, out []uint64) {
+	for i := range out {
 		hn = (hn * g) % m
-		fingerprint[i] = uint64(hn % s.m)
+		out[i] = uint64(hn % s.m)
 	}
 }
 
+// CheckFirstProbe tests only the first index of the fingerprint of a given
+// value, which takes a single memory access. It returns false if the value is
+// definitely not in the Bloom filter. As the result is true for a non-member
+// with a probability of about the fill ratio of the filter (see NumSetBits),
+// i.e. a much higher false positive rate than that of Check, it is meant for
+// pre-screening values that are then checked with Check. The exclusion set of
+// a filter is not consulted.
+func (s *BloomFilter) CheckFirstProbe(value []byte) bool {
+	if s.rejects(value) {
+		return false
+	}
+	return s.CheckFirstProbeHash(s.Hash(value))
+}
+
+// CheckFirstProbeHash is like CheckFirstProbe for a hash precomputed with
+// Hash.
+func (s *BloomFilter) CheckFirstProbeHash(hash uint64) bool {
+	index := (hash * g) % m % s.m
+	return bitset.Test(s.v, index)
+}
+
 // Add adds a byte array element to the Bloom filter.
 func (s *BloomFilter) Add(value []byte) {
-	var k, l uint64
-	newValue := false
+	if s.rejects(value) {
+		return
+	}
+	s.add(value)
+}
+
+// TestAndAdd adds a value like Add and returns true if it may have been in the
+// filter before, i.e. if all of its bits were already set, hashing it only
+// once. A rejected value is not added and false is returned.
+func (s *BloomFilter) TestAndAdd(value []byte) bool {
+	if s.rejects(value) {
+		return false
+	}
+	return s.add(value) == 0
+}
+
+// add adds a value that is not rejected and returns the number of bits that
+// were set by it.
+func (s *BloomFilter) add(value []byte) uint64 {
+	if s.exactCounter != nil {
+		s.exactCounter.add(s.truncate(value))
+	}
 	fingerprint := make([]uint64, s.k)
 	s.Fingerprint(value, fingerprint)
-	for i := uint64(0); i < s.k; i++ {
-		k = uint64(fingerprint[i] / 64)
-		l = uint64(fingerprint[i] % 64)
-		v := uint64(1 << l)
-		if (s.v[k] & v) == 0 {
-			newValue = true
+	return s.addFingerprint(fingerprint)
+}
+
+// AddFingerprint sets the bits of a fingerprint and counts the value if any
+// of them was not set before.
+func (s *BloomFilter) AddFingerprint(fingerprint []uint64) {
+	s.addFingerprint(fingerprint)
+}
+
+// addFingerprint is AddFingerprint returning the number of bits that were
+// set by it.
+func (s *BloomFilter) addFingerprint(fingerprint []uint64) uint64 {
+	var newBits uint64
+	for _, bit := range fingerprint[:s.k] {
+		if bitset.Set(s.v, bit) {
+			newBits++
 		}
-		s.v[k] |= v
 	}
-	if newValue {
-		s.N++
+	if newBits > 0 {
+		s.invalidate()
+		s.dropExactCount()
+		s.SetNumElements(s.NumElements() + 1)
 	}
+	return newBits
 }
 
+// ErrCountOverflow is returned by Join if the sum of the element counts of
+// the joined filters does not fit into 64 bits.
+var ErrCountOverflow = errors.New("addition of member counts would overflow")
+
 // Join adds the items of another Bloom filter with identical dimensions to
 // the receiver. That is, all elements that are described in the
 // second filter will also described by the receiver, and the number of elements
 // of the receiver will grow by the number of elements in the added filter.
 // Note that it is implicitly assumed that both filters are disjoint! Otherwise
 // the number of elements in the joined filter must _only_ be considered an
-// upper bound and not an exact value!
+// upper bound and not an exact value! Use JoinEstimate for overlapping filters.
 // Joining two differently dimensioned filters may yield unexpected results and
 // hence is not allowed. An error will be returned in this case, and the
-// receiver will be left unaltered.
+// receiver will be left unaltered. If the sum of the element counts would
+// overflow, the bits are joined, but ErrCountOverflow is returned and the
+// count of the receiver is left unaltered; use JoinSaturating to clamp the
+// count instead.
 func (s *BloomFilter) Join(s2 *BloomFilter) error {
-	var i uint64
+	if err := s.joinBits(s2); err != nil {
+		return err
+	}
+	if s.NumElements()+s2.NumElements() < s.NumElements() {
+		return ErrCountOverflow
+	}
+	s.SetNumElements(s.NumElements() + s2.NumElements())
+	if s2.CountIsEstimate() {
+		s.SetMetadata(MetadataKeyCount, "estimated")
+	}
+
+	return nil
+}
+
+// JoinSaturating adds the items of another Bloom filter with identical
+// dimensions to the receiver like Join, but if the sum of the element counts
+// would overflow, the count of the receiver is set to math.MaxUint64 and
+// marked as estimated in the metadata instead of returning an error.
+func (s *BloomFilter) JoinSaturating(s2 *BloomFilter) error {
+	if err := s.joinBits(s2); err != nil {
+		return err
+	}
+	if s.NumElements()+s2.NumElements() < s.NumElements() {
+		s.SetNumElements(math.MaxUint64)
+		s.SetMetadata(MetadataKeyCount, "estimated")
+		return nil
+	}
+	s.SetNumElements(s.NumElements() + s2.NumElements())
+	if s2.CountIsEstimate() {
+		s.SetMetadata(MetadataKeyCount, "estimated")
+	}
+
+	return nil
+}
+
+// joinBits sets the bits of another filter with identical dimensions in the
+// receiver and records the producers of both.
+func (s *BloomFilter) joinBits(s2 *BloomFilter) error {
+	if err := s.checkDimensions(s2); err != nil {
+		return err
+	}
+	bitset.Or(s.v, s2.v)
+	s.invalidate()
+	s.dropExactCount()
+	s.changes++
+	s.recordJoinedProducers(s2)
+	return nil
+}
+
+// JoinEstimate adds the items of another Bloom filter with identical
+// dimensions to the receiver, like Join. Instead of summing the element
+// counts, which is only correct for disjoint filters, the count of the
+// receiver is set to the estimate returned by EstimatedNumElements for the
+// merged bit array, and the count is marked as estimated in the metadata.
+// Hence, JoinEstimate never fails because of a count overflow.
+func (s *BloomFilter) JoinEstimate(s2 *BloomFilter) error {
+	if err := s.joinBits(s2); err != nil {
+		return err
+	}
+	s.SetNumElements(s.EstimatedNumElements())
+	s.SetMetadata(MetadataKeyCount, "estimated")
+
+	return nil
+}
+
+func (s *BloomFilter) checkDimensions(s2 *BloomFilter) error {
 	if s.n != s2.n {
 		return fmt.Errorf("filters have different dimensions (n = %d vs. %d))",
 			s.n, s2.n)
 	}
+	// checked first, as an invalid p such as NaN may not equal itself
+	for _, p := range []float64{s.p, s2.p} {
+		if err := checkFPP(p); err != nil {
+			return fmt.Errorf("filters cannot be joined (p = %g vs. %g): %w", s.p, s2.p, err)
+		}
+	}
 	if s.p != s2.p {
-		return fmt.Errorf("filters have different dimensions (p = %f vs. %f))",
+		return fmt.Errorf("filters have different dimensions (p = %g vs. %g))",
 			s.p, s2.p)
 	}
 	if s.k != s2.k {
@@ -262,33 +717,88 @@ func (s *BloomFilter) Join(s2 *BloomFilter) error {
 		return fmt.Errorf("filters have different dimensions (M = %d vs. %d))",
 			s.M, s2.M)
 	}
-	for i = 0; i < s.M; i++ {
-		s.v[i] |= s2.v[i]
-	}
-	if s.N+s2.N < s.N {
-		return fmt.Errorf("addition of member counts would overflow")
+	if s.maxValueLength != s2.maxValueLength || s.valueLengthPolicy != s2.valueLengthPolicy {
+		return fmt.Errorf("filters have different value length limits (%d/%s vs. %d/%s)",
+			s.maxValueLength, s.valueLengthPolicy, s2.maxValueLength, s2.valueLengthPolicy)
 	}
-	s.N += s2.N
-
 	return nil
 }
 
 // Check returns true if the given value may be in the Bloom filter, false if it
 // is definitely not in it.
+// Values in the exclusion set of a filter loaded from the output of
+// WriteWithExclusions are reported as not contained.
 func (s *BloomFilter) Check(value []byte) bool {
+	if s.rejects(value) || s.excluded(value) {
+		return false
+	}
 	fingerprint := make([]uint64, s.k)
 	s.Fingerprint(value, fingerprint)
 	return s.CheckFingerprint(fingerprint)
 }
 
+// CheckAll returns true if all of the given values may be in the Bloom
+// filter, and false if at least one of them is definitely not in it or if no
+// values are given.
+func (s *BloomFilter) CheckAll(values [][]byte) bool {
+	if len(values) == 0 {
+		return false
+	}
+	fingerprint := make([]uint64, s.k)
+	for _, value := range values {
+		if s.rejects(value) || s.excluded(value) {
+			return false
+		}
+		s.Fingerprint(value, fingerprint)
+		if !s.CheckFingerprint(fingerprint) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckAny returns true if at least one of the given values may be in the
+// Bloom filter, and false if all of them are definitely not in it or if no
+// values are given.
+func (s *BloomFilter) CheckAny(values [][]byte) bool {
+	fingerprint := make([]uint64, s.k)
+	for _, value := range values {
+		if s.rejects(value) || s.excluded(value) {
+			continue
+		}
+		s.Fingerprint(value, fingerprint)
+		if s.CheckFingerprint(fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchScore returns the fraction of the bits of the fingerprint of the given
+// value that are set, from 0 to 1. A value may only be in the Bloom filter if
+// its score is 1; lower scores indicate how far it is from being a false
+// positive. Values that are rejected by the value length limit or excluded
+// score 0.
+func (s *BloomFilter) MatchScore(value []byte) float64 {
+	if s.rejects(value) || s.excluded(value) {
+		return 0
+	}
+	fingerprint := make([]uint64, s.k)
+	s.Fingerprint(value, fingerprint)
+	set := 0
+	for _, i := range fingerprint {
+		if bitset.Test(s.v, i) {
+			set++
+		}
+	}
+	return float64(set) / float64(s.k)
+}
+
 // CheckFingerprint returns true if the given fingerprint occurs in the Bloom
 // filter, false if it does not.
 func (s *BloomFilter) CheckFingerprint(fingerprint []uint64) bool {
-	var k, l uint64
-	for i := uint64(0); i < s.k; i++ {
-		k = uint64(fingerprint[i] / 64)
-		l = uint64(fingerprint[i] % 64)
-		if (s.v[k] & (1 << l)) == 0 {
+	for _, bit := range fingerprint[:s.k] {
+		if !bitset.Test(s.v, bit) {
 			return false
 		}
 	}
@@ -296,15 +806,129 @@ func (s *BloomFilter) CheckFingerprint(fingerprint []uint64) bool {
 }
 
 // Initialize returns a new, empty Bloom filter with the given capacity (n)
-// and FP probability (p).
+// and FP probability (p). It panics with an error wrapping ErrFilterTooLarge
+// if the filter cannot be allocated on this platform.
+//
+// Deprecated: Initialize returns the filter by value, which makes it easy to
+// copy filters by accident, and does not validate its parameters. Use New,
+// which returns a pointer and an error, instead.
 func Initialize(n uint64, p float64) BloomFilter {
-	m := math.Abs(math.Ceil(float64(n) * math.Log(p) / math.Pow(math.Log(2.0), 2.0)))
-	var bf BloomFilter
-	bf.n = n
-	bf.p = p
-	bf.m = uint64(m)
-	bf.M = uint64(math.Ceil(m / 64.0))
-	bf.k = uint64(math.Ceil(math.Log(2) * m / float64(n)))
-	bf.v = make([]uint64, bf.M)
-	return bf
+	m, err := optimalNumBits(n, p)
+	if errors.Is(err, ErrFilterTooLarge) {
+		panic(err)
+	}
+	k := optimalNumHashFuncs(n, m)
+	if err == nil {
+		if m, k, _, err = planDimensions(n, p, DefaultMaxHashFuncs); err != nil {
+			panic(err)
+		}
+	}
+	// the bit array is allocated separately, as the filter is returned by
+	// value
+	return BloomFilter{n: n, p: p, m: m, M: numWords(m), k: k, v: allocWords(nil, numWords(m))}
+}
+
+// New returns a new, empty Bloom filter with the given capacity (n) and FP
+// probability (p), configured by the given options. An error is returned if
+// the parameters are invalid or if the filter cannot be allocated on this
+// platform (see ErrFilterTooLarge). The number of hash functions is capped
+// at DefaultMaxHashFuncs unless configured otherwise with WithMaxHashFuncs.
+func New(n uint64, p float64, opts ...Option) (*BloomFilter, error) {
+	bf, storage, err := planNew(n, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+	bf.v = allocWords(storage, bf.M)
+	return bf, nil
+}
+
+// planNew returns the filter New creates, configured by the options and with
+// its dimensions set, but without its bit array, which is to be allocated by
+// allocWords with the returned storage.
+func planNew(n uint64, p float64, opts ...Option) (*BloomFilter, []uint64, error) {
+	// the options are applied before the bit array is allocated, as they
+	// may change its dimensions; the storage of a tiny bit array is
+	// allocated with the filter if it is tiny with the default options
+	words := uint64(inlineWords + 1)
+	if m, _, _, err := planDimensions(n, p, DefaultMaxHashFuncs); err == nil {
+		words = numWords(m)
+	}
+	bf, storage := allocFilter(words)
+	bf.n, bf.p = n, p
+	for _, opt := range opts {
+		opt(bf)
+	}
+	limit := hashFuncsLimit{max: DefaultMaxHashFuncs}
+	if bf.hashFuncsLimit != nil {
+		limit = *bf.hashFuncsLimit
+	}
+	plan, err := PlanFilterWithMaxHashFuncs(n, p, limit.max)
+	if err != nil {
+		return nil, nil, err
+	}
+	if plan.Capped() && limit.strict {
+		return nil, nil, fmt.Errorf("%w: p = %g needs %d hash functions, but at most %d are allowed",
+			ErrTooManyHashFuncs, p, plan.OptimalHashFuncs, plan.HashFuncs)
+	}
+	bf.m, bf.M, bf.k = plan.Bits, numWords(plan.Bits), plan.HashFuncs
+	return bf, storage, nil
+}
+
+func newFilter(n uint64, p float64, m, k uint64) *BloomFilter {
+	s, storage := allocFilter(numWords(m))
+	s.n, s.p, s.m, s.M, s.k = n, p, m, numWords(m), k
+	s.v = allocWords(storage, s.M)
+	return s
+}
+
+// emptyCopy returns an empty filter with the same dimensions, settings,
+// metadata (except for the counts and tombstones) and Data as the receiver.
+func (s *BloomFilter) emptyCopy() *BloomFilter {
+	c := newFilter(s.n, s.p, s.m, s.k)
+	s.copySettings(c)
+	return c
+}
+
+// clone returns a copy of the filter with the same bits, count, metadata,
+// comment and Data.
+func (s *BloomFilter) clone() *BloomFilter {
+	c := newFilter(s.n, s.p, s.m, s.k)
+	s.copyState(c)
+	copy(c.v, s.v)
+	return c
+}
+
+// copyState copies everything but the bits of the receiver to the given
+// filter of the same dimensions: the settings, metadata, count, comment and
+// Data.
+func (s *BloomFilter) copyState(c *BloomFilter) {
+	s.copySettings(c)
+	for key, value := range s.meta {
+		c.SetMetadata(key, value)
+	}
+	c.SetNumElements(s.NumElements())
+	c.comment = s.comment
+}
+
+// copySettings copies the settings, metadata (except for the counts and
+// tombstones) and Data of the receiver to the given filter.
+func (s *BloomFilter) copySettings(c *BloomFilter) {
+	for key, value := range s.meta {
+		switch key {
+		case MetadataKeyCount, MetadataKeyExactCount, MetadataKeyExactDuplicates, MetadataKeyTombstones:
+		default:
+			c.SetMetadata(key, value)
+		}
+	}
+	c.maxValueLength, c.valueLengthPolicy = s.maxValueLength, s.valueLengthPolicy
+	c.exclusions = s.exclusions
+	if s.external != nil {
+		// the reference was copied with the metadata, which SetData keeps
+		// as long as c has no reference yet
+		c.external = nil
+		c.SetData(nil)
+		c.external = s.external
+		return
+	}
+	c.SetData(s.GetData())
 }
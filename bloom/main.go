@@ -0,0 +1,14 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package main
+
+import (
+	"os"
+
+	"github.com/DCSO/bloom/bloomcmd"
+)
+
+func main() {
+	os.Exit(bloomcmd.Main(os.Args, os.Stdin, os.Stdout, os.Stderr))
+}
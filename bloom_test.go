@@ -5,18 +5,21 @@ package bloom
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
 
 func TestFingerprinting(t *testing.T) {
-	filter := Initialize(100000, 0.01)
+	filter := mustNew(100000, 0.01)
 	fp := make([]uint64, 7)
 	expected := [7]uint64{20311, 36825, 412501, 835777, 658914, 853361, 307361}
 	filter.Fingerprint([]byte("bar"), fp)
@@ -28,8 +31,56 @@ func TestFingerprinting(t *testing.T) {
 	}
 }
 
+// testCapacities are the capacities the tests of both representations of bit
+// arrays are run with, the smaller ones allocated with the filter (see
+// inlineWords).
+var testCapacities = []uint64{10, 100, 1000, 100000}
+
+func TestInlineWords(t *testing.T) {
+	for _, capacity := range testCapacities {
+		filter := mustNew(capacity, 0.01)
+		var read BloomFilter
+		if err := read.Read(bytes.NewReader(mustWrite(t, filter))); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range []*BloomFilter{filter, &read} {
+			if uint64(len(f.v)) != f.M || uint64(cap(f.v)) != f.M {
+				t.Fatalf("capacity %d: unexpected bit array of %d words", capacity, f.M)
+			}
+		}
+		allocs := testing.AllocsPerRun(10, func() {
+			mustNew(capacity, 0.01)
+		})
+		expected := 2.0
+		if filter.M <= inlineWords {
+			expected = 1
+		}
+		if allocs != expected {
+			t.Fatalf("capacity %d: expected %g allocations, got %g", capacity, expected, allocs)
+		}
+	}
+	if filter := mustNew(100, 0.01); filter.M > inlineWords {
+		t.Fatalf("a filter of 100 values at 1%% needs %d words, more than are stored inline", filter.M)
+	}
+
+	// a filter returned by value has a bit array of its own
+	filter := Initialize(100, 0.01)
+	filter.Add([]byte("foo"))
+	if uint64(cap(filter.v)) != filter.M || !filter.Check([]byte("foo")) {
+		t.Fatal("unexpected bit array of a filter returned by Initialize")
+	}
+}
+
+func mustWrite(t *testing.T, filter *BloomFilter) []byte {
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
 func TestInitialization(t *testing.T) {
-	filter := Initialize(10000, 0.001)
+	filter := mustNew(10000, 0.001)
 	if filter.k != 10 {
 		t.Error("k does not match expectation!")
 	}
@@ -46,7 +97,7 @@ func TestInitialization(t *testing.T) {
 	}
 }
 
-func checkFilters(a BloomFilter, b BloomFilter, t *testing.T) bool {
+func checkFilters(a *BloomFilter, b *BloomFilter, t *testing.T) bool {
 	if b.n != a.n ||
 		b.p != a.p ||
 		b.k != a.k ||
@@ -63,7 +114,7 @@ func checkFilters(a BloomFilter, b BloomFilter, t *testing.T) bool {
 	return true
 }
 
-func serializeToBuffer(filter BloomFilter) (*BloomFilter, error) {
+func serializeToBuffer(filter *BloomFilter) (*BloomFilter, error) {
 	var buf bytes.Buffer
 	filter.Write(&buf)
 	var newFilter BloomFilter
@@ -71,7 +122,7 @@ func serializeToBuffer(filter BloomFilter) (*BloomFilter, error) {
 	return &newFilter, nil
 }
 
-func serializeToDisk(filter BloomFilter) (*BloomFilter, error) {
+func serializeToDisk(filter *BloomFilter) (*BloomFilter, error) {
 	tempFile, err := ioutil.TempFile("", "filter")
 	if err != nil {
 		return nil, err
@@ -89,9 +140,14 @@ func serializeToDisk(filter BloomFilter) (*BloomFilter, error) {
 }
 
 func TestSerialization(t *testing.T) {
-	capacity := uint64(100000)
+	for _, capacity := range testCapacities {
+		testSerialization(t, capacity)
+	}
+}
+
+func testSerialization(t *testing.T, capacity uint64) {
 	p := float64(0.01)
-	samples := uint64(1000)
+	samples := capacity/100 + 1
 	filter, _ := GenerateExampleFilter(capacity, p, samples)
 
 	newFilter, err := serializeToBuffer(filter)
@@ -100,7 +156,7 @@ func TestSerialization(t *testing.T) {
 		return
 	}
 
-	if !checkFilters(filter, *newFilter, t) {
+	if !checkFilters(filter, newFilter, t) {
 		t.Error("Filters do not match!")
 	}
 
@@ -111,7 +167,7 @@ func TestSerialization(t *testing.T) {
 		return
 	}
 
-	if !checkFilters(filter, *newFilter, t) {
+	if !checkFilters(filter, newFilter, t) {
 		t.Error("Filters do not match!")
 	}
 
@@ -123,7 +179,7 @@ func TestSerialization(t *testing.T) {
 		return
 	}
 
-	if !checkFilters(filter, *newFilter, t) {
+	if !checkFilters(filter, newFilter, t) {
 		t.Error("Filters do not match!")
 	}
 
@@ -135,11 +191,11 @@ func TestSerialization(t *testing.T) {
 		return
 	}
 
-	if !checkFilters(filter, *newFilter, t) {
+	if !checkFilters(filter, newFilter, t) {
 		t.Error("Filters do not match!")
 	}
 
-	checkFilters(filter, *newFilter, t)
+	checkFilters(filter, newFilter, t)
 }
 
 func TestSerializationToDisk(t *testing.T) {
@@ -156,7 +212,7 @@ func TestSerializationToDisk(t *testing.T) {
 
 	newFilter.Read(&buf)
 
-	checkFilters(filter, newFilter, t)
+	checkFilters(filter, &newFilter, t)
 }
 
 func TestSerializationWriteFail(t *testing.T) {
@@ -214,8 +270,8 @@ func GenerateTestValue(length uint64) []byte {
 	return value
 }
 
-func GenerateExampleFilter(capacity uint64, p float64, samples uint64) (BloomFilter, [][]byte) {
-	filter := Initialize(capacity, p)
+func GenerateExampleFilter(capacity uint64, p float64, samples uint64) (*BloomFilter, [][]byte) {
+	filter := mustNew(capacity, p)
 	filter.Data = []byte("foobar")
 	testValues := make([][]byte, 0, samples)
 	for i := uint64(0); i < samples; i++ {
@@ -226,8 +282,8 @@ func GenerateExampleFilter(capacity uint64, p float64, samples uint64) (BloomFil
 	return filter, testValues
 }
 
-func GenerateDisjointExampleFilter(capacity uint64, p float64, samples uint64, other BloomFilter) (BloomFilter, [][]byte) {
-	filter := Initialize(capacity, p)
+func GenerateDisjointExampleFilter(capacity uint64, p float64, samples uint64, other *BloomFilter) (*BloomFilter, [][]byte) {
+	filter := mustNew(capacity, p)
 	testValues := make([][]byte, 0, samples)
 	for i := uint64(0); i < samples; {
 		testValue := GenerateTestValue(100)
@@ -242,31 +298,50 @@ func GenerateDisjointExampleFilter(capacity uint64, p float64, samples uint64, o
 
 //This tests the checking of values against a given filter
 func TestChecking(t *testing.T) {
-	capacity := uint64(100000)
-	p := float64(0.001)
-	samples := uint64(100000)
-	filter, testValues := GenerateExampleFilter(capacity, p, samples)
-	fingerprint := make([]uint64, filter.k)
-	for _, value := range testValues {
-		filter.Fingerprint(value, fingerprint)
-		if !filter.CheckFingerprint(fingerprint) {
-			t.Error("Did not find test value in filter!")
+	for _, capacity := range testCapacities {
+		p := float64(0.001)
+		samples := capacity
+		filter, testValues := GenerateExampleFilter(capacity, p, samples)
+		fingerprint := make([]uint64, filter.k)
+		for _, value := range testValues {
+			filter.Fingerprint(value, fingerprint)
+			if !filter.CheckFingerprint(fingerprint) {
+				t.Error("Did not find test value in filter!")
+			}
 		}
 	}
 }
 
+func TestTestAndAdd(t *testing.T) {
+	filter := mustNew(1000, 0.001)
+	filter.SetMaxValueLength(8, RejectValues)
+	if filter.TestAndAdd([]byte("foo")) {
+		t.Fatal("new value reported as contained")
+	}
+	if !filter.TestAndAdd([]byte("foo")) {
+		t.Fatal("value added before not reported as contained")
+	}
+	if filter.TestAndAdd([]byte("rejected value")) || filter.Check([]byte("rejected value")) {
+		t.Fatal("rejected value added")
+	}
+	if filter.NumElements() != 1 {
+		t.Fatalf("expected 1 element, got %d", filter.NumElements())
+	}
+}
+
 //This tests the checking of values against a given filter after resetting it
 func TestReset(t *testing.T) {
-	capacity := uint64(100000)
-	p := float64(0.001)
-	samples := uint64(100000)
-	filter, testValues := GenerateExampleFilter(capacity, p, samples)
-	filter.Reset()
-	fingerprint := make([]uint64, filter.k)
-	for _, value := range testValues {
-		filter.Fingerprint(value, fingerprint)
-		if filter.CheckFingerprint(fingerprint) {
-			t.Error("Did not find test value in filter!")
+	for _, capacity := range testCapacities {
+		p := float64(0.001)
+		samples := capacity
+		filter, testValues := GenerateExampleFilter(capacity, p, samples)
+		filter.Reset()
+		fingerprint := make([]uint64, filter.k)
+		for _, value := range testValues {
+			filter.Fingerprint(value, fingerprint)
+			if filter.CheckFingerprint(fingerprint) {
+				t.Error("Did not find test value in filter!")
+			}
 		}
 	}
 }
@@ -302,48 +377,48 @@ func TestFalsePositives(t *testing.T) {
 }
 
 func TestJoiningRegularMisdimensioned(t *testing.T) {
-	a := Initialize(100000, 0.0001)
-	b := Initialize(10000, 0.0001)
-	err := a.Join(&b)
+	a := mustNew(100000, 0.0001)
+	b := mustNew(10000, 0.0001)
+	err := a.Join(b)
 	if err == nil {
 		t.Error("joining filters with different capacity should fail")
 	}
 	if !strings.Contains(err.Error(), "different dimensions") {
 		t.Error("wrong error message returned")
 	}
-	a = Initialize(100000, 0.0001)
-	b = Initialize(100000, 0.001)
-	err = a.Join(&b)
+	a = mustNew(100000, 0.0001)
+	b = mustNew(100000, 0.001)
+	err = a.Join(b)
 	if err == nil {
 		t.Error("joining filters with different FP prob should fail")
 	}
 	if !strings.Contains(err.Error(), "different dimensions") {
 		t.Error("wrong error message returned")
 	}
-	a = Initialize(100000, 0.0001)
-	b = Initialize(100000, 0.0001)
+	a = mustNew(100000, 0.0001)
+	b = mustNew(100000, 0.0001)
 	b.k = 1
-	err = a.Join(&b)
+	err = a.Join(b)
 	if err == nil {
 		t.Error("joining filters with different number of hash funcs should fail")
 	}
 	if !strings.Contains(err.Error(), "different dimensions") {
 		t.Error("wrong error message returned")
 	}
-	a = Initialize(100000, 0.0001)
-	b = Initialize(100000, 0.0001)
+	a = mustNew(100000, 0.0001)
+	b = mustNew(100000, 0.0001)
 	b.m = 1
-	err = a.Join(&b)
+	err = a.Join(b)
 	if err == nil {
 		t.Error("joining filters with different number of bits should fail")
 	}
 	if !strings.Contains(err.Error(), "different dimensions") {
 		t.Error("wrong error message returned")
 	}
-	a = Initialize(100000, 0.0001)
-	b = Initialize(100000, 0.0001)
+	a = mustNew(100000, 0.0001)
+	b = mustNew(100000, 0.0001)
 	b.M = 1
-	err = a.Join(&b)
+	err = a.Join(b)
 	if err == nil {
 		t.Error("joining filters with different int array size should fail")
 	}
@@ -369,34 +444,73 @@ func TestAccessors(t *testing.T) {
 }
 
 func TestJoiningRegular(t *testing.T) {
-	a, aval := GenerateExampleFilter(100000, 0.0001, 10000)
-	b, bval := GenerateDisjointExampleFilter(100000, 0.0001, 20000, a)
-	for _, v := range bval {
-		if a.Check(v) {
-			t.Errorf("value not missing in joined filter: %s", string(v))
+	for _, capacity := range testCapacities {
+		a, aval := GenerateExampleFilter(capacity, 0.0001, capacity/10)
+		b, bval := GenerateDisjointExampleFilter(capacity, 0.0001, capacity/5, a)
+		for _, v := range bval {
+			if a.Check(v) {
+				t.Errorf("value not missing in joined filter: %s", string(v))
+			}
 		}
-	}
-	if a.N != 10000 {
-		t.Error("unexpected number of elements in filter")
-	}
-	if b.N != 20000 {
-		t.Error("unexpected number of elements in filter")
-	}
-	err := a.Join(&b)
-	if a.N != 30000 {
-		t.Errorf("unexpected number of elements in filter")
-	}
-	if err != nil {
-		t.Fatal(err)
-	}
-	for _, v := range aval {
-		if !a.Check(v) {
-			t.Errorf("value not found in joined filter: %s", string(v))
+		if a.N != capacity/10 {
+			t.Error("unexpected number of elements in filter")
+		}
+		if b.N != capacity/5 {
+			t.Error("unexpected number of elements in filter")
+		}
+		err := a.Join(b)
+		if a.N != capacity/10+capacity/5 {
+			t.Errorf("unexpected number of elements in filter")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range aval {
+			if !a.Check(v) {
+				t.Errorf("value not found in joined filter: %s", string(v))
+			}
+		}
+		for _, v := range bval {
+			if !a.Check(v) {
+				t.Errorf("value not found in joined filter: %s", string(v))
+			}
 		}
 	}
-	for _, v := range bval {
-		if !a.Check(v) {
-			t.Errorf("value not found in joined filter: %s", string(v))
+}
+
+func TestJoiningOverflow(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		join      func(a, b *BloomFilter) error
+		err       error
+		count     uint64
+		estimated bool
+	}{
+		{"Join", (*BloomFilter).Join, ErrCountOverflow, ^uint64(0) - 10, false},
+		{"JoinSaturating", (*BloomFilter).JoinSaturating, nil, ^uint64(0), true},
+		{"JoinEstimate", (*BloomFilter).JoinEstimate, nil, 0, true},
+	} {
+		a, aval := GenerateExampleFilter(100000, 0.0001, 10000)
+		b, bval := GenerateDisjointExampleFilter(100000, 0.0001, 20000, a)
+		a.N = ^uint64(0) - 10
+		err := c.join(a, b)
+		if !errors.Is(err, c.err) || (c.err == nil) != (err == nil) {
+			t.Fatalf("%s: unexpected error %v", c.name, err)
+		}
+		if c.count != 0 && a.N != c.count {
+			t.Errorf("%s: unexpected number of elements in filter: %d", c.name, a.N)
+		}
+		if c.count == 0 && (a.N < 29000 || a.N > 31000) {
+			t.Errorf("%s: estimated count too far from union size: %d vs. 30000", c.name, a.N)
+		}
+		if a.CountIsEstimate() != c.estimated {
+			t.Errorf("%s: count should be marked as estimated: %v", c.name, c.estimated)
+		}
+		// the bits are joined in all modes
+		for _, v := range append(aval, bval...) {
+			if !a.Check(v) {
+				t.Fatalf("%s: value not found in joined filter: %s", c.name, string(v))
+			}
 		}
 	}
 }
@@ -432,3 +546,126 @@ func BenchmarkSimpleChecking(b *testing.B) {
 		}
 	}
 }
+
+func TestCheckAllAny(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.Add([]byte("foo"))
+	filter.Add([]byte("bar"))
+	foo, bar, baz := []byte("foo"), []byte("bar"), []byte("baz")
+	for _, c := range []struct {
+		values [][]byte
+		all    bool
+		any    bool
+	}{
+		{nil, false, false},
+		{[][]byte{foo}, true, true},
+		{[][]byte{baz}, false, false},
+		{[][]byte{foo, bar}, true, true},
+		{[][]byte{foo, baz}, false, true},
+		{[][]byte{baz, foo}, false, true},
+	} {
+		if filter.CheckAll(c.values) != c.all {
+			t.Errorf("unexpected CheckAll result for %q", c.values)
+		}
+		if filter.CheckAny(c.values) != c.any {
+			t.Errorf("unexpected CheckAny result for %q", c.values)
+		}
+	}
+}
+
+func TestMatchScore(t *testing.T) {
+	filter := mustNew(1000, 0.001)
+	filter.Add([]byte("foo"))
+	if score := filter.MatchScore([]byte("foo")); score != 1 {
+		t.Fatalf("expected a score of 1 for a contained value, got %f", score)
+	}
+	for i := 0; i < 100; i++ {
+		value := []byte(fmt.Sprintf("value-%d", i))
+		score := filter.MatchScore(value)
+		if score < 0 || score > 1 || (score == 1) != filter.Check(value) {
+			t.Fatalf("unexpected score %f for %q", score, value)
+		}
+	}
+	if score := mustNew(1000, 0.001).MatchScore([]byte("foo")); score != 0 {
+		t.Fatalf("expected a score of 0 for an empty filter, got %f", score)
+	}
+}
+
+func TestProbeIndexes(t *testing.T) {
+	filter, testValues := GenerateExampleFilter(10000, 0.001, 1000)
+	fingerprint := make([]uint64, filter.k)
+	prefix := make([]uint64, filter.k)
+	for _, value := range testValues {
+		filter.Fingerprint(value, fingerprint)
+		for k := 1; k <= int(filter.k); k++ {
+			filter.ProbeIndexes(value, k, prefix)
+			if !reflect.DeepEqual(prefix[:k], fingerprint[:k]) {
+				t.Fatalf("probe indexes %v are not a prefix of fingerprint %v", prefix[:k], fingerprint)
+			}
+		}
+	}
+}
+
+func TestCheckFirstProbe(t *testing.T) {
+	filter, testValues := GenerateExampleFilter(10000, 0.001, 10000)
+	for _, value := range testValues {
+		if !filter.CheckFirstProbe(value) || !filter.CheckFirstProbeHash(filter.Hash(value)) {
+			t.Fatalf("first probe should match value %q in filter", value)
+		}
+	}
+	fill := float64(filter.NumSetBits()) / float64(filter.m)
+	matches := 0
+	const tests = 100000
+	for i := 0; i < tests; i++ {
+		if filter.CheckFirstProbe(GenerateTestValue(100)) {
+			matches++
+		}
+	}
+	rate := float64(matches) / tests
+	if math.Abs(rate-fill) > 0.02 {
+		t.Errorf("first probe false positive rate %.3f should be close to fill ratio %.3f", rate, fill)
+	}
+}
+
+func BenchmarkCheckFirstProbe(b *testing.B) {
+	capacity := uint64(1e9)
+	p := float64(0.001)
+	samples := uint64(100000)
+	filter, testValues := GenerateExampleFilter(capacity, p, samples)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		value := testValues[rand.Int()%len(testValues)]
+		if !filter.CheckFirstProbe(value) {
+			b.Error("Did not find test value in filter!")
+		}
+	}
+}
+
+// mustNew is like New, but panics if the filter cannot be created.
+func mustNew(n uint64, p float64) *BloomFilter {
+	filter, err := New(n, p)
+	if err != nil {
+		panic(err)
+	}
+	return filter
+}
+
+func TestSetDataCopies(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	data := []byte("payload")
+	filter.SetData(data)
+	copy(data, "PAYLOAD")
+	if string(filter.GetData()) != "payload" {
+		t.Fatalf("SetData retained its argument: %q", filter.GetData())
+	}
+	filter.SetData(nil)
+	if filter.GetData() != nil {
+		t.Fatal("nil data not kept")
+	}
+
+	filter.SetDataNoCopy(data)
+	copy(data, "changed")
+	if string(filter.GetData()) != "changed" {
+		t.Fatalf("SetDataNoCopy copied its argument: %q", filter.GetData())
+	}
+}
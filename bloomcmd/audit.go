@@ -0,0 +1,159 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	gz "compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// writableFilter is a filter written by a command, a *bloom.BloomFilter, a
+// *bloom.TombstoneFilter or a *bloom.TaggedFilter.
+type writableFilter interface {
+	Write(w io.Writer, opts ...bloom.WriteOption) error
+	NumElements() uint64
+}
+
+// fileDigest describes a file read or written by a command, as recorded in
+// the audit log.
+type fileDigest struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// auditRecord is the line appended to the file given with --log-file for each
+// filter written. The JSON field names are part of the log format and must not
+// change.
+type auditRecord struct {
+	Time    time.Time    `json:"timestamp"`
+	Command string       `json:"command"`
+	Args    []string     `json:"args"`
+	Inputs  []fileDigest `json:"inputs"`
+	Output  fileDigest   `json:"output"`
+	N       uint64       `json:"n"`
+}
+
+// writeFilter writes the filter to path like bloom.WriteFilter, which all
+// commands writing a filter do through it. With --dry-run, it prints what
+// would be written instead: the path, the number of elements, the digest and
+// the size of the file and how much that changes. With --log-file, an audit
+// record naming the inputs the filter was derived from, with their digests
+// taken right before the write, is appended to the log once it succeeded.
+func (bloomParams BloomParams) writeFilter(filter writableFilter, path string, inputs []string, opts ...bloom.WriteOption) (fileDigest, error) {
+	opts = bloomParams.writeOptions(opts...)
+	if bloomParams.dryRun {
+		return bloomParams.previewWrite(filter, path, opts)
+	}
+	var inputFiles []fileDigest
+	if bloomParams.logFile != "" {
+		for _, input := range inputs {
+			file, err := digestFile(input)
+			if err != nil {
+				return fileDigest{}, err
+			}
+			inputFiles = append(inputFiles, file)
+		}
+	}
+	var err error
+	switch f := filter.(type) {
+	case *bloom.TombstoneFilter:
+		err = bloom.WriteTombstoneFilter(f, path, bloomParams.gzip, opts...)
+	case *bloom.TaggedFilter:
+		err = bloom.WriteTaggedFilter(f, path, bloomParams.gzip, opts...)
+	case *bloom.BloomFilter:
+		err = bloom.WriteFilter(f, path, bloomParams.gzip, opts...)
+	default:
+		err = fmt.Errorf("cannot write %T", filter)
+	}
+	if err != nil {
+		return fileDigest{}, err
+	}
+	if bloomParams.logFile == "" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fileDigest{}, err
+		}
+		return fileDigest{Path: path, Size: info.Size()}, nil
+	}
+	output, err := digestFile(path)
+	if err != nil {
+		return fileDigest{}, err
+	}
+	if err = bloomParams.appendAuditRecord(auditRecord{
+		Time:    time.Now().UTC(),
+		Command: bloomParams.command,
+		Args:    bloomParams.args,
+		Inputs:  inputFiles,
+		Output:  output,
+		N:       filter.NumElements(),
+	}); err != nil {
+		return output, fmt.Errorf("The filter %s was written, but the audit log could not be: %s", path, err)
+	}
+	return output, nil
+}
+
+// previewWrite serializes the filter as written by bloom.WriteFilter, without
+// writing it, and prints the outcome.
+func (bloomParams BloomParams) previewWrite(filter writableFilter, path string, opts []bloom.WriteOption) (fileDigest, error) {
+	var buf bytes.Buffer
+	if bloomParams.gzip {
+		// closed without flushing first like by WriteFilter, for the same
+		// bytes
+		w := gz.NewWriter(&buf)
+		if err := filter.Write(w, opts...); err != nil {
+			return fileDigest{}, err
+		}
+		if err := w.Close(); err != nil {
+			return fileDigest{}, err
+		}
+	} else if err := filter.Write(&buf, opts...); err != nil {
+		return fileDigest{}, err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	preview := fileDigest{Path: path, SHA256: hex.EncodeToString(sum[:]), Size: int64(buf.Len())}
+	change := "new file"
+	if info, err := os.Stat(path); err == nil {
+		change = fmt.Sprintf("%+d bytes", preview.Size-info.Size())
+	}
+	fmt.Fprintf(bloomParams.stdout, "Dry run: would write %s (N %d, SHA256 %s, %d bytes, %s)\n",
+		path, filter.NumElements(), preview.SHA256, preview.Size, change)
+	return preview, nil
+}
+
+// appendAuditRecord appends a record to the file given with --log-file, one
+// JSON object per line.
+func (bloomParams BloomParams) appendAuditRecord(record auditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(bloomParams.logFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// digestFile returns the SHA-256 digest and size of the file at path.
+func digestFile(path string) (fileDigest, error) {
+	digest, size, err := bloom.FileDigest(path)
+	if err != nil {
+		return fileDigest{}, err
+	}
+	return fileDigest{Path: path, SHA256: digest, Size: size}, nil
+}
@@ -0,0 +1,70 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DCSO/bloom/bloomtest"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// defaultBenchFills are the fill ratios compared by bench --compression by
+// default.
+const defaultBenchFills = "0.01,0.25,0.5,0.69"
+
+// parseFills parses comma-separated fill ratios.
+func parseFills(s string) ([]float64, error) {
+	var fills []float64
+	for _, field := range strings.Split(s, ",") {
+		fill, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || !(fill >= 0 && fill < 1) {
+			return nil, fmt.Errorf("invalid fill ratio %q", field)
+		}
+		fills = append(fills, fill)
+	}
+	return fills, nil
+}
+
+// parseBenchFilterBits returns the filter sizes given with --filter-bits, or
+// nil if none were given.
+func parseBenchFilterBits(sizes []int64) ([]uint64, error) {
+	var bits []uint64
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, errors.New("Filter sizes must be positive.")
+		}
+		bits = append(bits, uint64(size))
+	}
+	return bits, nil
+}
+
+// compareCompression writes the results of comparing the compressions as a
+// table, one row per filter and compression, or as JSON, one object per line.
+func compareCompression(w io.Writer, config bloomtest.CompressionBenchConfig, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		return bloomtest.CompareCompression(config, func(result bloomtest.CompressionBenchResult) error {
+			return encoder.Encode(result)
+		})
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "fill\tm (bits)\tcompression\tsize\tcompressed\tratio\tcompress MiB/s\tdecompress MiB/s\t")
+	err := bloomtest.CompareCompression(config, func(result bloomtest.CompressionBenchResult) error {
+		_, err := fmt.Fprintf(tw, "%.2f\t%d\t%s\t%s\t%s\t%.3f\t%.1f\t%.1f\t\n", result.FillRatio, result.FilterBits, result.Compression,
+			pipeline.FormatByteSize(uint64(result.Size)), pipeline.FormatByteSize(uint64(result.CompressedSize)), result.Ratio,
+			result.CompressMBPerSec, result.DecompressMBPerSec)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,221 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+func testFilter(values ...string) *bloom.BloomFilter {
+	filter, _ := bloom.New(1000, 0.0001)
+	for _, v := range values {
+		filter.Add([]byte(v))
+	}
+	return filter
+}
+
+func TestLineSelected(t *testing.T) {
+	for _, c := range []struct {
+		matched []bool
+		all     bool
+		invert  bool
+		result  bool
+	}{
+		{[]bool{true, true}, false, false, true},
+		{[]bool{true, false}, false, false, true},
+		{[]bool{false, false}, false, false, false},
+		{nil, false, false, false},
+		{[]bool{true, true}, true, false, true},
+		{[]bool{true, false}, true, false, false},
+		{[]bool{false, false}, true, false, false},
+		{nil, true, false, false},
+		// invert of "any": no field matches
+		{[]bool{true, true}, false, true, false},
+		{[]bool{true, false}, false, true, false},
+		{[]bool{false, false}, false, true, true},
+		// invert of "all": at least one field does not match
+		{[]bool{true, true}, true, true, false},
+		{[]bool{true, false}, true, true, true},
+		{[]bool{false, false}, true, true, true},
+	} {
+		if lineSelected(c.matched, c.all, c.invert) != c.result {
+			t.Errorf("unexpected result for %v (all: %v, invert: %v)", c.matched, c.all, c.invert)
+		}
+	}
+}
+
+func TestCheckLineMatchModes(t *testing.T) {
+	filter := testFilter("foo", "bar")
+	lines := []string{"foo,bar,x", "foo,baz,x", "baz,qux,x"}
+	for _, c := range []struct {
+		all      bool
+		invert   bool
+		each     bool
+		expected []string
+	}{
+		{false, false, false, []string{"foo,bar,x", "foo,baz,x"}},
+		{true, false, false, []string{"foo,bar,x"}},
+		{false, true, false, []string{"baz,qux,x"}},
+		{true, true, false, []string{"foo,baz,x", "baz,qux,x"}},
+		{false, false, true, []string{"foo", "bar", "foo"}},
+		{true, false, true, []string{"foo", "bar"}},
+		{false, true, true, []string{"baz", "qux"}},
+		{true, true, true, []string{"baz", "baz", "qux"}},
+	} {
+		params := BloomParams{
+			split:          true,
+			delimiter:      ",",
+			fields:         []int{0, 1},
+			matchAll:       c.all,
+			invertMatch:    c.invert,
+			printEachMatch: c.each,
+		}
+		var output []string
+		for _, line := range lines {
+			output = append(output, checkLine(filter.Check, line, params)...)
+		}
+		if !reflect.DeepEqual(output, c.expected) {
+			t.Errorf("all: %v, invert: %v, each: %v: unexpected output %q", c.all, c.invert, c.each, output)
+		}
+	}
+}
+
+func TestCheckLinePrintFields(t *testing.T) {
+	filter := testFilter("foo")
+	params := BloomParams{
+		split:       true,
+		delimiter:   ",",
+		fields:      []int{0},
+		printFields: []int{-1, 1, 5},
+	}
+	output := checkLine(filter.Check, "foo,bar,baz", params)
+	if !reflect.DeepEqual(output, []string{"baz,bar"}) {
+		t.Fatalf("unexpected output: %q", output)
+	}
+	if checkLine(filter.Check, "bar,foo", params) != nil {
+		t.Fatal("line with non-matching selected field should not be reported")
+	}
+	if output := checkLine(filter.Check, "foo", BloomParams{}); !reflect.DeepEqual(output, []string{"foo"}) {
+		t.Fatalf("unexpected output for unsplit line: %q", output)
+	}
+}
+
+func TestCheckValuesByFilter(t *testing.T) {
+	filters := []bloom.NamedFilter{
+		{ManifestEntry: bloom.ManifestEntry{Name: "a"}, Filter: testFilter("foo", "bar")},
+		{ManifestEntry: bloom.ManifestEntry{Name: "b"}, Filter: testFilter("bar")},
+	}
+	var output bytes.Buffer
+	checkValuesByFilter(filters, strings.NewReader("foo\nbar\nbaz\n"), &output, BloomParams{})
+	expected := "a\tfoo\na\tbar\nb\tbar\n"
+	if output.String() != expected {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+}
+
+func TestFingerprintValues(t *testing.T) {
+	filter := testFilter("foo", "bar")
+	var output bytes.Buffer
+	bloomParams := BloomParams{split: true, delimiter: ",", fields: []int{1}}
+	if err := fingerprintValues(filter, strings.NewReader("x,foo\ny,baz\n"), &output, bloom.FingerprintCSV, bloomParams); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "foo,") || !strings.HasPrefix(lines[1], "baz,") {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+	var expected bytes.Buffer
+	filter.WriteFingerprints(&expected, [][]byte{[]byte("foo"), []byte("baz")}, bloom.FingerprintCSV)
+	if output.String() != expected.String() {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+}
+
+func TestTupleFields(t *testing.T) {
+	bloomParams := BloomParams{split: true, delimiter: ",", tupleFields: []int{0, 3}}
+	cli := testFilter()
+	insertValues(cli, strings.NewReader("customer-1,x,y,example.com\n"), bloomParams)
+	library := testFilter()
+	library.AddTuple([]byte("customer-1"), []byte("example.com"))
+	for i := uint64(0); i < library.NumWords(); i++ {
+		if cli.WordAt(i) != library.WordAt(i) {
+			t.Fatalf("CLI and library filters differ at word %d", i)
+		}
+	}
+	if values := checkPipeline(bloomParams).Values("customer-1,x,y,example.com"); len(values) != 1 ||
+		values[0] != string(bloom.EncodeTuple([]byte("customer-1"), []byte("example.com"))) {
+		t.Fatalf("unexpected composite value %q", values)
+	}
+
+	var output bytes.Buffer
+	checkValues(library, strings.NewReader("customer-1,x,y,example.com\ncustomer-2,x,y,example.com\nexample.com,x,y,customer-1\n"), &output, bloomParams)
+	if output.String() != "customer-1,x,y,example.com\n" {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+}
+
+func TestCheckValuesJSON(t *testing.T) {
+	filter := testFilter("foo", "bar")
+	score := int(100 * filter.MatchScore([]byte("x")))
+	var output bytes.Buffer
+	params := BloomParams{split: true, delimiter: ",", json: true, source: "in.txt"}
+	checkValues(filter, strings.NewReader("foo,x\ny\nx,bar\n"), &output, params)
+	expected := fmt.Sprintf(`{"value":"foo","source_file":"in.txt","line":1,"matched":true,"score":100}
+{"value":"x","source_file":"in.txt","line":1,"matched":false,"score":%d}
+{"value":"x","source_file":"in.txt","line":3,"matched":false,"score":%d}
+{"value":"bar","source_file":"in.txt","line":3,"matched":true,"score":100}
+`, score, score)
+	if output.String() != expected {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+
+	output.Reset()
+	params.printEachMatch, params.encoding = true, pipeline.Hex
+	checkValues(filter, strings.NewReader("foo,x\n"), &output, params)
+	if expected := `{"value":"666f6f","source_file":"in.txt","line":1,"matched":true,"score":100}` + "\n"; output.String() != expected {
+		t.Fatalf("unexpected output with --each %q", output.String())
+	}
+
+	filters := []bloom.NamedFilter{
+		{ManifestEntry: bloom.ManifestEntry{Name: "a"}, Filter: testFilter("foo", "bar")},
+		{ManifestEntry: bloom.ManifestEntry{Name: "b"}, Filter: testFilter("bar")},
+	}
+	output.Reset()
+	checkValuesByFilter(filters, strings.NewReader("foo\nbar\n"), &output, BloomParams{json: true})
+	expected = `{"value":"foo","line":1,"matched":true,"matched_filters":["a"],"score":100}
+{"value":"bar","line":2,"matched":true,"matched_filters":["a","b"],"score":100}
+`
+	if output.String() != expected {
+		t.Fatalf("unexpected output for several filters %q", output.String())
+	}
+}
+
+func TestCheckDomainForms(t *testing.T) {
+	input := "bücher.example\nXN--BCHER-KVA.example.\nxn--mnchen-3ya.de\nxn--bcher-kv!.example\n"
+	for _, filter := range []*bloom.BloomFilter{
+		testFilter("bücher.example", "münchen.de"),
+		testFilter("xn--bcher-kva.example", "xn--mnchen-3ya.de"),
+	} {
+		var output bytes.Buffer
+		checkValues(filter, strings.NewReader(input), &output, BloomParams{domainForms: true})
+		if expected := "bücher.example\nXN--BCHER-KVA.example.\nxn--mnchen-3ya.de\n"; output.String() != expected {
+			t.Fatalf("unexpected output %q", output.String())
+		}
+		output.Reset()
+		checkValues(filter, strings.NewReader(input), &output, BloomParams{})
+		if lines := strings.Count(output.String(), "\n"); lines != 1 {
+			t.Fatalf("unexpected literal output %q", output.String())
+		}
+	}
+	if _, _, err := runCommand("", "check", "--domain-forms", "unicode", "test.bloom"); err == nil || !strings.Contains(err.Error(), "--domain-forms") {
+		t.Fatalf("expected an error for an invalid --domain-forms, got %v", err)
+	}
+}
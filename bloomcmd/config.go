@@ -0,0 +1,226 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// envPrefix is the prefix of the environment variables providing defaults for
+// flags, see envVarName.
+const envPrefix = "BLOOM_"
+
+// The sources of the effective value of a flag, in order of precedence.
+const (
+	sourceFlag    = "flag"
+	sourceProfile = "profile"
+	sourceEnv     = "env"
+	sourceDefault = "default"
+)
+
+// envVarName returns the name of the environment variable providing the
+// default of a flag of the given command ("" for global flags): the prefix
+// followed by the command and flag names in upper case, with dashes replaced
+// by underscores, e.g. BLOOM_PRINT_FIELDS or BLOOM_CHECK_SAMPLE_RATE.
+func envVarName(command, name string) string {
+	if command != "" {
+		name = command + "_" + name
+	}
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// flagName returns the name of a flag without its aliases.
+func flagName(f cli.Flag) string {
+	return strings.TrimSpace(strings.Split(f.GetName(), ",")[0])
+}
+
+// resolvedFlag is the effective value of a flag and where it comes from.
+type resolvedFlag struct {
+	name   string
+	value  string
+	source string
+	envVar string
+}
+
+// describeSource returns the source of the value with the name of the
+// profile or environment variable it comes from.
+func (r resolvedFlag) describeSource(profile string) string {
+	switch r.source {
+	case sourceProfile:
+		return sourceProfile + " " + profile
+	case sourceEnv:
+		return sourceEnv + " " + r.envVar
+	}
+	return r.source
+}
+
+// resolvedConfig holds the effective values of the global flags. Values given
+// on the command line take precedence over those of the active profile, which
+// take precedence over the environment variables of the flags, which take
+// precedence over the defaults of the flags. The flags selecting the profile
+// are only taken from the command line, the environment or their defaults.
+type resolvedConfig struct {
+	flags   []resolvedFlag
+	profile string
+}
+
+// resolveGlobalFlag returns the effective value of the named global flag,
+// with the values of the given profile.
+func resolveGlobalFlag(c *cli.Context, profile Profile, name string) resolvedFlag {
+	r := resolvedFlag{name: name, envVar: envVarName("", name)}
+	if c.GlobalIsSet(name) {
+		r.value, r.source = c.GlobalString(name), sourceFlag
+	} else if v, ok := profile[name]; ok {
+		r.value, r.source = v, sourceProfile
+	} else if v, ok := os.LookupEnv(r.envVar); ok {
+		r.value, r.source = v, sourceEnv
+	} else {
+		r.value, r.source = c.GlobalString(name), sourceDefault
+	}
+	return r
+}
+
+// resolveGlobalFlags returns the effective values of all global flags.
+func resolveGlobalFlags(c *cli.Context) (resolvedConfig, error) {
+	var config resolvedConfig
+	for _, name := range profileMetaFlags {
+		config.flags = append(config.flags, resolveGlobalFlag(c, nil, name))
+	}
+	profile, err := activeProfile(c)
+	if err != nil {
+		return config, err
+	}
+	config.profile = config.String("profile")
+	for _, name := range profileFlagNames(c) {
+		config.flags = append(config.flags, resolveGlobalFlag(c, profile, name))
+	}
+	return config, nil
+}
+
+func (config resolvedConfig) lookup(name string) resolvedFlag {
+	for _, r := range config.flags {
+		if r.name == name {
+			return r
+		}
+	}
+	return resolvedFlag{name: name}
+}
+
+// String returns the effective value of the named flag.
+func (config resolvedConfig) String(name string) string {
+	return config.lookup(name).value
+}
+
+// Bool returns the effective value of the named boolean flag.
+func (config resolvedConfig) Bool(name string) (bool, error) {
+	r := config.lookup(name)
+	b, err := strconv.ParseBool(r.value)
+	if err != nil {
+		if r.source == sourceEnv {
+			return false, fmt.Errorf("Invalid value for %s: %s", r.envVar, err)
+		}
+		return false, fmt.Errorf("Invalid value for --%s: %s", name, err)
+	}
+	return b, nil
+}
+
+// applyCommandEnv sets the flags of the command of the context that were not
+// given on the command line to the values of their environment variables, if
+// any. The values of repeatable flags are separated by commas.
+func applyCommandEnv(c *cli.Context) error {
+	for _, f := range c.Command.Flags {
+		name := flagName(f)
+		if c.IsSet(name) {
+			continue
+		}
+		envVar := envVarName(c.Command.Name, name)
+		value, ok := os.LookupEnv(envVar)
+		if !ok {
+			continue
+		}
+		values := []string{value}
+		if _, ok := f.(cli.StringSliceFlag); ok {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := c.Set(name, v); err != nil {
+				return fmt.Errorf("Invalid value for %s: %s", envVar, err)
+			}
+		}
+	}
+	return nil
+}
+
+// withCommandEnv returns the action of a command preceded by applyCommandEnv.
+func withCommandEnv(action interface{}) interface{} {
+	fn, ok := action.(func(*cli.Context) error)
+	if !ok {
+		return action
+	}
+	return func(c *cli.Context) error {
+		if err := applyCommandEnv(c); err != nil {
+			return err
+		}
+		return fn(c)
+	}
+}
+
+// resolveCommandFlags returns the effective values of the flags of the given
+// command when they are not given on the command line.
+func resolveCommandFlags(command cli.Command) ([]resolvedFlag, error) {
+	set := flag.NewFlagSet(command.Name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	var flags []resolvedFlag
+	for _, f := range command.Flags {
+		f.Apply(set)
+		r := resolvedFlag{name: flagName(f), envVar: envVarName(command.Name, flagName(f))}
+		if v, ok := os.LookupEnv(r.envVar); ok {
+			if err := set.Set(r.name, v); err != nil {
+				return nil, fmt.Errorf("Invalid value for %s: %s", r.envVar, err)
+			}
+			r.value, r.source = v, sourceEnv
+		} else if _, ok := f.(cli.StringSliceFlag); ok {
+			r.source = sourceDefault
+		} else {
+			r.value, r.source = set.Lookup(r.name).DefValue, sourceDefault
+		}
+		flags = append(flags, r)
+	}
+	return flags, nil
+}
+
+// printFlags prints the effective values of the global flags, or of the flags
+// of the given command, with their sources.
+func printFlags(c *cli.Context, w io.Writer, command string) error {
+	var flags []resolvedFlag
+	var profile string
+	if command == "" {
+		config, err := resolveGlobalFlags(c)
+		if err != nil {
+			return err
+		}
+		flags, profile = config.flags, config.profile
+	} else {
+		cmd := c.App.Command(command)
+		if cmd == nil {
+			return fmt.Errorf("Unknown command %s.", command)
+		}
+		var err error
+		if flags, err = resolveCommandFlags(*cmd); err != nil {
+			return err
+		}
+	}
+	for _, r := range flags {
+		fmt.Fprintf(w, "--%s=%q\t%s\n", r.name, r.value, r.describeSource(profile))
+	}
+	return nil
+}
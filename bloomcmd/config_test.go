@@ -0,0 +1,155 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+// setEnv sets (or with a nil value unsets) environment variables until the
+// end of the test.
+func setEnv(t *testing.T, env map[string]*string) {
+	for name, value := range env {
+		name := name
+		old, ok := os.LookupEnv(name)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		})
+		if value == nil {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, *value)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+// flagLine returns the line of the output of the flags command for the
+// named flag.
+func flagLine(t *testing.T, output, name string) string {
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, "--"+name+"=") {
+			return line
+		}
+	}
+	t.Fatalf("no flag %s in output %q", name, output)
+	return ""
+}
+
+func TestRunFlagsPrecedence(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	profiles := filepath.Join(dir, "profiles.json")
+	mustRun(t, "", "--profiles-file", profiles, "-d", "P", "profile", "save", "p")
+
+	for _, c := range []struct {
+		flag, profile, env bool
+		expected           string
+	}{
+		{false, false, false, "--delimiter=\",\"\tdefault"},
+		{false, false, true, "--delimiter=\"E\"\tenv BLOOM_DELIMITER"},
+		{false, true, false, "--delimiter=\"P\"\tprofile p"},
+		{false, true, true, "--delimiter=\"P\"\tprofile p"},
+		{true, false, false, "--delimiter=\"F\"\tflag"},
+		{true, false, true, "--delimiter=\"F\"\tflag"},
+		{true, true, false, "--delimiter=\"F\"\tflag"},
+		{true, true, true, "--delimiter=\"F\"\tflag"},
+	} {
+		env := map[string]*string{"BLOOM_DELIMITER": nil}
+		if c.env {
+			env["BLOOM_DELIMITER"] = stringPtr("E")
+		}
+		setEnv(t, env)
+		args := []string{"--profiles-file", profiles}
+		if c.flag {
+			args = append(args, "-d", "F")
+		}
+		if c.profile {
+			args = append(args, "--profile", "p")
+		}
+		output := mustRun(t, "", append(args, "flags")...)
+		if line := flagLine(t, output, "delimiter"); line != c.expected {
+			t.Errorf("flag %v, profile %v, env %v: expected %q, got %q", c.flag, c.profile, c.env, c.expected, line)
+		}
+	}
+
+	// the profile itself can be selected in the environment
+	setEnv(t, map[string]*string{"BLOOM_PROFILE": stringPtr("p"), "BLOOM_PROFILES_FILE": stringPtr(profiles)})
+	output := mustRun(t, "", "flags")
+	if line := flagLine(t, output, "delimiter"); line != "--delimiter=\"P\"\tprofile p" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	if line := flagLine(t, output, "profile"); line != "--profile=\"p\"\tenv BLOOM_PROFILE" {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+func TestRunCommandEnv(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+
+	setEnv(t, map[string]*string{
+		"BLOOM_GZIP":          stringPtr("true"),
+		"BLOOM_CREATE_N":      stringPtr("1234"),
+		"BLOOM_CHECK_INPUT":   stringPtr("a,b"),
+		"BLOOM_FIELDS":        stringPtr("0,2"),
+		"BLOOM_CHECK_UNIQUE":  nil,
+		"BLOOM_CREATE_P":      nil,
+		"BLOOM_INSERT_QUIET":  nil,
+		"BLOOM_PRINT_FIELDS":  nil,
+		"BLOOM_TUPLE_FIELDS":  nil,
+		"BLOOM_DELIMITER":     nil,
+		"BLOOM_PROFILE":       nil,
+		"BLOOM_PROFILES_FILE": nil,
+	})
+	mustRun(t, "foo\n", "create", path)
+	filter, err := bloom.LoadFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.MaxNumElements() != 1234 {
+		t.Fatalf("expected a capacity of 1234, got %d", filter.MaxNumElements())
+	}
+	mustRun(t, "foo\n", "create", "-n", "99", path)
+	if filter, err = bloom.LoadFilter(path, true); err != nil || filter.MaxNumElements() != 99 {
+		t.Fatalf("expected a capacity of 99, got %d (%v)", filter.MaxNumElements(), err)
+	}
+
+	output := mustRun(t, "", "flags", "check")
+	if line := flagLine(t, output, "input"); line != "--input=\"a,b\"\tenv BLOOM_CHECK_INPUT" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	if line := flagLine(t, output, "unique"); line != "--unique=\"false\"\tdefault" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	output = mustRun(t, "", "flags")
+	if line := flagLine(t, output, "fields"); line != "--fields=\"0,2\"\tenv BLOOM_FIELDS" {
+		t.Fatalf("unexpected line %q", line)
+	}
+
+	setEnv(t, map[string]*string{"BLOOM_CREATE_N": stringPtr("many")})
+	if _, _, err := runCommand("foo\n", "create", path); err == nil || !strings.Contains(err.Error(), "BLOOM_CREATE_N") {
+		t.Fatalf("expected an invalid value error, got %v", err)
+	}
+	if _, _, err := runCommand("", "flags", "create"); err == nil || !strings.Contains(err.Error(), "BLOOM_CREATE_N") {
+		t.Fatalf("expected an invalid value error, got %v", err)
+	}
+	setEnv(t, map[string]*string{"BLOOM_SPLIT": stringPtr("maybe")})
+	if _, _, err := runCommand("foo\n", "show", path); err == nil || !strings.Contains(err.Error(), "BLOOM_SPLIT") {
+		t.Fatalf("expected an invalid value error, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// defaultDuplicatesMax is the default number of candidate duplicates counted
+// exactly by --report-duplicates.
+const defaultDuplicatesMax = 100000
+
+// duplicateReport counts the values that are added to a filter more than
+// once, using the filter itself to find them: a value that the filter already
+// matches before it is added is a candidate, which is then counted exactly.
+// Since the filter also matches values that were never added with its false
+// positive probability, a small fraction of the candidates are not duplicates
+// but false positives of the filter, reported with a count of 2.
+//
+// Up to max candidates are tracked. When a further candidate is found, it
+// replaces the candidate with the lowest count, and among those the one
+// tracked longest, so that frequent duplicates are kept.
+type duplicateReport struct {
+	max        int
+	candidates map[string]*duplicateCandidate
+	heap       duplicateHeap
+	// seq orders the candidates by the time they were tracked
+	seq uint64
+	// evicted is the number of candidates that were replaced
+	evicted uint64
+}
+
+type duplicateCandidate struct {
+	value string
+	// count is the number of times the value was added, including its first
+	// occurrence
+	count uint64
+	seq   uint64
+	index int
+}
+
+func newDuplicateReport(max int) *duplicateReport {
+	if max < 1 {
+		max = 1
+	}
+	return &duplicateReport{max: max, candidates: make(map[string]*duplicateCandidate)}
+}
+
+// observe records a value about to be added, which the filter matched
+// already if seen is true.
+func (d *duplicateReport) observe(value []byte, seen bool) {
+	if !seen {
+		return
+	}
+	if c, ok := d.candidates[string(value)]; ok {
+		c.count++
+		heap.Fix(&d.heap, c.index)
+		return
+	}
+	if len(d.candidates) >= d.max {
+		evicted := heap.Pop(&d.heap).(*duplicateCandidate)
+		delete(d.candidates, evicted.value)
+		d.evicted++
+	}
+	c := &duplicateCandidate{value: string(value), count: 2, seq: d.seq}
+	d.seq++
+	d.candidates[c.value] = c
+	heap.Push(&d.heap, c)
+}
+
+// write writes the candidates with their counts like 'uniq -c', the most
+// frequent first and those with equal counts in lexical order.
+func (d *duplicateReport) write(w io.Writer) error {
+	candidates := make([]*duplicateCandidate, 0, len(d.candidates))
+	for _, c := range d.candidates {
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].count != candidates[j].count {
+			return candidates[i].count > candidates[j].count
+		}
+		return candidates[i].value < candidates[j].value
+	})
+	for _, c := range candidates {
+		if _, err := fmt.Fprintf(w, "%7d %s\n", c.count, c.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes the report to the file at path, and warns if candidates
+// were replaced.
+func (d *duplicateReport) writeFile(path string, s streams) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err = d.write(w); err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot write the duplicate report: %s", err)
+	}
+	if d.evicted > 0 {
+		s.warnf("more than %d candidate duplicates, %d were dropped from the report, use a larger --report-duplicates-max", d.max, d.evicted)
+	}
+	return nil
+}
+
+// duplicateHeap orders the candidates by count and then by age, the next to
+// be replaced first.
+type duplicateHeap []*duplicateCandidate
+
+func (h duplicateHeap) Len() int { return len(h) }
+func (h duplicateHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].seq < h[j].seq
+}
+func (h duplicateHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *duplicateHeap) Push(x interface{}) {
+	c := x.(*duplicateCandidate)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *duplicateHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
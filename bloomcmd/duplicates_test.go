@@ -0,0 +1,54 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestDuplicateReport(t *testing.T) {
+	filter, err := bloom.New(1000, 1e-6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDuplicateReport(10)
+	for _, value := range []string{"foo", "bar", "foo", "baz", "bar", "foo", "qux"} {
+		d.observe([]byte(value), filter.Check([]byte(value)))
+		filter.Add([]byte(value))
+	}
+	var output bytes.Buffer
+	if err := d.write(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := "      3 foo\n      2 bar\n"
+	if output.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestDuplicateReportEviction(t *testing.T) {
+	d := newDuplicateReport(3)
+	// a frequent duplicate is kept while the others replace each other
+	for i := 0; i < 5; i++ {
+		d.observe([]byte("frequent"), true)
+	}
+	for i := 0; i < 10; i++ {
+		d.observe([]byte(fmt.Sprintf("value-%d", i)), true)
+	}
+	if len(d.candidates) != 3 || len(d.heap) != 3 || d.evicted != 8 {
+		t.Fatalf("unexpected state: %d candidates, %d evicted", len(d.candidates), d.evicted)
+	}
+	var output bytes.Buffer
+	if err := d.write(&output); err != nil {
+		t.Fatal(err)
+	}
+	expected := "      6 frequent\n      2 value-8\n      2 value-9\n"
+	if output.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, output.String())
+	}
+}
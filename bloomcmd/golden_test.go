@@ -0,0 +1,130 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+// goldenInsert and goldenCheck are the input used to test the output of the
+// insert and check commands for combinations of flags.
+const (
+	goldenInsert = "foo,bar,baz\nqux;quux\r\n,empty\n\nlast,line"
+	goldenCheck  = "foo,bar,baz\nfoo,x,y\nx,bar,y\nx,y,baz\nqux;quux\r\nquux\n,empty\nempty\n\nlast,line\nline,last\nx"
+)
+
+func TestGoldenOutput(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		insert   BloomParams
+		check    BloomParams
+		expected string
+	}{
+		{"plain", BloomParams{}, BloomParams{}, "foo,bar,baz\nqux;quux\n,empty\n\nlast,line\n"},
+		{"keep-cr", BloomParams{keepCR: true}, BloomParams{keepCR: true}, "foo,bar,baz\nqux;quux\r\n,empty\n\nlast,line\n"},
+		{"interactive", BloomParams{interactive: true}, BloomParams{interactive: true}, ">foo,bar,baz\n>qux;quux\n>,empty\n"},
+		{"split", BloomParams{split: true, delimiter: ","}, BloomParams{split: true, delimiter: ","}, "foo,bar,baz\nfoo,x,y\nx,bar,y\nx,y,baz\nqux;quux\n,empty\nempty\n\nlast,line\nline,last\n"},
+		{"split-semicolon", BloomParams{split: true, delimiter: ";"}, BloomParams{split: true, delimiter: ","}, "quux\n,empty\n\n"},
+		{"fields", BloomParams{split: true, delimiter: ",", fields: []int{0}}, BloomParams{split: true, delimiter: ",", fields: []int{0, -1}}, "foo,bar,baz\nfoo,x,y\nqux;quux\n,empty\n\nlast,line\nline,last\n"},
+		{"fields-unsplit", BloomParams{fields: []int{1}}, BloomParams{fields: []int{-1}}, "foo,bar,baz\nqux;quux\n,empty\n\nlast,line\n"},
+		{"fields-unsplit-excluded", BloomParams{}, BloomParams{fields: []int{1}}, ""},
+		{"each", BloomParams{split: true, delimiter: ","}, BloomParams{split: true, delimiter: ",", printEachMatch: true}, "foo\nbar\nbaz\nfoo\nbar\nbaz\nqux;quux\n\nempty\nempty\n\nlast\nline\nline\nlast\n"},
+		{"each-invert", BloomParams{split: true, delimiter: ","}, BloomParams{split: true, delimiter: ",", printEachMatch: true, invertMatch: true}, "quux\nx\n"},
+		{"all", BloomParams{split: true, delimiter: ",", fields: []int{0}}, BloomParams{split: true, delimiter: ",", matchAll: true}, "qux;quux\n\n"},
+		{"all-invert", BloomParams{split: true, delimiter: ",", fields: []int{0}}, BloomParams{split: true, delimiter: ",", matchAll: true, invertMatch: true}, "foo,bar,baz\nfoo,x,y\nx,bar,y\nx,y,baz\nquux\n,empty\nempty\nlast,line\nline,last\nx\n"},
+		{"print-fields", BloomParams{split: true, delimiter: ","}, BloomParams{split: true, delimiter: ",", fields: []int{1}, printFields: []int{-1, 0, 7}}, "baz,foo\ny,x\nempty,\nline,last\nlast,line\n"},
+		{"print-fields-unsplit", BloomParams{}, BloomParams{printFields: []int{0}, delimiter: ","}, "foo,bar,baz\nqux;quux\n,empty\n\nlast,line\n"},
+		{"value-limit", BloomParams{split: true, delimiter: ",", guardValueBytes: 3}, BloomParams{split: true, delimiter: ",", guardValueBytes: 3, printEachMatch: true}, "foo\nbar\nbaz\nfoo\nbar\nbaz\n\n\n"},
+	} {
+		filter, _ := bloom.New(1000, 0.0001)
+		insertValues(filter, strings.NewReader(goldenInsert), c.insert)
+		var output bytes.Buffer
+		checkValues(filter, strings.NewReader(goldenCheck), &output, c.check)
+		if output.String() != c.expected {
+			t.Errorf("%s: unexpected output %q", c.name, output.String())
+		}
+	}
+}
+
+func TestGoldenSummary(t *testing.T) {
+	// the small filter is overfilled so that false positives are expected
+	filter, _ := bloom.New(5, 0.1)
+	insertValues(filter, strings.NewReader(goldenInsert), BloomParams{keepCR: true})
+	for _, c := range []struct {
+		name     string
+		check    BloomParams
+		expected string
+	}{
+		{"text", BloomParams{keepCR: true}, "Checked 12 lines, of which 6 were reported.\n" +
+			"Estimated FP rate of the filter: 1.81e-01\n" +
+			"Estimated false positives among the reported lines: 1.3\n" +
+			"Estimated true matches: 5 (95% confidence interval: 2 to 6)\n"},
+		{"split", BloomParams{keepCR: true, split: true, delimiter: ","}, "Checked 12 lines, of which 7 were reported.\n" +
+			"Estimated FP rate of the filter: 1.81e-01\n" +
+			"Estimated false positives among the reported lines: 1.1\n" +
+			"Estimated true matches: 6 (95% confidence interval: 4 to 7)\n"},
+		{"source", BloomParams{keepCR: true, source: "dns"}, "dns: Checked 12 lines, of which 6 were reported.\n" +
+			"dns: Estimated FP rate of the filter: 1.81e-01\n" +
+			"dns: Estimated false positives among the reported lines: 1.3\n" +
+			"dns: Estimated true matches: 5 (95% confidence interval: 2 to 6)\n"},
+		{"json", BloomParams{keepCR: true, json: true}, `{"lines":12,"reported":6,"fp_rate_estimate":0.18090630036342067,` +
+			`"false_positives_estimate":1.325169272651124,"true_matches_estimate":4.674830727348876,` +
+			`"true_matches_low":2.181815350568264,"true_matches_high":6}` + "\n"},
+	} {
+		var stderr bytes.Buffer
+		c.check.summary = true
+		c.check.stderr = &stderr
+		checkValues(filter, strings.NewReader(goldenCheck), ioutil.Discard, c.check)
+		if stderr.String() != c.expected {
+			t.Errorf("%s: unexpected summary %q", c.name, stderr.String())
+		}
+	}
+}
+
+func TestGoldenTemplate(t *testing.T) {
+	filter, _ := bloom.New(1000, 0.0001)
+	insertValues(filter, strings.NewReader(goldenInsert), BloomParams{split: true, delimiter: ","})
+	for _, c := range []struct {
+		name     string
+		template string
+		check    BloomParams
+		expected string
+	}{
+		{"example", "{{.File}}:{{.Line}} {{.Value}} matched={{.Filters}}", BloomParams{printEachMatch: true, source: "dns"},
+			"dns:5 qux;quux matched=test.bloom\ndns:8 empty matched=test.bloom\ndns:9  matched=test.bloom\n"},
+		{"each", "{{.Line}}\t{{.Value}}\n", BloomParams{split: true, delimiter: ",", printEachMatch: true, invertMatch: true},
+			"6\tquux\n12\tx\n"},
+		{"matched", "{{.Value}}={{.Matched}} {{.Score}}", BloomParams{split: true, delimiter: ",", fields: []int{0, 1}, matchAll: true, invertMatch: true},
+			"foo=true 100\nx=false 0\nx=false 0\nbar=true 100\nx=false 0\ny=false 0\nquux=false 0\nx=false 0\n"},
+		{"csv", `{{csv .Line .Value .Text}}`, BloomParams{split: true, delimiter: ",", fields: []int{0}, printEachMatch: true},
+			"1,foo,\"foo,bar,baz\"\n2,foo,\"foo,x,y\"\n5,qux;quux,qux;quux\n7,,\",empty\"\n8,empty,empty\n9,,\n10,last,\"last,line\"\n11,line,\"line,last\"\n"},
+		{"json", `{"value":{{json .Value}},"filters":{{json .Filters}}}`, BloomParams{fields: []int{0}, printEachMatch: true},
+			"{\"value\":\"qux;quux\",\"filters\":[\"test.bloom\"]}\n{\"value\":\"empty\",\"filters\":[\"test.bloom\"]}\n{\"value\":\"\",\"filters\":[\"test.bloom\"]}\n"},
+	} {
+		tmpl, err := parseOutcomeTemplate(c.template)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		c.check.template = tmpl
+		c.check.filterName = "test.bloom"
+		var output bytes.Buffer
+		checkValues(filter, strings.NewReader(goldenCheck), &output, c.check)
+		if output.String() != c.expected {
+			t.Errorf("%s: unexpected output %q", c.name, output.String())
+		}
+	}
+}
+
+func TestTemplateErrors(t *testing.T) {
+	for _, text := range []string{"{{.Value", "{{.Unknown}}", "{{nofunc .Value}}", "{{join .Value \",\"}}"} {
+		if _, err := parseOutcomeTemplate(text); err == nil {
+			t.Errorf("%q: expected an error", text)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestCRLFInputSymmetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"lf":    "foo\nbar\nbaz\n",
+		"crlf":  "foo\r\nbar\r\nbaz\r\n",
+		"mixed": "foo\r\nbar\nbaz\r\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run := func(insertFile, checkFile string, keepCR bool) string {
+		params := BloomParams{keepCR: keepCR}
+		filter, _ := bloom.New(1000, 0.0001)
+		f, err := os.Open(filepath.Join(dir, insertFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		insertValues(filter, f, params)
+		g, err := os.Open(filepath.Join(dir, checkFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer g.Close()
+		var output bytes.Buffer
+		checkValues(filter, g, &output, params)
+		return output.String()
+	}
+	for insertFile := range files {
+		for checkFile := range files {
+			if output := run(insertFile, checkFile, false); output != "foo\nbar\nbaz\n" {
+				t.Errorf("insert %s, check %s: unexpected output %q", insertFile, checkFile, output)
+			}
+		}
+	}
+	// with --keep-cr, values only match if their line endings do
+	if output := run("crlf", "lf", true); output != "" {
+		t.Errorf("CRs should be kept: unexpected output %q", output)
+	}
+	if output := run("mixed", "lf", true); output != "bar\n" {
+		t.Errorf("CRs should be kept: unexpected output %q", output)
+	}
+}
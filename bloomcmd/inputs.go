@@ -0,0 +1,215 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followPollInterval is the interval at which inputs that reached their end
+// are polled for more data with --follow.
+const followPollInterval = 200 * time.Millisecond
+
+// outputTemplateName is replaced by the name of an input in the output
+// template.
+const outputTemplateName = "{name}"
+
+// checkFunc checks the lines read from the input and writes the lines to
+// report to the output, like checkValues.
+type checkFunc func(input io.Reader, output io.Writer, bloomParams BloomParams)
+
+// input is an input given with --input. It is opened when it is read, as
+// opening a named pipe blocks until it is opened for writing.
+type input struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// fileInputs returns the inputs reading the files at the given paths, named
+// after the files.
+func fileInputs(paths []string) ([]input, error) {
+	inputs := make([]input, len(paths))
+	names := make(map[string]string)
+	for i, path := range paths {
+		name := filepath.Base(path)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("The inputs %s and %s have the same name.", other, path)
+		}
+		names[name] = path
+		path := path
+		inputs[i] = input{name: name, open: func() (io.ReadCloser, error) {
+			return os.Open(path)
+		}}
+	}
+	return inputs, nil
+}
+
+// followReader reads from r, waiting for more data at its end like 'tail -f'
+// instead of returning io.EOF, until stop is closed.
+type followReader struct {
+	r    io.Reader
+	stop <-chan struct{}
+}
+
+func (f followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := f.r.Read(p)
+		if n > 0 || err != io.EOF {
+			return n, err
+		}
+		select {
+		case <-f.stop:
+			return 0, io.EOF
+		case <-time.After(followPollInterval):
+		}
+	}
+}
+
+// prefixWriter prefixes each write, i.e. each reported line, with a prefix.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (p prefixWriter) Write(b []byte) (int, error) {
+	if _, err := p.w.Write(append([]byte(p.prefix), b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// runCheck checks the lines read from standard input, or from the inputs
+// given with --input, using the check function. If the tool is interrupted
+// or terminated, or a value cannot be decoded, the input ends, and an error
+// is returned once the output is written.
+func runCheck(check checkFunc, bloomParams BloomParams) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bloomParams.ctx, bloomParams.cancel = ctx, cancel
+	stopForwarding, err := bloomParams.startForwarding()
+	if err != nil {
+		return err
+	}
+	err = checkUntilStopped(check, bloomParams)
+	stopForwarding()
+	if err != nil {
+		return err
+	}
+	if err := bloomParams.failure(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return errors.New("Interrupted.")
+	}
+	return nil
+}
+
+// checkUntilStopped checks the lines read from the inputs given with --input
+// or from standard input, until they end or the context of the tool is done.
+func checkUntilStopped(check checkFunc, bloomParams BloomParams) error {
+	if len(bloomParams.inputs) > 0 {
+		inputs, err := fileInputs(bloomParams.inputs)
+		if err != nil {
+			return err
+		}
+		return checkInputs(check, inputs, bloomParams.ctx.Done(), bloomParams)
+	}
+	if bloomParams.interactive {
+		fmt.Fprintln(bloomParams.stdout, "Interactive mode: Enter a blank line [by pressing ENTER] to exit.")
+	}
+	output, done := openMatchOutput(bloomParams)
+	if !bloomParams.unique {
+		check(bloomParams.stdin, output, bloomParams)
+		return done()
+	}
+	unique := newUniqueWriter(output, bloomParams.uniqueMax, bloomParams.count, bloomParams.streams)
+	check(bloomParams.stdin, unique, bloomParams)
+	if err := unique.Close(); err != nil {
+		done()
+		return err
+	}
+	return done()
+}
+
+// checkInputs checks the lines read from each of the inputs concurrently
+// using the check function, until the end of all of them, or with --follow
+// until stop is closed. The lines to report are written to the file named by
+// the output template for each input, or to standard output, prefixed with the
+// name of the input and a tab unless they are JSON outcomes. An input that
+// cannot be read does not stop the others, but its error is returned once they
+// are done.
+func checkInputs(check checkFunc, inputs []input, stop <-chan struct{}, bloomParams BloomParams) error {
+	if len(inputs) > 1 && bloomParams.outputTemplate != "" && !strings.Contains(bloomParams.outputTemplate, outputTemplateName) {
+		return fmt.Errorf("The output template must contain %s for several inputs.", outputTemplateName)
+	}
+	outputs := make([]io.Writer, len(inputs))
+	var writers []*matchWriter
+	if bloomParams.outputTemplate == "" {
+		out := newMatchWriter(bloomParams.stdout, bloomParams.lineBuffered || isTerminalStream(bloomParams.stdout))
+		writers = append(writers, out)
+		for i, in := range inputs {
+			// JSON and rendered outcomes name their input themselves
+			outputs[i] = out
+			if !bloomParams.printsOutcomes() {
+				outputs[i] = prefixWriter{out, in.name + "\t"}
+			}
+		}
+	} else {
+		for i, in := range inputs {
+			f, err := os.Create(strings.Replace(bloomParams.outputTemplate, outputTemplateName, in.name, -1))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out := newMatchWriter(f, bloomParams.lineBuffered)
+			writers = append(writers, out)
+			outputs[i] = out
+		}
+	}
+	stopFlushing := flushOnTermination(bloomParams, matchWriters(writers))
+
+	// the inputs share standard error for warnings
+	if bloomParams.stderr != nil {
+		bloomParams.stderr = newMatchWriter(bloomParams.stderr, true)
+	}
+	errs := make([]error, len(inputs))
+	var wg sync.WaitGroup
+	for i, in := range inputs {
+		wg.Add(1)
+		go func(i int, in input) {
+			defer wg.Done()
+			r, err := in.open()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer r.Close()
+			var reader io.Reader = r
+			if bloomParams.follow {
+				reader = followReader{r, stop}
+			}
+			params := bloomParams
+			params.source = in.name
+			check(reader, outputs[i], params)
+		}(i, in)
+	}
+	wg.Wait()
+
+	stopFlushing()
+	err := matchWriters(writers).Flush()
+	for i, inputErr := range errs {
+		if inputErr != nil && err == nil {
+			err = fmt.Errorf("Cannot read input %s: %s", inputs[i].name, inputErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,176 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeInputs returns inputs reading from pipes with the given names and the
+// write ends of the pipes.
+func pipeInputs(t *testing.T, names ...string) ([]input, []*os.File) {
+	inputs := make([]input, len(names))
+	writers := make([]*os.File, len(names))
+	for i, name := range names {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs[i] = input{name: name, open: func() (io.ReadCloser, error) {
+			return r, nil
+		}}
+		writers[i] = w
+	}
+	return inputs, writers
+}
+
+func sortedLines(s string) []string {
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	sort.Strings(lines)
+	return lines
+}
+
+func TestCheckInputs(t *testing.T) {
+	filter := testFilter("foo", "bar")
+	inputs, writers := pipeInputs(t, "dns", "http", "tls")
+	var stdout bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- checkInputs(func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+			checkValues(filter, input, output, bloomParams)
+		}, inputs, nil, BloomParams{streams: streams{stdout: &stdout}})
+	}()
+
+	// the end of one input does not stop the others
+	io.WriteString(writers[0], "foo\nbaz\n")
+	writers[0].Close()
+	io.WriteString(writers[1], "bar\n")
+	io.WriteString(writers[2], "qux\n")
+	time.Sleep(10 * time.Millisecond)
+	io.WriteString(writers[1], "foo\n")
+	io.WriteString(writers[2], "bar\n")
+	writers[1].Close()
+	writers[2].Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dns\tfoo", "http\tbar", "http\tfoo", "tls\tbar"}
+	if lines := sortedLines(stdout.String()); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected output %q", stdout.String())
+	}
+}
+
+func TestCheckInputsOutputTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filter := testFilter("foo", "bar")
+	check := func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+		checkValues(filter, input, output, bloomParams)
+	}
+	inputs, writers := pipeInputs(t, "dns", "http", "tls")
+	inputs = append(inputs, input{name: "missing", open: func() (io.ReadCloser, error) {
+		return os.Open(filepath.Join(dir, "missing"))
+	}})
+	bloomParams := BloomParams{outputTemplate: filepath.Join(dir, "hits-{name}.txt")}
+	for i, w := range writers {
+		go func(i int, w *os.File) {
+			io.WriteString(w, strings.Repeat("foo\nbaz\n", i+1))
+			w.Close()
+		}(i, w)
+	}
+	if err := checkInputs(check, inputs, nil, bloomParams); err == nil || !strings.Contains(err.Error(), "missing") {
+		t.Fatalf("expected an error for the missing input, got %v", err)
+	}
+	for i, name := range []string{"dns", "http", "tls"} {
+		output, err := ioutil.ReadFile(filepath.Join(dir, "hits-"+name+".txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(output) != strings.Repeat("foo\n", i+1) {
+			t.Fatalf("%s: unexpected output %q", name, output)
+		}
+	}
+
+	bloomParams.outputTemplate = filepath.Join(dir, "hits.txt")
+	if err := checkInputs(check, inputs, nil, bloomParams); err == nil {
+		t.Fatal("expected an error for a template without name")
+	}
+}
+
+func TestCheckInputsFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dns")
+	if err := ioutil.WriteFile(path, []byte("foo\nbaz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := fileInputs([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := testFilter("foo", "bar")
+	outputPath := filepath.Join(dir, "hits-{name}.txt")
+	bloomParams := BloomParams{outputTemplate: outputPath, lineBuffered: true, follow: true}
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- checkInputs(func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+			checkValues(filter, input, output, bloomParams)
+		}, inputs, stop, bloomParams)
+	}()
+	waitForOutput := func(expected string) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			output, _ := ioutil.ReadFile(strings.Replace(outputPath, "{name}", "dns", 1))
+			if string(output) == expected {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected output %q", output)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForOutput("foo\n")
+
+	// data appended after the end is read
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(f, "bar\n")
+	f.Close()
+	waitForOutput("foo\nbar\n")
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileInputs(t *testing.T) {
+	inputs, err := fileInputs([]string{"/var/run/dns", "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inputs[0].name != "dns" || inputs[1].name != "http" {
+		t.Fatalf("unexpected names %q and %q", inputs[0].name, inputs[1].name)
+	}
+	if _, err := fileInputs([]string{"a/dns", "b/dns"}); err == nil {
+		t.Fatal("expected an error for inputs with the same name")
+	}
+}
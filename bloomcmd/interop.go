@@ -0,0 +1,147 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DCSO/bloom"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// verifyInteropFlags are the flags of verify-interop.
+var verifyInteropFlags = []cli.Flag{
+	cli.StringFlag{Name: "values", Usage: "The file listing the values to check, one per line, each prefixed with '+' if the implementation that wrote the filter reports it as possibly contained and '-' if not (lines starting with '#' are ignored)."},
+	cli.BoolFlag{Name: "json", Usage: "Print the report as a JSON object."},
+}
+
+// expectedValue is a value listed for verify-interop with the expected result
+// of checking it.
+type expectedValue struct {
+	value   string
+	present bool
+}
+
+// readExpectedValues reads the values listed in a file for verify-interop.
+func readExpectedValues(path string) ([]expectedValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var values []expectedValue
+	scanner := bufio.NewScanner(f)
+	// values may be longer than the default limit of 64 KiB
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch {
+		case text == "" || text[0] == '#':
+		case text[0] == '+' || text[0] == '-':
+			values = append(values, expectedValue{value: text[1:], present: text[0] == '+'})
+		default:
+			return nil, fmt.Errorf("%s, line %d: expected '+' or '-' before the value", path, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// interopReport is the report of verify-interop.
+type interopReport struct {
+	File           string  `json:"file"`
+	HashScheme     int     `json:"hash_scheme"`
+	HashSchemeName string  `json:"hash_scheme_name"`
+	Capacity       uint64  `json:"capacity"`
+	FPP            float64 `json:"fpp"`
+	HashFuncs      uint64  `json:"hash_funcs"`
+	Bits           uint64  `json:"bits"`
+	Elements       uint64  `json:"elements"`
+	// Values is the number of values checked, ExpectedHits the number of
+	// those expected to be contained.
+	Values       int `json:"values"`
+	ExpectedHits int `json:"expected_hits"`
+	// UnexpectedMisses and UnexpectedHits are the values whose check gave
+	// another result than listed.
+	UnexpectedMisses []string `json:"unexpected_misses"`
+	UnexpectedHits   []string `json:"unexpected_hits"`
+	// Error is the reason the filter could not be read, e.g. an unsupported
+	// hash scheme.
+	Error string `json:"error,omitempty"`
+}
+
+// mismatches returns the number of values whose check gave another result
+// than listed.
+func (r interopReport) mismatches() int {
+	return len(r.UnexpectedMisses) + len(r.UnexpectedHits)
+}
+
+// verifyInterop checks the listed values against the filter at path, written
+// by another implementation, and reports those giving other results. A filter
+// with an unsupported hash scheme is reported with the scheme and the error,
+// which is also returned.
+func verifyInterop(path string, gzip bool, values []expectedValue) (interopReport, error) {
+	report := interopReport{
+		File:             path,
+		UnexpectedMisses: []string{},
+		UnexpectedHits:   []string{},
+	}
+	filter, err := bloom.LoadFilter(path, gzip)
+	if err != nil {
+		var schemeErr *bloom.UnsupportedHashSchemeError
+		if !errors.As(err, &schemeErr) {
+			return report, fmt.Errorf("Cannot read the filter %s: %s", path, err)
+		}
+		report.HashScheme, report.HashSchemeName = schemeErr.Scheme, bloom.HashSchemeName(schemeErr.Scheme)
+		report.Error = err.Error()
+		return report, fmt.Errorf("Cannot check the values of %s: %s", path, err)
+	}
+	stats := filter.Stats()
+	report.HashScheme, report.HashSchemeName = bloom.HashSchemeFNV1, bloom.HashSchemeName(bloom.HashSchemeFNV1)
+	report.Capacity, report.FPP = stats.Capacity, stats.FalsePositiveProb
+	report.HashFuncs, report.Bits, report.Elements = stats.HashFuncs, stats.Bits, stats.Elements
+	for _, v := range values {
+		report.Values++
+		if v.present {
+			report.ExpectedHits++
+		}
+		switch present := filter.Check([]byte(v.value)); {
+		case v.present && !present:
+			report.UnexpectedMisses = append(report.UnexpectedMisses, v.value)
+		case !v.present && present:
+			report.UnexpectedHits = append(report.UnexpectedHits, v.value)
+		}
+	}
+	return report, nil
+}
+
+// printInteropReport writes the report as text or as a JSON object.
+func printInteropReport(w io.Writer, report interopReport, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(w).Encode(report)
+	}
+	fmt.Fprintf(w, "File:\t\t\t%s\n", report.File)
+	fmt.Fprintf(w, "Hash scheme:\t\t%d (%s)\n", report.HashScheme, report.HashSchemeName)
+	if report.Error != "" {
+		fmt.Fprintf(w, "Error:\t\t\t%s\n", report.Error)
+		return nil
+	}
+	fmt.Fprintf(w, "Parameters:\t\tn = %d, p = %g, k = %d, m = %d, N = %d\n",
+		report.Capacity, report.FPP, report.HashFuncs, report.Bits, report.Elements)
+	fmt.Fprintf(w, "Values:\t\t\t%d (%d expected hits)\n", report.Values, report.ExpectedHits)
+	for _, value := range report.UnexpectedMisses {
+		fmt.Fprintf(w, "Unexpected miss:\t%q\n", value)
+	}
+	for _, value := range report.UnexpectedHits {
+		fmt.Fprintf(w, "Unexpected hit:\t\t%q\n", value)
+	}
+	return nil
+}
@@ -0,0 +1,3099 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package bloomcmd implements the bloom command line tool, so that it can be
+// embedded in other programs. Run runs the tool with the given arguments and
+// streams, Main also reports errors like the tool itself.
+package bloomcmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/bloomtest"
+	"github.com/DCSO/bloom/pipeline"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// BloomParams represents the parameters of the 'bloom' command line tool.
+type BloomParams struct {
+	gzip           bool
+	interactive    bool
+	split          bool
+	printEachMatch bool
+	delimiter      string
+	fields         []int
+	tupleFields    []int
+	printFields    []int
+	matchAll       bool
+	invertMatch    bool
+	keepCR         bool
+	forceStdin     bool
+	lineBuffered   bool
+	// inputs are the files read concurrently instead of standard input by
+	// check, with the lines to report written to the files named by the
+	// output template, if given
+	inputs         []string
+	outputTemplate string
+	follow         bool
+	// unique makes check report each distinct line once, tracking up to
+	// uniqueMax lines exactly, with count the number of times it was matched
+	unique    bool
+	uniqueMax int
+	count     bool
+	// sampler selects the lines processed by check, if sampling
+	sampler *pipeline.Sampler
+	// summary makes check print the number of lines checked and reported,
+	// corrected for false positives, see reportSummary
+	summary bool
+	// strictSettings makes check fail instead of warning if the input
+	// settings recorded with the filter differ, ignoreSettings skips the
+	// comparison
+	strictSettings bool
+	ignoreSettings bool
+	exactCount     bool
+	exactCountMem  int64
+	// maxHashFuncs and strictHashFuncs configure create with
+	// bloom.WithMaxHashFuncs
+	maxHashFuncs    uint64
+	strictHashFuncs bool
+	from            string
+	// fromDir is the directory tree whose files are added by create, using
+	// the values named by dirValue
+	fromDir        string
+	dirValue       string
+	quiet          bool
+	force          bool
+	maxValueBytes  uint64
+	maxValuePolicy bloom.ValueLengthPolicy
+	// guardValueBytes is the maximum value length enforced by the tool for
+	// filters that do not record a limit themselves
+	guardValueBytes  uint64
+	autosaveInterval time.Duration
+	autosaveEvery    uint64
+	autosavePath     string
+	resume           bool
+	// autosaver writes the snapshots while values are inserted, starting
+	// at inputOffset
+	autosaver   *bloom.Autosaver
+	inputOffset int64
+	// producer identifies the tool in the filters it writes, in all of them
+	// with recordProducer, and comment, if set with --comment, is written
+	// to the comment region of the filters created
+	producer       string
+	recordProducer bool
+	comment        *string
+	// limiter limits the rate of the lines read and niceIO reads them in
+	// small chunks, see throttleFlags
+	limiter *pipeline.RateLimiter
+	niceIO  bool
+	// progress makes create and insert report their progress, estimating
+	// the size of gzip-compressed files with gzipRatio, see progressFlags
+	progress  bool
+	gzipRatio float64
+	// dedupWindow is the number of recently added distinct values whose
+	// repetitions are skipped by insert, see bloom.DedupWindow
+	dedupWindow int
+	// duplicates counts the values added more than once, which are written
+	// to duplicatesPath, see duplicateReport
+	duplicates     *duplicateReport
+	duplicatesPath string
+	// decoding is the encoding of the input values, which are decoded
+	// before they are used, and encoding the one of the values printed by
+	// check with --each
+	decoding    pipeline.Encoding
+	skipInvalid bool
+	encoding    pipeline.Encoding
+	// invalid records the first value that could not be decoded, which
+	// makes the command fail once the input ended
+	invalid *invalidInput
+	// json makes check print the outcome of each value as JSON, see
+	// outcomeJSON, or template renders it, see templateOutcome, and source
+	// names the input of check in the outcomes
+	json     bool
+	template *template.Template
+	source   string
+	// webhook configures forwarding the matches of check to a webhook, done
+	// by forwarder while checking, with the filter checked named by
+	// filterName unless several are
+	webhook    *pipeline.ForwarderConfig
+	forwarder  *pipeline.Forwarder
+	filterName string
+	// lockWait is how long to wait for the lock of a filter to modify, which
+	// is not taken with noLock, see lockFlags
+	lockWait time.Duration
+	noLock   bool
+	// ctx ends the input when done, by calling cancel when check is
+	// interrupted or terminated
+	ctx    context.Context
+	cancel func()
+	// dryRun makes the commands print the filters they would write instead
+	// of writing them, and logFile names the audit log the filters written
+	// are recorded in by command, see writeFilter
+	dryRun  bool
+	logFile string
+	command string
+	// tag names the source the values inserted are attributed to, whose
+	// filter, created with tagCapacity if given, is tagFilter; tagged holds
+	// the tags of the filter checked, which are printed with showTags
+	tag         string
+	tagCapacity uint64
+	tagFilter   *bloom.BloomFilter
+	tagged      *bloom.TaggedFilter
+	showTags    bool
+	// domainForms makes check also check the other forms of the values as
+	// domain names, see checkForms
+	domainForms bool
+	streams
+}
+
+// writeOptions returns the options for writing filters, followed by the given
+// ones. The producer is recorded in filters written in version 2 of the file
+// format, and makes all filters use it with --record-producer.
+func (p BloomParams) writeOptions(opts ...bloom.WriteOption) []bloom.WriteOption {
+	options := []bloom.WriteOption{bloom.WithDefaultProducer(p.producer)}
+	if p.recordProducer {
+		options[0] = bloom.WithProducer(p.producer)
+	}
+	if p.comment != nil {
+		options = append(options, bloom.WithComment(*p.comment))
+	}
+	return append(options, opts...)
+}
+
+// streams are the standard streams of the tool.
+type streams struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// exit is called to exit the process when it is interrupted or
+	// terminated, after writing buffered output. Signals are not handled if
+	// it is nil.
+	exit func(code int)
+	// args are the arguments the tool was run with, without the name of
+	// the program, as recorded in the audit log
+	args []string
+}
+
+// warnf prints a warning to the standard error stream, if any.
+func (s streams) warnf(format string, a ...interface{}) {
+	if s.stderr != nil {
+		fmt.Fprintf(s.stderr, "Warning: "+format+"\n", a...)
+	}
+}
+
+// dataSizeWarningThreshold is the size above which set-data warns about the
+// size of the data read from standard input.
+const dataSizeWarningThreshold = 1 << 20
+
+// valueSet is a filter that values are added to or checked against, i.e. a
+// single filter or a sharded filter.
+type valueSet interface {
+	Add(value []byte)
+	Check(value []byte) bool
+	Rejects(value []byte) bool
+}
+
+// valueLimitFlags are the flags of the commands that add or check values
+// to limit the length of the values.
+var valueLimitFlags = []cli.Flag{
+	cli.Uint64Flag{Name: "max-value-bytes", Usage: "The maximum length of values in bytes (stored in new filters; must match the limit of existing filters)."},
+	cli.StringFlag{Name: "max-value-policy", Value: "reject", Usage: "How to handle longer values: 'reject' (skip) or 'truncate'."},
+}
+
+func parseValueLimitFlags(c *cli.Context, bloomParams *BloomParams) error {
+	var err error
+	bloomParams.maxValueBytes = c.Uint64("max-value-bytes")
+	bloomParams.maxValuePolicy, err = bloom.ParseValueLengthPolicy(c.String("max-value-policy"))
+	return err
+}
+
+// autosaveFlags are the flags of the commands that add values from standard
+// input to write snapshots during long runs.
+var autosaveFlags = []cli.Flag{
+	cli.DurationFlag{Name: "autosave-interval", Usage: "Write a snapshot of the filter at the given interval (e.g. '10m') while values are inserted."},
+	cli.Uint64Flag{Name: "autosave-every", Usage: "Write a snapshot of the filter whenever the given number of values was inserted."},
+	cli.StringFlag{Name: "autosave-path", Usage: "Write the snapshots to the given file instead of the destination, which is removed when the filter is complete."},
+	cli.BoolFlag{Name: "resume", Usage: "Continue from the last snapshot, skipping the part of the input that it contains (the same input must be given)."},
+}
+
+func parseAutosaveFlags(c *cli.Context, bloomParams *BloomParams) error {
+	bloomParams.autosaveInterval = c.Duration("autosave-interval")
+	bloomParams.autosaveEvery = c.Uint64("autosave-every")
+	bloomParams.autosavePath = c.String("autosave-path")
+	bloomParams.resume = c.Bool("resume")
+	if bloomParams.dryRun && (bloomParams.autosaving() || bloomParams.resume) {
+		return errors.New("--dry-run cannot be used with autosaving or --resume.")
+	}
+	if bloomParams.autosaveInterval < 0 {
+		return errors.New("The autosave interval cannot be negative.")
+	}
+	if bloomParams.autosavePath != "" {
+		path, err := filepath.Abs(bloomParams.autosavePath)
+		if err != nil {
+			return err
+		}
+		bloomParams.autosavePath = path
+	}
+	return nil
+}
+
+var throttleFlags = []cli.Flag{
+	cli.StringFlag{Name: "max-rate", Usage: "Process at most the given number of lines per second (e.g. '5000'), or bytes per second with a unit (e.g. '20MB/s'), in total over all inputs."},
+	cli.BoolFlag{Name: "nice-io", Usage: "Read the input in small chunks, pausing after each for as long as it took to read (at most a second), to leave I/O capacity to other processes."},
+}
+
+func parseThrottleFlags(c *cli.Context, bloomParams *BloomParams) error {
+	if v := c.String("max-rate"); v != "" {
+		rate, err := pipeline.ParseRate(v)
+		if err != nil {
+			return fmt.Errorf("Invalid value for --max-rate: %s", err)
+		}
+		if bloomParams.limiter, err = pipeline.NewRateLimiter(rate); err != nil {
+			return err
+		}
+	}
+	bloomParams.niceIO = c.Bool("nice-io")
+	return nil
+}
+
+// duplicateFlags are the flags of the commands that add values from standard
+// input to report the values added more than once.
+var duplicateFlags = []cli.Flag{
+	cli.StringFlag{Name: "report-duplicates", Usage: "Write the values added more than once, with the number of times, to the given file (a small fraction may be false positives of the filter, reported twice)."},
+	cli.IntFlag{Name: "report-duplicates-max", Value: defaultDuplicatesMax, Usage: "The maximum number of duplicates to count, beyond which the least frequent are dropped from the report."},
+}
+
+func parseDuplicateFlags(c *cli.Context, bloomParams *BloomParams) error {
+	bloomParams.duplicatesPath = c.String("report-duplicates")
+	if bloomParams.duplicatesPath == "" {
+		return nil
+	}
+	if bloomParams.dryRun {
+		return errors.New("--dry-run cannot be used with --report-duplicates.")
+	}
+	if c.Int("report-duplicates-max") < 1 {
+		return errors.New("--report-duplicates-max must be positive.")
+	}
+	// the report is written once all values were added, so it is checked
+	// early that it can be
+	f, err := os.Create(bloomParams.duplicatesPath)
+	if err != nil {
+		return fmt.Errorf("Cannot create the duplicate report: %s", err)
+	}
+	f.Close()
+	bloomParams.duplicates = newDuplicateReport(c.Int("report-duplicates-max"))
+	return nil
+}
+
+// decodeFlags are the flags of the commands that read values to decode them.
+var decodeFlags = []cli.Flag{
+	cli.StringFlag{Name: "decode", Usage: "Decode the values, e.g. hash digests, from the given encoding ('hex' or 'base64') before they are used (recorded with new filters)."},
+	cli.BoolFlag{Name: "skip-invalid", Usage: "Skip lines with values that cannot be decoded, instead of failing."},
+}
+
+func parseDecodeFlags(c *cli.Context, bloomParams *BloomParams) error {
+	bloomParams.skipInvalid = c.Bool("skip-invalid")
+	if c.String("decode") == "" {
+		if bloomParams.skipInvalid {
+			return errors.New("--skip-invalid requires --decode.")
+		}
+		return nil
+	}
+	var err error
+	if bloomParams.decoding, err = pipeline.ParseEncoding(c.String("decode")); err != nil {
+		return fmt.Errorf("Invalid value for --decode: %s", err)
+	}
+	if len(bloomParams.tupleFields) > 0 {
+		return errors.New("--decode cannot be used with --tuple-fields.")
+	}
+	bloomParams.invalid = &invalidInput{}
+	return nil
+}
+
+// parseTemplateFlags compiles the template given to check with --template or
+// --template-file, if any.
+func parseTemplateFlags(c *cli.Context) (*template.Template, error) {
+	text := c.String("template")
+	if path := c.String("template-file"); path != "" {
+		if text != "" {
+			return nil, errors.New("--template cannot be used with --template-file.")
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read the template: %s", err)
+		}
+		text = string(data)
+	}
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := parseOutcomeTemplate(text)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// lockFlags are the flags of the commands that load, modify and write a filter
+// to control the lock that keeps concurrent commands from overwriting each
+// other's changes.
+var lockFlags = []cli.Flag{
+	cli.DurationFlag{Name: "wait-lock", Usage: "Wait up to the given duration (e.g. '5m') for another command modifying the filter to finish, instead of failing immediately."},
+	cli.BoolFlag{Name: "no-lock", Usage: "Do not lock the filter (e.g. on file systems without locking); concurrent commands may lose each other's changes."},
+}
+
+func parseLockFlags(c *cli.Context, bloomParams *BloomParams) error {
+	bloomParams.lockWait = c.Duration("wait-lock")
+	bloomParams.noLock = c.Bool("no-lock")
+	if bloomParams.lockWait < 0 {
+		return errors.New("The lock wait duration cannot be negative.")
+	}
+	if bloomParams.noLock && bloomParams.lockWait > 0 {
+		return errors.New("--wait-lock cannot be used with --no-lock.")
+	}
+	return nil
+}
+
+// lockFilter takes the lock of the filter at path for loading, modifying and
+// writing it, unless disabled with --no-lock, and returns the function that
+// releases it.
+func (p BloomParams) lockFilter(path string) (func(), error) {
+	// a dry run writes nothing, not even the lock file
+	if p.noLock || p.dryRun {
+		return func() {}, nil
+	}
+	lock, err := bloom.LockFile(path, p.lockWait)
+	if errors.Is(err, bloom.ErrLocked) {
+		return nil, fmt.Errorf("The filter %s is being modified by another command (%s); use --wait-lock to wait for it.", path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot lock the filter %s: %s", path, err)
+	}
+	return func() { lock.Unlock() }, nil
+}
+
+// invalidInput records the first value that could not be decoded by any of
+// the inputs.
+type invalidInput struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (i *invalidInput) set(err error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.err == nil {
+		i.err = err
+	}
+}
+
+// failure returns the error for a value that could not be decoded, if any.
+func (bloomParams BloomParams) failure() error {
+	if bloomParams.invalid == nil {
+		return nil
+	}
+	bloomParams.invalid.mu.Lock()
+	defer bloomParams.invalid.mu.Unlock()
+	if bloomParams.invalid.err == nil {
+		return nil
+	}
+	return fmt.Errorf("Invalid input on %s; use --skip-invalid to skip such lines.", bloomParams.invalid.err)
+}
+
+// decoded returns the driver decoding the values as given with --decode.
+func (bloomParams BloomParams) decoded(driver pipeline.Driver) pipeline.Driver {
+	driver.Decoding = bloomParams.decoding
+	driver.SkipInvalid = bloomParams.skipInvalid
+	return driver
+}
+
+// writeDuplicateReport writes the duplicates found while adding values, if
+// requested.
+func (bloomParams BloomParams) writeDuplicateReport() error {
+	if bloomParams.duplicates == nil {
+		return nil
+	}
+	return bloomParams.duplicates.writeFile(bloomParams.duplicatesPath, bloomParams.streams)
+}
+
+// throttled returns the driver limited by the throttle flags and stopped by
+// the context of the tool, if any.
+func (bloomParams BloomParams) throttled(driver pipeline.Driver) pipeline.Driver {
+	driver.Limiter = bloomParams.limiter
+	driver.NiceIO = bloomParams.niceIO
+	driver.Context = bloomParams.ctx
+	return driver
+}
+
+// autosaving returns true if snapshots are written or resumed.
+func (bloomParams BloomParams) autosaving() bool {
+	return bloomParams.autosaveInterval > 0 || bloomParams.autosaveEvery > 0 || bloomParams.resume
+}
+
+// startAutosave sets up the snapshots of the filter written to path, or to
+// the autosave path if given. With resume, the last snapshot replaces the
+// filter if it exists, and the input it contains is skipped. Without an
+// autosave path, the filter inserted into is its own snapshot, so if it was
+// not written as a snapshot, the input is read from the start. The filter to
+// add the values to is returned.
+func startAutosave(filter *bloom.BloomFilter, path string, creating bool, bloomParams *BloomParams) (*bloom.BloomFilter, error) {
+	snapshotPath := path
+	if bloomParams.autosavePath != "" {
+		snapshotPath = bloomParams.autosavePath
+	}
+	var offset int64
+	if bloomParams.resume {
+		if _, err := os.Stat(snapshotPath); err == nil {
+			snapshot, snapshotOffset, err := bloom.ResumeFilter(snapshotPath, bloomParams.gzip)
+			if err != nil {
+				return nil, err
+			}
+			if snapshotOffset < 0 && (creating || snapshotPath != path) {
+				return nil, fmt.Errorf("%s is not a snapshot, cannot resume.", snapshotPath)
+			}
+			if snapshotOffset < 0 {
+				snapshotOffset = 0
+			}
+			fmt.Fprintf(bloomParams.stderr, "Resuming from %s at input offset %d\n", snapshotPath, snapshotOffset)
+			if err := skipInput(bloomParams.stdin, snapshotOffset); err != nil {
+				return nil, fmt.Errorf("Cannot skip the input contained in the snapshot: %s", err)
+			}
+			filter, offset = snapshot, snapshotOffset
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if bloomParams.autosaveInterval > 0 || bloomParams.autosaveEvery > 0 {
+		bloomParams.autosaver = bloom.NewAutosaver(filter, snapshotPath, bloomParams.gzip,
+			bloomParams.autosaveEvery, bloomParams.autosaveInterval, offset)
+	}
+	bloomParams.inputOffset = offset
+	return filter, nil
+}
+
+// finishAutosave removes the snapshot written to the autosave path once the
+// filter was written to path.
+func finishAutosave(path string, bloomParams BloomParams) {
+	if bloomParams.autosavePath == "" || bloomParams.autosavePath == path {
+		return
+	}
+	if err := os.Remove(bloomParams.autosavePath); err != nil && !os.IsNotExist(err) {
+		bloomParams.warnf("cannot remove snapshot: %s", err)
+	}
+}
+
+// skipInput skips the given number of bytes of the input, seeking if
+// possible.
+func skipInput(input io.Reader, offset int64) error {
+	if file, ok := input.(*os.File); ok {
+		if stat, err := file.Stat(); err == nil && stat.Mode().IsRegular() {
+			if stat.Size() < offset {
+				return io.ErrUnexpectedEOF
+			}
+			_, err := file.Seek(offset, io.SeekStart)
+			return err
+		}
+	}
+	n, err := io.CopyN(ioutil.Discard, input, offset)
+	if err == io.EOF && n < offset {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// applyValueLimit applies the value length limit given on the command line
+// to the filter. New filters store the limit; existing filters must either
+// record the same limit or none, in which case longer values are skipped.
+func applyValueLimit(filter *bloom.BloomFilter, bloomParams *BloomParams, creating bool) error {
+	if bloomParams.maxValueBytes == 0 {
+		return nil
+	}
+	if creating {
+		filter.SetMaxValueLength(bloomParams.maxValueBytes, bloomParams.maxValuePolicy)
+		return nil
+	}
+	maxLength, policy := filter.MaxValueLength()
+	if maxLength == 0 {
+		if bloomParams.maxValuePolicy == bloom.TruncateValues {
+			return errors.New("Values can only be truncated for filters created with the same limit.")
+		}
+		bloomParams.guardValueBytes = bloomParams.maxValueBytes
+		return nil
+	}
+	if maxLength != bloomParams.maxValueBytes || policy != bloomParams.maxValuePolicy {
+		return fmt.Errorf("The filter limits values to %d bytes (%s), not %d bytes (%s).",
+			maxLength, policy, bloomParams.maxValueBytes, bloomParams.maxValuePolicy)
+	}
+	return nil
+}
+
+// valueRejected returns true if the value is too long to be added or checked.
+func valueRejected(filter valueSet, value []byte, bloomParams BloomParams) bool {
+	if bloomParams.guardValueBytes > 0 && uint64(len(value)) > bloomParams.guardValueBytes {
+		return true
+	}
+	return filter.Rejects(value)
+}
+
+func (s streams) warnRejectedValues(rejected int) {
+	if rejected > 0 {
+		s.warnf("skipped %d values exceeding the maximum value length", rejected)
+	}
+}
+
+func readValuesIntoFilter(filter valueSet, bloomParams BloomParams) {
+	//if we are not in an interactive session and this is a terminal, we quit
+	if !readStdin(bloomParams) {
+		return
+	}
+	if bloomParams.interactive {
+		fmt.Fprintln(bloomParams.stdout, "Interactive mode: Enter a blank line [by pressing ENTER] to exit (values will not be stored otherwise).")
+	}
+	insertValues(filter, bloomParams.stdin, bloomParams)
+}
+
+// insertValues adds the values read from the input to the filter.
+func insertValues(filter valueSet, input io.Reader, bloomParams BloomParams) {
+	rejected := 0
+	var added, values uint64
+	var window *bloom.DedupWindow
+	if bloomParams.dedupWindow > 0 {
+		window = bloom.NewDedupWindow(bloomParams.dedupWindow)
+	}
+	add := func(value []byte) {
+		if window != nil && window.Contains(value) {
+			// adding it again would not change the filter
+			added++
+			values++
+			return
+		}
+		if valueRejected(filter, value, bloomParams) {
+			rejected++
+			return
+		}
+		if bloomParams.duplicates != nil {
+			bloomParams.duplicates.observe(value, testAndAdd(filter, value))
+		} else {
+			filter.Add(value)
+		}
+		if window != nil {
+			window.Insert(value)
+		}
+		added++
+		values++
+	}
+	driver := bloomParams.decoded(bloomParams.throttled(pipeline.Driver{
+		Pipeline:        insertPipeline(bloomParams),
+		KeepCR:          bloomParams.keepCR,
+		StopAtEmptyLine: bloomParams.interactive,
+	}))
+	autosaver := bloomParams.autosaver
+	progress := bloomParams.inputProgressReporter(input)
+	var read int64
+	if autosaver != nil || progress != nil {
+		driver.Progress = func(offset int64) error {
+			read = offset
+			if progress != nil {
+				progress.update(offset, values)
+			}
+			if autosaver == nil {
+				return nil
+			}
+			if err := autosaver.Advance(bloomParams.inputOffset+offset, added); err != nil {
+				bloomParams.warnf("autosave failed, no further snapshots are written: %s", err)
+				autosaver = nil
+			}
+			added = 0
+			return nil
+		}
+	}
+	stats, err := driver.Add(input, add)
+	if progress != nil {
+		progress.finish(read, values)
+	}
+	if window != nil && bloomParams.stderr != nil {
+		fmt.Fprintf(bloomParams.stderr, "Dedup window: %d hits, %d misses\n", window.Hits(), window.Misses())
+	}
+	bloomParams.handleInputError(err)
+	bloomParams.warnStrippedCR(stats.StrippedCR)
+	bloomParams.warnSkippedInvalid(stats.Invalid)
+	bloomParams.warnRejectedValues(rejected)
+}
+
+// insertPipeline returns the transformers applied to the lines read when
+// inserting values.
+func insertPipeline(bloomParams BloomParams) pipeline.Pipeline {
+	// fields are only selected from split lines when inserting
+	var transformers pipeline.Pipeline
+	if bloomParams.split {
+		transformers = append(transformers, pipeline.Split(bloomParams.delimiter))
+		if len(bloomParams.fields) > 0 {
+			transformers = append(transformers, pipeline.SelectFields(bloomParams.fields))
+		}
+	}
+	if len(bloomParams.tupleFields) > 0 {
+		transformers = append(transformers, pipeline.Tuple(bloomParams.tupleFields))
+	}
+	return transformers
+}
+
+// warnInputError prints a warning if the input could not be read completely.
+func (s streams) warnInputError(err error) {
+	if err != nil {
+		s.warnf("error reading input: %s", err)
+	}
+}
+
+// handleInputError records a value that could not be decoded, which ended
+// the input, to fail the command, and warns about other errors.
+func (bloomParams BloomParams) handleInputError(err error) {
+	var invalid *pipeline.InvalidValueError
+	if errors.As(err, &invalid) && bloomParams.invalid != nil {
+		bloomParams.invalid.set(invalid)
+		return
+	}
+	bloomParams.warnInputError(err)
+}
+
+// warnSkippedInvalid prints a warning if lines with values that could not be
+// decoded were skipped.
+func (bloomParams BloomParams) warnSkippedInvalid(skipped int) {
+	if skipped > 0 {
+		bloomParams.warnf("skipped %d lines with values that are not valid %s", skipped, bloomParams.decoding)
+	}
+}
+
+// warnStrippedCR prints a warning if carriage returns were stripped.
+func (s streams) warnStrippedCR(stripped int) {
+	if stripped > 0 {
+		s.warnf("stripped trailing CR from %d lines (CRLF input?), use --keep-cr to keep them", stripped)
+	}
+}
+
+func readInputIntoData(filter *bloom.BloomFilter, bloomParams BloomParams) {
+	//if we are not in an interactive session and this is a terminal, we quit
+	if !readStdin(bloomParams) {
+		return
+	}
+	if bloomParams.interactive {
+		fmt.Fprintln(bloomParams.stdout, "Interactive mode: Enter a blank line [by pressing ENTER] to exit (values will not be stored otherwise).")
+	}
+	scanner := pipeline.NewScanner(bloomParams.stdin, bloomParams.keepCR)
+	dataBuffer := bytes.NewBuffer([]byte(""))
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 && bloomParams.interactive {
+			break
+		}
+		dataBuffer.Write(line)
+		dataBuffer.Write([]byte("\n"))
+	}
+	bloomParams.warnStrippedCR(scanner.StrippedCR())
+	// the buffer is not used afterwards, so the filter can own its memory
+	filter.SetDataNoCopy(dataBuffer.Bytes())
+	if filter.DataSize() > dataSizeWarningThreshold {
+		bloomParams.warnf("data is %d bytes, which is unusually large for filter data (maximum: %d bytes)",
+			filter.DataSize(), bloom.DefaultMaxDataSize)
+	}
+}
+
+// checkCompatibility refuses to insert into a filter whose settings conflict
+// with those given on the command line, unless --force is given, in which case
+// the settings of the filter are used.
+func checkCompatibility(filter *bloom.BloomFilter, bloomParams *BloomParams) error {
+	if maxLength, _ := filter.MaxValueLength(); maxLength == 0 || bloomParams.maxValueBytes == 0 {
+		return nil
+	}
+	err := filter.CompatibleWith(bloom.WithMaxValueLength(bloomParams.maxValueBytes, bloomParams.maxValuePolicy))
+	if err == nil {
+		return nil
+	}
+	if !bloomParams.force {
+		return fmt.Errorf("%s (use --force to insert using the settings of the filter)", err)
+	}
+	bloomParams.warnf("%s, using the settings of the filter", err)
+	bloomParams.maxValueBytes = 0
+	return nil
+}
+
+// warnCapacity prints a warning if the filter holds more elements than its
+// capacity.
+func (s streams) warnCapacity(filter *bloom.BloomFilter) {
+	stats := filter.Stats()
+	if stats.Elements > stats.Capacity {
+		s.warnf("the filter holds %d elements, more than its capacity of %d, so the false positive probability exceeds %.2e",
+			stats.Elements, stats.Capacity, stats.FalsePositiveProb)
+	}
+}
+
+// readValuesWithAlarm reads the values into the filter like
+// testAndAdder is a valueSet that can add a value and report whether it may
+// have been contained before while hashing it only once.
+type testAndAdder interface {
+	TestAndAdd(value []byte) bool
+}
+
+// testAndAdd adds a value to the filter and returns whether it may have been
+// contained before.
+func testAndAdd(filter valueSet, value []byte) bool {
+	if t, ok := filter.(testAndAdder); ok {
+		return t.TestAndAdd(value)
+	}
+	contained := filter.Check(value)
+	filter.Add(value)
+	return contained
+}
+
+// readValuesIntoFilter, printing a warning whenever its estimated false
+// positive probability exceeds one of the default saturation thresholds.
+func readValuesWithAlarm(filter *bloom.BloomFilter, bloomParams BloomParams) {
+	alarm := bloom.NewSaturationAlarm(filter, bloom.DefaultSaturationThresholds, func(threshold float64, stats bloom.FilterStats) {
+		bloomParams.warnf("the estimated false positive probability exceeds %g times %.2e after %d elements",
+			threshold, stats.FalsePositiveProb, stats.Elements)
+	})
+	var target valueSet = alarm
+	if bloomParams.tagFilter != nil {
+		target = taggedAdder{alarm, bloomParams.tagFilter}
+	}
+	readValuesIntoFilter(target, bloomParams)
+	alarm.Evaluate()
+}
+
+// taggedAdder adds values to the filter and to the filter of the tag given
+// with --tag, like TaggedFilter.AddTagged.
+type taggedAdder struct {
+	valueSet
+	tag *bloom.BloomFilter
+}
+
+func (a taggedAdder) Add(value []byte) {
+	a.valueSet.Add(value)
+	a.tag.Add(value)
+}
+
+func (a taggedAdder) TestAndAdd(value []byte) bool {
+	contained := testAndAdd(a.valueSet, value)
+	a.tag.Add(value)
+	return contained
+}
+
+func insertIntoFilter(path string, bloomParams BloomParams) error {
+	unlock, err := bloomParams.lockFilter(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	if !bloomParams.quiet {
+		if err = writeStats(bloomParams.stderr, path, filter); err != nil {
+			return err
+		}
+	}
+	if err = checkCompatibility(filter, &bloomParams); err != nil {
+		return err
+	}
+	if err = applyValueLimit(filter, &bloomParams, false); err != nil {
+		return err
+	}
+	// a snapshot that is inserted into without resuming is no longer one
+	filter.DeleteMetadata(bloom.MetadataKeyInputOffset)
+	filter.RecordInputSettings(bloomParams.inputSettings())
+	var written writableFilter = filter
+	if bloomParams.tag != "" {
+		tagged, err := bloom.TaggedOf(filter)
+		if err != nil {
+			return err
+		}
+		if tagged.Tag(bloomParams.tag) == nil && bloomParams.tagCapacity > 0 {
+			bloomParams.tagFilter, err = tagged.AddTag(bloomParams.tag, bloomParams.tagCapacity, filter.FalsePositiveProb())
+		} else {
+			bloomParams.tagFilter, err = tagged.EnsureTag(bloomParams.tag)
+		}
+		if err != nil {
+			return err
+		}
+		written = tagged
+	}
+	if bloomParams.autosaving() {
+		filter, err = startAutosave(filter, path, false, &bloomParams)
+		if err != nil {
+			return err
+		}
+	}
+	readValuesWithAlarm(filter, bloomParams)
+	if err = bloomParams.failure(); err != nil {
+		return err
+	}
+	bloomParams.warnCapacity(filter)
+	if err = bloomParams.writeDuplicateReport(); err != nil {
+		return err
+	}
+	if _, err = bloomParams.writeFilter(written, path, []string{path}); err != nil {
+		return err
+	}
+	finishAutosave(path, bloomParams)
+	return nil
+}
+
+// tombstoneDeleter deletes the values it is asked to add.
+type tombstoneDeleter struct {
+	*bloom.TombstoneFilter
+}
+
+func (d tombstoneDeleter) Add(value []byte) {
+	d.Delete(value)
+}
+
+func deleteFromFilter(path string, tombstoneN uint64, tombstoneP float64, bloomParams BloomParams) error {
+	unlock, err := bloomParams.lockFilter(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	main, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	var filter *bloom.TombstoneFilter
+	if _, ok := main.Metadata(bloom.MetadataKeyTombstones); !ok {
+		if tombstoneN == 0 {
+			tombstoneN = bloom.DefaultTombstoneCapacity(main, tombstoneP)
+		}
+		filter, err = bloom.NewTombstoneFilter(main, tombstoneN, tombstoneP)
+	} else {
+		filter, err = bloom.TombstonesOf(main)
+	}
+	if err != nil {
+		return err
+	}
+	if err = applyValueLimit(main, &bloomParams, false); err != nil {
+		return err
+	}
+	readValuesIntoFilter(tombstoneDeleter{filter}, bloomParams)
+	tombstones := filter.Tombstones().Stats()
+	if tombstones.Elements > tombstones.Capacity {
+		bloomParams.warnf("the filter holds %d tombstones, more than their capacity of %d; consider rebuilding it",
+			tombstones.Elements, tombstones.Capacity)
+	}
+	_, err = bloomParams.writeFilter(filter, path, []string{path})
+	return err
+}
+
+func updateFilterData(path string, bloomParams BloomParams) error {
+	unlock, err := bloomParams.lockFilter(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	readInputIntoData(filter, bloomParams)
+	_, err = bloomParams.writeFilter(filter, path, []string{path})
+	return err
+}
+
+func getFilterData(path string, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	_, err = bloomParams.stdout.Write(filter.GetData())
+	return err
+}
+
+// loadFilter loads the filter at path like bloom.LoadFilter, naming the file
+// in errors for filters in a format that is not supported.
+func loadFilter(path string, gzip bool) (*bloom.BloomFilter, error) {
+	filter, err := bloom.LoadFilter(path, gzip)
+	if errors.Is(err, bloom.ErrUnsupportedVersion) || errors.Is(err, bloom.ErrUnsupportedFlags) {
+		return nil, fmt.Errorf("Cannot read the filter %s: %s", path, err)
+	}
+	return filter, err
+}
+
+// loadCheckFilter loads the filter to check against. If the file name part of
+// the path is a glob pattern, the newest valid matching filter is used.
+func loadCheckFilter(path string, bloomParams BloomParams) (*bloom.BloomFilter, error) {
+	dir, pattern := filepath.Split(path)
+	if !strings.ContainsAny(pattern, "*?[") {
+		return loadFilter(path, bloomParams.gzip)
+	}
+	filter, _, err := bloom.LoadNewestFilter(dir, pattern, bloomParams.gzip,
+		bloom.OnSkippedFilter(func(path string, err error) {
+			bloomParams.warnf("skipping filter %s: %s", path, err)
+		}))
+	return filter, err
+}
+
+// lineSelected decides whether a line is reported, given the match results of
+// its selected fields. With matchAll, all selected fields (and at least one)
+// must match, otherwise a single matching field suffices. invertMatch negates
+// the result.
+func lineSelected(matched []bool, matchAll bool, invertMatch bool) bool {
+	selected := matchAll && len(matched) > 0
+	for _, m := range matched {
+		if matchAll && !m {
+			selected = false
+			break
+		}
+		if !matchAll && m {
+			selected = true
+			break
+		}
+	}
+	return selected != invertMatch
+}
+
+// checkPipeline returns the pipeline deriving the values to check from a
+// line: its fields if the line is split, and only the selected ones if fields
+// are given (also for lines that are not split, which then consist of a single
+// field). With tuple fields, the single value checked is the composite key of
+// the given fields.
+func checkPipeline(bloomParams BloomParams) pipeline.Pipeline {
+	var transformers pipeline.Pipeline
+	if bloomParams.split {
+		transformers = append(transformers, pipeline.Split(bloomParams.delimiter))
+	}
+	if len(bloomParams.fields) > 0 {
+		transformers = append(transformers, pipeline.SelectFields(bloomParams.fields))
+	}
+	if len(bloomParams.tupleFields) > 0 {
+		transformers = append(transformers, pipeline.Tuple(bloomParams.tupleFields))
+	}
+	return transformers
+}
+
+// checkLine checks the selected fields of a line using the check function and
+// returns the output lines for it, see reportLine.
+func checkLine(check func(value []byte) bool, line string, bloomParams BloomParams) []string {
+	values := checkPipeline(bloomParams).Values(line)
+	matched := make([]bool, len(values))
+	for i, value := range values {
+		matched[i] = check([]byte(value))
+	}
+	return reportLine(line, values, matched, bloomParams)
+}
+
+// printsOutcomes tells whether check prints the outcome of each value, as
+// JSON or rendered through a template, instead of the reported lines.
+func (bloomParams BloomParams) printsOutcomes() bool {
+	return bloomParams.json || bloomParams.template != nil
+}
+
+// reportOutcomes returns the output lines for a line whose values were
+// checked: the outcomes as JSON with --json or rendered with --template, and
+// otherwise those returned by reportLine.
+func reportOutcomes(line string, outcomes []pipeline.CheckOutcome, bloomParams BloomParams) []string {
+	values, matched := pipeline.Matches(outcomes)
+	if !bloomParams.printsOutcomes() {
+		return reportLine(line, values, matched, bloomParams)
+	}
+	if !lineSelected(matched, bloomParams.matchAll, bloomParams.invertMatch) {
+		return nil
+	}
+	var output []string
+	for _, outcome := range outcomes {
+		if bloomParams.printEachMatch && outcome.Matched == bloomParams.invertMatch {
+			continue
+		}
+		if bloomParams.template == nil {
+			output = append(output, formatOutcome(outcome, bloomParams.encoding))
+			continue
+		}
+		rendered, err := renderOutcome(bloomParams.template, line, outcome, bloomParams)
+		if err != nil {
+			bloomParams.warnf("cannot render line %d: %s", outcome.Line, err)
+			continue
+		}
+		output = append(output, rendered)
+	}
+	return output
+}
+
+// reportLine returns the output lines for a line whose values were checked.
+// With printEachMatch, the individual field values that caused the line to be
+// selected are returned, i.e. the matching values, or the non-matching ones
+// with invertMatch.
+func reportLine(line string, values []string, matched []bool, bloomParams BloomParams) []string {
+	if !lineSelected(matched, bloomParams.matchAll, bloomParams.invertMatch) {
+		return nil
+	}
+	if bloomParams.printEachMatch {
+		var output []string
+		for i, value := range values {
+			if matched[i] != bloomParams.invertMatch {
+				output = append(output, bloomParams.encoding.EncodeToString([]byte(value)))
+			}
+		}
+		return output
+	}
+	if len(bloomParams.printFields) > 0 {
+		fields := []string{line}
+		if bloomParams.split {
+			fields = pipeline.Split(bloomParams.delimiter).Transform(fields)
+		}
+		values := make([]string, 0, len(bloomParams.printFields))
+		for _, i := range bloomParams.printFields {
+			j := i
+			if j < 0 {
+				j = j + len(fields)
+			}
+			if j >= len(fields) || j < 0 {
+				continue
+			}
+			values = append(values, fields[j])
+		}
+		return []string{strings.Join(values, bloomParams.delimiter)}
+	}
+	return []string{line}
+}
+
+func checkAgainstFilter(path string, bloomParams BloomParams) error {
+	filter, err := loadCheckFilter(path, bloomParams)
+	if err != nil {
+		return err
+	}
+	if err = applyValueLimit(filter, &bloomParams, false); err != nil {
+		return err
+	}
+	if err = compareInputSettings(filter, bloomParams); err != nil {
+		return err
+	}
+	if bloom.HasTags(filter) {
+		if bloomParams.tagged, err = bloom.TaggedOf(filter); err != nil {
+			return err
+		}
+	} else if bloomParams.showTags {
+		bloomParams.warnf("the filter has no tags")
+	}
+	var checked valueSet = filter
+	if _, ok := filter.Metadata(bloom.MetadataKeyTombstones); ok {
+		checked, err = bloom.TombstonesOf(filter)
+		if err != nil {
+			return err
+		}
+	}
+	return runCheck(func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+		checkValues(checked, input, output, bloomParams)
+	}, bloomParams)
+}
+
+// inputSettings returns the settings with which values are extracted from the
+// lines read, as recorded with the filters built.
+func (bloomParams BloomParams) inputSettings() bloom.InputSettings {
+	return bloom.InputSettings{
+		Split:       bloomParams.split,
+		Delimiter:   bloomParams.delimiter,
+		Fields:      bloomParams.fields,
+		TupleFields: bloomParams.tupleFields,
+		Decoding:    bloomParams.decodingSetting(),
+	}
+}
+
+// decodingSetting returns the decoding recorded in the input settings.
+func (bloomParams BloomParams) decodingSetting() string {
+	if bloomParams.decoding == pipeline.NoEncoding {
+		return ""
+	}
+	return bloomParams.decoding.String()
+}
+
+// compareInputSettings warns if values are checked against the filter with
+// input settings that make the check miss the values the filter was built
+// from, or fails with --strict-settings.
+func compareInputSettings(filter *bloom.BloomFilter, bloomParams BloomParams) error {
+	if bloomParams.ignoreSettings {
+		return nil
+	}
+	differences, err := filter.CompareInputSettings(bloomParams.inputSettings())
+	if err != nil || len(differences) == 0 {
+		return err
+	}
+	msg := strings.Join(differences, "; ")
+	if bloomParams.strictSettings {
+		return fmt.Errorf("The filter was built with %s, use --ignore-recorded-settings to check anyway.", msg)
+	}
+	bloomParams.warnf("the filter was built with %s, so values may be missed (use --ignore-recorded-settings to silence this warning)", msg)
+	return nil
+}
+
+// checkValues checks the lines read from the input against the filter and
+// writes the lines to report to the output.
+func checkValues(filter valueSet, input io.Reader, output io.Writer, bloomParams BloomParams) {
+	rejected := 0
+	check := func(value []byte) pipeline.CheckOutcome {
+		if valueRejected(filter, value, bloomParams) {
+			rejected++
+			return pipeline.CheckOutcome{}
+		}
+		matched, form := checkForms(filter, value, bloomParams)
+		outcome := pipeline.CheckOutcome{Matched: matched}
+		if bloomParams.printsOutcomes() {
+			outcome.Score = matchScore(filter, form, outcome.Matched)
+		}
+		if outcome.Matched && bloomParams.tagged != nil {
+			outcome.Tags = bloomParams.tagged.CheckTagged(form)
+		}
+		return outcome
+	}
+	prefix := ""
+	if bloomParams.interactive {
+		prefix = ">"
+	}
+	driver := bloomParams.decoded(bloomParams.throttled(pipeline.Driver{
+		Pipeline:        checkPipeline(bloomParams),
+		KeepCR:          bloomParams.keepCR,
+		StopAtEmptyLine: bloomParams.interactive,
+		Sampler:         bloomParams.sampler,
+		SourceFile:      bloomParams.source,
+	}))
+	reported := 0
+	stats, err := driver.CheckOutcomes(input, check, func(line string, outcomes []pipeline.CheckOutcome) error {
+		bloomParams.forwardMatches(outcomes)
+		results := reportOutcomes(line, outcomes, bloomParams)
+		if bloomParams.showTags {
+			results = prefixTags(results, outcomes, bloomParams)
+		}
+		for _, result := range results {
+			fmt.Fprintf(output, "%s%s\n", prefix, result)
+		}
+		if len(results) > 0 {
+			reported++
+		}
+		return nil
+	})
+	bloomParams.handleInputError(err)
+	bloomParams.warnStrippedCR(stats.StrippedCR)
+	bloomParams.warnSkippedInvalid(stats.Invalid)
+	bloomParams.warnRejectedValues(rejected)
+	bloomParams.reportSample(stats, reported)
+	bloomParams.reportStopped(stats, reported)
+	bloomParams.reportSummary(stats, reported, func() float64 { return estimatedFPRate(filter) })
+}
+
+// reportSample prints the estimated number of reported lines if the lines
+// checked were sampled.
+func (bloomParams BloomParams) reportSample(stats pipeline.Stats, reported int) {
+	if bloomParams.sampler == nil || bloomParams.stderr == nil {
+		return
+	}
+	e := pipeline.EstimateFromSample(stats.Lines, stats.Sampled, reported)
+	sampled := 0.0
+	if stats.Lines > 0 {
+		sampled = float64(stats.Sampled) / float64(stats.Lines)
+	}
+	fmt.Fprintf(bloomParams.stderr, "Sampled %d of %d lines read (%.2f%%), of which %d were reported.\n", stats.Sampled, stats.Lines, 100*sampled, reported)
+	fmt.Fprintf(bloomParams.stderr, "Estimated reported lines: %.0f (95%% confidence interval: %.0f to %.0f)\n", e.Matches, e.MatchesLow, e.MatchesHigh)
+	fmt.Fprintf(bloomParams.stderr, "Estimated match rate: %.2f%% (95%% confidence interval: %.2f%% to %.2f%%)\n", 100*e.Rate, 100*e.RateLow, 100*e.RateHigh)
+}
+
+// reportStopped prints the number of lines read and reported if the input
+// ended because check was interrupted or terminated.
+func (bloomParams BloomParams) reportStopped(stats pipeline.Stats, reported int) {
+	if !stats.Stopped || bloomParams.stderr == nil {
+		return
+	}
+	fmt.Fprintf(bloomParams.stderr, "Stopped after %d lines read, of which %d were reported.\n", stats.Lines, reported)
+}
+
+func printStats(path string, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	return writeStats(bloomParams.stdout, path, filter)
+}
+
+func writeStats(w io.Writer, path string, filter *bloom.BloomFilter) error {
+	stats := filter.Stats()
+	fmt.Fprintf(w, "File:\t\t\t%s\n", path)
+	fmt.Fprintf(w, "Capacity:\t\t%d\n", stats.Capacity)
+	if stats.ElementsEstimated {
+		fmt.Fprintf(w, "Elements present:\t%d (estimated)\n", stats.Elements)
+	} else {
+		fmt.Fprintf(w, "Elements present:\t%d\n", stats.Elements)
+	}
+	fmt.Fprintf(w, "FP probability:\t\t%.2e\n", stats.FalsePositiveProb)
+	fmt.Fprintf(w, "Bits:\t\t\t%d\n", stats.Bits)
+	fmt.Fprintf(w, "Hash functions:\t\t%d\n", stats.HashFuncs)
+	for _, warning := range stats.Warnings {
+		fmt.Fprintf(w, "Warning:\t\t%s\n", warning)
+	}
+	fmt.Fprintf(w, "Remaining capacity:\t%d (at FP probability %.2e)\n", stats.RemainingCapacity, stats.FalsePositiveProb)
+	fmt.Fprintf(w, "Data size:\t\t%d bytes\n", stats.DataSize)
+	if count, ok := filter.ExactCount(); ok {
+		fmt.Fprintf(w, "Distinct values:\t%d (exact, duplicates: %d)\n", count.Distinct, count.Duplicates)
+	}
+	if v, ok := filter.Metadata(bloom.MetadataKeyNormalization); ok {
+		fmt.Fprintf(w, "Normalization:\t\t%s\n", v)
+	}
+	if settings, ok, err := filter.RecordedInputSettings(); err == nil && ok && (settings.Split || len(settings.TupleFields) > 0 || settings.Decoding != "") {
+		fmt.Fprintf(w, "Input:\t\t\t%s\n", settings)
+	}
+	fmt.Fprintf(w, "Producer:\t\t%s\n", stats.Producer)
+	if comment := filter.Comment(); comment != "" {
+		fmt.Fprintf(w, "Comment:\t\t%s\n", comment)
+	}
+	if len(stats.JoinedProducers) > 0 {
+		fmt.Fprintf(w, "Joined producers:\t%s\n", strings.Join(stats.JoinedProducers, "; "))
+	}
+	if v, ok := filter.Metadata(bloom.MetadataKeyShard); ok {
+		fmt.Fprintf(w, "Shard:\t\t\t%s\n", v)
+	}
+	if v, ok := filter.Metadata(bloom.MetadataKeySampleFraction); ok {
+		fmt.Fprintf(w, "Sample fraction:\t%s\n", v)
+	}
+	if v, ok := filter.Metadata(bloom.MetadataKeyTombstones); ok {
+		tombstones, err := bloom.LoadFromBytes([]byte(v), false)
+		if err != nil {
+			return err
+		}
+		stats := tombstones.Stats()
+		fmt.Fprintf(w, "Fill:\t\t\t%.2f%%\n", 100*float64(filter.NumSetBits())/float64(filter.NumBits()))
+		fmt.Fprintf(w, "Tombstones:\t\t%d (capacity %d, FP probability %.2e, fill %.2f%%)\n",
+			stats.Elements, stats.Capacity, stats.FalsePositiveProb,
+			100*float64(tombstones.NumSetBits())/float64(tombstones.NumBits()))
+	}
+	issues := filter.Health()
+	if len(issues) == 0 {
+		fmt.Fprintf(w, "Health:\t\t\tOK\n")
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(w, "Health:\t\t\t%s\n", issue)
+	}
+	return nil
+}
+
+func checkAgainstShardedFilter(pattern string, shards int, bloomParams BloomParams) error {
+	filter, err := bloom.LoadShardedFilter(pattern, shards, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	for _, shard := range filter.Filters() {
+		if err = applyValueLimit(shard, &bloomParams, false); err != nil {
+			return err
+		}
+	}
+	// all shards are built with the same settings
+	if err = compareInputSettings(filter.Filters()[0], bloomParams); err != nil {
+		return err
+	}
+	return runCheck(func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+		checkValues(filter, input, output, bloomParams)
+	}, bloomParams)
+}
+
+func checkAgainstManifest(path string, strict bool, bloomParams BloomParams) error {
+	var opts []bloom.ManifestOption
+	if strict {
+		opts = append(opts, bloom.StrictManifest())
+	}
+	loaded, err := bloom.LoadManifest(path, opts...)
+	if err != nil {
+		return err
+	}
+	var filters []bloom.NamedFilter
+	for _, filter := range loaded {
+		if filter.Err != nil {
+			bloomParams.warnf("skipping filter %s: %s", filter.Name, filter.Err)
+			continue
+		}
+		if err = applyValueLimit(filter.Filter, &bloomParams, false); err != nil {
+			return err
+		}
+		filters = append(filters, filter)
+	}
+	if len(filters) == 0 {
+		return errors.New("No filter of the manifest could be loaded.")
+	}
+	return runCheck(func(input io.Reader, output io.Writer, bloomParams BloomParams) {
+		checkValuesByFilter(filters, input, output, bloomParams)
+	}, bloomParams)
+}
+
+// checkValuesByFilter checks the lines read from the input against each of
+// the filters and writes the lines to report for each filter to the output,
+// prefixed with the name of the filter and a tab.
+func checkValuesByFilter(filters []bloom.NamedFilter, input io.Reader, output io.Writer, bloomParams BloomParams) {
+	rejected := 0
+	prefix := ""
+	if bloomParams.interactive {
+		prefix = ">"
+	}
+	driver := bloomParams.decoded(bloomParams.throttled(pipeline.Driver{
+		Pipeline:        checkPipeline(bloomParams),
+		KeepCR:          bloomParams.keepCR,
+		StopAtEmptyLine: bloomParams.interactive,
+		Sampler:         bloomParams.sampler,
+		SourceFile:      bloomParams.source,
+	}))
+	check := func(value []byte) pipeline.CheckOutcome {
+		var outcome pipeline.CheckOutcome
+		for _, filter := range filters {
+			if valueRejected(filter.Filter, value, bloomParams) {
+				rejected++
+				continue
+			}
+			matched, form := checkForms(filter.Filter, value, bloomParams)
+			if matched {
+				outcome.Matched = true
+				outcome.MatchedFilters = append(outcome.MatchedFilters, filter.Name)
+			}
+			if bloomParams.printsOutcomes() {
+				if score := matchScore(filter.Filter, form, matched); score > outcome.Score {
+					outcome.Score = score
+				}
+			}
+		}
+		return outcome
+	}
+	reported := 0
+	stats, err := driver.CheckOutcomes(input, check, func(line string, outcomes []pipeline.CheckOutcome) error {
+		bloomParams.forwardMatches(outcomes)
+		if bloomParams.printsOutcomes() {
+			results := reportOutcomes(line, outcomes, bloomParams)
+			for _, result := range results {
+				fmt.Fprintf(output, "%s%s\n", prefix, result)
+			}
+			if len(results) > 0 {
+				reported++
+			}
+			return nil
+		}
+		values, _ := pipeline.Matches(outcomes)
+		matched := make([]bool, len(values))
+		lineReported := false
+		for _, filter := range filters {
+			for i, outcome := range outcomes {
+				matched[i] = containsString(outcome.MatchedFilters, filter.Name)
+			}
+			results := reportLine(line, values, matched, bloomParams)
+			for _, result := range results {
+				fmt.Fprintf(output, "%s%s\t%s\n", prefix, filter.Name, result)
+			}
+			lineReported = lineReported || len(results) > 0
+		}
+		if lineReported {
+			reported++
+		}
+		return nil
+	})
+	bloomParams.handleInputError(err)
+	bloomParams.warnStrippedCR(stats.StrippedCR)
+	bloomParams.warnSkippedInvalid(stats.Invalid)
+	bloomParams.warnRejectedValues(rejected)
+	bloomParams.reportSample(stats, reported)
+	bloomParams.reportStopped(stats, reported)
+	bloomParams.reportSummary(stats, reported, func() float64 { return anyFPRate(filters) })
+}
+
+// createOptions returns the options of the filters created by create.
+func (bloomParams BloomParams) createOptions() []bloom.Option {
+	opts := []bloom.Option{bloom.WithMaxHashFuncs(bloomParams.maxHashFuncs, bloomParams.strictHashFuncs)}
+	if bloomParams.exactCount {
+		opts = append(opts, bloom.WithExactCounting(bloomParams.exactCountMem))
+	}
+	return opts
+}
+
+// warnDimensions warns about unexpected dimensions of a new filter, e.g.
+// capped hash functions.
+func (bloomParams BloomParams) warnDimensions(filter *bloom.BloomFilter) {
+	for _, warning := range filter.Warnings() {
+		bloomParams.warnf("%s.", warning)
+	}
+}
+
+func createShardedFilter(pattern string, n uint64, p float64, shards int, bloomParams BloomParams) error {
+	filter, err := bloom.NewShardedFilter(n, p, shards, bloomParams.createOptions()...)
+	if err != nil {
+		return err
+	}
+	// all shards have the same dimensions
+	bloomParams.warnDimensions(filter.Filters()[0])
+	for _, shard := range filter.Filters() {
+		if err = applyValueLimit(shard, &bloomParams, true); err != nil {
+			return err
+		}
+		shard.RecordInputSettings(bloomParams.inputSettings())
+	}
+	readValuesIntoFilter(filter, bloomParams)
+	if err = bloomParams.failure(); err != nil {
+		return err
+	}
+	if err = bloomParams.writeDuplicateReport(); err != nil {
+		return err
+	}
+	if bloomParams.exactCount {
+		// every value is counted in a single shard only
+		var total bloom.ExactCount
+		for _, shard := range filter.Filters() {
+			count, err := shard.FinishExactCount()
+			if err != nil {
+				return err
+			}
+			total.Distinct += count.Distinct
+			total.Duplicates += count.Duplicates
+		}
+		fmt.Fprintf(bloomParams.stdout, "Distinct values: %d (duplicates: %d)\n", total.Distinct, total.Duplicates)
+	}
+	return filter.WriteShards(pattern, bloomParams.gzip, bloomParams.writeOptions()...)
+}
+
+func createFilter(path string, n uint64, p float64, bloomParams BloomParams) error {
+	opts := bloomParams.createOptions()
+	var filter *bloom.BloomFilter
+	var err error
+	if bloomParams.fromDir != "" {
+		filter, err = createFilterFromDir(n, p, opts, bloomParams)
+		if err != nil {
+			return err
+		}
+		bloomParams.warnDimensions(filter)
+	} else if bloomParams.from != "" {
+		if bloomParams.maxValueBytes > 0 {
+			opts = append(opts, bloom.WithMaxValueLength(bloomParams.maxValueBytes, bloomParams.maxValuePolicy))
+		}
+		var progress *progressReporter
+		var read int64
+		if bloomParams.progress {
+			total, ok, err := pipeline.InputSize([]string{bloomParams.from}, bloomParams.gzipRatio)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				total = 0
+			} else if n == 0 {
+				// the file is read twice to determine the capacity
+				total *= 2
+			}
+			progress = bloomParams.newProgressReporter(total, false)
+			opts = append(opts, bloom.WithReadProgress(func(bytes int64) {
+				read = bytes
+				progress.update(bytes, 0)
+			}))
+		}
+		var added uint64
+		filter, added, err = bloom.NewFilterFromTextFile(bloomParams.from, n, p, opts...)
+		if err != nil {
+			return err
+		}
+		bloomParams.warnDimensions(filter)
+		if progress != nil {
+			progress.finish(read, 0)
+		}
+		fmt.Fprintf(bloomParams.stdout, "Added %d values (capacity %d).\n", added, filter.MaxNumElements())
+	} else {
+		filter, err = bloom.New(n, p, opts...)
+		if err != nil {
+			return err
+		}
+		bloomParams.warnDimensions(filter)
+		if err = applyValueLimit(filter, &bloomParams, true); err != nil {
+			return err
+		}
+		filter.RecordInputSettings(bloomParams.inputSettings())
+		if bloomParams.autosaving() {
+			filter, err = startAutosave(filter, path, true, &bloomParams)
+			if err != nil {
+				return err
+			}
+		}
+		readValuesWithAlarm(filter, bloomParams)
+		if err = bloomParams.failure(); err != nil {
+			return err
+		}
+		if err = bloomParams.writeDuplicateReport(); err != nil {
+			return err
+		}
+	}
+	if bloomParams.exactCount {
+		count, err := filter.FinishExactCount()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(bloomParams.stdout, "Distinct values: %d (duplicates: %d)\n", count.Distinct, count.Duplicates)
+	}
+	if _, err = bloomParams.writeFilter(filter, path, bloomParams.createInputs()); err != nil {
+		return err
+	}
+	finishAutosave(path, bloomParams)
+	return nil
+}
+
+// createInputs returns the files create reads the values from, if any, as
+// recorded in the audit log.
+func (bloomParams BloomParams) createInputs() []string {
+	if bloomParams.from == "" {
+		return nil
+	}
+	return []string{bloomParams.from}
+}
+
+// createFilterFromDir creates a filter of the values of the files in the
+// directory tree given with --from-dir. Files that cannot be read are skipped
+// with a warning.
+func createFilterFromDir(n uint64, p float64, opts []bloom.Option, bloomParams BloomParams) (*bloom.BloomFilter, error) {
+	var valueFn bloom.DirTreeValueFunc
+	switch bloomParams.dirValue {
+	case "sha256":
+		valueFn = bloom.FileSHA256
+	case "name":
+		valueFn = bloom.FileBaseName
+	case "relpath":
+		valueFn = bloom.RelativePath(bloomParams.fromDir)
+	default:
+		return nil, fmt.Errorf("Invalid value %q, must be sha256, name or relpath.", bloomParams.dirValue)
+	}
+	if bloomParams.maxValueBytes > 0 {
+		opts = append(opts, bloom.WithMaxValueLength(bloomParams.maxValueBytes, bloomParams.maxValuePolicy))
+	}
+	filter, err := bloom.New(n, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := filter.AddFromDirTree(bloomParams.fromDir, valueFn, bloom.SkipDirTreeErrors(func(path string, err error) {
+		bloomParams.warnf("skipped %s: %s", path, err)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	bloomParams.warnRejectedValues(int(stats.Rejected))
+	fmt.Fprintf(bloomParams.stdout, "Added %d values from %d files (capacity %d).\n", stats.Values, stats.Files, filter.MaxNumElements())
+	bloomParams.warnCapacity(filter)
+	return filter, nil
+}
+
+func compareHashes(w io.Writer, config bloomtest.HashBenchConfig) error {
+	encoder := json.NewEncoder(w)
+	return bloomtest.CompareHashes(config, func(result bloomtest.HashBenchResult) error {
+		return encoder.Encode(result)
+	})
+}
+
+func printFormat(w io.Writer, version int, markdown bool) error {
+	spec, err := bloom.DescribeFormat(version)
+	if err != nil {
+		return err
+	}
+	if !markdown {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(spec)
+	}
+	size := func(field bloom.FormatField) string {
+		if field.Size < 0 {
+			return field.Length
+		}
+		return strconv.Itoa(field.Size)
+	}
+	offset := func(field bloom.FormatField) string {
+		if field.Offset < 0 {
+			return "follows"
+		}
+		return strconv.Itoa(field.Offset)
+	}
+	fmt.Fprintf(w, "# Bloom filter file format, version %d\n\n", spec.Version)
+	fmt.Fprintf(w, "Byte order: %s\n\n", spec.ByteOrder)
+	fmt.Fprintf(w, "| Field | Offset | Size | Type | Description |\n")
+	fmt.Fprintf(w, "|-------|--------|------|------|-------------|\n")
+	for _, field := range spec.Fields {
+		fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n", field.Name, offset(field), size(field), field.Type, field.Description)
+		for _, entry := range field.Entries {
+			fmt.Fprintf(w, "| `%s.%s` | %s | %s | %s | %s |\n", field.Name, entry.Name, offset(entry), size(entry), entry.Type, entry.Description)
+		}
+	}
+	fmt.Fprintf(w, "\nHash scheme %d (%s): %s\n\n", spec.HashScheme.ID, spec.HashScheme.Name, spec.HashScheme.Description)
+	fmt.Fprintf(w, "Checksum: %s\n", spec.Checksum)
+	return nil
+}
+
+func printLayout(w io.Writer, path string, asJSON bool) error {
+	layout, err := bloom.ReadLayoutFromFile(path)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(layout)
+	}
+	fmt.Fprintf(w, "Format version: %d\n", layout.Version)
+	fmt.Fprintf(w, "%-16s %12s %12s %12s\n", "Region", "Offset", "Length", "End")
+	for _, region := range layout.Regions {
+		fmt.Fprintf(w, "%-16s %12d %12d %12d\n", region.Name, region.Offset, region.Length, region.End())
+	}
+	return nil
+}
+
+// joinPolicy is how join merges the data and metadata of the filters, as
+// given with --data-policy, --data-separator and --metadata-policy.
+type joinPolicy struct {
+	data      string
+	separator string
+	metadata  string
+}
+
+// joinOptions returns the options of JoinWithOptions for the policy.
+func (policy joinPolicy) joinOptions() ([]bloom.JoinOption, error) {
+	var opts []bloom.JoinOption
+	switch policy.data {
+	case "receiver":
+		opts = append(opts, bloom.KeepReceiverData())
+	case "other":
+		opts = append(opts, bloom.KeepOtherData())
+	case "concat":
+		opts = append(opts, bloom.ConcatenateData([]byte(policy.separator)))
+	default:
+		return nil, fmt.Errorf("Invalid value %q for --data-policy, must be receiver, other or concat.", policy.data)
+	}
+	switch policy.metadata {
+	case "receiver":
+		opts = append(opts, bloom.WithMetadataConflicts(bloom.ReceiverWins))
+	case "other":
+		opts = append(opts, bloom.WithMetadataConflicts(bloom.OtherWins))
+	case "error":
+		opts = append(opts, bloom.WithMetadataConflicts(bloom.ErrorOnConflict))
+	default:
+		return nil, fmt.Errorf("Invalid value %q for --metadata-policy, must be receiver, other or error.", policy.metadata)
+	}
+	return opts, nil
+}
+
+// reportJoin prints how the data and metadata of the second filter were
+// merged into the first.
+func (policy joinPolicy) reportJoin(w io.Writer, path, pathToAdd string, report bloom.JoinReport, otherData int, data int) {
+	if otherData > 0 {
+		switch policy.data {
+		case "receiver":
+			fmt.Fprintf(w, "Kept the data of %s, dropped that of %s (%d bytes).\n", path, pathToAdd, otherData)
+		case "other":
+			fmt.Fprintf(w, "Replaced the data of %s with that of %s (%d bytes).\n", path, pathToAdd, otherData)
+		case "concat":
+			fmt.Fprintf(w, "Concatenated the data of %s and %s (%d bytes).\n", path, pathToAdd, data)
+		}
+	}
+	if len(report.AddedKeys) > 0 {
+		fmt.Fprintf(w, "Copied metadata from %s: %s.\n", pathToAdd, strings.Join(report.AddedKeys, ", "))
+	}
+	if len(report.Conflicts) > 0 {
+		winner := path
+		if policy.metadata == "other" {
+			winner = pathToAdd
+		}
+		fmt.Fprintf(w, "Kept the metadata of %s for conflicting keys: %s.\n", winner, strings.Join(report.Conflicts, ", "))
+	}
+}
+
+func joinFilters(path string, pathToAdd string, estimate bool, policy joinPolicy, bloomParams BloomParams) error {
+	opts, err := policy.joinOptions()
+	if err != nil {
+		return err
+	}
+	unlock, err := bloomParams.lockFilter(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	filter2, err := loadFilter(pathToAdd, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	if estimate {
+		opts = append(opts, bloom.WithJoinCount(bloom.EstimateCount))
+	} else {
+		if filter.NumElements()+filter2.NumElements() < filter.NumElements() {
+			bloomParams.warnf("the number of elements exceeds %d and is recorded as estimated", uint64(math.MaxUint64))
+		}
+		opts = append(opts, bloom.WithJoinCount(bloom.SaturateCounts))
+	}
+	report, err := filter.JoinWithOptions(filter2, opts...)
+	if err != nil {
+		return err
+	}
+	if _, err = bloomParams.writeFilter(filter, path, []string{path, pathToAdd}); err != nil {
+		return err
+	}
+	policy.reportJoin(bloomParams.stdout, path, pathToAdd, report, len(filter2.GetData()), len(filter.GetData()))
+	return nil
+}
+
+func chunkFilter(path string, dir string, chunkSize int64, bloomParams BloomParams) error {
+	if bloomParams.dryRun {
+		return errors.New("--dry-run cannot be used with chunk.")
+	}
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	manifest, err := filter.WriteChunks(dir, chunkSize)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(bloomParams.stdout, "Wrote %d chunks (%d bytes, SHA256 %s)\n", len(manifest.Chunks), manifest.TotalSize, manifest.SHA256)
+	return nil
+}
+
+func unchunkFilter(dir string, path string, bloomParams BloomParams) error {
+	filter, err := bloom.LoadFromChunkDir(dir)
+	if err != nil {
+		return err
+	}
+	_, err = bloomParams.writeFilter(filter, path, nil)
+	return err
+}
+
+// readExclusions reads the values to exclude from a file with one value per
+// line.
+func readExclusions(path string, bloomParams BloomParams) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var exclusions [][]byte
+	scanner := pipeline.NewScanner(f, bloomParams.keepCR)
+	for scanner.Scan() {
+		exclusions = append(exclusions, []byte(scanner.Text()))
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	bloomParams.warnStrippedCR(scanner.StrippedCR())
+	return exclusions, nil
+}
+
+func exportFilter(path string, exportPath string, excludePath string, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	var opts []bloom.WriteOption
+	inputs := []string{path}
+	if excludePath != "" {
+		exclusions, err := readExclusions(excludePath, bloomParams)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, bloom.Exclusions(exclusions))
+		inputs = append(inputs, excludePath)
+	}
+	_, err = bloomParams.writeFilter(filter, exportPath, inputs, opts...)
+	return err
+}
+
+func sampleFilter(path string, samplePath string, fraction float64, seed int64, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	sample, err := filter.SampleBits(fraction, seed)
+	if err != nil {
+		return err
+	}
+	_, err = bloomParams.writeFilter(sample, samplePath, []string{path})
+	return err
+}
+
+func rebuildFilter(path string, rebuiltPath string, valuesPath string, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	input, err := os.Open(valuesPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	scanner := pipeline.NewScanner(input, bloomParams.keepCR)
+	rebuilt, err := filter.RebuildWithValues(scanner)
+	if err != nil {
+		return err
+	}
+	bloomParams.warnStrippedCR(scanner.StrippedCR())
+	bloomParams.warnCapacity(rebuilt)
+	_, err = bloomParams.writeFilter(rebuilt, rebuiltPath, []string{path, valuesPath})
+	return err
+}
+
+// compactProgressInterval is the number of values after which compact
+// reports its progress.
+const compactProgressInterval = 1000000
+
+// progressIterator reports the number of values read from the wrapped
+// iterator to the standard error stream.
+type progressIterator struct {
+	bloom.ValueIterator
+	streams
+	values uint64
+}
+
+func (it *progressIterator) Scan() bool {
+	if !it.ValueIterator.Scan() {
+		return false
+	}
+	it.values++
+	if it.values%compactProgressInterval == 0 && it.stderr != nil {
+		fmt.Fprintf(it.stderr, "Added %d values\n", it.values)
+	}
+	return true
+}
+
+// compactFilter rebuilds the filter with the given capacity and FP
+// probability (that of the filter if 0) and reports the sizes and FP
+// probabilities of both filters.
+func compactFilter(path string, compactedPath string, valuesPath string, n uint64, p float64, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	input, err := os.Open(valuesPath)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+	if p == 0 {
+		p = filter.FalsePositiveProb()
+	}
+	scanner := pipeline.NewScanner(input, bloomParams.keepCR)
+	values := &progressIterator{ValueIterator: scanner, streams: bloomParams.streams}
+	compacted, err := filter.Rebuild(values, n, p)
+	if errors.Is(err, bloom.ErrValueNotInFilter) {
+		return fmt.Errorf("The values are not those of the filter: %s", err)
+	} else if err != nil {
+		return err
+	}
+	bloomParams.warnStrippedCR(scanner.StrippedCR())
+	bloomParams.warnCapacity(compacted)
+	written, err := bloomParams.writeFilter(compacted, compactedPath, []string{path, valuesPath})
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name   string
+		size   int64
+		filter *bloom.BloomFilter
+	}{{"Original", info.Size(), filter}, {"Compacted", written.Size, compacted}} {
+		fmt.Fprintf(bloomParams.stdout, "%s:\tcapacity %d, %d bytes, FP probability %.2e (estimated %.2e)\n",
+			f.name, f.filter.MaxNumElements(), f.size, f.filter.FalsePositiveProb(), f.filter.EstimatedFalsePositiveProb())
+	}
+	fmt.Fprintf(bloomParams.stdout, "Values:\t\t%d\n", values.values)
+	return nil
+}
+
+func extractFilter(documentPath string, jsonPath string, path string, bloomParams BloomParams) error {
+	document, err := os.Open(documentPath)
+	if err != nil {
+		return err
+	}
+	defer document.Close()
+	filter, err := bloom.ExtractFromJSON(document, jsonPath)
+	if err != nil {
+		return err
+	}
+	_, err = bloomParams.writeFilter(filter, path, []string{documentPath})
+	return err
+}
+
+func fingerprintFilter(path string, inputPaths []string, format bloom.FingerprintFormat, bloomParams BloomParams) error {
+	filter, err := loadCheckFilter(path, bloomParams)
+	if err != nil {
+		return err
+	}
+	if err = applyValueLimit(filter, &bloomParams, false); err != nil {
+		return err
+	}
+	output := bufio.NewWriter(bloomParams.stdout)
+	if len(inputPaths) == 0 {
+		if readStdin(bloomParams) {
+			if err = fingerprintValues(filter, bloomParams.stdin, output, format, bloomParams); err != nil {
+				return err
+			}
+		}
+	}
+	for _, inputPath := range inputPaths {
+		input, err := os.Open(inputPath)
+		if err != nil {
+			return err
+		}
+		err = fingerprintValues(filter, input, output, format, bloomParams)
+		input.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return output.Flush()
+}
+
+// fingerprintValues writes the fingerprints of the values read from the input
+// (selected like when inserting) to the output in the given format. Values that
+// are too long to be checked are skipped.
+func fingerprintValues(filter *bloom.BloomFilter, input io.Reader, output io.Writer, format bloom.FingerprintFormat, bloomParams BloomParams) error {
+	rejected := 0
+	var writeErr error
+	fingerprint := func(value []byte) {
+		if valueRejected(filter, value, bloomParams) {
+			rejected++
+			return
+		}
+		if writeErr == nil {
+			writeErr = filter.WriteFingerprints(output, [][]byte{value}, format)
+		}
+	}
+	driver := pipeline.Driver{
+		Pipeline:        insertPipeline(bloomParams),
+		KeepCR:          bloomParams.keepCR,
+		StopAtEmptyLine: bloomParams.interactive,
+	}
+	stats, err := driver.Add(input, fingerprint)
+	if writeErr != nil {
+		return writeErr
+	}
+	bloomParams.warnInputError(err)
+	bloomParams.warnStrippedCR(stats.StrippedCR)
+	bloomParams.warnRejectedValues(rejected)
+	return nil
+}
+
+func parseFieldIndexes(s string) ([]int, error) {
+	fields := strings.Split(s, ",")
+	fieldNumbers := make([]int, len(fields))
+	for i, field := range fields {
+		num, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
+		}
+		fieldNumbers[i] = num
+	}
+	return fieldNumbers, nil
+}
+
+func parseBloomParams(c *cli.Context, s streams) (BloomParams, error) {
+	bloomParams := BloomParams{streams: s, producer: "bloom " + c.App.Version}
+	config, err := resolveGlobalFlags(c)
+	if err != nil {
+		return bloomParams, err
+	}
+	flagString := config.String
+	flagBool := func(name string) bool {
+		b, parseErr := config.Bool(name)
+		if parseErr != nil && err == nil {
+			err = parseErr
+		}
+		return b
+	}
+	bloomParams.gzip = flagBool("gzip")
+	bloomParams.interactive = flagBool("interactive")
+	bloomParams.split = flagBool("split")
+	bloomParams.delimiter = flagString("delimiter")
+	bloomParams.printEachMatch = flagBool("each")
+	bloomParams.keepCR = flagBool("keep-cr")
+	bloomParams.forceStdin = flagBool("stdin")
+	bloomParams.dryRun = flagBool("dry-run")
+	bloomParams.logFile = flagString("log-file")
+	bloomParams.recordProducer = flagBool("record-producer")
+	bloomParams.command = c.Command.Name
+	if err != nil {
+		return bloomParams, err
+	}
+	if flagString("fields") != "" {
+		bloomParams.fields, err = parseFieldIndexes(flagString("fields"))
+		if err != nil {
+			return bloomParams, err
+		}
+	}
+	if flagString("tuple-fields") != "" {
+		if len(bloomParams.fields) > 0 {
+			return bloomParams, errors.New("--tuple-fields cannot be used with --fields.")
+		}
+		if bloomParams.printEachMatch {
+			return bloomParams, errors.New("--tuple-fields cannot be used with --each.")
+		}
+		bloomParams.tupleFields, err = parseFieldIndexes(flagString("tuple-fields"))
+		if err != nil {
+			return bloomParams, err
+		}
+	}
+	if flagString("print-fields") != "" {
+		bloomParams.printFields, err = parseFieldIndexes(flagString("print-fields"))
+		if err != nil {
+			return bloomParams, err
+		}
+		//if printFields is set we also set printEachMatch
+		if len(bloomParams.printFields) > 0 {
+			bloomParams.printEachMatch = false
+		}
+	}
+	return bloomParams, nil
+}
+
+// Run runs the command line tool with the given arguments, the first of which
+// is the name of the program, reading values from stdin and writing output to
+// stdout and warnings to stderr. The process is not exited and signals are not
+// handled.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return newApp(streams{stdin: stdin, stdout: stdout, stderr: stderr, args: programArgs(args)}).Run(args)
+}
+
+// Main runs the command line tool like Run, additionally writing the buffered
+// output of check and exiting the process when it is interrupted or
+// terminated. Errors are written to stderr. The exit code of the process is
+// returned.
+func Main(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	err := newApp(streams{stdin: stdin, stdout: stdout, stderr: stderr, exit: os.Exit, args: programArgs(args)}).Run(args)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %s \n", err)
+		return -1
+	}
+	return 0
+}
+
+// programArgs returns the arguments without the name of the program.
+func programArgs(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	return args[1:]
+}
+
+// newApp returns the command line tool using the given streams.
+func newApp(s streams) *cli.App {
+	app := cli.NewApp()
+	app.Name = "Bloom Filter"
+	app.Usage = "Utility to work with bloom filters"
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "gzip, gz",
+			Usage: "compress bloom file with gzip",
+		},
+		cli.BoolFlag{
+			Name:  "interactive, i",
+			Usage: "interactively add values to the filter",
+		},
+		cli.BoolFlag{
+			Name:  "split, s",
+			Usage: "split the input string",
+		},
+		cli.BoolFlag{
+			Name:  "each, e",
+			Usage: "print each match of a split string individually",
+		},
+		cli.BoolFlag{
+			Name:  "keep-cr",
+			Usage: "keep trailing carriage returns (CR) of input lines instead of stripping them",
+		},
+		cli.BoolFlag{
+			Name:  "stdin",
+			Usage: "read values from standard input even if it appears to be a terminal",
+		},
+		cli.StringFlag{
+			Name:  "delimiter, d",
+			Value: ",",
+			Usage: "delimiter to use for splitting",
+		},
+		cli.StringFlag{
+			Name:  "fields, f",
+			Value: "",
+			Usage: "fields of split output to use in filter (a single number or a comma-separated list of numbers, zero-indexed)",
+		},
+		cli.StringFlag{
+			Name:  "tuple-fields",
+			Value: "",
+			Usage: "fields of split output to combine, in the given order, into a single composite value (see EncodeTuple) that is used in the filter instead of the individual fields (a comma-separated list of numbers, zero-indexed)",
+		},
+		cli.StringFlag{
+			Name:  "print-fields, pf",
+			Value: "",
+			Usage: "fields of split output to print for a successful match (a single number or a comma-separated list of numbers, zero-indexed).",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "read the inputs and compute the filters to write, but only print what would be written (path, number of elements, SHA-256 digest and size) instead of writing anything",
+		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Value: "",
+			Usage: "append a JSON line recording the time, command, arguments, input digests and output digest to the given file for each filter written",
+		},
+		cli.BoolFlag{
+			Name:  "record-producer",
+			Usage: "record the version of the tool as the producer of the filters written, which stores them in version 2 of the file format; filters written in version 2 anyway always record it",
+		},
+		cli.StringFlag{
+			Name:  "profile, P",
+			Value: "",
+			Usage: "apply the global flags stored in the named profile (explicitly given flags take precedence)",
+		},
+		cli.StringFlag{
+			Name:  "profiles-file",
+			Value: defaultProfilesFile(),
+			Usage: "file to store profiles in",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:    "create",
+			Aliases: []string{"cr"},
+			Flags: append([]cli.Flag{
+				cli.Float64Flag{Name: "p", Value: 0.01, Usage: "The desired false positive probability."},
+				cli.Uint64Flag{Name: "n", Value: 10000, Usage: "The desired capacity (0 to derive it from the file given with --from)."},
+				cli.StringFlag{Name: "from", Usage: "Read the values from the given text file, which may be gzip-compressed, instead of from standard input."},
+				cli.StringFlag{Name: "from-dir", Usage: "Add a value for each file in the given directory tree instead of reading values from standard input."},
+				cli.StringFlag{Name: "value", Value: "sha256", Usage: "The value added for each file with --from-dir: 'sha256' (of the contents, in hex), 'name' (the base name) or 'relpath' (the path relative to the directory)."},
+				cli.IntFlag{Name: "shards", Usage: "Distribute the values across the given number of filters, each with a capacity of n/shards, stored in the files named by the given pattern (e.g. 'out-%d.bloom')."},
+				cli.BoolFlag{Name: "exact-count", Usage: "Count the distinct values exactly, print the count and store it with the filter."},
+				cli.Int64Flag{Name: "exact-count-memory", Value: bloom.DefaultExactCountingMemory, Usage: "The memory in bytes for exact counting before spilling to temporary files."},
+				cli.Uint64Flag{Name: "max-hash-funcs", Value: bloom.DefaultMaxHashFuncs, Usage: "The maximum number of hash functions (0 for no maximum). Filters with a very small p that need more use the maximum with more bits, with a warning."},
+				cli.BoolFlag{Name: "strict-hash-funcs", Usage: "Fail instead of capping the number of hash functions at --max-hash-funcs."},
+				cli.StringFlag{Name: "comment", Usage: "Describe the filter at the beginning of the file with a line of text, followed by the given comment (at most 255 bytes in total)."},
+			}, append(append(append(append(append(valueLimitFlags, autosaveFlags...), throttleFlags...), duplicateFlags...), decodeFlags...), progressFlags...)...),
+			Usage: "Create a new Bloom filter and store it in the given filename.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				bloomParams.exactCount = c.Bool("exact-count")
+				bloomParams.exactCountMem = c.Int64("exact-count-memory")
+				bloomParams.maxHashFuncs = c.Uint64("max-hash-funcs")
+				bloomParams.strictHashFuncs = c.Bool("strict-hash-funcs")
+				bloomParams.from = c.String("from")
+				bloomParams.fromDir = c.String("from-dir")
+				bloomParams.dirValue = c.String("value")
+				if c.IsSet("comment") {
+					comment := c.String("comment")
+					bloomParams.comment = &comment
+				}
+				if err = parseAutosaveFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseThrottleFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseDecodeFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseProgressFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				if bloomParams.from != "" && bloomParams.fromDir != "" {
+					return errors.New("--from and --from-dir cannot be used together.")
+				}
+				if bloomParams.progress && bloomParams.fromDir != "" {
+					return errors.New("--progress cannot be used with --from-dir.")
+				}
+				if bloomParams.dryRun && c.Int("shards") > 0 {
+					return errors.New("--dry-run cannot be used with --shards.")
+				}
+				if bloomParams.fromDir != "" && (bloomParams.split || c.Int("shards") > 0) {
+					return errors.New("Values added with --from-dir cannot be split or sharded.")
+				}
+				if bloomParams.autosaving() && (bloomParams.from != "" || bloomParams.fromDir != "" || c.Int("shards") > 0) {
+					return errors.New("Snapshots can only be written for a single filter created from standard input.")
+				}
+				if bloomParams.resume && bloomParams.exactCount {
+					return errors.New("--exact-count cannot be used with --resume.")
+				}
+				if (bloomParams.limiter != nil || bloomParams.niceIO) && (bloomParams.from != "" || bloomParams.fromDir != "") {
+					return errors.New("--max-rate and --nice-io only apply to values read from standard input.")
+				}
+				if c.String("report-duplicates") != "" && (bloomParams.from != "" || bloomParams.fromDir != "") {
+					return errors.New("--report-duplicates only applies to values read from standard input.")
+				}
+				if bloomParams.decoding != pipeline.NoEncoding && (bloomParams.from != "" || bloomParams.fromDir != "") {
+					return errors.New("--decode only applies to values read from standard input.")
+				}
+				if bloomParams.from != "" && bloomParams.split {
+					return errors.New("Values read with --from cannot be split.")
+				}
+				if bloomParams.from == "" && c.Uint64("n") == 0 {
+					return errors.New("n can only be 0 with --from.")
+				}
+				shards := c.Int("shards")
+				if shards < 0 {
+					return errors.New("The number of shards cannot be negative.")
+				}
+				if shards > 0 && bloomParams.from != "" {
+					return errors.New("Values read with --from cannot be sharded.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				n := c.Uint64("n")
+				p := c.Float64("p")
+				if n < 0 {
+					return errors.New("n cannot be negative.")
+				}
+				if p < 0 || p > 1 {
+					return errors.New("p must be between 0 and 1.")
+				}
+				if err = parseDuplicateFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if shards > 0 {
+					return createShardedFilter(path, n, p, shards, bloomParams)
+				}
+				return createFilter(path, n, p, bloomParams)
+			},
+		},
+		{
+			Name:    "insert",
+			Aliases: []string{"i"},
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{Name: "quiet, q", Usage: "Do not print the stats of the filter before inserting."},
+				cli.BoolFlag{Name: "force", Usage: "Insert even if the settings of the filter conflict with the given flags."},
+				cli.StringFlag{Name: "tag", Usage: "Attribute the values to the given source (e.g. the name of a feed), which check reports for matching values with --tags or --json."},
+				cli.Uint64Flag{Name: "tag-capacity", Usage: "The capacity of the filter of a new tag given with --tag (by default a tenth of the capacity of the filter, at most what fits into its metadata)."},
+				cli.IntFlag{Name: "dedup-window", Usage: "Skip adding the values among the given number of most recently added distinct values, which leaves the filter unchanged but saves time for input repeating values in bursts."},
+			}, append(append(append(append(append(append(valueLimitFlags, autosaveFlags...), throttleFlags...), duplicateFlags...), decodeFlags...), lockFlags...), progressFlags...)...),
+			Usage: "Inserts new values into an existing Bloom filter.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				bloomParams.quiet = c.Bool("quiet")
+				bloomParams.force = c.Bool("force")
+				bloomParams.tag = c.String("tag")
+				bloomParams.tagCapacity = c.Uint64("tag-capacity")
+				bloomParams.dedupWindow = c.Int("dedup-window")
+				if bloomParams.dedupWindow < 0 {
+					return errors.New("--dedup-window cannot be negative.")
+				}
+				if bloomParams.tagCapacity > 0 && bloomParams.tag == "" {
+					return errors.New("--tag-capacity requires --tag.")
+				}
+				if err = parseAutosaveFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if bloomParams.tag != "" && bloomParams.autosaving() {
+					return errors.New("--tag cannot be used with autosaving.")
+				}
+				if err = parseThrottleFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseDecodeFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseProgressFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				if err = parseDuplicateFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if bloomParams.duplicates != nil && bloomParams.dedupWindow > 0 {
+					return errors.New("--dedup-window cannot be used with --report-duplicates, which counts the values skipped.")
+				}
+				if err = parseLockFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				return insertIntoFilter(path, bloomParams)
+			},
+		},
+		{
+			Name:    "join",
+			Aliases: []string{"j", "merge", "m"},
+			Flags: append([]cli.Flag{
+				cli.BoolFlag{Name: "estimate", Usage: "Estimate the number of elements from the joined bits instead of summing the counts (for overlapping filters)."},
+				cli.StringFlag{Name: "data-policy", Value: "receiver", Usage: "How to merge the data of the filters: keep that of the first ('receiver'), replace it with that of the second ('other') or concatenate both ('concat')."},
+				cli.StringFlag{Name: "data-separator", Value: "\n", Usage: "The separator between the data of the filters with '--data-policy concat'."},
+				cli.StringFlag{Name: "metadata-policy", Value: "receiver", Usage: "Which value to keep for metadata keys that the filters have with different values: that of the first ('receiver') or the second filter ('other'), or fail without joining ('error'). Keys only the second filter has are copied."},
+			}, lockFlags...),
+			Usage: "Joins two Bloom filters into one.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("Two filenames are required.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path := c.Args().First()
+				if path == "" {
+					return errors.New("No first filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				pathToAdd := c.Args().Get(1)
+				if pathToAdd == "" {
+					return errors.New("No second filename given.")
+				}
+				pathToAdd, err = filepath.Abs(pathToAdd)
+				if err != nil {
+					return err
+				}
+				if err = parseLockFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if c.IsSet("data-separator") && c.String("data-policy") != "concat" {
+					return errors.New("--data-separator requires '--data-policy concat'.")
+				}
+				policy := joinPolicy{
+					data:      c.String("data-policy"),
+					separator: c.String("data-separator"),
+					metadata:  c.String("metadata-policy"),
+				}
+				return joinFilters(path, pathToAdd, c.Bool("estimate"), policy, bloomParams)
+			},
+		},
+		{
+			Name:  "merge-daemon",
+			Flags: mergeDaemonFlags,
+			Usage: "Merges the filters arriving in a spool directory into an output filter, moving them to its 'archive' subdirectory, or to 'failed' if they cannot be merged.",
+			Action: func(c *cli.Context) error {
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseLockFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if c.String("spool") == "" || c.String("output") == "" {
+					return errors.New("--spool and --output are required.")
+				}
+				if c.Duration("interval") <= 0 {
+					return errors.New("--interval must be positive.")
+				}
+				dir, err := filepath.Abs(c.String("spool"))
+				if err != nil {
+					return err
+				}
+				output, err := filepath.Abs(c.String("output"))
+				if err != nil {
+					return err
+				}
+				return mergeSpool(dir, c.String("pattern"), output, c.Duration("interval"), c.Bool("once"), bloomParams)
+			},
+		},
+		{
+			Name:    "check",
+			Aliases: []string{"c"},
+			Flags: append([]cli.Flag{
+				cli.StringFlag{Name: "match", Value: "any", Usage: "Report a split line if 'any' or 'all' of its selected fields match."},
+				cli.BoolFlag{Name: "invert-match", Usage: "Report lines that would not be reported otherwise (with --each: the non-matching field values)."},
+				cli.StringFlag{Name: "sharded", Usage: "Check against the filters created with 'create --shards', named by the given pattern (e.g. 'out-%d.bloom'), instead of a single filter."},
+				cli.IntFlag{Name: "shards", Usage: "The number of shards of the filter given with --sharded."},
+				cli.StringFlag{Name: "manifest", Usage: "Check against the filters listed in the given JSON manifest file instead of a single filter, prefixing each reported line with the name of the matching filter."},
+				cli.BoolFlag{Name: "strict-manifest", Usage: "Fail if any filter listed in the manifest cannot be loaded or verified, instead of skipping it."},
+				cli.BoolFlag{Name: "line-buffered", Usage: "Write each reported line immediately instead of buffering the output (always the case if it is a terminal)."},
+				cli.StringSliceFlag{Name: "input", Usage: "Read the values from the given file (e.g. a named pipe) instead of standard input (repeatable). All inputs are read concurrently, and the reported lines are prefixed with the name of the file and a tab."},
+				cli.StringFlag{Name: "output-template", Usage: "Write the lines reported for each input given with --input to the file named by the template, in which {name} is replaced by the name of the input (e.g. 'hits-{name}.txt'), instead of to standard output."},
+				cli.BoolFlag{Name: "follow", Usage: "Wait for more data at the end of the inputs given with --input, like 'tail -f' (e.g. for named pipes whose writers restart), until interrupted."},
+				cli.BoolFlag{Name: "unique", Usage: "Report each distinct line only once."},
+				cli.IntFlag{Name: "unique-max", Value: defaultUniqueMax, Usage: "The number of distinct lines tracked exactly with --unique, beyond which duplicates are removed approximately."},
+				cli.BoolFlag{Name: "count", Usage: "With --unique, report each distinct line once the input ends, sorted and prefixed with the number of times it was reported, like 'sort | uniq -c'."},
+				cli.Float64Flag{Name: "sample-rate", Usage: "Check only a random sample of the lines, each with the given probability (e.g. 0.01), and print the estimated number of reported lines of the whole input."},
+				cli.DurationFlag{Name: "sample-duration", Usage: "Stop reading after the given duration (e.g. 5m) and print the estimated number of reported lines, like --sample-rate."},
+				cli.Int64Flag{Name: "sample-seed", Usage: "The seed for choosing the lines with --sample-rate (random by default)."},
+				cli.BoolFlag{Name: "strict-settings", Usage: "Fail instead of warning if the split, delimiter and tuple field settings differ from those recorded when the filter was built."},
+				cli.BoolFlag{Name: "ignore-recorded-settings", Usage: "Do not compare the split, delimiter and tuple field settings with those recorded when the filter was built."},
+				cli.StringFlag{Name: "encode", Usage: "Encode the values printed with --each, --json or --template in the given encoding ('hex' or 'base64'), e.g. binary values decoded with --decode (which are encoded like the input by default)."},
+				cli.BoolFlag{Name: "json", Usage: "Print the outcome of checking each value of the reported lines as a JSON object (with the value, input, line number, match, matching filters and match score), one per line."},
+				cli.StringFlag{Name: "template", Usage: "Print the outcome of checking each value of the reported lines rendered through the given Go text/template (e.g. '{{.File}}:{{.Line}} {{.Value}} matched={{.Filters}}'), with the fields Value, File, Line, Text (the whole line), Matched, Filters, Tags and Score and the functions json, csv and join, one per line."},
+				cli.StringFlag{Name: "template-file", Usage: "Like --template, with the template read from the given file."},
+				cli.BoolFlag{Name: "tags", Usage: "Prefix each reported line with the comma-separated tags of its matching values ('-' if none) and a tab, for filters with values inserted with --tag (with --each: the tags of each value)."},
+				cli.StringFlag{Name: "domain-forms", Value: "literal", Usage: "The forms of the values checked: 'literal' (as read) or 'both' to also check them as domain names in lowercase without a trailing dot, with their labels in Unicode and in Punycode ('xn--'), so that internationalized names match whichever form the filter was built from."},
+				cli.BoolFlag{Name: "summary", Usage: "Print the number of lines checked and reported once the input ends, with the estimated FP rate of the filter, the expected number of false positives among the reported lines and the estimated number of true matches (as JSON with --json)."},
+			}, append(append(append(valueLimitFlags, throttleFlags...), decodeFlags...), webhookFlags...)...),
+			Usage: "Checks values against an existing Bloom filter.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				if c.String("sharded") != "" {
+					path = c.String("sharded")
+					if c.Int("shards") < 1 {
+						return errors.New("The number of shards must be given with --shards.")
+					}
+				}
+				if c.String("manifest") != "" {
+					if c.String("sharded") != "" {
+						return errors.New("--manifest cannot be used with --sharded.")
+					}
+					path = c.String("manifest")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				switch c.String("match") {
+				case "any":
+				case "all":
+					bloomParams.matchAll = true
+				default:
+					return errors.New("--match must be 'any' or 'all'.")
+				}
+				bloomParams.invertMatch = c.Bool("invert-match")
+				bloomParams.summary = c.Bool("summary")
+				if bloomParams.summary && bloomParams.invertMatch {
+					return errors.New("--summary cannot be used with --invert-match.")
+				}
+				bloomParams.lineBuffered = c.Bool("line-buffered")
+				bloomParams.inputs = c.StringSlice("input")
+				bloomParams.outputTemplate = c.String("output-template")
+				bloomParams.follow = c.Bool("follow")
+				if len(bloomParams.inputs) == 0 && (bloomParams.outputTemplate != "" || bloomParams.follow) {
+					return errors.New("--output-template and --follow require --input.")
+				}
+				if len(bloomParams.inputs) > 0 && bloomParams.interactive {
+					return errors.New("--input cannot be used with --interactive.")
+				}
+				bloomParams.unique = c.Bool("unique")
+				bloomParams.uniqueMax = c.Int("unique-max")
+				bloomParams.count = c.Bool("count")
+				if bloomParams.count && !bloomParams.unique {
+					return errors.New("--count requires --unique.")
+				}
+				if bloomParams.unique && len(bloomParams.inputs) > 0 {
+					return errors.New("--unique cannot be used with --input.")
+				}
+				if bloomParams.uniqueMax < 1 {
+					return errors.New("--unique-max must be positive.")
+				}
+				if c.IsSet("sample-rate") || c.IsSet("sample-duration") {
+					if len(bloomParams.inputs) > 0 {
+						return errors.New("--sample-rate and --sample-duration cannot be used with --input.")
+					}
+					rate := 1.0
+					if c.IsSet("sample-rate") {
+						rate = c.Float64("sample-rate")
+					}
+					seed := time.Now().UnixNano()
+					if c.IsSet("sample-seed") {
+						seed = c.Int64("sample-seed")
+					}
+					if bloomParams.sampler, err = pipeline.NewSampler(rate, c.Duration("sample-duration"), seed); err != nil {
+						return err
+					}
+				}
+				if err = parseThrottleFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseDecodeFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseWebhookFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				bloomParams.json = c.Bool("json")
+				if bloomParams.json && len(bloomParams.printFields) > 0 {
+					return errors.New("--json cannot be used with --print-fields.")
+				}
+				if bloomParams.template, err = parseTemplateFlags(c); err != nil {
+					return err
+				}
+				if bloomParams.template != nil && (bloomParams.json || len(bloomParams.printFields) > 0) {
+					return errors.New("--template cannot be used with --json or --print-fields.")
+				}
+				bloomParams.showTags = c.Bool("tags")
+				if bloomParams.showTags && bloomParams.printsOutcomes() {
+					return errors.New("--tags cannot be used with --json or --template, which include the tags.")
+				}
+				if bloomParams.showTags && (c.String("manifest") != "" || c.String("sharded") != "") {
+					return errors.New("--tags cannot be used with --manifest or --sharded.")
+				}
+				switch c.String("domain-forms") {
+				case "literal":
+				case "both":
+					bloomParams.domainForms = true
+				default:
+					return errors.New("--domain-forms must be 'literal' or 'both'.")
+				}
+				if v := c.String("encode"); v != "" {
+					if !bloomParams.printEachMatch && !bloomParams.printsOutcomes() && bloomParams.webhook == nil {
+						return errors.New("--encode only applies to the values printed with --each, --json or --template or posted with --webhook-url.")
+					}
+					if bloomParams.encoding, err = pipeline.ParseEncoding(v); err != nil {
+						return fmt.Errorf("Invalid value for --encode: %s", err)
+					}
+				} else if bloomParams.printEachMatch || bloomParams.printsOutcomes() || bloomParams.webhook != nil {
+					bloomParams.encoding = bloomParams.decoding
+				}
+				bloomParams.strictSettings = c.Bool("strict-settings")
+				bloomParams.ignoreSettings = c.Bool("ignore-recorded-settings")
+				if bloomParams.strictSettings && bloomParams.ignoreSettings {
+					return errors.New("--strict-settings cannot be used with --ignore-recorded-settings.")
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				if c.String("manifest") != "" {
+					return checkAgainstManifest(path, c.Bool("strict-manifest"), bloomParams)
+				}
+				bloomParams.filterName = filepath.Base(path)
+				if c.String("sharded") != "" {
+					return checkAgainstShardedFilter(path, c.Int("shards"), bloomParams)
+				}
+				return checkAgainstFilter(path, bloomParams)
+			},
+		},
+		{
+			Name:  "interactive",
+			Flags: valueLimitFlags,
+			Usage: "Starts an interactive session to check and add values (enter 'help' for the commands), which are stored with 'save'.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				return interactiveSession(path, bloomParams)
+			},
+		},
+		{
+			Name: "delete",
+			Flags: append([]cli.Flag{
+				cli.Uint64Flag{Name: "tombstone-n", Usage: "The capacity of the tombstone filter, if the filter has none yet (default: 10% of the capacity of the filter, at most what fits into its metadata)."},
+				cli.Float64Flag{Name: "tombstone-p", Value: 0.001, Usage: "The false positive probability of the tombstone filter, if the filter has none yet."},
+			}, append(valueLimitFlags, lockFlags...)...),
+			Usage: "Deletes values from an existing Bloom filter by recording them in a tombstone filter stored with it.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if err = parseLockFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				p := c.Float64("tombstone-p")
+				if p <= 0 || p >= 1 {
+					return errors.New("tombstone-p must be between 0 and 1.")
+				}
+				return deleteFromFilter(path, c.Uint64("tombstone-n"), p, bloomParams)
+			},
+		},
+		{
+			Name:    "set-data",
+			Aliases: []string{"sd"},
+			Flags:   lockFlags,
+			Usage:   "Sets the data associated with the Bloom filter.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				if err = parseLockFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				return updateFilterData(path, bloomParams)
+			},
+		},
+		{
+			Name:    "get-data",
+			Aliases: []string{"gd"},
+			Flags:   []cli.Flag{},
+			Usage:   "Prints the data associated with the Bloom filter.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				return getFilterData(path, bloomParams)
+			},
+		},
+		{
+			Name: "chunk",
+			Flags: []cli.Flag{
+				cli.Int64Flag{Name: "size", Value: 64 << 20, Usage: "The maximum chunk size in bytes (a multiple of 8)."},
+			},
+			Usage: "Splits a Bloom filter into verifiable chunks and a manifest in the given directory.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("A filename and a directory are required.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				dir, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return chunkFilter(path, dir, c.Int64("size"), bloomParams)
+			},
+		},
+		{
+			Name:  "unchunk",
+			Flags: []cli.Flag{},
+			Usage: "Reassembles and verifies a Bloom filter from a chunk directory and stores it in the given filename.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("A directory and a filename are required.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				dir, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return unchunkFilter(dir, path, bloomParams)
+			},
+		},
+		{
+			Name: "export",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "exclude-file", Usage: "File with values (one per line) that the exported filter reports as not contained."},
+			},
+			Usage: "Writes a copy of a Bloom filter for distribution to the given filename.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("Two filenames are required.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				exportPath, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return exportFilter(path, exportPath, c.String("exclude-file"), bloomParams)
+			},
+		},
+		{
+			Name: "sample",
+			Flags: []cli.Flag{
+				cli.Float64Flag{Name: "fraction", Value: 0.1, Usage: "The fraction of the set bits to keep."},
+				cli.Int64Flag{Name: "seed", Value: 1, Usage: "The seed selecting the bits to keep."},
+			},
+			Usage: "Writes a copy of a Bloom filter with a random fraction of its bits to the given filename, for estimating the overlap with other filters only.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("Two filenames are required.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				samplePath, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return sampleFilter(path, samplePath, c.Float64("fraction"), c.Int64("seed"), bloomParams)
+			},
+		},
+		{
+			Name: "rebuild",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "values", Usage: "File with the authoritative list of values (one per line) of the filter."},
+			},
+			Usage: "Writes a new Bloom filter with the parameters, metadata and data of an existing one, containing only the values from the given file, to the given filename.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("Two filenames are required.")
+				}
+				if c.String("values") == "" {
+					return errors.New("The values must be given with --values.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				rebuiltPath, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return rebuildFilter(path, rebuiltPath, c.String("values"), bloomParams)
+			},
+		},
+		{
+			Name: "compact",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "values", Usage: "File with the authoritative list of values (one per line) of the filter."},
+				cli.Uint64Flag{Name: "n", Usage: "The desired capacity of the compacted filter."},
+				cli.Float64Flag{Name: "p", Usage: "The desired false positive probability of the compacted filter (default: that of the filter)."},
+			},
+			Usage: "Writes a new Bloom filter of the given capacity with the metadata and data of an existing one, containing the values from the given file, all of which must match the existing filter, to the given filename.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("Two filenames are required.")
+				}
+				if c.String("values") == "" {
+					return errors.New("The values must be given with --values.")
+				}
+				if c.Uint64("n") == 0 {
+					return errors.New("The capacity must be given with -n.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				compactedPath, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return compactFilter(path, compactedPath, c.String("values"), c.Uint64("n"), c.Float64("p"), bloomParams)
+			},
+		},
+		{
+			Name: "extract",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "json-path", Usage: "The dot-separated path of the base64-encoded filter in the JSON document (e.g. 'Event.Attribute.0.data')."},
+			},
+			Usage: "Extracts a Bloom filter embedded as a base64-encoded string in a JSON document (e.g. a MISP event) and stores it in the given filename.",
+			Action: func(c *cli.Context) error {
+				if len(c.Args()) != 2 {
+					return errors.New("A JSON file and a filename are required.")
+				}
+				if c.String("json-path") == "" {
+					return errors.New("The path of the filter must be given with --json-path.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				path, err := filepath.Abs(c.Args().Get(1))
+				if err != nil {
+					return err
+				}
+				return extractFilter(c.Args().First(), c.String("json-path"), path, bloomParams)
+			},
+		},
+		{
+			Name: "fingerprint",
+			Flags: append([]cli.Flag{
+				cli.StringFlag{Name: "format", Value: "csv", Usage: "The output format: 'csv' (the value followed by its indexes) or 'binary' (the indexes as little-endian uint64 values)."},
+			}, valueLimitFlags...),
+			Usage: "Writes the fingerprints (the indexes of the bits checked) of values from standard input or the given files with respect to a Bloom filter.",
+			Action: func(c *cli.Context) error {
+				if c.Args().First() == "" {
+					return errors.New("No filename given.")
+				}
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if err = parseValueLimitFlags(c, &bloomParams); err != nil {
+					return err
+				}
+				var format bloom.FingerprintFormat
+				switch c.String("format") {
+				case "csv":
+					format = bloom.FingerprintCSV
+				case "binary":
+					format = bloom.FingerprintBinary
+				default:
+					return errors.New("--format must be 'csv' or 'binary'.")
+				}
+				path, err := filepath.Abs(c.Args().First())
+				if err != nil {
+					return err
+				}
+				return fingerprintFilter(path, c.Args().Tail(), format, bloomParams)
+			},
+		},
+		{
+			Name: "bench",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "compare-hashes", Usage: "Compare the throughput and latency of adding and checking values with the candidate hash functions."},
+				cli.BoolFlag{Name: "compression", Usage: "Compare the compressed size and the compression and decompression throughput of filters with several fill ratios for the candidate compressions: none and gzip at levels 1, 6 and 9 (zstd is not included, as this build has no zstd implementation)."},
+				cli.IntFlag{Name: "ops", Value: bloomtest.DefaultHashBenchConfig.Ops, Usage: "The number of operations per combination."},
+				cli.IntSliceFlag{Name: "value-length", Usage: "A value length in bytes to benchmark (repeatable, default: 8, 64, 512, 4096)."},
+				cli.Int64SliceFlag{Name: "filter-bits", Usage: "A filter size in bits to benchmark (repeatable, default: 1e6, 1e8, 1e9, or 1e7 with --compression)."},
+				cli.StringFlag{Name: "fill", Value: defaultBenchFills, Usage: "The comma-separated fractions of set bits of the filters compared with --compression."},
+				cli.IntFlag{Name: "rounds", Value: bloomtest.DefaultCompressionBenchConfig.Rounds, Usage: "The number of times each filter is compressed with --compression, of which the fastest is reported."},
+				cli.BoolFlag{Name: "json", Usage: "With --compression, print the results as JSON, one object per line, instead of a table."},
+			},
+			Usage: "Runs benchmarks and prints the results as JSON, one object per line (or, with --compression, as a table).",
+			Action: func(c *cli.Context) error {
+				if c.Bool("compare-hashes") == c.Bool("compression") {
+					return errors.New("Exactly one benchmark must be selected (use --compare-hashes or --compression).")
+				}
+				filterBits, err := parseBenchFilterBits(c.Int64Slice("filter-bits"))
+				if err != nil {
+					return err
+				}
+				if c.Bool("compression") {
+					config := bloomtest.DefaultCompressionBenchConfig
+					if config.FillRatios, err = parseFills(c.String("fill")); err != nil {
+						return fmt.Errorf("Invalid value for --fill: %s", err)
+					}
+					if filterBits != nil {
+						config.FilterBits = filterBits
+					}
+					config.Rounds = c.Int("rounds")
+					if config.Rounds < 1 {
+						return errors.New("--rounds must be positive.")
+					}
+					return compareCompression(s.stdout, config, c.Bool("json"))
+				}
+				config := bloomtest.DefaultHashBenchConfig
+				config.Ops = c.Int("ops")
+				if lengths := c.IntSlice("value-length"); len(lengths) > 0 {
+					config.ValueLengths = lengths
+				}
+				if filterBits != nil {
+					config.FilterBits = filterBits
+				}
+				return compareHashes(s.stdout, config)
+			},
+		},
+		{
+			Name:      "precheck",
+			ArgsUsage: "manifest.json",
+			Usage:     "Checks whether the filters listed in a manifest can be joined, reading only their headers, and prints the matrix of compatible pairs.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				if path == "" {
+					return errors.New("No manifest given.")
+				}
+				headers, err := readManifestHeaders(context.Background(), path)
+				if err != nil {
+					return err
+				}
+				return precheckJoin(s.stdout, headers)
+			},
+		},
+		{
+			Name: "plan",
+			Flags: []cli.Flag{
+				cli.StringFlag{Name: "n", Usage: "The capacity to plan for, e.g. 5e8."},
+				cli.StringFlag{Name: "size", Usage: "Instead of a capacity, the maximum file size to plan for, e.g. 512MiB (units are powers of 1024, also if written 512MB)."},
+				cli.StringFlag{Name: "p", Value: defaultPlanFPPs, Usage: "The comma-separated false positive probabilities to plan for."},
+				cli.BoolFlag{Name: "json", Usage: "Print the plans as JSON, one object per line, instead of a table."},
+			},
+			Usage: "Prints the number of bits and hash functions and the file size of filters for a capacity, or the capacity of filters of a size, for several false positive probabilities.",
+			Action: func(c *cli.Context) error {
+				if (c.String("n") == "") == (c.String("size") == "") {
+					return errors.New("Either -n or --size must be given.")
+				}
+				var n, size uint64
+				var err error
+				if c.String("n") != "" {
+					if n, err = parseCapacity(c.String("n")); err != nil {
+						return fmt.Errorf("Invalid value for -n: %s", err)
+					}
+				} else if size, err = pipeline.ParseByteSize(c.String("size")); err != nil {
+					return fmt.Errorf("Invalid value for --size: %s", err)
+				}
+				fpps, err := parseFPPs(c.String("p"))
+				if err != nil {
+					return fmt.Errorf("Invalid value for -p: %s", err)
+				}
+				rows, err := planFilters(n, size, fpps)
+				if err != nil {
+					return err
+				}
+				return printPlan(s.stdout, rows, c.Bool("json"))
+			},
+		},
+		{
+			Name: "format",
+			Flags: []cli.Flag{
+				cli.IntFlag{Name: "version", Value: bloom.FormatVersion2, Usage: "The version of the file format."},
+				cli.BoolFlag{Name: "markdown", Usage: "Print the specification as Markdown instead of JSON."},
+			},
+			Usage: "Prints the specification of the binary file format.",
+			Action: func(c *cli.Context) error {
+				return printFormat(s.stdout, c.Int("version"), c.Bool("markdown"))
+			},
+		},
+		{
+			Name: "layout",
+			Flags: []cli.Flag{
+				cli.BoolFlag{Name: "json", Usage: "Print the layout as JSON."},
+			},
+			Usage: "Prints the offsets and lengths in bytes of the header fields, the bit array, the metadata and the data of the uncompressed binary representation of a filter.",
+			Action: func(c *cli.Context) error {
+				if c.Args().First() == "" {
+					return errors.New("No filename given.")
+				}
+				return printLayout(s.stdout, c.Args().First(), c.Bool("json"))
+			},
+		},
+		{
+			Name:  "verify-interop",
+			Flags: verifyInteropFlags,
+			Usage: "Checks the values listed with --values against a filter written by another implementation, e.g. in Python or C, and reports the values that unexpectedly miss or hit.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				if c.String("values") == "" {
+					return errors.New("No list of values given with --values.")
+				}
+				values, err := readExpectedValues(c.String("values"))
+				if err != nil {
+					return err
+				}
+				report, err := verifyInterop(path, bloomParams.gzip, values)
+				if err != nil && report.Error == "" {
+					return err
+				}
+				if err := printInteropReport(s.stdout, report, c.Bool("json")); err != nil {
+					return err
+				}
+				if err != nil {
+					return err
+				}
+				if n := report.mismatches(); n > 0 {
+					return fmt.Errorf("%d of %d values gave unexpected results.", n, report.Values)
+				}
+				return nil
+			},
+		},
+		{
+			Name:    "show",
+			Aliases: []string{"s"},
+			Flags:   []cli.Flag{},
+			Usage:   "Shows various details about a given Bloom filter.",
+			Action: func(c *cli.Context) error {
+				path := c.Args().First()
+				bloomParams, err := parseBloomParams(c, s)
+				if err != nil {
+					return err
+				}
+				if path == "" {
+					return errors.New("No filename given.")
+				}
+				path, err = filepath.Abs(path)
+				if err != nil {
+					return err
+				}
+				return printStats(path, bloomParams)
+			},
+		},
+		{
+			Name:      "flags",
+			Usage:     "Prints the effective values of the global flags, or of the flags of the given command, and where they come from.",
+			ArgsUsage: "[command]",
+			Action: func(c *cli.Context) error {
+				return printFlags(c, c.App.Writer, c.Args().First())
+			},
+		},
+		profileCommand,
+	}
+	for i := range app.Commands {
+		app.Commands[i].Action = withCommandEnv(app.Commands[i].Action)
+	}
+	app.Version = "0.2.4"
+	app.Writer = s.stdout
+	app.ErrWriter = s.stderr
+	return app
+}
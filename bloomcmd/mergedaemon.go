@@ -0,0 +1,72 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/DCSO/bloom"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// mergeDaemonFlags are the flags of merge-daemon.
+var mergeDaemonFlags = append([]cli.Flag{
+	cli.StringFlag{Name: "spool", Usage: "The directory the filters to merge arrive in. A filter is merged once a marker file named like it with '.done' appended exists, or once its size did not change between two scans."},
+	cli.StringFlag{Name: "output", Usage: "The filter the arriving filters are merged into, which is rewritten atomically after each merge (created by the first merge if missing)."},
+	cli.StringFlag{Name: "pattern", Value: "*.bloom", Usage: "The pattern of the names of the filters to merge in the spool directory (see filepath.Match)."},
+	cli.DurationFlag{Name: "interval", Value: time.Minute, Usage: "The time between scans of the spool directory."},
+	cli.BoolFlag{Name: "once", Usage: "Scan the spool directory only once and exit, e.g. from cron (only filters with a marker file are complete then)."},
+}, lockFlags...)
+
+// streamLogger is a bloom.Logger writing each event as a line with the time,
+// level, message and the keys and values of the event.
+type streamLogger struct {
+	w   io.Writer
+	now func() time.Time
+}
+
+func (l streamLogger) log(level, msg string, args []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s", l.now().Format(time.RFC3339), level, msg)
+	for i := 0; i+1 < len(args); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+	fmt.Fprintln(l.w, b.String())
+}
+
+func (l streamLogger) Debug(msg string, args ...interface{}) { l.log("DEBUG", msg, args) }
+func (l streamLogger) Info(msg string, args ...interface{})  { l.log("INFO", msg, args) }
+func (l streamLogger) Warn(msg string, args ...interface{})  { l.log("WARN", msg, args) }
+
+// mergeSpool merges the filters arriving in the spool directory into the
+// output, holding the lock of the output meanwhile, until interrupted or
+// terminated, or after a single scan with once. The merges are logged to
+// standard error.
+func mergeSpool(dir, pattern, output string, interval time.Duration, once bool, bloomParams BloomParams) error {
+	unlock, err := bloomParams.lockFilter(output)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	logger := streamLogger{w: bloomParams.stderr, now: time.Now}
+	merger, err := bloom.NewSpoolMerger(dir, pattern, output, bloomParams.gzip, bloom.WithSpoolLogger(logger))
+	if err != nil {
+		return err
+	}
+	if once {
+		_, err := merger.Scan()
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bloomParams.cancel = cancel
+	stop := flushOnTermination(bloomParams, matchWriters(nil))
+	defer stop()
+	merger.Run(ctx, interval)
+	return nil
+}
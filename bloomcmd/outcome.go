@@ -0,0 +1,201 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// outcomeJSON is the JSON representation of the outcome of checking a value,
+// printed by check with --json.
+type outcomeJSON struct {
+	Value          string   `json:"value"`
+	SourceFile     string   `json:"source_file,omitempty"`
+	Line           int      `json:"line"`
+	Matched        bool     `json:"matched"`
+	MatchedFilters []string `json:"matched_filters,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	Score          int      `json:"score"`
+}
+
+// formatOutcome returns the JSON representation of an outcome, with the
+// value in the given encoding.
+func formatOutcome(outcome pipeline.CheckOutcome, encoding pipeline.Encoding) string {
+	data, _ := json.Marshal(outcomeJSON{
+		Value:          encoding.EncodeToString(outcome.Value),
+		SourceFile:     outcome.SourceFile,
+		Line:           outcome.Line,
+		Matched:        outcome.Matched,
+		MatchedFilters: outcome.MatchedFilters,
+		Tags:           outcome.Tags,
+		Score:          outcome.Score,
+	})
+	return string(data)
+}
+
+// checkForms checks a value against the filter and returns whether it
+// matches and the form of it that matched, which with --domain-forms both may
+// be another form of the value as a domain name (see bloom.DomainForms).
+func checkForms(filter valueSet, value []byte, bloomParams BloomParams) (bool, []byte) {
+	if !bloomParams.domainForms {
+		return filter.Check(value), value
+	}
+	for _, form := range bloom.DomainForms(string(value)) {
+		if filter.Check([]byte(form)) {
+			return true, []byte(form)
+		}
+	}
+	return false, value
+}
+
+// matchScore returns the percentage of the bits of a value that are set in
+// the filter, if it can tell, and otherwise 100 for values that matched.
+func matchScore(filter valueSet, value []byte, matched bool) int {
+	if scorer, ok := filter.(interface{ MatchScore(value []byte) float64 }); ok {
+		return int(100 * scorer.MatchScore(value))
+	}
+	if matched {
+		return 100
+	}
+	return 0
+}
+
+// prefixTags prefixes the lines to report for a line with the tags of its
+// matching values, or with --each each value with its own tags, and a tab.
+func prefixTags(results []string, outcomes []pipeline.CheckOutcome, bloomParams BloomParams) []string {
+	if !bloomParams.printEachMatch {
+		var tags []string
+		for _, outcome := range outcomes {
+			for _, tag := range outcome.Tags {
+				if !containsString(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		sort.Strings(tags)
+		for i, result := range results {
+			results[i] = formatTags(tags) + "\t" + result
+		}
+		return results
+	}
+	// the values are reported in the order of their outcomes
+	i := 0
+	for _, outcome := range outcomes {
+		if outcome.Matched != bloomParams.invertMatch && i < len(results) {
+			results[i] = formatTags(outcome.Tags) + "\t" + results[i]
+			i++
+		}
+	}
+	return results
+}
+
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return "-"
+	}
+	return strings.Join(tags, ",")
+}
+
+// templateOutcome is the outcome of checking a value as rendered by the
+// template given to check with --template or --template-file.
+type templateOutcome struct {
+	// Value is the value checked, in the encoding of the output.
+	Value string
+	// File is the name of the input, if known, Line the number of the line
+	// of the value, starting at 1, and Text the whole line.
+	File string
+	Line int
+	Text string
+	// Matched tells whether the value matched, and Filters are the names of
+	// the filters it matched.
+	Matched bool
+	Filters templateList
+	// Tags are the tags the value was probably added with, and Score the
+	// percentage of its bits that are set in the filter it matched best.
+	Tags  templateList
+	Score int
+}
+
+// templateList is a list of names, which templates print separated by
+// commas and can range over.
+type templateList []string
+
+func (l templateList) String() string {
+	return strings.Join(l, ",")
+}
+
+// templateFuncs are the functions available in the templates of check:
+// json formats its argument as JSON, csv its arguments as a CSV record, and
+// join the elements of a list with a separator.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"csv": func(fields ...interface{}) (string, error) {
+		record := make([]string, len(fields))
+		for i, field := range fields {
+			record[i] = fmt.Sprint(field)
+		}
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		if err := w.Write(record); err != nil {
+			return "", err
+		}
+		w.Flush()
+		return strings.TrimSuffix(buf.String(), "\n"), w.Error()
+	},
+	"join": func(list templateList, sep string) string {
+		return strings.Join(list, sep)
+	},
+}
+
+// parseOutcomeTemplate compiles the template of check, which is rendered
+// once with an example outcome so that references to unknown fields fail
+// before any input is read.
+func parseOutcomeTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("outcome").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	example := templateOutcome{Value: "example.com", File: "input", Line: 1, Text: "example.com",
+		Matched: true, Filters: templateList{"filter"}, Tags: templateList{"tag"}, Score: 100}
+	if err := tmpl.Execute(ioutil.Discard, example); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// renderOutcome renders an outcome of checking a value of the line through
+// the template of check. The output ends with a single newline, which the
+// template may omit. If the outcome names no filters, a matching value is
+// reported as matching the filter checked.
+func renderOutcome(tmpl *template.Template, line string, outcome pipeline.CheckOutcome, bloomParams BloomParams) (string, error) {
+	filters := outcome.MatchedFilters
+	if len(filters) == 0 && outcome.Matched && bloomParams.filterName != "" {
+		filters = []string{bloomParams.filterName}
+	}
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, templateOutcome{
+		Value:   bloomParams.encoding.EncodeToString(outcome.Value),
+		File:    outcome.SourceFile,
+		Line:    outcome.Line,
+		Text:    line,
+		Matched: outcome.Matched,
+		Filters: filters,
+		Tags:    outcome.Tags,
+		Score:   outcome.Score,
+	})
+	return strings.TrimSuffix(buf.String(), "\n"), err
+}
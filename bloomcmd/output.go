@@ -0,0 +1,141 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// outputBufferSize is the size of the buffer for the lines reported by check.
+const outputBufferSize = 64 << 10
+
+// matchWriter buffers the lines reported by check, or flushes each write
+// immediately if it is line buffered. It is safe for concurrent use, so that
+// it can be flushed when the process is interrupted.
+type matchWriter struct {
+	mu           sync.Mutex
+	w            *bufio.Writer
+	lineBuffered bool
+}
+
+func newMatchWriter(w io.Writer, lineBuffered bool) *matchWriter {
+	return &matchWriter{
+		w:            bufio.NewWriterSize(w, outputBufferSize),
+		lineBuffered: lineBuffered,
+	}
+}
+
+// Write writes p, which is expected to consist of complete lines.
+func (m *matchWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, err := m.w.Write(p)
+	if err == nil && m.lineBuffered {
+		err = m.w.Flush()
+	}
+	return n, err
+}
+
+// Flush writes the buffered lines.
+func (m *matchWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.w.Flush()
+}
+
+// matchWriters are several outputs for the lines reported by check.
+type matchWriters []*matchWriter
+
+// Flush writes the buffered lines of all outputs and returns the first error.
+func (m matchWriters) Flush() error {
+	var err error
+	for _, w := range m {
+		if flushErr := w.Flush(); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+	return err
+}
+
+// terminationGracePeriod is the time check has after it is interrupted or
+// terminated to end the input, write the buffered output and print its
+// summary, before the output is flushed and the process exits regardless,
+// e.g. because reading the input blocks.
+var terminationGracePeriod = 5 * time.Second
+
+// flushOnSignal flushes the output and calls exit when a signal is received
+// on the channel, until the returned function is called.
+func flushOnSignal(out interface{ Flush() error }, signals <-chan os.Signal, exit func(code int)) func() {
+	return stopOnSignal(out, signals, nil, exit)
+}
+
+// stopOnSignal calls cancel when a signal is received on the channel, so that
+// the input ends and the command finishes as usual, i.e. calls the returned
+// function. If it does not finish within terminationGracePeriod, if another
+// signal is received or if cancel is nil, the output is flushed and exit is
+// called.
+func stopOnSignal(out interface{ Flush() error }, signals <-chan os.Signal, cancel func(), exit func(code int)) func() {
+	done := make(chan struct{})
+	gracePeriod := terminationGracePeriod
+	go func() {
+		select {
+		case <-signals:
+		case <-done:
+			return
+		}
+		if cancel != nil {
+			cancel()
+			timer := time.NewTimer(gracePeriod)
+			defer timer.Stop()
+			select {
+			case <-done:
+				return
+			case <-signals:
+			case <-timer.C:
+			}
+		}
+		out.Flush()
+		exit(1)
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// flushOnTermination ends the input with the cancel function of the tool, if
+// any, and flushes the output and exits the process if it does not finish in
+// time when it is interrupted or terminated (see stopOnSignal), if the tool
+// exits the process, until the returned function is called.
+func flushOnTermination(bloomParams BloomParams, out interface{ Flush() error }) func() {
+	if bloomParams.exit == nil {
+		return func() {}
+	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, terminationSignals...)
+	stop := stopOnSignal(out, signals, bloomParams.cancel, bloomParams.exit)
+	return func() {
+		signal.Stop(signals)
+		stop()
+	}
+}
+
+// openMatchOutput returns the output for the lines reported by check, which is
+// line buffered with --line-buffered, in interactive mode and if standard
+// output is a terminal. The buffered lines are written by the returned
+// function, which is to be called when all lines are written, and, if the tool
+// exits the process, when it is interrupted or terminated.
+func openMatchOutput(bloomParams BloomParams) (*matchWriter, func() error) {
+	lineBuffered := bloomParams.lineBuffered || bloomParams.interactive || isTerminalStream(bloomParams.stdout)
+	out := newMatchWriter(bloomParams.stdout, lineBuffered)
+	stop := flushOnTermination(bloomParams, out)
+	return out, func() error {
+		stop()
+		return out.Flush()
+	}
+}
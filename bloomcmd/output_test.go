@@ -0,0 +1,154 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchWriterLineBuffered(t *testing.T) {
+	var buf bytes.Buffer
+	out := newMatchWriter(&buf, false)
+	out.Write([]byte("foo\n"))
+	if buf.Len() != 0 {
+		t.Fatal("expected the output to be buffered")
+	}
+	out.Flush()
+	if buf.String() != "foo\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+
+	buf.Reset()
+	out = newMatchWriter(&buf, true)
+	out.Write([]byte("foo\n"))
+	if buf.String() != "foo\n" {
+		t.Fatalf("expected the line to be written immediately, got %q", buf.String())
+	}
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	filter := testFilter("foo")
+	input, inputWriter := io.Pipe()
+	var buf bytes.Buffer
+	out := newMatchWriter(&buf, false)
+	signals := make(chan os.Signal, 1)
+	exited := make(chan int)
+	stop := flushOnSignal(out, signals, func(code int) {
+		exited <- code
+	})
+	defer stop()
+	checked := make(chan struct{})
+	go func() {
+		checkValues(filter, input, out, BloomParams{})
+		close(checked)
+	}()
+
+	// each line is only read once the previous ones were checked, so all
+	// matches were reported when the last line was read
+	for i := 0; i < 100; i++ {
+		io.WriteString(inputWriter, "foo\n")
+		io.WriteString(inputWriter, "bar\n")
+	}
+	io.WriteString(inputWriter, "bar\n")
+	signals <- os.Interrupt
+	if code := <-exited; code == 0 {
+		t.Fatal("expected a non-zero exit code")
+	}
+	if buf.String() != strings.Repeat("foo\n", 100) {
+		t.Fatalf("unexpected output after interrupt: %d bytes", buf.Len())
+	}
+	inputWriter.Close()
+	<-checked
+}
+
+func TestStopOnSignal(t *testing.T) {
+	filter := testFilter("foo")
+	input, inputWriter := io.Pipe()
+	var buf, stderr bytes.Buffer
+	out := newMatchWriter(&buf, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	bloomParams := BloomParams{ctx: ctx, cancel: cancel, streams: streams{stderr: &stderr}}
+	signals := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+	stop := stopOnSignal(out, signals, cancel, func(code int) {
+		exited <- code
+	})
+	checked := make(chan struct{})
+	go func() {
+		checkValues(filter, input, out, bloomParams)
+		close(checked)
+	}()
+
+	io.WriteString(inputWriter, "foo\nbar\nfoo\n")
+	signals <- os.Interrupt
+	<-ctx.Done()
+	// the input ends when the next line was read
+	io.WriteString(inputWriter, "foo\n")
+	<-checked
+	stop()
+	out.Flush()
+	if buf.String() != "foo\nfoo\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	if stderr.String() != "Stopped after 3 lines read, of which 2 were reported.\n" {
+		t.Fatalf("unexpected summary %q", stderr.String())
+	}
+	select {
+	case <-exited:
+		t.Fatal("exited although check finished")
+	default:
+	}
+}
+
+func TestStopOnSignalGracePeriod(t *testing.T) {
+	defer func(d time.Duration) { terminationGracePeriod = d }(terminationGracePeriod)
+	terminationGracePeriod = 10 * time.Millisecond
+	var buf bytes.Buffer
+	out := newMatchWriter(&buf, false)
+	out.Write([]byte("foo\n"))
+	signals := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+	canceled := false
+	stop := stopOnSignal(out, signals, func() { canceled = true }, func(code int) {
+		exited <- code
+	})
+	defer stop()
+	signals <- os.Interrupt
+	if code := <-exited; code == 0 || !canceled {
+		t.Fatalf("unexpected exit code %d (canceled: %v)", code, canceled)
+	}
+	if buf.String() != "foo\n" {
+		t.Fatalf("output not flushed: %q", buf.String())
+	}
+}
+
+func BenchmarkCheckOutput(b *testing.B) {
+	filter := testFilter("foo")
+	input := strings.Repeat("foo\n", 100000)
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	for _, lineBuffered := range []bool{false, true} {
+		name := "buffered"
+		if lineBuffered {
+			name = "line-buffered"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(len(input)))
+			for i := 0; i < b.N; i++ {
+				out := newMatchWriter(devNull, lineBuffered)
+				checkValues(filter, strings.NewReader(input), out, BloomParams{})
+				out.Flush()
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// defaultPlanFPPs are the false positive probabilities planned for by default.
+const defaultPlanFPPs = "0.1,0.01,0.001,0.0001,0.00001,0.000001"
+
+// planRow is a row of the table printed by the plan command.
+type planRow struct {
+	Capacity  uint64  `json:"capacity"`
+	FPP       float64 `json:"fpp"`
+	Bits      uint64  `json:"bits"`
+	HashFuncs uint64  `json:"hash_funcs"`
+	FileSize  uint64  `json:"file_size"`
+	GzipSize  uint64  `json:"gzip_size_estimate"`
+	Warning   string  `json:"warning,omitempty"`
+}
+
+// parseCapacity parses a capacity, which may be given in scientific notation,
+// e.g. "5e8".
+func parseCapacity(s string) (uint64, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil && n > 0 {
+		return n, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n >= 1) || n != math.Trunc(n) || n >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid capacity %q", s)
+	}
+	return uint64(n), nil
+}
+
+// parseFPPs parses a comma-separated list of false positive probabilities.
+func parseFPPs(s string) ([]float64, error) {
+	var fpps []float64
+	for _, field := range strings.Split(s, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || !(p > 0 && p < 1) {
+			return nil, fmt.Errorf("invalid false positive probability %q", field)
+		}
+		fpps = append(fpps, p)
+	}
+	return fpps, nil
+}
+
+// planFilters returns a row for each false positive probability, planned for
+// the capacity n or, if n is zero, for the largest capacity that fits into
+// size bytes.
+func planFilters(n, size uint64, fpps []float64) ([]planRow, error) {
+	rows := make([]planRow, 0, len(fpps))
+	for _, p := range fpps {
+		var plan bloom.Plan
+		var err error
+		if n > 0 {
+			plan, err = bloom.PlanFilter(n, p)
+		} else {
+			plan, err = bloom.PlanForSize(size, p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, planRow{
+			Capacity:  plan.Capacity,
+			FPP:       plan.FPP,
+			Bits:      plan.Bits,
+			HashFuncs: plan.HashFuncs,
+			FileSize:  plan.FileSize(),
+			GzipSize:  plan.EstimatedGzipSize(),
+			Warning:   plan.Warning(),
+		})
+	}
+	return rows, nil
+}
+
+// printPlan writes the rows as a table, or as JSON, one object per line.
+func printPlan(w io.Writer, rows []planRow, asJSON bool) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "p\tn\tm (bits)\tk\tfile size\tgzip size (approx.)\t")
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%g\t%d\t%d\t%d\t%s\t%s\t\n", row.FPP, row.Capacity, row.Bits, row.HashFuncs,
+			pipeline.FormatByteSize(row.FileSize), pipeline.FormatByteSize(row.GzipSize))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.Warning != "" {
+			fmt.Fprintf(w, "Warning: %s.\n", row.Warning)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import "testing"
+
+func TestParseCapacity(t *testing.T) {
+	for s, expected := range map[string]uint64{"1": 1, "5e8": 5e8, "18446744073709551615": 1<<64 - 1, "1.5e3": 1500} {
+		if n, err := parseCapacity(s); err != nil || n != expected {
+			t.Errorf("%q: got %d, %v, expected %d", s, n, err, expected)
+		}
+	}
+	for _, s := range []string{"", "0", "0.5", "1.5", "-3", "1e30"} {
+		if _, err := parseCapacity(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/DCSO/bloom"
+)
+
+// readManifestHeaders reads only the headers of the filters listed in a
+// manifest, named by their entries.
+func readManifestHeaders(ctx context.Context, path string) ([]bloom.FilterInfo, error) {
+	manifest, err := bloom.ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]bloom.FilterInfo, len(manifest.Filters))
+	for i, entry := range manifest.Filters {
+		source := entry.Source
+		if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+			headers[i], err = bloom.ReadHeaderFromURL(ctx, source)
+		} else {
+			if !filepath.IsAbs(source) {
+				source = filepath.Join(filepath.Dir(path), source)
+			}
+			headers[i], err = bloom.ReadHeaderFromFile(source)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read the header of filter %q: %s", entry.Name, err)
+		}
+		headers[i].Name = entry.Name
+	}
+	return headers, nil
+}
+
+// precheckJoin prints the parameters of the filters, the matrix of the pairs
+// that can be joined ("ok") or not ("X") and the differences of the latter.
+// An error is returned if any pair is incompatible.
+func precheckJoin(w io.Writer, headers []bloom.FilterInfo) error {
+	err := bloom.CheckJoinCompatibility(headers)
+	var joinErr *bloom.JoinCompatibilityError
+	if err != nil && !errors.As(err, &joinErr) {
+		return err
+	}
+	incompatible := make(map[[2]int]bool)
+	if joinErr != nil {
+		for _, pair := range joinErr.Pairs {
+			incompatible[[2]int{pair.A, pair.B}] = true
+			incompatible[[2]int{pair.B, pair.A}] = true
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tname\tn\tp\tk\tm")
+	for i, header := range headers {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%g\t%d\t%d\n", i+1, header.Name, header.Capacity, header.FalsePositiveProb, header.HashFuncs, header.Bits)
+	}
+	fmt.Fprintln(tw)
+	for i := range headers {
+		fmt.Fprintf(tw, "\t%d", i+1)
+	}
+	fmt.Fprintln(tw)
+	for i := range headers {
+		fmt.Fprintf(tw, "%d", i+1)
+		for j := range headers {
+			switch {
+			case i == j:
+				fmt.Fprint(tw, "\t-")
+			case incompatible[[2]int{i, j}]:
+				fmt.Fprint(tw, "\tX")
+			default:
+				fmt.Fprint(tw, "\tok")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if joinErr == nil {
+		fmt.Fprintf(w, "\nAll %d filters can be joined.\n", len(headers))
+		return nil
+	}
+	fmt.Fprintln(w)
+	for _, pair := range joinErr.Pairs {
+		fmt.Fprintf(w, "%s and %s: %s\n", headers[pair.A].Name, headers[pair.B].Name, strings.Join(pair.Differences, ", "))
+	}
+	return fmt.Errorf("%d of the %d pairs of filters cannot be joined.", len(joinErr.Pairs), len(headers)*(len(headers)-1)/2)
+}
@@ -0,0 +1,254 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Profile is a named set of global flag values, keyed by flag name.
+type Profile map[string]string
+
+// ProfileStore is the on-disk representation of all saved profiles.
+type ProfileStore struct {
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// profileMetaFlags are the global flags that select profiles and hence are
+// never stored in a profile themselves.
+var profileMetaFlags = []string{"profile", "profiles-file"}
+
+// defaultProfilesFile returns the default location of the profiles file.
+func defaultProfilesFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".bloom-profiles.json"
+	}
+	return filepath.Join(dir, "bloom", "profiles.json")
+}
+
+// loadProfiles reads the profiles file at the given path. A missing file
+// yields an empty store.
+func loadProfiles(path string) (ProfileStore, error) {
+	store := ProfileStore{Profiles: make(map[string]Profile)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	if err = json.Unmarshal(data, &store); err != nil {
+		return store, fmt.Errorf("cannot parse profiles file %s: %s", path, err)
+	}
+	if store.Profiles == nil {
+		store.Profiles = make(map[string]Profile)
+	}
+	return store, nil
+}
+
+// saveProfiles atomically replaces the profiles file at the given path.
+func saveProfiles(path string, store ProfileStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".profiles")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// profileFlagNames returns the names of the global flags that can be stored
+// in a profile.
+func profileFlagNames(c *cli.Context) []string {
+	// the context of a subcommand belongs to an app without global flags
+	for c.Parent() != nil {
+		c = c.Parent()
+	}
+	var names []string
+	for _, name := range c.GlobalFlagNames() {
+		if !containsString(profileMetaFlags, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func containsString(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// activeProfile returns the profile selected with --profile, or nil if no
+// profile was selected.
+func activeProfile(c *cli.Context) (Profile, error) {
+	name := resolveGlobalFlag(c, nil, "profile").value
+	if name == "" {
+		return nil, nil
+	}
+	store, err := loadProfiles(profilesFile(c))
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := store.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s does not exist", name)
+	}
+	return profile, nil
+}
+
+// profilesFile returns the path of the profiles file.
+func profilesFile(c *cli.Context) string {
+	return resolveGlobalFlag(c, nil, "profiles-file").value
+}
+
+// globalFlagValue returns the value of the named global flag. Values given
+// explicitly on the command line take precedence over values from the
+// profile, which take precedence over the default value of the flag.
+func globalFlagValue(c *cli.Context, profile Profile, name string) string {
+	if !c.GlobalIsSet(name) {
+		if v, ok := profile[name]; ok {
+			return v
+		}
+	}
+	return c.GlobalString(name)
+}
+
+// captureProfile returns a profile with the values of all global flags that
+// were given explicitly or come from the active profile.
+func captureProfile(c *cli.Context, active Profile) Profile {
+	profile := make(Profile)
+	for _, name := range profileFlagNames(c) {
+		if _, ok := active[name]; ok || c.GlobalIsSet(name) {
+			profile[name] = globalFlagValue(c, active, name)
+		}
+	}
+	return profile
+}
+
+func saveProfile(c *cli.Context, name string) error {
+	active, err := activeProfile(c)
+	if err != nil {
+		return err
+	}
+	profile := captureProfile(c, active)
+	if len(profile) == 0 {
+		return fmt.Errorf("no global flags given to store in profile %s", name)
+	}
+	path := profilesFile(c)
+	store, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	store.Profiles[name] = profile
+	return saveProfiles(path, store)
+}
+
+func removeProfile(path string, name string) error {
+	store, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := store.Profiles[name]; !ok {
+		return fmt.Errorf("profile %s does not exist", name)
+	}
+	delete(store.Profiles, name)
+	return saveProfiles(path, store)
+}
+
+// formatProfile returns the flags of a profile as they would be given on the
+// command line, in sorted order.
+func formatProfile(profile Profile) string {
+	names := make([]string, 0, len(profile))
+	for name := range profile {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, len(names))
+	for i, name := range names {
+		args[i] = fmt.Sprintf("--%s=%q", name, profile[name])
+	}
+	return strings.Join(args, " ")
+}
+
+func listProfiles(w io.Writer, path string) error {
+	store, err := loadProfiles(path)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(store.Profiles))
+	for name := range store.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "%s\t%s\n", name, formatProfile(store.Profiles[name]))
+	}
+	return nil
+}
+
+var profileCommand = cli.Command{
+	Name:  "profile",
+	Usage: "Manages named sets of global flags (profiles), applied with --profile.",
+	Subcommands: []cli.Command{
+		{
+			Name:  "save",
+			Usage: "Saves the given global flags (and those of the active profile) under the given name.",
+			Action: func(c *cli.Context) error {
+				name := c.Args().First()
+				if name == "" {
+					return errors.New("No profile name given.")
+				}
+				return saveProfile(c, name)
+			},
+		},
+		{
+			Name:    "list",
+			Aliases: []string{"ls"},
+			Usage:   "Lists all profiles.",
+			Action: func(c *cli.Context) error {
+				return listProfiles(c.App.Writer, profilesFile(c))
+			},
+		},
+		{
+			Name:    "remove",
+			Aliases: []string{"rm"},
+			Usage:   "Removes the profile with the given name.",
+			Action: func(c *cli.Context) error {
+				name := c.Args().First()
+				if name == "" {
+					return errors.New("No profile name given.")
+				}
+				return removeProfile(profilesFile(c), name)
+			},
+		},
+	},
+}
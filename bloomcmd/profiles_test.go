@@ -0,0 +1,126 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"gopkg.in/urfave/cli.v1"
+)
+
+func testContext(t *testing.T, args ...string) *cli.Context {
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{Name: "split"},
+		cli.StringFlag{Name: "delimiter", Value: ","},
+		cli.StringFlag{Name: "fields"},
+		cli.StringFlag{Name: "profile"},
+	}
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range app.Flags {
+		f.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cli.NewContext(app, set, nil)
+}
+
+func TestProfilePrecedence(t *testing.T) {
+	profile := Profile{"delimiter": ";", "split": "true"}
+	for _, c := range []struct {
+		args     []string
+		flag     string
+		expected string
+	}{
+		// default without profile value
+		{nil, "fields", ""},
+		// profile value over default
+		{nil, "delimiter", ";"},
+		{nil, "split", "true"},
+		// explicit value over profile value
+		{[]string{"--delimiter", "\t"}, "delimiter", "\t"},
+		{[]string{"--delimiter", ","}, "delimiter", ","},
+		{[]string{"--split=false"}, "split", "false"},
+		// explicit value over default
+		{[]string{"--fields", "1,2"}, "fields", "1,2"},
+	} {
+		ctx := testContext(t, c.args...)
+		if v := globalFlagValue(ctx, profile, c.flag); v != c.expected {
+			t.Errorf("args %v: unexpected value for %s: %q vs. %q", c.args, c.flag, v, c.expected)
+		}
+	}
+	ctx := testContext(t)
+	if v := globalFlagValue(ctx, nil, "delimiter"); v != "," {
+		t.Errorf("unexpected value without profile: %q", v)
+	}
+}
+
+func TestCaptureProfile(t *testing.T) {
+	ctx := testContext(t, "--delimiter", "\t", "--fields", "4", "--profile", "other")
+	profile := captureProfile(ctx, Profile{"split": "true", "delimiter": ";"})
+	expected := Profile{"split": "true", "delimiter": "\t", "fields": "4"}
+	if !reflect.DeepEqual(profile, expected) {
+		t.Fatalf("unexpected profile: %v", profile)
+	}
+}
+
+func TestProfileStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sub", "profiles.json")
+
+	store, err := loadProfiles(path)
+	if err != nil {
+		t.Fatalf("missing profiles file should yield an empty store: %s", err)
+	}
+	if len(store.Profiles) != 0 {
+		t.Fatal("store should be empty")
+	}
+	store.Profiles["tsv"] = Profile{"split": "true", "delimiter": "\t", "fields": "4"}
+	store.Profiles["gz"] = Profile{"gzip": "true"}
+	if err := saveProfiles(path, store); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, store) {
+		t.Fatalf("profiles do not round-trip: %v vs. %v", loaded, store)
+	}
+
+	if err := removeProfile(path, "gz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeProfile(path, "gz"); err == nil {
+		t.Fatal("removing a missing profile should fail")
+	}
+	loaded, _ = loadProfiles(path)
+	if _, ok := loaded.Profiles["gz"]; ok || len(loaded.Profiles) != 1 {
+		t.Fatalf("unexpected profiles after removal: %v", loaded.Profiles)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadProfiles(path); err == nil {
+		t.Fatal("corrupt profiles file should be reported")
+	}
+}
+
+func TestFormatProfile(t *testing.T) {
+	s := formatProfile(Profile{"split": "true", "delimiter": "\t"})
+	if s != `--delimiter="\t" --split="true"` {
+		t.Fatalf("unexpected formatting: %s", s)
+	}
+}
@@ -0,0 +1,136 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/DCSO/bloom/pipeline"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// progressInterval is how often --progress reports the progress.
+const progressInterval = 5 * time.Second
+
+// progressCheckEvery is the number of updates of a progressReporter after
+// which it checks whether the progress is due, to not read the clock for
+// every line.
+const progressCheckEvery = 256
+
+// progressFlags are the flags of the commands that build filters to report
+// their progress.
+var progressFlags = []cli.Flag{
+	cli.BoolFlag{Name: "progress", Usage: "Report the values and bytes read and their rates on standard error every few seconds, with the percentage read and the estimated remaining time when reading a regular file (e.g. with --from or redirected standard input), and at the end the time taken with the estimated size and time, for tuning --gzip-ratio."},
+	cli.Float64Flag{Name: "gzip-ratio", Value: pipeline.DefaultGzipRatio, Usage: "The assumed ratio of the uncompressed to the compressed size of a gzip-compressed file given with --from, for estimating its size with --progress."},
+}
+
+func parseProgressFlags(c *cli.Context, bloomParams *BloomParams) error {
+	bloomParams.progress = c.Bool("progress")
+	bloomParams.gzipRatio = c.Float64("gzip-ratio")
+	if c.IsSet("gzip-ratio") && !bloomParams.progress {
+		return errors.New("--gzip-ratio requires --progress.")
+	}
+	if bloomParams.gzipRatio <= 0 {
+		return errors.New("--gzip-ratio must be positive.")
+	}
+	return nil
+}
+
+// progressReporter reports the progress of reading the values of a filter.
+type progressReporter struct {
+	streams
+	estimator *pipeline.Estimator
+	// countValues is false if the values are not counted, e.g. with --from
+	countValues bool
+	updates     int
+	lastReport  time.Time
+}
+
+// newProgressReporter returns a reporter for an input of the given estimated
+// size (0 if unknown).
+func (bloomParams BloomParams) newProgressReporter(total int64, countValues bool) *progressReporter {
+	now := time.Now()
+	return &progressReporter{
+		streams:     bloomParams.streams,
+		estimator:   pipeline.NewEstimator(total, now),
+		countValues: countValues,
+		lastReport:  now,
+	}
+}
+
+// inputProgressReporter returns a reporter for the values read from input,
+// whose size is known if it is a regular file, or nil without --progress.
+func (bloomParams BloomParams) inputProgressReporter(input io.Reader) *progressReporter {
+	if !bloomParams.progress {
+		return nil
+	}
+	var total int64
+	if f, ok := input.(*os.File); ok {
+		info, err := f.Stat()
+		// the input may have been positioned to resume
+		if pos, seekErr := f.Seek(0, io.SeekCurrent); err == nil && seekErr == nil && info.Mode().IsRegular() {
+			total = info.Size() - pos
+		}
+	}
+	return bloomParams.newProgressReporter(total, true)
+}
+
+// update records the bytes and values read and reports the progress if the
+// last report is at least progressInterval ago.
+func (r *progressReporter) update(bytes int64, values uint64) {
+	r.updates++
+	if r.updates%progressCheckEvery != 0 {
+		return
+	}
+	now := time.Now()
+	est := r.estimator.Update(bytes, values, now)
+	if now.Sub(r.lastReport) < progressInterval || r.stderr == nil {
+		return
+	}
+	r.lastReport = now
+	line := "Progress: "
+	if r.countValues {
+		line += fmt.Sprintf("%d values, ", est.Values)
+	}
+	line += pipeline.FormatByteSize(uint64(est.Bytes)) + " read"
+	if est.Fraction >= 0 {
+		line += fmt.Sprintf(" (%.0f%%)", 100*est.Fraction)
+	}
+	line += fmt.Sprintf(", %s/s", pipeline.FormatByteSize(uint64(est.BytesPerSec)))
+	if r.countValues {
+		line += fmt.Sprintf(", %.0f values/s", est.ValuesPerSec)
+	}
+	if est.Remaining >= 0 {
+		line += ", ETA " + est.Remaining.Round(time.Second).String()
+	} else if est.Fraction >= 0 {
+		line += ", ETA unknown (larger than estimated)"
+	}
+	fmt.Fprintln(r.stderr, line)
+}
+
+// finish reports the totals read and the time taken, with the estimated size
+// and the initially estimated time if there were estimates.
+func (r *progressReporter) finish(bytes int64, values uint64) {
+	est := r.estimator.Update(bytes, values, time.Now())
+	if r.stderr == nil {
+		return
+	}
+	line := "Read "
+	if r.countValues {
+		line += fmt.Sprintf("%d values, ", est.Values)
+	}
+	line += pipeline.FormatByteSize(uint64(est.Bytes))
+	if total := r.estimator.Total(); total > 0 {
+		line += fmt.Sprintf(" (estimated: %s)", pipeline.FormatByteSize(uint64(total)))
+	}
+	line += " in " + est.Elapsed.Round(time.Millisecond).String()
+	if initial, ok := r.estimator.InitialEstimate(); ok {
+		line += fmt.Sprintf(" (initially estimated: %s)", initial.Round(time.Millisecond))
+	}
+	fmt.Fprintln(r.stderr, line)
+}
@@ -0,0 +1,171 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// replPrompt is printed before each command of an interactive session if
+// standard input is a terminal.
+const replPrompt = "bloom> "
+
+const replHelp = `Commands:
+  check <value>  check a value (also any line that is not a command)
+  add <value>    add a value
+  stats          show the details of the filter
+  save           write the filter to its file
+  quit           end the session
+The value is the rest of the line after the command and a single space, so
+'check ' checks the empty value. Blank lines are ignored.
+`
+
+// repl is an interactive session on a filter stored in a file.
+type repl struct {
+	filter      *bloom.BloomFilter
+	path        string
+	output      io.Writer
+	bloomParams BloomParams
+	// unsaved is true if values were added since the filter was loaded or
+	// last saved
+	unsaved bool
+	// quitting is true if quit was refused because of unsaved changes
+	quitting bool
+}
+
+// runREPL runs an interactive session on the filter stored at path, reading
+// commands from the input and writing their results to the output, until quit
+// is entered (twice if there are unsaved changes) or the input ends. The
+// prompt is printed before each command. Values added are only stored with
+// save.
+func runREPL(filter *bloom.BloomFilter, path string, input io.Reader, output io.Writer, prompt string, bloomParams BloomParams) error {
+	r := &repl{filter: filter, path: path, output: output, bloomParams: bloomParams}
+	scanner := pipeline.NewScanner(input, bloomParams.keepCR)
+	fmt.Fprint(output, prompt)
+	for scanner.Scan() {
+		quit, err := r.execute(scanner.Text())
+		if err != nil {
+			return err
+		}
+		if quit {
+			return nil
+		}
+		fmt.Fprint(output, prompt)
+	}
+	if r.unsaved {
+		fmt.Fprintln(output, "Warning: discarding unsaved changes")
+	}
+	return scanner.Err()
+}
+
+// execute executes a command line and returns true if the session ends.
+// Errors of commands are reported to the output, only errors writing to it end
+// the session.
+func (r *repl) execute(line string) (bool, error) {
+	command, value := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		command, value = line[:i], line[i+1:]
+	}
+	quitting := r.quitting
+	r.quitting = false
+	var err error
+	switch command {
+	case "":
+		if line != "" {
+			err = r.check(line)
+		}
+	case "check":
+		err = r.check(value)
+	case "add":
+		err = r.add(value)
+	case "stats":
+		err = writeStats(r.output, r.path, r.filter)
+	case "save":
+		err = r.save()
+	case "help":
+		_, err = io.WriteString(r.output, replHelp)
+	case "quit", "exit":
+		if !r.unsaved || quitting {
+			return true, nil
+		}
+		r.quitting = true
+		_, err = fmt.Fprintln(r.output, "There are unsaved changes: use save to store them, or quit again to discard them.")
+	default:
+		err = r.check(line)
+	}
+	var commandErr replError
+	if errors.As(err, &commandErr) {
+		_, err = fmt.Fprintf(r.output, "Error: %s\n", commandErr.error)
+	}
+	return false, err
+}
+
+// replError is an error of a command, which does not end the session.
+type replError struct {
+	error
+}
+
+func (r *repl) check(value string) error {
+	if r.bloomParams.guardValueBytes > 0 && uint64(len(value)) > r.bloomParams.guardValueBytes {
+		return replError{bloom.ErrValueTooLarge}
+	}
+	contained, err := r.filter.TryCheck([]byte(value))
+	if err != nil {
+		return replError{err}
+	}
+	result := "no"
+	if contained {
+		result = "yes"
+	}
+	_, err = fmt.Fprintf(r.output, "%s (match score %.2f)\n", result, r.filter.MatchScore([]byte(value)))
+	return err
+}
+
+func (r *repl) add(value string) error {
+	if r.bloomParams.guardValueBytes > 0 && uint64(len(value)) > r.bloomParams.guardValueBytes {
+		return replError{bloom.ErrValueTooLarge}
+	}
+	if err := r.filter.TryAdd([]byte(value)); err != nil {
+		return replError{err}
+	}
+	r.unsaved = true
+	_, err := fmt.Fprintln(r.output, "added")
+	return err
+}
+
+func (r *repl) save() error {
+	if _, err := r.bloomParams.writeFilter(r.filter, r.path, []string{r.path}); err != nil {
+		return replError{err}
+	}
+	r.unsaved = false
+	_, err := fmt.Fprintf(r.output, "saved %s\n", r.path)
+	return err
+}
+
+// interactiveSession runs an interactive session on the filter stored at path
+// on the standard streams.
+func interactiveSession(path string, bloomParams BloomParams) error {
+	filter, err := loadFilter(path, bloomParams.gzip)
+	if err != nil {
+		return err
+	}
+	if _, ok := filter.Metadata(bloom.MetadataKeyTombstones); ok {
+		return errors.New("Interactive sessions are not supported for filters with tombstones.")
+	}
+	if err = applyValueLimit(filter, &bloomParams, false); err != nil {
+		return err
+	}
+	prompt := ""
+	if isTerminalStream(bloomParams.stdin) {
+		prompt = replPrompt
+		fmt.Fprintln(bloomParams.stdout, "Enter values to check or 'help' for a list of commands.")
+	}
+	return runREPL(filter, path, bloomParams.stdin, bloomParams.stdout, prompt, bloomParams)
+}
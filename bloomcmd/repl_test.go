@@ -0,0 +1,80 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestREPL(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	filter := testFilter("foo")
+	if err := bloom.WriteFilter(filter, path, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var output bytes.Buffer
+	input := "foo\ncheck bar\n\nadd \ncheck \nadd bar\nbar\nquit\nstats\nquit\nsave\nquit\n"
+	if err := runREPL(filter, path, strings.NewReader(input), &output, "", BloomParams{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(output.String(), "\n")
+	expected := []string{
+		"yes (match score 1.00)",
+		"no (match score ",
+		"added",
+		"yes (match score 1.00)",
+		"added",
+		"yes (match score 1.00)",
+		"There are unsaved changes",
+		"File:",
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(lines[i], prefix) {
+			t.Fatalf("line %d: expected %q, got %q", i, prefix, lines[i])
+		}
+	}
+	if !strings.HasSuffix(output.String(), "saved "+path+"\n") {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+	saved, err := bloom.LoadFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.Check([]byte("bar")) || !saved.Check([]byte("")) {
+		t.Fatal("added values not saved")
+	}
+}
+
+func TestREPLUnsaved(t *testing.T) {
+	for _, input := range []string{"add foo\nquit\nquit\ncheck foo\n", "add foo\n"} {
+		filter := testFilter()
+		var output bytes.Buffer
+		if err := runREPL(filter, "test.bloom", strings.NewReader(input), &output, "> ", BloomParams{}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(output.String(), "yes") || !strings.Contains(output.String(), "unsaved") {
+			t.Fatalf("unexpected output %q", output.String())
+		}
+		if !strings.HasPrefix(output.String(), "> added\n> ") {
+			t.Fatalf("missing prompt in %q", output.String())
+		}
+	}
+
+	var output bytes.Buffer
+	if err := runREPL(testFilter(), "test.bloom", strings.NewReader("add toolong\ncheck toolong\n"), &output, "", BloomParams{guardValueBytes: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(output.String(), "Error: ") != 2 {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+}
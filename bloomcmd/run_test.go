@@ -0,0 +1,1286 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/bloomtest"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// runCommand runs the tool with the given arguments and input and returns its
+// output and error output.
+func runCommand(input string, args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := Run(append([]string{"bloom"}, args...), strings.NewReader(input), &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// mustRun runs the tool like runCommand and fails if it returns an error.
+func mustRun(t *testing.T, input string, args ...string) string {
+	stdout, stderr, err := runCommand(input, args...)
+	if err != nil {
+		t.Fatalf("%v: %s (error output: %q)", args, err, stderr)
+	}
+	return stdout
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRunCreateInsertCheck(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+
+	mustRun(t, "foo\nbar\n", "create", "-n", "1000", "-p", "0.001", path)
+	if output := mustRun(t, "", "--gzip", "create", filepath.Join(dir, "empty.bloom")); output != "" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	_, stderr, err := runCommand("baz\r\n", "insert", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "Elements present:\t2") || !strings.Contains(stderr, "Warning: stripped trailing CR") {
+		t.Fatalf("unexpected error output %q", stderr)
+	}
+	filter, err := bloom.LoadFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.NumElements() != 3 {
+		t.Fatalf("expected 3 elements, got %d", filter.NumElements())
+	}
+
+	output := mustRun(t, "foo\nqux\nbaz\n", "check", path)
+	if output != "foo\nbaz\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	output = mustRun(t, "x,foo\nbar,y\nx,y\n", "-s", "--fields", "1", "check", path)
+	if output != "x,foo\n" {
+		t.Fatalf("unexpected output with fields %q", output)
+	}
+}
+
+func TestRunJoin(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.bloom")
+	pathToAdd := filepath.Join(dir, "b.bloom")
+	mustRun(t, "foo\n", "create", path)
+	mustRun(t, "bar\n", "create", pathToAdd)
+
+	mustRun(t, "", "join", path, pathToAdd)
+	if output := mustRun(t, "foo\nbar\nbaz\n", "check", path); output != "foo\nbar\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := mustRun(t, "foo\nbar\n", "check", pathToAdd); output != "bar\n" {
+		t.Fatalf("joined filter changed: unexpected output %q", output)
+	}
+
+	mustRun(t, "", "create", "-n", "100", pathToAdd)
+	if _, _, err := runCommand("", "join", path, pathToAdd); err == nil {
+		t.Fatal("expected an error joining filters of different sizes")
+	}
+}
+
+func TestRunMergeDaemon(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	spool := filepath.Join(dir, "spool")
+	if err := os.Mkdir(spool, 0755); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "merged.bloom")
+	mustRun(t, "foo\n", "create", filepath.Join(spool, "a.bloom"))
+	mustRun(t, "bar\n", "create", filepath.Join(spool, "b.bloom"))
+	mustRun(t, "baz\n", "create", "-n", "10", filepath.Join(spool, "c.bloom"))
+	for _, name := range []string{"a.bloom.done", "c.bloom.done"} {
+		if err := ioutil.WriteFile(filepath.Join(spool, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, stderr, err := runCommand("", "merge-daemon", "--spool", spool, "--output", output, "--once")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "INFO merged spool file path="+filepath.Join(spool, "a.bloom")+" digest=") ||
+		!strings.Contains(stderr, "WARN rejected spool file path="+filepath.Join(spool, "c.bloom")) {
+		t.Fatalf("unexpected log %q", stderr)
+	}
+	// b.bloom has no marker, so a single scan does not merge it
+	if output := mustRun(t, "foo\nbar\nbaz\n", "check", output); output != "foo\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if _, err := os.Stat(filepath.Join(spool, "archive", "a.bloom")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(spool, "failed", "c.bloom")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"merge-daemon", "--spool", spool},
+		{"merge-daemon", "--spool", spool, "--output", output, "--interval", "0s"},
+		{"merge-daemon", "--spool", spool, "--output", output, "--pattern", "[", "--once"},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunLayout(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "--gzip", "create", "-n", "1000", "-p", "0.01", path)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(gz); err != nil {
+		t.Fatal(err)
+	}
+
+	var layout bloom.FormatLayout
+	if err := json.Unmarshal([]byte(mustRun(t, "", "layout", "--json", path)), &layout); err != nil {
+		t.Fatal(err)
+	}
+	if layout.Size() != int64(buf.Len()) {
+		t.Fatalf("layout of %d bytes, expected %d", layout.Size(), buf.Len())
+	}
+	bits, ok := layout.Region("bits")
+	if !ok || bits.End() > int64(buf.Len()) {
+		t.Fatalf("unexpected layout %+v", layout)
+	}
+	stdout := mustRun(t, "", "layout", path)
+	if !strings.Contains(stdout, fmt.Sprintf("%-16s %12d %12d %12d\n", "bits", bits.Offset, bits.Length, bits.End())) {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+	if _, _, err := runCommand("", "layout"); err == nil {
+		t.Fatal("expected an error without a filename")
+	}
+}
+
+func TestRunJoinPolicies(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.bloom")
+	pathToAdd := filepath.Join(dir, "b.bloom")
+	write := func(path, value, data, source string, extra ...string) {
+		filter, err := bloom.New(1000, 0.01)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter.Add([]byte(value))
+		filter.SetData([]byte(data))
+		filter.SetMetadata("source", source)
+		for i := 0; i+1 < len(extra); i += 2 {
+			filter.SetMetadata(extra[i], extra[i+1])
+		}
+		if err := bloom.WriteFilter(filter, path, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		args     []string
+		data     string
+		source   string
+		expected string
+	}{
+		{nil, "feed-a", "a", "Kept the data of " + path + ", dropped that of " + pathToAdd + " (6 bytes).\n" +
+			"Copied metadata from " + pathToAdd + ": manifest.\nKept the metadata of " + path + " for conflicting keys: source.\n"},
+		{[]string{"--data-policy", "other", "--metadata-policy", "other"}, "feed-b", "b", "Replaced the data of " + path + " with that of " + pathToAdd + " (6 bytes).\n" +
+			"Copied metadata from " + pathToAdd + ": manifest.\nKept the metadata of " + pathToAdd + " for conflicting keys: source.\n"},
+		{[]string{"--data-policy", "concat", "--data-separator", "; "}, "feed-a; feed-b", "a", "Concatenated the data of " + path + " and " + pathToAdd + " (14 bytes).\n" +
+			"Copied metadata from " + pathToAdd + ": manifest.\nKept the metadata of " + path + " for conflicting keys: source.\n"},
+	} {
+		write(path, "foo", "feed-a", "a")
+		write(pathToAdd, "bar", "feed-b", "b", "manifest", "feed-b.json")
+		output := mustRun(t, "", append(append([]string{"join"}, c.args...), path, pathToAdd)...)
+		if output != c.expected {
+			t.Fatalf("%v: unexpected output %q", c.args, output)
+		}
+		filter, err := bloom.LoadFilter(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source, _ := filter.Metadata("source")
+		manifest, _ := filter.Metadata("manifest")
+		if string(filter.GetData()) != c.data || source != c.source || manifest != "feed-b.json" || !filter.Check([]byte("bar")) {
+			t.Fatalf("%v: unexpected data %q or metadata %q, %q", c.args, filter.GetData(), source, manifest)
+		}
+	}
+
+	// a conflict leaves both files untouched
+	write(path, "foo", "feed-a", "a")
+	write(pathToAdd, "bar", "feed-b", "b")
+	digests := []string{fileSHA256(t, path), fileSHA256(t, pathToAdd)}
+	_, _, err := runCommand("", "join", "--metadata-policy", "error", path, pathToAdd)
+	if err == nil || !strings.Contains(err.Error(), "source") {
+		t.Fatalf("expected a conflict for source, got %v", err)
+	}
+	if fileSHA256(t, path) != digests[0] || fileSHA256(t, pathToAdd) != digests[1] {
+		t.Fatal("a filter was modified despite the conflict")
+	}
+
+	for _, args := range [][]string{
+		{"--data-policy", "first"},
+		{"--metadata-policy", "first"},
+		{"--data-separator", ","},
+	} {
+		if _, _, err := runCommand("", append(append([]string{"join"}, args...), path, pathToAdd)...); err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunProducer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a.bloom")
+	pathToAdd := filepath.Join(dir, "b.bloom")
+	producer := "bloom " + newApp(streams{}).Version + " (DCSO/bloom " + bloom.Version + ")"
+
+	// filters without metadata remain in version 1 unless asked for
+	for _, c := range []struct {
+		args     []string
+		producer string
+	}{
+		{[]string{"create"}, bloom.UnknownProducer},
+		{[]string{"create", "--comment", "feed"}, producer},
+		{[]string{"--record-producer", "create"}, producer},
+	} {
+		mustRun(t, "foo\n", append(c.args, path)...)
+		filter, err := bloom.LoadFilter(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filter.Producer() != c.producer {
+			t.Fatalf("%v: unexpected producer %q", c.args, filter.Producer())
+		}
+	}
+	mustRun(t, "bar\n", "--record-producer", "create", pathToAdd)
+	if output := mustRun(t, "", "show", path); !strings.Contains(output, "Producer:\t\t"+producer+"\n") {
+		t.Fatalf("producer missing from %q", output)
+	}
+
+	mustRun(t, "", "join", path, pathToAdd)
+	if output := mustRun(t, "", "show", path); !strings.Contains(output, "Joined producers:\t"+producer+"\n") {
+		t.Fatalf("joined producers missing from %q", output)
+	}
+}
+
+func TestRunComment(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "a.bloom")
+	mustRun(t, "foo\n", "create", "-n", "1000", "--comment", "example feed", path)
+	output := mustRun(t, "", "show", path)
+	if !strings.Contains(output, "Comment:\t\tDCSO bloom filter v2, n=1000, p=0.01, built ") ||
+		!strings.Contains(output, ", see "+bloom.ProjectURL+": example feed\n") {
+		t.Fatalf("comment missing from %q", output)
+	}
+
+	// the comment is kept by commands modifying the filter
+	mustRun(t, "bar\n", "insert", path)
+	if modified := mustRun(t, "", "show", path); !strings.Contains(modified, ": example feed\n") {
+		t.Fatalf("comment missing from %q", modified)
+	}
+
+	// filters created without --comment have none
+	mustRun(t, "foo\n", "create", path)
+	if output := mustRun(t, "", "show", path); strings.Contains(output, "Comment:") {
+		t.Fatalf("unexpected comment in %q", output)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	for _, args := range [][]string{
+		{"create"},
+		{"create", "-p", "2", path},
+		{"insert", path},
+		{"check", path},
+		{"check", "--match", "some", path},
+		{"--tuple-fields", "0", "--each", "check", path},
+		{"join", path},
+		{"--fields", "x", "create", path},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("no filter should have been written")
+	}
+
+	var stderr bytes.Buffer
+	if code := Main([]string{"bloom", "check", path}, strings.NewReader(""), ioutil.Discard, &stderr); code == 0 {
+		t.Fatal("expected a non-zero exit code")
+	}
+	if !strings.HasPrefix(stderr.String(), "Error: ") {
+		t.Fatalf("unexpected error output %q", stderr.String())
+	}
+}
+
+func TestRunUnsupportedVersion(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\n", "create", path)
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[bloom.FormatFlagsOffset] = 3
+	if err := ioutil.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = runCommand("foo\n", "check", path)
+	if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "upgrade") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRunCheckTemplate(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+
+	templatePath := filepath.Join(dir, "template.txt")
+	if err := ioutil.WriteFile(templatePath, []byte("{{.Line}}={{json .Value}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if output := mustRun(t, "x,foo\nbar,y\n", "-s", "-e", "check", "--template-file", templatePath, path); output != "1=\"foo\"\n2=\"bar\"\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	for _, args := range [][]string{
+		{"check", "--template", "{{.Value", path},
+		{"check", "--template", "{{.Unknown}}", path},
+		{"check", "--template", "{{.Value}}", "--json", path},
+		{"check", "--template", "{{.Value}}", "--template-file", templatePath, path},
+		{"check", "--template-file", filepath.Join(dir, "missing"), path},
+	} {
+		if _, _, err := runCommand("foo\n", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunCheckInputs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+	for name, content := range map[string]string{"dns": "foo\nbaz\n", "http": "qux\nbar\n"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	output := mustRun(t, "", "check", "--input", filepath.Join(dir, "dns"), "--input", filepath.Join(dir, "http"), path)
+	if output != "dns\tfoo\nhttp\tbar\n" && output != "http\tbar\ndns\tfoo\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	mustRun(t, "", "check", "--input", filepath.Join(dir, "http"), "--output-template", filepath.Join(dir, "hits-{name}.txt"), path)
+	if hits, err := ioutil.ReadFile(filepath.Join(dir, "hits-http.txt")); err != nil || string(hits) != "bar\n" {
+		t.Fatalf("unexpected output file %q (%v)", hits, err)
+	}
+	if _, _, err := runCommand("", "check", "--follow", path); err == nil {
+		t.Fatal("expected an error for --follow without --input")
+	}
+}
+
+func TestRunExtract(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "extracted.bloom")
+	mustRun(t, "", "extract", "--json-path", "Event.Attribute.1.data", "../testdata/misp-event.json", path)
+	extracted, err := bloom.LoadFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := bloom.LoadFilter("../testdata/test.bloom", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < expected.NumWords(); i++ {
+		if extracted.WordAt(i) != expected.WordAt(i) {
+			t.Fatalf("extracted filter differs at word %d", i)
+		}
+	}
+	_, _, err = runCommand("", "extract", "--json-path", "Event.Attribute.2.data", "../testdata/misp-event.json", path)
+	if err == nil || !strings.Contains(err.Error(), "not a Bloom filter") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestRunCreateFromDir(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	tree := filepath.Join(dir, "tree")
+	if err := os.MkdirAll(filepath.Join(tree, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(tree, "sub", "a.txt"), []byte("alpha\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(tree, "dangling")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.bloom")
+
+	for value, expected := range map[string]string{
+		"sha256":  "b6a98d9ce9a2d9149288fa3df42d377c3e42737afdcdaf714e33c0a100b51060",
+		"name":    "a.txt",
+		"relpath": "sub/a.txt",
+	} {
+		output := mustRun(t, "", "create", "--from-dir", tree, "--value", value, path)
+		if output != "Added 1 values from 1 files (capacity 10000).\n" {
+			t.Fatalf("%s: unexpected output %q", value, output)
+		}
+		if output = mustRun(t, expected+"\nx\n", "check", path); output != expected+"\n" {
+			t.Fatalf("%s: unexpected output %q", value, output)
+		}
+	}
+
+	if _, _, err := runCommand("", "create", "--from-dir", tree, "--value", "size", path); err == nil {
+		t.Fatal("expected an error for an invalid value")
+	}
+	if _, _, err := runCommand("", "create", "--from-dir", tree, "--from", path, path); err == nil {
+		t.Fatal("expected an error for --from with --from-dir")
+	}
+}
+
+func TestRunCheckUnique(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+
+	input := "foo\nx\nbar\nfoo\nfoo\n"
+	if output := mustRun(t, input, "check", "--unique", path); output != "foo\nbar\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := mustRun(t, input, "check", "--unique", "--count", path); output != "      1 bar\n      3 foo\n" {
+		t.Fatalf("unexpected output with counts %q", output)
+	}
+	if _, _, err := runCommand(input, "check", "--count", path); err == nil {
+		t.Fatal("expected an error for --count without --unique")
+	}
+}
+
+func TestRunCheckSample(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+
+	stdout, stderr, err := runCommand("foo\nx\nbar\ny\n", "check", "--sample-rate", "1", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "foo\nbar\n" {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+	for _, expected := range []string{
+		"Sampled 4 of 4 lines read (100.00%), of which 2 were reported.",
+		"Estimated reported lines: 2 (95% confidence interval: ",
+		"Estimated match rate: 50.00% (95% confidence interval: 15.00% to 85.00%)",
+	} {
+		if !strings.Contains(stderr, expected) {
+			t.Fatalf("missing %q in %q", expected, stderr)
+		}
+	}
+
+	// the same seed selects the same lines
+	var outputs []string
+	for i := 0; i < 2; i++ {
+		outputs = append(outputs, mustRun(t, strings.Repeat("foo\nbar\n", 100), "check", "--sample-rate", "0.1", "--sample-seed", "7", path))
+	}
+	if outputs[0] != outputs[1] || strings.Count(outputs[0], "\n") > 100 {
+		t.Fatalf("unexpected outputs %q", outputs)
+	}
+	if _, _, err := runCommand("", "check", "--sample-rate", "2", path); err == nil {
+		t.Fatal("expected an error for an invalid sample rate")
+	}
+}
+
+func TestRunCheckSummary(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+
+	stdout, stderr, err := runCommand("foo\nx\nbar\ny\n", "check", "--summary", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "foo\nbar\n" {
+		t.Fatalf("unexpected output %q", stdout)
+	}
+	for _, expected := range []string{
+		"Checked 4 lines, of which 2 were reported.",
+		"Estimated false positives among the reported lines: 0.0",
+		"Estimated true matches: 2 (95% confidence interval: 2 to 2)",
+	} {
+		if !strings.Contains(stderr, expected) {
+			t.Fatalf("missing %q in %q", expected, stderr)
+		}
+	}
+	if _, stderr, err = runCommand("foo\nx\n", "check", "--summary", "--json", path); err != nil || !strings.Contains(stderr, `{"lines":2,"reported":1,`) {
+		t.Fatalf("unexpected JSON summary %q (%v)", stderr, err)
+	}
+	if _, _, err := runCommand("", "check", "--summary", "--invert-match", path); err == nil {
+		t.Fatal("expected an error for --summary with --invert-match")
+	}
+}
+
+func TestRunCheckWebhook(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", path)
+
+	var mu sync.Mutex
+	var events []pipeline.MatchEvent
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []pipeline.MatchEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, batch...)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	stdout, stderr, err := runCommand("foo\nx\nbar\n", "check", "--webhook-url", server.URL, "--webhook-header", "Authorization: Bearer token", "--webhook-batch-size", "1", path)
+	if err != nil || stdout != "foo\nbar\n" || stderr != "" {
+		t.Fatalf("unexpected output %q, %q (%v)", stdout, stderr, err)
+	}
+	if len(events) != 2 || events[0].Value != "foo" || events[0].Line != 1 || events[1].Value != "bar" || events[1].Line != 3 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	if events[0].Filter != "test.bloom" || auth != "Bearer token" {
+		t.Errorf("unexpected filter %q or authorization %q", events[0].Filter, auth)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer failing.Close()
+	_, stderr, err = runCommand("foo\n", "check", "--webhook-url", failing.URL, path)
+	if err != nil || !strings.Contains(stderr, "Warning: 1 of 1 matches were not posted to the webhook") {
+		t.Fatalf("unexpected warnings %q (%v)", stderr, err)
+	}
+
+	if _, _, err := runCommand("", "check", "--webhook-header", "Authorization: x", path); err == nil {
+		t.Fatal("expected an error for --webhook-header without --webhook-url")
+	}
+	if _, _, err := runCommand("", "check", "--webhook-url", "ftp://example.com", path); err == nil {
+		t.Fatal("expected an error for an invalid webhook URL")
+	}
+}
+
+func TestRunCheckRecordedSettings(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "a\tb\tc\tfoo\n", "-s", "-d", "\t", "-f", "3", "create", path)
+	if output := mustRun(t, "", "show", path); !strings.Contains(output, "Input:\t\t\tsplit at \"\\t\", fields 3\n") {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	stdout, stderr, err := runCommand("x\ty\tz\tfoo\n", "-s", "-d", "\t", "-f", "2", "check", path)
+	if err != nil || stdout != "" || stderr != "" {
+		t.Fatalf("unexpected result %q, %q, %v", stdout, stderr, err)
+	}
+	stdout, stderr, err = runCommand("x,y,z,foo\n", "-s", "-f", "3", "check", path)
+	if err != nil || stdout != "x,y,z,foo\n" || !strings.Contains(stderr, `Warning: the filter was built with split at "\t" instead of ","`) {
+		t.Fatalf("unexpected result %q, %q, %v", stdout, stderr, err)
+	}
+	if _, _, err = runCommand("x,y,z,foo\n", "-s", "-f", "3", "check", "--strict-settings", path); err == nil || !strings.Contains(err.Error(), "--ignore-recorded-settings") {
+		t.Fatalf("expected a settings error, got %v", err)
+	}
+	if _, stderr, err = runCommand("x,y,z,foo\n", "-s", "-f", "3", "check", "--ignore-recorded-settings", path); err != nil || stderr != "" {
+		t.Fatalf("unexpected result %q, %v", stderr, err)
+	}
+
+	// filters built from whole lines have no recorded settings
+	plain := filepath.Join(dir, "plain.bloom")
+	mustRun(t, "foo\n", "create", plain)
+	filter, err := bloom.LoadFilter(plain, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := filter.RecordedInputSettings(); ok || err != nil {
+		t.Fatalf("unexpected metadata %v (%v)", filter.MetadataKeys(), err)
+	}
+	if stdout, stderr, err = runCommand("x,foo\n", "-s", "check", "--strict-settings", plain); err != nil || stdout != "x,foo\n" || stderr != "" {
+		t.Fatalf("unexpected result %q, %q, %v", stdout, stderr, err)
+	}
+}
+
+func TestRunCompact(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "big.bloom")
+	compactedPath := filepath.Join(dir, "compacted.bloom")
+	valuesPath := filepath.Join(dir, "values.txt")
+	var values strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&values, "value-%d\n", i)
+	}
+	if err := ioutil.WriteFile(valuesPath, []byte(values.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mustRun(t, values.String(), "create", "-n", "100000", "-p", "0.001", path)
+
+	output := mustRun(t, "", "compact", "--values", valuesPath, "-n", "200", path, compactedPath)
+	if !strings.Contains(output, "Compacted:\tcapacity 200, ") || !strings.Contains(output, "FP probability 1.00e-03") || !strings.Contains(output, "Values:\t\t100\n") {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if output := mustRun(t, values.String(), "check", compactedPath); output != values.String() {
+		t.Fatalf("values missing from the compacted filter: %q", output)
+	}
+
+	if _, _, err := runCommand("", "compact", "--values", valuesPath, "-n", "200", compactedPath, path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(valuesPath, []byte("value-1\nother\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := runCommand("", "compact", "--values", valuesPath, "-n", "200", path, compactedPath); err == nil || !strings.Contains(err.Error(), "not those of the filter") {
+		t.Fatalf("expected an error for values not in the filter, got %v", err)
+	}
+	if _, _, err := runCommand("", "compact", "--values", valuesPath, path, compactedPath); err == nil {
+		t.Fatal("expected an error without a capacity")
+	}
+}
+
+func TestRunThrottled(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "foo\nbar\n", "create", "--max-rate", "20MB/s", "--nice-io", path)
+	mustRun(t, "baz\n", "insert", "--max-rate", "1000000", path)
+	if output := mustRun(t, "foo\nqux\nbaz\n", "check", "--max-rate", "1000000/s", "--nice-io", path); output != "foo\nbaz\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if _, _, err := runCommand("foo\n", "check", "--max-rate", "fast", path); err == nil || !strings.Contains(err.Error(), "--max-rate") {
+		t.Fatalf("expected an error for an invalid rate, got %v", err)
+	}
+	if _, _, err := runCommand("", "create", "--from", path, "--max-rate", "10", filepath.Join(dir, "other.bloom")); err == nil {
+		t.Fatal("expected an error for --max-rate with --from")
+	}
+}
+
+func TestRunReportDuplicates(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	reportPath := filepath.Join(dir, "dup.txt")
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "value-%d\n", i)
+		// every tenth value is repeated, value-0 once more
+		if i%10 == 0 {
+			fmt.Fprintf(&input, "value-%d\n", i)
+		}
+	}
+	input.WriteString("value-0\n")
+	mustRun(t, input.String(), "create", "-n", "10000", "-p", "0.0001", "--report-duplicates", reportPath, path)
+	report, err := ioutil.ReadFile(reportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(report), "      3 value-0\n") {
+		t.Fatalf("unexpected report %q", report)
+	}
+	for i := 10; i < 1000; i += 10 {
+		if !strings.Contains(string(report), fmt.Sprintf("      2 value-%d\n", i)) {
+			t.Fatalf("duplicate value-%d missing from the report %q", i, report)
+		}
+	}
+
+	mustRun(t, "value-1\nnew\n", "insert", "--report-duplicates", reportPath, path)
+	if report, err := ioutil.ReadFile(reportPath); err != nil || string(report) != "      2 value-1\n" {
+		t.Fatalf("unexpected report %q (%v)", report, err)
+	}
+	if _, _, err := runCommand("", "create", "--from", reportPath, "--report-duplicates", reportPath, path); err == nil {
+		t.Fatal("expected an error for --report-duplicates with --from")
+	}
+	if _, _, err := runCommand("", "insert", "--report-duplicates", filepath.Join(dir, "missing", "dup.txt"), path); err == nil {
+		t.Fatal("expected an error for a report that cannot be created")
+	}
+}
+
+func TestRunDecode(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	var digests [][]byte
+	var hexInput, base64Input strings.Builder
+	for i := 0; i < 100; i++ {
+		digest := sha256.Sum256([]byte(fmt.Sprintf("file-%d", i)))
+		digests = append(digests, digest[:])
+		fmt.Fprintln(&hexInput, hex.EncodeToString(digest[:]))
+		fmt.Fprintln(&base64Input, base64.StdEncoding.EncodeToString(digest[:]))
+	}
+	mustRun(t, hexInput.String(), "create", "--decode", "hex", path)
+
+	// the raw digests are in the filter, not their hex encoding
+	filter, err := bloom.LoadFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, digest := range digests {
+		if !filter.Check(digest) {
+			t.Fatalf("raw digest %x missing from the filter", digest)
+		}
+	}
+	if filter.Check([]byte(hex.EncodeToString(digests[0]))) {
+		t.Fatal("hex encoding of a digest found in the filter")
+	}
+	if output := mustRun(t, "", "show", path); !strings.Contains(output, "Input:\t\t\twhole lines, decoding hex\n") {
+		t.Fatalf("decoding missing from %q", output)
+	}
+
+	if output := mustRun(t, base64Input.String(), "check", "--decode", "base64", "--ignore-recorded-settings", path); output != base64Input.String() {
+		t.Fatalf("unexpected output %q", output)
+	}
+	expected := hex.EncodeToString(digests[1]) + "\n"
+	if output := mustRun(t, "x\n00,"+expected, "--split", "--each", "check", "--decode", "hex", "--skip-invalid", path); output != expected {
+		t.Fatalf("unexpected output %q", output)
+	}
+	expected = base64.StdEncoding.EncodeToString(digests[1]) + "\n"
+	if output := mustRun(t, "00,"+hex.EncodeToString(digests[1])+"\n", "--split", "--each", "check", "--decode", "hex", "--encode", "base64", path); output != expected {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	// invalid values fail the command, or are skipped and counted
+	if _, _, err := runCommand("00\nnot hex\n", "insert", "--decode", "hex", path); err == nil || !strings.Contains(err.Error(), "line 2: invalid hex value") {
+		t.Fatalf("expected an error for line 2, got %v", err)
+	}
+	if _, _, err := runCommand(hexInput.String()+"zz\n", "check", "--decode", "hex", path); err == nil {
+		t.Fatal("expected an error for an invalid value")
+	}
+	_, stderr, err := runCommand("00\nnot hex\n", "insert", "--decode", "hex", "--skip-invalid", path)
+	if err != nil || !strings.Contains(stderr, "skipped 1 lines with values that are not valid hex") {
+		t.Fatalf("unexpected result %q, %v", stderr, err)
+	}
+	if _, stderr, err := runCommand(hexInput.String(), "check", path); err != nil || !strings.Contains(stderr, "decoding hex instead of none") {
+		t.Fatalf("expected a warning about the recorded decoding, got %q, %v", stderr, err)
+	}
+	for _, args := range [][]string{
+		{"check", "--decode", "rot13", path},
+		{"check", "--encode", "hex", path},
+		{"check", "--skip-invalid", path},
+		{"--tuple-fields", "0,1", "-s", "check", "--decode", "hex", path},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	expected := "" +
+		"      p          n    m (bits)   k  file size  gzip size (approx.)\n" +
+		"    0.1  500000000  2396264594   4  285.7 MiB            282.1 MiB\n" +
+		"  0.001  500000000  7188793783  10  857.0 MiB            857.0 MiB\n"
+	if output := mustRun(t, "", "plan", "-n", "5e8", "-p", "0.1,0.001"); output != expected {
+		t.Fatalf("unexpected table %q", output)
+	}
+	expected = `{"capacity":875135,"fpp":0.01,"bits":8388220,"hash_funcs":7,"file_size":1048576,"gzip_size_estimate":1047588}` + "\n"
+	if output := mustRun(t, "", "plan", "--size", "1MiB", "-p", "0.01", "--json"); output != expected {
+		t.Fatalf("unexpected JSON %q", output)
+	}
+	if output := mustRun(t, "", "plan", "-n", "1000"); strings.Count(output, "\n") != 7 {
+		t.Fatalf("expected a row for each default probability, got %q", output)
+	}
+	for _, args := range [][]string{
+		{"plan"},
+		{"plan", "-n", "1000", "--size", "1MB"},
+		{"plan", "-n", "0.5"},
+		{"plan", "--size", "12XB"},
+		{"plan", "-n", "1000", "-p", "1"},
+		{"plan", "--size", "10"},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunHashFuncsCap(t *testing.T) {
+	output := mustRun(t, "", "plan", "-n", "1000", "-p", "1e-12")
+	if !strings.Contains(output, "Warning: p = 1e-12 needs 40 hash functions, capped at 30") {
+		t.Fatalf("expected a warning, got %q", output)
+	}
+	if output = mustRun(t, "", "plan", "-n", "1000", "-p", "1e-12", "--json"); !strings.Contains(output, `"hash_funcs":30,`) || !strings.Contains(output, `"warning":"p = 1e-12`) {
+		t.Fatalf("unexpected JSON %q", output)
+	}
+
+	path := filepath.Join(tempDir(t), "test.bloom")
+	defer os.RemoveAll(filepath.Dir(path))
+	_, stderr, err := runCommand("foo\n", "create", "-n", "1000", "-p", "1e-12", path)
+	if err != nil || !strings.Contains(stderr, "Warning: p = 1e-12 needs 40 hash functions") {
+		t.Fatalf("expected a warning, got %q (%v)", stderr, err)
+	}
+	if output = mustRun(t, "", "show", path); !strings.Contains(output, "Hash functions:\t\t30\nWarning:") {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if _, stderr, err = runCommand("foo\n", "create", "-n", "1000", "-p", "1e-12", "--max-hash-funcs", "0", path); err != nil || stderr != "" {
+		t.Fatalf("unexpected warning %q (%v)", stderr, err)
+	}
+	if _, _, err = runCommand("foo\n", "create", "-n", "1000", "-p", "1e-12", "--strict-hash-funcs", path); err == nil {
+		t.Fatal("expected an error with --strict-hash-funcs")
+	}
+}
+
+func TestRunVerifyInterop(t *testing.T) {
+	output := mustRun(t, "", "--gzip", "verify-interop", "--json", "--values", "../testdata/interop/v1-data.values", "../testdata/interop/v1-data.bloom.gz")
+	var report interopReport
+	if err := json.Unmarshal([]byte(output), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.HashScheme != bloom.HashSchemeFNV1 || report.Values != 1505 || report.ExpectedHits != 505 || report.mismatches() != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	values := filepath.Join(dir, "values.txt")
+	if err := ioutil.WriteFile(values, []byte("# comment\n+interop-0\n-interop-1\n+absent\n-probe-0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, _, err := runCommand("", "verify-interop", "--values", values, "../testdata/interop/v1.bloom")
+	if err == nil || err.Error() != "2 of 4 values gave unexpected results." {
+		t.Fatalf("expected an error for the mismatches, got %v", err)
+	}
+	if !strings.Contains(stdout, "Unexpected miss:\t\"absent\"\n") || !strings.Contains(stdout, "Unexpected hit:\t\t\"interop-1\"\n") {
+		t.Fatalf("unexpected report %q", stdout)
+	}
+
+	// a different hash scheme is named instead of giving wrong answers
+	stdout, _, err = runCommand("", "verify-interop", "--json", "--values", "../testdata/interop/hash-scheme-2.values", "../testdata/interop/hash-scheme-2.bloom")
+	if err == nil || !strings.Contains(err.Error(), "unsupported hash scheme 2") {
+		t.Fatalf("expected an unsupported hash scheme, got %v", err)
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil || report.HashScheme != 2 || report.Error == "" {
+		t.Fatalf("unexpected report %q (%v)", stdout, err)
+	}
+
+	if err := ioutil.WriteFile(values, []byte("interop-0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"verify-interop", "--values", values},
+		{"verify-interop", "../testdata/interop/v1.bloom"},
+		{"verify-interop", "--values", values, "../testdata/interop/v1.bloom"},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunBenchCompression(t *testing.T) {
+	args := []string{"bench", "--compression", "--fill", "0.01,0.5", "--filter-bits", "100000", "--rounds", "1"}
+	lines := strings.Split(strings.TrimSuffix(mustRun(t, "", args...), "\n"), "\n")
+	if len(lines) != 1+2*len(bloomtest.Compressions) {
+		t.Fatalf("unexpected table %q", lines)
+	}
+	if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "fill m (bits) compression size compressed ratio compress MiB/s decompress MiB/s" {
+		t.Fatalf("unexpected header %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 10 || fields[0] != "0.01" || fields[2] != "none" || fields[7] != "1.000" {
+		t.Fatalf("unexpected row %q", lines[1])
+	}
+
+	output := mustRun(t, "", append(args, "--json")...)
+	var results []map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(output))
+	for decoder.More() {
+		var result map[string]interface{}
+		if err := decoder.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if len(results) != 2*len(bloomtest.Compressions) {
+		t.Fatalf("unexpected number of results in %q", output)
+	}
+	for _, field := range []string{"compression", "fill_ratio", "filter_bits", "size", "compressed_size", "ratio", "compress_mb_per_sec", "decompress_mb_per_sec"} {
+		if _, ok := results[0][field]; !ok {
+			t.Fatalf("missing field %s in %v", field, results[0])
+		}
+	}
+	if results[1]["compression"] != "gzip-1" || results[1]["filter_bits"].(float64) != 100000 {
+		t.Fatalf("unexpected result %v", results[1])
+	}
+
+	for _, args := range [][]string{
+		{"bench"},
+		{"bench", "--compression", "--compare-hashes"},
+		{"bench", "--compression", "--fill", "1"},
+		{"bench", "--compression", "--rounds", "0"},
+		{"bench", "--compression", "--filter-bits", "0"},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunLocked(t *testing.T) {
+	dir := tempDir(t)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "", "create", path)
+
+	lock, err := bloom.LockFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"insert", path},
+		{"join", path, path},
+		{"set-data", path},
+		{"delete", path},
+	} {
+		_, _, err := runCommand("foo\n", args...)
+		if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("held by process %d", os.Getpid())) || !strings.Contains(err.Error(), "--wait-lock") {
+			t.Fatalf("%v: expected an error naming the lock holder, got %v", args, err)
+		}
+	}
+	mustRun(t, "foo\n", "insert", "--no-lock", path)
+
+	// concurrent inserts waiting for the lock keep each other's values
+	errs := make(chan error, 2)
+	for _, value := range []string{"bar", "baz"} {
+		go func(value string) {
+			_, _, err := runCommand(value+"\n", "insert", "--wait-lock", "1m", path)
+			errs <- err
+		}(value)
+	}
+	time.Sleep(100 * time.Millisecond)
+	lock.Unlock()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if output := mustRun(t, "foo\nbar\nbaz\nqux\n", "check", path); output != "foo\nbar\nbaz\n" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if _, _, err := runCommand("", "insert", "--no-lock", "--wait-lock", "1s", path); err == nil {
+		t.Fatal("expected an error for --wait-lock with --no-lock")
+	}
+}
+
+func TestRunPrecheck(t *testing.T) {
+	dir := tempDir(t)
+	mustRun(t, "foo\n", "create", "-n", "1000", filepath.Join(dir, "a.bloom"))
+	mustRun(t, "bar\n", "--gzip", "create", "-n", "1000", filepath.Join(dir, "b.bloom.gz"))
+	mustRun(t, "baz\n", "create", "-n", "2000", filepath.Join(dir, "c.bloom"))
+	remote, err := ioutil.ReadFile(filepath.Join(dir, "a.bloom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a server without support for range requests
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(remote)
+	}))
+	defer server.Close()
+	writeManifest := func(entries string) string {
+		path := filepath.Join(dir, "manifest.json")
+		if err := ioutil.WriteFile(path, []byte(`{"filters": [`+entries+`]}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	path := writeManifest(`{"name": "a", "source": "a.bloom"}, {"name": "b", "source": "b.bloom.gz", "compression": "gzip"},
+		{"name": "remote", "source": "` + server.URL + `"}`)
+	output := mustRun(t, "", "precheck", path)
+	if !strings.Contains(output, "1  -   ok  ok\n") || !strings.Contains(output, "All 3 filters can be joined.\n") {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	path = writeManifest(`{"name": "a", "source": "a.bloom"}, {"name": "c", "source": "c.bloom"},
+		{"name": "remote", "source": "` + server.URL + `"}`)
+	output, _, err = runCommand("", "precheck", path)
+	if err == nil || err.Error() != "2 of the 3 pairs of filters cannot be joined." {
+		t.Fatalf("expected an error for incompatible filters, got %v", err)
+	}
+	if !strings.Contains(output, "1  -   X  ok\n2  X   -  X\n3  ok  X  -\n") || !strings.Contains(output, "a and c: n = 1000 vs. 2000") {
+		t.Fatalf("unexpected output %q", output)
+	}
+
+	path = writeManifest(`{"name": "missing", "source": "missing.bloom"}`)
+	if _, _, err := runCommand("", "precheck", path); err == nil || !strings.Contains(err.Error(), `filter "missing"`) {
+		t.Fatalf("expected an error for a missing filter, got %v", err)
+	}
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRunDryRun(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	otherPath := filepath.Join(dir, "other.bloom")
+	compactedPath := filepath.Join(dir, "compacted.bloom")
+	valuesPath := filepath.Join(dir, "values.txt")
+	logPath := filepath.Join(dir, "audit.log")
+	if err := ioutil.WriteFile(valuesPath, []byte("foo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, gzip := range []bool{false, true} {
+		var global []string
+		if gzip {
+			global = []string{"--gzip"}
+		}
+		run := func(input string, args ...string) string {
+			return mustRun(t, input, append(append(append([]string{}, global...), "--dry-run", "--log-file", logPath), args...)...)
+		}
+		// left behind by the insert of the previous round
+		os.Remove(path + bloom.LockFileSuffix)
+		mustRun(t, "foo\n", append(global, "create", "-n", "100", path)...)
+		mustRun(t, "bar\n", append(global, "create", "-n", "100", otherPath)...)
+		digest := fileSHA256(t, path)
+
+		for _, args := range [][]string{
+			{"insert", path},
+			{"join", path, otherPath},
+			{"set-data", path},
+			{"delete", path},
+		} {
+			output := run("bar\n", args...)
+			prefix := fmt.Sprintf("Dry run: would write %s (N ", path)
+			if !strings.HasPrefix(output, prefix) || !strings.Contains(output, " bytes)\n") {
+				t.Fatalf("%v: unexpected output %q", args, output)
+			}
+			if fileSHA256(t, path) != digest {
+				t.Fatalf("%v: the filter was modified", args)
+			}
+		}
+		if output := run("", "compact", "--values", valuesPath, "-n", "10", path, compactedPath); !strings.Contains(output, "new file)\n") || !strings.Contains(output, "Compacted:\tcapacity 10, ") {
+			t.Fatalf("unexpected output %q", output)
+		}
+		for _, p := range []string{compactedPath, logPath, path + bloom.LockFileSuffix} {
+			if _, err := os.Stat(p); !os.IsNotExist(err) {
+				t.Fatalf("%s was written: %v", p, err)
+			}
+		}
+
+		// the digest printed is the one of the file written
+		output := run("bar\n", "insert", path)
+		mustRun(t, "bar\n", append(global, "insert", path)...)
+		if expected := fmt.Sprintf("(N 2, SHA256 %s, ", fileSHA256(t, path)); !strings.Contains(output, expected) {
+			t.Fatalf("expected %q in %q", expected, output)
+		}
+	}
+
+	if _, _, err := runCommand("foo\n", "--dry-run", "insert", "--autosave-every", "1", path); err == nil {
+		t.Fatal("expected an error for --dry-run with autosaving")
+	}
+	if _, _, err := runCommand("", "--dry-run", "chunk", path, filepath.Join(dir, "chunks")); err == nil {
+		t.Fatal("expected an error for --dry-run with chunk")
+	}
+}
+
+func TestRunLogFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	otherPath := filepath.Join(dir, "other.bloom")
+	logPath := filepath.Join(dir, "audit.log")
+	mustRun(t, "foo\n", "create", "-n", "100", path)
+	mustRun(t, "bar\n", "create", "-n", "100", otherPath)
+	before, other := fileSHA256(t, path), fileSHA256(t, otherPath)
+
+	mustRun(t, "", "--log-file", logPath, "join", path, otherPath)
+	mustRun(t, "baz\n", "--log-file", logPath, "insert", path)
+	data, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %q", data)
+	}
+	var join, insert auditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &join); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &insert); err != nil {
+		t.Fatal(err)
+	}
+	if join.Command != "join" || strings.Join(join.Args, " ") != "--log-file "+logPath+" join "+path+" "+otherPath || join.N != 2 {
+		t.Errorf("unexpected record %+v", join)
+	}
+	if len(join.Inputs) != 2 || join.Inputs[0].SHA256 != before || join.Inputs[1].Path != otherPath || join.Inputs[1].SHA256 != other {
+		t.Errorf("unexpected inputs %+v", join.Inputs)
+	}
+	if insert.Command != "insert" || len(insert.Inputs) != 1 || insert.Inputs[0].SHA256 != join.Output.SHA256 || insert.N != 3 {
+		t.Errorf("unexpected record %+v", insert)
+	}
+	if insert.Output.Path != path || insert.Output.SHA256 != fileSHA256(t, path) || insert.Time.IsZero() {
+		t.Errorf("unexpected output %+v", insert.Output)
+	}
+}
+
+func TestRunInsertTagged(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	mustRun(t, "", "create", "-n", "1000", "-p", "0.001", path)
+	mustRun(t, "foo\nshared\n", "insert", "--tag", "feed-a", path)
+	mustRun(t, "bar\nshared\n", "insert", "--tag", "feed-b", "--tag-capacity", "50", path)
+	mustRun(t, "untagged\n", "insert", path)
+
+	filter, err := bloom.LoadTaggedFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := filter.Tags(); !reflect.DeepEqual(tags, []string{"feed-a", "feed-b"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if n := filter.Tag("feed-b").MaxNumElements(); n != 50 {
+		t.Fatalf("expected a capacity of 50 for feed-b, got %d", n)
+	}
+
+	output := mustRun(t, "foo\nbar\nshared\nuntagged\nmissing\n", "check", "--tags", path)
+	if expected := "feed-a\tfoo\nfeed-b\tbar\nfeed-a,feed-b\tshared\n-\tuntagged\n"; output != expected {
+		t.Fatalf("unexpected output %q", output)
+	}
+	output = mustRun(t, "shared\n", "check", "--json", path)
+	if !strings.Contains(output, `"tags":["feed-a","feed-b"]`) {
+		t.Fatalf("tags missing from %q", output)
+	}
+
+	for _, args := range [][]string{
+		{"insert", "--tag-capacity", "10", path},
+		{"insert", "--tag", "feed-c", "--autosave-every", "10", path},
+		{"insert", "--tag", "feed-c", "--tag-capacity", "100000000", path},
+		{"check", "--tags", "--json", path},
+	} {
+		if _, _, err := runCommand("foo\n", args...); err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunProgress(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+	valuesPath := filepath.Join(dir, "values.txt")
+	if err := ioutil.WriteFile(valuesPath, []byte("foo\nbar\nbaz\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, stderr, err := runCommand("", "create", "--progress", "--from", valuesPath, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stderr, "Read 12 B (estimated: 12 B) in ") {
+		t.Fatalf("unexpected progress output %q", stderr)
+	}
+	_, stderr, err = runCommand("qux\nquux\n", "insert", "--progress", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "Read 2 values, 9 B in ") || strings.Contains(stderr, "estimated") {
+		t.Fatalf("unexpected progress output %q", stderr)
+	}
+
+	for _, args := range [][]string{
+		{"insert", "--gzip-ratio", "3", path},
+		{"insert", "--progress", "--gzip-ratio", "0", path},
+		{"create", "--progress", "--from-dir", dir, path},
+	} {
+		if _, _, err := runCommand("", args...); err == nil {
+			t.Fatalf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestRunInsertDedupWindow(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "value-%d\n", i/10%30)
+	}
+	var digests []string
+	for _, args := range [][]string{nil, {"--dedup-window", "4"}} {
+		path := filepath.Join(dir, fmt.Sprintf("test-%d.bloom", len(digests)))
+		mustRun(t, "", "create", "-n", "100", path)
+		_, stderr, err := runCommand(input.String(), append(append([]string{"insert"}, args...), path)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (args != nil) != strings.Contains(stderr, "Dedup window: 900 hits, 100 misses\n") {
+			t.Fatalf("%v: unexpected output %q", args, stderr)
+		}
+		digests = append(digests, fileSHA256(t, path))
+	}
+	if digests[0] != digests[1] {
+		t.Fatal("the filters differ with and without the dedup window")
+	}
+	path := filepath.Join(dir, "test-0.bloom")
+	if _, _, err := runCommand("", "insert", "--dedup-window", "4", "--report-duplicates", filepath.Join(dir, "dups.txt"), path); err == nil {
+		t.Fatal("expected an error for --dedup-window with --report-duplicates")
+	}
+}
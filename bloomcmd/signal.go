@@ -0,0 +1,16 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build !plan9
+// +build !plan9
+
+package bloomcmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// terminationSignals are the signals upon which buffered output is written
+// before the process exits.
+var terminationSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
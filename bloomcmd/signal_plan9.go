@@ -0,0 +1,10 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import "os"
+
+// terminationSignals are the signals upon which buffered output is written
+// before the process exits.
+var terminationSignals = []os.Signal{os.Interrupt}
@@ -0,0 +1,94 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/pipeline"
+)
+
+// summaryJSON is the JSON representation of the summary printed by check with
+// --summary and --json. The fields suffixed with _estimate and the bounds of
+// their confidence interval are estimates, see bloom.CorrectMatchCount.
+type summaryJSON struct {
+	SourceFile             string  `json:"source_file,omitempty"`
+	Lines                  uint64  `json:"lines"`
+	Reported               uint64  `json:"reported"`
+	FPRate                 float64 `json:"fp_rate_estimate"`
+	ExpectedFalsePositives float64 `json:"false_positives_estimate"`
+	TrueMatches            float64 `json:"true_matches_estimate"`
+	TrueMatchesLow         float64 `json:"true_matches_low"`
+	TrueMatchesHigh        float64 `json:"true_matches_high"`
+}
+
+// estimatedFPRate returns the false positive rate of the filter checked,
+// estimated from its fill.
+func estimatedFPRate(filter valueSet) float64 {
+	switch f := filter.(type) {
+	case *bloom.BloomFilter:
+		return f.EstimatedFalsePositiveProb()
+	case *bloom.TombstoneFilter:
+		// deleted values no longer match, the others as in the main filter
+		return f.Main().EstimatedFalsePositiveProb()
+	case *bloom.ShardedFilter:
+		// each value is checked against one of the shards
+		rate := 0.0
+		for _, shard := range f.Filters() {
+			rate += shard.EstimatedFalsePositiveProb()
+		}
+		return rate / float64(len(f.Filters()))
+	}
+	return 0
+}
+
+// anyFPRate returns the rate at which a value that was added to none of the
+// filters matches any of them.
+func anyFPRate(filters []bloom.NamedFilter) float64 {
+	none := 1.0
+	for _, filter := range filters {
+		none *= 1 - filter.Filter.EstimatedFalsePositiveProb()
+	}
+	return 1 - none
+}
+
+// reportSummary prints the number of lines checked and reported with
+// --summary, and how many of the latter are expected to be false positives
+// given the false positive rate of the filters checked, which is only
+// computed then. Each line is treated as one value, so the estimates are rough
+// for lines split into several.
+func (bloomParams BloomParams) reportSummary(stats pipeline.Stats, reported int, fpRate func() float64) {
+	if !bloomParams.summary || bloomParams.stderr == nil {
+		return
+	}
+	lines := stats.Lines
+	if bloomParams.sampler != nil {
+		lines = stats.Sampled
+	}
+	e := bloom.CorrectMatchCount(uint64(reported), uint64(lines), fpRate())
+	if bloomParams.json {
+		data, _ := json.Marshal(summaryJSON{
+			SourceFile:             bloomParams.source,
+			Lines:                  e.Total,
+			Reported:               e.Matches,
+			FPRate:                 e.FalsePositiveRate,
+			ExpectedFalsePositives: e.ExpectedFalsePositives,
+			TrueMatches:            e.TrueMatches,
+			TrueMatchesLow:         e.TrueMatchesLow,
+			TrueMatchesHigh:        e.TrueMatchesHigh,
+		})
+		fmt.Fprintf(bloomParams.stderr, "%s\n", data)
+		return
+	}
+	prefix := ""
+	if bloomParams.source != "" {
+		prefix = bloomParams.source + ": "
+	}
+	fmt.Fprintf(bloomParams.stderr, "%sChecked %d lines, of which %d were reported.\n", prefix, e.Total, e.Matches)
+	fmt.Fprintf(bloomParams.stderr, "%sEstimated FP rate of the filter: %.2e\n", prefix, e.FalsePositiveRate)
+	fmt.Fprintf(bloomParams.stderr, "%sEstimated false positives among the reported lines: %.1f\n", prefix, e.ExpectedFalsePositives)
+	fmt.Fprintf(bloomParams.stderr, "%sEstimated true matches: %.0f (95%% confidence interval: %.0f to %.0f)\n", prefix, e.TrueMatches, e.TrueMatchesLow, e.TrueMatchesHigh)
+}
@@ -0,0 +1,21 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import "os"
+
+// isTerminalStream returns true if the stream is a file that is an
+// interactive terminal. Other streams, e.g. of programs embedding the tool,
+// are never terminals.
+func isTerminalStream(stream interface{}) bool {
+	f, ok := stream.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// readStdin returns true if values are to be read from standard input, which
+// is the case unless it is a terminal and neither --interactive nor --stdin is
+// given.
+func readStdin(bloomParams BloomParams) bool {
+	return bloomParams.interactive || bloomParams.forceStdin || !isTerminalStream(bloomParams.stdin)
+}
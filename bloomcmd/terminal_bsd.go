@@ -0,0 +1,11 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+// +build darwin dragonfly freebsd netbsd openbsd
+
+package bloomcmd
+
+import "syscall"
+
+const ioctlReadTermios = syscall.TIOCGETA
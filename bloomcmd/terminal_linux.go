@@ -0,0 +1,8 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import "syscall"
+
+const ioctlReadTermios = syscall.TCGETS
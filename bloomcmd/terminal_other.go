@@ -0,0 +1,16 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package bloomcmd
+
+import "os"
+
+// isTerminal returns true if the file is a character device, which is the
+// best guess for a terminal on this platform.
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
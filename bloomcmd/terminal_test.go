@@ -0,0 +1,68 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStdinFromPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write([]byte("foo\nbar\n"))
+		w.Close()
+	}()
+	if isTerminal(r) {
+		t.Fatal("pipe detected as terminal")
+	}
+	filter := testFilter()
+	readValuesIntoFilter(filter, BloomParams{streams: streams{stdin: r}})
+	if !filter.Check([]byte("foo")) || !filter.Check([]byte("bar")) {
+		t.Fatal("values from pipe not inserted")
+	}
+}
+
+func TestStdinFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "values.txt")
+	if err := ioutil.WriteFile(path, []byte("foo\r\nbar\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Fatal("file detected as terminal")
+	}
+	filter := testFilter()
+	readValuesIntoFilter(filter, BloomParams{streams: streams{stdin: f}})
+	if !filter.Check([]byte("foo")) || !filter.Check([]byte("bar")) {
+		t.Fatal("values from file not inserted")
+	}
+}
+
+func TestReadStdin(t *testing.T) {
+	for _, params := range []BloomParams{{interactive: true}, {forceStdin: true}} {
+		if !readStdin(params) {
+			t.Fatalf("standard input not read with %+v", params)
+		}
+	}
+	if !readStdin(BloomParams{streams: streams{stdin: strings.NewReader("foo\n")}}) {
+		t.Fatal("standard input that is not a file not read")
+	}
+}
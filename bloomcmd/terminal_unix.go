@@ -0,0 +1,22 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package bloomcmd
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// isTerminal returns true if the file is a terminal, i.e. its terminal
+// attributes can be read. Other character devices such as /dev/null are not
+// terminals.
+func isTerminal(f *os.File) bool {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), ioctlReadTermios, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
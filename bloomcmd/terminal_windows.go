@@ -0,0 +1,16 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// isTerminal returns true if the file is a console. Redirected input, pipes
+// and the NUL device are not.
+func isTerminal(f *os.File) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(f.Fd()), &mode) == nil
+}
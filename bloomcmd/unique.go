@@ -0,0 +1,145 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/DCSO/bloom"
+)
+
+// defaultUniqueMax is the default number of distinct lines tracked exactly by
+// check --unique.
+const defaultUniqueMax = 1000000
+
+// uniqueFallbackCapacity is the capacity of the filter used to remove
+// duplicates approximately (about 34 MiB).
+const uniqueFallbackCapacity = 10000000
+
+// uniqueFallbackFPP is the false positive probability of the filter used to
+// remove duplicates approximately, i.e. the probability of suppressing a
+// distinct line.
+const uniqueFallbackFPP = 1e-6
+
+// uniqueWriter writes each distinct line written to it only once, or with
+// counts, each distinct line with the number of times it was written once
+// closed. Up to max distinct lines are tracked exactly, further lines are
+// deduplicated approximately using a Bloom filter (and not counted).
+type uniqueWriter struct {
+	w       io.Writer
+	max     int
+	count   bool
+	streams streams
+	// partial holds the incomplete last line written
+	partial []byte
+	// seen holds the distinct lines tracked exactly with their counts
+	seen map[string]uint64
+	// fallback holds the further distinct lines once max is exceeded
+	fallback *bloom.BloomFilter
+	// untracked is the number of lines not counted
+	untracked uint64
+}
+
+func newUniqueWriter(w io.Writer, max int, count bool, s streams) *uniqueWriter {
+	if max < 1 {
+		max = 1
+	}
+	return &uniqueWriter{w: w, max: max, count: count, streams: s, seen: make(map[string]uint64)}
+}
+
+func (u *uniqueWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			u.partial = append(u.partial, p...)
+			break
+		}
+		line := p[:i]
+		if len(u.partial) > 0 {
+			line = append(u.partial, line...)
+			u.partial = u.partial[:0]
+		}
+		if err := u.writeLine(line); err != nil {
+			return 0, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// writeLine writes the line unless it was seen before.
+func (u *uniqueWriter) writeLine(line []byte) error {
+	if n, ok := u.seen[string(line)]; ok {
+		u.seen[string(line)] = n + 1
+		return nil
+	}
+	if len(u.seen) < u.max {
+		u.seen[string(line)] = 1
+		if u.count {
+			return nil
+		}
+		return u.writeOutput(line)
+	}
+	if u.fallback == nil {
+		var err error
+		u.fallback, err = bloom.New(uniqueFallbackCapacity, uniqueFallbackFPP)
+		if err != nil {
+			return err
+		}
+		if u.count {
+			u.streams.warnf("more than %d distinct lines, further lines are not counted", u.max)
+		} else {
+			u.streams.warnf("more than %d distinct lines, further duplicates are removed approximately, which may omit distinct lines", u.max)
+		}
+	}
+	u.untracked++
+	if u.fallback.Check(line) {
+		return nil
+	}
+	u.fallback.Add(line)
+	if u.count {
+		return nil
+	}
+	return u.writeOutput(line)
+}
+
+func (u *uniqueWriter) writeOutput(line []byte) error {
+	if _, err := u.w.Write(line); err != nil {
+		return err
+	}
+	_, err := u.w.Write([]byte{'\n'})
+	return err
+}
+
+// Close handles an incomplete last line and with counts writes the counted
+// lines in lexical order, like 'sort | uniq -c'.
+func (u *uniqueWriter) Close() error {
+	if len(u.partial) > 0 {
+		if err := u.writeLine(u.partial); err != nil {
+			return err
+		}
+		u.partial = nil
+	}
+	if !u.count {
+		return nil
+	}
+	lines := make([]string, 0, len(u.seen))
+	for line := range u.seen {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(u.w, "%7d %s\n", u.seen[line], line); err != nil {
+			return err
+		}
+	}
+	if u.untracked > 0 {
+		u.streams.warnf("%d further lines (about %d distinct) were not counted, use a larger --unique-max", u.untracked, u.fallback.EstimatedNumElements())
+	}
+	return nil
+}
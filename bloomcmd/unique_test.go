@@ -0,0 +1,83 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUniqueWriter(t *testing.T) {
+	var output, warnings bytes.Buffer
+	u := newUniqueWriter(&output, 10, false, streams{stderr: &warnings})
+	// lines may be split across writes
+	io.WriteString(u, "foo\nbar\nfo")
+	io.WriteString(u, "o\nbaz\nbar\nqux")
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "foo\nbar\nbaz\nqux\n" {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+	if warnings.Len() != 0 {
+		t.Fatalf("unexpected warnings %q", warnings.String())
+	}
+}
+
+func TestUniqueWriterCount(t *testing.T) {
+	var output bytes.Buffer
+	u := newUniqueWriter(&output, 10, true, streams{})
+	io.WriteString(u, "foo\nbar\nfoo\n\nfoo\nbar\nbaz\n")
+	if output.Len() != 0 {
+		t.Fatalf("unexpected output before closing %q", output.String())
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := "      1 \n      2 bar\n      1 baz\n      3 foo\n"
+	if output.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, output.String())
+	}
+}
+
+func TestUniqueWriterFallback(t *testing.T) {
+	var output, warnings bytes.Buffer
+	u := newUniqueWriter(&output, 3, false, streams{stderr: &warnings})
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 100; j++ {
+			fmt.Fprintf(u, "value-%d\n", j)
+		}
+	}
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// only the bounded number of lines is tracked exactly
+	if len(u.seen) != 3 {
+		t.Fatalf("expected 3 lines tracked exactly, got %d", len(u.seen))
+	}
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 100 {
+		t.Fatalf("expected 100 distinct lines, got %d", len(lines))
+	}
+	if strings.Count(warnings.String(), "Warning: more than 3 distinct lines") != 1 {
+		t.Fatalf("unexpected warnings %q", warnings.String())
+	}
+
+	output.Reset()
+	warnings.Reset()
+	u = newUniqueWriter(&output, 3, true, streams{stderr: &warnings})
+	io.WriteString(u, "a\nb\na\nc\nd\ne\nd\nc\n")
+	if err := u.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if output.String() != "      2 a\n      1 b\n      2 c\n" {
+		t.Fatalf("unexpected output %q", output.String())
+	}
+	if !strings.Contains(warnings.String(), "3 further lines (about 2 distinct) were not counted") {
+		t.Fatalf("unexpected warnings %q", warnings.String())
+	}
+}
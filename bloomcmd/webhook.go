@@ -0,0 +1,119 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomcmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/DCSO/bloom/pipeline"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// webhookCloseTimeout is how long check waits for the matches still buffered
+// to be posted to the webhook once the input ended.
+const webhookCloseTimeout = 30 * time.Second
+
+// webhookFlags are the flags of check to forward the matched values to a
+// webhook.
+var webhookFlags = []cli.Flag{
+	cli.StringFlag{Name: "webhook-url", Usage: "Post the matched values, with the time, input, line number and filter, to the given URL as JSON arrays of events, in batches. Matches are dropped with a warning if the webhook cannot keep up, instead of slowing down checking."},
+	cli.StringSliceFlag{Name: "webhook-header", Usage: "Send the given header with the requests to the webhook (e.g. 'Authorization: Bearer ...', repeatable)."},
+	cli.IntFlag{Name: "webhook-batch-size", Value: pipeline.DefaultForwardBatchSize, Usage: "The maximum number of matches posted to the webhook per request."},
+	cli.DurationFlag{Name: "webhook-flush-interval", Value: pipeline.DefaultForwardFlushInterval, Usage: "The maximum time a match waits for its batch to fill up before it is posted to the webhook."},
+}
+
+func parseWebhookFlags(c *cli.Context, bloomParams *BloomParams) error {
+	url := c.String("webhook-url")
+	if url == "" {
+		if c.IsSet("webhook-header") || c.IsSet("webhook-batch-size") || c.IsSet("webhook-flush-interval") {
+			return errors.New("--webhook-header, --webhook-batch-size and --webhook-flush-interval require --webhook-url.")
+		}
+		return nil
+	}
+	if c.Int("webhook-batch-size") < 1 {
+		return errors.New("--webhook-batch-size must be positive.")
+	}
+	if c.Duration("webhook-flush-interval") <= 0 {
+		return errors.New("--webhook-flush-interval must be positive.")
+	}
+	header := http.Header{}
+	for _, v := range c.StringSlice("webhook-header") {
+		name, value, err := pipeline.ParseHeader(v)
+		if err != nil {
+			return fmt.Errorf("Invalid value for --webhook-header: %s", err)
+		}
+		header.Add(name, value)
+	}
+	bloomParams.webhook = &pipeline.ForwarderConfig{
+		URL:           url,
+		Header:        header,
+		BatchSize:     c.Int("webhook-batch-size"),
+		FlushInterval: c.Duration("webhook-flush-interval"),
+	}
+	return nil
+}
+
+// startForwarding starts forwarding the matches to the webhook given with
+// --webhook-url, if any, and returns a function that posts the matches still
+// buffered and warns about the ones dropped.
+func (bloomParams *BloomParams) startForwarding() (func(), error) {
+	if bloomParams.webhook == nil {
+		return func() {}, nil
+	}
+	// the forwarder warns while check does
+	if bloomParams.stderr != nil {
+		bloomParams.stderr = newMatchWriter(bloomParams.stderr, true)
+	}
+	config := *bloomParams.webhook
+	warnf := bloomParams.warnf
+	config.OnError = func(err error, events int) {
+		warnf("dropped %d matches that could not be posted to the webhook: %s", events, err)
+	}
+	forwarder, err := pipeline.NewForwarder(config)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid value for --webhook-url: %s", err)
+	}
+	bloomParams.forwarder = forwarder
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), webhookCloseTimeout)
+		defer cancel()
+		stats := forwarder.Close(ctx)
+		if stats.Dropped > 0 {
+			warnf("%d of %d matches were not posted to the webhook", stats.Dropped, stats.Dropped+stats.Forwarded)
+		}
+	}, nil
+}
+
+// forwardMatches forwards the matched values of a line to the webhook, if
+// any, once per filter they matched.
+func (bloomParams BloomParams) forwardMatches(outcomes []pipeline.CheckOutcome) {
+	if bloomParams.forwarder == nil {
+		return
+	}
+	now := time.Now()
+	for _, outcome := range outcomes {
+		if !outcome.Matched {
+			continue
+		}
+		event := pipeline.MatchEvent{
+			Time:   now,
+			Value:  bloomParams.encoding.EncodeToString(outcome.Value),
+			Source: outcome.SourceFile,
+			Line:   outcome.Line,
+			Filter: bloomParams.filterName,
+		}
+		if len(outcome.MatchedFilters) == 0 {
+			bloomParams.forwarder.Forward(event)
+			continue
+		}
+		for _, filter := range outcome.MatchedFilters {
+			event.Filter = filter
+			bloomParams.forwarder.Forward(event)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package bloomtest provides helpers for testing code that uses Bloom filters
+// and for measuring the properties of filters.
+package bloomtest
+
+import (
+	"math/rand"
+
+	"github.com/DCSO/bloom"
+)
+
+// DefaultValueLength is the length in bytes of the values generated by
+// FalsePositiveRate.
+const DefaultValueLength = 32
+
+// RandomValues returns n random values of the given length in bytes, generated
+// deterministically from the seed.
+func RandomValues(n int, length int, seed int64) [][]byte {
+	rng := rand.New(rand.NewSource(seed))
+	values := make([][]byte, n)
+	for i := range values {
+		values[i] = make([]byte, length)
+		rng.Read(values[i])
+	}
+	return values
+}
+
+// FalsePositiveRate measures the false positive rate of a filter by checking
+// the given number of random values generated from the seed, and returns the
+// fraction of values that matched. The values are assumed not to be in the
+// filter, which holds with overwhelming probability for random values of
+// DefaultValueLength bytes.
+func FalsePositiveRate(filter *bloom.BloomFilter, trials int, seed int64) float64 {
+	if trials <= 0 {
+		return 0
+	}
+	rng := rand.New(rand.NewSource(seed))
+	value := make([]byte, DefaultValueLength)
+	matches := 0
+	for i := 0; i < trials; i++ {
+		rng.Read(value)
+		if filter.Check(value) {
+			matches++
+		}
+	}
+	return float64(matches) / float64(trials)
+}
@@ -0,0 +1,148 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestRandomValues(t *testing.T) {
+	values := RandomValues(100, 16, 42)
+	if len(values) != 100 || len(values[0]) != 16 {
+		t.Fatalf("unexpected values: %d values of length %d", len(values), len(values[0]))
+	}
+	if !reflect.DeepEqual(values, RandomValues(100, 16, 42)) {
+		t.Fatal("values should be deterministic for a seed")
+	}
+	if reflect.DeepEqual(values, RandomValues(100, 16, 43)) {
+		t.Fatal("values should differ for different seeds")
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	filter, err := bloom.New(10000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := FalsePositiveRate(filter, 1000, 1); rate != 0 {
+		t.Fatalf("empty filter should have no false positives, got %f", rate)
+	}
+	for _, value := range RandomValues(10000, DefaultValueLength, 1) {
+		filter.Add(value)
+	}
+	rate := FalsePositiveRate(filter, 100000, 2)
+	if rate < 0.005 || rate > 0.015 {
+		t.Fatalf("false positive rate %f too far from 0.01", rate)
+	}
+	if rate != FalsePositiveRate(filter, 100000, 2) {
+		t.Fatal("rate should be deterministic for a seed")
+	}
+}
+
+func TestCompareHashes(t *testing.T) {
+	config := HashBenchConfig{
+		ValueLengths: []int{8, 64},
+		FilterBits:   []uint64{1e4},
+		Ops:          1000,
+		Seed:         1,
+	}
+	var results []HashBenchResult
+	err := CompareHashes(config, func(result HashBenchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2*len(HashFunctions)*2 {
+		t.Fatalf("unexpected number of results %d", len(results))
+	}
+	for _, result := range results {
+		if result.Ops != config.Ops || result.OpsPerSec <= 0 || result.P99Nanos < result.P50Nanos {
+			t.Fatalf("implausible result %+v", result)
+		}
+		// the filter size is approximated from the capacity
+		if result.FilterBits < 9900 || result.FilterBits > 10100 {
+			t.Fatalf("unexpected filter size %d", result.FilterBits)
+		}
+	}
+
+	if err := CompareHashes(HashBenchConfig{}, func(HashBenchResult) error { return nil }); err == nil {
+		t.Fatal("empty configuration should fail")
+	}
+}
+
+func TestFilterWithFill(t *testing.T) {
+	for _, fill := range DefaultCompressionBenchConfig.FillRatios {
+		filter, err := FilterWithFill(1e5, fill, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := float64(filter.NumSetBits()) / float64(filter.NumBits())
+		if actual < fill-0.01 || actual > fill+0.01 {
+			t.Fatalf("fill %f too far from %f", actual, fill)
+		}
+	}
+	if _, err := FilterWithFill(1e5, 1, 1); err == nil {
+		t.Fatal("a full filter should fail")
+	}
+}
+
+func TestCompareCompression(t *testing.T) {
+	config := CompressionBenchConfig{
+		FillRatios: []float64{0.01, 0.5},
+		FilterBits: []uint64{1e5},
+		Rounds:     1,
+		Seed:       1,
+	}
+	var results []CompressionBenchResult
+	err := CompareCompression(config, func(result CompressionBenchResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2*len(Compressions) {
+		t.Fatalf("unexpected number of results %d", len(results))
+	}
+	for i, result := range results {
+		if result.Compression != Compressions[i%len(Compressions)].Name || result.Size < 1e5/8 ||
+			result.CompressMBPerSec <= 0 || result.DecompressMBPerSec <= 0 {
+			t.Fatalf("implausible result %+v", result)
+		}
+		if result.Ratio != float64(result.CompressedSize)/float64(result.Size) {
+			t.Fatalf("inconsistent ratio %+v", result)
+		}
+		switch {
+		case result.Compression == "none" && result.CompressedSize != result.Size:
+			t.Fatalf("uncompressed size differs %+v", result)
+		// sparse bit arrays compress well, half-filled ones hardly at all
+		case result.Compression != "none" && result.FillRatio < 0.1 && result.Ratio > 0.2:
+			t.Fatalf("sparse filter compressed poorly %+v", result)
+		case result.Compression != "none" && result.FillRatio > 0.4 && result.Ratio < 0.9:
+			t.Fatalf("dense filter compressed implausibly well %+v", result)
+		}
+	}
+
+	if err := CompareCompression(CompressionBenchConfig{}, func(CompressionBenchResult) error { return nil }); err == nil {
+		t.Fatal("empty configuration should fail")
+	}
+}
+
+func TestHashFunctionMatchesFilter(t *testing.T) {
+	// the first hash function must be the one used by the filter
+	filter, _ := bloom.New(1000, 0.01)
+	fingerprint := make([]uint64, filter.NumHashFuncs())
+	for _, value := range RandomValues(100, 16, 1) {
+		filter.FingerprintHash(HashFunctions[0].Sum(value), fingerprint)
+		filter.AddFingerprint(fingerprint)
+		if !filter.Check(value) {
+			t.Fatal("value added by hash not found")
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomtest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// Compression is a candidate compression of filter files.
+type Compression struct {
+	// Name identifies the compression in reports.
+	Name string
+	// NewWriter returns a writer compressing to w, which must be closed.
+	NewWriter func(w io.Writer) (io.WriteCloser, error)
+	// NewReader returns a reader decompressing from r.
+	NewReader func(r io.Reader) (io.Reader, error)
+}
+
+// nopWriteCloser adds a Close method doing nothing to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// gzipCompression returns the gzip compression with the given level.
+func gzipCompression(level int) Compression {
+	return Compression{
+		Name: fmt.Sprintf("gzip-%d", level),
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	}
+}
+
+// Compressions are the compressions compared by CompareCompression. "none"
+// writes the filter as is and "gzip-6" is the compression of WriteFilter.
+// zstd is not among them, as the standard library has no implementation of
+// it and the module does not depend on one; it is to be added here together
+// with such a dependency.
+var Compressions = []Compression{
+	{
+		Name: "none",
+		NewWriter: func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		NewReader: func(r io.Reader) (io.Reader, error) {
+			return r, nil
+		},
+	},
+	gzipCompression(gzip.BestSpeed),
+	gzipCompression(6),
+	gzipCompression(gzip.BestCompression),
+}
+
+// CompressionBenchConfig configures CompareCompression.
+type CompressionBenchConfig struct {
+	// FillRatios are the fractions of set bits of the filters.
+	FillRatios []float64
+	// FilterBits are the (approximate) sizes in bits of the filters.
+	FilterBits []uint64
+	// Rounds is the number of times each filter is compressed and
+	// decompressed, of which the fastest is reported.
+	Rounds int
+	// Seed is the seed for generating the values added to the filters.
+	Seed int64
+}
+
+// DefaultCompressionBenchConfig is the configuration compressions are compared
+// with. A filter filled to capacity with the optimal number of hash functions
+// has half of its bits set, and one with about 69% of its bits set holds about
+// 1.7 times its capacity.
+var DefaultCompressionBenchConfig = CompressionBenchConfig{
+	FillRatios: []float64{0.01, 0.25, 0.5, 0.69},
+	FilterBits: []uint64{1e7},
+	Rounds:     3,
+	Seed:       1,
+}
+
+// compressionBenchFPP is the false positive probability of the benchmarked
+// filters, which determines the number of hash functions k.
+const compressionBenchFPP = 0.01
+
+// compressionBenchValueLength is the length of the values added to the
+// benchmarked filters.
+const compressionBenchValueLength = 16
+
+// CompressionBenchResult is the result of benchmarking one compression with
+// one filter. The JSON field names are part of the report format and must not
+// change.
+type CompressionBenchResult struct {
+	// Compression is the name of the compression.
+	Compression string `json:"compression"`
+	// FillRatio is the actual fraction of set bits of the filter.
+	FillRatio float64 `json:"fill_ratio"`
+	// FilterBits is the actual size of the filter in bits.
+	FilterBits uint64 `json:"filter_bits"`
+	// Size is the size of the uncompressed filter file in bytes.
+	Size int64 `json:"size"`
+	// CompressedSize is the size of the compressed filter file in bytes.
+	CompressedSize int64 `json:"compressed_size"`
+	// Ratio is CompressedSize divided by Size.
+	Ratio float64 `json:"ratio"`
+	// CompressMBPerSec and DecompressMBPerSec are the throughputs of
+	// compressing and decompressing in mebibytes (2^20 bytes) of the
+	// uncompressed file per second.
+	CompressMBPerSec   float64 `json:"compress_mb_per_sec"`
+	DecompressMBPerSec float64 `json:"decompress_mb_per_sec"`
+}
+
+// FilterWithFill returns a new filter of about the given number of bits to
+// which values generated from the seed were added until about the given
+// fraction of its bits is set. The number of values is derived from the
+// expected fill 1 - exp(-kn/m) of adding n values.
+func FilterWithFill(bits uint64, fill float64, seed int64) (*bloom.BloomFilter, error) {
+	if !(fill >= 0 && fill < 1) {
+		return nil, fmt.Errorf("invalid fill ratio %g", fill)
+	}
+	filter, err := NewFilterWithBits(bits, compressionBenchFPP)
+	if err != nil {
+		return nil, err
+	}
+	m, k := float64(filter.NumBits()), float64(filter.NumHashFuncs())
+	n := int(math.Round(-m / k * math.Log1p(-fill)))
+	stream := NewValueStream(ValueStreamConfig{Seed: seed, Lengths: FixedLength(compressionBenchValueLength)})
+	for i := 0; i < n; i++ {
+		filter.Add(stream.Next())
+	}
+	return filter, nil
+}
+
+// CompareCompression measures the compressed size of filter files and the
+// throughput of compressing and decompressing them with each of the
+// Compressions for each combination of fill ratio and filter size in the
+// configuration. The filters are generated by FilterWithFill, so the results
+// are reproducible. Each result is passed to report as soon as it is
+// available.
+func CompareCompression(config CompressionBenchConfig, report func(CompressionBenchResult) error) error {
+	if config.Rounds <= 0 {
+		return errors.New("number of rounds must be positive")
+	}
+	for _, bits := range config.FilterBits {
+		for _, fill := range config.FillRatios {
+			filter, err := FilterWithFill(bits, fill, config.Seed)
+			if err != nil {
+				return fmt.Errorf("creating filter of %d bits: %w", bits, err)
+			}
+			var file bytes.Buffer
+			if err := filter.Write(&file); err != nil {
+				return err
+			}
+			actualFill := float64(filter.NumSetBits()) / float64(filter.NumBits())
+			for _, compression := range Compressions {
+				result, err := measureCompression(compression, file.Bytes(), config.Rounds)
+				if err != nil {
+					return fmt.Errorf("%s: %w", compression.Name, err)
+				}
+				result.FillRatio = actualFill
+				result.FilterBits = filter.NumBits()
+				if err := report(result); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// measureCompression compresses and decompresses the data the given number of
+// times and returns the size and the fastest times.
+func measureCompression(compression Compression, data []byte, rounds int) (CompressionBenchResult, error) {
+	var compressed bytes.Buffer
+	var compressTime, decompressTime time.Duration
+	for i := 0; i < rounds; i++ {
+		compressed.Reset()
+		start := time.Now()
+		w, err := compression.NewWriter(&compressed)
+		if err != nil {
+			return CompressionBenchResult{}, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return CompressionBenchResult{}, err
+		}
+		if err := w.Close(); err != nil {
+			return CompressionBenchResult{}, err
+		}
+		if elapsed := time.Since(start); i == 0 || elapsed < compressTime {
+			compressTime = elapsed
+		}
+
+		start = time.Now()
+		r, err := compression.NewReader(bytes.NewReader(compressed.Bytes()))
+		if err != nil {
+			return CompressionBenchResult{}, err
+		}
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			return CompressionBenchResult{}, err
+		}
+		if elapsed := time.Since(start); i == 0 || elapsed < decompressTime {
+			decompressTime = elapsed
+		}
+		if n != int64(len(data)) {
+			return CompressionBenchResult{}, fmt.Errorf("decompressed %d of %d bytes", n, len(data))
+		}
+	}
+	// the times are at least a nanosecond so that the throughputs are finite
+	mb := float64(len(data)) / (1 << 20)
+	compressTime, decompressTime = maxDuration(compressTime, 1), maxDuration(decompressTime, 1)
+	return CompressionBenchResult{
+		Compression:        compression.Name,
+		Size:               int64(len(data)),
+		CompressedSize:     int64(compressed.Len()),
+		Ratio:              float64(compressed.Len()) / float64(len(data)),
+		CompressMBPerSec:   mb / compressTime.Seconds(),
+		DecompressMBPerSec: mb / decompressTime.Seconds(),
+	}, nil
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,181 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomtest
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// HashFunction is a candidate hash function for deriving the fingerprints of
+// values.
+type HashFunction struct {
+	// Name identifies the function in reports.
+	Name string
+	// Sum returns the 64-bit hash of a value.
+	Sum func(value []byte) uint64
+}
+
+// HashFunctions are the hash functions compared by CompareHashes. "fnv1" is the
+// function currently used by the filter. Further candidates (e.g. xxHash or
+// SipHash) are to be added here when they are implemented.
+var HashFunctions = []HashFunction{
+	{Name: "fnv1", Sum: func(value []byte) uint64 {
+		h := fnv.New64()
+		h.Write(value)
+		return h.Sum64()
+	}},
+	{Name: "fnv1a", Sum: func(value []byte) uint64 {
+		h := fnv.New64a()
+		h.Write(value)
+		return h.Sum64()
+	}},
+}
+
+// HashBenchConfig configures CompareHashes.
+type HashBenchConfig struct {
+	// ValueLengths are the lengths in bytes of the values.
+	ValueLengths []int
+	// FilterBits are the (approximate) sizes in bits of the filters.
+	FilterBits []uint64
+	// Ops is the number of operations measured per combination.
+	Ops int
+	// Seed is the seed for generating the values.
+	Seed int64
+}
+
+// DefaultHashBenchConfig is the configuration whose results are expected with
+// changes to hashing.
+var DefaultHashBenchConfig = HashBenchConfig{
+	ValueLengths: []int{8, 64, 512, 4096},
+	FilterBits:   []uint64{1e6, 1e8, 1e9},
+	Ops:          100000,
+	Seed:         1,
+}
+
+// hashBenchFPP is the false positive probability of the benchmarked filters,
+// which determines the number of hash functions k.
+const hashBenchFPP = 0.01
+
+// HashBenchResult is the result of benchmarking one operation with one hash
+// function, value length and filter size. The JSON field names are part of
+// the report format and must not change.
+type HashBenchResult struct {
+	// Hash is the name of the hash function.
+	Hash string `json:"hash"`
+	// Operation is "add" or "check".
+	Operation string `json:"op"`
+	// ValueLength is the length of the values in bytes.
+	ValueLength int `json:"value_length"`
+	// FilterBits is the actual size of the filter in bits.
+	FilterBits uint64 `json:"filter_bits"`
+	// HashFuncs is the number of probes per value (k).
+	HashFuncs uint64 `json:"k"`
+	// Ops is the number of measured operations.
+	Ops int `json:"ops"`
+	// OpsPerSec is the throughput measured without timing single operations.
+	OpsPerSec float64 `json:"ops_per_sec"`
+	// P50Nanos and P99Nanos are latency percentiles of single operations in
+	// nanoseconds, including the overhead of reading the clock.
+	P50Nanos int64 `json:"p50_ns"`
+	P99Nanos int64 `json:"p99_ns"`
+}
+
+// NewFilterWithBits returns a new filter with the given FP probability whose
+// capacity is chosen so that it has about the given number of bits.
+func NewFilterWithBits(bits uint64, p float64) (*bloom.BloomFilter, error) {
+	n := uint64(math.Round(float64(bits) * math.Pow(math.Log(2.0), 2.0) / -math.Log(p)))
+	if n == 0 {
+		n = 1
+	}
+	return bloom.New(n, p)
+}
+
+// CompareHashes benchmarks adding values to and checking values against
+// filters for each of the HashFunctions and each combination of value length
+// and filter size in the configuration. The fingerprints are derived from the
+// hashes as by the filter, so only the hash function differs. The values are
+// generated by ValueStreams seeded from the configuration and checked after
+// they were added, and about half of the checked values are in the filter.
+// Each result is passed to report as soon as it is available.
+func CompareHashes(config HashBenchConfig, report func(HashBenchResult) error) error {
+	if config.Ops <= 0 {
+		return errors.New("number of operations must be positive")
+	}
+	for _, bits := range config.FilterBits {
+		for _, length := range config.ValueLengths {
+			values := NewValueStream(ValueStreamConfig{Seed: config.Seed, Lengths: FixedLength(length)}).Take(config.Ops)
+			checked := NewValueStream(ValueStreamConfig{
+				Seed:           config.Seed + 1,
+				Lengths:        FixedLength(length),
+				Members:        values,
+				MemberFraction: 0.5,
+			}).Take(config.Ops)
+			for _, hf := range HashFunctions {
+				filter, err := NewFilterWithBits(bits, hashBenchFPP)
+				if err != nil {
+					return fmt.Errorf("creating filter of %d bits: %w", bits, err)
+				}
+				fingerprint := make([]uint64, filter.NumHashFuncs())
+				add := func(value []byte) {
+					filter.FingerprintHash(hf.Sum(value), fingerprint)
+					filter.AddFingerprint(fingerprint)
+				}
+				check := func(value []byte) {
+					filter.FingerprintHash(hf.Sum(value), fingerprint)
+					filter.CheckFingerprint(fingerprint)
+				}
+				for _, op := range []struct {
+					name string
+					fn   func([]byte)
+				}{{"add", add}, {"check", check}} {
+					values := values
+					if op.name == "check" {
+						values = checked
+					}
+					result := measure(op.fn, values)
+					result.Hash = hf.Name
+					result.Operation = op.name
+					result.ValueLength = length
+					result.FilterBits = filter.NumBits()
+					result.HashFuncs = filter.NumHashFuncs()
+					if err := report(result); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// measure runs the operation on all values, once for the throughput and once
+// timing every single operation.
+func measure(op func([]byte), values [][]byte) HashBenchResult {
+	start := time.Now()
+	for _, value := range values {
+		op(value)
+	}
+	elapsed := time.Since(start)
+
+	latencies := make([]int64, len(values))
+	for i, value := range values {
+		start := time.Now()
+		op(value)
+		latencies[i] = int64(time.Since(start))
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return HashBenchResult{
+		Ops:       len(values),
+		OpsPerSec: float64(len(values)) / elapsed.Seconds(),
+		P50Nanos:  latencies[len(latencies)/2],
+		P99Nanos:  latencies[len(latencies)*99/100],
+	}
+}
@@ -0,0 +1,140 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomtest
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/DCSO/bloom"
+)
+
+// DefaultDuplicateWindow is the number of most recently generated values from
+// which a ValueStream picks the duplicates.
+const DefaultDuplicateWindow = 1 << 16
+
+// LengthDistribution returns the length in bytes of the next value using the
+// given source of randomness.
+type LengthDistribution func(rng *rand.Rand) int
+
+// FixedLength returns the distribution of values of the given length.
+func FixedLength(length int) LengthDistribution {
+	return func(*rand.Rand) int {
+		return length
+	}
+}
+
+// UniformLength returns the distribution of values whose lengths are
+// uniformly distributed between min and max, inclusive.
+func UniformLength(min, max int) LengthDistribution {
+	return func(rng *rand.Rand) int {
+		return min + rng.Intn(max-min+1)
+	}
+}
+
+// ValueStreamConfig configures a ValueStream. The zero value generates unique
+// random values of DefaultValueLength bytes from seed 0.
+type ValueStreamConfig struct {
+	// Seed is the seed the values are generated from.
+	Seed int64
+	// Lengths is the distribution of the lengths of new values
+	// (FixedLength(DefaultValueLength) by default).
+	Lengths LengthDistribution
+	// DuplicateProb is the probability that a value repeats one of the
+	// DuplicateWindow most recently generated values.
+	DuplicateProb float64
+	// DuplicateWindow is the number of values duplicates are picked from
+	// (DefaultDuplicateWindow by default).
+	DuplicateWindow int
+	// Members are the values added to a filter, e.g. those recorded by
+	// BuildFilter, of which MemberFraction of the generated values are
+	// replayed, picked at random. Unless the distribution yields very short
+	// values, the other values are not members of the filter with
+	// overwhelming probability, so that the stream overlaps with the filter
+	// by MemberFraction plus its false positives.
+	Members        [][]byte
+	MemberFraction float64
+}
+
+// ValueStream generates a deterministic stream of pseudo-random values with
+// the properties given by its configuration, e.g. for load tests. Streams with
+// the same configuration generate the same values. A ValueStream is not safe
+// for concurrent use.
+type ValueStream struct {
+	config ValueStreamConfig
+	rng    *rand.Rand
+	// recent are the most recently generated values, from which duplicates
+	// are picked, with next the index of the oldest once it is full
+	recent [][]byte
+	next   int
+}
+
+// NewValueStream returns a stream of values with the given configuration.
+func NewValueStream(config ValueStreamConfig) *ValueStream {
+	if config.Lengths == nil {
+		config.Lengths = FixedLength(DefaultValueLength)
+	}
+	if config.DuplicateWindow <= 0 {
+		config.DuplicateWindow = DefaultDuplicateWindow
+	}
+	return &ValueStream{
+		config: config,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// Next returns the next value of the stream, which may be retained by the
+// caller but must not be modified.
+func (s *ValueStream) Next() []byte {
+	var value []byte
+	switch {
+	case len(s.recent) > 0 && s.rng.Float64() < s.config.DuplicateProb:
+		return s.recent[s.rng.Intn(len(s.recent))]
+	case len(s.config.Members) > 0 && s.rng.Float64() < s.config.MemberFraction:
+		value = s.config.Members[s.rng.Intn(len(s.config.Members))]
+	default:
+		value = make([]byte, s.config.Lengths(s.rng))
+		s.rng.Read(value)
+	}
+	if s.config.DuplicateProb > 0 {
+		s.remember(value)
+	}
+	return value
+}
+
+// remember adds a value to the recent values, replacing the oldest one once
+// the window is full.
+func (s *ValueStream) remember(value []byte) {
+	if len(s.recent) < s.config.DuplicateWindow {
+		s.recent = append(s.recent, value)
+		return
+	}
+	s.recent[s.next] = value
+	s.next = (s.next + 1) % len(s.recent)
+}
+
+// Take returns the next n values of the stream.
+func (s *ValueStream) Take(n int) [][]byte {
+	values := make([][]byte, n)
+	for i := range values {
+		values[i] = s.Next()
+	}
+	return values
+}
+
+// BuildFilter returns a filter with the given capacity and FP probability to
+// which the first n values of a stream with the given configuration were
+// added, along with these values, which can be replayed by streams with
+// ValueStreamConfig.Members.
+func BuildFilter(n uint64, p float64, config ValueStreamConfig) (*bloom.BloomFilter, [][]byte, error) {
+	filter, err := bloom.New(n, p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating filter: %w", err)
+	}
+	values := NewValueStream(config).Take(int(n))
+	for _, value := range values {
+		filter.Add(value)
+	}
+	return filter, values, nil
+}
@@ -0,0 +1,76 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloomtest
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestValueStream(t *testing.T) {
+	config := ValueStreamConfig{Seed: 42, Lengths: UniformLength(4, 64), DuplicateProb: 0.2}
+	values := NewValueStream(config).Take(10000)
+	if !reflect.DeepEqual(values, NewValueStream(config).Take(10000)) {
+		t.Fatal("values should be deterministic for a seed")
+	}
+	config.Seed = 43
+	if reflect.DeepEqual(values, NewValueStream(config).Take(10000)) {
+		t.Fatal("values should differ for different seeds")
+	}
+
+	seen := make(map[string]bool)
+	duplicates := 0
+	for _, value := range values {
+		if len(value) < 4 || len(value) > 64 {
+			t.Fatalf("unexpected length %d", len(value))
+		}
+		if seen[string(value)] {
+			duplicates++
+		}
+		seen[string(value)] = true
+	}
+	if ratio := float64(duplicates) / float64(len(values)); math.Abs(ratio-0.2) > 0.02 {
+		t.Fatalf("duplicate ratio %f too far from 0.2", ratio)
+	}
+
+	// without duplicates, all values are distinct
+	seen = make(map[string]bool)
+	for i, value := range NewValueStream(ValueStreamConfig{Seed: 1}).Take(1000) {
+		if len(value) != DefaultValueLength || seen[string(value)] {
+			t.Fatalf("unexpected value %d", i)
+		}
+		seen[string(value)] = true
+	}
+}
+
+func TestValueStreamMembers(t *testing.T) {
+	filter, members, err := BuildFilter(10000, 0.0001, ValueStreamConfig{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 10000 || !filter.Check(members[0]) {
+		t.Fatalf("unexpected build of %d values", len(members))
+	}
+	_, again, err := BuildFilter(10000, 0.0001, ValueStreamConfig{Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(members, again) {
+		t.Fatal("builds should be deterministic for a seed")
+	}
+
+	for _, fraction := range []float64{0, 0.3, 1} {
+		stream := NewValueStream(ValueStreamConfig{Seed: 2, Members: members, MemberFraction: fraction, DuplicateProb: 0.1})
+		found := 0
+		for _, value := range stream.Take(20000) {
+			if filter.Check(value) {
+				found++
+			}
+		}
+		if ratio := float64(found) / 20000; math.Abs(ratio-fraction) > 0.02 {
+			t.Fatalf("member ratio %f too far from %f", ratio, fraction)
+		}
+	}
+}
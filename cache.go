@@ -0,0 +1,112 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// invalidate marks all results of Check obtained so far as potentially
+// outdated. It must be called after the filter was modified.
+func (s *BloomFilter) invalidate() {
+	atomic.AddUint64(&s.generation, 1)
+}
+
+// cacheLocks is the number of locks guarding the slots of a CachedFilter.
+const cacheLocks = 64
+
+// CachedFilter memoizes the results of Check for recently checked values,
+// which speeds up workloads in which few values are checked very often. The
+// results are kept in a fixed number of slots selected by the hash of the
+// value, a newer value replacing an older one in the same slot. Cached results
+// are invalidated automatically when the underlying filter is modified, e.g.
+// by Add, Join, Reset or Read. A CachedFilter is safe for concurrent use, as
+// long as the underlying filter is not modified concurrently (see the package
+// documentation).
+type CachedFilter struct {
+	// accessed atomically, first words for 64-bit alignment
+	hits   uint64
+	misses uint64
+
+	filter *BloomFilter
+	seed   maphash.Seed
+	slots  []cacheSlot
+	locks  [cacheLocks]sync.Mutex
+}
+
+type cacheSlot struct {
+	hash       uint64
+	generation uint64
+	value      []byte
+	result     bool
+	used       bool
+}
+
+// NewCachedFilter returns a CachedFilter for the given filter that keeps the
+// results for up to size recently checked values. A size of zero or less
+// disables the cache, so that Check is passed through to the filter.
+func NewCachedFilter(filter *BloomFilter, size int) *CachedFilter {
+	c := &CachedFilter{filter: filter, seed: maphash.MakeSeed()}
+	if size > 0 {
+		c.slots = make([]cacheSlot, size)
+	}
+	return c
+}
+
+// Filter returns the underlying filter.
+func (c *CachedFilter) Filter() *BloomFilter {
+	return c.filter
+}
+
+// Check returns the same result as Check of the underlying filter.
+func (c *CachedFilter) Check(value []byte) bool {
+	if len(c.slots) == 0 {
+		return c.filter.Check(value)
+	}
+	hash, i := c.slot(value)
+	lock := &c.locks[i%cacheLocks]
+	slot := &c.slots[i]
+
+	// the generation is read before checking, so that results obtained
+	// while the filter is modified are outdated immediately
+	generation := atomic.LoadUint64(&c.filter.generation)
+	lock.Lock()
+	if slot.used && slot.hash == hash && slot.generation == generation && bytes.Equal(slot.value, value) {
+		result := slot.result
+		lock.Unlock()
+		atomic.AddUint64(&c.hits, 1)
+		return result
+	}
+	lock.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	result := c.filter.Check(value)
+
+	lock.Lock()
+	// the buffer of the slot is reused to avoid allocations
+	slot.hash = hash
+	slot.generation = generation
+	slot.value = append(slot.value[:0], value...)
+	slot.result = result
+	slot.used = true
+	lock.Unlock()
+	return result
+}
+
+// slot returns the hash of a value and the index of its slot.
+func (c *CachedFilter) slot(value []byte) (uint64, uint64) {
+	var h maphash.Hash
+	h.SetSeed(c.seed)
+	h.Write(value)
+	hash := h.Sum64()
+	return hash, hash % uint64(len(c.slots))
+}
+
+// CacheStats returns the number of cache hits and misses so far.
+func (c *CachedFilter) CacheStats() (hits, misses uint64) {
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
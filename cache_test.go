@@ -0,0 +1,142 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestCachedFilter(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.Add([]byte("foo"))
+	cached := NewCachedFilter(filter, 2)
+	// the slots are selected with a random seed, so use a value that does not
+	// share the slot of "foo"
+	_, fooSlot := cached.slot([]byte("foo"))
+	other := "bar"
+	for i := 0; ; i++ {
+		if _, slot := cached.slot([]byte(other)); slot != fooSlot {
+			break
+		}
+		other = fmt.Sprintf("bar%d", i)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !cached.Check([]byte("foo")) || cached.Check([]byte(other)) {
+			t.Fatal("unexpected cached result")
+		}
+	}
+	if hits, misses := cached.CacheStats(); hits != 4 || misses != 2 {
+		t.Fatalf("unexpected cache stats: %d hits, %d misses", hits, misses)
+	}
+
+	// modifications invalidate the cache
+	filter.Add([]byte(other))
+	if !cached.Check([]byte(other)) {
+		t.Fatal("cached result not invalidated by Add")
+	}
+	filter.Reset()
+	if cached.Check([]byte("foo")) || cached.Check([]byte(other)) {
+		t.Fatal("cached result not invalidated by Reset")
+	}
+	joined := mustNew(1000, 0.0001)
+	joined.Add([]byte("foo"))
+	if err := filter.Join(joined); err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Check([]byte("foo")) {
+		t.Fatal("cached result not invalidated by Join")
+	}
+	filter.SetMaxValueLength(2, RejectValues)
+	if cached.Check([]byte("foo")) {
+		t.Fatal("cached result not invalidated by SetMaxValueLength")
+	}
+
+	// newer values replace older ones in the same slot
+	filter.SetMaxValueLength(0, RejectValues)
+	single := NewCachedFilter(filter, 1)
+	for _, v := range []string{"foo", "bar", "bar", "foo"} {
+		if single.Check([]byte(v)) != (v == "foo") {
+			t.Fatalf("unexpected result for %s", v)
+		}
+	}
+	if hits, misses := single.CacheStats(); hits != 1 || misses != 3 {
+		t.Fatalf("unexpected cache stats: %d hits, %d misses", hits, misses)
+	}
+}
+
+func TestCachedFilterDisabled(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.Add([]byte("foo"))
+	cached := NewCachedFilter(filter, 0)
+	if !cached.Check([]byte("foo")) || cached.Check([]byte("bar")) {
+		t.Fatal("unexpected result")
+	}
+	if hits, misses := cached.CacheStats(); hits != 0 || misses != 0 {
+		t.Fatal("disabled cache should not be used")
+	}
+}
+
+func TestCachedFilterConcurrent(t *testing.T) {
+	filter, values := GenerateExampleFilter(10000, 0.001, 1000)
+	cached := NewCachedFilter(filter, 100)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 10000; i++ {
+				if !cached.Check(values[rng.Intn(len(values))]) {
+					t.Error("value not found")
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
+
+// zipfFilter returns a large filter containing the most frequent of the
+// values returned by zipfValues.
+func zipfFilter() *BloomFilter {
+	filter := mustNew(1e9, 0.001)
+	for i := 0; i < 100000; i++ {
+		filter.Add([]byte(fmt.Sprintf("domain-%d.example.com", i)))
+	}
+	return filter
+}
+
+// zipfValues returns values with a Zipf-distributed frequency, as typical for
+// e.g. the domains in network traffic.
+func zipfValues(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rng, 1.1, 1, 1e6)
+	values := make([][]byte, n)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("domain-%d.example.com", zipf.Uint64()))
+	}
+	return values
+}
+
+func BenchmarkCheckZipf(b *testing.B) {
+	filter := zipfFilter()
+	values := zipfValues(1 << 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		filter.Check(values[i%len(values)])
+	}
+}
+
+func BenchmarkCachedFilterZipf(b *testing.B) {
+	cached := NewCachedFilter(zipfFilter(), 16384)
+	values := zipfValues(1 << 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cached.Check(values[i%len(values)])
+	}
+}
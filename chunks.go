@@ -0,0 +1,261 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ChunkManifestFile is the name of the manifest file written by WriteChunks.
+const ChunkManifestFile = "manifest.json"
+
+// ChunkInfo describes a single chunk of a serialized filter.
+type ChunkInfo struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ChunkManifest describes a filter serialized into chunks by WriteChunks.
+type ChunkManifest struct {
+	Capacity          uint64      `json:"capacity"`
+	FalsePositiveProb float64     `json:"p"`
+	HashFuncs         uint64      `json:"k"`
+	Bits              uint64      `json:"m"`
+	ChunkSize         int64       `json:"chunk_size"`
+	TotalSize         int64       `json:"total_size"`
+	SHA256            string      `json:"sha256"`
+	Chunks            []ChunkInfo `json:"chunks"`
+}
+
+type chunkWriter struct {
+	dir       string
+	chunkSize int64
+	manifest  *ChunkManifest
+	total     hash.Hash
+	file      *os.File
+	buf       *bufio.Writer
+	hash      hash.Hash
+	written   int64
+	err       error
+}
+
+func (w *chunkWriter) finishChunk() error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.manifest.Chunks = append(w.manifest.Chunks, ChunkInfo{
+		Name:   filepath.Base(w.file.Name()),
+		Size:   w.written,
+		SHA256: hex.EncodeToString(w.hash.Sum(nil)),
+	})
+	w.file = nil
+	return nil
+}
+
+// Write writes p into the current chunk, starting new chunks as needed. As
+// Write may ignore errors while writing the header, the first error is kept
+// and returned on all subsequent calls.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.write(p)
+	w.err = err
+	return n, err
+}
+
+func (w *chunkWriter) write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		if w.file == nil {
+			name := fmt.Sprintf("chunk-%06d.bin", len(w.manifest.Chunks))
+			file, err := os.Create(filepath.Join(w.dir, name))
+			if err != nil {
+				return n, err
+			}
+			w.file = file
+			w.buf = bufio.NewWriter(file)
+			w.hash = sha256.New()
+			w.written = 0
+		}
+		part := p
+		if remaining := w.chunkSize - w.written; int64(len(part)) > remaining {
+			part = part[:remaining]
+		}
+		if _, err := w.buf.Write(part); err != nil {
+			return n, err
+		}
+		w.hash.Write(part)
+		w.total.Write(part)
+		w.written += int64(len(part))
+		w.manifest.TotalSize += int64(len(part))
+		n += len(part)
+		p = p[len(part):]
+		if w.written == w.chunkSize {
+			if err := w.finishChunk(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// WriteChunks writes the binary representation of the filter into numbered
+// chunk files of at most chunkSize bytes in the given directory, along with a
+// manifest file (see ChunkManifestFile) containing the SHA256 digest of every
+// chunk and of the complete representation. The chunk size must be a multiple
+// of 8, so that chunk boundaries in the bit array are word-aligned.
+func (s *BloomFilter) WriteChunks(dir string, chunkSize int64, opts ...WriteOption) (ChunkManifest, error) {
+	manifest := ChunkManifest{
+		Capacity:          s.n,
+		FalsePositiveProb: s.p,
+		HashFuncs:         s.k,
+		Bits:              s.m,
+		ChunkSize:         chunkSize,
+		Chunks:            []ChunkInfo{},
+	}
+	if chunkSize <= 0 || chunkSize%8 != 0 {
+		return manifest, fmt.Errorf("chunk size must be a positive multiple of 8, not %d", chunkSize)
+	}
+	w := &chunkWriter{
+		dir:       dir,
+		chunkSize: chunkSize,
+		manifest:  &manifest,
+		total:     sha256.New(),
+	}
+	err := s.Write(w, opts...)
+	if err == nil {
+		err = w.err
+	}
+	if err != nil {
+		if w.file != nil {
+			w.file.Close()
+		}
+		return manifest, err
+	}
+	if err := w.finishChunk(); err != nil {
+		return manifest, err
+	}
+	manifest.SHA256 = hex.EncodeToString(w.total.Sum(nil))
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, ioutil.WriteFile(filepath.Join(dir, ChunkManifestFile), encoded, 0644)
+}
+
+// ReadChunkManifest reads a manifest file written by WriteChunks.
+func ReadChunkManifest(path string) (ChunkManifest, error) {
+	var manifest ChunkManifest
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(encoded, &manifest)
+	return manifest, err
+}
+
+type chunkReader struct {
+	manifest ChunkManifest
+	open     func(name string) (io.Reader, error)
+	total    hash.Hash
+	next     int
+	current  []byte
+}
+
+// loadChunk reads the next chunk completely and verifies it before any of its
+// contents are passed on to the parser.
+func (r *chunkReader) loadChunk() error {
+	chunk := r.manifest.Chunks[r.next]
+	reader, err := r.open(chunk.Name)
+	if err != nil {
+		return err
+	}
+	if closer, ok := reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(reader, chunk.Size+1))
+	if err != nil {
+		return fmt.Errorf("cannot read chunk %s: %w", chunk.Name, err)
+	}
+	if int64(len(data)) != chunk.Size {
+		return fmt.Errorf("chunk %s has wrong size (%d bytes, expected %d)", chunk.Name, len(data), chunk.Size)
+	}
+	digest := sha256.Sum256(data)
+	if hex.EncodeToString(digest[:]) != chunk.SHA256 {
+		return fmt.Errorf("chunk %s is corrupt (SHA256 digest mismatch)", chunk.Name)
+	}
+	r.total.Write(data)
+	r.current = data
+	r.next++
+	return nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.current) == 0 {
+		if r.next >= len(r.manifest.Chunks) {
+			return 0, io.EOF
+		}
+		if err := r.loadChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.current)
+	r.current = r.current[n:]
+	return n, nil
+}
+
+// LoadFromChunks reassembles a filter from the chunks described by the given
+// manifest. The open function is called to obtain a reader for each chunk
+// name (readers implementing io.Closer are closed). Every chunk is verified
+// against its digest before it is used, and the digest of the complete
+// representation is verified at the end.
+func LoadFromChunks(manifest ChunkManifest, open func(name string) (io.Reader, error), opts ...LoadOption) (*BloomFilter, error) {
+	r := &chunkReader{
+		manifest: manifest,
+		open:     open,
+		total:    sha256.New(),
+	}
+	var filter BloomFilter
+	if err := filter.Read(r, opts...); err != nil {
+		return nil, err
+	}
+	// make sure that all chunks were consumed and verified
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(r.total.Sum(nil)) != manifest.SHA256 {
+		return nil, fmt.Errorf("reassembled filter is corrupt (SHA256 digest mismatch)")
+	}
+	return &filter, nil
+}
+
+// LoadFromChunkDir reassembles a filter from a directory written by
+// WriteChunks.
+func LoadFromChunkDir(dir string, opts ...LoadOption) (*BloomFilter, error) {
+	manifest, err := ReadChunkManifest(filepath.Join(dir, ChunkManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	return LoadFromChunks(manifest, func(name string) (io.Reader, error) {
+		return os.Open(filepath.Join(dir, filepath.Base(name)))
+	}, opts...)
+}
@@ -0,0 +1,96 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestChunks(t *testing.T, chunkSize int64) (*BloomFilter, ChunkManifest, string) {
+	filter, _ := GenerateExampleFilter(10000, 0.001, 1000)
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := filter.WriteChunks(dir, chunkSize)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return filter, manifest, dir
+}
+
+func TestChunksRoundTrip(t *testing.T) {
+	filter, manifest, dir := writeTestChunks(t, 4096)
+	defer os.RemoveAll(dir)
+
+	expectedChunks := int((manifest.TotalSize + 4095) / 4096)
+	if len(manifest.Chunks) != expectedChunks {
+		t.Fatalf("unexpected number of chunks: %d vs. %d", len(manifest.Chunks), expectedChunks)
+	}
+	for i, chunk := range manifest.Chunks {
+		if i < len(manifest.Chunks)-1 && chunk.Size != 4096 {
+			t.Fatalf("unexpected size of chunk %d: %d", i, chunk.Size)
+		}
+	}
+	if manifest.Bits != filter.m || manifest.HashFuncs != filter.k {
+		t.Fatal("manifest parameters do not match filter")
+	}
+
+	loaded, err := LoadFromChunkDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkFilters(filter, loaded, t) {
+		t.Fatal("filters do not match")
+	}
+}
+
+func TestChunksInvalidSize(t *testing.T) {
+	filter := mustNew(100, 0.01)
+	if _, err := filter.WriteChunks(os.TempDir(), 100); err == nil {
+		t.Fatal("chunk size that is not a multiple of 8 should be rejected")
+	}
+}
+
+func TestChunksCorruptMiddleChunk(t *testing.T) {
+	_, manifest, dir := writeTestChunks(t, 1024)
+	defer os.RemoveAll(dir)
+
+	middle := manifest.Chunks[len(manifest.Chunks)/2]
+	path := filepath.Join(dir, middle.Name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[10] ^= 0x01
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadFromChunkDir(dir)
+	if err == nil {
+		t.Fatal("corrupt chunk not detected")
+	}
+	if !strings.Contains(err.Error(), middle.Name) {
+		t.Fatalf("error does not name the corrupt chunk: %s", err)
+	}
+}
+
+func TestChunksTamperedManifest(t *testing.T) {
+	_, manifest, dir := writeTestChunks(t, 1024)
+	defer os.RemoveAll(dir)
+
+	manifest.SHA256 = strings.Repeat("0", 64)
+	_, err := LoadFromChunks(manifest, func(name string) (io.Reader, error) {
+		return os.Open(filepath.Join(dir, name))
+	})
+	if err == nil {
+		t.Fatal("total digest mismatch not detected")
+	}
+}
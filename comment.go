@@ -0,0 +1,126 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The comment region of version 2 of the file format is a line of ASCII text
+// directly following the header, so that tools like file, head or strings
+// show what a file is. Its size is declared in the flags field and the bit
+// array follows it. Filters with a comment region have the required flag
+// FormatFlagComment set, so that readers without support for it refuse them
+// instead of taking the comment for bits.
+const (
+	// FormatFlagComment is set in the flags field of filters with a comment
+	// region.
+	FormatFlagComment = 1 << 18
+	// FormatCommentOffset is the offset of the comment region.
+	FormatCommentOffset = FormatHeaderSize
+	// FormatCommentSizeMask selects the size of the comment region in words
+	// from the flags field.
+	FormatCommentSizeMask = 0xFF00
+	// FormatCommentSizeShift is the position of the size of the comment
+	// region in the flags field.
+	FormatCommentSizeShift = 8
+	// FormatMaxCommentSize is the maximum size of the comment region in
+	// bytes, including the terminating newline and the padding to a whole
+	// number of words.
+	FormatMaxCommentSize = 256
+)
+
+// ProjectURL is referred to by the comments written with WithComment.
+const ProjectURL = "https://github.com/DCSO/bloom"
+
+// WithComment makes Write store a one-line description of the filter and the
+// given comment, if any, in the comment region, e.g. "DCSO bloom filter v2,
+// n=1000, p=0.01, built 2024-05-01, see https://github.com/DCSO/bloom: feed
+// of example.com". The line is truncated to fit into FormatMaxCommentSize
+// bytes and characters other than printable ASCII are replaced by '?'. The
+// build date is left out of reproducible output. As the comment region is part
+// of version 2 of the file format, the filter is written in version 2. Without
+// this option, the comment of a filter that was read is written unchanged.
+func WithComment(comment string) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.comment = &comment
+	})
+}
+
+// Comment returns the line stored in the comment region of the filter that
+// was read, or written with WithComment, or an empty string if there is none.
+func (s *BloomFilter) Comment() string {
+	return s.comment
+}
+
+// describe returns the line written by WithComment.
+func (s *BloomFilter) describe(comment string, reproducible bool) string {
+	line := fmt.Sprintf("DCSO bloom filter v%d, n=%d, p=%g", FormatVersion2, s.n, s.p)
+	if !reproducible {
+		line += ", built " + time.Now().UTC().Format("2006-01-02")
+	}
+	line += ", see " + ProjectURL
+	if comment != "" {
+		line += ": " + comment
+	}
+	return sanitizeComment(line)
+}
+
+// sanitizeComment replaces the characters of a comment other than printable
+// ASCII and truncates it to fit into the comment region.
+func sanitizeComment(comment string) string {
+	b := []byte(comment)
+	for i, c := range b {
+		if c < ' ' || c > '~' {
+			b[i] = '?'
+		}
+	}
+	if len(b) > FormatMaxCommentSize-1 {
+		b = b[:FormatMaxCommentSize-1]
+	}
+	return string(b)
+}
+
+// encodeComment returns the comment region for a comment: the comment and a
+// newline, padded with zero bytes to a whole number of words.
+func encodeComment(comment string) []byte {
+	size := (len(comment) + 1 + FormatWordSize - 1) / FormatWordSize * FormatWordSize
+	region := make([]byte, size)
+	copy(region, comment)
+	region[len(comment)] = '\n'
+	return region
+}
+
+// commentSize returns the size in bytes of the comment region declared in
+// the flags field of a header.
+func commentSize(header []byte) (int, error) {
+	flags := binary.LittleEndian.Uint64(header[FormatFlagsOffset:])
+	size := int(flags&FormatCommentSizeMask>>FormatCommentSizeShift) * FormatWordSize
+	if size > FormatMaxCommentSize {
+		return 0, fmt.Errorf("comment region too large (%d > %d bytes)", size, FormatMaxCommentSize)
+	}
+	if size > 0 && flags&FormatVersionMask != FormatVersion2 {
+		return 0, fmt.Errorf("comment region in version %d", flags&FormatVersionMask)
+	}
+	if size > 0 && flags&FormatFlagComment == 0 {
+		return 0, fmt.Errorf("comment region without flag 0x%X", FormatFlagComment)
+	}
+	if size == 0 && flags&FormatFlagComment != 0 {
+		return 0, fmt.Errorf("flag 0x%X without a comment region", FormatFlagComment)
+	}
+	return size, nil
+}
+
+// decodeComment returns the comment of a comment region, i.e. its content up
+// to the newline or the padding.
+func decodeComment(region []byte) string {
+	if i := bytes.IndexAny(region, "\n\x00"); i >= 0 {
+		region = region[:i]
+	}
+	return strings.TrimSpace(string(region))
+}
@@ -0,0 +1,135 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestComment(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	filter.Add([]byte("foo"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf, WithComment("feed of\texample.com")); err != nil {
+		t.Fatal(err)
+	}
+	expected := "DCSO bloom filter v2, n=1000, p=0.01, built " + time.Now().UTC().Format("2006-01-02") +
+		", see " + ProjectURL + ": feed of?example.com"
+	// the comment is readable at the beginning of the file
+	if !bytes.HasPrefix(buf.Bytes()[FormatCommentOffset:], []byte(expected+"\n")) {
+		t.Fatalf("comment not found in %q", buf.Bytes()[:FormatCommentOffset+len(expected)+1])
+	}
+
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Comment() != expected || !loaded.Check([]byte("foo")) || loaded.NumElements() != 1 {
+		t.Fatalf("unexpected filter with comment %q", loaded.Comment())
+	}
+
+	// the resumable loader reads the comment as well
+	resumed := &BloomFilter{}
+	if _, done, err := NewResumableLoader(resumed).Feed(iotest.OneByteReader(bytes.NewReader(buf.Bytes()))); !done || err != nil {
+		t.Fatalf("resumable load failed: %v", err)
+	}
+	if resumed.Comment() != expected || !resumed.Check([]byte("foo")) {
+		t.Fatalf("unexpected resumed filter with comment %q", resumed.Comment())
+	}
+
+	// the comment is kept when written again
+	var rewritten bytes.Buffer
+	if err := loaded.Write(&rewritten); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewritten.Bytes(), buf.Bytes()) {
+		t.Fatal("comment not kept")
+	}
+
+	// reproducible output has no build date
+	buf.Reset()
+	if err := filter.Write(&buf, WithComment(""), Reproducible()); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = LoadFromBytes(buf.Bytes(), false); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "DCSO bloom filter v2, n=1000, p=0.01, see " + ProjectURL; loaded.Comment() != expected {
+		t.Fatalf("unexpected comment %q", loaded.Comment())
+	}
+}
+
+func TestCommentTruncated(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	var buf bytes.Buffer
+	if err := filter.Write(&buf, WithComment(strings.Repeat("x", 1000))); err != nil {
+		t.Fatal(err)
+	}
+	flags := binary.LittleEndian.Uint64(buf.Bytes()[FormatFlagsOffset:])
+	if size := flags & FormatCommentSizeMask >> FormatCommentSizeShift * FormatWordSize; size != FormatMaxCommentSize {
+		t.Fatalf("unexpected size of the comment region %d", size)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Comment()) != FormatMaxCommentSize-1 || !strings.HasSuffix(loaded.Comment(), "xxx") {
+		t.Fatalf("unexpected comment %q", loaded.Comment())
+	}
+
+	// a larger comment region is invalid
+	b := append([]byte(nil), buf.Bytes()...)
+	binary.LittleEndian.PutUint64(b[FormatFlagsOffset:], flags+1<<FormatCommentSizeShift)
+	if _, err := LoadFromBytes(b, false); err == nil || !strings.Contains(err.Error(), "comment region too large") {
+		t.Fatalf("expected an error for a large comment region, got %v", err)
+	}
+	// as is a comment region in version 1
+	binary.LittleEndian.PutUint64(b[FormatFlagsOffset:], FormatVersion1|1<<FormatCommentSizeShift)
+	if _, err := LoadFromBytes(b, false); err == nil {
+		t.Fatal("expected an error for a comment region in version 1")
+	}
+	// and a comment region without its required flag, or the reverse
+	if flags&FormatFlagComment == 0 {
+		t.Fatal("expected the comment flag to be set")
+	}
+	binary.LittleEndian.PutUint64(b[FormatFlagsOffset:], flags&^FormatFlagComment)
+	if _, err := LoadFromBytes(b, false); err == nil {
+		t.Fatal("expected an error for a comment region without its flag")
+	}
+	binary.LittleEndian.PutUint64(b[FormatFlagsOffset:], flags&^FormatCommentSizeMask)
+	if _, err := LoadFromBytes(b, false); err == nil {
+		t.Fatal("expected an error for the comment flag without a comment region")
+	}
+}
+
+func TestCommentAbsent(t *testing.T) {
+	// files written without a comment, in either version, have none
+	filter, err := LoadFilter("testdata/test.bloom", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.Comment() != "" {
+		t.Fatalf("unexpected comment %q", filter.Comment())
+	}
+	filter.SetMetadata("source", "test")
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if flags := binary.LittleEndian.Uint64(buf.Bytes()[FormatFlagsOffset:]); flags != FormatVersion2 {
+		t.Fatalf("unexpected flags %x", flags)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source, _ := loaded.Metadata("source"); loaded.Comment() != "" || source != "test" {
+		t.Fatalf("unexpected comment %q", loaded.Comment())
+	}
+}
@@ -0,0 +1,68 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MetadataKeyNormalization names the normalization applied to values before
+// they were added to the filter, see WithNormalization.
+const MetadataKeyNormalization = "bloom.normalization"
+
+// ErrIncompatible is returned by CompatibleWith if a filter was built with
+// settings that conflict with the given options.
+var ErrIncompatible = errors.New("filter is incompatible")
+
+// compatibilityKeys are the metadata keys describing how values are mapped to
+// the bits of a filter. Values must only be added to or checked against a
+// filter using the same settings.
+var compatibilityKeys = []string{
+	MetadataKeyNormalization,
+	MetadataKeyMaxValueLength,
+	MetadataKeyValueLengthPolicy,
+}
+
+// WithNormalization records the name of the normalization (e.g. "lowercase")
+// that the application applies to values before adding them to or checking
+// them against the filter, so that users of the filter can verify with
+// CompatibleWith that they normalize values in the same way. The filter does
+// not normalize values itself.
+func WithNormalization(name string) Option {
+	return func(s *BloomFilter) {
+		if name == "" {
+			s.DeleteMetadata(MetadataKeyNormalization)
+			return
+		}
+		s.SetMetadata(MetadataKeyNormalization, name)
+	}
+}
+
+// CompatibleWith returns an error wrapping ErrIncompatible if the settings
+// recorded in the filter that determine how values are mapped to bits, i.e.
+// the normalization and the value length limit, differ from those of a filter
+// created with the given options.
+func (s *BloomFilter) CompatibleWith(opts ...Option) error {
+	var reference BloomFilter
+	for _, opt := range opts {
+		opt(&reference)
+	}
+	for _, key := range compatibilityKeys {
+		v, ok := s.Metadata(key)
+		w, refOk := reference.Metadata(key)
+		if v != w || ok != refOk {
+			return fmt.Errorf("%w: %s is %s, not %s", ErrIncompatible, key,
+				describeSetting(v, ok), describeSetting(w, refOk))
+		}
+	}
+	return nil
+}
+
+func describeSetting(value string, ok bool) string {
+	if !ok {
+		return "not set"
+	}
+	return fmt.Sprintf("%q", value)
+}
@@ -0,0 +1,47 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCompatibleWith(t *testing.T) {
+	filter, err := New(1000, 0.01, WithNormalization("lowercase"), WithMaxValueLength(64, TruncateValues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.CompatibleWith(WithNormalization("lowercase"), WithMaxValueLength(64, TruncateValues)); err != nil {
+		t.Fatalf("filter should be compatible: %s", err)
+	}
+	for _, opts := range [][]Option{
+		nil,
+		{WithNormalization("lowercase")},
+		{WithNormalization("none"), WithMaxValueLength(64, TruncateValues)},
+		{WithNormalization("lowercase"), WithMaxValueLength(64, RejectValues)},
+		{WithNormalization("lowercase"), WithMaxValueLength(32, TruncateValues)},
+	} {
+		if err := loaded.CompatibleWith(opts...); !errors.Is(err, ErrIncompatible) {
+			t.Errorf("expected ErrIncompatible for %d options, got %v", len(opts), err)
+		}
+	}
+
+	plain := mustNew(1000, 0.01)
+	if err := plain.CompatibleWith(); err != nil {
+		t.Fatalf("plain filter should be compatible without options: %s", err)
+	}
+	if err := plain.CompatibleWith(WithNormalization("lowercase")); !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("expected ErrIncompatible, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+// DedupWindow remembers the most recently added distinct values, up to its
+// size, so that adding them again can be skipped. Since adding a value that
+// was added before does not change a filter, skipping it leaves the filter
+// exactly as it would be otherwise, but saves hashing the value and probing
+// its bits, which pays off for inputs that repeat values in bursts. The
+// values are compared exactly, not by hash, so a value is never skipped
+// unless it was added. A DedupWindow is not safe for concurrent use.
+type DedupWindow struct {
+	// ring holds the remembered values in the order they were inserted,
+	// with next the index of the oldest once it is full
+	ring   []string
+	next   int
+	seen   map[string]struct{}
+	hits   uint64
+	misses uint64
+}
+
+// NewDedupWindow returns a window remembering up to size values (at least
+// one).
+func NewDedupWindow(size int) *DedupWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &DedupWindow{
+		ring: make([]string, 0, size),
+		seen: make(map[string]struct{}, size),
+	}
+}
+
+// Contains returns true if the value is remembered by the window, counting a
+// hit, and false otherwise, counting a miss.
+func (w *DedupWindow) Contains(value []byte) bool {
+	if _, ok := w.seen[string(value)]; ok {
+		w.hits++
+		return true
+	}
+	w.misses++
+	return false
+}
+
+// Insert remembers a value that was added, forgetting the oldest one if the
+// window is full.
+func (w *DedupWindow) Insert(value []byte) {
+	s := string(value)
+	if _, ok := w.seen[s]; ok {
+		return
+	}
+	if len(w.ring) < cap(w.ring) {
+		w.ring = append(w.ring, s)
+	} else {
+		delete(w.seen, w.ring[w.next])
+		w.ring[w.next] = s
+		w.next = (w.next + 1) % len(w.ring)
+	}
+	w.seen[s] = struct{}{}
+}
+
+// Hits returns the number of calls of Contains that returned true.
+func (w *DedupWindow) Hits() uint64 {
+	return w.hits
+}
+
+// Misses returns the number of calls of Contains that returned false.
+func (w *DedupWindow) Misses() uint64 {
+	return w.misses
+}
@@ -0,0 +1,291 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// DirTreeValueFunc returns the values to add to a filter for a regular file
+// found by AddFromDirTree. It may be called concurrently for several files.
+type DirTreeValueFunc func(path string, info fs.FileInfo) ([][]byte, error)
+
+// DirTreeStats counts the files visited by AddFromDirTree.
+type DirTreeStats struct {
+	// Files is the number of regular files whose values were added.
+	Files uint64
+	// Values is the number of values added.
+	Values uint64
+	// Rejected is the number of values rejected due to their length.
+	Rejected uint64
+	// Skipped is the number of files and directories skipped due to errors,
+	// see SkipDirTreeErrors.
+	Skipped uint64
+}
+
+// DirTreeOption configures AddFromDirTree.
+type DirTreeOption func(*dirTreeOptions)
+
+type dirTreeOptions struct {
+	followSymlinks bool
+	concurrency    int
+	onError        func(path string, err error)
+	progress       func(path string, stats DirTreeStats)
+}
+
+// FollowSymlinks makes AddFromDirTree follow symbolic links to files and
+// directories. Directories reached more than once, e.g. through a link to one
+// of their parents, are only visited once. By default, symbolic links are
+// ignored.
+func FollowSymlinks() DirTreeOption {
+	return func(o *dirTreeOptions) {
+		o.followSymlinks = true
+	}
+}
+
+// WithDirTreeConcurrency sets the number of files for which AddFromDirTree
+// calls the value function concurrently (runtime.NumCPU() by default).
+func WithDirTreeConcurrency(n int) DirTreeOption {
+	return func(o *dirTreeOptions) {
+		o.concurrency = n
+	}
+}
+
+// SkipDirTreeErrors makes AddFromDirTree skip files and directories that
+// cannot be read or for which the value function fails, calling fn (if not
+// nil) with the path and the error, instead of stopping with the error.
+func SkipDirTreeErrors(fn func(path string, err error)) DirTreeOption {
+	return func(o *dirTreeOptions) {
+		o.onError = func(path string, err error) {
+			if fn != nil {
+				fn(path, err)
+			}
+		}
+	}
+}
+
+// WithDirTreeProgress makes AddFromDirTree call fn after the values of each
+// file were added, with the path of the file and the statistics so far.
+func WithDirTreeProgress(fn func(path string, stats DirTreeStats)) DirTreeOption {
+	return func(o *dirTreeOptions) {
+		o.progress = fn
+	}
+}
+
+// dirTreeFile is a regular file found by AddFromDirTree, whose values are
+// sent on the result channel once computed, or an error of the traversal
+// (without info) to be skipped.
+type dirTreeFile struct {
+	path   string
+	info   fs.FileInfo
+	result chan dirTreeResult
+}
+
+type dirTreeResult struct {
+	values [][]byte
+	err    error
+}
+
+// AddFromDirTree adds the values returned by valueFn for each regular file in
+// the directory tree below root to the filter, e.g. the SHA-256 hashes of the
+// contents of all files with FileSHA256. The filter must not be used by other
+// goroutines meanwhile. The tree is traversed in lexical order and the values
+// are added in that order, regardless of the concurrency, so that the same
+// tree always yields the same filter. Files other than regular files (e.g.
+// devices and named pipes) are ignored. The returned statistics cover all
+// files visited, also if an error is returned.
+func (s *BloomFilter) AddFromDirTree(root string, valueFn DirTreeValueFunc, opts ...DirTreeOption) (DirTreeStats, error) {
+	o := dirTreeOptions{
+		concurrency: runtime.NumCPU(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		o.concurrency = 1
+	}
+
+	// files are queued in traversal order; as the queue is bounded, so is
+	// the number of files whose values are held in memory
+	queue := make(chan *dirTreeFile, 2*o.concurrency)
+	jobs := make(chan *dirTreeFile)
+	done := make(chan struct{})
+	walkErr := make(chan error, 1)
+	w := &dirTreeWalker{options: &o, visited: make(map[string]bool)}
+	go func() {
+		defer close(queue)
+		defer close(jobs)
+		err := w.walk(root, func(file *dirTreeFile) bool {
+			select {
+			case queue <- file:
+			case <-done:
+				return false
+			}
+			if file.info == nil {
+				return true
+			}
+			select {
+			case jobs <- file:
+				return true
+			case <-done:
+				return false
+			}
+		})
+		if err == errDirTreeStopped {
+			err = nil
+		}
+		walkErr <- err
+	}()
+	for i := 0; i < o.concurrency; i++ {
+		go func() {
+			for file := range jobs {
+				values, err := valueFn(file.path, file.info)
+				file.result <- dirTreeResult{values, err}
+			}
+		}()
+	}
+
+	var stats DirTreeStats
+	err := func() error {
+		for file := range queue {
+			result := <-file.result
+			if result.err != nil {
+				if o.onError == nil {
+					return result.err
+				}
+				o.onError(file.path, result.err)
+				stats.Skipped++
+				continue
+			}
+			stats.Files++
+			for _, value := range result.values {
+				if s.TryAdd(value) != nil {
+					stats.Rejected++
+				} else {
+					stats.Values++
+				}
+			}
+			if o.progress != nil {
+				o.progress(file.path, stats)
+			}
+		}
+		return nil
+	}()
+	// stop the traversal if the values of a file could not be computed
+	close(done)
+	if werr := <-walkErr; err == nil {
+		err = werr
+	}
+	return stats, err
+}
+
+// errDirTreeStopped stops the traversal of a directory tree.
+var errDirTreeStopped = errors.New("traversal stopped")
+
+// dirTreeWalker traverses a directory tree for AddFromDirTree.
+type dirTreeWalker struct {
+	options *dirTreeOptions
+	// visited holds the resolved paths of the directories visited if
+	// symbolic links are followed
+	visited map[string]bool
+}
+
+// walk calls visit for each regular file below dir in lexical order, until
+// visit returns false, in which case errDirTreeStopped is returned.
+func (w *dirTreeWalker) walk(dir string, visit func(*dirTreeFile) bool) error {
+	if w.options.followSymlinks {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return w.skip(dir, err, visit)
+		}
+		if w.visited[resolved] {
+			return nil
+		}
+		w.visited[resolved] = true
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return w.skip(dir, err, visit)
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !w.options.followSymlinks {
+				continue
+			}
+			if info, err = os.Stat(path); err != nil {
+				if err = w.skip(path, err, visit); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		switch {
+		case info.IsDir():
+			if err = w.walk(path, visit); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			file := &dirTreeFile{path: path, info: info, result: make(chan dirTreeResult, 1)}
+			if !visit(file) {
+				return errDirTreeStopped
+			}
+		}
+	}
+	return nil
+}
+
+// skip returns the error of the traversal, unless errors are skipped, in
+// which case it is queued to be reported in order with the files.
+func (w *dirTreeWalker) skip(path string, err error, visit func(*dirTreeFile) bool) error {
+	if w.options.onError == nil {
+		return err
+	}
+	file := &dirTreeFile{path: path, result: make(chan dirTreeResult, 1)}
+	file.result <- dirTreeResult{err: err}
+	if !visit(file) {
+		return errDirTreeStopped
+	}
+	return nil
+}
+
+// FileBaseName is a DirTreeValueFunc returning the base name of the file.
+func FileBaseName(path string, info fs.FileInfo) ([][]byte, error) {
+	return [][]byte{[]byte(info.Name())}, nil
+}
+
+// RelativePath returns a DirTreeValueFunc returning the path of the file
+// relative to root, with '/' as separator on all systems.
+func RelativePath(root string) DirTreeValueFunc {
+	return func(path string, info fs.FileInfo) ([][]byte, error) {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte(filepath.ToSlash(rel))}, nil
+	}
+}
+
+// FileSHA256 is a DirTreeValueFunc returning the SHA-256 hash of the contents
+// of the file as lowercase hexadecimal string, as printed by sha256sum.
+func FileSHA256(path string, info fs.FileInfo) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return [][]byte{[]byte(hex.EncodeToString(h.Sum(nil)))}, nil
+}
@@ -0,0 +1,202 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeDirTree creates a directory tree with symbolic links to a file, to a
+// directory, to the root and to a missing file, and returns its root.
+func makeDirTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "dirtree")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, content := range map[string]string{
+		"a.txt":     "alpha",
+		"sub/b.txt": "beta",
+		"sub/c.txt": "gamma",
+	} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"link-file": "sub/b.txt",
+		"link-dir":  "sub",
+		"loop":      ".",
+		"dangling":  "missing",
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// addFromDirTree adds the relative paths in the tree to a new filter and
+// returns the filter, the paths in the order of the progress callbacks and
+// the skipped paths.
+func addFromDirTree(t *testing.T, root string, opts ...DirTreeOption) (*BloomFilter, []string, []string) {
+	filter, err := New(100, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress, skipped []string
+	opts = append(opts, WithDirTreeProgress(func(path string, stats DirTreeStats) {
+		rel, _ := filepath.Rel(root, path)
+		progress = append(progress, filepath.ToSlash(rel))
+		if stats.Files != uint64(len(progress)) {
+			t.Errorf("%s: unexpected stats %+v", path, stats)
+		}
+	}), SkipDirTreeErrors(func(path string, err error) {
+		rel, _ := filepath.Rel(root, path)
+		skipped = append(skipped, filepath.ToSlash(rel))
+	}))
+	stats, err := filter.AddFromDirTree(root, RelativePath(root), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != uint64(len(progress)) || stats.Values != stats.Files || stats.Skipped != uint64(len(skipped)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	return filter, progress, skipped
+}
+
+func TestAddFromDirTree(t *testing.T) {
+	root := makeDirTree(t)
+	defer os.RemoveAll(root)
+
+	filter, progress, skipped := addFromDirTree(t, root, WithDirTreeConcurrency(4))
+	if expected := []string{"a.txt", "sub/b.txt", "sub/c.txt"}; !reflect.DeepEqual(progress, expected) {
+		t.Fatalf("expected files %v, got %v", expected, progress)
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("unexpected skipped paths %v", skipped)
+	}
+	if !filter.Check([]byte("sub/b.txt")) || filter.Check([]byte("link-file")) {
+		t.Fatal("unexpected values")
+	}
+
+	// directories are visited once, under the first path reaching them
+	_, progress, skipped = addFromDirTree(t, root, FollowSymlinks())
+	if expected := []string{"a.txt", "link-dir/b.txt", "link-dir/c.txt", "link-file"}; !reflect.DeepEqual(progress, expected) {
+		t.Fatalf("expected files %v, got %v", expected, progress)
+	}
+	if expected := []string{"dangling"}; !reflect.DeepEqual(skipped, expected) {
+		t.Fatalf("expected skipped paths %v, got %v", expected, skipped)
+	}
+
+	// without SkipDirTreeErrors, the missing target is an error
+	filter, _ = New(100, 0.001)
+	if _, err := filter.AddFromDirTree(root, FileBaseName, FollowSymlinks()); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected a missing file, got %v", err)
+	}
+}
+
+func TestAddFromDirTreeDeterministic(t *testing.T) {
+	root := makeDirTree(t)
+	defer os.RemoveAll(root)
+
+	var serialized [][]byte
+	for _, concurrency := range []int{1, 8} {
+		filter, _, _ := addFromDirTree(t, root, FollowSymlinks(), WithDirTreeConcurrency(concurrency))
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		serialized = append(serialized, buf.Bytes())
+	}
+	if !bytes.Equal(serialized[0], serialized[1]) {
+		t.Fatal("filters differ depending on the concurrency")
+	}
+}
+
+func TestAddFromDirTreeErrors(t *testing.T) {
+	root := makeDirTree(t)
+	defer os.RemoveAll(root)
+
+	// unreadable files cannot be hashed, unless permissions are not enforced
+	// (e.g. when running as root)
+	unreadable := filepath.Join(root, "sub", "c.txt")
+	if err := os.Chmod(unreadable, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(unreadable); err == nil {
+		t.Log("permissions are not enforced, simulating an unreadable file")
+	}
+	failing := errors.New("unreadable")
+	valueFn := func(path string, info fs.FileInfo) ([][]byte, error) {
+		if path == unreadable {
+			if _, err := ioutil.ReadFile(path); err != nil {
+				return nil, err
+			}
+			return nil, failing
+		}
+		return FileSHA256(path, info)
+	}
+
+	filter, _ := New(100, 0.001)
+	stats, err := filter.AddFromDirTree(root, valueFn, WithDirTreeConcurrency(2))
+	if err == nil || stats.Files != 2 {
+		t.Fatalf("expected an error after 2 files, got %v (%+v)", err, stats)
+	}
+
+	filter, _ = New(100, 0.001)
+	var skipped []string
+	stats, err = filter.AddFromDirTree(root, valueFn, SkipDirTreeErrors(func(path string, err error) {
+		skipped = append(skipped, path)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 || stats.Skipped != 1 || !reflect.DeepEqual(skipped, []string{unreadable}) {
+		t.Fatalf("unexpected stats %+v, skipped %v", stats, skipped)
+	}
+	hash := sha256.Sum256([]byte("beta"))
+	if !filter.Check([]byte(hex.EncodeToString(hash[:]))) {
+		t.Fatal("hash of file not found")
+	}
+}
+
+func TestDirTreeValueFuncs(t *testing.T) {
+	root := makeDirTree(t)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "sub", "b.txt")
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		valueFn  DirTreeValueFunc
+		expected string
+	}{
+		{FileBaseName, "b.txt"},
+		{RelativePath(root), "sub/b.txt"},
+		{FileSHA256, "f44e64e75f3948e9f73f8dfa94721c4ce8cbb4f265c4790c702b2d41cfbf2753"},
+	} {
+		values, err := c.valueFn(path, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 || string(values[0]) != c.expected {
+			t.Errorf("expected %q, got %q", c.expected, values)
+		}
+	}
+}
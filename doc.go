@@ -0,0 +1,21 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package bloom implements a simple and highly efficient variant of the Bloom
+// filter that uses only two hash functions.
+//
+// Filters are created with New and loaded with LoadFilter, LoadFromReader,
+// LoadFromBytes or LoadFromFS. The functions reading and writing files take a
+// gzip flag that must match the way the file was written, as compression is
+// not detected automatically.
+//
+// A filter owns the memory of its bit array and its Data: loading never
+// retains the input (unless LoadFromBytes is given ZeroCopyData), SetData
+// copies its argument (unlike SetDataNoCopy), and GetData returns memory that
+// must not be modified.
+//
+// A BloomFilter is not safe for concurrent use: Check may be called from
+// multiple goroutines at once, but not while another goroutine modifies the
+// filter with Add, Join or Reset. Filters built concurrently should use one
+// filter per goroutine and be joined afterwards, or a StripedWriter.
+package bloom
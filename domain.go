@@ -0,0 +1,88 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DCSO/bloom/internal/punycode"
+)
+
+// punycodePrefix is the prefix of the labels of internationalized domain
+// names encoded in Punycode.
+const punycodePrefix = "xn--"
+
+// domainDots are the characters other than '.' that separate the labels of
+// internationalized domain names (ideographic and fullwidth full stops).
+var domainDots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// DomainForms returns the forms in which a domain name may have been added to
+// a filter: the name as given, its canonical form (in lowercase, without a
+// trailing dot), and the canonical form with all labels encoded in Punycode
+// ("xn--") and with all of them in Unicode, each once. Labels that are not
+// valid Punycode are kept as they are, so that the literal forms are checked
+// for any input.
+func DomainForms(domain string) []string {
+	forms := []string{domain}
+	add := func(form string) {
+		for _, f := range forms {
+			if f == form {
+				return
+			}
+		}
+		forms = append(forms, form)
+	}
+	canonical := strings.ToLower(strings.TrimSuffix(domainDots.Replace(domain), "."))
+	add(canonical)
+	labels := strings.Split(canonical, ".")
+	ascii := make([]string, len(labels))
+	unicode := make([]string, len(labels))
+	for i, label := range labels {
+		ascii[i], unicode[i] = asciiLabel(label), unicodeLabel(label)
+	}
+	add(strings.Join(ascii, "."))
+	add(strings.Join(unicode, "."))
+	return forms
+}
+
+// asciiLabel returns a label with non-ASCII characters encoded in Punycode,
+// or the label as is.
+func asciiLabel(label string) string {
+	for i := 0; i < len(label); i++ {
+		if label[i] >= utf8.RuneSelf {
+			if encoded, err := punycode.Encode(label); err == nil {
+				return punycodePrefix + encoded
+			}
+			break
+		}
+	}
+	return label
+}
+
+// unicodeLabel returns a label encoded in Punycode decoded to lowercase, or
+// the label as is if it is not encoded or not valid, i.e. does not decode to
+// non-ASCII characters that encode to the label again.
+func unicodeLabel(label string) string {
+	if !strings.HasPrefix(label, punycodePrefix) {
+		return label
+	}
+	decoded, err := punycode.Decode(label[len(punycodePrefix):])
+	if err != nil || asciiLabel(decoded) != label {
+		return label
+	}
+	return strings.ToLower(decoded)
+}
+
+// CheckDomainForms returns true if any of the forms of the domain name given
+// by DomainForms may be in the filter, so that a name matches regardless of
+// whether the filter was built from Unicode or Punycode names.
+func CheckDomainForms(filter Filter, domain string) bool {
+	for _, form := range DomainForms(domain) {
+		if filter.Check([]byte(form)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// idnFixtures are internationalized domain names in Unicode and Punycode.
+var idnFixtures = []struct {
+	unicode  string
+	punycode string
+}{
+	{"bücher.example", "xn--bcher-kva.example"},
+	{"www.münchen.de", "www.xn--mnchen-3ya.de"},
+	{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+	{"пример.испытание", "xn--e1afmkfd.xn--80akhbyknj4f"},
+}
+
+func TestDomainForms(t *testing.T) {
+	for _, c := range []struct {
+		domain string
+		forms  []string
+	}{
+		{"example.com", []string{"example.com"}},
+		{"Example.COM.", []string{"Example.COM.", "example.com"}},
+		{"Bücher.example", []string{"Bücher.example", "bücher.example", "xn--bcher-kva.example"}},
+		{"XN--BCHER-KVA.example.", []string{"XN--BCHER-KVA.example.", "xn--bcher-kva.example", "bücher.example"}},
+		// labels in both representations
+		{"bücher.xn--mnchen-3ya.de", []string{"bücher.xn--mnchen-3ya.de", "xn--bcher-kva.xn--mnchen-3ya.de", "bücher.münchen.de"}},
+		{"bücher。example", []string{"bücher。example", "bücher.example", "xn--bcher-kva.example"}},
+		// invalid Punycode is checked literally
+		{"xn--bcher-kv!.example", []string{"xn--bcher-kv!.example"}},
+		{"xn--abc-.example", []string{"xn--abc-.example"}},
+		{"", []string{""}},
+	} {
+		if forms := DomainForms(c.domain); !reflect.DeepEqual(forms, c.forms) {
+			t.Errorf("%q: expected %q, got %q", c.domain, c.forms, forms)
+		}
+	}
+}
+
+func TestCheckDomainForms(t *testing.T) {
+	unicode, punycode := mustNew(1000, 0.0001), mustNew(1000, 0.0001)
+	for _, f := range idnFixtures {
+		unicode.Add([]byte(f.unicode))
+		punycode.Add([]byte(f.punycode))
+	}
+	for _, filter := range []*BloomFilter{unicode, punycode} {
+		for _, f := range idnFixtures {
+			for _, domain := range []string{f.unicode, f.punycode, f.unicode + ".", strings.ToUpper(f.punycode)} {
+				if !CheckDomainForms(filter, domain) {
+					t.Errorf("%s does not match", domain)
+				}
+			}
+		}
+		if CheckDomainForms(filter, "example.com") || CheckDomainForms(filter, "xn--invalid-!.example") {
+			t.Error("unexpected match")
+		}
+	}
+	if unicode.Check([]byte(idnFixtures[0].punycode)) || punycode.Check([]byte(idnFixtures[0].unicode)) {
+		t.Fatal("the fixtures match without the alternate forms")
+	}
+	if !CheckDomainForms(unicode, "WWW.MÜNCHEN.DE.") {
+		t.Error("mixed-case name with trailing dot does not match")
+	}
+}
@@ -0,0 +1,91 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"encoding/binary"
+	"io"
+	"math/bits"
+)
+
+// All serialized integers, including the words of the bit array, are stored
+// in little-endian byte order regardless of the byte order of the host, so
+// that filters written on one platform can be read on any other. Reading and
+// writing the bit array uses the memory of the words directly on
+// little-endian hosts (see words_native.go), and converts each word on
+// big-endian hosts such as s390x or ppc64, or if built with the 'purego' tag.
+
+// wordChunkSize is the maximum number of words of the bit array read or
+// written at once (512 KiB).
+const wordChunkSize = 1 << 16
+
+// decodeWords decodes the little-endian words in src into dst, which holds
+// len(src)/FormatWordSize words.
+func decodeWords(dst []uint64, src []byte) {
+	for i := range dst {
+		dst[i] = binary.LittleEndian.Uint64(src[FormatWordSize*i:])
+	}
+}
+
+// encodeWords encodes the words in src into dst in little-endian byte order,
+// which holds FormatWordSize*len(src) bytes.
+func encodeWords(dst []byte, src []uint64) {
+	for i, word := range src {
+		binary.LittleEndian.PutUint64(dst[FormatWordSize*i:], word)
+	}
+}
+
+// swapWords reverses the byte order of the words, converting words loaded
+// from little-endian memory on a big-endian host (or vice versa).
+func swapWords(words []uint64) {
+	for i, word := range words {
+		words[i] = bits.ReverseBytes64(word)
+	}
+}
+
+// readWordsPortable reads the little-endian words of a bit array from r,
+// converting each word.
+func readWordsPortable(r io.Reader, words []uint64) error {
+	size := len(words)
+	if size > wordChunkSize {
+		size = wordChunkSize
+	}
+	buf := make([]byte, FormatWordSize*size)
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordChunkSize {
+			chunk = chunk[:wordChunkSize]
+		}
+		b := buf[:FormatWordSize*len(chunk)]
+		if _, err := io.ReadFull(r, b); err != nil {
+			return err
+		}
+		decodeWords(chunk, b)
+		words = words[len(chunk):]
+	}
+	return nil
+}
+
+// writeWordsPortable writes the words of a bit array to w in little-endian
+// byte order, converting each word.
+func writeWordsPortable(w io.Writer, words []uint64) error {
+	size := len(words)
+	if size > wordChunkSize {
+		size = wordChunkSize
+	}
+	buf := make([]byte, FormatWordSize*size)
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordChunkSize {
+			chunk = chunk[:wordChunkSize]
+		}
+		b := buf[:FormatWordSize*len(chunk)]
+		encodeWords(b, chunk)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		words = words[len(chunk):]
+	}
+	return nil
+}
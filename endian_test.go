@@ -0,0 +1,131 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+var wordPatterns = []struct {
+	serialized []byte
+	word       uint64
+}{
+	{[]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}, 0x0807060504030201},
+	{[]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, 1},
+	{[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, 1 << 63},
+	{[]byte{0xff, 0x00, 0xff, 0x00, 0x00, 0xff, 0x00, 0xff}, 0xff00ff0000ff00ff},
+}
+
+func TestDecodeWords(t *testing.T) {
+	for _, p := range wordPatterns {
+		words := make([]uint64, 1)
+		decodeWords(words, p.serialized)
+		if words[0] != p.word {
+			t.Errorf("%x: expected %#x, got %#x", p.serialized, p.word, words[0])
+		}
+		serialized := make([]byte, FormatWordSize)
+		encodeWords(serialized, words)
+		if !bytes.Equal(serialized, p.serialized) {
+			t.Errorf("%#x: expected %x, got %x", p.word, p.serialized, serialized)
+		}
+	}
+}
+
+// TestSwapWords simulates reading the bit array on a big-endian host, where
+// loading the serialized bytes from memory yields the big-endian value of
+// each word, which swapWords has to convert.
+func TestSwapWords(t *testing.T) {
+	for _, p := range wordPatterns {
+		words := []uint64{binary.BigEndian.Uint64(p.serialized)}
+		swapWords(words)
+		if words[0] != p.word {
+			t.Errorf("%x: expected %#x, got %#x", p.serialized, p.word, words[0])
+		}
+	}
+}
+
+func TestReadWriteWords(t *testing.T) {
+	// more than one chunk
+	words := make([]uint64, wordChunkSize+3)
+	rng := rand.New(rand.NewSource(1))
+	for i := range words {
+		words[i] = rng.Uint64()
+	}
+	expected := make([]byte, FormatWordSize*len(words))
+	encodeWords(expected, words)
+
+	var buf bytes.Buffer
+	if err := writeWords(&buf, words); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatal("written words are not little-endian")
+	}
+	buf.Reset()
+	if err := writeWordsPortable(&buf, words); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatal("portably written words are not little-endian")
+	}
+
+	for _, read := range []func([]byte, []uint64) error{
+		func(b []byte, words []uint64) error { return readWords(bytes.NewReader(b), words) },
+		func(b []byte, words []uint64) error { return readWordsPortable(bytes.NewReader(b), words) },
+	} {
+		readBack := make([]uint64, len(words))
+		if err := read(expected, readBack); err != nil {
+			t.Fatal(err)
+		}
+		for i := range words {
+			if readBack[i] != words[i] {
+				t.Fatalf("word %d: expected %#x, got %#x", i, words[i], readBack[i])
+			}
+		}
+		if err := read(expected[:len(expected)-1], readBack); err == nil {
+			t.Fatal("expected an error for truncated words")
+		}
+	}
+}
+
+// TestGoldenWords checks that the golden file gives the same bit array, and
+// thus the same membership results, through the host-specific and the
+// portable conversion of words.
+func TestGoldenWords(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/test.bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := LoadFromBytes(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, filter)
+
+	portable, err := LoadFromBytes(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readWordsPortable(bytes.NewReader(golden[FormatHeaderSize:]), portable.v); err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < filter.NumWords(); i++ {
+		if filter.WordAt(i) != portable.WordAt(i) {
+			t.Fatalf("word %d: %#x differs from %#x", i, filter.WordAt(i), portable.WordAt(i))
+		}
+	}
+	checkResults(t, portable)
+
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatal("written filter differs from the golden file")
+	}
+}
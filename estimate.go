@@ -0,0 +1,116 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"math"
+
+	"github.com/DCSO/bloom/internal/bitset"
+)
+
+// NumSetBits returns the number of bits set in the Bloom filter.
+func (s *BloomFilter) NumSetBits() uint64 {
+	return bitset.OnesCount(s.v)
+}
+
+// EstimatedNumElements returns an estimate of the number of distinct elements
+// in the Bloom filter, derived from the fraction of set bits (see Swamidass &
+// Baldi, 2007). Unlike N, the estimate does not depend on the order in which
+// values were added, nor does it assume that joined filters are disjoint. If
+// all bits are set, math.MaxUint64 is returned.
+func (s *BloomFilter) EstimatedNumElements() uint64 {
+	x := s.NumSetBits()
+	if x == 0 || s.k == 0 {
+		return 0
+	}
+	if x >= s.m {
+		return math.MaxUint64
+	}
+	e := -float64(s.m) / float64(s.k) * math.Log1p(-float64(x)/float64(s.m))
+	return uint64(math.Round(e))
+}
+
+// MatchConfidenceZ is the z-score of the 95% confidence interval of the
+// number of true matches estimated by CorrectMatchCount.
+const MatchConfidenceZ = 1.96
+
+// MatchCountEstimate is the number of matches of values checked against a
+// filter corrected for its false positives, see CorrectMatchCount. All of its
+// fields except for the inputs are estimates.
+type MatchCountEstimate struct {
+	// Matches is the number of values that matched out of Total values
+	// checked.
+	Matches, Total uint64
+	// FalsePositiveRate is the false positive rate of the filter.
+	FalsePositiveRate float64
+	// ExpectedFalsePositives is the expected number of false positives
+	// among the matches, i.e. the rate times the estimated number of values
+	// that were not added.
+	ExpectedFalsePositives float64
+	// TrueMatches is the estimated number of matches of values that were
+	// added, within the 95% confidence interval from TrueMatchesLow to
+	// TrueMatchesHigh.
+	TrueMatches, TrueMatchesLow, TrueMatchesHigh float64
+}
+
+// CorrectMatchCount estimates how many of the matches of total values checked
+// against a filter with the given false positive rate (e.g. its
+// EstimatedFalsePositiveProb) are false positives. Each of the values that
+// were not added matches independently with the rate, so that the matches
+// are the true matches t plus a binomial number of false positives with mean
+// rate*(total-t), from which t is estimated. The confidence interval is the
+// normal approximation of the binomial, clamped to the matches. If every
+// value matches (rate 1), nothing can be estimated, so the interval is
+// [0, matches].
+func CorrectMatchCount(matches, total uint64, fpRate float64) MatchCountEstimate {
+	if matches > total {
+		matches = total
+	}
+	fpRate = math.Max(0, math.Min(1, fpRate))
+	e := MatchCountEstimate{Matches: matches, Total: total, FalsePositiveRate: fpRate}
+	m, n := float64(matches), float64(total)
+	if fpRate == 1 {
+		e.ExpectedFalsePositives = m
+		e.TrueMatchesHigh = m
+		return e
+	}
+	t := math.Max(0, math.Min(m, (m-fpRate*n)/(1-fpRate)))
+	// t is the matches minus the false positives, whose count varies
+	// binomially among the other values
+	margin := MatchConfidenceZ * math.Sqrt((n-t)*fpRate*(1-fpRate)) / (1 - fpRate)
+	e.ExpectedFalsePositives = math.Min(m, fpRate*(n-t))
+	e.TrueMatches = t
+	e.TrueMatchesLow = math.Max(0, t-margin)
+	e.TrueMatchesHigh = math.Min(m, t+margin)
+	return e
+}
+
+// RemainingCapacityAt returns how many more distinct values can be added to
+// the Bloom filter before its FP probability, estimated from the fraction of
+// set bits like EstimatedFalsePositiveProb, exceeds targetP, or 0 if it
+// already does. The estimate is based on the set bits rather than on N, so it
+// also holds for joined filters and repeated values. Each value sets k bits
+// at random, so that after d more values a fraction (1-f)(1-1/m)^(kd) of the
+// bits is still unset, where f is the current fraction of set bits; solving
+// for the fraction targetP^(1/k) gives d.
+func (s *BloomFilter) RemainingCapacityAt(targetP float64) (uint64, error) {
+	if err := checkFPP(targetP); err != nil {
+		return 0, err
+	}
+	if s.m == 0 || s.k == 0 {
+		return 0, nil
+	}
+	fill := float64(s.NumSetBits()) / float64(s.m)
+	targetFill := math.Pow(targetP, 1/float64(s.k))
+	if fill >= targetFill {
+		return 0, nil
+	}
+	// both logarithms are negative, log1p keeps them precise for small fills
+	// and large m
+	d := (math.Log1p(-targetFill) - math.Log1p(-fill)) / (float64(s.k) * math.Log1p(-1/float64(s.m)))
+	if d >= math.MaxUint64 {
+		return math.MaxUint64, nil
+	}
+	return uint64(d), nil
+}
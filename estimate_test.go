@@ -0,0 +1,148 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEstimatedNumElements(t *testing.T) {
+	filter, _ := GenerateExampleFilter(100000, 0.001, 20000)
+	est := filter.EstimatedNumElements()
+	if est < 19500 || est > 20500 {
+		t.Fatalf("estimate too far from actual count: %d vs. 20000", est)
+	}
+	filter.Reset()
+	if filter.EstimatedNumElements() != 0 {
+		t.Fatal("estimate for empty filter should be zero")
+	}
+	if filter.NumSetBits() != 0 {
+		t.Fatal("empty filter should not have any bits set")
+	}
+}
+
+func TestCorrectMatchCount(t *testing.T) {
+	for _, c := range []struct {
+		matches, total             uint64
+		fpRate                     float64
+		fp, trueMatches, low, high float64
+	}{
+		// t = (1234 - 1000) / 0.9999, sd = sqrt((1e7 - t) * 1e-4 * 0.9999) / 0.9999
+		{1234, 10000000, 1e-4, 999.9766, 234.0234, 172.0404, 296.0064},
+		// t = (50 - 10) / 0.99, sd = sqrt((1000 - t) * 0.01 * 0.99) / 0.99
+		{50, 1000, 0.01, 9.5960, 40.4040, 34.3019, 46.5062},
+		// no false positives
+		{50, 1000, 0, 0, 50, 50, 50},
+		// fewer matches than expected false positives
+		{5, 1000000, 1e-4, 5, 0, 0, 5},
+		// every value matches
+		{1000, 1000, 1, 1000, 0, 0, 1000},
+		// no values checked
+		{0, 0, 0.01, 0, 0, 0, 0},
+	} {
+		e := CorrectMatchCount(c.matches, c.total, c.fpRate)
+		for _, v := range []struct {
+			name          string
+			got, expected float64
+		}{
+			{"false positives", e.ExpectedFalsePositives, c.fp},
+			{"true matches", e.TrueMatches, c.trueMatches},
+			{"lower bound", e.TrueMatchesLow, c.low},
+			{"upper bound", e.TrueMatchesHigh, c.high},
+		} {
+			if math.Abs(v.got-v.expected) > 1e-3 {
+				t.Errorf("%d of %d at %g: %s %f, expected %f", c.matches, c.total, c.fpRate, v.name, v.got, v.expected)
+			}
+		}
+	}
+}
+
+func reproducibleDigest(t *testing.T, values [][]byte) [sha256.Size]byte {
+	filter := mustNew(1000, 0.1)
+	filter.Data = []byte("foobar")
+	for _, v := range values {
+		filter.Add(v)
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf, Reproducible()); err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(buf.Bytes())
+}
+
+func TestReproducibleWrite(t *testing.T) {
+	// we overfill a small filter so that the insertion counter depends on
+	// the order of the values
+	values := make([][]byte, 2000)
+	for i := range values {
+		values[i] = GenerateTestValue(20)
+	}
+	expected := reproducibleDigest(t, values)
+	rng := rand.New(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		rng.Shuffle(len(values), func(i, j int) {
+			values[i], values[j] = values[j], values[i]
+		})
+		if reproducibleDigest(t, values) != expected {
+			t.Fatal("digest of reproducible output depends on insertion order")
+		}
+	}
+}
+
+func TestRemainingCapacityAt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	value := func() []byte {
+		v := make([]byte, 16)
+		rng.Read(v)
+		return v
+	}
+	for _, fill := range []uint64{0, 200, 500, 900} {
+		for _, targetP := range []float64{0.01, 0.05} {
+			// the remaining capacity is compared with the number of values
+			// added until the estimated FP probability exceeds targetP,
+			// averaged over several filters
+			const trials = 20
+			var predicted, simulated float64
+			for i := 0; i < trials; i++ {
+				filter := mustNew(1000, 0.01)
+				for j := uint64(0); j < fill; j++ {
+					filter.Add(value())
+				}
+				remaining, err := filter.RemainingCapacityAt(targetP)
+				if err != nil {
+					t.Fatal(err)
+				}
+				predicted += float64(remaining)
+				for filter.EstimatedFalsePositiveProb() <= targetP {
+					filter.Add(value())
+					simulated++
+				}
+			}
+			predicted /= trials
+			simulated /= trials
+			if math.Abs(predicted-simulated) > 0.05*simulated+5 {
+				t.Errorf("fill %d, p %g: predicted %.1f, simulated %.1f", fill, targetP, predicted, simulated)
+			}
+		}
+	}
+
+	filter, _ := GenerateExampleFilter(1000, 0.01, 3000)
+	if remaining, err := filter.RemainingCapacityAt(0.01); err != nil || remaining != 0 {
+		t.Fatalf("expected no remaining capacity for an overfilled filter, got %d (%v)", remaining, err)
+	}
+	for _, p := range []float64{0, 1, math.NaN()} {
+		if _, err := filter.RemainingCapacityAt(p); !errors.Is(err, ErrInvalidFPP) {
+			t.Errorf("p = %g: expected ErrInvalidFPP, got %v", p, err)
+		}
+	}
+	empty := mustNew(1000, 0.01)
+	if remaining := empty.Stats().RemainingCapacity; remaining < 950 || remaining > 1050 {
+		t.Fatalf("unexpected remaining capacity %d of an empty filter", remaining)
+	}
+}
@@ -0,0 +1,333 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Metadata keys for the exact counts determined with WithExactCounting.
+const (
+	// MetadataKeyExactCount is the exact number of distinct values added.
+	MetadataKeyExactCount = "bloom.exact-count"
+	// MetadataKeyExactDuplicates is the exact number of values added that
+	// had been added before.
+	MetadataKeyExactDuplicates = "bloom.exact-duplicates"
+)
+
+// DefaultExactCountingMemory is the memory in bytes used by WithExactCounting
+// for hashes before they are spilled to disk, if no other limit is given.
+const DefaultExactCountingMemory = 64 << 20
+
+// exactCountMergeFanIn is the maximum number of runs kept on disk before they
+// are merged into a single run, which bounds the number of open files.
+const exactCountMergeFanIn = 64
+
+// ExactCount holds the exact counts determined with WithExactCounting.
+type ExactCount struct {
+	// Distinct is the number of distinct values added.
+	Distinct uint64
+	// Duplicates is the number of values added that had been added before.
+	Duplicates uint64
+}
+
+// WithExactCounting makes the filter track the 128-bit hashes of all values
+// added to it in a sidecar, so that FinishExactCount can determine the exact
+// number of distinct values. At most maxMemory bytes
+// (DefaultExactCountingMemory if zero or less) are used for hashes in memory;
+// further hashes are spilled to sorted runs in temporary files, which are
+// merged at the end.
+func WithExactCounting(maxMemory int64) Option {
+	return func(s *BloomFilter) {
+		if maxMemory <= 0 {
+			maxMemory = DefaultExactCountingMemory
+		}
+		capacity := maxMemory / exactHashSize
+		if capacity < 1 {
+			capacity = 1
+		}
+		s.exactCounter = &exactCounter{capacity: int(capacity)}
+	}
+}
+
+// FinishExactCount determines the exact counts of the values added since the
+// filter was created with WithExactCounting, stores them in the metadata and
+// discards the sidecar, including all temporary files.
+func (s *BloomFilter) FinishExactCount() (ExactCount, error) {
+	c := s.exactCounter
+	if c == nil {
+		return ExactCount{}, errors.New("exact counting is not enabled for this filter")
+	}
+	s.exactCounter = nil
+	count, err := c.finish()
+	if err != nil {
+		return count, err
+	}
+	s.SetMetadata(MetadataKeyExactCount, strconv.FormatUint(count.Distinct, 10))
+	s.SetMetadata(MetadataKeyExactDuplicates, strconv.FormatUint(count.Duplicates, 10))
+	return count, nil
+}
+
+// ExactCount returns the exact counts stored by FinishExactCount, and whether
+// they are present. They are removed when values are added to the filter
+// afterwards, or when it is joined or reset.
+func (s *BloomFilter) ExactCount() (ExactCount, bool) {
+	distinct, err := strconv.ParseUint(s.meta[MetadataKeyExactCount], 10, 64)
+	if err != nil {
+		return ExactCount{}, false
+	}
+	duplicates, _ := strconv.ParseUint(s.meta[MetadataKeyExactDuplicates], 10, 64)
+	return ExactCount{Distinct: distinct, Duplicates: duplicates}, true
+}
+
+// dropExactCount removes the exact counts stored by FinishExactCount, which
+// are stale once the bits of the filter change.
+func (s *BloomFilter) dropExactCount() {
+	s.DeleteMetadata(MetadataKeyExactCount)
+	s.DeleteMetadata(MetadataKeyExactDuplicates)
+}
+
+const exactHashSize = 16
+
+type exactHash [exactHashSize]byte
+
+func lessExactHash(a, b exactHash) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// exactCounter collects the hashes of added values in memory and spills them
+// to sorted, deduplicated runs on disk when the memory limit is reached.
+type exactCounter struct {
+	capacity int
+	hashes   []exactHash
+	runs     []string
+	total    uint64
+	// err is the first error that occurred while spilling, reported by finish
+	err error
+}
+
+func (c *exactCounter) add(value []byte) {
+	hv := fnv.New128a()
+	hv.Write(value)
+	c.addHash(hv)
+}
+
+// addHash adds the value whose 128-bit FNV-1a hash is computed by hv.
+func (c *exactCounter) addHash(hv hash.Hash) {
+	c.total++
+	if c.err != nil {
+		return
+	}
+	var h exactHash
+	hv.Sum(h[:0])
+	c.hashes = append(c.hashes, h)
+	if len(c.hashes) >= c.capacity {
+		c.err = c.spill()
+	}
+}
+
+// sortHashes sorts the hashes in memory and removes duplicates.
+func (c *exactCounter) sortHashes() {
+	sort.Slice(c.hashes, func(i, j int) bool {
+		return lessExactHash(c.hashes[i], c.hashes[j])
+	})
+	unique := c.hashes[:0]
+	for i, h := range c.hashes {
+		if i == 0 || h != unique[len(unique)-1] {
+			unique = append(unique, h)
+		}
+	}
+	c.hashes = unique
+}
+
+// spill writes the hashes in memory to a new run.
+func (c *exactCounter) spill() error {
+	c.sortHashes()
+	name, err := writeRun(func(emit func(exactHash) error) error {
+		for _, h := range c.hashes {
+			if err := emit(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.hashes = c.hashes[:0]
+	c.runs = append(c.runs, name)
+	if len(c.runs) >= exactCountMergeFanIn {
+		name, err = writeRun(func(emit func(exactHash) error) error {
+			return mergeRuns(c.runs, nil, emit)
+		})
+		if err != nil {
+			return err
+		}
+		c.removeRuns()
+		c.runs = []string{name}
+	}
+	return nil
+}
+
+func (c *exactCounter) removeRuns() {
+	for _, name := range c.runs {
+		os.Remove(name)
+	}
+	c.runs = nil
+}
+
+func (c *exactCounter) finish() (ExactCount, error) {
+	defer c.removeRuns()
+	if c.err != nil {
+		return ExactCount{}, fmt.Errorf("exact counting failed: %w", c.err)
+	}
+	c.sortHashes()
+	var distinct uint64
+	err := mergeRuns(c.runs, c.hashes, func(exactHash) error {
+		distinct++
+		return nil
+	})
+	c.hashes = nil
+	if err != nil {
+		return ExactCount{}, fmt.Errorf("exact counting failed: %w", err)
+	}
+	return ExactCount{Distinct: distinct, Duplicates: c.total - distinct}, nil
+}
+
+// writeRun writes the hashes emitted by fn to a new temporary file and
+// returns its name.
+func writeRun(fn func(emit func(exactHash) error) error) (string, error) {
+	f, err := ioutil.TempFile("", "bloom-exact")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	err = fn(func(h exactHash) error {
+		_, err := w.Write(h[:])
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// runReader reads the sorted hashes of a run.
+type runReader struct {
+	r       io.Reader
+	current exactHash
+}
+
+func (r *runReader) next() (bool, error) {
+	_, err := io.ReadFull(r.r, r.current[:])
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// hashSliceReader reads hashes in memory like a run.
+type hashSliceReader struct {
+	hashes []exactHash
+	offset int
+}
+
+func (r *hashSliceReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && len(r.hashes) > 0 {
+		c := copy(p[n:], r.hashes[0][r.offset:])
+		n += c
+		r.offset += c
+		if r.offset == exactHashSize {
+			r.hashes = r.hashes[1:]
+			r.offset = 0
+		}
+	}
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return lessExactHash(h[i].current, h[j].current) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns merges the sorted runs in the named files and the sorted hashes
+// in memory, and emits each distinct hash once, in order.
+func mergeRuns(names []string, hashes []exactHash, emit func(exactHash) error) error {
+	readers := make([]io.Reader, 0, len(names)+1)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		readers = append(readers, bufio.NewReader(f))
+	}
+	if len(hashes) > 0 {
+		readers = append(readers, &hashSliceReader{hashes: hashes})
+	}
+	h := make(runHeap, 0, len(readers))
+	for _, r := range readers {
+		rr := &runReader{r: r}
+		ok, err := rr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, rr)
+		}
+	}
+	heap.Init(&h)
+	var last exactHash
+	first := true
+	for h.Len() > 0 {
+		rr := h[0]
+		if first || rr.current != last {
+			if err := emit(rr.current); err != nil {
+				return err
+			}
+			last = rr.current
+			first = false
+		}
+		ok, err := rr.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
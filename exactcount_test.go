@@ -0,0 +1,124 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testExactCount(t *testing.T, maxMemory int64, distinct, repeats int) {
+	filter, err := New(uint64(distinct), 0.01, WithExactCounting(maxMemory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := 0; r < repeats; r++ {
+		for i := 0; i < distinct; i++ {
+			filter.Add([]byte(fmt.Sprintf("value-%d", (i*7919+r)%distinct)))
+		}
+	}
+	count, err := filter.FinishExactCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ExactCount{Distinct: uint64(distinct), Duplicates: uint64(distinct * (repeats - 1))}
+	if count != expected {
+		t.Fatalf("unexpected count %+v, expected %+v", count, expected)
+	}
+	if stored, ok := filter.ExactCount(); !ok || stored != expected {
+		t.Fatalf("unexpected stored count %+v", stored)
+	}
+}
+
+func TestExactCounting(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpdir := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+	defer os.Setenv("TMPDIR", tmpdir)
+
+	// in memory only
+	testExactCount(t, 0, 1000, 5)
+	// spilling to a few runs
+	testExactCount(t, 1000*exactHashSize, 5000, 4)
+	// spilling to enough runs to merge them before finishing
+	testExactCount(t, 100*exactHashSize, 2000, 5)
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("%d temporary files were not removed", len(files))
+	}
+}
+
+func TestExactCountRoundTrip(t *testing.T) {
+	filter, _ := New(1000, 0.01, WithExactCounting(0))
+	if _, ok := filter.ExactCount(); ok {
+		t.Fatal("count should not be present before finishing")
+	}
+	filter.Add([]byte("foo"))
+	filter.Add([]byte("foo"))
+	filter.Add([]byte("bar"))
+	if _, err := filter.FinishExactCount(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.FinishExactCount(); err == nil {
+		t.Fatal("finishing twice should fail")
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, ok := loaded.ExactCount(); !ok || count != (ExactCount{Distinct: 2, Duplicates: 1}) {
+		t.Fatalf("unexpected count after loading: %+v", count)
+	}
+}
+
+func TestExactCountDroppedOnChange(t *testing.T) {
+	finished := func() *BloomFilter {
+		filter, _ := New(1000, 0.01, WithExactCounting(0))
+		filter.Add([]byte("foo"))
+		if _, err := filter.FinishExactCount(); err != nil {
+			t.Fatal(err)
+		}
+		return filter
+	}
+	other := mustNew(1000, 0.01)
+	other.Add([]byte("bar"))
+	for name, change := range map[string]func(*BloomFilter) error{
+		"Add":             func(f *BloomFilter) error { f.Add([]byte("bar")); return nil },
+		"Join":            func(f *BloomFilter) error { return f.Join(other) },
+		"JoinEstimate":    func(f *BloomFilter) error { return f.JoinEstimate(other) },
+		"JoinWithOptions": func(f *BloomFilter) error { _, err := f.JoinWithOptions(other); return err },
+		"Reset":           func(f *BloomFilter) error { f.Reset(); return nil },
+		"MergeWords":      func(f *BloomFilter) error { f.MergeWords(0, other.v, false); return nil },
+	} {
+		filter := finished()
+		if err := change(filter); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if count, ok := filter.ExactCount(); ok {
+			t.Errorf("%s: stale exact count %+v", name, count)
+		}
+	}
+
+	// adding a value that is already present does not change the filter
+	filter := finished()
+	filter.Add([]byte("foo"))
+	if _, ok := filter.ExactCount(); !ok {
+		t.Error("exact count dropped although the filter did not change")
+	}
+}
@@ -0,0 +1,125 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom_test
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/DCSO/bloom"
+	"github.com/DCSO/bloom/bloomtest"
+)
+
+//go:embed testdata/test.bloom
+var testdata embed.FS
+
+// Creating a filter and checking values.
+func Example() {
+	filter, err := bloom.New(1000, 0.001)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter.Add([]byte("foo"))
+	filter.Add([]byte("bar"))
+
+	fmt.Println(filter.Check([]byte("foo")))
+	fmt.Println(filter.Check([]byte("baz")))
+	// Output:
+	// true
+	// false
+}
+
+// Serializing a filter to a buffer and reading it back.
+func Example_serialize() {
+	filter, err := bloom.New(1000, 0.001)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter.Add([]byte("foo"))
+
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		log.Fatal(err)
+	}
+	loaded, err := bloom.LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(loaded.Check([]byte("foo")), loaded.N)
+	// Output: true 1
+}
+
+// Loading a filter embedded into the binary.
+func Example_embed() {
+	filter, err := bloom.LoadFromFS(testdata, "testdata/test.bloom", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(filter.Check([]byte("foo")), filter.Check([]byte("qux")))
+	// Output: true false
+}
+
+// Writing and loading a gzip-compressed filter file. The gzip flag must be
+// the same for writing and loading, as it is not detected automatically.
+func Example_gzip() {
+	dir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.bloom.gz")
+
+	filter, err := bloom.New(1000, 0.001)
+	if err != nil {
+		log.Fatal(err)
+	}
+	filter.Add([]byte("foo"))
+	if err := bloom.WriteFilter(filter, path, true); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := bloom.LoadFilter(path, false); err != nil {
+		fmt.Println("loading without gzip fails")
+	}
+	loaded, err := bloom.LoadFilter(path, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(loaded.Check([]byte("foo")))
+	// Output:
+	// loading without gzip fails
+	// true
+}
+
+// Joining two filters with the same dimensions. Filters are not safe for
+// concurrent use, so filters built in separate goroutines are best joined
+// afterwards.
+func Example_join() {
+	a, _ := bloom.New(1000, 0.001)
+	b, _ := bloom.New(1000, 0.001)
+	a.Add([]byte("foo"))
+	b.Add([]byte("bar"))
+
+	if err := a.Join(b); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(a.Check([]byte("foo")), a.Check([]byte("bar")), a.N)
+	// Output: true true 2
+}
+
+// Measuring the false positive rate of a filter filled to its capacity.
+func Example_falsePositiveRate() {
+	filter, _ := bloom.New(10000, 0.01)
+	for _, value := range bloomtest.RandomValues(10000, bloomtest.DefaultValueLength, 1) {
+		filter.Add(value)
+	}
+	rate := bloomtest.FalsePositiveRate(filter, 100000, 2)
+	fmt.Println(rate > 0.005 && rate < 0.015)
+	// Output: true
+}
@@ -0,0 +1,129 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+)
+
+// MetadataKeyExclusions stores the exclusion set written by
+// WriteWithExclusions: a format version byte followed by the sorted SHA-256
+// digests of the excluded values.
+const MetadataKeyExclusions = "bloom.exclusions"
+
+// exclusionsVersion is the version of the exclusion set encoding.
+const exclusionsVersion = 1
+
+// maxExclusions is the maximum number of values in an exclusion set, whose
+// digests take up to maxEmbeddedSize bytes of the metadata.
+const maxExclusions = (maxEmbeddedSize - 1) / sha256.Size
+
+type exclusionSet map[[sha256.Size]byte]struct{}
+
+// Exclusions makes Write store the given values in an exclusion set with the
+// filter; see WriteWithExclusions.
+func Exclusions(values [][]byte) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.exclusions = append(o.exclusions, values...)
+	})
+}
+
+// WriteWithExclusions writes the filter like Write, together with an exact set
+// of values that filters loaded from the output report as not contained, even
+// though their bits are set. This allows publishing a filter without letting
+// sensitive values match, as bits cannot be unset safely.
+//
+// The exclusion set only affects filters loaded from the written output, the
+// filter itself is not modified. Only values that are found in the filter are
+// stored, as SHA-256 digests, and existing exclusions of the filter are kept.
+// Note that the digests still allow anyone to test candidate values for
+// having been excluded.
+//
+// Each exclusion takes 32 bytes of the metadata, so the set holds at most
+// about 520,000 values; an error wrapping ErrMetadataTooLarge is returned for
+// more, and nothing is written.
+func (s *BloomFilter) WriteWithExclusions(w io.Writer, exclusions [][]byte, opts ...WriteOption) error {
+	return s.Write(w, append(opts, Exclusions(exclusions))...)
+}
+
+// excluded returns true if the value is in the exclusion set of the filter.
+func (s *BloomFilter) excluded(value []byte) bool {
+	if len(s.exclusions) == 0 {
+		return false
+	}
+	_, ok := s.exclusions[sha256.Sum256(s.truncate(value))]
+	return ok
+}
+
+// excludedHash returns true if the value whose SHA-256 digest is computed by
+// h is in the exclusion set of the filter.
+func (s *BloomFilter) excludedHash(h hash.Hash) bool {
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+	_, ok := s.exclusions[digest]
+	return ok
+}
+
+// encodeExclusions returns the encoded exclusion set consisting of the
+// existing exclusions and those of the given values found in the filter. An
+// error wrapping ErrMetadataTooLarge is returned if it holds more than
+// maxExclusions values.
+func (s *BloomFilter) encodeExclusions(values [][]byte) (string, error) {
+	digests := make([][sha256.Size]byte, 0, len(s.exclusions)+len(values))
+	set := make(exclusionSet, len(s.exclusions)+len(values))
+	for digest := range s.exclusions {
+		set[digest] = struct{}{}
+		digests = append(digests, digest)
+	}
+	for _, value := range values {
+		if !s.Check(value) {
+			continue
+		}
+		digest := sha256.Sum256(s.truncate(value))
+		if _, ok := set[digest]; !ok {
+			set[digest] = struct{}{}
+			digests = append(digests, digest)
+		}
+	}
+	if len(digests) > maxExclusions {
+		return "", fmt.Errorf("%w: exclusion set of %d values (maximum is %d)", ErrMetadataTooLarge, len(digests), maxExclusions)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i][:], digests[j][:]) < 0
+	})
+	buf := make([]byte, 1, 1+len(digests)*sha256.Size)
+	buf[0] = exclusionsVersion
+	for _, digest := range digests {
+		buf = append(buf, digest[:]...)
+	}
+	return string(buf), nil
+}
+
+// loadExclusions restores the exclusion set from the metadata.
+func (s *BloomFilter) loadExclusions() error {
+	s.exclusions = nil
+	v, ok := s.Metadata(MetadataKeyExclusions)
+	if !ok {
+		return nil
+	}
+	if len(v) == 0 || v[0] != exclusionsVersion {
+		return fmt.Errorf("unsupported exclusion set version")
+	}
+	v = v[1:]
+	if len(v)%sha256.Size != 0 {
+		return fmt.Errorf("invalid exclusion set length %d", len(v))
+	}
+	s.exclusions = make(exclusionSet, len(v)/sha256.Size)
+	for i := 0; i < len(v); i += sha256.Size {
+		var digest [sha256.Size]byte
+		copy(digest[:], v[i:i+sha256.Size])
+		s.exclusions[digest] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestWriteWithExclusions(t *testing.T) {
+	filter, values := GenerateExampleFilter(1000, 0.001, 100)
+	exclusions := [][]byte{values[0], values[1], []byte("not in the filter")}
+	var buf bytes.Buffer
+	if err := filter.WriteWithExclusions(&buf, exclusions); err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range values {
+		if !filter.Check(value) {
+			t.Fatal("writing exclusions should not modify the filter")
+		}
+	}
+
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.exclusions) != 2 {
+		t.Fatalf("only excluded values in the filter should be stored, got %d", len(loaded.exclusions))
+	}
+	for i, value := range values {
+		if i < 2 {
+			if loaded.Check(value) {
+				t.Errorf("excluded value %q should not match", value)
+			}
+		} else if !loaded.Check(value) {
+			t.Errorf("value %q should still match", value)
+		}
+	}
+	if loaded.CheckAny(values[:2]) || loaded.CheckAll(values[1:3]) {
+		t.Error("CheckAny/CheckAll should honour exclusions")
+	}
+	if !loaded.CheckAll(values[2:]) {
+		t.Error("CheckAll should match the remaining values")
+	}
+
+	// exclusions are kept on rewrite and extended by further exclusions
+	buf.Reset()
+	if err := loaded.WriteWithExclusions(&buf, [][]byte{values[2]}); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, value := range values[:4] {
+		if reloaded.Check(value) != (i == 3) {
+			t.Errorf("unexpected result for value %q after rewrite", value)
+		}
+	}
+}
+
+func TestWriteWithoutExclusions(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000, 0.001, 100)
+	var plain, excluded bytes.Buffer
+	if err := filter.Write(&plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.WriteWithExclusions(&excluded, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain.Bytes(), excluded.Bytes()) {
+		t.Fatal("writing without exclusions should not change the output")
+	}
+}
+
+func TestTooManyExclusions(t *testing.T) {
+	// all values are found in a filter with all bits set
+	filter := mustNew(100, 0.01)
+	for i := range filter.v {
+		filter.v[i] = ^uint64(0)
+	}
+	values := make([][]byte, maxExclusions+1)
+	for i := range values {
+		values[i] = []byte(strconv.Itoa(i))
+	}
+
+	var buf bytes.Buffer
+	if err := filter.WriteWithExclusions(&buf, values[:maxExclusions]); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.exclusions) != maxExclusions {
+		t.Fatalf("expected %d exclusions, got %d", maxExclusions, len(loaded.exclusions))
+	}
+
+	buf.Reset()
+	if err := filter.WriteWithExclusions(&buf, values); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes written", buf.Len())
+	}
+	// the existing exclusions count towards the limit
+	if err := loaded.WriteWithExclusions(&buf, values[maxExclusions:]); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+}
+
+func TestInvalidExclusions(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000, 0.001, 10)
+	for _, v := range []string{"", "\x02", "\x01abc"} {
+		filter.SetMetadata(MetadataKeyExclusions, v)
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadFromBytes(buf.Bytes(), false); err == nil {
+			t.Errorf("invalid exclusion set %q should be rejected", v)
+		}
+	}
+}
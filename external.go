@@ -0,0 +1,262 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FormatFlagExternalData is set in the flags field of filters whose Data
+// section is stored externally. The section is then empty, and the reference
+// to the data, its SHA-256 digest and its size are stored in the metadata
+// (see MetadataKeyExternalData).
+const FormatFlagExternalData = 1 << 16
+
+const (
+	// MetadataKeyExternalData is the reference to the externally stored
+	// data, a path (relative to the filter file unless absolute) or an
+	// http(s) URL.
+	MetadataKeyExternalData = "bloom.external-data"
+	// MetadataKeyExternalDataSHA256 is the hex-encoded SHA-256 digest of the
+	// externally stored data.
+	MetadataKeyExternalDataSHA256 = "bloom.external-data-sha256"
+	// MetadataKeyExternalDataSize is the size in bytes of the externally
+	// stored data.
+	MetadataKeyExternalDataSize = "bloom.external-data-size"
+)
+
+// DataResolution controls when the externally stored data of a filter is
+// fetched, see WithDataResolution.
+type DataResolution int
+
+const (
+	// DataResolve fetches the data while the filter is loaded, failing the
+	// load if it cannot be fetched or does not match its digest.
+	DataResolve DataResolution = iota
+	// DataSkip does not fetch the data, so that GetData returns nil unless
+	// it is fetched with LoadData.
+	DataSkip
+	// DataLazy fetches the data on the first call of GetData or LoadData.
+	DataLazy
+)
+
+// WithDataResolution sets when the externally stored data of a filter is
+// fetched (DataResolve by default). It has no effect on filters storing their
+// data in the file.
+func WithDataResolution(mode DataResolution) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.dataResolution = mode
+	})
+}
+
+// DataResolver opens the externally stored data of filters.
+type DataResolver interface {
+	// Open returns the data referenced by ref, see
+	// MetadataKeyExternalData.
+	Open(ref string) (io.ReadCloser, error)
+}
+
+// WithDataResolver sets the resolver of externally stored data. By default,
+// http(s) URLs are opened with an HTTPDataResolver and paths with a
+// FileDataResolver relative to the directory of the filter file, if known.
+func WithDataResolver(resolver DataResolver) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.dataResolver = resolver
+	})
+}
+
+// FileDataResolver opens data stored in files.
+type FileDataResolver struct {
+	// Dir is the directory relative paths are resolved against, or the
+	// working directory if empty.
+	Dir string
+}
+
+// Open opens the file at the path ref.
+func (r FileDataResolver) Open(ref string) (io.ReadCloser, error) {
+	path := filepath.FromSlash(ref)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(r.Dir, path)
+	}
+	return os.Open(path)
+}
+
+// HTTPDataResolver fetches data served at http(s) URLs.
+type HTTPDataResolver struct {
+	// Client is the client making the requests, or http.DefaultClient if
+	// nil.
+	Client *http.Client
+}
+
+// Open requests the URL ref.
+func (r HTTPDataResolver) Open(ref string) (io.ReadCloser, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Get(ref)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %w", ref, statusError(resp))
+	}
+	return resp.Body, nil
+}
+
+// defaultDataResolver opens http(s) URLs with an HTTPDataResolver and paths
+// with a FileDataResolver.
+type defaultDataResolver struct {
+	dir string
+}
+
+func (r defaultDataResolver) Open(ref string) (io.ReadCloser, error) {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return HTTPDataResolver{}.Open(ref)
+	}
+	return FileDataResolver{Dir: r.dir}.Open(ref)
+}
+
+// WithExternalData makes Write store the Data of the filter externally: its
+// reference, digest and size are written to the metadata instead of the data
+// itself, which the caller stores at the given reference (see
+// MetadataKeyExternalData). WriteFilterWithExternalData does both for files.
+func WithExternalData(ref string) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.externalData = ref
+	})
+}
+
+// WriteFilterWithExternalData writes a filter to a file like WriteFilter,
+// storing its Data in a separate uncompressed file at dataPath, which is
+// referenced relative to the filter file if possible.
+func WriteFilterWithExternalData(filter *BloomFilter, path string, gzip bool, dataPath string, opts ...WriteOption) error {
+	if err := ioutil.WriteFile(dataPath, filter.GetData(), 0644); err != nil {
+		return err
+	}
+	ref := dataPath
+	if rel, err := filepath.Rel(filepath.Dir(path), dataPath); err == nil && filepath.IsAbs(dataPath) == filepath.IsAbs(path) {
+		ref = rel
+	}
+	return WriteFilter(filter, path, gzip, append(opts[:len(opts):len(opts)], WithExternalData(filepath.ToSlash(ref)))...)
+}
+
+// externalData is the externally stored data of a filter that was read.
+type externalData struct {
+	ref         string
+	digest      string
+	size        int64
+	resolver    DataResolver
+	lazy        bool
+	maxDataSize int64
+
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// setExternalData records the external data of a filter that was read,
+// given the content of its Data section, and fetches it with DataResolve.
+func (s *BloomFilter) setExternalData(section []byte, lo loadOptions) error {
+	if len(section) > 0 {
+		return fmt.Errorf("data section of a filter with external data is not empty (%d bytes)", len(section))
+	}
+	ref, _ := s.Metadata(MetadataKeyExternalData)
+	digest, _ := s.Metadata(MetadataKeyExternalDataSHA256)
+	sizeValue, _ := s.Metadata(MetadataKeyExternalDataSize)
+	size, err := strconv.ParseInt(sizeValue, 10, 64)
+	if ref == "" || digest == "" || err != nil || size < 0 {
+		return fmt.Errorf("invalid reference to external data (%q, %q, %q)", ref, digest, sizeValue)
+	}
+	resolver := lo.dataResolver
+	if resolver == nil {
+		resolver = defaultDataResolver{dir: filepath.Dir(lo.path)}
+	}
+	s.external = nil
+	s.SetData(nil)
+	s.external = &externalData{
+		ref:         ref,
+		digest:      digest,
+		size:        size,
+		resolver:    resolver,
+		lazy:        lo.dataResolution == DataLazy,
+		maxDataSize: lo.maxDataSize,
+	}
+	if lo.dataResolution == DataResolve {
+		return s.LoadData()
+	}
+	return nil
+}
+
+// LoadData fetches the externally stored data of a filter that was read with
+// DataLazy or DataSkip, unless it was fetched before, and returns the error
+// of fetching it, e.g. one wrapping ErrDigestMismatch. It does nothing for
+// filters whose data is not stored externally.
+func (s *BloomFilter) LoadData() error {
+	ext := s.external
+	if ext == nil {
+		return nil
+	}
+	ext.once.Do(func() {
+		ext.data, ext.err = ext.fetch()
+	})
+	return ext.err
+}
+
+// ExternalData returns the reference to the externally stored data of the
+// filter, or false if its data is not stored externally.
+func (s *BloomFilter) ExternalData() (string, bool) {
+	if s.external == nil {
+		return "", false
+	}
+	return s.external.ref, true
+}
+
+// fetch reads the data and verifies its size and digest.
+func (ext *externalData) fetch() ([]byte, error) {
+	if ext.maxDataSize > 0 && ext.size > ext.maxDataSize {
+		return nil, fmt.Errorf("external data %s: %w (%d > %d bytes)", ext.ref, ErrDataTooLarge, ext.size, ext.maxDataSize)
+	}
+	r, err := ext.resolver.Open(ext.ref)
+	if err != nil {
+		return nil, fmt.Errorf("external data %s: %w", ext.ref, err)
+	}
+	defer r.Close()
+	// one byte more than expected is read to detect larger data
+	data, err := ioutil.ReadAll(io.LimitReader(r, ext.size+1))
+	if err != nil {
+		return nil, fmt.Errorf("external data %s: %w", ext.ref, err)
+	}
+	sum := sha256.Sum256(data)
+	if int64(len(data)) != ext.size || !strings.EqualFold(hex.EncodeToString(sum[:]), ext.digest) {
+		return nil, fmt.Errorf("external data %s is corrupt (%w)", ext.ref, ErrDigestMismatch)
+	}
+	return data, nil
+}
+
+// externalMetadata returns the metadata entries referencing the data of the
+// filter if Write stores it externally with WithExternalData, or nil.
+func (s *BloomFilter) externalMetadata(wo writeOptions) map[string]string {
+	if wo.externalData == "" {
+		return nil
+	}
+	data := s.GetData()
+	sum := sha256.Sum256(data)
+	return map[string]string{
+		MetadataKeyExternalData:       wo.externalData,
+		MetadataKeyExternalDataSHA256: hex.EncodeToString(sum[:]),
+		MetadataKeyExternalDataSize:   strconv.Itoa(len(data)),
+	}
+}
@@ -0,0 +1,164 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// countingResolver counts the data opened through it.
+type countingResolver struct {
+	DataResolver
+	opened int
+}
+
+func (r *countingResolver) Open(ref string) (io.ReadCloser, error) {
+	r.opened++
+	return r.DataResolver.Open(ref)
+}
+
+func writeExternal(t *testing.T) (dir string, filter *BloomFilter) {
+	dir = t.TempDir()
+	filter = mustNew(1000, 0.01)
+	filter.Add([]byte("foo"))
+	filter.SetData([]byte("some external data"))
+	if err := WriteFilterWithExternalData(filter, filepath.Join(dir, "filter.bloom"), true, filepath.Join(dir, "filter.data")); err != nil {
+		t.Fatal(err)
+	}
+	return dir, filter
+}
+
+func TestExternalDataResolve(t *testing.T) {
+	dir, _ := writeExternal(t)
+	loaded, err := LoadFilter(filepath.Join(dir, "filter.bloom"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref, ok := loaded.ExternalData(); !ok || ref != "filter.data" {
+		t.Fatalf("unexpected reference %q", ref)
+	}
+	if string(loaded.GetData()) != "some external data" || !loaded.Check([]byte("foo")) {
+		t.Fatalf("unexpected data %q", loaded.GetData())
+	}
+
+	// rewriting keeps the reference rather than embedding the data
+	var buf bytes.Buffer
+	if err := loaded.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("some external data")) {
+		t.Fatal("external data embedded in the filter")
+	}
+	loaded.SetData([]byte("embedded"))
+	if _, ok := loaded.ExternalData(); ok {
+		t.Fatal("reference kept after SetData")
+	}
+	if _, ok := loaded.Metadata(MetadataKeyExternalData); ok {
+		t.Fatal("reference kept in the metadata after SetData")
+	}
+}
+
+func TestExternalDataSkip(t *testing.T) {
+	dir, _ := writeExternal(t)
+	resolver := &countingResolver{DataResolver: FileDataResolver{Dir: dir}}
+	loaded, err := LoadFilter(filepath.Join(dir, "filter.bloom"), true, WithDataResolution(DataSkip), WithDataResolver(resolver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetData() != nil || resolver.opened != 0 {
+		t.Fatal("data fetched although skipped")
+	}
+	if loaded.DataSize() != uint64(len("some external data")) {
+		t.Fatalf("unexpected data size %d", loaded.DataSize())
+	}
+	if err := loaded.LoadData(); err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.GetData()) != "some external data" || resolver.opened != 1 {
+		t.Fatalf("unexpected data %q", loaded.GetData())
+	}
+}
+
+func TestExternalDataLazy(t *testing.T) {
+	dir, _ := writeExternal(t)
+	resolver := &countingResolver{DataResolver: FileDataResolver{Dir: dir}}
+	loaded, err := LoadFilter(filepath.Join(dir, "filter.bloom"), true, WithDataResolution(DataLazy), WithDataResolver(resolver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.opened != 0 {
+		t.Fatal("data fetched before use")
+	}
+	for i := 0; i < 2; i++ {
+		if string(loaded.GetData()) != "some external data" {
+			t.Fatalf("unexpected data %q", loaded.GetData())
+		}
+	}
+	if resolver.opened != 1 {
+		t.Fatalf("data fetched %d times", resolver.opened)
+	}
+}
+
+func TestExternalDataDigestMismatch(t *testing.T) {
+	dir, _ := writeExternal(t)
+	for _, data := range []string{"some EXTERNAL data", "some external data and more"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, "filter.data"), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadFilter(filepath.Join(dir, "filter.bloom"), true); !errors.Is(err, ErrDigestMismatch) {
+			t.Fatalf("expected digest mismatch, got %v", err)
+		}
+		loaded, err := LoadFilter(filepath.Join(dir, "filter.bloom"), true, WithDataResolution(DataLazy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.GetData() != nil {
+			t.Fatal("corrupt data returned")
+		}
+		if err := loaded.LoadData(); !errors.Is(err, ErrDigestMismatch) {
+			t.Fatalf("expected digest mismatch, got %v", err)
+		}
+	}
+}
+
+func TestExternalDataHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/filter.data" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("served data"))
+	}))
+	defer server.Close()
+
+	filter := mustNew(1000, 0.01)
+	filter.SetData([]byte("served data"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf, WithExternalData(server.URL+"/filter.data")); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromReader(bytes.NewReader(buf.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.GetData()) != "served data" {
+		t.Fatalf("unexpected data %q", loaded.GetData())
+	}
+
+	buf.Reset()
+	if err := filter.Write(&buf, WithExternalData(server.URL+"/missing")); err != nil {
+		t.Fatal(err)
+	}
+	var statusErr *HTTPStatusError
+	if _, err := LoadFromReader(bytes.NewReader(buf.Bytes()), false); !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status error, got %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrJSONPathNotFound is returned by ExtractFromJSON if the document has no
+// string at the given path.
+var ErrJSONPathNotFound = errors.New("JSON path not found")
+
+// ErrNotBase64 is returned by ExtractFromJSON if the string at the given path
+// is not base64-encoded.
+var ErrNotBase64 = errors.New("value is not base64-encoded")
+
+// ErrNotBloomFilter is returned by ExtractFromJSON if the decoded string at
+// the given path is not a (possibly gzip-compressed) Bloom filter.
+var ErrNotBloomFilter = errors.New("value is not a Bloom filter")
+
+// ExtractFromJSON loads a Bloom filter embedded in a JSON document as a
+// base64-encoded string, such as an attachment of a MISP event. The path
+// consists of the keys of objects and the indexes of arrays leading to the
+// string, separated by dots (e.g. 'Event.Attribute.0.data'). The filter may be
+// gzip-compressed, which is detected automatically. The errors distinguish a
+// missing string (ErrJSONPathNotFound), invalid base64 (ErrNotBase64) and
+// data that is not a filter (ErrNotBloomFilter). The options are passed to
+// LoadFromBytes.
+func ExtractFromJSON(r io.Reader, path string, opts ...LoadOption) (*BloomFilter, error) {
+	var document interface{}
+	if err := json.NewDecoder(r).Decode(&document); err != nil {
+		return nil, err
+	}
+	value, err := lookupJSONPath(document, path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrNotBase64, path, err)
+	}
+	gzip := bytes.HasPrefix(data, gzipMagic)
+	if gzip {
+		newLoadOptions(opts).logger.Debug("detected gzip compression", LogKeyBytes, len(data))
+	}
+	filter, err := LoadFromBytes(data, gzip, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrNotBloomFilter, path, err)
+	}
+	return filter, nil
+}
+
+// lookupJSONPath returns the string at the given dot-separated path of a
+// decoded JSON document.
+func lookupJSONPath(document interface{}, path string) (string, error) {
+	node := document
+	for i, key := range strings.Split(path, ".") {
+		found := false
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node, found = n[key]
+		case []interface{}:
+			if index, err := strconv.Atoi(key); err == nil && index >= 0 && index < len(n) {
+				node, found = n[index], true
+			}
+		}
+		if !found {
+			prefix := strings.Join(strings.Split(path, ".")[:i+1], ".")
+			return "", fmt.Errorf("%w: %s", ErrJSONPathNotFound, prefix)
+		}
+	}
+	value, ok := node.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not a string", ErrJSONPathNotFound, path)
+	}
+	return value, nil
+}
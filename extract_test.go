@@ -0,0 +1,71 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestExtractFromJSON(t *testing.T) {
+	expected, err := LoadFilter("testdata/test.bloom", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expectedBytes bytes.Buffer
+	if err := expected.Write(&expectedBytes); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"Event.Attribute.0.data", "Event.Attribute.1.data"} {
+		f, err := os.Open("testdata/misp-event.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := ExtractFromJSON(f, path)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		// the extracted filter is bit-exact
+		var extracted bytes.Buffer
+		if err := filter.Write(&extracted); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(extracted.Bytes(), expectedBytes.Bytes()) {
+			t.Fatalf("%s: extracted filter differs", path)
+		}
+	}
+}
+
+func TestExtractFromJSONErrors(t *testing.T) {
+	for _, c := range []struct {
+		path string
+		err  error
+	}{
+		{"Event.Attribute.9.data", ErrJSONPathNotFound},
+		{"Event.Attribute.x.data", ErrJSONPathNotFound},
+		{"Event.Attributes.0.data", ErrJSONPathNotFound},
+		{"Event.Attribute.0", ErrJSONPathNotFound},
+		{"Event.published", ErrJSONPathNotFound},
+		{"Event.Attribute.3.data", ErrNotBase64},
+		{"Event.Attribute.2.data", ErrNotBloomFilter},
+		{"Event.info", ErrNotBase64},
+	} {
+		f, err := os.Open("testdata/misp-event.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ExtractFromJSON(f, c.path)
+		f.Close()
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: expected %v, got %v", c.path, c.err, err)
+		}
+	}
+	if _, err := ExtractFromJSON(strings.NewReader("{"), "data"); err == nil {
+		t.Fatal("expected an error for invalid JSON")
+	}
+}
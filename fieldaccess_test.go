@@ -0,0 +1,53 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+// fieldAccessors are the only functions that may access the N and Data fields
+// of filters directly.
+var fieldAccessors = map[string]bool{
+	"NumElements":    true,
+	"SetNumElements": true,
+	"GetData":        true,
+	"SetData":        true,
+	"SetDataNoCopy":  true,
+}
+
+// TestFieldAccess ensures that the package and the command line tool access
+// the count and the data of filters through their accessors only, so that
+// their representation can change.
+func TestFieldAccess(t *testing.T) {
+	fset := token.NewFileSet()
+	for _, dir := range []string{".", "bloom", "bloomcmd"} {
+		pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+			return !strings.HasSuffix(info.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, pkg := range pkgs {
+			for _, file := range pkg.Files {
+				for _, decl := range file.Decls {
+					if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && fieldAccessors[fn.Name.Name] {
+						continue
+					}
+					ast.Inspect(decl, func(node ast.Node) bool {
+						if sel, ok := node.(*ast.SelectorExpr); ok && (sel.Sel.Name == "N" || sel.Sel.Name == "Data") {
+							t.Errorf("%s: field %s accessed directly", fset.Position(sel.Pos()), sel.Sel.Name)
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+}
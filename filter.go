@@ -0,0 +1,27 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "io"
+
+// Filter is a set membership filter that values are added to and checked
+// against, and that can be written in the format read by LoadFilter. Code that
+// only needs these operations can accept a Filter instead of a *BloomFilter,
+// so that it also works with e.g. a TombstoneFilter. The methods specific to
+// an implementation remain available through a type assertion.
+type Filter interface {
+	// Add adds a value to the filter.
+	Add(value []byte)
+	// Check returns true if the value may be in the filter.
+	Check(value []byte) bool
+	// NumElements returns the number of values added to the filter.
+	NumElements() uint64
+	// Write writes the filter to the output.
+	Write(output io.Writer, opts ...WriteOption) error
+}
+
+var (
+	_ Filter = (*BloomFilter)(nil)
+	_ Filter = (*TombstoneFilter)(nil)
+)
@@ -0,0 +1,48 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFilterImplementations(t *testing.T) {
+	tombstones, err := NewTombstoneFilter(mustNew(1000, 0.001), 100, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, filter := range map[string]Filter{
+		"BloomFilter":     mustNew(1000, 0.001),
+		"TombstoneFilter": tombstones,
+	} {
+		filter.Add([]byte("foo"))
+		filter.Add([]byte("bar"))
+		if !filter.Check([]byte("foo")) || filter.Check([]byte("baz")) {
+			t.Errorf("%s: unexpected check results", name)
+		}
+		if n := filter.NumElements(); n != 2 {
+			t.Errorf("%s: expected 2 elements, got %d", name, n)
+		}
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		loaded, err := LoadFromBytes(buf.Bytes(), false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !loaded.Check([]byte("bar")) || loaded.NumElements() != 2 {
+			t.Errorf("%s: values missing from the written filter", name)
+		}
+	}
+
+	// the methods of the implementation remain reachable
+	var filter Filter = tombstones
+	if tf, ok := filter.(*TombstoneFilter); !ok {
+		t.Fatal("expected a TombstoneFilter")
+	} else if tf.Delete([]byte("foo")); filter.Check([]byte("foo")) {
+		t.Fatal("deleted value still reported")
+	}
+}
@@ -0,0 +1,78 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// FingerprintFormat is the output format of WriteFingerprints.
+type FingerprintFormat int
+
+const (
+	// FingerprintCSV writes one CSV record per value, consisting of the value
+	// followed by the k indexes of its fingerprint in decimal. Note that CSV
+	// readers may not preserve all values exactly, e.g. encoding/csv turns
+	// CRLF within values into LF.
+	FingerprintCSV FingerprintFormat = iota
+	// FingerprintBinary writes the k indexes of the fingerprint of each value
+	// as little-endian uint64 values, without any framing, so that the
+	// fingerprint of the i-th value starts at byte offset 8*k*i.
+	FingerprintBinary
+)
+
+// WriteFingerprints writes the fingerprints of the given values with respect
+// to the parameters of the filter in the given format, e.g. for systems that
+// test the bits of a filter themselves. The fingerprints are exactly those
+// computed by Fingerprint, i.e. CheckFingerprint returns the same result for
+// them as Check for the values (apart from exclusions), and the bit with index
+// i is bit i%64 of word i/64 (see WordAt). It returns an error wrapping
+// ErrValueTooLarge if a value is rejected due to its length, as no fingerprint
+// would be checked for it; the fingerprints of the preceding values have been
+// written then.
+func (s *BloomFilter) WriteFingerprints(w io.Writer, values [][]byte, format FingerprintFormat) error {
+	fingerprint := make([]uint64, s.k)
+	switch format {
+	case FingerprintCSV:
+		cw := csv.NewWriter(w)
+		record := make([]string, 1+s.k)
+		for _, value := range values {
+			if s.rejects(value) {
+				cw.Flush()
+				return fmt.Errorf("%w (%d > %d bytes)", ErrValueTooLarge, len(value), s.maxValueLength)
+			}
+			s.Fingerprint(value, fingerprint)
+			record[0] = string(value)
+			for i, index := range fingerprint {
+				record[1+i] = strconv.FormatUint(index, 10)
+			}
+			if err := cw.Write(record); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	case FingerprintBinary:
+		buf := make([]byte, 8*s.k)
+		for _, value := range values {
+			if s.rejects(value) {
+				return fmt.Errorf("%w (%d > %d bytes)", ErrValueTooLarge, len(value), s.maxValueLength)
+			}
+			s.Fingerprint(value, fingerprint)
+			for i, index := range fingerprint {
+				binary.LittleEndian.PutUint64(buf[8*i:], index)
+			}
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown fingerprint format %d", format)
+	}
+}
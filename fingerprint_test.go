@@ -0,0 +1,111 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestWriteFingerprintsCSV(t *testing.T) {
+	filter, values := GenerateExampleFilter(10000, 0.001, 1000)
+	others := make([][]byte, 1000)
+	for i := range others {
+		others[i] = GenerateTestValue(100)
+	}
+	values = append(values, others...)
+	var buf bytes.Buffer
+	if err := filter.WriteFingerprints(&buf, values, FingerprintCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2000 {
+		t.Fatalf("expected 2000 records, got %d", len(records))
+	}
+	fingerprint := make([]uint64, filter.NumHashFuncs())
+	for i, record := range records {
+		if len(record) != 1+len(fingerprint) {
+			t.Fatalf("unexpected record length %d", len(record))
+		}
+		for j := range fingerprint {
+			if fingerprint[j], err = strconv.ParseUint(record[1+j], 10, 64); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// the value itself is not compared, as encoding/csv turns CRLF in
+		// values into LF
+		value := values[i]
+		if i < 1000 && !filter.CheckFingerprint(fingerprint) {
+			t.Fatalf("fingerprint of added value %q not contained", value)
+		}
+		if filter.CheckFingerprint(fingerprint) != filter.Check(value) {
+			t.Fatalf("fingerprint and value %q checked differently", value)
+		}
+	}
+}
+
+func TestWriteFingerprintsBinary(t *testing.T) {
+	filter, values := GenerateExampleFilter(10000, 0.001, 1000)
+	var buf bytes.Buffer
+	if err := filter.WriteFingerprints(&buf, values, FingerprintBinary); err != nil {
+		t.Fatal(err)
+	}
+	k := int(filter.NumHashFuncs())
+	if buf.Len() != len(values)*k*8 {
+		t.Fatalf("unexpected output length %d", buf.Len())
+	}
+	fingerprint := make([]uint64, k)
+	expected := make([]uint64, k)
+	for _, value := range values {
+		for j := range fingerprint {
+			fingerprint[j] = binary.LittleEndian.Uint64(buf.Next(8))
+		}
+		if !filter.CheckFingerprint(fingerprint) {
+			t.Fatalf("fingerprint of added value %q not contained", value)
+		}
+		filter.Fingerprint(value, expected)
+		for j := range expected {
+			if fingerprint[j] != expected[j] {
+				t.Fatalf("unexpected index %d of value %q", fingerprint[j], value)
+			}
+		}
+	}
+}
+
+func TestWriteFingerprintsValueLength(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	filter.SetMaxValueLength(3, RejectValues)
+	var buf bytes.Buffer
+	err := filter.WriteFingerprints(&buf, [][]byte{[]byte("foo"), []byte("foobar")}, FingerprintBinary)
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if buf.Len() != int(filter.NumHashFuncs())*8 {
+		t.Fatal("expected the fingerprint of the preceding value to be written")
+	}
+
+	// truncated values have the fingerprint of their prefix
+	filter.SetMaxValueLength(3, TruncateValues)
+	filter.Add([]byte("foobar"))
+	buf.Reset()
+	if err := filter.WriteFingerprints(&buf, [][]byte{[]byte("foo"), []byte("foobar")}, FingerprintCSV); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(records[0]); i++ {
+		if records[0][i] != records[1][i] {
+			t.Fatal("expected the same fingerprint for a truncated value")
+		}
+	}
+}
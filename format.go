@@ -0,0 +1,313 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The binary format of a filter as written by Write. All integers are
+// unsigned 64-bit values in little-endian byte order. The header is followed
+// (in version 2) by the comment region (see FormatCommentOffset), by the bit
+// array, then (in version 2) by the metadata section, and finally by the Data
+// section, which extends to the end of the input.
+const (
+	// FormatVersion1 is the format without a metadata section.
+	FormatVersion1 = 1
+	// FormatVersion2 is the format with a metadata section, written for
+	// filters with metadata.
+	FormatVersion2 = 2
+	// FormatVersionMask selects the version from the flags field.
+	FormatVersionMask = 0xFF
+
+	// FormatFlagsOffset is the offset of the flags field holding the version.
+	FormatFlagsOffset = 0
+	// FormatCapacityOffset is the offset of the capacity (n).
+	FormatCapacityOffset = 8
+	// FormatFPPOffset is the offset of the false positive probability (p),
+	// an IEEE 754 double strictly between 0 and 1 (see ErrInvalidFPP).
+	FormatFPPOffset = 16
+	// FormatHashFuncsOffset is the offset of the number of hash functions (k).
+	FormatHashFuncsOffset = 24
+	// FormatNumBitsOffset is the offset of the number of bits (m).
+	FormatNumBitsOffset = 32
+	// FormatCountOffset is the offset of the number of elements (N).
+	FormatCountOffset = 40
+	// FormatHeaderSize is the size of the header, i.e. the offset of the bit
+	// array.
+	FormatHeaderSize = 48
+
+	// FormatWordSize is the size of the words of the bit array, which holds
+	// ceil(m/64) words. Bit i is bit i%64 (counting from the least significant
+	// bit) of word i/64.
+	FormatWordSize = 8
+	// FormatLengthSize is the size of the length prefixes of the metadata
+	// section and of its keys and values.
+	FormatLengthSize = 8
+)
+
+// Besides the version and the size of the comment region, the flags field
+// holds flags of features introduced without a new version. Readers refuse
+// filters with required flags they do not know, which they would read
+// incorrectly, and ignore optional flags they do not know, which mark
+// information they can do without (and do not preserve when writing).
+const (
+	// FormatRequiredFlagsMask selects the required flags, e.g.
+	// FormatFlagExternalData and FormatFlagComment.
+	FormatRequiredFlagsMask = 0xFFFF0000
+	// FormatOptionalFlagsMask selects the optional flags, none of which are
+	// defined yet.
+	FormatOptionalFlagsMask = 0xFFFFFFFF00000000
+	// FormatFlagChecksum is reserved for a checksum following the Data
+	// section, which readers without support would take for Data.
+	FormatFlagChecksum = 1 << 17
+	// FormatHashSchemeMask selects the identifier of the hash scheme of the
+	// filter (see HashSchemeFNV1), shifted by FormatHashSchemeShift. Zero
+	// stands for HashSchemeFNV1, which all filters written by this library
+	// use, and readers accept its identifier 1 as well, so both values
+	// denote it. Readers refuse other schemes (see ErrUnsupportedHashScheme),
+	// as checking values with the wrong scheme would miss them.
+	FormatHashSchemeMask = 0xFF000000
+	// FormatHashSchemeShift is the shift of the hash scheme identifier.
+	FormatHashSchemeShift = 24
+)
+
+// knownRequiredFlags are the required flags that Read supports, besides the
+// hash scheme checked by checkHashScheme.
+const knownRequiredFlags = FormatFlagExternalData | FormatFlagComment
+
+// ErrUnsupportedVersion is wrapped by the errors returned for filters in a
+// version of the file format that is not supported, e.g. written by a newer
+// version of the library.
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// UnsupportedVersionError gives the version of a filter that is not
+// supported and the supported versions.
+type UnsupportedVersionError struct {
+	Found     int
+	Supported []int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	supported := make([]string, len(e.Supported))
+	for i, v := range e.Supported {
+		supported[i] = strconv.Itoa(v)
+	}
+	msg := fmt.Sprintf("%s %d (supported: %s)", ErrUnsupportedVersion, e.Found, strings.Join(supported, ", "))
+	if e.Newer() {
+		msg += ", upgrade to a newer version of DCSO/bloom to read it"
+	}
+	return msg
+}
+
+func (e *UnsupportedVersionError) Unwrap() error {
+	return ErrUnsupportedVersion
+}
+
+// Newer returns true if the version found is newer than the supported ones,
+// i.e. the filter was presumably written by a newer version of the library,
+// rather than being corrupt or not a filter.
+func (e *UnsupportedVersionError) Newer() bool {
+	return len(e.Supported) > 0 && e.Found > e.Supported[len(e.Supported)-1]
+}
+
+// ErrUnsupportedFlags is wrapped by the errors returned for filters with
+// required flags that are not supported.
+var ErrUnsupportedFlags = errors.New("unsupported format flags")
+
+// UnsupportedFlagsError gives the required flags of a filter that are not
+// supported.
+type UnsupportedFlagsError struct {
+	Flags uint64
+}
+
+func (e *UnsupportedFlagsError) Error() string {
+	return fmt.Sprintf("%s 0x%X, upgrade to a newer version of DCSO/bloom to read the filter", ErrUnsupportedFlags, e.Flags)
+}
+
+func (e *UnsupportedFlagsError) Unwrap() error {
+	return ErrUnsupportedFlags
+}
+
+// ErrUnsupportedHashScheme is wrapped by the errors returned for filters
+// whose hash scheme is not supported, e.g. written by an implementation in
+// another language deriving the bits of a value differently.
+var ErrUnsupportedHashScheme = errors.New("unsupported hash scheme")
+
+// UnsupportedHashSchemeError gives the hash scheme identifier of a filter
+// that is not supported.
+type UnsupportedHashSchemeError struct {
+	Scheme int
+}
+
+func (e *UnsupportedHashSchemeError) Error() string {
+	return fmt.Sprintf("%s %d (%s), only %d (%s) is supported; checking its values would give wrong results",
+		ErrUnsupportedHashScheme, e.Scheme, HashSchemeName(e.Scheme), HashSchemeFNV1, HashSchemeName(HashSchemeFNV1))
+}
+
+func (e *UnsupportedHashSchemeError) Unwrap() error {
+	return ErrUnsupportedHashScheme
+}
+
+// formatHashScheme returns the hash scheme identifier of the flags field,
+// where zero stands for HashSchemeFNV1.
+func formatHashScheme(flags uint64) int {
+	if scheme := int(flags & FormatHashSchemeMask >> FormatHashSchemeShift); scheme != 0 {
+		return scheme
+	}
+	return HashSchemeFNV1
+}
+
+// checkHashScheme returns an error if the hash scheme of the flags field is
+// not supported.
+func checkHashScheme(flags uint64) error {
+	if scheme := formatHashScheme(flags); scheme != HashSchemeFNV1 {
+		return &UnsupportedHashSchemeError{Scheme: scheme}
+	}
+	return nil
+}
+
+// SupportedFormatVersions returns the versions of the file format that Read
+// supports, in ascending order.
+func SupportedFormatVersions() []int {
+	return []int{FormatVersion1, FormatVersion2}
+}
+
+// HashSchemeFNV1 identifies the hashing scheme of all format versions: the
+// 64-bit FNV-1 hash h_0 of a value modulo the prime 2^64-59, from which the
+// probes are derived as h_i = ((h_{i-1} * g) mod 2^64) mod (2^64-59) with
+// g = 2^64-1469, i.e. the product wraps around at 64 bits, and probe i
+// (1 <= i <= k) being bit h_i mod m. The scheme is stored as zero in the
+// flags field, and readers accept its identifier as well (see
+// FormatHashSchemeMask).
+const HashSchemeFNV1 = 1
+
+// HashSchemeName returns the name of a hash scheme identifier, or "unknown".
+func HashSchemeName(scheme int) string {
+	if scheme == HashSchemeFNV1 {
+		return "fnv1-64"
+	}
+	return "unknown"
+}
+
+// FormatField describes a field of the binary format.
+type FormatField struct {
+	// Name is the name of the field.
+	Name string `json:"name"`
+	// Offset is the offset of the field in bytes, or -1 if it immediately
+	// follows the previous field at an offset depending on the filter.
+	Offset int `json:"offset"`
+	// Size is the size of the field in bytes, or -1 if it depends on the
+	// filter as given by Length.
+	Size int `json:"size"`
+	// Type is the type of the field: "uint64", "float64" or "bytes".
+	Type string `json:"type"`
+	// Length describes the size of variable-size fields.
+	Length string `json:"length,omitempty"`
+	// Description describes the content of the field.
+	Description string `json:"description"`
+	// Entries describes the repeated entries of a field.
+	Entries []FormatField `json:"entries,omitempty"`
+}
+
+// HashScheme describes how the bits of a value are determined.
+type HashScheme struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// FormatSpec describes a version of the binary format of filters.
+type FormatSpec struct {
+	Version    int           `json:"version"`
+	ByteOrder  string        `json:"byte_order"`
+	Fields     []FormatField `json:"fields"`
+	HashScheme HashScheme    `json:"hash_scheme"`
+	// Checksum describes the checksums of the format and what they cover.
+	Checksum string `json:"checksum"`
+}
+
+// DescribeFormat returns the specification of the given version of the binary
+// format, derived from the constants used by Read and Write.
+func DescribeFormat(version int) (FormatSpec, error) {
+	if version != FormatVersion1 && version != FormatVersion2 {
+		return FormatSpec{}, fmt.Errorf("unknown format version %d", version)
+	}
+	flags := fmt.Sprintf("format version (%d) in the bits selected by 0x%X", version, FormatVersionMask)
+	bitsOffset := FormatHeaderSize
+	if version == FormatVersion2 {
+		flags += fmt.Sprintf(", the size of the comment region in words in the bits selected by 0x%X together with bit 0x%X if there is one, and bit 0x%X if the data is stored externally",
+			FormatCommentSizeMask, FormatFlagComment, FormatFlagExternalData)
+		bitsOffset = -1
+	}
+	flags += fmt.Sprintf("; the bits selected by 0x%X are required flags, which readers refuse if unknown (0x%X is reserved for a checksum, and 0x%X holds the "+
+		"hash scheme identifier, zero or %d for scheme %d), the bits selected by 0x%X are optional flags, which readers ignore if unknown; the remaining bits are zero",
+		uint64(FormatRequiredFlagsMask), uint64(FormatFlagChecksum), uint64(FormatHashSchemeMask), HashSchemeFNV1, HashSchemeFNV1, uint64(FormatOptionalFlagsMask))
+	fields := []FormatField{
+		{Name: "flags", Offset: FormatFlagsOffset, Size: 8, Type: "uint64",
+			Description: flags},
+		{Name: "n", Offset: FormatCapacityOffset, Size: 8, Type: "uint64",
+			Description: "capacity of the filter"},
+		{Name: "p", Offset: FormatFPPOffset, Size: 8, Type: "float64",
+			Description: "false positive probability at capacity, IEEE 754 double in (0, 1)"},
+		{Name: "k", Offset: FormatHashFuncsOffset, Size: 8, Type: "uint64",
+			Description: "number of hash functions (probes per value)"},
+		{Name: "m", Offset: FormatNumBitsOffset, Size: 8, Type: "uint64",
+			Description: "number of bits"},
+		{Name: "N", Offset: FormatCountOffset, Size: 8, Type: "uint64",
+			Description: "number of elements added (an estimate if metadata key " + MetadataKeyCount + " is \"estimated\")"},
+	}
+	if version == FormatVersion2 {
+		fields = append(fields, FormatField{Name: "comment", Offset: FormatCommentOffset, Size: -1, Type: "bytes",
+			Length:      fmt.Sprintf("((flags & 0x%X) >> %d) * %d, at most %d", FormatCommentSizeMask, FormatCommentSizeShift, FormatWordSize, FormatMaxCommentSize),
+			Description: "optional line of printable ASCII text describing the filter, terminated by a newline and padded with zero bytes"})
+	}
+	fields = append(fields, FormatField{Name: "bits", Offset: bitsOffset, Size: -1, Type: "bytes",
+		Length:      fmt.Sprintf("ceil(m/64) * %d", FormatWordSize),
+		Description: fmt.Sprintf("bit array as uint64 words of %d bytes; bit i is bit i%%64 of word i/64, counting from the least significant bit", FormatWordSize)})
+	if version == FormatVersion2 {
+		fields = append(fields,
+			FormatField{Name: "metadata_length", Offset: -1, Size: FormatLengthSize, Type: "uint64",
+				Description: fmt.Sprintf("size of the metadata entries in bytes (at most %d)", maxMetadataSize)},
+			FormatField{Name: "metadata", Offset: -1, Size: -1, Type: "bytes", Length: "metadata_length",
+				Description: "metadata entries sorted by key; keys starting with \"bloom.\" are reserved",
+				Entries: []FormatField{
+					{Name: "key_length", Offset: -1, Size: FormatLengthSize, Type: "uint64", Description: "size of the key in bytes"},
+					{Name: "key", Offset: -1, Size: -1, Type: "bytes", Length: "key_length", Description: "key"},
+					{Name: "value_length", Offset: -1, Size: FormatLengthSize, Type: "uint64", Description: "size of the value in bytes"},
+					{Name: "value", Offset: -1, Size: -1, Type: "bytes", Length: "value_length", Description: "value"},
+				}},
+		)
+	}
+	fields = append(fields, FormatField{Name: "data", Offset: -1, Size: -1, Type: "bytes",
+		Length:      "until the end of the input",
+		Description: dataDescription(version)})
+	return FormatSpec{
+		Version:   version,
+		ByteOrder: "little-endian",
+		Fields:    fields,
+		HashScheme: HashScheme{
+			ID:   HashSchemeFNV1,
+			Name: HashSchemeName(HashSchemeFNV1),
+			Description: fmt.Sprintf("h_0 = FNV-1 64-bit hash of the value (truncated to the length in "+
+				"metadata key %s if %s is \"truncate\") mod %d; h_i = ((h_{i-1} * %d) mod 2^64) mod %d, "+
+				"i.e. the product wraps around at 64 bits; probe i of k is bit h_i mod m; "+
+				"stored as zero in the bits of the flags selected by 0x%X",
+				MetadataKeyMaxValueLength, MetadataKeyValueLengthPolicy, m, g, m, uint64(FormatHashSchemeMask)),
+		},
+		Checksum: "none; the format carries no checksums, use e.g. the SHA-256 digests of the chunk manifest to verify files",
+	}, nil
+}
+
+// dataDescription describes the Data section of the given version.
+func dataDescription(version int) string {
+	if version == FormatVersion1 {
+		return "data attached to the filter"
+	}
+	return fmt.Sprintf("data attached to the filter; empty if flag 0x%X is set, the data then being referenced by metadata keys %s, %s and %s",
+		FormatFlagExternalData, MetadataKeyExternalData, MetadataKeyExternalDataSHA256, MetadataKeyExternalDataSize)
+}
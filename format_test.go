@@ -0,0 +1,248 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// parseWithSpec parses a serialized filter using only the format
+// specification, returning the values of the fields by name.
+func parseWithSpec(t *testing.T, spec FormatSpec, buf []byte) map[string]interface{} {
+	if spec.ByteOrder != "little-endian" {
+		t.Fatalf("unexpected byte order %s", spec.ByteOrder)
+	}
+	fields := make(map[string]interface{})
+	offset := 0
+	readUint := func(size int) uint64 {
+		if size != 8 {
+			t.Fatalf("unexpected integer size %d", size)
+		}
+		v := binary.LittleEndian.Uint64(buf[offset:])
+		offset += size
+		return v
+	}
+	for _, field := range spec.Fields {
+		if field.Offset >= 0 && field.Offset != offset {
+			t.Fatalf("field %s at offset %d, expected %d", field.Name, offset, field.Offset)
+		}
+		switch {
+		case field.Type == "uint64":
+			fields[field.Name] = readUint(field.Size)
+		case field.Type == "float64":
+			fields[field.Name] = math.Float64frombits(readUint(field.Size))
+		case field.Name == "comment":
+			size := int(fields["flags"].(uint64)&FormatCommentSizeMask>>FormatCommentSizeShift) * FormatWordSize
+			fields[field.Name] = string(bytes.TrimRight(buf[offset:offset+size], "\x00"))
+			offset += size
+		case field.Name == "bits":
+			words := (fields["m"].(uint64) + 63) / 64
+			size := int(words) * FormatWordSize
+			fields[field.Name] = buf[offset : offset+size]
+			offset += size
+		case field.Name == "metadata":
+			end := offset + int(fields[field.Length].(uint64))
+			meta := make(map[string]string)
+			for offset < end {
+				entry := make(map[string]interface{})
+				for _, e := range field.Entries {
+					if e.Type == "uint64" {
+						entry[e.Name] = readUint(e.Size)
+						continue
+					}
+					size := int(entry[e.Length].(uint64))
+					entry[e.Name] = string(buf[offset : offset+size])
+					offset += size
+				}
+				meta[entry["key"].(string)] = entry["value"].(string)
+			}
+			fields[field.Name] = meta
+		case field.Name == "data":
+			fields[field.Name] = buf[offset:]
+			offset = len(buf)
+		default:
+			t.Fatalf("cannot parse field %s", field.Name)
+		}
+	}
+	return fields
+}
+
+func TestDescribeFormat(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000, 0.01, 100)
+	filter.Data = []byte("some data")
+	for _, version := range []int{FormatVersion1, FormatVersion2} {
+		if version == FormatVersion2 {
+			filter.SetMetadata("source", "test")
+			filter.SetMaxValueLength(64, TruncateValues)
+		}
+		var opts []WriteOption
+		if version == FormatVersion2 {
+			opts = append(opts, WithComment("test"))
+		}
+		var buf bytes.Buffer
+		if err := filter.Write(&buf, opts...); err != nil {
+			t.Fatal(err)
+		}
+		spec, err := DescribeFormat(version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields := parseWithSpec(t, spec, buf.Bytes())
+		if fields["flags"].(uint64)&FormatVersionMask != uint64(version) {
+			t.Fatalf("unexpected version in flags %x", fields["flags"])
+		}
+		if fields["n"] != filter.MaxNumElements() || fields["p"] != filter.FalsePositiveProb() ||
+			fields["k"] != filter.NumHashFuncs() || fields["m"] != filter.NumBits() || fields["N"] != filter.N {
+			t.Fatalf("unexpected header fields %v", fields)
+		}
+		bits := fields["bits"].([]byte)
+		for i, word := range filter.v {
+			if binary.LittleEndian.Uint64(bits[i*FormatWordSize:]) != word {
+				t.Fatalf("unexpected word %d", i)
+			}
+		}
+		if !bytes.Equal(fields["data"].([]byte), filter.Data) {
+			t.Fatalf("unexpected data %q", fields["data"])
+		}
+		if version == FormatVersion2 {
+			filter.SetMetadata(MetadataKeyProducer, producerString(""))
+			comment := fields["comment"].(string)
+			if !strings.HasPrefix(comment, "DCSO bloom filter v2, n=1000, p=0.01, built ") || !strings.HasSuffix(comment, ", see "+ProjectURL+": test\n") {
+				t.Fatalf("unexpected comment %q", fields["comment"])
+			}
+		}
+		if version == FormatVersion2 && !reflect.DeepEqual(fields["metadata"], filter.meta) {
+			t.Fatalf("unexpected metadata %v", fields["metadata"])
+		}
+	}
+	if _, err := DescribeFormat(3); err == nil {
+		t.Fatal("unknown version should fail")
+	}
+}
+
+// withFlags returns a copy of a serialized filter with the flags field
+// modified by f.
+func withFlags(buf []byte, f func(flags uint64) uint64) []byte {
+	buf = append([]byte(nil), buf...)
+	flags := binary.LittleEndian.Uint64(buf[FormatFlagsOffset:])
+	binary.LittleEndian.PutUint64(buf[FormatFlagsOffset:], f(flags))
+	return buf
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	filter := Initialize(100, 0.01)
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		version int
+		newer   bool
+	}{{0, false}, {3, true}, {0xFF, true}} {
+		input := withFlags(buf.Bytes(), func(flags uint64) uint64 {
+			return flags&^FormatVersionMask | uint64(c.version)
+		})
+		_, err := LoadFromBytes(input, false)
+		if !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("expected ErrUnsupportedVersion for version %d, got %v", c.version, err)
+		}
+		var versionErr *UnsupportedVersionError
+		if !errors.As(err, &versionErr) {
+			t.Fatalf("expected an UnsupportedVersionError, got %T", err)
+		}
+		if versionErr.Found != c.version || !reflect.DeepEqual(versionErr.Supported, SupportedFormatVersions()) {
+			t.Fatalf("unexpected error %+v", versionErr)
+		}
+		if versionErr.Newer() != c.newer || strings.Contains(err.Error(), "upgrade") != c.newer {
+			t.Fatalf("unexpected upgrade hint for version %d: %s", c.version, err)
+		}
+	}
+}
+
+func TestFormatFlags(t *testing.T) {
+	filter := Initialize(100, 0.01)
+	filter.Add([]byte("foo"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown optional flags are ignored
+	input := withFlags(buf.Bytes(), func(flags uint64) uint64 {
+		return flags | 1<<32 | 1<<63
+	})
+	loaded, err := LoadFromBytes(input, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Check([]byte("foo")) || loaded.N != 1 {
+		t.Fatal("filter with optional flags was not read correctly")
+	}
+
+	// unknown required flags are refused
+	for _, flag := range []uint64{FormatFlagChecksum, 1 << 23} {
+		input := withFlags(buf.Bytes(), func(flags uint64) uint64 {
+			return flags | flag
+		})
+		_, err := LoadFromBytes(input, false)
+		if !errors.Is(err, ErrUnsupportedFlags) {
+			t.Fatalf("expected ErrUnsupportedFlags for flag 0x%X, got %v", flag, err)
+		}
+		var flagsErr *UnsupportedFlagsError
+		if !errors.As(err, &flagsErr) || flagsErr.Flags != flag {
+			t.Fatalf("expected an UnsupportedFlagsError for flag 0x%X, got %v", flag, err)
+		}
+	}
+
+	// the known required flag is still read
+	if FormatFlagExternalData&FormatRequiredFlagsMask == 0 {
+		t.Fatal("FormatFlagExternalData is not a required flag")
+	}
+}
+
+func TestHashScheme(t *testing.T) {
+	filter := Initialize(100, 0.01)
+	filter.Add([]byte("foo"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// the scheme may be given explicitly
+	input := withFlags(buf.Bytes(), func(flags uint64) uint64 {
+		return flags | HashSchemeFNV1<<FormatHashSchemeShift
+	})
+	loaded, err := LoadFromBytes(input, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Check([]byte("foo")) {
+		t.Fatal("filter with explicit hash scheme was not read correctly")
+	}
+
+	// other schemes are refused by the loader and the header reader
+	for _, scheme := range []int{2, 0xFF} {
+		input := withFlags(buf.Bytes(), func(flags uint64) uint64 {
+			return flags | uint64(scheme)<<FormatHashSchemeShift
+		})
+		_, err := LoadFromBytes(input, false)
+		var schemeErr *UnsupportedHashSchemeError
+		if !errors.Is(err, ErrUnsupportedHashScheme) || !errors.As(err, &schemeErr) || schemeErr.Scheme != scheme {
+			t.Fatalf("expected an UnsupportedHashSchemeError for scheme %d, got %v", scheme, err)
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("hash scheme %d (unknown)", scheme)) {
+			t.Fatalf("unexpected error %q", err)
+		}
+		if _, err := ReadHeader(bytes.NewReader(input)); !errors.Is(err, ErrUnsupportedHashScheme) {
+			t.Fatalf("expected ErrUnsupportedHashScheme from ReadHeader, got %v", err)
+		}
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/DCSO/bloom
 
-go 1.15
+go 1.16
 
 require gopkg.in/urfave/cli.v1 v1.20.0
@@ -0,0 +1,100 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	gz "compress/gzip"
+	"errors"
+	"io"
+)
+
+// ErrTrailingGarbage is returned when loading a gzip-compressed filter that
+// is followed by data which is not another gzip member, unless
+// IgnoreTrailingGarbage is given.
+var ErrTrailingGarbage = errors.New("trailing data after gzip stream")
+
+// IgnoreTrailingGarbage makes the loader ignore data following the last gzip
+// member of a gzip-compressed filter (e.g. padding of a file), instead of
+// failing with ErrTrailingGarbage.
+func IgnoreTrailingGarbage() LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.ignoreTrailingGarbage = true
+	})
+}
+
+// gzipMembersReader decompresses all consecutive gzip members of its input,
+// such as those of concatenated gzip files or written by parallel
+// compressors, and checks what follows the last one.
+type gzipMembersReader struct {
+	input          *bufio.Reader
+	member         *gz.Reader
+	ignoreTrailing bool
+	// ignoredTrailing is true if trailing data was ignored
+	ignoredTrailing bool
+	done            bool
+}
+
+func newGzipMembersReader(input io.Reader, ignoreTrailing bool) (*gzipMembersReader, error) {
+	// a bufio.Reader is an io.ByteReader, so that gzip does not read beyond
+	// the end of a member
+	buffered := bufio.NewReader(input)
+	member, err := gz.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	member.Multistream(false)
+	return &gzipMembersReader{
+		input:          buffered,
+		member:         member,
+		ignoreTrailing: ignoreTrailing,
+	}, nil
+}
+
+func (r *gzipMembersReader) Read(p []byte) (int, error) {
+	for !r.done {
+		n, err := r.member.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		if err = r.nextMember(); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// nextMember continues with the next gzip member, if any.
+func (r *gzipMembersReader) nextMember() error {
+	magic, err := r.input.Peek(len(gzipMagic))
+	if err == io.EOF && len(magic) == 0 {
+		r.done = true
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(magic, gzipMagic) {
+		if r.ignoreTrailing {
+			r.ignoredTrailing = true
+			r.done = true
+			return nil
+		}
+		return ErrTrailingGarbage
+	}
+	if err := r.member.Reset(r.input); err != nil {
+		return err
+	}
+	r.member.Multistream(false)
+	return nil
+}
+
+// Close closes the current gzip member.
+func (r *gzipMembersReader) Close() error {
+	return r.member.Close()
+}
@@ -0,0 +1,79 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	gz "compress/gzip"
+	"errors"
+	"testing"
+)
+
+// gzipMembers compresses the parts as consecutive gzip members.
+func gzipMembers(parts ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		w := gz.NewWriter(&buf)
+		w.Write(part)
+		w.Close()
+	}
+	return buf.Bytes()
+}
+
+func serializedExampleFilter(t *testing.T) (*BloomFilter, []byte) {
+	filter, _ := GenerateExampleFilter(1000, 0.01, 100)
+	filter.SetData([]byte("data"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return filter, buf.Bytes()
+}
+
+func TestLoadGzipMembers(t *testing.T) {
+	filter, b := serializedExampleFilter(t)
+	for _, parts := range [][][]byte{
+		{b},
+		{b[:10], b[10:]},
+		{b[:FormatHeaderSize], b[FormatHeaderSize : len(b)-2], b[len(b)-2:]},
+		{b[:100], nil, b[100:]},
+	} {
+		loaded, err := LoadFromBytes(gzipMembers(parts...), true)
+		if err != nil {
+			t.Fatalf("%d members: %v", len(parts), err)
+		}
+		if !checkFilters(filter, loaded, t) {
+			t.Fatalf("%d members: filter differs", len(parts))
+		}
+	}
+}
+
+func TestLoadGzipTrailingGarbage(t *testing.T) {
+	filter, b := serializedExampleFilter(t)
+	compressed := gzipMembers(b[:10], b[10:])
+	for _, garbage := range [][]byte{
+		[]byte("junk"),
+		[]byte{0x1f},
+		make([]byte, 512),
+	} {
+		input := append(append([]byte(nil), compressed...), garbage...)
+		if _, err := LoadFromBytes(input, true); !errors.Is(err, ErrTrailingGarbage) {
+			t.Fatalf("expected ErrTrailingGarbage for %q, got %v", garbage, err)
+		}
+		loaded, err := LoadFromBytes(input, true, IgnoreTrailingGarbage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !checkFilters(filter, loaded, t) {
+			t.Fatal("filter differs")
+		}
+	}
+
+	// data that looks like another member, but is not, is an error either way
+	input := append(append([]byte(nil), compressed...), gzipMagic...)
+	input = append(input, []byte("junk")...)
+	if _, err := LoadFromBytes(input, true, IgnoreTrailingGarbage()); err == nil {
+		t.Fatal("expected an error for a corrupt member")
+	}
+}
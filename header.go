@@ -0,0 +1,245 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// HeaderFetchSize is the number of bytes ReadHeaderFromURL fetches, which
+// holds the header and the largest comment region even if compressed.
+const HeaderFetchSize = 1024
+
+// FilterInfo describes a filter as given by its header, which suffices to
+// check whether filters can be joined.
+type FilterInfo struct {
+	// Name identifies the filter in errors, e.g. its path or URL.
+	Name string
+	// Version is the version of the file format.
+	Version int
+	// Capacity is the desired maximum number of elements (n).
+	Capacity uint64
+	// FalsePositiveProb is the desired false positive probability (p).
+	FalsePositiveProb float64
+	// HashFuncs is the number of hash functions (k).
+	HashFuncs uint64
+	// Bits is the number of bits (m).
+	Bits uint64
+	// Words is the number of words of the bit array (M).
+	Words uint64
+	// Elements is the number of elements added (N).
+	Elements uint64
+	// HashScheme identifies how the bits of a value are determined, see
+	// HashSchemeFNV1.
+	HashScheme int
+	// Comment is the line of the comment region, if any.
+	Comment string
+	// CommentSize is the size of the comment region in bytes, including its
+	// padding.
+	CommentSize int
+}
+
+// ReadHeader reads the header of a filter, including its comment region,
+// from the beginning of its binary representation, which may be
+// gzip-compressed. The input may be consumed beyond the header.
+func ReadHeader(input io.Reader) (FilterInfo, error) {
+	input, err := decompressHeader(input)
+	if err != nil {
+		return FilterInfo{}, err
+	}
+	return readHeader(input)
+}
+
+// decompressHeader returns a reader decompressing input if it starts with the
+// magic number of gzip, and a buffered reader of input otherwise.
+func decompressHeader(input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// readHeader reads the header and the comment region from the beginning of
+// the uncompressed input, consuming nothing beyond them.
+func readHeader(input io.Reader) (FilterInfo, error) {
+	header := make([]byte, FormatHeaderSize)
+	if _, err := io.ReadFull(input, header); err != nil {
+		return FilterInfo{}, fmt.Errorf("reading header: %w", err)
+	}
+	if err := checkVersion(header); err != nil {
+		return FilterInfo{}, err
+	}
+	size, err := commentSize(header)
+	if err != nil {
+		return FilterInfo{}, err
+	}
+	region := make([]byte, size)
+	if _, err := io.ReadFull(input, region); err != nil {
+		return FilterInfo{}, fmt.Errorf("reading comment region: %w", err)
+	}
+	m := binary.LittleEndian.Uint64(header[FormatNumBitsOffset:])
+	if err := checkSize(m); err != nil {
+		return FilterInfo{}, err
+	}
+	p := math.Float64frombits(binary.LittleEndian.Uint64(header[FormatFPPOffset:]))
+	if err := checkFPP(p); err != nil {
+		return FilterInfo{}, fmt.Errorf("value of p is invalid: %w", err)
+	}
+	return FilterInfo{
+		Version:           int(binary.LittleEndian.Uint64(header[FormatFlagsOffset:]) & FormatVersionMask),
+		Capacity:          binary.LittleEndian.Uint64(header[FormatCapacityOffset:]),
+		FalsePositiveProb: p,
+		HashFuncs:         binary.LittleEndian.Uint64(header[FormatHashFuncsOffset:]),
+		Bits:              m,
+		Words:             numWords(m),
+		Elements:          binary.LittleEndian.Uint64(header[FormatCountOffset:]),
+		HashScheme:        formatHashScheme(binary.LittleEndian.Uint64(header[FormatFlagsOffset:])),
+		Comment:           decodeComment(region),
+		CommentSize:       size,
+	}, nil
+}
+
+// ReadHeaderFromFile reads the header of the filter stored in a file, which
+// may be gzip-compressed.
+func ReadHeaderFromFile(path string) (FilterInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FilterInfo{}, err
+	}
+	defer file.Close()
+	info, err := ReadHeader(file)
+	if err != nil {
+		return FilterInfo{}, fmt.Errorf("%s: %w", path, err)
+	}
+	info.Name = path
+	return info, nil
+}
+
+// ReadHeaderFromURL reads the header of the filter served at an http(s) URL,
+// which may be gzip-compressed, without downloading the whole filter. Only the
+// first HeaderFetchSize bytes are requested with a range request. If the
+// server does not support range requests, the download is aborted after them.
+func ReadHeaderFromURL(ctx context.Context, url string) (FilterInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return FilterInfo{}, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", HeaderFetchSize-1))
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return FilterInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return FilterInfo{}, fmt.Errorf("fetching %s: %w", url, statusError(resp))
+	}
+	info, err := ReadHeader(io.LimitReader(resp.Body, HeaderFetchSize))
+	if err != nil {
+		return FilterInfo{}, fmt.Errorf("%s: %w", url, err)
+	}
+	info.Name = url
+	return info, nil
+}
+
+// IncompatiblePair is a pair of filters that cannot be joined.
+type IncompatiblePair struct {
+	// A and B are the indexes of the filters, with A < B.
+	A, B int
+	// Differences describe the differing parameters, e.g. "n = 1000 vs.
+	// 2000".
+	Differences []string
+}
+
+// JoinCompatibilityError is returned by CheckJoinCompatibility if some of the
+// filters cannot be joined. It wraps ErrIncompatible.
+type JoinCompatibilityError struct {
+	Filters []FilterInfo
+	Pairs   []IncompatiblePair
+}
+
+// maxReportedPairs is the number of incompatible pairs described by the
+// message of a JoinCompatibilityError.
+const maxReportedPairs = 10
+
+func (e *JoinCompatibilityError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d incompatible pairs of filters", len(e.Pairs))
+	for i, pair := range e.Pairs {
+		if i == maxReportedPairs {
+			fmt.Fprintf(&b, "; and %d more", len(e.Pairs)-i)
+			break
+		}
+		sep := ", "
+		if i == 0 {
+			sep = ": "
+		}
+		fmt.Fprintf(&b, "%s%s and %s (%s)", sep, e.name(pair.A), e.name(pair.B), strings.Join(pair.Differences, ", "))
+	}
+	return b.String()
+}
+
+func (e *JoinCompatibilityError) Unwrap() error {
+	return ErrIncompatible
+}
+
+// name returns the name of a filter, or its index if it has none.
+func (e *JoinCompatibilityError) name(i int) string {
+	if e.Filters[i].Name != "" {
+		return e.Filters[i].Name
+	}
+	return fmt.Sprintf("filter %d", i)
+}
+
+// CheckJoinCompatibility checks that all of the given filters can be joined,
+// i.e. have the same dimensions and hashing scheme, as Join does. If not, a
+// *JoinCompatibilityError listing every incompatible pair is returned.
+func CheckJoinCompatibility(headers []FilterInfo) error {
+	var pairs []IncompatiblePair
+	for i := range headers {
+		for j := i + 1; j < len(headers); j++ {
+			if differences := joinDifferences(headers[i], headers[j]); len(differences) > 0 {
+				pairs = append(pairs, IncompatiblePair{A: i, B: j, Differences: differences})
+			}
+		}
+	}
+	if len(pairs) > 0 {
+		return &JoinCompatibilityError{Filters: headers, Pairs: pairs}
+	}
+	return nil
+}
+
+// joinDifferences describes the parameters in which two filters differ that
+// prevent joining them.
+func joinDifferences(a, b FilterInfo) []string {
+	var differences []string
+	if a.Capacity != b.Capacity {
+		differences = append(differences, fmt.Sprintf("n = %d vs. %d", a.Capacity, b.Capacity))
+	}
+	if a.FalsePositiveProb != b.FalsePositiveProb {
+		differences = append(differences, fmt.Sprintf("p = %g vs. %g", a.FalsePositiveProb, b.FalsePositiveProb))
+	}
+	if a.HashFuncs != b.HashFuncs {
+		differences = append(differences, fmt.Sprintf("k = %d vs. %d", a.HashFuncs, b.HashFuncs))
+	}
+	if a.Bits != b.Bits {
+		differences = append(differences, fmt.Sprintf("m = %d vs. %d", a.Bits, b.Bits))
+	}
+	if a.Words != b.Words {
+		differences = append(differences, fmt.Sprintf("M = %d vs. %d", a.Words, b.Words))
+	}
+	if a.HashScheme != b.HashScheme {
+		differences = append(differences, fmt.Sprintf("hash scheme = %d vs. %d", a.HashScheme, b.HashScheme))
+	}
+	return differences
+}
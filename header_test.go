@@ -0,0 +1,163 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResponseWriter counts the bytes of the responses of a handler.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
+
+// serializeFilter returns the binary representation of a filter, compressed
+// if gzipped is true.
+func serializeFilter(t *testing.T, filter *BloomFilter, gzipped bool, opts ...WriteOption) []byte {
+	var buf bytes.Buffer
+	if !gzipped {
+		if err := filter.Write(&buf, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	gz := gzip.NewWriter(&buf)
+	if err := filter.Write(gz, opts...); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadHeader(t *testing.T) {
+	filter := mustNew(100000, 0.001)
+	filter.Add([]byte("foo"))
+	for _, gzipped := range []bool{false, true} {
+		info, err := ReadHeader(bytes.NewReader(serializeFilter(t, filter, gzipped, WithComment("test"))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Version != FormatVersion2 || info.Capacity != 100000 || info.FalsePositiveProb != 0.001 ||
+			info.HashFuncs != filter.NumHashFuncs() || info.Bits != filter.NumBits() || info.Words != filter.M ||
+			info.Elements != 1 || info.HashScheme != HashSchemeFNV1 || !strings.HasSuffix(info.Comment, ": test") {
+			t.Fatalf("unexpected header %+v", info)
+		}
+	}
+	if _, err := ReadHeader(strings.NewReader("short")); err == nil {
+		t.Fatal("expected an error for a short header")
+	}
+	info, err := ReadHeaderFromFile("testdata/test.bloom.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != FormatVersion1 || info.Name != "testdata/test.bloom.gz" || info.Comment != "" {
+		t.Fatalf("unexpected header %+v", info)
+	}
+}
+
+func TestReadHeaderFromURL(t *testing.T) {
+	// the filter is larger than the buffers of the connection, so that the
+	// download is seen to be aborted
+	filter := mustNew(10000000, 0.001)
+	for _, gzipped := range []bool{false, true} {
+		data := serializeFilter(t, filter, gzipped)
+		for _, ranges := range []bool{true, false} {
+			// the bytes written are counted to check that not the whole
+			// filter is transferred
+			var written int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if ranges {
+					http.ServeContent(countingResponseWriter{w, &written}, r, "filter.bloom", time.Time{}, bytes.NewReader(data))
+					return
+				}
+				for offset := 0; offset < len(data); offset += 4096 {
+					end := offset + 4096
+					if end > len(data) {
+						end = len(data)
+					}
+					if _, err := w.Write(data[offset:end]); err != nil {
+						return
+					}
+					w.(http.Flusher).Flush()
+					atomic.AddInt64(&written, int64(end-offset))
+				}
+			}))
+			info, err := ReadHeaderFromURL(context.Background(), server.URL)
+			server.Close()
+			if err != nil {
+				t.Fatalf("gzip %t, ranges %t: %v", gzipped, ranges, err)
+			}
+			if info.Capacity != 10000000 || info.Bits != filter.NumBits() || info.Name != server.URL {
+				t.Fatalf("gzip %t, ranges %t: unexpected header %+v", gzipped, ranges, info)
+			}
+			if !gzipped && written >= int64(len(data)) {
+				t.Fatalf("gzip %t, ranges %t: whole filter of %d bytes transferred", gzipped, ranges, len(data))
+			}
+		}
+	}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	var status *HTTPStatusError
+	if _, err := ReadHeaderFromURL(context.Background(), server.URL); !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+}
+
+func TestCheckJoinCompatibility(t *testing.T) {
+	info := func(name string, n uint64, p float64) FilterInfo {
+		filter := mustNew(n, p)
+		header, err := ReadHeader(bytes.NewReader(serializeFilter(t, filter, false)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		header.Name = name
+		return header
+	}
+	a, b, c := info("a", 1000, 0.01), info("b", 1000, 0.01), info("c", 2000, 0.01)
+	if err := CheckJoinCompatibility([]FilterInfo{a, b}); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckJoinCompatibility(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	d := info("d", 1000, 0.001)
+	err := CheckJoinCompatibility([]FilterInfo{a, b, c, d})
+	var joinErr *JoinCompatibilityError
+	if !errors.As(err, &joinErr) || !errors.Is(err, ErrIncompatible) {
+		t.Fatalf("expected a JoinCompatibilityError, got %v", err)
+	}
+	// every pair but a and b is incompatible
+	if len(joinErr.Pairs) != 5 || joinErr.Pairs[0].A != 0 || joinErr.Pairs[0].B != 2 {
+		t.Fatalf("unexpected pairs %+v", joinErr.Pairs)
+	}
+	if !strings.Contains(err.Error(), "a and c (n = 1000 vs. 2000, m = ") || !strings.Contains(err.Error(), "a and d (p = 0.01 vs. 0.001, k = 7 vs. 10, m = ") {
+		t.Fatalf("unexpected error %q", err)
+	}
+
+	scheme := a
+	scheme.HashScheme = 2
+	if err := CheckJoinCompatibility([]FilterInfo{a, scheme}); err == nil || !strings.Contains(err.Error(), "hash scheme = 1 vs. 2") {
+		t.Fatalf("expected a hash scheme difference, got %v", err)
+	}
+}
@@ -0,0 +1,81 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"math"
+)
+
+// HealthIssue is a condition of a filter that makes its results less
+// trustworthy than designed, see Health. The concrete types are OverCapacity
+// and EstimatedFPRate.
+type HealthIssue interface {
+	fmt.Stringer
+	healthIssue()
+}
+
+// OverCapacity is reported if more elements were added to a filter than its
+// capacity.
+type OverCapacity struct {
+	// Ratio is the number of elements relative to the capacity.
+	Ratio float64
+}
+
+func (OverCapacity) healthIssue() {}
+
+func (i OverCapacity) String() string {
+	return fmt.Sprintf("over capacity (%.2f times the capacity)", i.Ratio)
+}
+
+// EstimatedFPRate is reported if the false positive probability estimated
+// from the fraction of set bits exceeds the one the filter was designed for.
+type EstimatedFPRate struct {
+	// Value is the estimated false positive probability.
+	Value float64
+	// Design is the false positive probability the filter was designed for.
+	Design float64
+}
+
+func (EstimatedFPRate) healthIssue() {}
+
+func (i EstimatedFPRate) String() string {
+	return fmt.Sprintf("estimated FP probability %.2e exceeds %.2e", i.Value, i.Design)
+}
+
+// EstimatedFalsePositiveProb returns the probability that a value that was
+// not added matches, estimated from the fraction of set bits.
+func (s *BloomFilter) EstimatedFalsePositiveProb() float64 {
+	return s.falsePositiveProbAt(s.NumSetBits())
+}
+
+// falsePositiveProbAt returns the FP probability of the filter with the given
+// number of set bits.
+func (s *BloomFilter) falsePositiveProbAt(setBits uint64) float64 {
+	if s.m == 0 {
+		return 0
+	}
+	return math.Pow(float64(setBits)/float64(s.m), float64(s.k))
+}
+
+// Health returns the conditions that make the results of the filter less
+// trustworthy than designed, or nil if there are none. The issues are derived
+// from the current state of the filter, so they reflect all modifications
+// made since it was created or loaded. As the fraction of set bits is
+// counted, Health takes time linear in the size of the filter.
+func (s *BloomFilter) Health() []HealthIssue {
+	var issues []HealthIssue
+	if s.NumElements() > s.n {
+		issues = append(issues, OverCapacity{Ratio: float64(s.NumElements()) / float64(s.n)})
+	}
+	if p := s.EstimatedFalsePositiveProb(); p > s.p {
+		issues = append(issues, EstimatedFPRate{Value: p, Design: s.p})
+	}
+	return issues
+}
+
+// Healthy returns true if Health reports no issues.
+func (s *BloomFilter) Healthy() bool {
+	return len(s.Health()) == 0
+}
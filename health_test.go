@@ -0,0 +1,63 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	if !filter.Healthy() || filter.Health() != nil {
+		t.Fatalf("unexpected issues of an empty filter: %v", filter.Health())
+	}
+	for i := 0; i < 500; i++ {
+		filter.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	if !filter.Healthy() {
+		t.Fatalf("unexpected issues of a half-full filter: %v", filter.Health())
+	}
+
+	// adding values beyond the capacity raises both issues
+	for i := 500; i < 3000; i++ {
+		filter.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	issues := filter.Health()
+	if filter.Healthy() || len(issues) != 2 {
+		t.Fatalf("expected two issues, got %v", issues)
+	}
+	over, ok := issues[0].(OverCapacity)
+	if !ok || over.Ratio != float64(filter.N)/1000 {
+		t.Fatalf("expected the filter to be over capacity, got %v", issues[0])
+	}
+	rate, ok := issues[1].(EstimatedFPRate)
+	if !ok || rate.Design != 0.01 || rate.Value <= 0.01 || rate.Value != filter.EstimatedFalsePositiveProb() {
+		t.Fatalf("expected an excessive FP rate, got %v", issues[1])
+	}
+
+	// the issues are updated on mutation
+	filter.Reset()
+	if !filter.Healthy() {
+		t.Fatalf("unexpected issues after reset: %v", filter.Health())
+	}
+
+	// a filter joined with another one within its capacity may still have
+	// more bits set than designed
+	other := mustNew(1000, 0.01)
+	for i := 0; i < 2000; i++ {
+		other.Add([]byte(fmt.Sprintf("other-%d", i)))
+	}
+	other.N = 0
+	if err := filter.Join(other); err != nil {
+		t.Fatal(err)
+	}
+	issues = filter.Health()
+	if len(issues) != 1 {
+		t.Fatalf("expected a single issue, got %v", issues)
+	}
+	if _, ok := issues[0].(EstimatedFPRate); !ok {
+		t.Fatalf("expected an excessive FP rate, got %v", issues[0])
+	}
+}
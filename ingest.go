@@ -0,0 +1,272 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultIngestBatchSize is the maximum number of values that ConsumeChannel
+// takes from the channel at once before adding them to the filter.
+const DefaultIngestBatchSize = 1024
+
+// WriteFunc persists a snapshot of a filter, e.g. using WriteFilter. It is
+// called from the consuming goroutine, so the filter is not modified while it
+// runs.
+type WriteFunc func(filter *BloomFilter) error
+
+// IngestStats counts the values consumed by ConsumeChannel.
+type IngestStats struct {
+	// Consumed is the number of values taken from the channel.
+	Consumed uint64
+	// New is the number of values that set at least one bit.
+	New uint64
+	// Duplicates is the number of values whose bits were all set already,
+	// i.e. values added before or false positives.
+	Duplicates uint64
+	// Rejected is the number of values rejected due to their length.
+	Rejected uint64
+	// Snapshots is the number of snapshots persisted successfully.
+	Snapshots uint64
+	// SnapshotErrors is the number of failed snapshots.
+	SnapshotErrors uint64
+	// DedupHits is the number of values that were not added again because
+	// the window configured with WithDedupWindow remembered them, which
+	// are counted as Duplicates as well, and DedupMisses the number of
+	// values it did not remember.
+	DedupHits   uint64
+	DedupMisses uint64
+}
+
+// IngestOption configures ConsumeChannel.
+type IngestOption func(*ingestOptions)
+
+// clock provides the time for snapshot intervals and persisting, so that
+// tests can control it.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type ingestOptions struct {
+	batchSize        int
+	write            WriteFunc
+	snapshotValues   uint64
+	snapshotInterval time.Duration
+	continueOnError  bool
+	clock            clock
+	alarmThresholds  []float64
+	alarm            func(threshold float64, stats FilterStats)
+	dedupWindow      int
+}
+
+// WithIngestBatchSize sets the maximum number of values taken from the
+// channel at once (DefaultIngestBatchSize by default).
+func WithIngestBatchSize(n int) IngestOption {
+	return func(o *ingestOptions) {
+		o.batchSize = n
+	}
+}
+
+// WithSnapshots makes ConsumeChannel persist snapshots of the filter using
+// write whenever the given number of values was consumed or the given
+// interval has passed since the last snapshot, if any values were consumed
+// since, and when the channel is closed. A zero number of values or interval
+// disables the respective trigger.
+func WithSnapshots(write WriteFunc, everyValues uint64, every time.Duration) IngestOption {
+	return func(o *ingestOptions) {
+		o.write = write
+		o.snapshotValues = everyValues
+		o.snapshotInterval = every
+	}
+}
+
+// ContinueOnSnapshotError makes ConsumeChannel continue consuming values if
+// a snapshot fails, counting the failure in IngestStats.SnapshotErrors,
+// instead of stopping and returning the error.
+func ContinueOnSnapshotError() IngestOption {
+	return func(o *ingestOptions) {
+		o.continueOnError = true
+	}
+}
+
+// WithSaturationAlarm makes ConsumeChannel call fn when the estimated FP
+// probability of the filter first exceeds each of the thresholds, given as
+// factors of the designed FP probability (e.g. DefaultSaturationThresholds),
+// see SaturationAlarm. The alarm is evaluated after each batch of values.
+func WithSaturationAlarm(thresholds []float64, fn func(threshold float64, stats FilterStats)) IngestOption {
+	return func(o *ingestOptions) {
+		o.alarmThresholds = thresholds
+		o.alarm = fn
+	}
+}
+
+// WithDedupWindow makes ConsumeChannel skip adding the values among the
+// given number of most recently added distinct values, see DedupWindow, which
+// does not change the filter. It is ignored for filters counting exactly
+// (see WithExactCounting), whose counts would miss the skipped duplicates.
+func WithDedupWindow(size int) IngestOption {
+	return func(o *ingestOptions) {
+		o.dedupWindow = size
+	}
+}
+
+func withClock(c clock) IngestOption {
+	return func(o *ingestOptions) {
+		o.clock = c
+	}
+}
+
+// ConsumeChannel adds the values received from the channel to the filter
+// until the channel is closed or the context is cancelled, in which case the
+// error of the context is returned. The filter must not be used by other
+// goroutines meanwhile. Values are taken from the channel in batches of up to
+// the configured batch size, so the producer is only blocked while a batch is
+// added. Snapshots are persisted as configured with WithSnapshots; a failing
+// snapshot stops the consumption with its error, unless
+// ContinueOnSnapshotError is given. The returned statistics cover all values
+// consumed, also if an error is returned.
+func (s *BloomFilter) ConsumeChannel(ctx context.Context, ch <-chan []byte, opts ...IngestOption) (IngestStats, error) {
+	o := ingestOptions{
+		batchSize: DefaultIngestBatchSize,
+		clock:     systemClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.batchSize < 1 {
+		o.batchSize = 1
+	}
+
+	var stats IngestStats
+	var pending uint64
+	var timer <-chan time.Time
+	resetTimer := func() {
+		if o.write != nil && o.snapshotInterval > 0 {
+			timer = o.clock.After(o.snapshotInterval)
+		}
+	}
+	snapshot := func() error {
+		if pending == 0 {
+			return nil
+		}
+		if err := o.write(s); err != nil {
+			stats.SnapshotErrors++
+			if !o.continueOnError {
+				return fmt.Errorf("persisting snapshot: %w", err)
+			}
+		} else {
+			stats.Snapshots++
+			pending = 0
+		}
+		resetTimer()
+		return nil
+	}
+	var alarm *SaturationAlarm
+	if o.alarm != nil {
+		alarm = NewSaturationAlarm(s, o.alarmThresholds, o.alarm)
+	}
+	tryAdd := s.TryAdd
+	if alarm != nil {
+		tryAdd = alarm.TryAdd
+	}
+	var window *DedupWindow
+	if o.dedupWindow > 0 && s.exactCounter == nil {
+		window = NewDedupWindow(o.dedupWindow)
+	}
+	add := func(value []byte) {
+		stats.Consumed++
+		pending++
+		if window != nil {
+			if window.Contains(value) {
+				stats.DedupHits++
+				stats.Duplicates++
+				return
+			}
+			stats.DedupMisses++
+		}
+		n := s.NumElements()
+		if err := tryAdd(value); err != nil {
+			stats.Rejected++
+			return
+		}
+		if s.NumElements() > n {
+			stats.New++
+		} else {
+			stats.Duplicates++
+		}
+		if window != nil {
+			window.Insert(value)
+		}
+	}
+
+	resetTimer()
+	batch := make([][]byte, 0, o.batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		case <-timer:
+			timer = nil
+			if err := snapshot(); err != nil {
+				return stats, err
+			}
+			if timer == nil {
+				resetTimer()
+			}
+			continue
+		case value, ok := <-ch:
+			if !ok {
+				if o.write != nil {
+					return stats, snapshot()
+				}
+				return stats, nil
+			}
+			batch = append(batch[:0], value)
+		}
+		closed := false
+	fill:
+		for len(batch) < o.batchSize {
+			select {
+			case value, ok := <-ch:
+				if !ok {
+					closed = true
+					break fill
+				}
+				batch = append(batch, value)
+			default:
+				break fill
+			}
+		}
+		for _, value := range batch {
+			add(value)
+		}
+		if alarm != nil {
+			alarm.Evaluate()
+		}
+		if closed {
+			if o.write != nil {
+				return stats, snapshot()
+			}
+			return stats, nil
+		}
+		if o.write != nil && o.snapshotValues > 0 && pending >= o.snapshotValues {
+			if err := snapshot(); err != nil {
+				return stats, err
+			}
+		}
+	}
+}
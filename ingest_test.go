@@ -0,0 +1,228 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock fires the timers returned by After when tick is called.
+type fakeClock struct {
+	timers chan chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{timers: make(chan chan time.Time, 100)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	return time.Time{}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	timer := make(chan time.Time, 1)
+	c.timers <- timer
+	return timer
+}
+
+// tick fires the most recently started timer.
+func (c *fakeClock) tick() {
+	var timer chan time.Time
+	for timer == nil || len(c.timers) > 0 {
+		timer = <-c.timers
+	}
+	timer <- time.Time{}
+}
+
+func TestConsumeChannel(t *testing.T) {
+	filter := mustNew(10000, 0.0001)
+	filter.SetMaxValueLength(10, RejectValues)
+	ch := make(chan []byte, 10)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			ch <- []byte(fmt.Sprintf("value-%d", i%500))
+		}
+		ch <- []byte("a value that is too long")
+		close(ch)
+	}()
+	var snapshots []uint64
+	stats, err := filter.ConsumeChannel(context.Background(), ch,
+		WithIngestBatchSize(7),
+		WithSnapshots(func(f *BloomFilter) error {
+			snapshots = append(snapshots, f.N)
+			return nil
+		}, 300, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := IngestStats{Consumed: 1001, New: 500, Duplicates: 500, Rejected: 1, Snapshots: uint64(len(snapshots))}
+	if stats != expected {
+		t.Fatalf("unexpected stats %+v, expected %+v", stats, expected)
+	}
+	// three snapshots after at least 300 values each, and a final one
+	if len(snapshots) != 4 || snapshots[len(snapshots)-1] != 500 {
+		t.Fatalf("unexpected snapshots %v", snapshots)
+	}
+}
+
+func TestConsumeChannelInterval(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	clock := newFakeClock()
+	ch := make(chan []byte)
+	written := make(chan uint64)
+	done := make(chan IngestStats)
+	go func() {
+		stats, err := filter.ConsumeChannel(context.Background(), ch, withClock(clock),
+			WithSnapshots(func(f *BloomFilter) error {
+				written <- f.N
+				return nil
+			}, 0, time.Minute))
+		if err != nil {
+			t.Error(err)
+		}
+		done <- stats
+	}()
+	ch <- []byte("foo")
+	clock.tick()
+	if n := <-written; n != 1 {
+		t.Fatalf("unexpected snapshot of %d values", n)
+	}
+	// no snapshot without new values
+	clock.tick()
+	ch <- []byte("bar")
+	clock.tick()
+	if n := <-written; n != 2 {
+		t.Fatalf("unexpected snapshot of %d values", n)
+	}
+	close(ch)
+	if stats := <-done; stats.Snapshots != 2 || stats.Consumed != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestConsumeChannelSnapshotErrors(t *testing.T) {
+	errFull := errors.New("disk full")
+	failing := func(*BloomFilter) error { return errFull }
+	values := func() chan []byte {
+		ch := make(chan []byte, 100)
+		for i := 0; i < 100; i++ {
+			ch <- []byte(fmt.Sprintf("value-%d", i))
+		}
+		close(ch)
+		return ch
+	}
+
+	// stop by default
+	filter := mustNew(1000, 0.0001)
+	stats, err := filter.ConsumeChannel(context.Background(), values(),
+		WithIngestBatchSize(10), WithSnapshots(failing, 10, 0))
+	if !errors.Is(err, errFull) {
+		t.Fatalf("expected snapshot error, got %v", err)
+	}
+	if stats.Consumed != 10 || stats.SnapshotErrors != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// continue if configured
+	filter = mustNew(1000, 0.0001)
+	stats, err = filter.ConsumeChannel(context.Background(), values(),
+		WithIngestBatchSize(10), WithSnapshots(failing, 10, 0), ContinueOnSnapshotError())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Consumed != 100 || stats.SnapshotErrors != 11 || stats.Snapshots != 0 || filter.N != 100 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestConsumeChannelCancel(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan []byte)
+	done := make(chan error)
+	go func() {
+		_, err := filter.ConsumeChannel(ctx, ch)
+		done <- err
+	}()
+	ch <- []byte("foo")
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+	if !filter.Check([]byte("foo")) {
+		t.Fatal("value not added")
+	}
+}
+
+func TestConsumeChannelDedupWindow(t *testing.T) {
+	// bursts of repeated values, some of them recurring after the window
+	values := make([][]byte, 0, 20000)
+	for i := 0; len(values) < cap(values); i++ {
+		for j := 0; j < 1+i%20; j++ {
+			values = append(values, []byte(fmt.Sprintf("value-%d", i%700)))
+		}
+	}
+	values = append(values, []byte("a value that is too long"), []byte("a value that is too long"))
+	consume := func(opts ...IngestOption) (*BloomFilter, IngestStats) {
+		filter := mustNew(10000, 0.001)
+		filter.SetMaxValueLength(12, RejectValues)
+		ch := make(chan []byte, len(values))
+		for _, value := range values {
+			ch <- value
+		}
+		close(ch)
+		stats, err := filter.ConsumeChannel(context.Background(), ch, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filter, stats
+	}
+	plain, plainStats := consume()
+	deduped, stats := consume(WithDedupWindow(16))
+	var plainData, dedupedData bytes.Buffer
+	if err := plain.Write(&plainData); err != nil {
+		t.Fatal(err)
+	}
+	if err := deduped.Write(&dedupedData); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plainData.Bytes(), dedupedData.Bytes()) {
+		t.Fatal("the filters differ with and without the dedup window")
+	}
+	if stats.DedupHits+stats.DedupMisses != stats.Consumed || stats.DedupHits < stats.Consumed/2 {
+		t.Fatalf("unexpected dedup counters %+v", stats)
+	}
+	// the window only saves work, the other counters are unchanged
+	stats.DedupHits, stats.DedupMisses = 0, 0
+	if stats != plainStats {
+		t.Fatalf("unexpected stats %+v, expected %+v", stats, plainStats)
+	}
+	// rejected values are not remembered
+	if stats.Rejected != 2 {
+		t.Fatalf("expected 2 rejected values, got %d", stats.Rejected)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	w := NewDedupWindow(2)
+	w.Insert([]byte("a"))
+	w.Insert([]byte("b"))
+	w.Insert([]byte("a"))
+	if !w.Contains([]byte("a")) || !w.Contains([]byte("b")) || w.Contains([]byte("c")) {
+		t.Fatal("unexpected contents of the window")
+	}
+	// the oldest value is forgotten first
+	w.Insert([]byte("c"))
+	if w.Contains([]byte("a")) || !w.Contains([]byte("b")) || !w.Contains([]byte("c")) {
+		t.Fatal("unexpected contents of the window after eviction")
+	}
+	if w.Hits() != 4 || w.Misses() != 2 {
+		t.Fatalf("unexpected counters: %d hits, %d misses", w.Hits(), w.Misses())
+	}
+}
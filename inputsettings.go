@@ -0,0 +1,193 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metadata keys of the settings with which values were extracted from input
+// lines, see InputSettings.
+const (
+	// MetadataKeyDelimiter is the delimiter at which lines were split into
+	// fields. It is absent if whole lines were added.
+	MetadataKeyDelimiter = "bloom.input.delimiter"
+	// MetadataKeyFields are the indexes of the fields of split lines that
+	// were added (comma-separated, negative indexes counting from the end).
+	MetadataKeyFields = "bloom.input.fields"
+	// MetadataKeyTupleFields are the indexes of the fields combined into a
+	// single composite value (see EncodeTuple) that was added.
+	MetadataKeyTupleFields = "bloom.input.tuple-fields"
+	// MetadataKeyDecoding is the encoding of the input values, e.g. "hex",
+	// which were decoded before they were added.
+	MetadataKeyDecoding = "bloom.input.decoding"
+)
+
+// InputSettings describes how the values added to a filter were extracted
+// from lines of input, so that values can be checked against it in the same
+// way. Applications reading values from delimited input record them with
+// RecordInputSettings and compare them with CompareInputSettings.
+type InputSettings struct {
+	// Split is true if lines were split at Delimiter.
+	Split     bool
+	Delimiter string
+	// Fields are the indexes of the fields of split lines that were used,
+	// all fields if empty.
+	Fields []int
+	// TupleFields are the indexes of the fields combined into a single
+	// composite value.
+	TupleFields []int
+	// Normalization is the normalization applied to values, see
+	// WithNormalization.
+	Normalization string
+	// Decoding is the encoding of the input values that were decoded before
+	// they were added, e.g. "hex", or empty if they were used as they are.
+	Decoding string
+}
+
+// String describes the settings like the flags of the command line tool.
+func (settings InputSettings) String() string {
+	var parts []string
+	if settings.Split {
+		parts = append(parts, fmt.Sprintf("split at %q", settings.Delimiter))
+		if len(settings.Fields) > 0 {
+			parts = append(parts, "fields "+formatFields(settings.Fields))
+		}
+	} else {
+		parts = append(parts, "whole lines")
+	}
+	if len(settings.TupleFields) > 0 {
+		parts = append(parts, "tuple fields "+formatFields(settings.TupleFields))
+	}
+	if settings.Normalization != "" {
+		parts = append(parts, "normalization "+settings.Normalization)
+	}
+	if settings.Decoding != "" {
+		parts = append(parts, "decoding "+settings.Decoding)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func formatFields(fields []int) string {
+	s := make([]string, len(fields))
+	for i, field := range fields {
+		s[i] = strconv.Itoa(field)
+	}
+	return strings.Join(s, ",")
+}
+
+func parseFields(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var fields []int
+	for _, part := range strings.Split(s, ",") {
+		field, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// RecordInputSettings stores the settings in the metadata of the filter,
+// replacing those recorded before, apart from a normalization recorded with
+// WithNormalization, which is only replaced by another one. Only settings
+// differing from adding whole lines are stored, so that filters built from
+// whole lines keep the metadata (and file format) they had before.
+func (s *BloomFilter) RecordInputSettings(settings InputSettings) {
+	s.DeleteMetadata(MetadataKeyDelimiter)
+	s.DeleteMetadata(MetadataKeyFields)
+	s.DeleteMetadata(MetadataKeyTupleFields)
+	s.DeleteMetadata(MetadataKeyDecoding)
+	if settings.Split {
+		s.SetMetadata(MetadataKeyDelimiter, settings.Delimiter)
+		if len(settings.Fields) > 0 {
+			s.SetMetadata(MetadataKeyFields, formatFields(settings.Fields))
+		}
+	}
+	if len(settings.TupleFields) > 0 {
+		s.SetMetadata(MetadataKeyTupleFields, formatFields(settings.TupleFields))
+	}
+	if settings.Decoding != "" {
+		s.SetMetadata(MetadataKeyDecoding, settings.Decoding)
+	}
+	if settings.Normalization != "" {
+		s.SetMetadata(MetadataKeyNormalization, settings.Normalization)
+	}
+}
+
+// RecordedInputSettings returns the settings recorded with the filter, and
+// false if none were recorded, e.g. for filters of version 1 of the file
+// format or built by applications that do not record them.
+func (s *BloomFilter) RecordedInputSettings() (InputSettings, bool, error) {
+	var settings InputSettings
+	recorded := false
+	if delimiter, ok := s.Metadata(MetadataKeyDelimiter); ok {
+		settings.Split, settings.Delimiter, recorded = true, delimiter, true
+	}
+	for key, fields := range map[string]*[]int{MetadataKeyFields: &settings.Fields, MetadataKeyTupleFields: &settings.TupleFields} {
+		v, ok := s.Metadata(key)
+		if !ok {
+			continue
+		}
+		parsed, err := parseFields(v)
+		if err != nil {
+			return settings, false, fmt.Errorf("invalid metadata %s: %w", key, err)
+		}
+		*fields, recorded = parsed, true
+	}
+	if normalization, ok := s.Metadata(MetadataKeyNormalization); ok {
+		settings.Normalization, recorded = normalization, true
+	}
+	if decoding, ok := s.Metadata(MetadataKeyDecoding); ok {
+		settings.Decoding, recorded = decoding, true
+	}
+	return settings, recorded, nil
+}
+
+// CompareInputSettings returns descriptions of the differences between the
+// settings recorded with the filter and those with which values are about to
+// be checked that make the check miss values of the filter, each describing
+// the recorded setting instead of the given one: values of split lines checked
+// as whole lines or split at another delimiter, different tuple fields, a
+// different normalization or decoding. Checking other fields of lines split at
+// the same delimiter (e.g. both the source and the destination column of a
+// log) is common and hence not reported. The result is empty if the settings
+// agree or if the filter has no recorded settings.
+func (s *BloomFilter) CompareInputSettings(settings InputSettings) ([]string, error) {
+	recorded, ok, err := s.RecordedInputSettings()
+	if err != nil || !ok {
+		return nil, err
+	}
+	var differences []string
+	if recorded.Split && !settings.Split {
+		differences = append(differences, fmt.Sprintf("split at %q instead of whole lines", recorded.Delimiter))
+	} else if recorded.Split && settings.Delimiter != recorded.Delimiter {
+		differences = append(differences, fmt.Sprintf("split at %q instead of %q", recorded.Delimiter, settings.Delimiter))
+	}
+	if formatFields(recorded.TupleFields) != formatFields(settings.TupleFields) {
+		differences = append(differences, fmt.Sprintf("tuple fields %s instead of %s",
+			describeOptional(formatFields(recorded.TupleFields)), describeOptional(formatFields(settings.TupleFields))))
+	}
+	if recorded.Normalization != settings.Normalization {
+		differences = append(differences, fmt.Sprintf("normalization %s instead of %s",
+			describeOptional(recorded.Normalization), describeOptional(settings.Normalization)))
+	}
+	if recorded.Decoding != settings.Decoding {
+		differences = append(differences, fmt.Sprintf("decoding %s instead of %s",
+			describeOptional(recorded.Decoding), describeOptional(settings.Decoding)))
+	}
+	return differences, nil
+}
+
+func describeOptional(setting string) string {
+	if setting == "" {
+		return "none"
+	}
+	return setting
+}
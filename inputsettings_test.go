@@ -0,0 +1,75 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordInputSettings(t *testing.T) {
+	settings := InputSettings{Split: true, Delimiter: "\t", Fields: []int{3, -1}, TupleFields: []int{0, 1}, Normalization: "lowercase", Decoding: "hex"}
+	filter := mustNew(100, 0.01)
+	filter.RecordInputSettings(settings)
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorded, ok, err := loaded.RecordedInputSettings()
+	if err != nil || !ok || !reflect.DeepEqual(recorded, settings) {
+		t.Fatalf("unexpected settings %+v, %v, %v", recorded, ok, err)
+	}
+	if s := recorded.String(); s != `split at "\t", fields 3,-1, tuple fields 0,1, normalization lowercase, decoding hex` {
+		t.Fatalf("unexpected description %q", s)
+	}
+
+	// whole lines are not recorded, but the normalization is kept
+	loaded.RecordInputSettings(InputSettings{Delimiter: ","})
+	if keys := loaded.MetadataKeys(); len(keys) != 2 || keys[0] != MetadataKeyNormalization || keys[1] != MetadataKeyProducer {
+		t.Fatalf("unexpected metadata keys %v", keys)
+	}
+
+	plain := mustNew(100, 0.01)
+	plain.RecordInputSettings(InputSettings{})
+	if _, ok, err := plain.RecordedInputSettings(); ok || err != nil || len(plain.MetadataKeys()) != 0 {
+		t.Fatalf("expected no recorded settings, got %v, %v", ok, err)
+	}
+
+	plain.SetMetadata(MetadataKeyFields, "1,x")
+	if _, _, err := plain.RecordedInputSettings(); err == nil || !strings.Contains(err.Error(), MetadataKeyFields) {
+		t.Fatalf("expected an invalid metadata error, got %v", err)
+	}
+}
+
+func TestCompareInputSettings(t *testing.T) {
+	tsv := InputSettings{Split: true, Delimiter: "\t", Fields: []int{3}}
+	for _, c := range []struct {
+		recorded, checked InputSettings
+		differences       []string
+	}{
+		{tsv, tsv, nil},
+		{tsv, InputSettings{Split: true, Delimiter: "\t", Fields: []int{4, 5}}, nil},
+		{tsv, InputSettings{Split: true, Delimiter: ",", Fields: []int{3}}, []string{`split at "\t" instead of ","`}},
+		{tsv, InputSettings{Delimiter: "\t"}, []string{`split at "\t" instead of whole lines`}},
+		{InputSettings{}, InputSettings{Split: true, Delimiter: ","}, nil},
+		{InputSettings{TupleFields: []int{0, 1}}, InputSettings{TupleFields: []int{1, 0}}, []string{"tuple fields 0,1 instead of 1,0"}},
+		{InputSettings{TupleFields: []int{0, 1}}, InputSettings{}, []string{"tuple fields 0,1 instead of none"}},
+		{InputSettings{Normalization: "lowercase"}, InputSettings{}, []string{"normalization lowercase instead of none"}},
+		{InputSettings{Decoding: "hex"}, InputSettings{}, []string{"decoding hex instead of none"}},
+		{InputSettings{Decoding: "hex"}, InputSettings{Decoding: "base64"}, []string{"decoding hex instead of base64"}},
+	} {
+		filter := mustNew(100, 0.01)
+		filter.RecordInputSettings(c.recorded)
+		differences, err := filter.CompareInputSettings(c.checked)
+		if err != nil || !reflect.DeepEqual(differences, c.differences) {
+			t.Errorf("%+v vs. %+v: unexpected differences %q (%v)", c.recorded, c.checked, differences, err)
+		}
+	}
+}
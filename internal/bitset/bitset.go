@@ -0,0 +1,113 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package bitset implements the operations on bit arrays shared by the
+// filter variants. A bit array is a slice of 64-bit words, in which bit i is
+// bit i%64 (counting from the least significant bit) of word i/64, as in the
+// binary format of filters. The functions do not check their arguments
+// beyond the bounds checks of Go, i.e. they panic for bits outside of the
+// array and for sources shorter than their destination.
+package bitset
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// WordBits is the number of bits of a word.
+const WordBits = 64
+
+// Index returns the index of the word holding the given bit and the mask
+// selecting the bit within the word.
+func Index(bit uint64) (word uint64, mask uint64) {
+	return bit / WordBits, 1 << (bit % WordBits)
+}
+
+// Test returns whether the given bit is set.
+func Test(words []uint64, bit uint64) bool {
+	word, mask := Index(bit)
+	return words[word]&mask != 0
+}
+
+// Set sets the given bit and returns whether it was not set before.
+func Set(words []uint64, bit uint64) bool {
+	word, mask := Index(bit)
+	old := words[word]
+	words[word] = old | mask
+	return old&mask == 0
+}
+
+// SetAtomic sets the given bit atomically, so that concurrent calls of
+// SetAtomic on the same array are safe, and returns whether it was not set
+// before. Concurrent reads of the array must use atomic loads.
+func SetAtomic(words []uint64, bit uint64) bool {
+	word, mask := Index(bit)
+	addr := &words[word]
+	for {
+		old := atomic.LoadUint64(addr)
+		if old&mask != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(addr, old, old|mask) {
+			return true
+		}
+	}
+}
+
+// Or sets the bits of dst that are set in src (dst |= src).
+func Or(dst, src []uint64) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] |= src[i]
+	}
+}
+
+// And clears the bits of dst that are not set in src (dst &= src).
+func And(dst, src []uint64) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] &= src[i]
+	}
+}
+
+// AndNot clears the bits of dst that are set in src (dst &^= src).
+func AndNot(dst, src []uint64) {
+	src = src[:len(dst)]
+	for i := range dst {
+		dst[i] &^= src[i]
+	}
+}
+
+// Clear clears all bits.
+func Clear(words []uint64) {
+	for i := range words {
+		words[i] = 0
+	}
+}
+
+// OnesCount returns the number of set bits.
+func OnesCount(words []uint64) uint64 {
+	var c uint64
+	for _, word := range words {
+		c += uint64(bits.OnesCount64(word))
+	}
+	return c
+}
+
+// OnesCountRange returns the number of set bits with indexes from from
+// (inclusive) to to (exclusive), or 0 if to is not greater than from.
+func OnesCountRange(words []uint64, from, to uint64) uint64 {
+	if to <= from {
+		return 0
+	}
+	first, last := from/WordBits, to/WordBits
+	if first == last {
+		return uint64(bits.OnesCount64(words[first] >> (from % WordBits) & (1<<(to-from) - 1)))
+	}
+	c := uint64(bits.OnesCount64(words[first] >> (from % WordBits)))
+	c += OnesCount(words[first+1 : last])
+	if to%WordBits != 0 {
+		c += uint64(bits.OnesCount64(words[last] & (1<<(to%WordBits) - 1)))
+	}
+	return c
+}
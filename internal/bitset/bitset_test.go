@@ -0,0 +1,205 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bitset
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// randomWords returns n random words with about the given fraction of bits
+// set.
+func randomWords(rng *rand.Rand, n int, fill float64) []uint64 {
+	words := make([]uint64, n)
+	for i := range words {
+		for j := 0; j < WordBits; j++ {
+			if rng.Float64() < fill {
+				words[i] |= 1 << j
+			}
+		}
+	}
+	return words
+}
+
+// naiveCount counts the set bits from from to to one by one.
+func naiveCount(words []uint64, from, to uint64) uint64 {
+	var c uint64
+	for bit := from; bit < to; bit++ {
+		if words[bit/64]>>(bit%64)&1 == 1 {
+			c++
+		}
+	}
+	return c
+}
+
+func TestIndex(t *testing.T) {
+	for _, c := range []struct {
+		bit, word, mask uint64
+	}{
+		{0, 0, 1},
+		{63, 0, 1 << 63},
+		{64, 1, 1},
+		{130, 2, 4},
+		{1<<64 - 1, 1<<58 - 1, 1 << 63},
+	} {
+		if word, mask := Index(c.bit); word != c.word || mask != c.mask {
+			t.Errorf("Index(%d) = %d, %#x, expected %d, %#x", c.bit, word, mask, c.word, c.mask)
+		}
+	}
+}
+
+func TestSetTest(t *testing.T) {
+	words := make([]uint64, 3)
+	for _, bit := range []uint64{0, 5, 63, 64, 191} {
+		if Test(words, bit) {
+			t.Fatalf("bit %d set before Set", bit)
+		}
+		if !Set(words, bit) {
+			t.Fatalf("bit %d reported as set before", bit)
+		}
+		if Set(words, bit) {
+			t.Fatalf("bit %d reported as not set before", bit)
+		}
+		if !Test(words, bit) {
+			t.Fatalf("bit %d not set", bit)
+		}
+	}
+	if words[0] != 1|1<<5|1<<63 || words[1] != 1 || words[2] != 1<<63 {
+		t.Fatalf("unexpected words %#x", words)
+	}
+	Clear(words)
+	if OnesCount(words) != 0 {
+		t.Fatalf("words not cleared: %#x", words)
+	}
+}
+
+func TestSetAtomic(t *testing.T) {
+	words := make([]uint64, 4)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	newBits := 0
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n := 0
+			for bit := uint64(0); bit < 256; bit++ {
+				if SetAtomic(words, bit) {
+					n++
+				}
+			}
+			mu.Lock()
+			newBits += n
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if newBits != 256 {
+		t.Fatalf("expected each of 256 bits to be set once, got %d", newBits)
+	}
+	for i, word := range words {
+		if word != ^uint64(0) {
+			t.Fatalf("word %d is %#x", i, word)
+		}
+	}
+}
+
+func TestBulk(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	a, b := randomWords(rng, 5, 0.5), randomWords(rng, 6, 0.5)
+	for _, c := range []struct {
+		name string
+		fn   func(dst, src []uint64)
+		op   func(x, y uint64) uint64
+	}{
+		{"Or", Or, func(x, y uint64) uint64 { return x | y }},
+		{"And", And, func(x, y uint64) uint64 { return x & y }},
+		{"AndNot", AndNot, func(x, y uint64) uint64 { return x &^ y }},
+	} {
+		dst := append([]uint64(nil), a...)
+		c.fn(dst, b)
+		for i := range dst {
+			if dst[i] != c.op(a[i], b[i]) {
+				t.Fatalf("%s: unexpected word %d: %#x", c.name, i, dst[i])
+			}
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic for a short source", c.name)
+				}
+			}()
+			c.fn(b, a)
+		}()
+	}
+}
+
+func TestOnesCountRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, fill := range []float64{0, 0.3, 1} {
+		words := randomWords(rng, 4, fill)
+		if OnesCount(words) != naiveCount(words, 0, 256) {
+			t.Fatalf("unexpected count %d", OnesCount(words))
+		}
+		for from := uint64(0); from <= 256; from++ {
+			for to := uint64(0); to <= 256; to++ {
+				expected := naiveCount(words, from, to)
+				if c := OnesCountRange(words, from, to); c != expected {
+					t.Fatalf("fill %g: OnesCountRange(%d, %d) = %d, expected %d", fill, from, to, c, expected)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSet(b *testing.B) {
+	words := make([]uint64, 1<<14)
+	mask := uint64(len(words)*WordBits - 1)
+	for i := 0; i < b.N; i++ {
+		Set(words, uint64(i)*0x9E3779B97F4A7C15&mask)
+	}
+}
+
+func BenchmarkSetAtomic(b *testing.B) {
+	words := make([]uint64, 1<<14)
+	mask := uint64(len(words)*WordBits - 1)
+	for i := 0; i < b.N; i++ {
+		SetAtomic(words, uint64(i)*0x9E3779B97F4A7C15&mask)
+	}
+}
+
+func BenchmarkTest(b *testing.B) {
+	words := randomWords(rand.New(rand.NewSource(1)), 1<<14, 0.5)
+	mask := uint64(len(words)*WordBits - 1)
+	for i := 0; i < b.N; i++ {
+		Test(words, uint64(i)*0x9E3779B97F4A7C15&mask)
+	}
+}
+
+func BenchmarkBulk(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{1 << 10, 1 << 20} {
+		dst, src := randomWords(rng, n, 0.5), randomWords(rng, n, 0.5)
+		b.Run(fmt.Sprintf("Or/words=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(8 * n))
+			for i := 0; i < b.N; i++ {
+				Or(dst, src)
+			}
+		})
+		b.Run(fmt.Sprintf("OnesCount/words=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(8 * n))
+			for i := 0; i < b.N; i++ {
+				OnesCount(dst)
+			}
+		})
+		b.Run(fmt.Sprintf("OnesCountRange/words=%d", n), func(b *testing.B) {
+			b.SetBytes(int64(8 * n))
+			for i := 0; i < b.N; i++ {
+				OnesCountRange(dst, 3, uint64(n*WordBits-5))
+			}
+		})
+	}
+}
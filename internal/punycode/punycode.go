@@ -0,0 +1,198 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package punycode implements the Punycode encoding of RFC 3492, in which
+// the Unicode labels of internationalized domain names are represented in
+// ASCII (following the prefix "xn--"). It neither maps nor validates labels
+// as IDNA does, i.e. it encodes and decodes the code points as they are.
+package punycode
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// the parameters of Punycode, see section 5 of RFC 3492
+const (
+	base        int32 = 36
+	tMin        int32 = 1
+	tMax        int32 = 26
+	skew        int32 = 38
+	damp        int32 = 700
+	initialBias int32 = 72
+	initialN    int32 = 128
+)
+
+// ErrInvalid is returned by Decode for input that is not valid Punycode.
+var ErrInvalid = errors.New("invalid punycode")
+
+// ErrOverflow is returned for labels whose encoding overflows.
+var ErrOverflow = errors.New("punycode overflow")
+
+// Encode returns the Punycode encoding of a label, without the "xn--"
+// prefix.
+func Encode(label string) (string, error) {
+	output := make([]byte, 0, len(label)+1)
+	var basic, remaining int32
+	for _, r := range label {
+		if r < utf8.RuneSelf {
+			basic++
+			output = append(output, byte(r))
+		} else {
+			remaining++
+		}
+	}
+	if basic > 0 {
+		output = append(output, '-')
+	}
+	n, bias, delta, handled := initialN, initialBias, int32(0), basic
+	for remaining > 0 {
+		// the smallest code point not handled yet
+		m := int32(math.MaxInt32)
+		for _, r := range label {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		d := int64(delta) + int64(m-n)*int64(handled+1)
+		if d > math.MaxInt32 {
+			return "", ErrOverflow
+		}
+		delta, n = int32(d), m
+		for _, r := range label {
+			if r < n {
+				if delta++; delta < 0 {
+					return "", ErrOverflow
+				}
+				continue
+			}
+			if r > n {
+				continue
+			}
+			q := delta
+			for k := base; ; k += base {
+				t := threshold(k, bias)
+				if q < t {
+					break
+				}
+				output = append(output, encodeDigit(t+(q-t)%(base-t)))
+				q = (q - t) / (base - t)
+			}
+			output = append(output, encodeDigit(q))
+			bias = adapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+			remaining--
+		}
+		delta++
+		n++
+	}
+	return string(output), nil
+}
+
+// Decode returns the label encoded in Punycode, given without the "xn--"
+// prefix.
+func Decode(encoded string) (string, error) {
+	var output []rune
+	pos := 0
+	if i := strings.LastIndexByte(encoded, '-'); i >= 0 {
+		for _, r := range encoded[:i] {
+			if r >= utf8.RuneSelf {
+				return "", ErrInvalid
+			}
+			output = append(output, r)
+		}
+		pos = i + 1
+	}
+	n, bias, i := initialN, initialBias, int32(0)
+	for pos < len(encoded) {
+		oldI, w := i, int32(1)
+		for k := base; ; k += base {
+			if pos == len(encoded) {
+				return "", ErrInvalid
+			}
+			digit, ok := decodeDigit(encoded[pos])
+			if !ok {
+				return "", ErrInvalid
+			}
+			pos++
+			if digit > (math.MaxInt32-i)/w {
+				return "", ErrOverflow
+			}
+			i += digit * w
+			t := threshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > math.MaxInt32/(base-t) {
+				return "", ErrOverflow
+			}
+			w *= base - t
+		}
+		x := int32(len(output) + 1)
+		bias = adapt(i-oldI, x, oldI == 0)
+		if i/x > math.MaxInt32-n {
+			return "", ErrOverflow
+		}
+		n += i / x
+		i %= x
+		if n > utf8.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return "", ErrInvalid
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = n
+		i++
+	}
+	return string(output), nil
+}
+
+// threshold returns the threshold t for the digit at position k.
+func threshold(k, bias int32) int32 {
+	switch t := k - bias; {
+	case t < tMin:
+		return tMin
+	case t > tMax:
+		return tMax
+	default:
+		return t
+	}
+}
+
+// adapt returns the bias following a code point, see section 6.1 of RFC
+// 3492.
+func adapt(delta, numPoints int32, first bool) int32 {
+	if first {
+		delta /= damp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := int32(0)
+	for delta > ((base-tMin)*tMax)/2 {
+		delta /= base - tMin
+		k += base
+	}
+	return k + (base-tMin+1)*delta/(delta+skew)
+}
+
+func encodeDigit(digit int32) byte {
+	if digit < 26 {
+		return byte('a' + digit)
+	}
+	return byte('0' + digit - 26)
+}
+
+func decodeDigit(c byte) (int32, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int32(c-'0') + 26, true
+	case c >= 'a' && c <= 'z':
+		return int32(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int32(c - 'A'), true
+	}
+	return 0, false
+}
@@ -0,0 +1,59 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package punycode
+
+import (
+	"strings"
+	"testing"
+)
+
+// samples of section 7.1 of RFC 3492 and of common domain labels
+var samples = []struct {
+	decoded string
+	encoded string
+}{
+	{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+	{"Pročprostěnemluvíčesky", "Proprostnemluvesky-uyb24dma41a"},
+	{"3年B組金八先生", "3B-ww4c5e180e575a65lsy2b"},
+	{"bücher", "bcher-kva"},
+	{"münchen", "mnchen-3ya"},
+	{"example", "example-"},
+	{"", ""},
+}
+
+func TestEncode(t *testing.T) {
+	for _, s := range samples {
+		encoded, err := Encode(s.decoded)
+		if err != nil {
+			t.Fatalf("%s: %s", s.decoded, err)
+		}
+		if encoded != s.encoded {
+			t.Errorf("%s: expected %s, got %s", s.decoded, s.encoded, encoded)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	for _, s := range samples {
+		decoded, err := Decode(s.encoded)
+		if err != nil {
+			t.Fatalf("%s: %s", s.encoded, err)
+		}
+		if decoded != s.decoded {
+			t.Errorf("%s: expected %s, got %s", s.encoded, s.decoded, decoded)
+		}
+	}
+	// the digits are case-insensitive
+	if decoded, err := Decode(strings.ToUpper("bcher-kva")); err != nil || decoded != "BüCHER" {
+		t.Errorf("unexpected decoding %q (%v)", decoded, err)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, encoded := range []string{"bcher-kv!", "ü-kva", "bcher-k", "99999999999", "zzzzzzzzzzzzzzzzzzzzzzzz"} {
+		if decoded, err := Decode(encoded); err == nil {
+			t.Errorf("%s: expected an error, got %q", encoded, decoded)
+		}
+	}
+}
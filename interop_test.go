@@ -0,0 +1,109 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// interopFixture describes a filter of testdata/interop written by another
+// implementation, named with its version by Generator, see generate.py there.
+type interopFixture struct {
+	File       string            `json:"file"`
+	Generator  string            `json:"generator"`
+	Gzip       bool              `json:"gzip"`
+	Values     string            `json:"values"`
+	Version    int               `json:"version"`
+	Capacity   uint64            `json:"capacity"`
+	FPP        float64           `json:"fpp"`
+	HashFuncs  uint64            `json:"hash_funcs"`
+	Bits       uint64            `json:"bits"`
+	Elements   uint64            `json:"elements"`
+	HashScheme int               `json:"hash_scheme"`
+	Comment    string            `json:"comment"`
+	Metadata   map[string]string `json:"metadata"`
+	Data       string            `json:"data"`
+}
+
+func TestInterop(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/interop/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixtures []interopFixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		path := filepath.Join("testdata/interop", fixture.File)
+		info, err := ReadHeaderFromFile(path)
+		if fixture.HashScheme != HashSchemeFNV1 {
+			// the loader names the scheme instead of giving wrong answers
+			var schemeErr *UnsupportedHashSchemeError
+			if !errors.As(err, &schemeErr) || schemeErr.Scheme != fixture.HashScheme {
+				t.Errorf("%s: expected an UnsupportedHashSchemeError for scheme %d, got %v", fixture.File, fixture.HashScheme, err)
+			}
+			if _, err := LoadFilter(path, fixture.Gzip); !errors.Is(err, ErrUnsupportedHashScheme) {
+				t.Errorf("%s: expected ErrUnsupportedHashScheme, got %v", fixture.File, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s (%s): %s", fixture.File, fixture.Generator, err)
+		}
+		if info.Version != fixture.Version || info.Capacity != fixture.Capacity || info.FalsePositiveProb != fixture.FPP ||
+			info.HashFuncs != fixture.HashFuncs || info.Bits != fixture.Bits || info.Elements != fixture.Elements ||
+			info.HashScheme != fixture.HashScheme {
+			t.Errorf("%s: unexpected header %+v", fixture.File, info)
+		}
+
+		filter, err := LoadFilter(path, fixture.Gzip)
+		if err != nil {
+			t.Fatalf("%s: %s", fixture.File, err)
+		}
+		if filter.NumElements() != fixture.Elements || string(filter.GetData()) != fixture.Data || filter.Comment() != fixture.Comment {
+			t.Errorf("%s: unexpected elements %d, data %q or comment %q", fixture.File, filter.NumElements(), filter.GetData(), filter.Comment())
+		}
+		for key, value := range fixture.Metadata {
+			if v, ok := filter.Metadata(key); !ok || v != value {
+				t.Errorf("%s: metadata %s is %q, expected %q", fixture.File, key, v, value)
+			}
+		}
+		checkInteropValues(t, filter, filepath.Join("testdata/interop", fixture.Values))
+	}
+}
+
+// checkInteropValues checks the values listed in a file of testdata/interop
+// as "+value" or "-value" against the filter.
+func checkInteropValues(t *testing.T, filter *BloomFilter, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var checked int
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if expected := line[0] == '+'; filter.Check([]byte(line[1:])) != expected {
+			t.Errorf("%s: Check(%q) is %t", path, line[1:], !expected)
+		}
+		checked++
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if checked == 0 {
+		t.Fatalf("%s: no values", path)
+	}
+}
@@ -4,44 +4,91 @@
 package bloom
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
 	gz "compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"time"
 )
 
 // LoadFromBytes reads a binary Bloom filter representation from a byte array
 // and returns a BloomFilter struct pointer based on it.
 // If 'gzip' is true, then compressed input will be expected.
-func LoadFromBytes(input []byte, gzip bool) (*BloomFilter, error) {
-	return LoadFromReader(bytes.NewReader(input), gzip)
+// The filter does not retain the input unless ZeroCopyData is given.
+func LoadFromBytes(input []byte, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
+	if !gzip {
+		opts = append(opts[:len(opts):len(opts)], withDataSource(input))
+	}
+	return LoadFromReader(bytes.NewReader(input), gzip, opts...)
 }
 
 // LoadFilter reads a binary Bloom filter representation from a file
 // and returns a BloomFilter struct pointer based on it.
 // If 'gzip' is true, then compressed input will be expected.
-func LoadFilter(path string, gzip bool) (*BloomFilter, error) {
+func LoadFilter(path string, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	return LoadFromReader(file, gzip)
+	return LoadFromReader(file, gzip, append(opts[:len(opts):len(opts)], withLogPath(path))...)
+}
+
+// LoadFromFS reads a binary Bloom filter representation from a file in a file
+// system, e.g. an embed.FS, and returns a BloomFilter struct pointer based on
+// it. If 'gzip' is true, then compressed input will be expected.
+func LoadFromFS(fsys fs.FS, path string, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
+	file, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadFromReader(file, gzip, append(opts[:len(opts):len(opts)], withLogPath(path))...)
+}
+
+// LoadFromSectionReader reads a binary Bloom filter representation from the n
+// bytes at offset off of r, e.g. a member of an uncompressed tar archive, and
+// returns a BloomFilter struct pointer based on it. The Data section extends
+// to the end of the section. If 'gzip' is true, then compressed input will be
+// expected.
+func LoadFromSectionReader(r io.ReaderAt, off, n int64, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
+	return LoadFromReader(io.NewSectionReader(r, off, n), gzip, opts...)
+}
+
+// LoadFromZip reads a binary Bloom filter representation from the file with
+// the given name in a ZIP archive, without extracting it, and returns a
+// BloomFilter struct pointer based on it. If 'gzip' is true, then compressed
+// input will be expected, regardless of the compression of the archive.
+func LoadFromZip(zr *zip.Reader, name string, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
+	return LoadFromFS(zr, name, gzip, opts...)
 }
 
 // LoadFromReader reads a binary Bloom filter representation from an io.Reader
 // and returns a BloomFilter struct pointer based on it.
 // If 'gzip' is true, then compressed input will be expected.
-func LoadFromReader(inReader io.Reader, gzip bool) (*BloomFilter, error) {
+func LoadFromReader(inReader io.Reader, gzip bool, opts ...LoadOption) (*BloomFilter, error) {
 	var err error
 	var reader io.Reader
-	var gzipReader *gz.Reader
+	var gzipReader *gzipMembersReader
 	var ioReader *bufio.Reader
 
+	lo := newLoadOptions(opts)
+	start := time.Now()
+	counter := &countingReader{r: inReader}
+	inReader = counter
 	if gzip {
-		gzipReader, err = gz.NewReader(inReader)
+		gzipReader, err = newGzipMembersReader(inReader, lo.ignoreTrailingGarbage)
 		if err != nil {
 			return nil, err
 		}
@@ -53,16 +100,33 @@ func LoadFromReader(inReader io.Reader, gzip bool) (*BloomFilter, error) {
 	}
 
 	var filter BloomFilter
-	if err = filter.Read(reader); err != nil {
+	if err = filter.Read(reader, opts...); err != nil {
 		return nil, err
 	}
+	if gzipReader != nil && gzipReader.ignoredTrailing {
+		lo.logger.Warn("ignored trailing data after gzip stream", lo.logArgs()...)
+	}
+	lo.logger.Debug("loaded filter", lo.logArgs(LogKeyBytes, counter.n, LogKeyGzip, gzip, LogKeyDuration, time.Since(start))...)
+
+	if lo.prefault {
+		filter.prefault(context.Background(), lo.progress)
+	}
 
 	return &filter, nil
 }
 
 // WriteFilter writes a binary Bloom filter representation for a given struct
-// to a file. If 'gzip' is true, then a compressed file will be written.
-func WriteFilter(filter *BloomFilter, path string, gzip bool) error {
+// to a file. If 'gzip' is true, then a compressed file will be written. An
+// error is returned unless the whole filter was written and synced to stable
+// storage, e.g. if the disk is full.
+func WriteFilter(filter *BloomFilter, path string, gzip bool, opts ...WriteOption) error {
+
+	// refuse early so that an existing file is not truncated
+	wo := newWriteOptions(opts)
+	if err := filter.checkDataSize(wo); err != nil {
+		return err
+	}
+	start := time.Now()
 
 	file, err := os.Create(path)
 
@@ -70,36 +134,103 @@ func WriteFilter(filter *BloomFilter, path string, gzip bool) error {
 		return err
 	}
 
-	defer file.Close()
+	n, err := writeFilterFile(file, file, filter, gzip, opts)
+	if err != nil {
+		return err
+	}
+	wo.logger.Debug("wrote filter", LogKeyPath, path, LogKeyBytes, n, LogKeyGzip, gzip, LogKeyDuration, time.Since(start))
 
-	file.Seek(0, 0)
+	return nil
+}
 
-	var writer io.Writer
-	var gzipWriter *gz.Writer
-	var ioWriter *bufio.Writer
+// writeFilterFile writes the filter to w, which writes to file, like
+// writeFilterTo, and then syncs and closes the file. It returns the number of
+// bytes written and the first error.
+func writeFilterFile(file *os.File, w io.Writer, filter *BloomFilter, gzip bool, opts []WriteOption) (int64, error) {
+	n, err := writeFilterTo(w, filter, gzip, opts)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
 
+// writeFilterTo writes the filter to w, compressed if gzip is true and
+// buffered otherwise, and returns the number of bytes written to w. The
+// errors of flushing the buffer or the compressor are returned as well, as
+// Write does not see the errors of w before.
+func writeFilterTo(w io.Writer, filter *BloomFilter, gzip bool, opts []WriteOption) (int64, error) {
+	counter := &countingWriter{w: w}
 	if gzip {
-		gzipWriter = gz.NewWriter(file)
-		defer gzipWriter.Close()
-		writer = gzipWriter
-	} else {
-		ioWriter = bufio.NewWriter(file)
-		writer = ioWriter
+		gzipWriter := gz.NewWriter(counter)
+		err := filter.Write(gzipWriter, opts...)
+		if closeErr := gzipWriter.Close(); err == nil {
+			err = closeErr
+		}
+		return counter.n, err
 	}
+	ioWriter := bufio.NewWriter(counter)
+	if err := filter.Write(ioWriter, opts...); err != nil {
+		return counter.n, err
+	}
+	return counter.n, ioWriter.Flush()
+}
 
-	err = filter.Write(writer)
-
+// LoadNewestFilter loads the newest valid Bloom filter from the files in dir
+// whose names match the given pattern (see filepath.Match). Files are ordered
+// by name, so the pattern should match names that sort chronologically, such
+// as filter-YYYYMMDDHH.bloom, unless the NewestByModTime option is given.
+// If the newest file cannot be loaded, the next older one is tried, and so on.
+// The path of the file that was loaded is returned along with the filter.
+func LoadNewestFilter(dir string, pattern string, gzip bool, opts ...LoadOption) (*BloomFilter, string, error) {
+	lo := newLoadOptions(opts)
+	paths, err := filepath.Glob(filepath.Join(dir, pattern))
 	if err != nil {
-		return err
+		return nil, "", err
 	}
-
-	if gzip {
-		gzipWriter.Flush()
+	if len(paths) == 0 {
+		return nil, "", fmt.Errorf("no files matching %s found in %s", pattern, dir)
+	}
+	if lo.byModTime {
+		modTimes := make(map[string]time.Time, len(paths))
+		for _, path := range paths {
+			if info, err := os.Stat(path); err == nil {
+				modTimes[path] = info.ModTime()
+			}
+		}
+		sort.SliceStable(paths, func(i, j int) bool {
+			return modTimes[paths[i]].After(modTimes[paths[j]])
+		})
 	} else {
-		ioWriter.Flush()
+		sort.Sort(sort.Reverse(sort.StringSlice(paths)))
 	}
+	for _, path := range paths {
+		filter, err := LoadFilter(path, gzip, opts...)
+		if err == nil {
+			return filter, path, nil
+		}
+		lo.logger.Warn("skipped filter", LogKeyPath, path, LogKeyError, err)
+		if lo.onSkipped != nil {
+			lo.onSkipped(path, err)
+		}
+	}
+	return nil, "", fmt.Errorf("no valid filter matching %s found in %s (tried %d files)", pattern, dir, len(paths))
+}
 
-	file.Sync()
-
-	return nil
+// FileDigest returns the hex-encoded SHA-256 digest and the size of the
+// content of the file at path.
+func FileDigest(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
 }
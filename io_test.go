@@ -4,10 +4,19 @@
 package bloom
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	gz "compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
+	"time"
 )
 
 func checkResults(t *testing.T, bf *BloomFilter) {
@@ -54,7 +63,7 @@ func TestFromReaderCorruptFile(t *testing.T) {
 }
 
 func testFromSerialized(t *testing.T, gzip bool) {
-	bf := Initialize(100, 0.0001)
+	bf := mustNew(100, 0.0001)
 	for _, v := range []string{"foo", "bar", "baz"} {
 		bf.Add([]byte(v))
 	}
@@ -64,7 +73,7 @@ func testFromSerialized(t *testing.T, gzip bool) {
 	}
 	defer os.Remove(tmpfile.Name())
 
-	err = WriteFilter(&bf, tmpfile.Name(), gzip)
+	err = WriteFilter(bf, tmpfile.Name(), gzip)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,6 +118,96 @@ func TestFromBytes(t *testing.T) {
 	checkResults(t, bf)
 }
 
+// serializedWithData returns a filter containing "foo" with the given data
+// in its binary representation, compressed if gzip is true.
+func serializedWithData(t *testing.T, data string, gzip bool) []byte {
+	filter := mustNew(1000, 0.01)
+	filter.Add([]byte("foo"))
+	filter.SetData([]byte(data))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if gzip {
+		var compressed bytes.Buffer
+		w := gz.NewWriter(&compressed)
+		w.Write(buf.Bytes())
+		w.Close()
+		return compressed.Bytes()
+	}
+	return buf.Bytes()
+}
+
+// overwrite sets all bytes of b, as a caller reusing its buffer would.
+func overwrite(b []byte) {
+	for i := range b {
+		b[i] = 0xff
+	}
+}
+
+func TestLoadFromBytesDoesNotRetainInput(t *testing.T) {
+	for _, gzip := range []bool{false, true} {
+		input := serializedWithData(t, "payload", gzip)
+		filter, err := LoadFromBytes(input, gzip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overwrite(input)
+		if string(filter.GetData()) != "payload" || !filter.Check([]byte("foo")) || filter.Check([]byte("bar")) {
+			t.Fatalf("gzip %v: filter changed with its input (data %q)", gzip, filter.GetData())
+		}
+	}
+}
+
+func TestReadOwnsData(t *testing.T) {
+	input := serializedWithData(t, "payload", false)
+	var filter BloomFilter
+	if err := filter.Read(bytes.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	overwrite(input)
+	if string(filter.GetData()) != "payload" || filter.Check([]byte("bar")) {
+		t.Fatalf("filter changed with its input (data %q)", filter.GetData())
+	}
+}
+
+func TestZeroCopyData(t *testing.T) {
+	input := serializedWithData(t, "payload", false)
+	filter, err := LoadFromBytes(input, false, ZeroCopyData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(filter.GetData()) != "payload" {
+		t.Fatalf("unexpected data %q", filter.GetData())
+	}
+	// only the Data section aliases the input
+	copy(input[len(input)-len("payload"):], "PAYLOAD")
+	if string(filter.GetData()) != "PAYLOAD" {
+		t.Fatalf("data does not alias the input: %q", filter.GetData())
+	}
+	if cap(filter.GetData()) != len("payload") {
+		t.Fatal("appending to the data could overwrite the input")
+	}
+	overwrite(input[:len(input)-len("payload")])
+	if !filter.Check([]byte("foo")) || filter.Check([]byte("bar")) {
+		t.Fatal("bit array aliases the input")
+	}
+
+	// compressed input is always copied
+	input = serializedWithData(t, "payload", true)
+	if filter, err = LoadFromBytes(input, true, ZeroCopyData()); err != nil {
+		t.Fatal(err)
+	}
+	overwrite(input)
+	if string(filter.GetData()) != "payload" {
+		t.Fatalf("compressed data aliases the input: %q", filter.GetData())
+	}
+
+	if _, err := LoadFromBytes(serializedWithData(t, "payload", false), false, ZeroCopyData(), MaxDataSize(3)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("expected ErrDataTooLarge, got %v", err)
+	}
+}
+
 func TestFromFile(t *testing.T) {
 	bf, err := LoadFilter("testdata/test.bloom", false)
 	if err != nil {
@@ -116,3 +215,221 @@ func TestFromFile(t *testing.T) {
 	}
 	checkResults(t, bf)
 }
+
+func TestFromTarMember(t *testing.T) {
+	f, err := os.Open("testdata/archive.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "test.bloom" {
+			continue
+		}
+		// the tar reader is positioned at the contents of the member
+		off, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bf, err := LoadFromSectionReader(f, off, hdr.Size, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResults(t, bf)
+		// the padding and the following members are not read as Data
+		if len(bf.Data) != 0 {
+			t.Fatalf("unexpected Data of %d bytes", len(bf.Data))
+		}
+		return
+	}
+}
+
+func TestFromZip(t *testing.T) {
+	zr, err := zip.OpenReader("testdata/archive.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, name := range []string{"test.bloom", "deflated/test.bloom"} {
+		bf, err := LoadFromZip(&zr.Reader, name, false)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		checkResults(t, bf)
+	}
+	if _, err := LoadFromZip(&zr.Reader, "missing.bloom", false); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDataSizeLimit(t *testing.T) {
+	bf := mustNew(100, 0.01)
+	bf.Data = bytes.Repeat([]byte("x"), 100)
+	if bf.DataSize() != 100 {
+		t.Fatalf("unexpected data size: %d", bf.DataSize())
+	}
+	if bf.Stats().DataSize != 100 {
+		t.Fatalf("unexpected data size in stats: %d", bf.Stats().DataSize)
+	}
+
+	var buf bytes.Buffer
+	if err := bf.Write(&buf, MaxDataSize(100)); err != nil {
+		t.Fatalf("writing data exactly at limit failed: %s", err)
+	}
+	serialized := buf.Bytes()
+	loaded, err := LoadFromBytes(serialized, false, MaxDataSize(100))
+	if err != nil {
+		t.Fatalf("reading data exactly at limit failed: %s", err)
+	}
+	if !bytes.Equal(loaded.Data, bf.Data) {
+		t.Fatal("data does not match")
+	}
+
+	buf.Reset()
+	if err := bf.Write(&buf, MaxDataSize(99)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("expected ErrDataTooLarge on write, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("nothing should be written if data is too large")
+	}
+	if _, err := LoadFromBytes(serialized, false, MaxDataSize(99)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("expected ErrDataTooLarge on read, got %v", err)
+	}
+	if _, err := LoadFromBytes(serialized, false, MaxDataSize(0)); err != nil {
+		t.Fatalf("disabling the limit should allow reading: %s", err)
+	}
+}
+
+func TestDataSizeLimitKeepsFile(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	defer os.Remove(tmpfile.Name())
+
+	bf := mustNew(100, 0.01)
+	bf.Add([]byte("foo"))
+	if err := WriteFilter(bf, tmpfile.Name(), false); err != nil {
+		t.Fatal(err)
+	}
+	bf.Data = bytes.Repeat([]byte("x"), 100)
+	if err := WriteFilter(bf, tmpfile.Name(), false, MaxDataSize(10)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("expected ErrDataTooLarge, got %v", err)
+	}
+	loaded, err := LoadFilter(tmpfile.Name(), false)
+	if err != nil {
+		t.Fatalf("existing file was damaged: %s", err)
+	}
+	if !loaded.Check([]byte("foo")) || len(loaded.Data) != 0 {
+		t.Fatal("existing file was modified")
+	}
+}
+
+func TestLoadNewestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bf := mustNew(100, 0.0001)
+	for _, v := range []string{"foo", "bar", "baz"} {
+		bf.Add([]byte(v))
+	}
+	if err := WriteFilter(bf, filepath.Join(dir, "filter-2021010100.bloom"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "filter-2021010101.bloom"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.bloom"), []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var skipped []string
+	loaded, path, err := LoadNewestFilter(dir, "filter-*.bloom", false,
+		OnSkippedFilter(func(path string, err error) {
+			skipped = append(skipped, filepath.Base(path))
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "filter-2021010100.bloom" {
+		t.Fatalf("unexpected filter loaded: %s", path)
+	}
+	if len(skipped) != 1 || skipped[0] != "filter-2021010101.bloom" {
+		t.Fatalf("unexpected skipped files: %v", skipped)
+	}
+	checkResults(t, loaded)
+
+	// by modification time, the corrupt file is the oldest one
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "filter-2021010100.bloom"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFilter(bf, filepath.Join(dir, "filter-2020010100.bloom"), false); err != nil {
+		t.Fatal(err)
+	}
+	_, path, err = LoadNewestFilter(dir, "filter-*.bloom", false, NewestByModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != "filter-2020010100.bloom" {
+		t.Fatalf("unexpected filter loaded: %s", path)
+	}
+
+	if _, _, err := LoadNewestFilter(dir, "other*.bloom", false); err == nil {
+		t.Fatal("loading without valid filters should fail")
+	}
+	if _, _, err := LoadNewestFilter(dir, "missing*.bloom", false); err == nil {
+		t.Fatal("loading without matching files should fail")
+	}
+}
+
+// failingWriter accepts the first size bytes and then fails, like a file on
+// a full disk.
+type failingWriter struct {
+	size int
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.size {
+		n := w.size
+		w.size = 0
+		return n, errDiskFull
+	}
+	w.size -= len(p)
+	return len(p), nil
+}
+
+func TestWriteFilterErrors(t *testing.T) {
+	filter := mustNew(100000, 0.01)
+	filter.Add([]byte("foo"))
+	filter.SetData([]byte("data"))
+	for _, gzip := range []bool{false, true} {
+		// the writes of the buffer and the compressor fail when flushed
+		// or closed, after Write returned
+		for _, size := range []int{0, 10, 60} {
+			if _, err := writeFilterTo(&failingWriter{size: size}, filter, gzip, nil); !errors.Is(err, errDiskFull) {
+				t.Fatalf("gzip %v, %d bytes: expected the error of the writer, got %v", gzip, size, err)
+			}
+		}
+	}
+
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full")
+	}
+	for _, gzip := range []bool{false, true} {
+		if err := WriteFilter(filter, "/dev/full", gzip); err == nil {
+			t.Fatalf("gzip %v: expected an error writing to a full device", gzip)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// reservedMetadataPrefix prefixes the metadata keys reserved for the library,
+// which JoinWithOptions handles like Join instead of merging them.
+const reservedMetadataPrefix = "bloom."
+
+// MetadataConflictPolicy decides which value JoinWithOptions keeps for a
+// metadata key that both filters have with different values.
+type MetadataConflictPolicy int
+
+const (
+	// ReceiverWins keeps the value of the receiver.
+	ReceiverWins MetadataConflictPolicy = iota
+	// OtherWins takes the value of the joined filter.
+	OtherWins
+	// ErrorOnConflict fails the join with a *MetadataConflictError.
+	ErrorOnConflict
+)
+
+// JoinCountMode decides how JoinWithOptions derives the element count.
+type JoinCountMode int
+
+const (
+	// SumCounts sums the counts like Join.
+	SumCounts JoinCountMode = iota
+	// SaturateCounts sums the counts like JoinSaturating.
+	SaturateCounts
+	// EstimateCount estimates the count from the joined bits like
+	// JoinEstimate.
+	EstimateCount
+)
+
+// ErrMetadataConflict is wrapped by the errors returned by JoinWithOptions
+// with ErrorOnConflict if the filters have conflicting metadata.
+var ErrMetadataConflict = errors.New("conflicting metadata")
+
+// MetadataConflictError lists the metadata keys that two filters joined with
+// ErrorOnConflict have with different values.
+type MetadataConflictError struct {
+	Keys []string
+}
+
+func (e *MetadataConflictError) Error() string {
+	return fmt.Sprintf("%s for keys %s", ErrMetadataConflict, strings.Join(e.Keys, ", "))
+}
+
+func (e *MetadataConflictError) Unwrap() error {
+	return ErrMetadataConflict
+}
+
+// JoinOption configures JoinWithOptions.
+type JoinOption func(*joinOptions)
+
+type joinOptions struct {
+	mergeData func(a, b []byte) []byte
+	conflicts MetadataConflictPolicy
+	count     JoinCountMode
+}
+
+// KeepReceiverData keeps the Data of the receiver and drops that of the
+// joined filter, like Join. This is the default.
+func KeepReceiverData() JoinOption {
+	return func(o *joinOptions) {
+		o.mergeData = nil
+	}
+}
+
+// KeepOtherData replaces the Data of the receiver with that of the joined
+// filter.
+func KeepOtherData() JoinOption {
+	return MergeData(func(a, b []byte) []byte {
+		return b
+	})
+}
+
+// ConcatenateData appends the Data of the joined filter to that of the
+// receiver, separated by sep unless either of them is empty.
+func ConcatenateData(sep []byte) JoinOption {
+	return MergeData(func(a, b []byte) []byte {
+		if len(a) == 0 {
+			return b
+		}
+		if len(b) == 0 {
+			return a
+		}
+		return bytes.Join([][]byte{a, b}, sep)
+	})
+}
+
+// MergeData sets the Data of the receiver to the result of fn, called with
+// the Data of the receiver (a) and of the joined filter (b), which it must
+// not modify.
+func MergeData(fn func(a, b []byte) []byte) JoinOption {
+	return func(o *joinOptions) {
+		o.mergeData = fn
+	}
+}
+
+// WithMetadataConflicts sets how metadata keys that both filters have with
+// different values are resolved (ReceiverWins by default).
+func WithMetadataConflicts(policy MetadataConflictPolicy) JoinOption {
+	return func(o *joinOptions) {
+		o.conflicts = policy
+	}
+}
+
+// WithJoinCount sets how the element count is derived (SumCounts by
+// default).
+func WithJoinCount(mode JoinCountMode) JoinOption {
+	return func(o *joinOptions) {
+		o.count = mode
+	}
+}
+
+// JoinReport describes how JoinWithOptions merged the Data and the metadata.
+type JoinReport struct {
+	// DataChanged is true if the Data of the receiver was replaced.
+	DataChanged bool
+	// AddedKeys are the metadata keys only the joined filter had, which were
+	// copied to the receiver.
+	AddedKeys []string
+	// Conflicts are the metadata keys both filters had with different
+	// values, resolved by the MetadataConflictPolicy.
+	Conflicts []string
+}
+
+// JoinWithOptions adds the items of another Bloom filter with identical
+// dimensions to the receiver like Join, and also merges the Data and the
+// metadata of both, where Join keeps those of the receiver only. The Data is
+// merged as configured with KeepReceiverData (the default), KeepOtherData,
+// ConcatenateData or MergeData. The metadata keys only the other filter has
+// are copied, and keys both have with different values are resolved by the
+// policy given with WithMetadataConflicts. Keys with the reserved "bloom."
+// prefix, which describe the filter itself, are handled like by Join. If the
+// filters cannot be joined, including due to a conflict with
+// ErrorOnConflict, an error is returned and the receiver is left unaltered.
+// The count is derived as given with WithJoinCount; with SumCounts,
+// ErrCountOverflow is returned if the sum of the counts would overflow, and
+// unlike by Join, the receiver is left unaltered.
+func (s *BloomFilter) JoinWithOptions(s2 *BloomFilter, opts ...JoinOption) (JoinReport, error) {
+	var o joinOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var report JoinReport
+	if err := s.checkDimensions(s2); err != nil {
+		return report, err
+	}
+	for _, key := range s2.MetadataKeys() {
+		if strings.HasPrefix(key, reservedMetadataPrefix) {
+			continue
+		}
+		v2, _ := s2.Metadata(key)
+		if v, ok := s.Metadata(key); !ok {
+			report.AddedKeys = append(report.AddedKeys, key)
+		} else if v != v2 {
+			report.Conflicts = append(report.Conflicts, key)
+		}
+	}
+	if len(report.Conflicts) > 0 && o.conflicts == ErrorOnConflict {
+		return report, &MetadataConflictError{Keys: report.Conflicts}
+	}
+	if o.count == SumCounts && s.NumElements()+s2.NumElements() < s.NumElements() {
+		return report, ErrCountOverflow
+	}
+
+	if o.mergeData != nil {
+		data := s.GetData()
+		merged := o.mergeData(data, s2.GetData())
+		if report.DataChanged = !bytes.Equal(merged, data); report.DataChanged {
+			s.SetData(merged)
+		}
+	}
+	for _, key := range report.AddedKeys {
+		v, _ := s2.Metadata(key)
+		s.SetMetadata(key, v)
+	}
+	if o.conflicts == OtherWins {
+		for _, key := range report.Conflicts {
+			v, _ := s2.Metadata(key)
+			s.SetMetadata(key, v)
+		}
+	}
+
+	if err := s.joinBits(s2); err != nil {
+		return report, err
+	}
+	switch o.count {
+	case EstimateCount:
+		s.SetNumElements(s.EstimatedNumElements())
+		s.SetMetadata(MetadataKeyCount, "estimated")
+		return report, nil
+	case SaturateCounts:
+		if s.NumElements()+s2.NumElements() < s.NumElements() {
+			s.SetNumElements(math.MaxUint64)
+			s.SetMetadata(MetadataKeyCount, "estimated")
+			return report, nil
+		}
+	}
+	s.SetNumElements(s.NumElements() + s2.NumElements())
+	if s2.CountIsEstimate() {
+		s.SetMetadata(MetadataKeyCount, "estimated")
+	}
+	return report, nil
+}
@@ -0,0 +1,160 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// joinPair returns two filters with data and metadata, the first of which
+// contains "foo" and the second "bar".
+func joinPair() (*BloomFilter, *BloomFilter) {
+	a := mustNew(1000, 0.01)
+	a.Add([]byte("foo"))
+	a.SetData([]byte("feed-a"))
+	a.SetMetadata("source", "feed-a")
+	a.SetMetadata("owner", "team")
+	a.SetMetadata(MetadataKeyProducer, "producer-a")
+	b := mustNew(1000, 0.01)
+	b.Add([]byte("bar"))
+	b.SetData([]byte("feed-b"))
+	b.SetMetadata("source", "feed-b")
+	b.SetMetadata("owner", "team")
+	b.SetMetadata("manifest", "feed-b.json")
+	b.SetMetadata(MetadataKeyProducer, "producer-b")
+	return a, b
+}
+
+func TestJoinWithOptionsData(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		opts     []JoinOption
+		expected string
+	}{
+		{"default", nil, "feed-a"},
+		{"receiver", []JoinOption{KeepOtherData(), KeepReceiverData()}, "feed-a"},
+		{"other", []JoinOption{KeepOtherData()}, "feed-b"},
+		{"concatenate", []JoinOption{ConcatenateData([]byte("\n"))}, "feed-a\nfeed-b"},
+		{"callback", []JoinOption{MergeData(func(a, b []byte) []byte {
+			return append(append([]byte("["), b...), append([]byte("]"), a...)...)
+		})}, "[feed-b]feed-a"},
+	} {
+		a, b := joinPair()
+		report, err := a.JoinWithOptions(b, c.opts...)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if !bytes.Equal(a.GetData(), []byte(c.expected)) {
+			t.Fatalf("%s: expected data %q, got %q", c.name, c.expected, a.GetData())
+		}
+		if report.DataChanged != (c.expected != "feed-a") {
+			t.Fatalf("%s: unexpected report %+v", c.name, report)
+		}
+		if !a.Check([]byte("foo")) || !a.Check([]byte("bar")) || a.NumElements() != 2 {
+			t.Fatalf("%s: values not joined", c.name)
+		}
+		if !bytes.Equal(b.GetData(), []byte("feed-b")) {
+			t.Fatalf("%s: the data of the joined filter was modified", c.name)
+		}
+	}
+
+	// concatenating with an empty side adds no separator
+	a, b := mustNew(10, 0.01), mustNew(10, 0.01)
+	b.SetData([]byte("only"))
+	if _, err := a.JoinWithOptions(b, ConcatenateData([]byte(", "))); err != nil {
+		t.Fatal(err)
+	}
+	if string(a.GetData()) != "only" {
+		t.Fatalf("unexpected data %q", a.GetData())
+	}
+}
+
+func TestJoinWithOptionsMetadata(t *testing.T) {
+	for _, c := range []struct {
+		policy MetadataConflictPolicy
+		source string
+	}{
+		{ReceiverWins, "feed-a"},
+		{OtherWins, "feed-b"},
+	} {
+		a, b := joinPair()
+		report, err := a.JoinWithOptions(b, WithMetadataConflicts(c.policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report.AddedKeys, []string{"manifest"}) || !reflect.DeepEqual(report.Conflicts, []string{"source"}) {
+			t.Fatalf("unexpected report %+v", report)
+		}
+		if v, _ := a.Metadata("source"); v != c.source {
+			t.Fatalf("policy %d: expected source %q, got %q", c.policy, c.source, v)
+		}
+		if v, _ := a.Metadata("manifest"); v != "feed-b.json" {
+			t.Fatalf("metadata only the joined filter had was not copied: %q", v)
+		}
+		// reserved keys are handled like by Join
+		if v, _ := a.Metadata(MetadataKeyProducer); v != "producer-a" {
+			t.Fatalf("the producer of the receiver was replaced by %q", v)
+		}
+		if producers := a.JoinedProducers(); !reflect.DeepEqual(producers, []string{"producer-a", "producer-b"}) {
+			t.Fatalf("unexpected joined producers %v", producers)
+		}
+	}
+}
+
+func TestJoinWithOptionsConflict(t *testing.T) {
+	a, b := joinPair()
+	var before bytes.Buffer
+	if err := a.Write(&before); err != nil {
+		t.Fatal(err)
+	}
+	_, err := a.JoinWithOptions(b, WithMetadataConflicts(ErrorOnConflict), KeepOtherData())
+	var conflict *MetadataConflictError
+	if !errors.As(err, &conflict) || !errors.Is(err, ErrMetadataConflict) || !reflect.DeepEqual(conflict.Keys, []string{"source"}) {
+		t.Fatalf("expected a conflict for source, got %v", err)
+	}
+	var after bytes.Buffer
+	if err := a.Write(&after); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Fatal("the receiver was modified despite the conflict")
+	}
+
+	// equal values do not conflict
+	b.SetMetadata("source", "feed-a")
+	if _, err := a.JoinWithOptions(b, WithMetadataConflicts(ErrorOnConflict)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJoinWithOptionsCount(t *testing.T) {
+	a, b := joinPair()
+	a.SetNumElements(math.MaxUint64)
+	if _, err := a.JoinWithOptions(b, KeepOtherData()); !errors.Is(err, ErrCountOverflow) || a.NumElements() != math.MaxUint64 {
+		t.Fatalf("expected ErrCountOverflow, got %v", err)
+	}
+	// the receiver is left unaltered
+	if a.Check([]byte("bar")) || string(a.GetData()) != "feed-a" {
+		t.Fatal("expected the receiver to be unaltered after an overflow")
+	}
+	if _, ok := a.Metadata("manifest"); ok {
+		t.Fatal("expected no metadata to be copied after an overflow")
+	}
+	a, b = joinPair()
+	a.SetNumElements(math.MaxUint64)
+	if _, err := a.JoinWithOptions(b, WithJoinCount(SaturateCounts)); err != nil || !a.CountIsEstimate() {
+		t.Fatalf("expected a saturated count, got %v", err)
+	}
+	a, b = joinPair()
+	if _, err := a.JoinWithOptions(b, WithJoinCount(EstimateCount)); err != nil || !a.CountIsEstimate() || a.NumElements() != a.EstimatedNumElements() {
+		t.Fatalf("expected an estimated count, got %v", err)
+	}
+	if _, err := a.JoinWithOptions(mustNew(10, 0.01)); err == nil {
+		t.Fatal("expected an error for filters of different dimensions")
+	}
+}
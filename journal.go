@@ -0,0 +1,248 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrJournalCorrupt is returned by OpenJournaledFilter if an entry of the
+// journal is damaged other than by a crash while the last entry was written.
+var ErrJournalCorrupt = errors.New("journal is corrupt")
+
+// ErrJournalMismatch is returned by OpenJournaledFilter if the journal was
+// written for a filter with other dimensions.
+var ErrJournalMismatch = errors.New("journal does not belong to the filter")
+
+// journalMagic starts a journal file, followed by the number of bits and hash
+// functions of the filter and the CRC-32 of the header.
+var journalMagic = [4]byte{'B', 'L', 'J', 1}
+
+const (
+	journalHeaderSize = 4 + 8 + 8 + 4
+	// journalEntrySize is the size of an entry: the length of the payload,
+	// the payload (the hash of a value, see Hash) and the CRC-32 of both.
+	journalEntrySize    = 4 + journalPayloadSize + 4
+	journalPayloadSize  = 8
+	maxJournalEntrySize = 1 << 20
+)
+
+// JournaledFilter records the values added to a filter in a write-ahead
+// journal before adding them, so that a service adding values continuously
+// and writing the filter only occasionally (see Checkpoint) does not lose the
+// values added since when it crashes. Opening the filter again replays the
+// journal.
+//
+// Add writes each entry to the journal file before it returns, so that it
+// survives a crash of the process. To survive a crash of the operating system
+// as well, Sync must be called before an addition is considered durable.
+//
+// Like a BloomFilter, a JournaledFilter is not safe for concurrent use.
+type JournaledFilter struct {
+	filter   *BloomFilter
+	path     string
+	gzip     bool
+	journal  *os.File
+	replayed int
+	// size is the size of the journal up to the last complete entry, and
+	// err the error of a failed write that could not be undone
+	size int64
+	err  error
+}
+
+// OpenJournaledFilter loads the filter at path and replays the entries of the
+// journal at journalPath, which is created if it does not exist. Remaining
+// data too short for an entry, i.e. a final entry that was only partially
+// written by a crash during Add, is ignored and removed from the journal. Any
+// other damaged entry makes it fail with ErrJournalCorrupt, leaving the
+// journal unchanged.
+func OpenJournaledFilter(path, journalPath string, gzip bool, opts ...LoadOption) (*JournaledFilter, error) {
+	filter, err := LoadFilter(path, gzip, opts...)
+	if err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(journalPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f := &JournaledFilter{filter: filter, path: path, gzip: gzip, journal: journal}
+	if err = f.replay(); err != nil {
+		journal.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// replay adds the entries of the journal to the filter, truncates the journal
+// after the last complete entry and positions it for appending.
+func (f *JournaledFilter) replay() error {
+	data, err := ioutil.ReadAll(f.journal)
+	if err != nil {
+		return err
+	}
+	header := f.header()
+	if len(data) < journalHeaderSize {
+		// a new journal, or one whose header was not written completely
+		return f.truncate(header)
+	}
+	if string(data[:4]) != string(journalMagic[:]) ||
+		crc32.ChecksumIEEE(data[:journalHeaderSize-4]) != binary.LittleEndian.Uint32(data[journalHeaderSize-4:]) {
+		return fmt.Errorf("%w: invalid header", ErrJournalCorrupt)
+	}
+	if string(data[:journalHeaderSize]) != string(header) {
+		return ErrJournalMismatch
+	}
+	offset := journalHeaderSize
+	fingerprint := make([]uint64, f.filter.k)
+	for offset < len(data) {
+		if len(data)-offset < journalEntrySize {
+			// only the last entry can have been written partially by a
+			// crash during Add
+			break
+		}
+		payload, n, ok := readJournalEntry(data[offset:])
+		if !ok {
+			return fmt.Errorf("%w: damaged entry at offset %d", ErrJournalCorrupt, offset)
+		}
+		if len(payload) == journalPayloadSize {
+			f.filter.FingerprintHash(binary.LittleEndian.Uint64(payload), fingerprint)
+			f.filter.AddFingerprint(fingerprint)
+			f.replayed++
+		}
+		offset += n
+	}
+	if err := f.journal.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	f.size = int64(offset)
+	_, err = f.journal.Seek(f.size, io.SeekStart)
+	return err
+}
+
+// readJournalEntry returns the payload of the entry at the start of data and
+// its size. If the entry extends beyond data or is damaged, ok is false.
+func readJournalEntry(data []byte) (payload []byte, n int, ok bool) {
+	if len(data) < 4 {
+		return nil, 0, false
+	}
+	length := int(binary.LittleEndian.Uint32(data))
+	if length > maxJournalEntrySize || 4+length+4 > len(data) {
+		return nil, 0, false
+	}
+	n = 4 + length + 4
+	if crc32.ChecksumIEEE(data[:4+length]) != binary.LittleEndian.Uint32(data[4+length:]) {
+		return nil, n, false
+	}
+	return data[4 : 4+length], n, true
+}
+
+// header returns the header of a journal for the filter.
+func (f *JournaledFilter) header() []byte {
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic[:])
+	binary.LittleEndian.PutUint64(header[4:], f.filter.m)
+	binary.LittleEndian.PutUint64(header[12:], f.filter.k)
+	binary.LittleEndian.PutUint32(header[20:], crc32.ChecksumIEEE(header[:20]))
+	return header
+}
+
+// truncate replaces the contents of the journal by the header.
+func (f *JournaledFilter) truncate(header []byte) error {
+	if err := f.journal.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.journal.WriteAt(header, 0); err != nil {
+		return err
+	}
+	f.size = int64(len(header))
+	_, err := f.journal.Seek(f.size, io.SeekStart)
+	return err
+}
+
+// Filter returns the underlying filter, which must not be modified directly,
+// as such changes are not journaled.
+func (f *JournaledFilter) Filter() *BloomFilter {
+	return f.filter
+}
+
+// Replayed returns the number of journal entries added to the filter when it
+// was opened.
+func (f *JournaledFilter) Replayed() int {
+	return f.replayed
+}
+
+// Add appends the value to the journal and then adds it to the filter. If the
+// journal cannot be written, the filter is left unchanged and the error is
+// returned. Values rejected by the filter due to their length are not
+// journaled, and an error wrapping ErrValueTooLarge is returned for them.
+// A partially written entry is removed from the journal, and if that fails,
+// all further additions fail with its error.
+func (f *JournaledFilter) Add(value []byte) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.filter.rejects(value) {
+		return f.filter.valueTooLarge(value)
+	}
+	var entry [journalEntrySize]byte
+	binary.LittleEndian.PutUint32(entry[:], journalPayloadSize)
+	binary.LittleEndian.PutUint64(entry[4:], f.filter.Hash(value))
+	binary.LittleEndian.PutUint32(entry[4+journalPayloadSize:], crc32.ChecksumIEEE(entry[:4+journalPayloadSize]))
+	n, err := f.journal.Write(entry[:])
+	if err == nil && n < len(entry) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return f.undoWrite(err)
+	}
+	f.size += int64(n)
+	f.filter.add(value)
+	return nil
+}
+
+// undoWrite removes a partially written entry after the given error, so that
+// further entries are not appended to it.
+func (f *JournaledFilter) undoWrite(err error) error {
+	if terr := f.journal.Truncate(f.size); terr != nil {
+		f.err = fmt.Errorf("journal damaged by a failed write (%v): %w", err, terr)
+		return f.err
+	}
+	if _, serr := f.journal.Seek(f.size, io.SeekStart); serr != nil {
+		f.err = fmt.Errorf("journal damaged by a failed write (%v): %w", err, serr)
+		return f.err
+	}
+	return err
+}
+
+// Check returns true if the value may be in the filter.
+func (f *JournaledFilter) Check(value []byte) bool {
+	return f.filter.Check(value)
+}
+
+// Sync commits the journal to stable storage.
+func (f *JournaledFilter) Sync() error {
+	return f.journal.Sync()
+}
+
+// Checkpoint writes the filter to its file, replacing it atomically and
+// durably, and then clears the journal. A crash in between leaves entries in
+// the journal that are contained in the filter, which are replayed without
+// effect.
+func (f *JournaledFilter) Checkpoint(opts ...WriteOption) error {
+	if err := writeFilterAtomically(f.filter, f.path, f.gzip, opts...); err != nil {
+		return err
+	}
+	return f.truncate(f.header())
+}
+
+// Close closes the journal without writing the filter.
+func (f *JournaledFilter) Close() error {
+	return f.journal.Close()
+}
@@ -0,0 +1,203 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func journalTestDir(t *testing.T) (string, string, string) {
+	dir, err := ioutil.TempDir("", "journaltest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "test.bloom")
+	if err := WriteFilter(mustNew(10000, 0.0001), path, false); err != nil {
+		t.Fatal(err)
+	}
+	return dir, path, filepath.Join(dir, "test.journal")
+}
+
+func mustOpenJournaled(t *testing.T, path, journalPath string) *JournaledFilter {
+	f, err := OpenJournaledFilter(path, journalPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestJournaledFilter(t *testing.T) {
+	_, path, journalPath := journalTestDir(t)
+	f := mustOpenJournaled(t, path, journalPath)
+	for i := 0; i < 100; i++ {
+		if err := f.Add([]byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a crash without a checkpoint
+	f.Close()
+
+	f = mustOpenJournaled(t, path, journalPath)
+	if f.Replayed() != 100 || f.Filter().NumElements() != 100 {
+		t.Fatalf("expected 100 replayed entries, got %d (%d elements)", f.Replayed(), f.Filter().NumElements())
+	}
+	for i := 0; i < 100; i++ {
+		if !f.Check([]byte(fmt.Sprintf("value-%d", i))) {
+			t.Fatalf("value-%d lost", i)
+		}
+	}
+	if err := f.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(journalPath); err != nil || stat.Size() != journalHeaderSize {
+		t.Fatalf("journal not cleared by the checkpoint: %v, %v", stat, err)
+	}
+	f.Add([]byte("after"))
+	f.Close()
+
+	f = mustOpenJournaled(t, path, journalPath)
+	defer f.Close()
+	if f.Replayed() != 1 || f.Filter().NumElements() != 101 || !f.Check([]byte("value-42")) || !f.Check([]byte("after")) {
+		t.Fatalf("unexpected state after the checkpoint: %d replayed, %d elements", f.Replayed(), f.Filter().NumElements())
+	}
+}
+
+func TestJournaledFilterTornEntries(t *testing.T) {
+	dir, path, journalPath := journalTestDir(t)
+	filterData, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := mustOpenJournaled(t, path, journalPath)
+	const values = 20
+	// acknowledged[i] is the journal size once value i was added
+	var acknowledged []int64
+	for i := 0; i < values; i++ {
+		if err := f.Add([]byte(fmt.Sprintf("value-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+		stat, err := os.Stat(journalPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		acknowledged = append(acknowledged, stat.Size())
+	}
+	f.Close()
+	journal, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// crash at every byte offset of the journal
+	crashPath := filepath.Join(dir, "crash.bloom")
+	crashJournalPath := filepath.Join(dir, "crash.journal")
+	for size := 0; size <= len(journal); size++ {
+		if err := ioutil.WriteFile(crashPath, filterData, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(crashJournalPath, journal[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := OpenJournaledFilter(crashPath, crashJournalPath, false)
+		if err != nil {
+			t.Fatalf("journal of %d bytes: %v", size, err)
+		}
+		for i, end := range acknowledged {
+			if end <= int64(size) && !f.Check([]byte(fmt.Sprintf("value-%d", i))) {
+				t.Fatalf("journal of %d bytes: acknowledged value-%d lost", size, i)
+			}
+		}
+		// the torn entry is removed, so that further entries can be read
+		if err := f.Add([]byte("next")); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		f, err = OpenJournaledFilter(crashPath, crashJournalPath, false)
+		if err != nil {
+			t.Fatalf("journal of %d bytes, reopened: %v", size, err)
+		}
+		if !f.Check([]byte("next")) {
+			t.Fatalf("journal of %d bytes: value added after reopening lost", size)
+		}
+		f.Close()
+	}
+}
+
+func TestJournaledFilterErrors(t *testing.T) {
+	dir, path, journalPath := journalTestDir(t)
+	f := mustOpenJournaled(t, path, journalPath)
+	for i := 0; i < 3; i++ {
+		f.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	f.Close()
+	journal, err := ioutil.ReadFile(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a damaged entry followed by others was not torn by a crash
+	damaged := append([]byte(nil), journal...)
+	damaged[journalHeaderSize+journalEntrySize+5] ^= 1
+	if err := ioutil.WriteFile(journalPath, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournaledFilter(path, journalPath, false); !errors.Is(err, ErrJournalCorrupt) {
+		t.Fatalf("expected ErrJournalCorrupt, got %v", err)
+	}
+
+	// so is a damaged length field, even of the last entry, and the journal
+	// is left unchanged
+	for _, entry := range []int{1, 2} {
+		damaged := append([]byte(nil), journal...)
+		damaged[journalHeaderSize+entry*journalEntrySize+1] = 0xff
+		if err := ioutil.WriteFile(journalPath, damaged, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := OpenJournaledFilter(path, journalPath, false); !errors.Is(err, ErrJournalCorrupt) {
+			t.Fatalf("entry %d: expected ErrJournalCorrupt, got %v", entry, err)
+		}
+		if unchanged, err := ioutil.ReadFile(journalPath); err != nil || !bytes.Equal(unchanged, damaged) {
+			t.Fatalf("entry %d: the corrupt journal was modified", entry)
+		}
+	}
+
+	otherPath := filepath.Join(dir, "other.bloom")
+	if err := WriteFilter(mustNew(100, 0.01), otherPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(journalPath, journal, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournaledFilter(otherPath, journalPath, false); !errors.Is(err, ErrJournalMismatch) {
+		t.Fatalf("expected ErrJournalMismatch, got %v", err)
+	}
+
+	filter := mustNew(100, 0.01)
+	filter.SetMaxValueLength(4, RejectValues)
+	if err := WriteFilter(filter, otherPath, false); err != nil {
+		t.Fatal(err)
+	}
+	f = mustOpenJournaled(t, otherPath, filepath.Join(dir, "other.journal"))
+	defer f.Close()
+	if err := f.Add([]byte("too long")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+
+	// a failed write that cannot be undone fails all further additions
+	f.journal.Close()
+	err = f.Add([]byte("foo"))
+	if err == nil || f.Add([]byte("bar")) != err {
+		t.Fatalf("expected the error of the failed write to persist, got %v", err)
+	}
+	if f.Check([]byte("foo")) {
+		t.Fatal("value added although it was not journaled")
+	}
+}
@@ -0,0 +1,166 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// FormatRegion is the range of bytes of a field or section in the binary
+// representation of a specific filter.
+type FormatRegion struct {
+	// Name is the name of the field as given by DescribeFormat.
+	Name string `json:"name"`
+	// Offset is the offset of the region in bytes, or -1 if it depends on
+	// the size of an earlier region that is not known.
+	Offset int64 `json:"offset"`
+	// Length is the size of the region in bytes, or -1 if it is not known.
+	Length int64 `json:"length"`
+}
+
+// End returns the offset of the byte following the region, or -1 if it is
+// not known.
+func (r FormatRegion) End() int64 {
+	if r.Offset < 0 || r.Length < 0 {
+		return -1
+	}
+	return r.Offset + r.Length
+}
+
+// FormatLayout gives the regions of the uncompressed binary representation of
+// a specific filter in the order they are stored, i.e. the header fields, the
+// comment region, the bit array, the metadata section and the Data section of
+// its version. There are no checksum regions, as the format carries no
+// checksums (see DescribeFormat).
+type FormatLayout struct {
+	Version int            `json:"version"`
+	Regions []FormatRegion `json:"regions"`
+}
+
+// Region returns the region with the given name, e.g. "bits", and false if
+// the version has no such region.
+func (l FormatLayout) Region(name string) (FormatRegion, bool) {
+	for _, r := range l.Regions {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return FormatRegion{}, false
+}
+
+// Size returns the size of the representation in bytes, or -1 if it is not
+// known.
+func (l FormatLayout) Size() int64 {
+	if len(l.Regions) == 0 {
+		return -1
+	}
+	return l.Regions[len(l.Regions)-1].End()
+}
+
+// Layout returns the layout of the filter described by the header. The header
+// determines the regions up to the bit array and, in version 2, the length
+// field of the metadata section. The size of the metadata and of the Data are
+// not known from the header, so the regions following them have an offset or
+// length of -1; ReadLayout determines them from the whole representation.
+func (info FilterInfo) Layout() FormatLayout {
+	l := FormatLayout{Version: info.Version}
+	add := func(name string, offset, length int64) {
+		l.Regions = append(l.Regions, FormatRegion{Name: name, Offset: offset, Length: length})
+	}
+	add("flags", FormatFlagsOffset, 8)
+	add("n", FormatCapacityOffset, 8)
+	add("p", FormatFPPOffset, 8)
+	add("k", FormatHashFuncsOffset, 8)
+	add("m", FormatNumBitsOffset, 8)
+	add("N", FormatCountOffset, 8)
+	bits := int64(FormatHeaderSize)
+	if info.Version == FormatVersion2 {
+		add("comment", FormatCommentOffset, int64(info.CommentSize))
+		bits += int64(info.CommentSize)
+	}
+	add("bits", bits, int64(numWords(info.Bits))*FormatWordSize)
+	next := bits + int64(numWords(info.Bits))*FormatWordSize
+	if info.Version == FormatVersion2 {
+		add("metadata_length", next, FormatLengthSize)
+		add("metadata", next+FormatLengthSize, -1)
+		next = -1
+	}
+	add("data", next, -1)
+	return l
+}
+
+// resolve sets the size of the metadata section, if any, and of the Data
+// section of the layout.
+func (l FormatLayout) resolve(metadataLength, dataLength int64) FormatLayout {
+	regions := append([]FormatRegion(nil), l.Regions...)
+	for i := range regions {
+		switch regions[i].Name {
+		case "metadata":
+			regions[i].Length = metadataLength
+		case "data":
+			regions[i].Offset = regions[i-1].End()
+			regions[i].Length = dataLength
+		}
+	}
+	l.Regions = regions
+	return l
+}
+
+// ReadLayout reads the binary representation of a filter, which may be
+// gzip-compressed, to the end and returns its complete layout, with the
+// offsets referring to the uncompressed representation.
+func ReadLayout(input io.Reader) (FormatLayout, error) {
+	input, err := decompressHeader(input)
+	if err != nil {
+		return FormatLayout{}, err
+	}
+	info, err := readHeader(input)
+	if err != nil {
+		return FormatLayout{}, err
+	}
+	l := info.Layout()
+	bits, _ := l.Region("bits")
+	if _, err := io.CopyN(ioutil.Discard, input, bits.Length); err != nil {
+		return FormatLayout{}, fmt.Errorf("reading bit array: %w", err)
+	}
+	var metadataLength int64
+	if info.Version == FormatVersion2 {
+		bs8 := make([]byte, FormatLengthSize)
+		if _, err := io.ReadFull(input, bs8); err != nil {
+			return FormatLayout{}, fmt.Errorf("reading metadata length: %w", err)
+		}
+		length := binary.LittleEndian.Uint64(bs8)
+		if length > maxMetadataSize {
+			return FormatLayout{}, fmt.Errorf("metadata section is too large (%d bytes, maximum is %d)", length, maxMetadataSize)
+		}
+		metadataLength = int64(length)
+		if _, err := io.CopyN(ioutil.Discard, input, metadataLength); err != nil {
+			return FormatLayout{}, fmt.Errorf("reading metadata: %w", err)
+		}
+	}
+	dataLength, err := io.Copy(ioutil.Discard, input)
+	if err != nil {
+		return FormatLayout{}, fmt.Errorf("reading data: %w", err)
+	}
+	return l.resolve(metadataLength, dataLength), nil
+}
+
+// ReadLayoutFromFile reads the layout of the filter stored in a file, which
+// may be gzip-compressed.
+func ReadLayoutFromFile(path string) (FormatLayout, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatLayout{}, err
+	}
+	defer file.Close()
+	l, err := ReadLayout(file)
+	if err != nil {
+		return FormatLayout{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return l, nil
+}
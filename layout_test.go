@@ -0,0 +1,145 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// checkLayout checks the regions of the layout against the filter by slicing
+// its serialized representation buf at the reported offsets.
+func checkLayout(t *testing.T, l FormatLayout, filter *BloomFilter, buf []byte) {
+	t.Helper()
+	region := func(name string) []byte {
+		r, ok := l.Region(name)
+		if !ok {
+			t.Fatalf("no region %s in %+v", name, l)
+		}
+		if r.Offset < 0 || r.Length < 0 || r.End() > int64(len(buf)) {
+			t.Fatalf("region %+v out of bounds", r)
+		}
+		return buf[r.Offset:r.End()]
+	}
+	uint64At := func(name string) uint64 {
+		return binary.LittleEndian.Uint64(region(name))
+	}
+	if int(uint64At("flags")&FormatVersionMask) != l.Version {
+		t.Fatalf("version %d does not match the flags", l.Version)
+	}
+	if uint64At("n") != filter.n || math.Float64frombits(uint64At("p")) != filter.p || uint64At("k") != filter.k ||
+		uint64At("m") != filter.m || uint64At("N") != filter.NumElements() {
+		t.Fatal("header fields do not match the filter")
+	}
+	bits := region("bits")
+	if len(bits) != len(filter.v)*FormatWordSize {
+		t.Fatalf("bit array of %d bytes for %d words", len(bits), len(filter.v))
+	}
+	for i, w := range filter.v {
+		if binary.LittleEndian.Uint64(bits[i*FormatWordSize:]) != w {
+			t.Fatalf("word %d does not match", i)
+		}
+	}
+	if !bytes.Equal(region("data"), filter.GetData()) {
+		t.Fatalf("data %q does not match", region("data"))
+	}
+	if l.Size() != int64(len(buf)) {
+		t.Fatalf("size %d, expected %d", l.Size(), len(buf))
+	}
+	if l.Version == FormatVersion2 {
+		if decodeComment(region("comment")) != filter.Comment() {
+			t.Fatalf("comment %q does not match", region("comment"))
+		}
+		if int(uint64At("metadata_length")) != len(region("metadata")) {
+			t.Fatal("metadata length does not match")
+		}
+		meta, err := decodeMetadata(region("metadata"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(meta, filter.meta) {
+			t.Fatalf("metadata %v does not match %v", meta, filter.meta)
+		}
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, capacity := range testCapacities {
+		v1 := mustNew(capacity, 0.01)
+		v1.Add([]byte("foo"))
+		v1.SetData([]byte("some data"))
+
+		v2 := mustNew(capacity, 0.01)
+		v2.Add([]byte("bar"))
+		v2.SetData([]byte("more data"))
+		v2.SetMetadata("source", "feed")
+
+		for _, c := range []struct {
+			filter  *BloomFilter
+			version int
+			opts    []WriteOption
+		}{
+			{v1, FormatVersion1, nil},
+			{v2, FormatVersion2, nil},
+			{v2, FormatVersion2, []WriteOption{WithComment("layout")}},
+		} {
+			buf := serializeFilter(t, c.filter, false, c.opts...)
+			// the comment written with WithComment is that of the filter read
+			var read BloomFilter
+			if err := read.Read(bytes.NewReader(buf)); err != nil {
+				t.Fatal(err)
+			}
+
+			l, err := ReadLayout(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Version != c.version {
+				t.Fatalf("expected version %d, got %d", c.version, l.Version)
+			}
+			checkLayout(t, l, &read, buf)
+
+			// the regions known from the header are those of the whole input
+			info, err := ReadHeader(bytes.NewReader(buf))
+			if err != nil {
+				t.Fatal(err)
+			}
+			partial := info.Layout()
+			if len(partial.Regions) != len(l.Regions) || partial.Size() != -1 {
+				t.Fatalf("unexpected layout from the header %+v", partial)
+			}
+			for i, r := range partial.Regions {
+				if r.Offset >= 0 && r.Offset != l.Regions[i].Offset || r.Length >= 0 && r.Length != l.Regions[i].Length {
+					t.Fatalf("region %+v from the header does not match %+v", r, l.Regions[i])
+				}
+			}
+			if bits, _ := partial.Region("bits"); bits.End() < 0 {
+				t.Fatal("the bit array is not known from the header")
+			}
+
+			// offsets refer to the uncompressed representation
+			gzipped, err := ReadLayout(bytes.NewReader(serializeFilter(t, c.filter, true, c.opts...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gzipped, l) {
+				t.Fatalf("layout of the compressed filter %+v differs from %+v", gzipped, l)
+			}
+		}
+	}
+}
+
+func TestReadLayoutTruncated(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	filter.SetMetadata("source", "feed")
+	buf := mustWrite(t, filter)
+	for _, size := range []int{FormatHeaderSize - 1, FormatHeaderSize + 8, len(buf) - 1} {
+		if _, err := ReadLayout(bytes.NewReader(buf[:size])); err == nil {
+			t.Fatalf("expected an error for %d of %d bytes", size, len(buf))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Normalizer transforms a value before it is added to a Bloom filter. If a
+// Normalizer returns nil, the value is skipped.
+type Normalizer func(value []byte) []byte
+
+type lineWriter struct {
+	filter      *BloomFilter
+	normalizers []Normalizer
+	buf         []byte
+	closed      bool
+}
+
+// LineWriter returns an io.WriteCloser that adds each newline-terminated line
+// written to it to the Bloom filter. Lines may be split across several calls
+// to Write; incomplete lines are buffered until their terminating newline
+// arrives. Close adds a final unterminated line, if any. The normalizers
+// configured with WithLineNormalizers and then the given normalizers are
+// applied to each line in order before it is added.
+// The returned writer is not safe for concurrent use.
+func (s *BloomFilter) LineWriter(normalizers ...Normalizer) io.WriteCloser {
+	return &lineWriter{
+		filter:      s,
+		normalizers: append(append([]Normalizer(nil), s.lineNormalizers...), normalizers...),
+	}
+}
+
+// textFileWriter returns a LineWriter for the lines of a text file, which
+// strips trailing CRs and skips empty lines before applying the configured
+// normalizers and then the given ones, so that normalizers see the same
+// values for files with LF and CRLF line endings.
+func (s *BloomFilter) textFileWriter(normalizers ...Normalizer) io.WriteCloser {
+	pre := []Normalizer{stripCR, skipEmpty}
+	return &lineWriter{
+		filter:      s,
+		normalizers: append(append(pre, s.lineNormalizers...), normalizers...),
+	}
+}
+
+// WithLineNormalizers configures normalizers that are applied to the lines
+// added with LineWriter and NewFilterFromTextFile, in order. They are not
+// applied by Add and Check, nor stored with the filter.
+func WithLineNormalizers(normalizers ...Normalizer) Option {
+	return func(s *BloomFilter) {
+		s.lineNormalizers = append(s.lineNormalizers, normalizers...)
+	}
+}
+
+func (w *lineWriter) add(line []byte) {
+	for _, normalize := range w.normalizers {
+		line = normalize(line)
+		if line == nil {
+			return
+		}
+	}
+	w.filter.Add(line)
+}
+
+// Write adds all complete lines in p (and in previously buffered data) to the
+// filter.
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed line writer")
+	}
+	w.buf = append(w.buf, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(w.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.add(w.buf[start : start+i])
+		start += i + 1
+	}
+	// keep only the unterminated remainder, reusing the buffer
+	w.buf = w.buf[:copy(w.buf, w.buf[start:])]
+	return len(p), nil
+}
+
+// Close adds the final unterminated line, if any, to the filter.
+func (w *lineWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if len(w.buf) > 0 {
+		w.add(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestLineWriterSplitWrites(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	w := filter.LineWriter()
+	for _, chunk := range []string{"fo", "o\nb", "ar", "\n", "baz"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if filter.Check([]byte("baz")) {
+		t.Fatal("unterminated line added before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, filter)
+	if filter.N != 3 {
+		t.Fatalf("unexpected number of elements: %d", filter.N)
+	}
+	for _, v := range []string{"fo", "o", "b", "ar"} {
+		if filter.Check([]byte(v)) {
+			t.Fatalf("partial value %s found in filter", v)
+		}
+	}
+}
+
+func TestLineWriterMultiLineWrite(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	w := filter.LineWriter()
+	n, err := w.Write([]byte("foo\nbar\nbaz\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 12 {
+		t.Fatalf("unexpected number of bytes written: %d", n)
+	}
+	checkResults(t, filter)
+	w.Close()
+	if filter.N != 3 {
+		t.Fatalf("unexpected number of elements: %d", filter.N)
+	}
+	if _, err := w.Write([]byte("qux\n")); err == nil {
+		t.Fatal("write after close should fail")
+	}
+}
+
+func TestLineWriterNormalizers(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	w := filter.LineWriter(bytes.ToLower, func(v []byte) []byte {
+		if len(v) == 0 {
+			return nil
+		}
+		return v
+	})
+	io.WriteString(w, "FOO\n\nBar\nbaz")
+	w.Close()
+	checkResults(t, filter)
+	if filter.N != 3 {
+		t.Fatalf("unexpected number of elements: %d", filter.N)
+	}
+}
+
+func ExampleBloomFilter_LineWriter() {
+	filter := mustNew(1000, 0.001)
+	f, err := os.Open("testdata/test-input.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	w := filter.LineWriter()
+	if _, err := io.Copy(w, f); err != nil {
+		panic(err)
+	}
+	w.Close()
+	fmt.Println(filter.Check([]byte("foo")), filter.Check([]byte("qux")))
+	// Output: true false
+}
@@ -0,0 +1,98 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLocked is returned by LockFile if the lock is held by another process,
+// or another FileLock of this process, and was not released in time.
+var ErrLocked = errors.New("file is locked")
+
+// LockFileSuffix is appended to the path of a file to name its lock file.
+const LockFileSuffix = ".lock"
+
+// lockPollInterval is the interval at which LockFile tries to take a lock
+// held by another process again.
+const lockPollInterval = 50 * time.Millisecond
+
+// FileLock is an exclusive advisory lock of a file, e.g. held by a process
+// while it loads, modifies and writes a filter, so that processes taking the
+// lock do not overwrite each other's changes. The lock is taken on a separate
+// lock file, which is not affected by replacing the locked file (e.g. by
+// WriteFilter or Autosaver), and released when the process exits. On
+// platforms without file locking, locks are not exclusive.
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile takes the lock of the file at path, using the lock file named by
+// appending LockFileSuffix, which is created if it does not exist and left in
+// place. If the lock is held, LockFile retries until wait has passed, and then
+// returns an error wrapping ErrLocked, naming the process holding the lock if
+// it is known. The process ID of the holder is written to the lock file.
+func LockFile(path string, wait time.Duration) (*FileLock, error) {
+	lockPath := path + LockFileSuffix
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			file.Close()
+			if pid := lockHolder(lockPath); pid > 0 {
+				return nil, fmt.Errorf("%w: %s is held by process %d", ErrLocked, lockPath, pid)
+			}
+			return nil, fmt.Errorf("%w: %s is held by another process", ErrLocked, lockPath)
+		}
+		time.Sleep(lockPollInterval)
+	}
+	// the process ID is informational, so failing to record it is ignored
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &FileLock{file: file}, nil
+}
+
+// lockHolder returns the process ID recorded in a lock file, or 0 if it
+// cannot be read.
+func lockHolder(lockPath string) int {
+	data, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	// the process ID is cleared first, as it cannot be once the lock is
+	// released
+	l.file.Truncate(0)
+	if err := unlock(l.file); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
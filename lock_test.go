@@ -0,0 +1,115 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func lockTestPath(t *testing.T) string {
+	if runtime.GOOS == "plan9" || runtime.GOOS == "js" {
+		t.Skip("file locking is not supported")
+	}
+	dir, err := ioutil.TempDir("", "locktest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "test.bloom")
+}
+
+func TestLockFile(t *testing.T) {
+	path := lockTestPath(t)
+	lock, err := LockFile(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LockFile(path, 100*time.Millisecond)
+	if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), fmt.Sprintf("process %d", os.Getpid())) {
+		t.Fatalf("expected ErrLocked naming this process, got %v", err)
+	}
+
+	// a waiting LockFile takes the lock once it is released
+	released := make(chan struct{})
+	go func(lock *FileLock) {
+		time.Sleep(100 * time.Millisecond)
+		close(released)
+		lock.Unlock()
+	}(lock)
+	lock, err = LockFile(path, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatal("lock taken before it was released")
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLockFileContention(t *testing.T) {
+	path := lockTestPath(t)
+	if err := WriteFilter(mustNew(1000, 0.001), path, false); err != nil {
+		t.Fatal(err)
+	}
+	// each goroutine adds its values in a load-modify-write cycle, which
+	// would lose the values of others without the lock
+	const workers, rounds = 4, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				lock, err := LockFile(path, time.Minute)
+				if err != nil {
+					errs <- err
+					return
+				}
+				filter, err := LoadFilter(path, false)
+				if err == nil {
+					filter.Add([]byte(fmt.Sprintf("%d-%d", w, r)))
+					err = WriteFilter(filter, path, false)
+				}
+				lock.Unlock()
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	filter, err := LoadFilter(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for w := 0; w < workers; w++ {
+		for r := 0; r < rounds; r++ {
+			if !filter.Check([]byte(fmt.Sprintf("%d-%d", w, r))) {
+				t.Fatalf("value %d-%d lost", w, r)
+			}
+		}
+	}
+	if filter.NumElements() != workers*rounds {
+		t.Fatalf("expected %d elements, got %d", workers*rounds, filter.NumElements())
+	}
+}
@@ -0,0 +1,100 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "io"
+
+// Logger receives structured events of loading and writing filters. The
+// arguments following the message are alternating keys (see LogKeyPath etc.)
+// and values. The method set matches that of *slog.Logger, which can be
+// passed directly.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// The keys of the values of events passed to a Logger.
+const (
+	// LogKeyPath is the key of the path of a file.
+	LogKeyPath = "path"
+	// LogKeyBytes is the key of the number of bytes read or written.
+	LogKeyBytes = "bytes"
+	// LogKeyDuration is the key of the time.Duration of an operation.
+	LogKeyDuration = "duration"
+	// LogKeyDigest is the key of the digest of a filter.
+	LogKeyDigest = "digest"
+	// LogKeyGzip is the key of whether data is gzip-compressed.
+	LogKeyGzip = "gzip"
+	// LogKeyCount is the key of a number of elements.
+	LogKeyCount = "count"
+	// LogKeyError is the key of an error.
+	LogKeyError = "error"
+)
+
+// NopLogger is a Logger discarding all events, the default.
+type NopLogger struct{}
+
+// Debug discards the event.
+func (NopLogger) Debug(msg string, args ...interface{}) {}
+
+// Info discards the event.
+func (NopLogger) Info(msg string, args ...interface{}) {}
+
+// Warn discards the event.
+func (NopLogger) Warn(msg string, args ...interface{}) {}
+
+type loggerOption struct {
+	logger Logger
+}
+
+func (o loggerOption) applyLoad(lo *loadOptions) {
+	lo.logger = o.logger
+}
+
+func (o loggerOption) applyWrite(wo *writeOptions) {
+	wo.logger = o.logger
+}
+
+// WithLogger makes a load or write operation report events to the logger,
+// e.g. a *slog.Logger: files loaded and written (debug), skipped candidates of
+// LoadNewestFilter and ignored trailing data (warn), and so on. A nil logger
+// discards them, like NopLogger.
+func WithLogger(logger Logger) LoadWriteOption {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+	return loggerOption{logger}
+}
+
+// withLogPath makes a load operation report the path of the file read.
+func withLogPath(path string) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.path = path
+	})
+}
+
+// countingReader counts the bytes read from a reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to a writer.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
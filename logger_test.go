@@ -0,0 +1,107 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build go1.21
+// +build go1.21
+
+package bloom
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// recordingHandler is a slog.Handler recording all records.
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler      { return h }
+
+// event returns the attributes of the first record with the given level and
+// message.
+func (h *recordingHandler) event(t *testing.T, level slog.Level, msg string) map[string]slog.Value {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.records {
+		if r.Level == level && r.Message == msg {
+			attrs := make(map[string]slog.Value)
+			r.Attrs(func(a slog.Attr) bool {
+				attrs[a.Key] = a.Value
+				return true
+			})
+			return attrs
+		}
+	}
+	t.Fatalf("no %s event %q", level, msg)
+	return nil
+}
+
+func TestLoggerGzipDetected(t *testing.T) {
+	handler := &recordingHandler{}
+	f, err := os.Open("testdata/misp-event.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ExtractFromJSON(f, "Event.Attribute.1.data", WithLogger(slog.New(handler))); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := handler.event(t, slog.LevelDebug, "detected gzip compression"); attrs[LogKeyBytes].Int64() == 0 {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	attrs := handler.event(t, slog.LevelDebug, "loaded filter")
+	if !attrs[LogKeyGzip].Bool() || attrs[LogKeyBytes].Int64() == 0 || attrs[LogKeyDuration].Kind() != slog.KindDuration {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+}
+
+func TestLoggerFailedReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	handler := &recordingHandler{}
+	logger := WithLogger(slog.New(handler))
+
+	older := filepath.Join(dir, "filter-1.bloom")
+	if err := WriteFilter(mustNew(100, 0.01), older, false, Reproducible(), logger); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := handler.event(t, slog.LevelDebug, "wrote filter"); attrs[LogKeyPath].String() != older || attrs[LogKeyBytes].Int64() == 0 {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	handler.event(t, slog.LevelDebug, "writing estimated element count")
+
+	newer := filepath.Join(dir, "filter-2.bloom")
+	if err := ioutil.WriteFile(newer, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, path, err := LoadNewestFilter(dir, "filter-*.bloom", false, logger); err != nil || path != older {
+		t.Fatalf("expected %s, got %s (%v)", older, path, err)
+	}
+	attrs := handler.event(t, slog.LevelWarn, "skipped filter")
+	if attrs[LogKeyPath].String() != newer || attrs[LogKeyError].Any() == nil {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+	if attrs := handler.event(t, slog.LevelDebug, "loaded filter"); attrs[LogKeyPath].String() != older {
+		t.Fatalf("unexpected attributes %v", attrs)
+	}
+}
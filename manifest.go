@@ -0,0 +1,280 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The compressions of the filters listed in a manifest.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// DefaultManifestTimeout limits the requests made by LoadManifest to fetch
+// filters from http(s) URLs, including reading the responses, unless another
+// client is given with WithHTTPClient. Interrupted downloads of uncompressed
+// filters are resumed.
+const DefaultManifestTimeout = 5 * time.Minute
+
+// ManifestEntry describes a filter listed in a manifest.
+type ManifestEntry struct {
+	// Name identifies the filter, e.g. in match reports.
+	Name string `json:"name"`
+	// Source is the path of the filter, relative to the directory of the
+	// manifest unless absolute, or an http(s) URL.
+	Source string `json:"source"`
+	// Compression is CompressionNone (the default) or CompressionGzip.
+	Compression string `json:"compression,omitempty"`
+	// SHA256 is the expected hex-encoded SHA-256 digest of the source as
+	// stored, i.e. before decompression. It is only verified if given.
+	SHA256 string `json:"sha256,omitempty"`
+	// Tags are arbitrary labels of the filter.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Manifest lists filters to be loaded together, see LoadManifest.
+type Manifest struct {
+	Filters []ManifestEntry `json:"filters"`
+}
+
+// NamedFilter is a filter loaded from a manifest entry. If the entry could not
+// be loaded, Filter is nil and Err describes the problem.
+type NamedFilter struct {
+	ManifestEntry
+	Filter *BloomFilter
+	Err    error
+}
+
+// ManifestOption configures LoadManifest.
+type ManifestOption func(*manifestOptions)
+
+type manifestOptions struct {
+	strict     bool
+	client     *http.Client
+	retry      RetryPolicy
+	loadOption []LoadOption
+}
+
+// StrictManifest makes LoadManifest fail if any of the filters cannot be
+// loaded or does not match its digest, instead of recording the error in the
+// entry.
+func StrictManifest() ManifestOption {
+	return func(o *manifestOptions) {
+		o.strict = true
+	}
+}
+
+// WithHTTPClient sets the client used to fetch filters from http(s) URLs (a
+// client with a timeout of DefaultManifestTimeout by default).
+func WithHTTPClient(client *http.Client) ManifestOption {
+	return func(o *manifestOptions) {
+		o.client = client
+	}
+}
+
+// WithManifestRetry sets the policy for retrying the loads of filters from
+// http(s) URLs that fail transiently (a single attempt by default).
+func WithManifestRetry(policy RetryPolicy) ManifestOption {
+	return func(o *manifestOptions) {
+		o.retry = policy
+	}
+}
+
+// WithManifestLoadOptions passes options to the loading of each filter.
+func WithManifestLoadOptions(opts ...LoadOption) ManifestOption {
+	return func(o *manifestOptions) {
+		o.loadOption = append(o.loadOption, opts...)
+	}
+}
+
+// ReadManifest reads a JSON manifest file. Entries are checked for names,
+// sources and compressions, names must be unique. YAML manifests are not
+// supported, as the module does not depend on a YAML parser; they need to be
+// converted to JSON.
+func ReadManifest(path string) (Manifest, error) {
+	var manifest Manifest
+	encoded, err := ioutil.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	if err := json.Unmarshal(encoded, &manifest); err != nil {
+		return manifest, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	names := make(map[string]bool, len(manifest.Filters))
+	for i, entry := range manifest.Filters {
+		switch {
+		case entry.Name == "":
+			return manifest, fmt.Errorf("invalid manifest %s: filter %d has no name", path, i)
+		case names[entry.Name]:
+			return manifest, fmt.Errorf("invalid manifest %s: duplicate filter name %q", path, entry.Name)
+		case entry.Source == "":
+			return manifest, fmt.Errorf("invalid manifest %s: filter %q has no source", path, entry.Name)
+		}
+		switch entry.Compression {
+		case "", CompressionNone, CompressionGzip:
+		default:
+			return manifest, fmt.Errorf("invalid manifest %s: unknown compression %q of filter %q", path, entry.Compression, entry.Name)
+		}
+		names[entry.Name] = true
+	}
+	return manifest, nil
+}
+
+// LoadManifest loads the filters listed in a JSON manifest file, e.g.
+//
+//	{"filters": [
+//	  {"name": "local", "source": "local.bloom"},
+//	  {"name": "remote", "source": "https://example.com/remote.bloom.gz",
+//	   "compression": "gzip", "sha256": "9f86d0...", "tags": ["feed"]}
+//	]}
+//
+// The filters are returned in the order of the manifest. If a filter cannot
+// be loaded or does not match its digest, its error is recorded in the entry
+// and the remaining filters are loaded, unless StrictManifest is given. An
+// error is returned if the manifest itself is invalid.
+func LoadManifest(path string, opts ...ManifestOption) ([]NamedFilter, error) {
+	o := newManifestOptions(opts)
+	manifest, err := ReadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	filters := make([]NamedFilter, len(manifest.Filters))
+	for i, entry := range manifest.Filters {
+		filters[i].ManifestEntry = entry
+		filters[i].Filter, filters[i].Err = loadManifestEntry(dir, entry, &o)
+		if filters[i].Err != nil && o.strict {
+			return nil, fmt.Errorf("filter %q: %w", entry.Name, filters[i].Err)
+		}
+	}
+	return filters, nil
+}
+
+func newManifestOptions(opts []ManifestOption) manifestOptions {
+	o := manifestOptions{client: &http.Client{Timeout: DefaultManifestTimeout}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func loadManifestEntry(dir string, entry ManifestEntry, o *manifestOptions) (*BloomFilter, error) {
+	if strings.HasPrefix(entry.Source, "http://") || strings.HasPrefix(entry.Source, "https://") {
+		var filter *BloomFilter
+		err := o.retry.Do(context.Background(), func(ctx context.Context) error {
+			var err error
+			filter, err = fetchManifestEntry(ctx, entry, o)
+			return err
+		})
+		return filter, err
+	}
+	path := entry.Source
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readManifestEntry(file, entry, o)
+}
+
+// fetchManifestEntry makes a single attempt to load a filter from an http(s)
+// URL.
+func fetchManifestEntry(ctx context.Context, entry ManifestEntry, o *manifestOptions) (*BloomFilter, error) {
+	req, err := http.NewRequest(http.MethodGet, entry.Source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %w", entry.Source, statusError(resp))
+	}
+	if entry.Compression != CompressionGzip && resp.Header.Get("Accept-Ranges") == "bytes" {
+		return loadResumable(ctx, o.client, entry, resp, o.loadOption)
+	}
+	defer resp.Body.Close()
+	return readManifestEntry(resp.Body, entry, o)
+}
+
+// readManifestEntry reads a filter from its source, verifying its digest.
+func readManifestEntry(source io.Reader, entry ManifestEntry, o *manifestOptions) (*BloomFilter, error) {
+	var reader io.Reader = source
+	var digest hash.Hash
+	if entry.SHA256 != "" {
+		digest = sha256.New()
+		reader = io.TeeReader(source, digest)
+	}
+	filter, err := LoadFromReader(reader, entry.Compression == CompressionGzip, o.loadOption...)
+	if err != nil {
+		return nil, err
+	}
+	if digest != nil {
+		// the digest covers the source as stored, including any trailing
+		// bytes not consumed by the decompressor
+		if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(hex.EncodeToString(digest.Sum(nil)), entry.SHA256) {
+			return nil, fmt.Errorf("%s is corrupt (SHA256 digest mismatch)", entry.Source)
+		}
+	}
+	return filter, nil
+}
+
+// maxResumes is the maximum number of range requests with which an
+// interrupted download of a filter is resumed.
+const maxResumes = 5
+
+// loadResumable loads an uncompressed filter from the response of a server
+// supporting range requests, resuming the download using a ResumableLoader if
+// it is interrupted.
+func loadResumable(ctx context.Context, client *http.Client, entry ManifestEntry, resp *http.Response, opts []LoadOption) (*BloomFilter, error) {
+	var filter BloomFilter
+	loader := NewResumableLoader(&filter, opts...)
+	if entry.SHA256 != "" {
+		loader.VerifySHA256(entry.SHA256)
+	}
+	for resumes := 0; ; resumes++ {
+		_, done, err := loader.Feed(resp.Body)
+		resp.Body.Close()
+		if done {
+			return &filter, nil
+		}
+		if loader.Err() != nil || resumes == maxResumes {
+			return nil, fmt.Errorf("fetching %s: %w", entry.Source, err)
+		}
+		req, err := http.NewRequest(http.MethodGet, entry.Source, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", loader.Offset()))
+		if resp, err = client.Do(req.WithContext(ctx)); err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return nil, fmt.Errorf("resuming %s at byte %d: %w", entry.Source, loader.Offset(), statusError(resp))
+		}
+	}
+}
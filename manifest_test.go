@@ -0,0 +1,139 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	gz "compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestManifest(t *testing.T, dir string, manifest Manifest) string {
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "feeds.json")
+	if err := ioutil.WriteFile(path, encoded, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local, localValues := GenerateExampleFilter(1000, 0.001, 100)
+	if err := WriteFilter(local, filepath.Join(dir, "local.bloom"), false); err != nil {
+		t.Fatal(err)
+	}
+	localBytes, err := ioutil.ReadFile(filepath.Join(dir, "local.bloom"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(localBytes)
+
+	remote, remoteValues := GenerateExampleFilter(1000, 0.001, 100)
+	var compressed bytes.Buffer
+	gzipWriter := gz.NewWriter(&compressed)
+	if err := remote.Write(gzipWriter); err != nil {
+		t.Fatal(err)
+	}
+	gzipWriter.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/remote.bloom.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(compressed.Bytes())
+	}))
+	defer server.Close()
+
+	path := writeTestManifest(t, dir, Manifest{Filters: []ManifestEntry{
+		{Name: "local", Source: "local.bloom", SHA256: hex.EncodeToString(sum[:]), Tags: []string{"a"}},
+		{Name: "remote", Source: server.URL + "/remote.bloom.gz", Compression: CompressionGzip},
+		{Name: "bad-digest", Source: "local.bloom", SHA256: strings.Repeat("00", sha256.Size)},
+		{Name: "missing", Source: server.URL + "/missing.bloom"},
+	}})
+
+	filters, err := LoadManifest(path, WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 4 {
+		t.Fatalf("expected 4 filters, got %d", len(filters))
+	}
+	for i, name := range []string{"local", "remote", "bad-digest", "missing"} {
+		if filters[i].Name != name {
+			t.Fatalf("expected filter %d to be %s, got %s", i, name, filters[i].Name)
+		}
+	}
+	for i, values := range [][][]byte{localValues, remoteValues} {
+		if filters[i].Err != nil {
+			t.Fatalf("filter %s: %s", filters[i].Name, filters[i].Err)
+		}
+		for _, value := range values {
+			if !filters[i].Filter.Check(value) {
+				t.Fatalf("value not found in filter %s", filters[i].Name)
+			}
+		}
+	}
+	if len(filters[0].Tags) != 1 || filters[0].Tags[0] != "a" {
+		t.Fatalf("unexpected tags %v", filters[0].Tags)
+	}
+	if filters[2].Filter != nil || filters[2].Err == nil || !strings.Contains(filters[2].Err.Error(), "digest mismatch") {
+		t.Fatalf("expected a digest mismatch, got %v", filters[2].Err)
+	}
+	if filters[3].Filter != nil || filters[3].Err == nil || !strings.Contains(filters[3].Err.Error(), "404") {
+		t.Fatalf("expected a fetch error, got %v", filters[3].Err)
+	}
+
+	_, err = LoadManifest(path, WithHTTPClient(server.Client()), StrictManifest())
+	if err == nil || !strings.Contains(err.Error(), "bad-digest") {
+		t.Fatalf("expected the strict manifest to fail at the bad digest, got %v", err)
+	}
+}
+
+func TestReadManifestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, entries := range [][]ManifestEntry{
+		{{Source: "a.bloom"}},
+		{{Name: "a"}},
+		{{Name: "a", Source: "a.bloom"}, {Name: "a", Source: "b.bloom"}},
+		{{Name: "a", Source: "a.bloom", Compression: "zstd"}},
+	} {
+		path := writeTestManifest(t, dir, Manifest{Filters: entries})
+		if _, err := LoadManifest(path); err == nil {
+			t.Fatalf("expected manifest %v to be rejected", entries)
+		}
+	}
+}
+
+func TestManifestClientTimeout(t *testing.T) {
+	if o := newManifestOptions(nil); o.client == http.DefaultClient || o.client.Timeout != DefaultManifestTimeout {
+		t.Fatalf("expected a default client with a timeout of %v", DefaultManifestTimeout)
+	}
+	client := &http.Client{}
+	if o := newManifestOptions([]ManifestOption{WithHTTPClient(client)}); o.client != client {
+		t.Fatal("expected the given client")
+	}
+}
@@ -0,0 +1,162 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Metadata keys used by this package. Keys with the "bloom." prefix are
+// reserved for the library.
+const (
+	// MetadataKeyCount describes how the element count N was obtained. It is
+	// either "exact" (the default if absent) or "estimated".
+	MetadataKeyCount = "bloom.count"
+)
+
+// maxMetadataSize is the maximum size in bytes of the serialized metadata
+// section that Read accepts.
+const maxMetadataSize = 16 << 20
+
+// maxEmbeddedSize is the maximum size in bytes of the filters stored in the
+// metadata of another filter, such as tombstones, leaving room for their keys
+// and the other metadata entries.
+const maxEmbeddedSize = maxMetadataSize - 64<<10
+
+// ErrMetadataTooLarge is returned for filters that are too large to be stored
+// in the metadata of another filter, and for metadata sections that are too
+// large to be written.
+var ErrMetadataTooLarge = errors.New("metadata exceeds maximum size")
+
+// embeddedCapacity returns the largest capacity of a filter with FP
+// probability p that takes at most size bytes of metadata, or 0 if there is
+// none.
+func embeddedCapacity(size int64, p float64) uint64 {
+	if size <= 0 {
+		return 0
+	}
+	plan, err := PlanForSize(uint64(size), p)
+	if err != nil {
+		return 0
+	}
+	return plan.Capacity
+}
+
+// Metadata returns the metadata value stored for the given key, and whether
+// the key is present.
+func (s *BloomFilter) Metadata(key string) (string, bool) {
+	v, ok := s.meta[key]
+	return v, ok
+}
+
+// SetMetadata stores a metadata key/value pair with the filter. Filters with
+// metadata are serialized in version 2 of the file format.
+func (s *BloomFilter) SetMetadata(key, value string) {
+	if s.meta == nil {
+		s.meta = make(map[string]string)
+	}
+	s.meta[key] = value
+	s.changes++
+}
+
+// DeleteMetadata removes the metadata value for the given key.
+func (s *BloomFilter) DeleteMetadata(key string) {
+	if _, ok := s.meta[key]; ok {
+		delete(s.meta, key)
+		s.changes++
+	}
+}
+
+// MetadataKeys returns the keys of all metadata stored with the filter, in
+// sorted order.
+func (s *BloomFilter) MetadataKeys() []string {
+	return sortedKeys(s.meta)
+}
+
+// CountIsEstimate returns true if the element count N is an estimate rather
+// than the number of values added to the filter (and possibly joined filters).
+func (s *BloomFilter) CountIsEstimate() bool {
+	return s.meta[MetadataKeyCount] == "estimated"
+}
+
+func sortedKeys(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for k := range meta {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeMetadata serializes a metadata map with the keys in sorted order:
+// for each entry, the key length, the key, the value length and the value,
+// with lengths as little-endian 64-bit integers.
+func encodeMetadata(meta map[string]string) []byte {
+	size := 0
+	for k, v := range meta {
+		size += 16 + len(k) + len(v)
+	}
+	buf := make([]byte, 0, size)
+	bs8 := make([]byte, 8)
+	for _, k := range sortedKeys(meta) {
+		binary.LittleEndian.PutUint64(bs8, uint64(len(k)))
+		buf = append(buf, bs8...)
+		buf = append(buf, k...)
+		binary.LittleEndian.PutUint64(bs8, uint64(len(meta[k])))
+		buf = append(buf, bs8...)
+		buf = append(buf, meta[k]...)
+	}
+	return buf
+}
+
+// decodeMetadata parses a metadata section as written by encodeMetadata.
+func decodeMetadata(buf []byte) (map[string]string, error) {
+	meta := make(map[string]string)
+	next := func() (string, error) {
+		if len(buf) < 8 {
+			return "", fmt.Errorf("truncated metadata section")
+		}
+		l := binary.LittleEndian.Uint64(buf)
+		buf = buf[8:]
+		if l > uint64(len(buf)) {
+			return "", fmt.Errorf("invalid metadata length %d", l)
+		}
+		s := string(buf[:l])
+		buf = buf[l:]
+		return s, nil
+	}
+	for len(buf) > 0 {
+		k, err := next()
+		if err != nil {
+			return nil, err
+		}
+		v, err := next()
+		if err != nil {
+			return nil, err
+		}
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+// readMetadata reads a length-prefixed metadata section.
+func readMetadata(input io.Reader) (map[string]string, error) {
+	bs8 := make([]byte, 8)
+	if _, err := io.ReadFull(input, bs8); err != nil {
+		return nil, err
+	}
+	l := binary.LittleEndian.Uint64(bs8)
+	if l > maxMetadataSize {
+		return nil, fmt.Errorf("metadata section is too large (%d bytes, maximum is %d)", l, maxMetadataSize)
+	}
+	buf := make([]byte, l)
+	if _, err := io.ReadFull(input, buf); err != nil {
+		return nil, err
+	}
+	return decodeMetadata(buf)
+}
@@ -0,0 +1,166 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000, 0.01, 100)
+	filter.SetMetadata("foo", "bar")
+	filter.SetMetadata("empty", "")
+	filter.SetMetadata("bloom.test", "with\nnewline")
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Bytes()[0] != 2 {
+		t.Fatalf("filter with metadata should be written as version 2, got %d", buf.Bytes()[0])
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkFilters(filter, loaded, t) {
+		t.Fatal("filters do not match")
+	}
+	keys := loaded.MetadataKeys()
+	if len(keys) != 4 || keys[0] != MetadataKeyProducer || keys[1] != "bloom.test" || keys[2] != "empty" || keys[3] != "foo" {
+		t.Fatalf("unexpected metadata keys: %v", keys)
+	}
+	for _, k := range keys[1:] {
+		expected, _ := filter.Metadata(k)
+		if v, _ := loaded.Metadata(k); v != expected {
+			t.Fatalf("metadata for key %s does not match: %q vs. %q", k, v, expected)
+		}
+	}
+	if _, ok := loaded.Metadata("missing"); ok {
+		t.Fatal("missing key reported as present")
+	}
+}
+
+func TestVersion1Unchanged(t *testing.T) {
+	golden, err := ioutil.ReadFile("testdata/test.bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := LoadFromBytes(golden, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatal("filter without metadata is not written in version 1 format")
+	}
+}
+
+func TestMetadataTooLarge(t *testing.T) {
+	filter := mustNew(100, 0.01)
+	filter.SetMetadata("large", "")
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	offset := 48 + 8*int(filter.M)
+	free := maxMetadataSize - int(binary.LittleEndian.Uint64(buf.Bytes()[offset:]))
+
+	// a section of the maximum size is written and read back
+	filter.SetMetadata("large", strings.Repeat("x", free))
+	buf.Reset()
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFromBytes(buf.Bytes(), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// a larger one is refused before anything is written
+	filter.SetMetadata("large", strings.Repeat("x", free+1))
+	buf.Reset()
+	if err := filter.Write(&buf); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes written", buf.Len())
+	}
+}
+
+func TestCorruptMetadata(t *testing.T) {
+	filter := mustNew(100, 0.01)
+	filter.SetMetadata("foo", "bar")
+	var buf bytes.Buffer
+	filter.Write(&buf)
+	serialized := buf.Bytes()
+	// the metadata section starts with its length, followed by the key length
+	offset := 48 + 8*int(filter.M) + 8
+	serialized[offset] = 0xFF
+	if _, err := LoadFromBytes(serialized, false); err == nil {
+		t.Fatal("invalid metadata length should be reported")
+	}
+	if _, err := LoadFromBytes(serialized[:offset], false); err == nil {
+		t.Fatal("truncated metadata should be reported")
+	}
+}
+
+func TestJoinEstimate(t *testing.T) {
+	values := make([][]byte, 15000)
+	for i := range values {
+		values[i] = GenerateTestValue(100)
+	}
+	a := mustNew(100000, 0.001)
+	b := mustNew(100000, 0.001)
+	for _, v := range values[:10000] {
+		a.Add(v)
+	}
+	for _, v := range values[5000:] {
+		b.Add(v)
+	}
+	if err := a.JoinEstimate(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.N < 14700 || a.N > 15300 {
+		t.Fatalf("estimated count too far from union size: %d vs. 15000", a.N)
+	}
+	if !a.CountIsEstimate() || !a.Stats().ElementsEstimated {
+		t.Fatal("count should be marked as estimated")
+	}
+	for _, v := range values {
+		if !a.Check(v) {
+			t.Fatalf("value not found in joined filter: %s", string(v))
+		}
+	}
+
+	c := mustNew(100000, 0.001)
+	c.N = ^uint64(0)
+	if err := c.JoinEstimate(b); err != nil {
+		t.Fatalf("JoinEstimate should not fail on count overflow: %s", err)
+	}
+	d := mustNew(10000, 0.001)
+	if err := d.JoinEstimate(b); err == nil {
+		t.Fatal("joining filters with different dimensions should fail")
+	}
+
+	var buf bytes.Buffer
+	a.Write(&buf)
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.CountIsEstimate() {
+		t.Fatal("estimated count marker not persisted")
+	}
+	loaded.Reset()
+	if loaded.CountIsEstimate() {
+		t.Fatal("count of reset filter should be exact")
+	}
+}
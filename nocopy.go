@@ -0,0 +1,16 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+// noCopy may be embedded into structs which must not be copied after first
+// use, so that the copylocks check of go vet reports copies. Copying a
+// BloomFilter shares its bit array between the copies, while the element
+// count and metadata diverge.
+type noCopy struct{}
+
+// Lock is a no-op used by the copylocks check of go vet.
+func (*noCopy) Lock() {}
+
+// Unlock is a no-op used by the copylocks check of go vet.
+func (*noCopy) Unlock() {}
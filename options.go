@@ -0,0 +1,181 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+// WriteOption configures how a Bloom filter is serialized.
+type WriteOption interface {
+	applyWrite(*writeOptions)
+}
+
+// LoadOption configures how a Bloom filter is deserialized.
+type LoadOption interface {
+	applyLoad(*loadOptions)
+}
+
+// LoadWriteOption is an option that applies to both loading and writing.
+type LoadWriteOption interface {
+	WriteOption
+	LoadOption
+}
+
+type writeOptions struct {
+	reproducible bool
+	maxDataSize  int64
+	exclusions   [][]byte
+	metadata     map[string]string
+	logger       Logger
+	producer     string
+	comment      *string
+	externalData string
+
+	// defaultProducer is the application identifier recorded by filters
+	// written in version 2 without WithProducer
+	defaultProducer string
+}
+
+type loadOptions struct {
+	maxDataSize int64
+	byModTime   bool
+	onSkipped   func(path string, err error)
+	prefault    bool
+	progress    func(done, total uint64)
+	logger      Logger
+	// path is the path of the file read, for events
+	path string
+
+	ignoreTrailingGarbage bool
+
+	dataResolution DataResolution
+	dataResolver   DataResolver
+
+	// dataSource is the input of LoadFromBytes, whose Data section is
+	// attached without copying it with zeroCopyData
+	zeroCopyData bool
+	dataSource   []byte
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	wo := writeOptions{
+		maxDataSize: DefaultMaxDataSize,
+		logger:      NopLogger{},
+	}
+	for _, opt := range opts {
+		opt.applyWrite(&wo)
+	}
+	return wo
+}
+
+func newLoadOptions(opts []LoadOption) loadOptions {
+	lo := loadOptions{
+		maxDataSize: DefaultMaxDataSize,
+		logger:      NopLogger{},
+	}
+	for _, opt := range opts {
+		opt.applyLoad(&lo)
+	}
+	return lo
+}
+
+type writeOptionFunc func(*writeOptions)
+
+func (f writeOptionFunc) applyWrite(o *writeOptions) {
+	f(o)
+}
+
+// withMetadata makes Write store an additional metadata entry with the
+// filter, without modifying the filter itself.
+func withMetadata(key, value string) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string)
+		}
+		o.metadata[key] = value
+	})
+}
+
+// Reproducible makes the serialized representation depend only on the filter
+// parameters, the set of inserted values and the attached Data, so that two
+// filters built from the same values produce byte-identical output regardless
+// of insertion order. As the element counter N depends on the order in which
+// values were added (a value whose bits were all set by earlier values is not
+// counted), it is replaced by the estimate returned by EstimatedNumElements.
+func Reproducible() WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.reproducible = true
+	})
+}
+
+type maxDataSizeOption int64
+
+func (o maxDataSizeOption) applyWrite(wo *writeOptions) {
+	wo.maxDataSize = int64(o)
+}
+
+func (o maxDataSizeOption) applyLoad(lo *loadOptions) {
+	lo.maxDataSize = int64(o)
+}
+
+// MaxDataSize overrides DefaultMaxDataSize for a single load or write
+// operation. A size of zero or less disables the limit.
+func MaxDataSize(size int64) LoadWriteOption {
+	return maxDataSizeOption(size)
+}
+
+// logArgs returns the key-value pairs of an event, preceded by the path of
+// the file read, if known.
+func (o loadOptions) logArgs(args ...interface{}) []interface{} {
+	if o.path == "" {
+		return args
+	}
+	return append([]interface{}{LogKeyPath, o.path}, args...)
+}
+
+type loadOptionFunc func(*loadOptions)
+
+func (f loadOptionFunc) applyLoad(o *loadOptions) {
+	f(o)
+}
+
+// NewestByModTime makes LoadNewestFilter order candidate files by modification
+// time instead of by name.
+func NewestByModTime() LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.byModTime = true
+	})
+}
+
+// OnSkippedFilter registers a function that LoadNewestFilter calls for each
+// candidate file that could not be loaded and was skipped.
+func OnSkippedFilter(fn func(path string, err error)) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.onSkipped = fn
+	})
+}
+
+// ZeroCopyData makes LoadFromBytes attach the Data section of uncompressed
+// input to the filter without copying it, so that the filter retains the end
+// of the input slice, which must not be modified afterwards. Without it, and
+// with all other loaders, the filter never retains the input.
+func ZeroCopyData() LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.zeroCopyData = true
+	})
+}
+
+// withDataSource sets the input of LoadFromBytes for ZeroCopyData.
+func withDataSource(input []byte) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.dataSource = input
+	})
+}
+
+// WithPrefault makes the loader call Prefault on the loaded filter before
+// returning it. If progress is not nil, it is called periodically with the
+// number of words touched so far and the total number of words.
+func WithPrefault(progress func(done, total uint64)) LoadOption {
+	return loadOptionFunc(func(o *loadOptions) {
+		o.prefault = true
+		o.progress = progress
+	})
+}
@@ -0,0 +1,95 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "io"
+
+// Overlay stages additions to a base filter without modifying it. Added
+// values are recorded in a delta filter with the same dimensions and
+// settings as the base filter, and Check consults both. The delta can be
+// reviewed, persisted on its own, merged into the base filter with Promote or
+// thrown away with Discard.
+//
+// The base filter is only modified by Promote, but it must not be modified
+// elsewhere while the overlay is in use.
+type Overlay struct {
+	base  *BloomFilter
+	delta *BloomFilter
+}
+
+// NewOverlay returns an overlay with an empty delta for the base filter.
+func NewOverlay(base *BloomFilter) *Overlay {
+	return &Overlay{base: base, delta: base.emptyCopy()}
+}
+
+// NewOverlayWithDelta returns an overlay for the base filter with the given
+// delta, e.g. one persisted with WriteDelta and loaded with LoadFilter. An
+// error is returned if the delta has different dimensions or settings.
+func NewOverlayWithDelta(base *BloomFilter, delta *BloomFilter) (*Overlay, error) {
+	if err := base.checkDimensions(delta); err != nil {
+		return nil, err
+	}
+	return &Overlay{base: base, delta: delta}, nil
+}
+
+// Base returns the base filter.
+func (o *Overlay) Base() *BloomFilter {
+	return o.base
+}
+
+// Delta returns the filter of the staged values, e.g. for review.
+func (o *Overlay) Delta() *BloomFilter {
+	return o.delta
+}
+
+// Add stages a value.
+func (o *Overlay) Add(value []byte) {
+	o.delta.Add(value)
+}
+
+// TryAdd stages a value like TryAdd of BloomFilter.
+func (o *Overlay) TryAdd(value []byte) error {
+	return o.delta.TryAdd(value)
+}
+
+// Check returns true if the value may be in the base filter or was staged.
+func (o *Overlay) Check(value []byte) bool {
+	return o.base.Check(value) || o.delta.Check(value)
+}
+
+// Rejects returns true if the value is rejected due to its length, like
+// Rejects of BloomFilter.
+func (o *Overlay) Rejects(value []byte) bool {
+	return o.base.rejects(value)
+}
+
+// TryCheck checks a value like Check, but returns an error wrapping
+// ErrValueTooLarge if the value is rejected due to its length.
+func (o *Overlay) TryCheck(value []byte) (bool, error) {
+	ok, err := o.base.TryCheck(value)
+	if err != nil || ok {
+		return ok, err
+	}
+	return o.delta.Check(value), nil
+}
+
+// Promote joins the staged values into the base filter (see Join) and clears
+// the delta. If Join fails, the delta is kept.
+func (o *Overlay) Promote() error {
+	if err := o.base.Join(o.delta); err != nil {
+		return err
+	}
+	o.delta.Reset()
+	return nil
+}
+
+// Discard clears the delta, leaving the base filter unchanged.
+func (o *Overlay) Discard() {
+	o.delta.Reset()
+}
+
+// WriteDelta writes the delta like Write of BloomFilter.
+func (o *Overlay) WriteDelta(w io.Writer, opts ...WriteOption) error {
+	return o.delta.Write(w, opts...)
+}
@@ -0,0 +1,98 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	base, baseValues := GenerateExampleFilter(10000, 0.001, 1000)
+	overlay := NewOverlay(base)
+	staged, stagedValues := GenerateDisjointExampleFilter(10000, 0.001, 1000, base)
+	for _, v := range stagedValues {
+		overlay.Add(v)
+	}
+	for _, v := range append(baseValues, stagedValues...) {
+		if !overlay.Check(v) {
+			t.Fatalf("value not found in overlay: %s", string(v))
+		}
+	}
+	for _, v := range stagedValues {
+		if base.Check(v) {
+			t.Fatalf("staged value found in base filter: %s", string(v))
+		}
+	}
+	if base.N != 1000 || overlay.Delta().N != 1000 {
+		t.Fatalf("unexpected counts %d and %d", base.N, overlay.Delta().N)
+	}
+
+	// the delta can be persisted and restored
+	var buf bytes.Buffer
+	if err := overlay.WriteDelta(&buf); err != nil {
+		t.Fatal(err)
+	}
+	delta, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewOverlayWithDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range stagedValues {
+		if !restored.Check(v) {
+			t.Fatalf("value not found in restored overlay: %s", string(v))
+		}
+	}
+	if _, err := NewOverlayWithDelta(base, mustNew(1000, 0.001)); err == nil {
+		t.Fatal("expected a delta with different dimensions to be rejected")
+	}
+
+	// promoting equals a direct join
+	joined, _ := GenerateExampleFilter(10000, 0.001, 0)
+	if err := joined.Join(base); err != nil {
+		t.Fatal(err)
+	}
+	if err := joined.Join(staged); err != nil {
+		t.Fatal(err)
+	}
+	if err := overlay.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if base.N != joined.N {
+		t.Fatalf("unexpected count after promotion: %d vs. %d", base.N, joined.N)
+	}
+	for i := range base.v {
+		if base.v[i] != joined.v[i] {
+			t.Fatalf("promoted filter differs from joined filter at word %d", i)
+		}
+	}
+	if overlay.Delta().N != 0 || overlay.Delta().NumSetBits() != 0 {
+		t.Fatal("delta not cleared by promotion")
+	}
+}
+
+func TestOverlayDiscard(t *testing.T) {
+	base, _ := GenerateExampleFilter(10000, 0.001, 1000)
+	var before bytes.Buffer
+	base.Write(&before)
+	overlay := NewOverlay(base)
+	_, stagedValues := GenerateDisjointExampleFilter(10000, 0.001, 1000, base)
+	for _, v := range stagedValues {
+		overlay.Add(v)
+	}
+	overlay.Discard()
+	for _, v := range stagedValues {
+		if overlay.Check(v) {
+			t.Fatalf("discarded value found in overlay: %s", string(v))
+		}
+	}
+	var after bytes.Buffer
+	base.Write(&after)
+	if !bytes.Equal(before.Bytes(), after.Bytes()) {
+		t.Fatal("base filter modified by overlay")
+	}
+}
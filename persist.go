@@ -0,0 +1,313 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultPersistPollInterval is how often a Persister checks whether the
+// filter changed, unless set with WithPersistPollInterval.
+const DefaultPersistPollInterval = 100 * time.Millisecond
+
+// persistChunkWords is the number of words of the bit array a Persister
+// copies per hold of the lock (256 KiB).
+const persistChunkWords = 32 << 10
+
+// ErrPersisterClosed is returned by Persister.Flush after Close.
+var ErrPersisterClosed = errors.New("persister closed")
+
+// PersisterOption configures a Persister.
+type PersisterOption func(*persisterOptions)
+
+type persisterOptions struct {
+	write          WriteFunc
+	pollInterval   time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	onError        func(err error, failures int)
+	clock          clock
+}
+
+// WithPersistWriter makes a Persister write the snapshots with the given
+// function instead of writing them atomically to its path.
+func WithPersistWriter(write WriteFunc) PersisterOption {
+	return func(o *persisterOptions) {
+		o.write = write
+	}
+}
+
+// WithPersistPollInterval sets how often a Persister checks whether the
+// filter changed (DefaultPersistPollInterval by default).
+func WithPersistPollInterval(d time.Duration) PersisterOption {
+	return func(o *persisterOptions) {
+		o.pollInterval = d
+	}
+}
+
+// WithPersistBackoff sets the delay before retrying a failed write, which
+// doubles with each further failure up to max (by default
+// DefaultRetryInitialBackoff and DefaultRetryMaxBackoff).
+func WithPersistBackoff(initial, max time.Duration) PersisterOption {
+	return func(o *persisterOptions) {
+		o.initialBackoff, o.maxBackoff = initial, max
+	}
+}
+
+// OnPersistError sets a function that is called on the goroutine of a
+// Persister with the error of each failed write and the number of
+// consecutive failures, starting at 1.
+func OnPersistError(fn func(err error, failures int)) PersisterOption {
+	return func(o *persisterOptions) {
+		o.onError = fn
+	}
+}
+
+// withPersistClock sets the clock of a Persister.
+func withPersistClock(c clock) PersisterOption {
+	return func(o *persisterOptions) {
+		o.clock = c
+	}
+}
+
+// Persister writes a filter that is modified continuously, e.g. by a
+// service adding values as they arrive, to a file in the background, at most
+// once per interval. It checks the Changes of the filter on its own
+// goroutine, so that adding values never waits for a write. After the filter
+// changed, the Persister waits for the interval to coalesce a burst of
+// changes into a single write, unless the given number of changes is pending,
+// but writes no sooner than the interval after the previous write. Each
+// snapshot is written atomically like by an Autosaver; failed writes are
+// reported to the function set with OnPersistError and retried with an
+// exponential backoff.
+//
+// All goroutines modifying the filter must hold the lock given to
+// NewPersister meanwhile, and its dimensions must not change. The Persister
+// holds the lock while it reads the Changes and copies the metadata for a
+// snapshot, and then for each chunk of persistChunkWords words of the bit
+// array it copies, so that adding a value waits for at most one chunk rather
+// than the whole filter. It does not hold the lock while the snapshot is
+// written.
+type Persister struct {
+	filter      *BloomFilter
+	lock        sync.Locker
+	minInterval time.Duration
+	maxPending  uint64
+	o           persisterOptions
+
+	flushes   chan persisterFlush
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// state of the goroutine: the Changes of the last snapshot written, when
+	// the filter was first seen changed since, and the failed writes since
+	persisted  uint64
+	dirtySince time.Time
+	failures   int
+	retryAt    time.Time
+
+	mu         sync.Mutex
+	lastTime   time.Time
+	lastDigest string
+}
+
+// persisterFlush is a request to write the pending changes by Flush.
+type persisterFlush struct {
+	ctx  context.Context
+	done chan error
+}
+
+// NewPersister starts a Persister writing the filter to the given path,
+// compressed if gzip is true, at most once per minInterval, and as soon as
+// the interval allows once maxPending changes are pending (zero disables
+// this trigger). It must be stopped with Close.
+func NewPersister(filter *BloomFilter, lock sync.Locker, path string, gzip bool, minInterval time.Duration, maxPending uint64, opts ...PersisterOption) *Persister {
+	o := persisterOptions{
+		write: func(filter *BloomFilter) error {
+			return writeFilterAtomically(filter, path, gzip)
+		},
+		pollInterval:   DefaultPersistPollInterval,
+		initialBackoff: DefaultRetryInitialBackoff,
+		maxBackoff:     DefaultRetryMaxBackoff,
+		clock:          systemClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	p := &Persister{
+		filter:      filter,
+		lock:        lock,
+		minInterval: minInterval,
+		maxPending:  maxPending,
+		o:           o,
+		flushes:     make(chan persisterFlush),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	lock.Lock()
+	p.persisted = filter.Changes()
+	lock.Unlock()
+	go p.run()
+	return p
+}
+
+func (p *Persister) run() {
+	defer close(p.done)
+	timer := p.o.clock.After(p.o.pollInterval)
+	for {
+		select {
+		case <-p.stop:
+			return
+		case req := <-p.flushes:
+			req.done <- p.flush(req.ctx)
+		case <-timer:
+			p.poll()
+			timer = p.o.clock.After(p.o.pollInterval)
+		}
+	}
+}
+
+// pending returns the number of changes since the last snapshot written.
+func (p *Persister) pending() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.filter.Changes() - p.persisted
+}
+
+// poll writes a snapshot if the filter changed and a write is due.
+func (p *Persister) poll() {
+	pending := p.pending()
+	if pending == 0 {
+		p.dirtySince = time.Time{}
+		return
+	}
+	now := p.o.clock.Now()
+	if p.dirtySince.IsZero() {
+		p.dirtySince = now
+	}
+	if now.Before(p.retryAt) || now.Sub(p.lastTime) < p.minInterval {
+		return
+	}
+	if now.Sub(p.dirtySince) < p.minInterval && (p.maxPending == 0 || pending < p.maxPending) {
+		return
+	}
+	p.persist()
+}
+
+// persist writes a snapshot of the filter. If the write fails, the error is
+// reported and the next attempt is delayed by the backoff.
+func (p *Persister) persist() error {
+	snapshot, changes := p.snapshot()
+	err := p.o.write(snapshot)
+	now := p.o.clock.Now()
+	if err != nil {
+		p.failures++
+		backoff := p.o.initialBackoff
+		for i := 1; i < p.failures && backoff < p.o.maxBackoff; i++ {
+			backoff *= 2
+		}
+		if backoff > p.o.maxBackoff {
+			backoff = p.o.maxBackoff
+		}
+		p.retryAt = now.Add(backoff)
+		if p.o.onError != nil {
+			p.o.onError(err, p.failures)
+		}
+		return err
+	}
+	h := sha256.New()
+	snapshot.Write(h)
+	p.persisted = changes
+	p.dirtySince = time.Time{}
+	p.failures = 0
+	p.retryAt = time.Time{}
+	p.mu.Lock()
+	p.lastTime = now
+	p.lastDigest = hex.EncodeToString(h.Sum(nil))
+	p.mu.Unlock()
+	return nil
+}
+
+// snapshot copies the filter for a write and returns the copy along with the
+// Changes it contains. The bit array is copied in chunks, holding the lock for
+// each chunk only, so the copy may also contain some of the values added
+// meanwhile. These are still counted as pending and written in full with the
+// next snapshot.
+func (p *Persister) snapshot() (*BloomFilter, uint64) {
+	f := p.filter
+	// the dimensions do not change, so the copy is allocated without the lock
+	snapshot := newFilter(f.n, f.p, f.m, f.k)
+	p.lock.Lock()
+	changes := f.Changes()
+	f.copyState(snapshot)
+	p.lock.Unlock()
+	for i := 0; i < len(snapshot.v); i += persistChunkWords {
+		end := i + persistChunkWords
+		if end > len(snapshot.v) {
+			end = len(snapshot.v)
+		}
+		p.lock.Lock()
+		copy(snapshot.v[i:end], f.v[i:end])
+		p.lock.Unlock()
+	}
+	return snapshot, changes
+}
+
+// flush writes the pending changes, if any, retrying failed writes after the
+// backoff until ctx is done.
+func (p *Persister) flush(ctx context.Context) error {
+	for attempts := 1; p.pending() > 0; attempts++ {
+		err := p.persist()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return &RetryError{Attempts: attempts, Err: err, Interrupted: ctx.Err()}
+		case <-p.o.clock.After(p.retryAt.Sub(p.o.clock.Now())):
+		}
+	}
+	return nil
+}
+
+// Flush writes the changes not written yet right away, regardless of the
+// interval, and waits for the write. A failed write is retried after the
+// backoff until ctx is done, in which case a *RetryError is returned.
+func (p *Persister) Flush(ctx context.Context) error {
+	req := persisterFlush{ctx: ctx, done: make(chan error, 1)}
+	select {
+	case p.flushes <- req:
+	case <-p.done:
+		return ErrPersisterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-req.done
+}
+
+// Close writes the pending changes like Flush, e.g. on shutdown, and stops
+// the Persister. It is stopped even if the write fails.
+func (p *Persister) Close(ctx context.Context) error {
+	err := p.Flush(ctx)
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	<-p.done
+	return err
+}
+
+// LastPersisted returns the time of the last snapshot written and the
+// SHA-256 digest of its uncompressed binary representation, or the zero time
+// and an empty string if none was written yet.
+func (p *Persister) LastPersisted() (time.Time, string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastTime, p.lastDigest
+}
@@ -0,0 +1,387 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// manualClock is a clock whose time only passes with advance, which fires
+// the timers that are due. Each timer started is signaled on started.
+type manualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []manualTimer
+	started chan struct{}
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1700000000, 0), started: make(chan struct{}, 100)}
+}
+
+func (c *manualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *manualClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, manualTimer{at: c.now.Add(d), ch: ch})
+	c.mu.Unlock()
+	c.fire()
+	c.started <- struct{}{}
+	return ch
+}
+
+func (c *manualClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+	c.fire()
+}
+
+func (c *manualClock) fire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timers := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			timers = append(timers, timer)
+		} else {
+			timer.ch <- c.now
+		}
+	}
+	c.timers = timers
+}
+
+// recordingWriter records the snapshots written by a Persister, failing the
+// first failures writes.
+type recordingWriter struct {
+	clock    *manualClock
+	mu       sync.Mutex
+	failures int
+	attempts []time.Time
+	written  []*BloomFilter
+}
+
+func (w *recordingWriter) write(filter *BloomFilter) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts = append(w.attempts, w.clock.Now())
+	if len(w.attempts) <= w.failures {
+		return errors.New("disk full")
+	}
+	w.written = append(w.written, filter)
+	return nil
+}
+
+func (w *recordingWriter) writes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.written)
+}
+
+// startPersister starts a Persister polling every second with a manual clock
+// and a recordingWriter, and returns a function adding values to the filter
+// and one letting the given time pass, after which the Persister has polled.
+func startPersister(t *testing.T, filter *BloomFilter, minInterval time.Duration, maxPending uint64, failures int, opts ...PersisterOption) (*Persister, *recordingWriter, func(values ...string), func(d time.Duration)) {
+	clock := newManualClock()
+	w := &recordingWriter{clock: clock, failures: failures}
+	var mu sync.Mutex
+	p := NewPersister(filter, &mu, "", false, minInterval, maxPending, append([]PersisterOption{
+		withPersistClock(clock), WithPersistWriter(w.write), WithPersistPollInterval(time.Second),
+	}, opts...)...)
+	<-clock.started
+	add := func(values ...string) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, value := range values {
+			filter.Add([]byte(value))
+		}
+	}
+	wait := func(d time.Duration) {
+		for ; d > 0; d -= time.Second {
+			clock.advance(time.Second)
+			<-clock.started
+		}
+	}
+	return p, w, add, wait
+}
+
+func TestChanges(t *testing.T) {
+	filter := mustNew(100, 0.01)
+	changes := filter.Changes()
+	filter.Add([]byte("foo"))
+	if filter.Changes() == changes {
+		t.Fatal("adding a value did not change the filter")
+	}
+	changes = filter.Changes()
+	filter.Add([]byte("foo"))
+	if filter.Changes() != changes {
+		t.Fatal("adding a value again changed the filter")
+	}
+	filter.SetData([]byte("data"))
+	filter.SetMetadata("source", "feed")
+	filter.DeleteMetadata("source")
+	filter.DeleteMetadata("source")
+	if filter.Changes() != changes+3 {
+		t.Fatalf("expected %d changes, got %d", changes+3, filter.Changes())
+	}
+}
+
+func TestPersisterCoalescing(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	p, w, add, wait := startPersister(t, filter, 10*time.Second, 0, 0)
+	defer p.Close(context.Background())
+
+	wait(5 * time.Second)
+	if w.writes() != 0 {
+		t.Fatal("an unchanged filter was written")
+	}
+	add("foo", "bar")
+	wait(5 * time.Second)
+	add("baz")
+	wait(5 * time.Second)
+	if w.writes() != 0 {
+		t.Fatal("the filter was written before the interval passed")
+	}
+	wait(5 * time.Second)
+	if w.writes() != 1 {
+		t.Fatalf("expected the burst to be written once, got %d writes", w.writes())
+	}
+	snapshot := w.written[0]
+	if !snapshot.Check([]byte("foo")) || !snapshot.Check([]byte("baz")) || snapshot.NumElements() != 3 {
+		t.Fatal("the snapshot lacks values")
+	}
+	if snapshot == filter {
+		t.Fatal("the filter itself was written instead of a snapshot")
+	}
+	last, digest := p.LastPersisted()
+	if last != w.attempts[0] {
+		t.Fatalf("last persisted at %s, expected %s", last, w.attempts[0])
+	}
+	h := sha256.Sum256(mustWrite(t, snapshot))
+	if digest != hex.EncodeToString(h[:]) {
+		t.Fatalf("unexpected digest %s", digest)
+	}
+
+	wait(30 * time.Second)
+	if w.writes() != 1 {
+		t.Fatal("an unchanged filter was written again")
+	}
+}
+
+func TestPersisterMaxPending(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	p, w, add, wait := startPersister(t, filter, 10*time.Second, 5, 0)
+	defer p.Close(context.Background())
+
+	add("a", "b", "c", "d")
+	wait(time.Second)
+	if w.writes() != 0 {
+		t.Fatal("the filter was written before the changes were pending")
+	}
+	add("e")
+	wait(time.Second)
+	if w.writes() != 1 {
+		t.Fatal("the pending changes were not written right away")
+	}
+	// the interval still applies between writes
+	add("f", "g", "h", "i", "j")
+	wait(9 * time.Second)
+	if w.writes() != 1 {
+		t.Fatal("the filter was written twice in the interval")
+	}
+	wait(time.Second)
+	if w.writes() != 2 || w.attempts[1].Sub(w.attempts[0]) != 10*time.Second {
+		t.Fatalf("expected a second write after the interval, got %v", w.attempts)
+	}
+}
+
+func TestPersisterRetry(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	var failures []int
+	p, w, add, wait := startPersister(t, filter, 0, 0, 3,
+		WithPersistBackoff(2*time.Second, 3*time.Second),
+		OnPersistError(func(err error, n int) {
+			failures = append(failures, n)
+		}))
+	defer p.Close(context.Background())
+
+	add("foo")
+	wait(10 * time.Second)
+	if w.writes() != 1 {
+		t.Fatalf("expected the write to succeed eventually, got %d", w.writes())
+	}
+	var delays []time.Duration
+	for i := 1; i < len(w.attempts); i++ {
+		delays = append(delays, w.attempts[i].Sub(w.attempts[i-1]))
+	}
+	if len(delays) != 3 || delays[0] != 2*time.Second || delays[1] != 3*time.Second || delays[2] != 3*time.Second {
+		t.Fatalf("unexpected delays between attempts %v", delays)
+	}
+	if len(failures) != 3 || failures[0] != 1 || failures[2] != 3 {
+		t.Fatalf("unexpected failures reported %v", failures)
+	}
+	if last, _ := p.LastPersisted(); last != w.attempts[3] {
+		t.Fatalf("last persisted at %s", last)
+	}
+}
+
+func TestPersisterFlush(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	p, w, add, _ := startPersister(t, filter, time.Hour, 0, 0)
+
+	ctx := context.Background()
+	if err := p.Flush(ctx); err != nil || w.writes() != 0 {
+		t.Fatalf("flushing an unchanged filter: %v, %d writes", err, w.writes())
+	}
+	add("foo")
+	if err := p.Flush(ctx); err != nil || w.writes() != 1 {
+		t.Fatalf("flushing a changed filter: %v, %d writes", err, w.writes())
+	}
+	add("bar")
+	if err := p.Close(ctx); err != nil || w.writes() != 2 || !w.written[1].Check([]byte("bar")) {
+		t.Fatalf("closing did not write the pending changes: %v, %d writes", err, w.writes())
+	}
+	if err := p.Flush(ctx); !errors.Is(err, ErrPersisterClosed) {
+		t.Fatalf("expected ErrPersisterClosed, got %v", err)
+	}
+}
+
+func TestPersisterSnapshotChunks(t *testing.T) {
+	// a filter of several chunks, to which values are added while it is copied
+	filter := mustNew(1000000, 0.001)
+	if filter.M <= 2*persistChunkWords {
+		t.Fatalf("filter of %d words is too small", filter.M)
+	}
+	p, w, add, _ := startPersister(t, filter, time.Hour, 0, 0)
+	ctx := context.Background()
+	stop := make(chan struct{})
+	started := make(chan struct{})
+	added := make(chan int)
+	go func() {
+		i := 0
+		for ; ; i++ {
+			select {
+			case <-stop:
+				added <- i
+				return
+			default:
+				add(strconv.Itoa(i))
+			}
+			if i == 0 {
+				close(started)
+			}
+		}
+	}()
+	<-started
+	for i := 0; i < 5; i++ {
+		if err := p.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	n := <-added
+
+	// values added during a copy are written in full with the next snapshot
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	last := w.written[len(w.written)-1]
+	if last.NumElements() != filter.NumElements() {
+		t.Fatalf("snapshot of %d elements, filter of %d", last.NumElements(), filter.NumElements())
+	}
+	for i := 0; i < n; i++ {
+		if !last.Check([]byte(strconv.Itoa(i))) {
+			t.Fatalf("value %d missing from the last snapshot", i)
+		}
+	}
+}
+
+func TestPersisterFlushRetry(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	p, w, add, _ := startPersister(t, filter, time.Hour, 0, 2)
+	clock := w.clock
+	add("foo")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Flush(context.Background())
+	}()
+	for flushed := false; !flushed; {
+		select {
+		case err := <-done:
+			if err != nil || w.writes() != 1 || len(w.attempts) != 3 {
+				t.Fatalf("flush: %v, %d attempts", err, len(w.attempts))
+			}
+			flushed = true
+		case <-clock.started:
+			clock.advance(time.Second)
+		}
+	}
+
+	// flushing on shutdown gives up when the context is done
+	w.mu.Lock()
+	w.failures = 1000
+	w.mu.Unlock()
+	add("bar")
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-clock.started
+		cancel()
+	}()
+	err := p.Close(ctx)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(retryErr.Interrupted, context.Canceled) {
+		t.Fatalf("expected an interrupted retry, got %v", err)
+	}
+}
+
+func TestPersisterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.bloom")
+
+	filter := mustNew(1000, 0.01)
+	var mu sync.Mutex
+	p := NewPersister(filter, &mu, path, true, time.Hour, 0)
+	mu.Lock()
+	filter.Add([]byte("foo"))
+	mu.Unlock()
+	if err := p.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Check([]byte("foo")) {
+		t.Fatal("the value was not persisted")
+	}
+	h := sha256.Sum256(mustWrite(t, loaded))
+	if _, digest := p.LastPersisted(); digest != hex.EncodeToString(h[:]) {
+		t.Fatalf("unexpected digest %s", digest)
+	}
+}
@@ -0,0 +1,68 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits are the units of sizes and rates in bytes, in increasing order.
+// All of them are powers of 1024; the names without "i" (e.g. "MB" and "M")
+// are accepted as aliases, and the first name is used for formatting.
+var byteUnits = []struct {
+	names  []string
+	factor uint64
+}{
+	{[]string{"B"}, 1},
+	{[]string{"KiB", "KB", "K"}, 1 << 10},
+	{[]string{"MiB", "MB", "M"}, 1 << 20},
+	{[]string{"GiB", "GB", "G"}, 1 << 30},
+	{[]string{"TiB", "TB", "T"}, 1 << 40},
+	{[]string{"PiB", "PB", "P"}, 1 << 50},
+}
+
+// splitByteUnit splits a trailing unit of byteUnits off s, case-insensitively,
+// and returns the remaining number, the factor of the unit and its name as
+// given, or s, 1 and "" if it has no unit.
+func splitByteUnit(s string) (string, uint64, string) {
+	upper := strings.ToUpper(s)
+	// larger units first, so that e.g. "KB" is not taken for "B"
+	for i := len(byteUnits) - 1; i >= 0; i-- {
+		for _, name := range byteUnits[i].names {
+			if strings.HasSuffix(upper, strings.ToUpper(name)) {
+				n := len(s) - len(name)
+				return strings.TrimSpace(s[:n]), byteUnits[i].factor, s[n:]
+			}
+		}
+	}
+	return s, 1, ""
+}
+
+// ParseByteSize parses a size in bytes with an optional unit of B, KiB, MiB,
+// GiB, TiB or PiB, which are powers of 1024 also if given as e.g. "MB" or
+// "M" (e.g. "512MB" or "1.5KiB").
+func ParseByteSize(s string) (uint64, error) {
+	number, factor, _ := splitByteUnit(strings.TrimSpace(s))
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || !(size > 0) || size*float64(factor) >= math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(size * float64(factor)), nil
+}
+
+// FormatByteSize formats a size in bytes with the largest unit of
+// ParseByteSize in which it is at least 1, e.g. "1.5 KiB".
+func FormatByteSize(size uint64) string {
+	unit := 0
+	for unit < len(byteUnits)-1 && size >= byteUnits[unit+1].factor {
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", size)
+	}
+	return fmt.Sprintf("%.1f %s", float64(size)/float64(byteUnits[unit].factor), byteUnits[unit].names[0])
+}
@@ -0,0 +1,37 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	for s, expected := range map[string]uint64{
+		"1000": 1000, "512MB": 512 << 20, "512 mb": 512 << 20, "512MiB": 512 << 20, "512M": 512 << 20,
+		"1.5KiB": 1536, "2GB": 2 << 30, "8B": 8, "1P": 1 << 50,
+	} {
+		if size, err := ParseByteSize(s); err != nil || size != expected {
+			t.Errorf("%q: got %d, %v, expected %d", s, size, err, expected)
+		}
+	}
+	for _, s := range []string{"", "MB", "-1MB", "12XB", "0"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	for size, expected := range map[uint64]string{
+		0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 512 << 20: "512.0 MiB", 3 << 50: "3.0 PiB",
+	} {
+		if s := FormatByteSize(size); s != expected {
+			t.Errorf("%d: got %q, expected %q", size, s, expected)
+		}
+		if size > 0 {
+			if parsed, err := ParseByteSize(FormatByteSize(size)); err != nil || parsed != size {
+				t.Errorf("%d does not round-trip: %d, %v", size, parsed, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// scanLinesKeepCR is a split function like bufio.ScanLines that does not drop
+// carriage returns, so that Scanner can decide what to do with them.
+func scanLinesKeepCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Scanner reads input lines. Unless keepCR is set, a trailing carriage return
+// is stripped from each line, so that values from CRLF input match the same
+// values from LF input.
+type Scanner struct {
+	scanner    *bufio.Scanner
+	keepCR     bool
+	line       []byte
+	strippedCR int
+	offset     int64
+}
+
+// NewScanner returns a Scanner reading lines from the input.
+func NewScanner(input io.Reader, keepCR bool) *Scanner {
+	s := &Scanner{scanner: bufio.NewScanner(input), keepCR: keepCR}
+	s.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := scanLinesKeepCR(data, atEOF)
+		s.offset += int64(advance)
+		return advance, token, err
+	})
+	return s
+}
+
+// Scan advances to the next line, see bufio.Scanner.
+func (s *Scanner) Scan() bool {
+	if !s.scanner.Scan() {
+		return false
+	}
+	s.line = s.scanner.Bytes()
+	if !s.keepCR && len(s.line) > 0 && s.line[len(s.line)-1] == '\r' {
+		s.line = s.line[:len(s.line)-1]
+		s.strippedCR++
+	}
+	return true
+}
+
+// Bytes returns the current line. The slice is only valid until the next call
+// to Scan.
+func (s *Scanner) Bytes() []byte {
+	return s.line
+}
+
+// Text returns the current line as a string.
+func (s *Scanner) Text() string {
+	return string(s.line)
+}
+
+// Err returns the first error encountered by the scanner.
+func (s *Scanner) Err() error {
+	return s.scanner.Err()
+}
+
+// StrippedCR returns the number of lines a carriage return was stripped from.
+func (s *Scanner) StrippedCR() int {
+	return s.strippedCR
+}
+
+// Offset returns the number of input bytes up to the end of the current
+// line, including its line break.
+func (s *Scanner) Offset() int64 {
+	return s.offset
+}
+
+// Stats summarizes the input processed by a Driver.
+type Stats struct {
+	// Lines is the number of lines read.
+	Lines int
+	// StrippedCR is the number of lines a carriage return was stripped from.
+	StrippedCR int
+	// Sampled is the number of lines selected by the Sampler of the Driver,
+	// if any.
+	Sampled int
+	// Stopped is true if the input ended because the Context of the Driver
+	// was done.
+	Stopped bool
+	// Invalid is the number of lines skipped because of values that are not
+	// validly encoded, see Driver.SkipInvalid.
+	Invalid int
+}
+
+// Driver feeds input lines through a pipeline.
+type Driver struct {
+	// Pipeline derives the values from each line.
+	Pipeline Pipeline
+	// KeepCR keeps trailing carriage returns, see Scanner.
+	KeepCR bool
+	// StopAtEmptyLine ends the input at the first empty line, e.g. for
+	// interactive input.
+	StopAtEmptyLine bool
+	// Progress, if set, is called after each line was processed with the
+	// number of input bytes read up to the end of the line (see
+	// Scanner.Offset). An error returned by it stops the input.
+	Progress func(offset int64) error
+	// Sampler, if set, selects the lines to process. The input ends once its
+	// duration has passed, which is noticed when the next line was read.
+	Sampler *Sampler
+	// Limiter, if set, limits the rate at which lines are processed. It may
+	// be shared by several drivers to limit their total rate.
+	Limiter *RateLimiter
+	// NiceIO makes the input be read in small chunks with pauses, see
+	// niceReader.
+	NiceIO bool
+	// Context, if set, ends the input once it is done, which is noticed when
+	// the next line was read or while waiting for the Limiter.
+	Context context.Context
+	// Decoding, if set, decodes the values derived from each line by the
+	// Pipeline. If a value of a line is not validly encoded, the line is
+	// skipped with SkipInvalid, and otherwise the input ends with an
+	// *InvalidValueError.
+	Decoding    Encoding
+	SkipInvalid bool
+	// SourceFile, if set, names the input in errors and outcomes.
+	SourceFile string
+}
+
+// Run calls fn with each line read from the input and the values derived from
+// it, until the input ends or fn returns an error, which is returned. A line
+// that is too long ends the input with a *LineTooLongError.
+func (d *Driver) Run(input io.Reader, fn func(line string, values []string) error) (Stats, error) {
+	return d.run(input, func(number int, line string, values []string) error {
+		return fn(line, values)
+	})
+}
+
+// run is Run, additionally passing the number of each line to fn.
+func (d *Driver) run(input io.Reader, fn func(number int, line string, values []string) error) (Stats, error) {
+	ctx := d.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if d.NiceIO {
+		input = niceReader{r: input, ctx: ctx, now: time.Now, sleep: sleepContext}
+	}
+	scanner := NewScanner(input, d.KeepCR)
+	var stats Stats
+	var err error
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" && d.StopAtEmptyLine {
+			break
+		}
+		if d.Sampler != nil && d.Sampler.Expired() {
+			break
+		}
+		if ctx.Err() != nil {
+			stats.Stopped = true
+			break
+		}
+		if d.Limiter != nil && d.Limiter.WaitLine(ctx, line) != nil {
+			stats.Stopped = true
+			break
+		}
+		stats.Lines++
+		sampled := d.Sampler == nil || d.Sampler.Sample()
+		if sampled && d.Sampler != nil {
+			stats.Sampled++
+		}
+		if sampled {
+			values := d.Pipeline.Values(line)
+			var invalid *InvalidValueError
+			if d.Decoding != NoEncoding {
+				values, invalid = d.decode(values, stats.Lines)
+			}
+			if invalid != nil && !d.SkipInvalid {
+				err = invalid
+				break
+			}
+			if invalid != nil {
+				stats.Invalid++
+			} else if err = fn(stats.Lines, line, values); err != nil {
+				break
+			}
+		}
+		if d.Progress != nil {
+			if err = d.Progress(scanner.Offset()); err != nil {
+				break
+			}
+		}
+	}
+	stats.StrippedCR = scanner.StrippedCR()
+	if err == nil {
+		err = scanner.Err()
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = &LineTooLongError{SourceFile: d.SourceFile, Line: stats.Lines + 1, Offset: scanner.Offset()}
+		}
+	}
+	return stats, err
+}
+
+// decode decodes the values of the line with the given number, or returns the
+// error for the first value that is not validly encoded.
+func (d *Driver) decode(values []string, line int) ([]string, *InvalidValueError) {
+	decoded := make([]string, len(values))
+	for i, value := range values {
+		v, err := d.Decoding.DecodeString(value)
+		if err != nil {
+			return nil, &InvalidValueError{SourceFile: d.SourceFile, Line: line, Value: value, Encoding: d.Decoding, Err: err}
+		}
+		decoded[i] = string(v)
+	}
+	return decoded, nil
+}
+
+// Add calls add with each value derived from the input.
+func (d *Driver) Add(input io.Reader, add func(value []byte)) (Stats, error) {
+	return d.Run(input, func(line string, values []string) error {
+		for _, value := range values {
+			add([]byte(value))
+		}
+		return nil
+	})
+}
+
+// Check checks each value derived from the input using check, and calls emit
+// with each line, its values and whether they matched, until the input ends
+// or emit returns an error, which is returned. See CheckOutcomes for the
+// complete outcomes.
+func (d *Driver) Check(input io.Reader, check func(value []byte) bool, emit func(line string, values []string, matched []bool) error) (Stats, error) {
+	return d.CheckOutcomes(input, func(value []byte) CheckOutcome {
+		return CheckOutcome{Matched: check(value)}
+	}, func(line string, outcomes []CheckOutcome) error {
+		values, matched := Matches(outcomes)
+		return emit(line, values, matched)
+	})
+}
@@ -0,0 +1,106 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanner(t *testing.T) {
+	input := "foo\r\nbar\nbaz\r\n\r\nqux\r"
+	for _, c := range []struct {
+		keepCR   bool
+		expected []string
+		stripped int
+	}{
+		{false, []string{"foo", "bar", "baz", "", "qux"}, 4},
+		{true, []string{"foo\r", "bar", "baz\r", "\r", "qux\r"}, 0},
+	} {
+		scanner := NewScanner(strings.NewReader(input), c.keepCR)
+		var lines []string
+		var offsets []int64
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+			offsets = append(offsets, scanner.Offset())
+		}
+		if !reflect.DeepEqual(lines, c.expected) {
+			t.Errorf("keepCR %v: unexpected lines %q", c.keepCR, lines)
+		}
+		if scanner.StrippedCR() != c.stripped {
+			t.Errorf("keepCR %v: unexpected number of stripped CRs %d", c.keepCR, scanner.StrippedCR())
+		}
+		if !reflect.DeepEqual(offsets, []int64{5, 9, 14, 16, 20}) {
+			t.Errorf("keepCR %v: unexpected offsets %v", c.keepCR, offsets)
+		}
+	}
+}
+
+func TestDriverProgress(t *testing.T) {
+	input := "a,b\r\nc\nd\ne"
+	var offsets []int64
+	driver := Driver{Pipeline: Pipeline{Split(",")}, Progress: func(offset int64) error {
+		offsets = append(offsets, offset)
+		if input[:offset] == "a,b\r\nc\nd\n" {
+			return errors.New("stop")
+		}
+		return nil
+	}}
+	var added []string
+	_, err := driver.Add(strings.NewReader(input), func(value []byte) {
+		added = append(added, string(value))
+	})
+	if err == nil || err.Error() != "stop" {
+		t.Fatalf("expected the error of Progress, got %v", err)
+	}
+	if !reflect.DeepEqual(offsets, []int64{5, 7, 9}) || !reflect.DeepEqual(added, []string{"a", "b", "c", "d"}) {
+		t.Fatalf("unexpected offsets %v or values %q", offsets, added)
+	}
+}
+
+func TestDriverAdd(t *testing.T) {
+	driver := Driver{Pipeline: Pipeline{Split(",")}, StopAtEmptyLine: true}
+	var added []string
+	stats, err := driver.Add(strings.NewReader("a,b\r\nc\n\nd"), func(value []byte) {
+		added = append(added, string(value))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(added, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected values %q", added)
+	}
+	if stats != (Stats{Lines: 2, StrippedCR: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestDriverCheck(t *testing.T) {
+	driver := Driver{Pipeline: Pipeline{Split(","), SelectFields([]int{0, -1})}}
+	check := func(value []byte) bool { return string(value) == "x" }
+	var lines []string
+	var matches [][]bool
+	_, err := driver.Check(strings.NewReader("x,y,z\na,x"), check, func(line string, values []string, matched []bool) error {
+		lines = append(lines, line+":"+strings.Join(values, "|"))
+		matches = append(matches, matched)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(lines, []string{"x,y,z:x|z", "a,x:a|x"}) ||
+		!reflect.DeepEqual(matches, [][]bool{{true, false}, {false, true}}) {
+		t.Fatalf("unexpected results %q %v", lines, matches)
+	}
+
+	errStop := errors.New("stop")
+	stats, err := driver.Check(strings.NewReader("a\nb\nc"), check, func(string, []string, []bool) error {
+		return errStop
+	})
+	if err != errStop || stats.Lines != 1 {
+		t.Fatalf("emit error should stop the driver: %v, %+v", err, stats)
+	}
+}
@@ -0,0 +1,123 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Encoding is a text encoding of binary values, e.g. of hash digests, which
+// are decoded before they are added to or checked against a filter.
+type Encoding int
+
+const (
+	// NoEncoding uses values as they are.
+	NoEncoding Encoding = iota
+	// Hex is the hexadecimal encoding, in upper or lower case.
+	Hex
+	// Base64 is the standard base64 encoding of RFC 4648, with or without
+	// padding.
+	Base64
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case NoEncoding:
+		return "none"
+	case Hex:
+		return "hex"
+	case Base64:
+		return "base64"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
+// ParseEncoding parses the string representation of an encoding.
+func ParseEncoding(s string) (Encoding, error) {
+	switch s {
+	case "none":
+		return NoEncoding, nil
+	case "hex":
+		return Hex, nil
+	case "base64":
+		return Base64, nil
+	}
+	return NoEncoding, fmt.Errorf("invalid encoding %q (must be 'hex' or 'base64')", s)
+}
+
+// DecodeString returns the binary value encoded in s.
+func (e Encoding) DecodeString(s string) ([]byte, error) {
+	switch e {
+	case Hex:
+		return hex.DecodeString(s)
+	case Base64:
+		return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	}
+	return []byte(s), nil
+}
+
+// EncodeToString returns the encoding of a binary value, in lower case for
+// Hex and with padding for Base64.
+func (e Encoding) EncodeToString(value []byte) string {
+	switch e {
+	case Hex:
+		return hex.EncodeToString(value)
+	case Base64:
+		return base64.StdEncoding.EncodeToString(value)
+	}
+	return string(value)
+}
+
+// InvalidValueError is returned by a Driver for a value that is not validly
+// encoded, see Driver.Decoding.
+type InvalidValueError struct {
+	// SourceFile is the name of the input, if known, and Line the number of
+	// the line of the value, starting at 1.
+	SourceFile string
+	Line       int
+	Value      string
+	Encoding   Encoding
+	Err        error
+}
+
+func (e *InvalidValueError) Error() string {
+	return locate(e.SourceFile, e.Line) + fmt.Sprintf("invalid %s value %q: %s", e.Encoding, e.Value, e.Err)
+}
+
+func (e *InvalidValueError) Unwrap() error {
+	return e.Err
+}
+
+// Decode decodes each value with the encoding. Values that are not validly
+// encoded are dropped and passed to invalid with the error, if it is not nil.
+func Decode(encoding Encoding, invalid func(value string, err error)) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		decoded := make([]string, 0, len(values))
+		for _, value := range values {
+			v, err := encoding.DecodeString(value)
+			if err != nil {
+				if invalid != nil {
+					invalid(value, err)
+				}
+				continue
+			}
+			decoded = append(decoded, string(v))
+		}
+		return decoded
+	})
+}
+
+// Encode encodes each value with the encoding, e.g. to print binary values.
+func Encode(encoding Encoding) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		encoded := make([]string, len(values))
+		for i, value := range values {
+			encoded[i] = encoding.EncodeToString([]byte(value))
+		}
+		return encoded
+	})
+}
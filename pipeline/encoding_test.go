@@ -0,0 +1,76 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncoding(t *testing.T) {
+	for _, c := range []struct {
+		encoding Encoding
+		encoded  []string
+	}{
+		{Hex, []string{"666f6f", "666F6F"}},
+		{Base64, []string{"Zm9v", "Zm9vYg==", "Zm9vYg"}},
+	} {
+		if parsed, err := ParseEncoding(c.encoding.String()); err != nil || parsed != c.encoding {
+			t.Errorf("%v does not round-trip: %v, %v", c.encoding, parsed, err)
+		}
+		for _, s := range c.encoded {
+			value, err := c.encoding.DecodeString(s)
+			if err != nil || !strings.HasPrefix(string(value), "foo") {
+				t.Errorf("%v: unexpected decoding of %q: %q, %v", c.encoding, s, value, err)
+			}
+			if encoded := c.encoding.EncodeToString(value); !strings.EqualFold(strings.TrimRight(encoded, "="), strings.TrimRight(s, "=")) {
+				t.Errorf("%v: %q encoded as %q", c.encoding, value, encoded)
+			}
+		}
+	}
+	if _, err := ParseEncoding("rot13"); err == nil {
+		t.Fatal("expected an error for an unknown encoding")
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	var invalid []string
+	p := Pipeline{Split(","), Decode(Hex, func(value string, err error) {
+		invalid = append(invalid, value)
+	})}
+	values := p.Values("00ff,zz,0")
+	if !reflect.DeepEqual(values, []string{"\x00\xff"}) || !reflect.DeepEqual(invalid, []string{"zz", "0"}) {
+		t.Fatalf("unexpected values %q, invalid %q", values, invalid)
+	}
+	if encoded := Encode(Base64).Transform(values); !reflect.DeepEqual(encoded, []string{"AP8="}) {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+}
+
+func TestDriverDecoding(t *testing.T) {
+	input := "666f6f\nnot hex\n626172\n"
+	var values []string
+	driver := Driver{Decoding: Hex}
+	_, err := driver.Add(strings.NewReader(input), func(value []byte) {
+		values = append(values, string(value))
+	})
+	var invalid *InvalidValueError
+	if !errors.As(err, &invalid) || invalid.Line != 2 || invalid.Value != "not hex" {
+		t.Fatalf("expected an error for line 2, got %v", err)
+	}
+	if !reflect.DeepEqual(values, []string{"foo"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+
+	values = nil
+	driver.SkipInvalid = true
+	stats, err := driver.Add(strings.NewReader(input), func(value []byte) {
+		values = append(values, string(value))
+	})
+	if err != nil || stats.Invalid != 1 || stats.Lines != 3 || !reflect.DeepEqual(values, []string{"foo", "bar"}) {
+		t.Fatalf("unexpected result %+v, %q, %v", stats, values, err)
+	}
+}
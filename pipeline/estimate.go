@@ -0,0 +1,144 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// DefaultGzipRatio is the assumed ratio of the uncompressed to the compressed
+// size of gzip-compressed input files, by which InputSize scales their size.
+const DefaultGzipRatio = 4.0
+
+// EstimatorWarmup is how long an Estimator measures the rate of the input
+// before its first estimate of the total duration is taken as the initial
+// one, which is compared with the actual duration in the end.
+const EstimatorWarmup = time.Second
+
+// gzipMagic are the first bytes of gzip-compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// InputSize estimates the number of bytes read from the given files, in
+// total: the size of each, multiplied by gzipRatio for gzip-compressed files,
+// which are decompressed when read. It returns false if any of them is not a
+// regular file, e.g. a pipe, whose size is unknown.
+func InputSize(paths []string, gzipRatio float64) (int64, bool, error) {
+	var total int64
+	for _, path := range paths {
+		size, ok, err := fileInputSize(path, gzipRatio)
+		if err != nil || !ok {
+			return 0, false, err
+		}
+		total += size
+	}
+	return total, true, nil
+}
+
+func fileInputSize(path string, gzipRatio float64) (int64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return 0, false, nil
+	}
+	magic := make([]byte, len(gzipMagic))
+	if _, err := io.ReadFull(f, magic); err == nil && bytes.Equal(magic, gzipMagic) {
+		return int64(float64(info.Size()) * gzipRatio), true, nil
+	}
+	return info.Size(), true, nil
+}
+
+// Estimate is the progress of reading an input, as estimated by an Estimator.
+type Estimate struct {
+	// Elapsed is the time since the input was started.
+	Elapsed time.Duration
+	// Bytes and Values are the numbers of bytes and values consumed.
+	Bytes  int64
+	Values uint64
+	// BytesPerSec and ValuesPerSec are the average rates since the start.
+	BytesPerSec  float64
+	ValuesPerSec float64
+	// Fraction is the fraction of the estimated total size consumed, or -1
+	// if the total size is unknown.
+	Fraction float64
+	// Remaining is the estimated time until the input is consumed at the
+	// average rate, or -1 if it is unknown: if the total size is, if nothing
+	// was consumed yet or if more than the estimated total was consumed.
+	Remaining time.Duration
+}
+
+// Estimator estimates the progress of reading an input of an estimated total
+// size, e.g. from InputSize, from the bytes consumed over time. Without a
+// total size, only the rates are estimated. Times are passed in so that the
+// estimates are reproducible.
+type Estimator struct {
+	total      int64
+	start      time.Time
+	initial    time.Duration
+	hasInitial bool
+	last       Estimate
+}
+
+// NewEstimator returns an Estimator for an input of the given total size in
+// bytes (0 if unknown) started at the given time.
+func NewEstimator(total int64, start time.Time) *Estimator {
+	return &Estimator{total: total, start: start}
+}
+
+// Update records the bytes and values consumed by the given time and returns
+// the resulting estimate.
+func (e *Estimator) Update(bytes int64, values uint64, now time.Time) Estimate {
+	est := Estimate{
+		Elapsed:   now.Sub(e.start),
+		Bytes:     bytes,
+		Values:    values,
+		Fraction:  -1,
+		Remaining: -1,
+	}
+	if est.Elapsed > 0 {
+		seconds := est.Elapsed.Seconds()
+		est.BytesPerSec = float64(bytes) / seconds
+		est.ValuesPerSec = float64(values) / seconds
+	}
+	if e.total > 0 {
+		est.Fraction = float64(bytes) / float64(e.total)
+		if est.Fraction > 1 {
+			est.Fraction = 1
+		} else if est.BytesPerSec > 0 {
+			est.Remaining = time.Duration(float64(e.total-bytes) / est.BytesPerSec * float64(time.Second))
+		}
+	}
+	if !e.hasInitial && est.Remaining >= 0 && est.Elapsed >= EstimatorWarmup {
+		e.initial = est.Elapsed + est.Remaining
+		e.hasInitial = true
+	}
+	e.last = est
+	return est
+}
+
+// Total returns the estimated total size of the input, 0 if unknown.
+func (e *Estimator) Total() int64 {
+	return e.total
+}
+
+// Last returns the estimate returned by the last Update.
+func (e *Estimator) Last() Estimate {
+	return e.last
+}
+
+// InitialEstimate returns the total duration estimated by the first Update
+// at least EstimatorWarmup after the start, or false if there was none with
+// a known total size.
+func (e *Estimator) InitialEstimate() (time.Duration, bool) {
+	return e.initial, e.hasInitial
+}
@@ -0,0 +1,94 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEstimator(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEstimator(1000, start)
+	if est := e.Update(0, 0, start); est.Fraction != 0 || est.Remaining != -1 || est.BytesPerSec != 0 {
+		t.Fatalf("unexpected estimate at the start: %+v", est)
+	}
+	// 100 bytes per second: too early for the initial estimate
+	est := e.Update(50, 5, start.Add(500*time.Millisecond))
+	if est.BytesPerSec != 100 || est.ValuesPerSec != 10 || est.Fraction != 0.05 || est.Remaining != 9500*time.Millisecond {
+		t.Fatalf("unexpected estimate %+v", est)
+	}
+	if _, ok := e.InitialEstimate(); ok {
+		t.Fatal("initial estimate taken during the warmup")
+	}
+	est = e.Update(200, 20, start.Add(2*time.Second))
+	if est.Fraction != 0.2 || est.Remaining != 8*time.Second {
+		t.Fatalf("unexpected estimate %+v", est)
+	}
+	// the input speeds up, which the initial estimate does not reflect
+	est = e.Update(1000, 100, start.Add(4*time.Second))
+	if est.Fraction != 1 || est.Remaining != 0 {
+		t.Fatalf("unexpected estimate at the end %+v", est)
+	}
+	if initial, ok := e.InitialEstimate(); !ok || initial != 10*time.Second {
+		t.Fatalf("expected an initial estimate of 10s, got %s (%v)", initial, ok)
+	}
+	if e.Total() != 1000 || e.Last() != est {
+		t.Fatalf("unexpected last estimate %+v", e.Last())
+	}
+	// more than the estimated total
+	if est = e.Update(1500, 150, start.Add(5*time.Second)); est.Fraction != 1 || est.Remaining != -1 {
+		t.Fatalf("unexpected estimate beyond the total %+v", est)
+	}
+}
+
+func TestEstimatorUnknownSize(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEstimator(0, start)
+	est := e.Update(4000, 100, start.Add(2*time.Second))
+	if est.BytesPerSec != 2000 || est.ValuesPerSec != 50 || est.Fraction != -1 || est.Remaining != -1 {
+		t.Fatalf("unexpected estimate %+v", est)
+	}
+	if _, ok := e.InitialEstimate(); ok {
+		t.Fatal("initial estimate without a total size")
+	}
+}
+
+func TestInputSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "estimate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain := filepath.Join(dir, "plain.txt")
+	if err := ioutil.WriteFile(plain, bytes.Repeat([]byte("foo\n"), 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(bytes.Repeat([]byte("bar\n"), 1000))
+	w.Close()
+	compressed := filepath.Join(dir, "compressed.txt.gz")
+	if err := ioutil.WriteFile(compressed, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size, ok, err := InputSize([]string{plain, compressed}, 3)
+	if err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if expected := int64(400 + 3*buf.Len()); size != expected {
+		t.Fatalf("expected %d bytes, got %d", expected, size)
+	}
+	if _, ok, err := InputSize([]string{plain, dir}, 3); ok || err != nil {
+		t.Fatalf("expected a directory to have an unknown size (%v, %v)", ok, err)
+	}
+	if _, _, err := InputSize([]string{filepath.Join(dir, "missing")}, 3); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
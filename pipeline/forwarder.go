@@ -0,0 +1,268 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// Defaults of ForwarderConfig for fields left zero.
+const (
+	DefaultForwardBatchSize     = 100
+	DefaultForwardFlushInterval = 5 * time.Second
+	DefaultForwardBufferSize    = 10000
+)
+
+// DefaultForwardRetry is the retry policy of a Forwarder by default, which
+// retries a batch for about half a minute.
+var DefaultForwardRetry = bloom.RetryPolicy{
+	MaxAttempts:    6,
+	InitialBackoff: time.Second,
+	MaxBackoff:     15 * time.Second,
+	Jitter:         0.2,
+	AttemptTimeout: 10 * time.Second,
+}
+
+// MatchEvent is a matched value forwarded by a Forwarder.
+type MatchEvent struct {
+	// Time is when the value matched.
+	Time time.Time `json:"timestamp"`
+	// Value is the value, encoded as printed.
+	Value string `json:"value"`
+	// Source is the name of the input of the value, if any, and Line the
+	// number of its line, starting at 1.
+	Source string `json:"source,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	// Filter is the name of the filter the value matched.
+	Filter string `json:"filter,omitempty"`
+}
+
+// ForwarderConfig configures a Forwarder.
+type ForwarderConfig struct {
+	// URL is the webhook the batches are posted to.
+	URL string
+	// Header holds additional headers of the requests, e.g. for
+	// authorization.
+	Header http.Header
+	// BatchSize is the maximum number of events per request, and
+	// FlushInterval the maximum time an event waits for its batch to fill
+	// up (by default DefaultForwardBatchSize and
+	// DefaultForwardFlushInterval).
+	BatchSize     int
+	FlushInterval time.Duration
+	// BufferSize is the maximum number of events waiting to be sent, beyond
+	// which further events are dropped (by default
+	// DefaultForwardBufferSize).
+	BufferSize int
+	// Retry is the policy for retrying the requests of a batch (by default
+	// DefaultForwardRetry), after which the batch is dropped.
+	Retry *bloom.RetryPolicy
+	// Client makes the requests, or http.DefaultClient if nil.
+	Client *http.Client
+	// OnError, if set, is called with the error of each dropped batch and
+	// the number of its events.
+	OnError func(err error, events int)
+}
+
+// ForwarderStats counts the events of a Forwarder.
+type ForwarderStats struct {
+	// Forwarded is the number of events posted successfully.
+	Forwarded uint64
+	// Dropped is the number of events dropped because the buffer was full
+	// or their batch could not be posted.
+	Dropped uint64
+	// FailedBatches is the number of batches that could not be posted.
+	FailedBatches uint64
+}
+
+// Forwarder posts matched values to a webhook in batches of JSON arrays of
+// MatchEvents. Forward never blocks: the events wait in a bounded buffer while
+// a batch is posted (and retried), and are dropped and counted if the buffer
+// is full, so that a webhook that is slow or down does not hold up matching.
+// It is safe for concurrent use.
+type Forwarder struct {
+	// accessed atomically, first words for 64-bit alignment
+	forwarded, dropped, failedBatches uint64
+
+	config ForwarderConfig
+	retry  bloom.RetryPolicy
+	events chan MatchEvent
+	done   chan struct{}
+	ctx    context.Context
+	cancel func()
+
+	// mu guards closed, which is set once events is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewForwarder returns a Forwarder posting to the webhook of the
+// configuration, which is started right away and must be closed.
+func NewForwarder(config ForwarderConfig) (*Forwarder, error) {
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return nil, fmt.Errorf("invalid webhook URL %q (expected http or https)", config.URL)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultForwardBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultForwardFlushInterval
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = DefaultForwardBufferSize
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+	f := &Forwarder{
+		config: config,
+		retry:  DefaultForwardRetry,
+		events: make(chan MatchEvent, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	if config.Retry != nil {
+		f.retry = *config.Retry
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	go f.run()
+	return f, nil
+}
+
+// Forward queues an event to be posted and returns false if it was dropped
+// because the buffer is full or the forwarder is closed.
+func (f *Forwarder) Forward(event MatchEvent) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if !f.closed {
+		select {
+		case f.events <- event:
+			return true
+		default:
+		}
+	}
+	atomic.AddUint64(&f.dropped, 1)
+	return false
+}
+
+// Stats returns the counts of the events so far.
+func (f *Forwarder) Stats() ForwarderStats {
+	return ForwarderStats{
+		Forwarded:     atomic.LoadUint64(&f.forwarded),
+		Dropped:       atomic.LoadUint64(&f.dropped),
+		FailedBatches: atomic.LoadUint64(&f.failedBatches),
+	}
+}
+
+// Close posts the events waiting in the buffer, retrying until ctx is done,
+// stops the forwarder and returns the final counts. Events forwarded
+// afterwards are dropped.
+func (f *Forwarder) Close(ctx context.Context) ForwarderStats {
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.events)
+	}
+	f.mu.Unlock()
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		// the retries of the remaining batches end early
+		f.cancel()
+		<-f.done
+	}
+	f.cancel()
+	return f.Stats()
+}
+
+// run collects the events into batches, which are posted when they are full
+// or the flush interval passed, until the events are closed.
+func (f *Forwarder) run() {
+	defer close(f.done)
+	ticker := time.NewTicker(f.config.FlushInterval)
+	defer ticker.Stop()
+	var batch []MatchEvent
+	for {
+		select {
+		case event, ok := <-f.events:
+			if !ok {
+				f.post(batch)
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) < f.config.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		f.post(batch)
+		batch = nil
+	}
+}
+
+// post posts a batch with retries, dropping it if that fails.
+func (f *Forwarder) post(batch []MatchEvent) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(batch)
+	if err == nil {
+		err = f.retry.Do(f.ctx, func(ctx context.Context) error {
+			return f.attempt(ctx, body)
+		})
+	}
+	if err != nil {
+		atomic.AddUint64(&f.dropped, uint64(len(batch)))
+		atomic.AddUint64(&f.failedBatches, 1)
+		if f.config.OnError != nil {
+			f.config.OnError(err, len(batch))
+		}
+		return
+	}
+	atomic.AddUint64(&f.forwarded, uint64(len(batch)))
+}
+
+// attempt makes a single request posting a batch.
+func (f *Forwarder) attempt(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, f.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range f.config.Header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := f.config.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("posting to %s: %w", f.config.URL, &bloom.HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	return nil
+}
+
+// ParseHeader parses a header given as "Name: value".
+func ParseHeader(s string) (name, value string, err error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid header %q (expected 'Name: value')", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
@@ -0,0 +1,236 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// webhook records the batches posted to it and fails the first failures
+// requests.
+type webhook struct {
+	mu       sync.Mutex
+	batches  [][]MatchEvent
+	headers  []http.Header
+	requests int
+	failures int
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.requests++
+	if h.requests <= h.failures {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var batch []MatchEvent
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.batches = append(h.batches, batch)
+	h.headers = append(h.headers, r.Header)
+}
+
+func (h *webhook) sizes() []int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var sizes []int
+	for _, batch := range h.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+var fastRetry = bloom.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+func TestForwarderBatches(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	forwarder, err := NewForwarder(ForwarderConfig{
+		URL:           server.URL,
+		Header:        http.Header{"Authorization": {"Bearer token"}},
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+		Retry:         &fastRetry,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if !forwarder.Forward(MatchEvent{Time: time.Unix(int64(i), 0).UTC(), Value: strconv.Itoa(i), Line: i, Filter: "f"}) {
+			t.Fatalf("event %d dropped", i)
+		}
+	}
+	stats := forwarder.Close(context.Background())
+	if stats != (ForwarderStats{Forwarded: 5}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if sizes := hook.sizes(); len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("unexpected batch sizes %v", sizes)
+	}
+	if event := hook.batches[2][0]; event.Value != "5" || event.Line != 5 || event.Filter != "f" || !event.Time.Equal(time.Unix(5, 0)) {
+		t.Errorf("unexpected event %+v", event)
+	}
+	for _, header := range hook.headers {
+		if header.Get("Authorization") != "Bearer token" || header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected headers %v", header)
+		}
+	}
+	if forwarder.Forward(MatchEvent{Value: "late"}) {
+		t.Error("event forwarded after close")
+	}
+}
+
+func TestForwarderFlushInterval(t *testing.T) {
+	hook := &webhook{}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	forwarder, err := NewForwarder(ForwarderConfig{URL: server.URL, FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer forwarder.Close(context.Background())
+	forwarder.Forward(MatchEvent{Value: "foo"})
+	deadline := time.Now().Add(5 * time.Second)
+	for forwarder.Stats().Forwarded != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("event not flushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestForwarderRetries(t *testing.T) {
+	hook := &webhook{failures: 2}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	forwarder, err := NewForwarder(ForwarderConfig{URL: server.URL, Retry: &fastRetry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder.Forward(MatchEvent{Value: "foo"})
+	if stats := forwarder.Close(context.Background()); stats != (ForwarderStats{Forwarded: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if hook.requests != 3 {
+		t.Errorf("expected 3 requests, got %d", hook.requests)
+	}
+}
+
+func TestForwarderDropsFailedBatches(t *testing.T) {
+	hook := &webhook{failures: 100}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	var errs, events int
+	forwarder, err := NewForwarder(ForwarderConfig{
+		URL:       server.URL,
+		BatchSize: 2,
+		Retry:     &fastRetry,
+		OnError: func(err error, n int) {
+			errs++
+			events += n
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		forwarder.Forward(MatchEvent{Value: "foo"})
+	}
+	if stats := forwarder.Close(context.Background()); stats != (ForwarderStats{Dropped: 3, FailedBatches: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if errs != 2 || events != 3 {
+		t.Errorf("OnError called %d times for %d events", errs, events)
+	}
+	if hook.requests != 6 {
+		t.Errorf("expected 6 requests, got %d", hook.requests)
+	}
+}
+
+func TestForwarderDropsWhenFull(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	forwarder, err := NewForwarder(ForwarderConfig{URL: server.URL, BatchSize: 1, BufferSize: 2, Retry: &fastRetry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first event is being posted, two wait in the buffer
+	forwarder.Forward(MatchEvent{Value: "0"})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(forwarder.events) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first event not taken")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	accepted := 0
+	for i := 0; i < 10; i++ {
+		if forwarder.Forward(MatchEvent{Value: "foo"}) {
+			accepted++
+		}
+	}
+	if accepted != 2 {
+		t.Errorf("expected 2 accepted events, got %d", accepted)
+	}
+	close(release)
+	if stats := forwarder.Close(context.Background()); stats != (ForwarderStats{Forwarded: 3, Dropped: 8}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestForwarderCloseDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	forwarder, err := NewForwarder(ForwarderConfig{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forwarder.Forward(MatchEvent{Value: "foo"})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stats := forwarder.Close(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("close took %v", elapsed)
+	}
+	if stats != (ForwarderStats{Dropped: 1, FailedBatches: 1}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	name, value, err := ParseHeader("Authorization: Bearer a:b")
+	if err != nil || name != "Authorization" || value != "Bearer a:b" {
+		t.Errorf("unexpected header %q: %q, %v", name, value, err)
+	}
+	for _, s := range []string{"", "foo", ": bar"} {
+		if _, _, err := ParseHeader(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestNewForwarderInvalidURL(t *testing.T) {
+	if _, err := NewForwarder(ForwarderConfig{URL: "ftp://example.com"}); err == nil {
+		t.Error("expected error")
+	}
+}
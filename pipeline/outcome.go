@@ -0,0 +1,86 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// CheckOutcome is the result of checking a value derived from an input line.
+type CheckOutcome struct {
+	Value []byte
+	// SourceFile is the name of the input (see Driver.SourceFile), and Line
+	// the number of the line of the value, starting at 1.
+	SourceFile string
+	Line       int
+	Matched    bool
+	// MatchedFilters are the names of the filters the value matched, if
+	// several filters are checked.
+	MatchedFilters []string
+	// Tags are the tags the value was probably added with, if it matched a
+	// filter with tags (see bloom.TaggedFilter).
+	Tags []string
+	// Score is the percentage of the bits of the value that are set in the
+	// filter, or in the filter it matched best, if the CheckFunc computes it.
+	Score int
+}
+
+// CheckFunc checks a value and returns the outcome, of which the Driver fills
+// in Value, SourceFile and Line.
+type CheckFunc func(value []byte) CheckOutcome
+
+// LineTooLongError is returned by a Driver for a line longer than the maximum
+// token size of bufio.Scanner, which ends the input.
+type LineTooLongError struct {
+	SourceFile string
+	// Line is the number of the line, starting at 1, and Offset the number of
+	// input bytes before it.
+	Line   int
+	Offset int64
+}
+
+func (e *LineTooLongError) Error() string {
+	return locate(e.SourceFile, e.Line) + fmt.Sprintf("line too long (at byte %d)", e.Offset)
+}
+
+func (e *LineTooLongError) Unwrap() error {
+	return bufio.ErrTooLong
+}
+
+// locate returns the prefix of an error message for a line of an input.
+func locate(sourceFile string, line int) string {
+	if sourceFile == "" {
+		return fmt.Sprintf("line %d: ", line)
+	}
+	return fmt.Sprintf("%s: line %d: ", sourceFile, line)
+}
+
+// CheckOutcomes checks each value derived from the input using check, and
+// calls emit with each line and the outcomes of its values, until the input
+// ends or emit returns an error, which is returned.
+func (d *Driver) CheckOutcomes(input io.Reader, check CheckFunc, emit func(line string, outcomes []CheckOutcome) error) (Stats, error) {
+	return d.run(input, func(number int, line string, values []string) error {
+		outcomes := make([]CheckOutcome, len(values))
+		for i, value := range values {
+			outcomes[i] = check([]byte(value))
+			outcomes[i].Value = []byte(value)
+			outcomes[i].SourceFile = d.SourceFile
+			outcomes[i].Line = number
+		}
+		return emit(line, outcomes)
+	})
+}
+
+// Matches returns the values of the outcomes and whether they matched.
+func Matches(outcomes []CheckOutcome) ([]string, []bool) {
+	values := make([]string, len(outcomes))
+	matched := make([]bool, len(outcomes))
+	for i, outcome := range outcomes {
+		values[i] = string(outcome.Value)
+		matched[i] = outcome.Matched
+	}
+	return values, matched
+}
@@ -0,0 +1,72 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDriverCheckOutcomes(t *testing.T) {
+	driver := Driver{Pipeline: Pipeline{Split(",")}, SourceFile: "input.txt"}
+	check := func(value []byte) CheckOutcome {
+		if string(value) == "x" {
+			return CheckOutcome{Matched: true, MatchedFilters: []string{"a", "b"}, Score: 100}
+		}
+		return CheckOutcome{Score: 50}
+	}
+	var outcomes []CheckOutcome
+	stats, err := driver.CheckOutcomes(strings.NewReader("x,y\n\nz,x\r\n"), check, func(line string, lineOutcomes []CheckOutcome) error {
+		outcomes = append(outcomes, lineOutcomes...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []CheckOutcome{
+		{Value: []byte("x"), SourceFile: "input.txt", Line: 1, Matched: true, MatchedFilters: []string{"a", "b"}, Score: 100},
+		{Value: []byte("y"), SourceFile: "input.txt", Line: 1, Score: 50},
+		{Value: []byte(""), SourceFile: "input.txt", Line: 2, Score: 50},
+		{Value: []byte("z"), SourceFile: "input.txt", Line: 3, Score: 50},
+		{Value: []byte("x"), SourceFile: "input.txt", Line: 3, Matched: true, MatchedFilters: []string{"a", "b"}, Score: 100},
+	}
+	if !reflect.DeepEqual(outcomes, expected) {
+		t.Fatalf("unexpected outcomes %+v", outcomes)
+	}
+	if stats != (Stats{Lines: 3, StrippedCR: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	values, matched := Matches(expected[:2])
+	if !reflect.DeepEqual(values, []string{"x", "y"}) || !reflect.DeepEqual(matched, []bool{true, false}) {
+		t.Fatalf("unexpected matches %q %v", values, matched)
+	}
+}
+
+func TestDriverErrorLocations(t *testing.T) {
+	driver := Driver{Decoding: Hex, SourceFile: "input.txt"}
+	noop := func(line string, outcomes []CheckOutcome) error { return nil }
+	_, err := driver.CheckOutcomes(strings.NewReader("00\nzz\n"), func([]byte) CheckOutcome { return CheckOutcome{} }, noop)
+	var invalid *InvalidValueError
+	if !errors.As(err, &invalid) || invalid.SourceFile != "input.txt" || invalid.Line != 2 ||
+		!strings.HasPrefix(err.Error(), "input.txt: line 2: invalid hex value") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	driver = Driver{}
+	input := "short\n" + strings.Repeat("x", bufio.MaxScanTokenSize+1) + "\n"
+	var lines int
+	_, err = driver.CheckOutcomes(strings.NewReader(input), func([]byte) CheckOutcome { return CheckOutcome{} },
+		func(string, []CheckOutcome) error {
+			lines++
+			return nil
+		})
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) || !errors.Is(err, bufio.ErrTooLong) || lines != 1 ||
+		*tooLong != (LineTooLongError{Line: 2, Offset: 6}) || err.Error() != "line 2: line too long (at byte 6)" {
+		t.Fatalf("unexpected error %v after %d lines", err, lines)
+	}
+}
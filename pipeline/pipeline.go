@@ -0,0 +1,241 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package pipeline transforms input lines into the values that are added to
+// or checked against Bloom filters, e.g. by splitting lines into fields,
+// selecting some of them and normalizing them. It is used by the bloom
+// command line tool and can be used to process input in the same way.
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/DCSO/bloom"
+)
+
+// Transformer maps the values derived from an input line to new values.
+type Transformer interface {
+	Transform(values []string) []string
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(values []string) []string
+
+// Transform calls f(values).
+func (f TransformerFunc) Transform(values []string) []string {
+	return f(values)
+}
+
+// Pipeline applies transformers in order. A Pipeline is itself a
+// Transformer, so pipelines can be composed.
+type Pipeline []Transformer
+
+// Transform applies the transformers of the pipeline to the values in order.
+func (p Pipeline) Transform(values []string) []string {
+	for _, t := range p {
+		values = t.Transform(values)
+	}
+	return values
+}
+
+// Values returns the values derived from a line.
+func (p Pipeline) Values(line string) []string {
+	return p.Transform([]string{line})
+}
+
+// Split splits each value into the fields separated by the delimiter.
+func Split(delimiter string) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		if len(values) == 1 {
+			return strings.Split(values[0], delimiter)
+		}
+		var fields []string
+		for _, value := range values {
+			fields = append(fields, strings.Split(value, delimiter)...)
+		}
+		return fields
+	})
+}
+
+// SelectFields keeps the values at the given indexes, where negative indexes
+// count from the end (-1 being the last value). The values keep their order,
+// and each is kept once even if several indexes refer to it.
+func SelectFields(indexes []int) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		var selected []string
+		for i, value := range values {
+			j := i - len(values)
+			for _, index := range indexes {
+				if index == i || index == j {
+					selected = append(selected, value)
+					break
+				}
+			}
+		}
+		return selected
+	})
+}
+
+// Tuple replaces the values by a single composite key, encoded with
+// bloom.EncodeTuple, of the values at the given indexes in the given order,
+// where negative indexes count from the end. If an index is out of range, no
+// value is returned.
+func Tuple(indexes []int) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		parts := make([][]byte, len(indexes))
+		for i, index := range indexes {
+			if index < 0 {
+				index += len(values)
+			}
+			if index < 0 || index >= len(values) {
+				return nil
+			}
+			parts[i] = []byte(values[index])
+		}
+		return []string{string(bloom.EncodeTuple(parts...))}
+	})
+}
+
+// Normalize applies a normalizer to each value. Values for which it returns
+// nil are dropped.
+func Normalize(normalizer bloom.Normalizer) Transformer {
+	return TransformerFunc(func(values []string) []string {
+		var normalized []string
+		for _, value := range values {
+			if n := normalizer([]byte(value)); n != nil {
+				normalized = append(normalized, string(n))
+			}
+		}
+		return normalized
+	})
+}
+
+// Regex replaces each value by the matches of the regular expression in it:
+// the first capturing group if the expression has one, the whole match
+// otherwise. Values without a match are dropped.
+func Regex(pattern string) (Transformer, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	group := 0
+	if re.NumSubexp() > 0 {
+		group = 1
+	}
+	return TransformerFunc(func(values []string) []string {
+		var matches []string
+		for _, value := range values {
+			for _, match := range re.FindAllStringSubmatch(value, -1) {
+				matches = append(matches, match[group])
+			}
+		}
+		return matches
+	}), nil
+}
+
+// jsonStep is a step of a JSON path: an object key, an array index, or all
+// array elements (index -1).
+type jsonStep struct {
+	key   string
+	index int
+	isKey bool
+}
+
+// parseJSONPath parses paths like "$.a.b[0]" or "a.b[*].c".
+func parseJSONPath(path string) ([]jsonStep, error) {
+	path = strings.TrimPrefix(path, "$")
+	var steps []jsonStep
+	for len(path) > 0 {
+		switch path[0] {
+		case '.':
+			path = path[1:]
+			end := strings.IndexAny(path, ".[")
+			if end < 0 {
+				end = len(path)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key in JSON path")
+			}
+			steps = append(steps, jsonStep{key: path[:end], isKey: true})
+			path = path[end:]
+		case '[':
+			end := strings.IndexByte(path, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated index in JSON path")
+			}
+			index := -1
+			if path[1:end] != "*" {
+				var err error
+				if index, err = strconv.Atoi(path[1:end]); err != nil || index < 0 {
+					return nil, fmt.Errorf("invalid index %q in JSON path", path[1:end])
+				}
+			}
+			steps = append(steps, jsonStep{index: index})
+			path = path[end+1:]
+		default:
+			if len(steps) > 0 {
+				return nil, fmt.Errorf("unexpected %q in JSON path", path)
+			}
+			path = "." + path
+		}
+	}
+	return steps, nil
+}
+
+// JSONPath parses each value as JSON and replaces it by the elements selected
+// by the path, e.g. "$.query.name" or "$.answers[*].data". Keys are separated
+// by dots, array elements are selected by [index] or by [*] for all elements.
+// Selected strings are used as is, other elements in their JSON encoding.
+// Values that are not valid JSON or lack the selected elements are dropped.
+func JSONPath(path string) (Transformer, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return TransformerFunc(func(values []string) []string {
+		var selected []string
+		for _, value := range values {
+			decoder := json.NewDecoder(strings.NewReader(value))
+			decoder.UseNumber()
+			var document interface{}
+			if decoder.Decode(&document) != nil {
+				continue
+			}
+			selected = appendJSON(selected, document, steps)
+		}
+		return selected
+	}), nil
+}
+
+func appendJSON(values []string, element interface{}, steps []jsonStep) []string {
+	if len(steps) == 0 {
+		if s, ok := element.(string); ok {
+			return append(values, s)
+		}
+		encoded, _ := json.Marshal(element)
+		return append(values, string(encoded))
+	}
+	step := steps[0]
+	switch e := element.(type) {
+	case map[string]interface{}:
+		if child, ok := e[step.key]; ok && step.isKey {
+			return appendJSON(values, child, steps[1:])
+		}
+	case []interface{}:
+		if step.isKey {
+			break
+		}
+		if step.index < 0 {
+			for _, child := range e {
+				values = appendJSON(values, child, steps[1:])
+			}
+		} else if step.index < len(e) {
+			values = appendJSON(values, e[step.index], steps[1:])
+		}
+	}
+	return values
+}
@@ -0,0 +1,141 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/DCSO/bloom"
+)
+
+func TestSplit(t *testing.T) {
+	values := Split(",").Transform([]string{"a,b", "c", ""})
+	if !reflect.DeepEqual(values, []string{"a", "b", "c", ""}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if values := Split(";").Transform([]string{"a;;b"}); !reflect.DeepEqual(values, []string{"a", "", "b"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	values := []string{"a", "b", "c", "d"}
+	for _, c := range []struct {
+		indexes  []int
+		expected []string
+	}{
+		{[]int{0}, []string{"a"}},
+		{[]int{-1}, []string{"d"}},
+		{[]int{2, 0}, []string{"a", "c"}},
+		{[]int{1, -3}, []string{"b"}},
+		{[]int{4, -5}, nil},
+	} {
+		if selected := SelectFields(c.indexes).Transform(values); !reflect.DeepEqual(selected, c.expected) {
+			t.Errorf("%v: unexpected values %q", c.indexes, selected)
+		}
+	}
+}
+
+func TestTuple(t *testing.T) {
+	values := []string{"a", "b", "c", "d"}
+	for _, c := range []struct {
+		indexes  []int
+		expected []string
+	}{
+		{[]int{0, 3}, []string{string(bloom.EncodeTuple([]byte("a"), []byte("d")))}},
+		{[]int{-1, 0}, []string{string(bloom.EncodeTuple([]byte("d"), []byte("a")))}},
+		{[]int{0, 4}, nil},
+	} {
+		if tuple := Tuple(c.indexes).Transform(values); !reflect.DeepEqual(tuple, c.expected) {
+			t.Errorf("%v: unexpected values %q", c.indexes, tuple)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	lower := Normalize(func(value []byte) []byte {
+		if len(value) == 0 {
+			return nil
+		}
+		return bytes.ToLower(value)
+	})
+	if values := lower.Transform([]string{"Foo", "", "BAR"}); !reflect.DeepEqual(values, []string{"foo", "bar"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+}
+
+func TestRegex(t *testing.T) {
+	whole, err := Regex(`[0-9]+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := whole.Transform([]string{"a1b22", "none", "333"}); !reflect.DeepEqual(values, []string{"1", "22", "333"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	group, err := Regex(`host=([^ ]+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := group.Transform([]string{"host=foo x host=bar"}); !reflect.DeepEqual(values, []string{"foo", "bar"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if _, err := Regex(`(`); err == nil {
+		t.Fatal("invalid expression should fail")
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	document := `{"query": {"name": "example.com", "type": 1}, "answers": [{"data": "1.2.3.4"}, {"data": "5.6.7.8"}], "ok": true}`
+	for path, expected := range map[string][]string{
+		"$.query.name":       {"example.com"},
+		"query.type":         {"1"},
+		"$.answers[*].data":  {"1.2.3.4", "5.6.7.8"},
+		"$.answers[1].data":  {"5.6.7.8"},
+		"$.answers[2].data":  nil,
+		"$.ok":               {"true"},
+		"$.query":            {`{"name":"example.com","type":1}`},
+		"$.missing.name":     nil,
+		"$.query.name[0]":    nil,
+		"$.answers.data":     nil,
+		"$.answers[0]":       {`{"data":"1.2.3.4"}`},
+		"$.answers[*].other": nil,
+	} {
+		transformer, err := JSONPath(path)
+		if err != nil {
+			t.Fatalf("%s: %s", path, err)
+		}
+		if values := transformer.Transform([]string{document, "not json"}); !reflect.DeepEqual(values, expected) {
+			t.Errorf("%s: unexpected values %q", path, values)
+		}
+	}
+	for _, path := range []string{"$.a..b", "$.a[", "$.a[-1]", "$.a[x]", "$.a[0]b"} {
+		if _, err := JSONPath(path); err == nil {
+			t.Errorf("invalid path %s should fail", path)
+		}
+	}
+}
+
+func TestPipelineOrder(t *testing.T) {
+	numbers, _ := Regex(`[0-9]+`)
+	// selecting fields before extracting numbers differs from extracting
+	// numbers before selecting fields
+	selectFirst := Pipeline{Split(","), SelectFields([]int{-1}), numbers}
+	if values := selectFirst.Values("a1,b2c3,x"); values != nil {
+		t.Fatalf("unexpected values %q", values)
+	}
+	extractFirst := Pipeline{Split(","), numbers, SelectFields([]int{-1})}
+	if values := extractFirst.Values("a1,b2c3,x"); !reflect.DeepEqual(values, []string{"3"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	// pipelines compose
+	nested := Pipeline{Pipeline{Split(",")}, Pipeline{SelectFields([]int{0})}}
+	if values := nested.Values("a,b"); !reflect.DeepEqual(values, []string{"a"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+	if values := (Pipeline{}).Values("a,b"); !reflect.DeepEqual(values, []string{"a,b"}) {
+		t.Fatalf("unexpected values %q", values)
+	}
+}
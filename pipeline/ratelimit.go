@@ -0,0 +1,160 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate is a limit of the throughput of a Driver, in lines or bytes (including
+// line endings) per second.
+type Rate struct {
+	PerSecond float64
+	Bytes     bool
+}
+
+// ParseRate parses a rate of lines per second (e.g. "5000" or "5000/s") or
+// of bytes per second with a unit of ParseByteSize that ends in "B" (e.g.
+// "20MB/s" or "20MiB/s", which are the same).
+func ParseRate(s string) (Rate, error) {
+	v, factor, unit := splitByteUnit(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	rate := Rate{Bytes: unit != ""}
+	perSecond, err := strconv.ParseFloat(v, 64)
+	if err != nil || !(perSecond > 0) || math.IsInf(perSecond, 0) || (unit != "" && !strings.HasSuffix(strings.ToUpper(unit), "B")) {
+		return Rate{}, fmt.Errorf("invalid rate %q (expected lines per second or e.g. 20MB/s)", s)
+	}
+	rate.PerSecond = perSecond * float64(factor)
+	return rate, nil
+}
+
+// String formats the rate like ParseRate accepts it.
+func (r Rate) String() string {
+	if r.Bytes {
+		return strconv.FormatFloat(r.PerSecond, 'g', -1, 64) + "B/s"
+	}
+	return strconv.FormatFloat(r.PerSecond, 'g', -1, 64) + "/s"
+}
+
+// rateLimitBurst is the time for which a RateLimiter accumulates unused
+// throughput.
+const rateLimitBurst = 100 * time.Millisecond
+
+// RateLimiter limits the throughput of lines to a Rate with a token bucket,
+// which holds the throughput of up to rateLimitBurst. It is safe for
+// concurrent use, so that a single limiter bounds the total throughput of
+// several inputs.
+type RateLimiter struct {
+	rate Rate
+	now  func() time.Time
+	// sleep waits for the given duration or until the context is done
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu sync.Mutex
+	// tokens are the tokens available at last, negative if lines wait for
+	// tokens to be added
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter for the given rate.
+func NewRateLimiter(rate Rate) (*RateLimiter, error) {
+	return newRateLimiter(rate, time.Now, sleepContext)
+}
+
+func newRateLimiter(rate Rate, now func() time.Time, sleep func(ctx context.Context, d time.Duration) error) (*RateLimiter, error) {
+	if !(rate.PerSecond > 0) || math.IsInf(rate.PerSecond, 0) {
+		return nil, fmt.Errorf("invalid rate %g", rate.PerSecond)
+	}
+	// the bucket starts full
+	tokens := rate.PerSecond * rateLimitBurst.Seconds()
+	return &RateLimiter{rate: rate, now: now, sleep: sleep, tokens: tokens, last: now()}, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Rate returns the rate of the limiter.
+func (l *RateLimiter) Rate() Rate {
+	return l.rate
+}
+
+// reserve takes n tokens and returns how long to wait until they are
+// available.
+func (l *RateLimiter) reserve(n float64) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	burst := l.rate.PerSecond * rateLimitBurst.Seconds()
+	l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*l.rate.PerSecond)
+	l.last = now
+	l.tokens -= n
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate.PerSecond * float64(time.Second))
+}
+
+// WaitLine waits until the line may be processed, or until the context is
+// done, in which case its error is returned. The line counts as its length
+// plus one byte for the line ending for byte rates.
+func (l *RateLimiter) WaitLine(ctx context.Context, line string) error {
+	n := 1.0
+	if l.rate.Bytes {
+		n = float64(len(line) + 1)
+	}
+	if d := l.reserve(n); d > 0 {
+		return l.sleep(ctx, d)
+	}
+	return ctx.Err()
+}
+
+// niceChunkSize is the maximum size of the reads of a niceReader.
+const niceChunkSize = 64 << 10
+
+// maxNicePause is the longest pause of a niceReader, so that a read that
+// blocked waiting for input, e.g. from a pipe, does not delay the next one as
+// long.
+const maxNicePause = time.Second
+
+// niceReader reads in chunks of at most niceChunkSize, pausing after each
+// read for as long as it took, but at most maxNicePause, so that at most half
+// of the time is spent waiting for the underlying reader, e.g. a disk shared
+// with other workloads. The pauses end early once ctx is done.
+type niceReader struct {
+	r     io.Reader
+	ctx   context.Context
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (r niceReader) Read(p []byte) (int, error) {
+	if len(p) > niceChunkSize {
+		p = p[:niceChunkSize]
+	}
+	start := r.now()
+	n, err := r.r.Read(p)
+	pause := r.now().Sub(start)
+	if pause > maxNicePause {
+		pause = maxNicePause
+	}
+	// an interrupted pause is noticed by the Driver, which checks ctx after
+	// each line
+	r.sleep(r.ctx, pause)
+	return n, err
+}
@@ -0,0 +1,208 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		expected Rate
+	}{
+		{"5000", Rate{5000, false}},
+		{"2.5/s", Rate{2.5, false}},
+		{"20MB/s", Rate{20 << 20, true}},
+		{"512kb", Rate{512 << 10, true}},
+		{"1 GB/s", Rate{1 << 30, true}},
+		{"100B/s", Rate{100, true}},
+		{"2MiB/s", Rate{2 << 20, true}},
+	} {
+		rate, err := ParseRate(c.s)
+		if err != nil || rate != c.expected {
+			t.Errorf("ParseRate(%q) = %v, %v, expected %v", c.s, rate, err, c.expected)
+		}
+		if parsed, err := ParseRate(rate.String()); err != nil || parsed != rate {
+			t.Errorf("%v does not round-trip: %v, %v", rate, parsed, err)
+		}
+	}
+	for _, s := range []string{"", "0", "-5", "fast", "MB/s", "Inf", "NaN", "5K/s"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q): expected an error", s)
+		}
+	}
+}
+
+// fakeClock is a clock that only advances by sleeping.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	return ctx.Err()
+}
+
+func TestRateLimiter(t *testing.T) {
+	for _, c := range []struct {
+		rate  Rate
+		lines int
+		line  string
+		// expected is the duration to process the lines, which exceeds
+		// their number divided by the rate by the burst
+		expected time.Duration
+	}{
+		{Rate{1000, false}, 10000, "foo", 9900 * time.Millisecond},
+		{Rate{4000, true}, 10000, "foo", 9900 * time.Millisecond},
+		{Rate{1e9, false}, 10000, "foo", 0},
+	} {
+		clock := &fakeClock{now: time.Unix(0, 0)}
+		limiter, err := newRateLimiter(c.rate, clock.Now, clock.Sleep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < c.lines; i++ {
+			if err := limiter.WaitLine(context.Background(), c.line); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed < c.expected-time.Millisecond || elapsed > c.expected+time.Millisecond {
+			t.Errorf("%v: %d lines took %s, expected %s", c.rate, c.lines, elapsed, c.expected)
+		}
+	}
+	if _, err := NewRateLimiter(Rate{}); err == nil {
+		t.Fatal("expected an error for a zero rate")
+	}
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	limiter, err := NewRateLimiter(Rate{PerSecond: 2000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 250; i++ {
+				limiter.WaitLine(context.Background(), "foo")
+			}
+		}()
+	}
+	wg.Wait()
+	// 1000 lines at 2000 lines per second, minus the initial burst
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("1000 lines took %s", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter, err := NewRateLimiter(Rate{PerSecond: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := limiter.WaitLine(ctx, "foo"); err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	}
+}
+
+func TestDriverLimiter(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter, err := newRateLimiter(Rate{PerSecond: 10}, clock.Now, clock.Sleep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	driver := Driver{Limiter: limiter, Context: ctx, NiceIO: true}
+	var lines []string
+	stats, err := driver.Run(strings.NewReader("a\nb\nc\nd\n"), func(line string, values []string) error {
+		lines = append(lines, line)
+		if line == "b" {
+			cancel()
+		}
+		return nil
+	})
+	if err != nil || !stats.Stopped || stats.Lines != 2 || len(lines) != 2 {
+		t.Fatalf("unexpected result %+v, %q, %v", stats, lines, err)
+	}
+	if elapsed := clock.Now().Sub(time.Unix(0, 0)); elapsed != 100*time.Millisecond {
+		t.Fatalf("2 lines at 10 lines per second took %s", elapsed)
+	}
+}
+
+func TestNiceReader(t *testing.T) {
+	var slept time.Duration
+	now := time.Unix(0, 0)
+	step := time.Millisecond
+	r := niceReader{
+		r:   strings.NewReader(strings.Repeat("x", 3*niceChunkSize)),
+		ctx: context.Background(),
+		now: func() time.Time {
+			now = now.Add(step)
+			return now
+		},
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept += d
+			return nil
+		},
+	}
+	n, err := r.Read(make([]byte, 2*niceChunkSize))
+	if n != niceChunkSize || err != nil {
+		t.Fatalf("unexpected read %d, %v", n, err)
+	}
+	if slept != time.Millisecond {
+		t.Fatalf("slept %s after a read taking 1ms", slept)
+	}
+
+	// long reads, e.g. waiting for a pipe, pause for at most maxNicePause
+	slept, step = 0, time.Minute
+	if _, err := r.Read(make([]byte, niceChunkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if slept != maxNicePause {
+		t.Fatalf("slept %s after a read taking a minute", slept)
+	}
+}
+
+func TestNiceReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	now := time.Unix(0, 0)
+	r := niceReader{
+		r:   strings.NewReader("foo\n"),
+		ctx: ctx,
+		now: func() time.Time {
+			now = now.Add(time.Hour)
+			return now
+		},
+		sleep: sleepContext,
+	}
+	start := time.Now()
+	if n, err := r.Read(make([]byte, 16)); n != 4 || err != nil {
+		t.Fatalf("unexpected read %d, %v", n, err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("the pause was not interrupted")
+	}
+}
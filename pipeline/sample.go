@@ -0,0 +1,95 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// SampleConfidenceZ is the z-score of the confidence intervals of
+// EstimateFromSample (95%).
+const SampleConfidenceZ = 1.959964
+
+// Sampler selects a random subset of lines, each with a given probability,
+// for a limited time, e.g. to estimate the match rate of a filter on a busy
+// input without processing all of it.
+type Sampler struct {
+	rate     float64
+	rng      *rand.Rand
+	deadline time.Time
+	now      func() time.Time
+}
+
+// NewSampler returns a sampler selecting each line with the given probability
+// (in (0, 1]), chosen by a random number generator with the given seed, until
+// the given duration has passed since its creation (0 for no limit).
+func NewSampler(rate float64, duration time.Duration, seed int64) (*Sampler, error) {
+	return newSampler(rate, duration, seed, time.Now)
+}
+
+func newSampler(rate float64, duration time.Duration, seed int64, now func() time.Time) (*Sampler, error) {
+	if rate <= 0 || rate > 1 || math.IsNaN(rate) {
+		return nil, fmt.Errorf("invalid sample rate %g (must be in (0, 1])", rate)
+	}
+	if duration < 0 {
+		return nil, fmt.Errorf("invalid sample duration %s", duration)
+	}
+	s := &Sampler{rate: rate, rng: rand.New(rand.NewSource(seed)), now: now}
+	if duration > 0 {
+		s.deadline = now().Add(duration)
+	}
+	return s, nil
+}
+
+// Sample returns whether the next line is selected.
+func (s *Sampler) Sample() bool {
+	return s.rate == 1 || s.rng.Float64() < s.rate
+}
+
+// Expired returns whether the duration of the sampler has passed, after which
+// no further lines should be read.
+func (s *Sampler) Expired() bool {
+	return !s.deadline.IsZero() && !s.now().Before(s.deadline)
+}
+
+// SampleEstimate is an estimate of the number of matching lines derived from
+// a sample, see EstimateFromSample.
+type SampleEstimate struct {
+	// Lines is the number of lines read, Sampled the number of lines
+	// processed and Matched the number of matching lines processed.
+	Lines, Sampled, Matched int
+	// Rate is the estimated fraction of matching lines, within the 95%
+	// confidence interval from RateLow to RateHigh.
+	Rate, RateLow, RateHigh float64
+	// Matches is the estimated number of matching lines of all lines read,
+	// within the 95% confidence interval from MatchesLow to MatchesHigh.
+	Matches, MatchesLow, MatchesHigh float64
+}
+
+// EstimateFromSample estimates the fraction and number of matching lines of
+// all lines read from the number of lines sampled and of those that matched.
+// The confidence interval of the rate is the Wilson score interval, which
+// remains meaningful for small samples and rates close to 0 or 1. Without
+// sampled lines, nothing can be estimated, so all rates are 0 and the interval
+// of the rate is [0, 1].
+func EstimateFromSample(lines, sampled, matched int) SampleEstimate {
+	e := SampleEstimate{Lines: lines, Sampled: sampled, Matched: matched, RateHigh: 1}
+	if sampled > 0 {
+		n := float64(sampled)
+		p := float64(matched) / n
+		z2 := SampleConfidenceZ * SampleConfidenceZ
+		center := (p + z2/(2*n)) / (1 + z2/n)
+		margin := SampleConfidenceZ / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+		e.Rate = p
+		e.RateLow = math.Max(0, center-margin)
+		e.RateHigh = math.Min(1, center+margin)
+	}
+	e.Matches = e.Rate * float64(lines)
+	e.MatchesLow = e.RateLow * float64(lines)
+	e.MatchesHigh = e.RateHigh * float64(lines)
+	return e
+}
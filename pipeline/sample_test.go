@@ -0,0 +1,106 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package pipeline
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	sampled := func(seed int64) []bool {
+		s, err := NewSampler(0.1, 0, seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make([]bool, 10000)
+		for i := range result {
+			result[i] = s.Sample()
+		}
+		return result
+	}
+	a, b := sampled(1), sampled(1)
+	n := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatal("samples with the same seed differ")
+		}
+		if a[i] {
+			n++
+		}
+	}
+	if n < 900 || n > 1100 {
+		t.Fatalf("expected about 1000 sampled lines, got %d", n)
+	}
+
+	for _, rate := range []float64{0, -1, 1.5, math.NaN()} {
+		if _, err := NewSampler(rate, 0, 1); err == nil {
+			t.Errorf("expected an error for rate %g", rate)
+		}
+	}
+	if _, err := NewSampler(1, -time.Second, 1); err == nil {
+		t.Error("expected an error for a negative duration")
+	}
+}
+
+func TestSamplerDuration(t *testing.T) {
+	now := time.Unix(0, 0)
+	s, err := newSampler(1, time.Minute, 1, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Expired() || !s.Sample() {
+		t.Fatal("sampler expired early")
+	}
+	now = now.Add(time.Minute)
+	if !s.Expired() {
+		t.Fatal("sampler not expired")
+	}
+}
+
+func TestDriverSampler(t *testing.T) {
+	var input strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&input, "%d\n", i)
+	}
+	sampler, err := NewSampler(0.5, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Driver{Pipeline: Pipeline{}, Sampler: sampler}
+	processed := 0
+	stats, err := d.Run(strings.NewReader(input.String()), func(line string, values []string) error {
+		processed++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Lines != 1000 || stats.Sampled != processed || processed < 400 || processed > 600 {
+		t.Fatalf("unexpected stats %+v (processed %d)", stats, processed)
+	}
+}
+
+func TestEstimateFromSample(t *testing.T) {
+	for _, c := range []struct {
+		lines, sampled, matched int
+		rate, low, high         float64
+	}{
+		{100000, 1000, 12, 0.012, 0.0068776, 0.0208573},
+		{100, 10, 0, 0, 0, 0.2775328},
+		{100, 10, 10, 1, 0.7224672, 1},
+		{100, 0, 0, 0, 0, 1},
+	} {
+		e := EstimateFromSample(c.lines, c.sampled, c.matched)
+		if math.Abs(e.Rate-c.rate) > 1e-6 || math.Abs(e.RateLow-c.low) > 1e-6 || math.Abs(e.RateHigh-c.high) > 1e-6 {
+			t.Errorf("%+v: unexpected rates %+v", c, e)
+		}
+		if e.Matches != e.Rate*float64(c.lines) || e.MatchesLow != e.RateLow*float64(c.lines) || e.MatchesHigh != e.RateHigh*float64(c.lines) {
+			t.Errorf("%+v: unexpected matches %+v", c, e)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// gzipOverhead is the size of the gzip header and trailer, and
+// storedBlockOverhead and storedBlockSize are the overhead and the maximum
+// size of a stored (uncompressed) deflate block.
+const (
+	gzipOverhead        = 18
+	storedBlockOverhead = 5
+	storedBlockSize     = 65535
+)
+
+// Plan describes the filter New creates for a capacity and false positive
+// probability, for capacity planning.
+type Plan struct {
+	Capacity uint64
+	FPP      float64
+	// Bits and HashFuncs are the number of bits and hash functions.
+	Bits      uint64
+	HashFuncs uint64
+	// OptimalHashFuncs is the number of hash functions of the smallest
+	// filter, which is more than HashFuncs if they are capped (see
+	// WithMaxHashFuncs).
+	OptimalHashFuncs uint64
+}
+
+// DefaultMaxHashFuncs is the number of hash functions that New uses at most
+// unless configured otherwise with WithMaxHashFuncs. Only filters with a
+// false positive probability below about 1e-9 need more, whose last probes
+// cost as much time as the others but barely lower the probability.
+const DefaultMaxHashFuncs = 30
+
+// ErrTooManyHashFuncs is returned by New with a strict WithMaxHashFuncs for a
+// false positive probability that needs more hash functions than the maximum.
+var ErrTooManyHashFuncs = errors.New("too many hash functions")
+
+// hashFuncsLimit is the maximum number of hash functions of a new filter, set
+// with WithMaxHashFuncs.
+type hashFuncsLimit struct {
+	max    uint64
+	strict bool
+}
+
+// WithMaxHashFuncs sets the maximum number of hash functions of a new filter
+// (DefaultMaxHashFuncs by default, zero for no maximum). If the optimal
+// number is larger, the filter uses the maximum with as many more bits as
+// needed to keep its false positive probability, and reports this in the
+// Warnings of its Stats. With strict, New fails with ErrTooManyHashFuncs
+// instead.
+func WithMaxHashFuncs(max uint64, strict bool) Option {
+	return func(s *BloomFilter) {
+		s.hashFuncsLimit = &hashFuncsLimit{max: max, strict: strict}
+	}
+}
+
+// Capped returns true if the number of hash functions is capped below the
+// optimal one.
+func (p Plan) Capped() bool {
+	return p.HashFuncs < p.OptimalHashFuncs
+}
+
+// Warning describes why the plan may not be what was expected, i.e. that the
+// number of hash functions is capped, or returns an empty string.
+func (p Plan) Warning() string {
+	if !p.Capped() {
+		return ""
+	}
+	return hashFuncsWarning(p.FPP, p.HashFuncs, p.OptimalHashFuncs)
+}
+
+// Warnings describes why the dimensions of the filter may not be what was
+// expected for its capacity and false positive probability, e.g. that the
+// number of hash functions is capped (see WithMaxHashFuncs). It returns nil
+// if there is nothing to warn about.
+func (s *BloomFilter) Warnings() []string {
+	m, err := optimalNumBits(s.n, s.p)
+	if err != nil {
+		return nil
+	}
+	if optimal := optimalNumHashFuncs(s.n, m); s.k < optimal {
+		return []string{hashFuncsWarning(s.p, s.k, optimal)}
+	}
+	return nil
+}
+
+func hashFuncsWarning(p float64, k, optimal uint64) string {
+	return fmt.Sprintf("p = %g needs %d hash functions, capped at %d with more bits; a larger p makes checks faster", p, optimal, k)
+}
+
+// ErrInvalidFPP is returned for a false positive probability that is not
+// between 0 and 1 (exclusive), including NaN and infinite values. Subnormal
+// values are valid.
+var ErrInvalidFPP = errors.New("false positive probability must be between 0 and 1 (exclusive)")
+
+// checkFPP checks that p is a valid false positive probability.
+func checkFPP(p float64) error {
+	if !(p > 0 && p < 1) {
+		return fmt.Errorf("%w, not %g", ErrInvalidFPP, p)
+	}
+	return nil
+}
+
+// PlanFilter returns the plan of the filter New(n, p) creates, without
+// allocating it.
+func PlanFilter(n uint64, p float64) (Plan, error) {
+	return PlanFilterWithMaxHashFuncs(n, p, DefaultMaxHashFuncs)
+}
+
+// PlanFilterWithMaxHashFuncs returns the plan of the filter New(n, p,
+// WithMaxHashFuncs(max, false)) creates, without allocating it.
+func PlanFilterWithMaxHashFuncs(n uint64, p float64, max uint64) (Plan, error) {
+	if n == 0 {
+		return Plan{}, errors.New("capacity must be positive")
+	}
+	if err := checkFPP(p); err != nil {
+		return Plan{}, err
+	}
+	m, k, optimal, err := planDimensions(n, p, max)
+	if err != nil {
+		return Plan{}, err
+	}
+	return Plan{Capacity: n, FPP: p, Bits: m, HashFuncs: k, OptimalHashFuncs: optimal}, nil
+}
+
+// planDimensions returns the number of bits and hash functions of a filter
+// for n elements with false positive probability p using at most max hash
+// functions (unless zero), and the optimal number of hash functions.
+func planDimensions(n uint64, p float64, max uint64) (m, k, optimal uint64, err error) {
+	if m, err = optimalNumBits(n, p); err != nil {
+		return 0, 0, 0, err
+	}
+	optimal = optimalNumHashFuncs(n, m)
+	if max == 0 || optimal <= max {
+		return m, optimal, optimal, nil
+	}
+	// with k hash functions, a fraction 1-exp(-kn/m) of the bits is set at
+	// the capacity, which must be p^(1/k); log1p keeps the tiny fraction of
+	// bits left unset precise
+	bits := math.Ceil(-float64(max) * float64(n) / math.Log1p(-math.Pow(p, 1/float64(max))))
+	if m, err = bitsFor(bits, n, p); err != nil {
+		return 0, 0, 0, err
+	}
+	return m, max, optimal, nil
+}
+
+// optimalNumHashFuncs returns the number of hash functions of a filter with m
+// bits for n elements, or 0 if either is 0.
+func optimalNumHashFuncs(n, m uint64) uint64 {
+	if n == 0 || m == 0 {
+		return 0
+	}
+	return uint64(math.Ceil(math.Log(2) * float64(m) / float64(n)))
+}
+
+// FileSize returns the size in bytes of the uncompressed file of the filter,
+// excluding metadata and Data.
+func (p Plan) FileSize() uint64 {
+	return FormatHeaderSize + numWords(p.Bits)*8
+}
+
+// Fill returns the expected fraction of bits set once the filter holds as
+// many elements as its capacity.
+func (p Plan) Fill() float64 {
+	return -math.Expm1(-float64(p.HashFuncs) * float64(p.Capacity) / float64(p.Bits))
+}
+
+// EstimatedGzipSize approximates the size in bytes of the gzip-compressed file
+// of the filter at its capacity. The bits of a filter are close to random, so
+// the estimate is their entropy at the expected fill, but at most the size of
+// the file stored uncompressed. As the fill of an optimal filter is about one
+// half, compression barely reduces its size.
+func (p Plan) EstimatedGzipSize() uint64 {
+	raw := p.FileSize()
+	stored := raw + gzipOverhead + storedBlockOverhead*((raw+storedBlockSize-1)/storedBlockSize)
+	f := p.Fill()
+	entropy := 1.0
+	if f > 0 && f < 1 {
+		entropy = -f*math.Log2(f) - (1-f)*math.Log2(1-f)
+	}
+	compressed := FormatHeaderSize + gzipOverhead + uint64(math.Ceil(float64(numWords(p.Bits)*8)*entropy))
+	if compressed < stored {
+		return compressed
+	}
+	return stored
+}
+
+// PlanForSize returns the plan of the filter with the largest capacity for
+// false positive probability p whose file (see Plan.FileSize) is at most size
+// bytes, or an error if no such filter exists.
+func PlanForSize(size uint64, p float64) (Plan, error) {
+	if !(p > 0 && p < 1) {
+		return Plan{}, fmt.Errorf("false positive probability must be between 0 and 1 (exclusive), not %g", p)
+	}
+	if size <= FormatHeaderSize {
+		return Plan{}, fmt.Errorf("size of %d bytes is too small for a filter", size)
+	}
+	// the number of bits grows with the capacity, so search for the largest
+	// capacity that fits, starting from an upper bound for it
+	bits := float64((size-FormatHeaderSize)/8) * 64
+	high := uint64(math.Min(bits*math.Pow(math.Log(2), 2)/-math.Log(p), math.MaxUint64/4))*2 + 2
+	low := uint64(0)
+	var best Plan
+	for low+1 < high {
+		n := low + (high-low)/2
+		plan, err := PlanFilter(n, p)
+		if err != nil && !errors.Is(err, ErrFilterTooLarge) {
+			return Plan{}, err
+		}
+		if err == nil && plan.FileSize() <= size {
+			low, best = n, plan
+		} else {
+			high = n
+		}
+	}
+	if low == 0 {
+		return Plan{}, fmt.Errorf("size of %d bytes is too small for a filter with false positive probability %g", size, p)
+	}
+	return best, nil
+}
@@ -0,0 +1,235 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPlanFilter(t *testing.T) {
+	for _, c := range []struct {
+		n uint64
+		p float64
+	}{{1, 0.5}, {1000, 0.01}, {10000, 0.0001}, {123457, 0.000001}} {
+		plan, err := PlanFilter(c.n, c.p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter := mustNew(c.n, c.p)
+		if plan.Bits != filter.m || plan.HashFuncs != filter.k {
+			t.Errorf("n = %d, p = %g: planned %d bits and %d hash functions, New uses %d and %d", c.n, c.p, plan.Bits, plan.HashFuncs, filter.m, filter.k)
+		}
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if plan.FileSize() != uint64(buf.Len()) {
+			t.Errorf("n = %d, p = %g: planned a file size of %d bytes, written %d", c.n, c.p, plan.FileSize(), buf.Len())
+		}
+		if gzipSize := plan.EstimatedGzipSize(); gzipSize == 0 || gzipSize > plan.FileSize()+1000 {
+			t.Errorf("n = %d, p = %g: implausible gzip size estimate %d", c.n, c.p, gzipSize)
+		}
+	}
+	if _, err := PlanFilter(0, 0.01); err == nil {
+		t.Error("expected an error for a capacity of 0")
+	}
+	if _, err := PlanFilter(1000, 1); err == nil {
+		t.Error("expected an error for p = 1")
+	}
+}
+
+func TestPlanFilterTinyFPP(t *testing.T) {
+	// the optimal filter needs 50 hash functions
+	plan, err := PlanFilter(1e12, 1e-15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimal, err := optimalNumBits(1e12, 1e-15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plan.HashFuncs != DefaultMaxHashFuncs || plan.OptimalHashFuncs != 50 || !plan.Capped() {
+		t.Fatalf("unexpected plan %+v", plan)
+	}
+	if plan.Bits < optimal || float64(plan.Bits) > 1.1*float64(optimal) {
+		t.Fatalf("capped plan has %d bits, the optimal filter %d", plan.Bits, optimal)
+	}
+	// the capped filter keeps the false positive probability at its capacity
+	k, m := float64(plan.HashFuncs), float64(plan.Bits)
+	if fpp := math.Pow(-math.Expm1(-k*1e12/m), k); math.Abs(fpp-1e-15) > 1e-17 {
+		t.Fatalf("capped plan has a false positive probability of %g", fpp)
+	}
+	if !strings.Contains(plan.Warning(), "capped at 30") {
+		t.Fatalf("unexpected warning %q", plan.Warning())
+	}
+
+	if plan, err = PlanFilterWithMaxHashFuncs(1e12, 1e-15, 0); err != nil {
+		t.Fatal(err)
+	}
+	if plan.HashFuncs != 50 || plan.Bits != optimal || plan.Warning() != "" {
+		t.Fatalf("unexpected uncapped plan %+v", plan)
+	}
+	if plan, err = PlanFilter(1000, 0.01); err != nil || plan.Capped() || plan.Warning() != "" {
+		t.Fatalf("unexpected plan %+v (%v)", plan, err)
+	}
+}
+
+func TestNewMaxHashFuncs(t *testing.T) {
+	filter := mustNew(1000, 1e-12)
+	if filter.k != DefaultMaxHashFuncs || len(filter.Stats().Warnings) != 1 {
+		t.Fatalf("unexpected filter with %d hash functions and warnings %q", filter.k, filter.Stats().Warnings)
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var loaded BloomFilter
+	if err := loaded.Read(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.k != filter.k || loaded.m != filter.m || len(loaded.Warnings()) != 1 {
+		t.Fatalf("loaded filter has %d bits, %d hash functions and warnings %q", loaded.m, loaded.k, loaded.Warnings())
+	}
+	if c := filter.emptyCopy(); c.k != filter.k || c.m != filter.m {
+		t.Fatalf("copy has %d bits and %d hash functions", c.m, c.k)
+	}
+
+	filter, err := New(1000, 1e-12, WithMaxHashFuncs(0, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.k != 40 || filter.Warnings() != nil {
+		t.Fatalf("unexpected filter with %d hash functions and warnings %q", filter.k, filter.Warnings())
+	}
+	if filter, err = New(1000, 1e-12, WithMaxHashFuncs(45, true)); err != nil || filter.k != 40 {
+		t.Fatalf("unexpected filter %v (%v)", filter, err)
+	}
+	if _, err := New(1000, 1e-12, WithMaxHashFuncs(20, true)); !errors.Is(err, ErrTooManyHashFuncs) {
+		t.Fatalf("expected ErrTooManyHashFuncs, got %v", err)
+	}
+	if filter := mustNew(1000, 0.01); filter.Warnings() != nil {
+		t.Fatalf("unexpected warnings %q", filter.Warnings())
+	}
+	if filter := Initialize(1000, 1e-12); filter.k != DefaultMaxHashFuncs {
+		t.Fatalf("Initialize uses %d hash functions", filter.k)
+	}
+}
+
+func TestPlanForSize(t *testing.T) {
+	for _, size := range []uint64{100, 1000, 1 << 20, 512e6} {
+		for _, p := range []float64{0.1, 0.001, 0.000001} {
+			plan, err := PlanForSize(size, p)
+			if err != nil {
+				t.Fatalf("size %d, p = %g: %v", size, p, err)
+			}
+			if plan.FileSize() > size {
+				t.Errorf("size %d, p = %g: planned file of %d bytes", size, p, plan.FileSize())
+			}
+			larger, err := PlanFilter(plan.Capacity+1, p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if larger.FileSize() <= size {
+				t.Errorf("size %d, p = %g: capacity %d is not the largest", size, p, plan.Capacity)
+			}
+		}
+	}
+	if _, err := PlanForSize(FormatHeaderSize, 0.01); err == nil {
+		t.Error("expected an error for a size without room for bits")
+	}
+}
+
+// withFPP returns the serialization of a filter with p replaced by the value
+// with the given bits.
+func withFPP(t *testing.T, bits uint64) []byte {
+	var buf bytes.Buffer
+	if err := mustNew(100, 0.01).Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()
+	binary.LittleEndian.PutUint64(serialized[FormatFPPOffset:], bits)
+	return serialized
+}
+
+func TestReadInvalidFPP(t *testing.T) {
+	for _, p := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), 0, math.Copysign(0, -1), 1, -0.5, 2} {
+		serialized := withFPP(t, math.Float64bits(p))
+		if _, err := LoadFromBytes(serialized, false); !errors.Is(err, ErrInvalidFPP) {
+			t.Errorf("p = %g: expected ErrInvalidFPP, got %v", p, err)
+		}
+		if _, err := ReadHeader(bytes.NewReader(serialized)); !errors.Is(err, ErrInvalidFPP) {
+			t.Errorf("p = %g: expected ErrInvalidFPP from ReadHeader, got %v", p, err)
+		}
+	}
+	// NaN payloads other than the one of math.NaN
+	for _, bits := range []uint64{0x7ff0000000000001, 0xfff8000000000000, 0x7fffffffffffffff} {
+		if _, err := LoadFromBytes(withFPP(t, bits), false); !errors.Is(err, ErrInvalidFPP) {
+			t.Errorf("p bits %#x: expected ErrInvalidFPP, got %v", bits, err)
+		}
+	}
+}
+
+func TestReadSubnormalFPP(t *testing.T) {
+	for _, p := range []float64{math.SmallestNonzeroFloat64, 1e-310, math.Nextafter(1, 0)} {
+		filter, err := LoadFromBytes(withFPP(t, math.Float64bits(p)), false)
+		if err != nil {
+			t.Fatalf("p = %g: %v", p, err)
+		}
+		if filter.FalsePositiveProb() != p {
+			t.Fatalf("p = %g read as %g", p, filter.FalsePositiveProb())
+		}
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint64(buf.Bytes()[FormatFPPOffset:]) != math.Float64bits(p) {
+			t.Fatalf("p = %g does not round-trip", p)
+		}
+	}
+}
+
+// TestReadFPPBitPatterns reads filters with random bit patterns for p, which
+// are accepted exactly if they are valid probabilities.
+func TestReadFPPBitPatterns(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	serialized := withFPP(t, 0)
+	for i := 0; i < 2000; i++ {
+		bits := rng.Uint64()
+		if i%4 == 0 {
+			// exponents of NaN and infinity
+			bits |= 0x7ff0000000000000
+		}
+		binary.LittleEndian.PutUint64(serialized[FormatFPPOffset:], bits)
+		p := math.Float64frombits(bits)
+		filter, err := LoadFromBytes(serialized, false)
+		if valid := p > 0 && p < 1; valid != (err == nil) {
+			t.Fatalf("p bits %#x (%g): unexpected error %v", bits, p, err)
+		}
+		if err == nil && math.Float64bits(filter.FalsePositiveProb()) != bits {
+			t.Fatalf("p bits %#x read as %#x", bits, math.Float64bits(filter.FalsePositiveProb()))
+		}
+	}
+}
+
+func TestJoinInvalidFPP(t *testing.T) {
+	a := Initialize(100, 0.01)
+	b := Initialize(100, 0.01)
+	a.p, b.p = math.NaN(), math.NaN()
+	err := a.Join(&b)
+	if !errors.Is(err, ErrInvalidFPP) || !strings.Contains(err.Error(), "p = NaN vs. NaN") {
+		t.Fatalf("expected ErrInvalidFPP naming the values, got %v", err)
+	}
+	c := Initialize(100, 0.01)
+	d := Initialize(100, 0.01)
+	d.p = 0.010000001
+	if err := c.Join(&d); err == nil || !strings.Contains(err.Error(), "p = 0.01 vs. 0.010000001") {
+		t.Fatalf("expected the differing values of p, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"runtime"
+)
+
+// prefaultBatch is the number of words touched between checks for context
+// cancellation and progress reports (512 KiB).
+const prefaultBatch = 1 << 16
+
+// Prefault touches every word of the bit array so that the memory backing it
+// is resident before latency-sensitive checks start. The filter contents are
+// not modified.
+//
+// For a filter that was just loaded with Read all pages are resident already,
+// but the pages of a large filter that has been idle may be paged out under
+// memory pressure, and the first checks after that suffer page fault latency.
+// Prefaulting trades a sequential pass over the whole array (roughly m/8 bytes
+// of memory bandwidth, and of I/O if pages were swapped out) for predictable
+// check latency afterwards.
+func (s *BloomFilter) Prefault() {
+	s.prefault(context.Background(), nil)
+}
+
+// PrefaultContext is like Prefault, but stops and returns the context error if
+// the context is cancelled before all words were touched.
+func (s *BloomFilter) PrefaultContext(ctx context.Context) error {
+	return s.prefault(ctx, nil)
+}
+
+func (s *BloomFilter) prefault(ctx context.Context, progress func(done, total uint64)) error {
+	var sum uint64
+	for i := uint64(0); i < s.M; i += prefaultBatch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := i + prefaultBatch
+		if end > s.M {
+			end = s.M
+		}
+		for _, w := range s.v[i:end] {
+			sum ^= w
+		}
+		if progress != nil {
+			progress(end, s.M)
+		}
+	}
+	runtime.KeepAlive(sum)
+	return nil
+}
@@ -0,0 +1,70 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"testing"
+)
+
+func filterDigest(t *testing.T, filter *BloomFilter) [sha256.Size]byte {
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return sha256.Sum256(buf.Bytes())
+}
+
+func TestPrefault(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000000, 0.001, 1000)
+	before := filterDigest(t, filter)
+	filter.Prefault()
+	if filterDigest(t, filter) != before {
+		t.Fatal("prefaulting altered the filter")
+	}
+
+	var lastDone, lastTotal uint64
+	calls := 0
+	filter.prefault(context.Background(), func(done, total uint64) {
+		if done <= lastDone {
+			t.Fatalf("progress did not advance: %d after %d", done, lastDone)
+		}
+		lastDone, lastTotal = done, total
+		calls++
+	})
+	if lastDone != filter.M || lastTotal != filter.M {
+		t.Fatalf("prefault did not walk the entire array: %d/%d of %d", lastDone, lastTotal, filter.M)
+	}
+	if expected := int((filter.M + prefaultBatch - 1) / prefaultBatch); calls != expected {
+		t.Fatalf("unexpected number of progress reports: %d vs. %d", calls, expected)
+	}
+}
+
+func TestPrefaultContext(t *testing.T) {
+	filter := mustNew(1000000, 0.001)
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := filter.PrefaultContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := filter.PrefaultContext(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestLoadWithPrefault(t *testing.T) {
+	var done, total uint64
+	bf, err := LoadFilter("testdata/test.bloom", false, WithPrefault(func(d, t uint64) {
+		done, total = d, t
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, bf)
+	if done != bf.M || total != bf.M {
+		t.Fatalf("unexpected progress: %d/%d of %d words", done, total, bf.M)
+	}
+}
@@ -0,0 +1,101 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "strings"
+
+// Version is the version of the library, recorded as part of the producer of
+// the filters it writes.
+const Version = "0.2.4"
+
+const (
+	// MetadataKeyProducer is the producer that wrote the filter (see
+	// WithProducer), recorded by Write in version 2 of the file format.
+	MetadataKeyProducer = "bloom.producer"
+	// MetadataKeyJoinedProducers are the distinct producers of the filters
+	// joined into the filter, separated by producerSeparator.
+	MetadataKeyJoinedProducers = "bloom.joined-producers"
+)
+
+// UnknownProducer is reported as the producer of filters without a recorded
+// producer, e.g. those read from files of version 1 of the file format.
+const UnknownProducer = "unknown"
+
+const producerSeparator = "; "
+
+// producerString returns the producer recorded by Write: the library and its
+// version, preceded by the given application identifier, if any.
+func producerString(application string) string {
+	library := "DCSO/bloom " + Version
+	if application == "" {
+		return library
+	}
+	return application + " (" + library + ")"
+}
+
+// WithProducer makes Write record the given identifier of the application
+// (e.g. its name and version) along with the version of the library as the
+// producer of the filter. As the producer is stored in the metadata, the
+// filter is written in version 2 of the file format even if it has no other
+// metadata. Without this option, the producer is only recorded for filters
+// written in version 2 anyway.
+func WithProducer(application string) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.producer = application
+	})
+}
+
+// WithDefaultProducer sets the identifier of the application recorded along
+// with the version of the library as the producer of filters written in
+// version 2 of the file format, unless WithProducer is given. Unlike
+// WithProducer, it does not make Write use version 2 for filters that would
+// be written in version 1.
+func WithDefaultProducer(application string) WriteOption {
+	return writeOptionFunc(func(o *writeOptions) {
+		o.defaultProducer = application
+	})
+}
+
+// Producer returns the producer that wrote the filter that was read, or
+// UnknownProducer if none was recorded.
+func (s *BloomFilter) Producer() string {
+	if v, ok := s.meta[MetadataKeyProducer]; ok {
+		return v
+	}
+	return UnknownProducer
+}
+
+// JoinedProducers returns the distinct producers of the filters joined into
+// the filter, including its own producer at the time, in the order they were
+// joined.
+func (s *BloomFilter) JoinedProducers() []string {
+	v, ok := s.meta[MetadataKeyJoinedProducers]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, producerSeparator)
+}
+
+// recordJoinedProducers adds the producers of the receiver and of the joined
+// filter to the joined producers of the receiver. Nothing is recorded if no
+// producer is known, so that joining filters built in memory does not add
+// metadata.
+func (s *BloomFilter) recordJoinedProducers(s2 *BloomFilter) {
+	producers := append(s.JoinedProducers(), s.Producer())
+	producers = append(producers, s2.JoinedProducers()...)
+	producers = append(producers, s2.Producer())
+	var distinct []string
+	known := false
+	seen := make(map[string]bool)
+	for _, producer := range producers {
+		if !seen[producer] {
+			seen[producer] = true
+			distinct = append(distinct, producer)
+			known = known || producer != UnknownProducer
+		}
+	}
+	if known {
+		s.SetMetadata(MetadataKeyJoinedProducers, strings.Join(distinct, producerSeparator))
+	}
+}
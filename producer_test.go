@@ -0,0 +1,85 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func rewrite(t *testing.T, filter *BloomFilter, opts ...WriteOption) *BloomFilter {
+	var buf bytes.Buffer
+	if err := filter.Write(&buf, opts...); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func TestProducer(t *testing.T) {
+	filter, _ := GenerateExampleFilter(1000, 0.01, 100)
+	if p := rewrite(t, filter).Producer(); p != UnknownProducer {
+		t.Fatalf("expected an unknown producer for version 1, got %q", p)
+	}
+	loaded := rewrite(t, filter, WithProducer("app 1.0"))
+	if p := loaded.Producer(); p != "app 1.0 (DCSO/bloom "+Version+")" {
+		t.Fatalf("unexpected producer %q", p)
+	}
+	if p := loaded.Stats().Producer; p != loaded.Producer() {
+		t.Fatalf("unexpected producer in stats %q", p)
+	}
+
+	// a default producer is only recorded in version 2
+	if p := rewrite(t, filter, WithDefaultProducer("app 1.0")).Producer(); p != UnknownProducer {
+		t.Fatalf("expected an unknown producer for version 1, got %q", p)
+	}
+	withMeta := filter.clone()
+	withMeta.SetMetadata("source", "feed")
+	if p := rewrite(t, withMeta, WithDefaultProducer("app 1.0")).Producer(); p != "app 1.0 (DCSO/bloom "+Version+")" {
+		t.Fatalf("unexpected producer %q", p)
+	}
+	if p := rewrite(t, withMeta, WithDefaultProducer("app 1.0"), WithProducer("app 2.0")).Producer(); p != "app 2.0 (DCSO/bloom "+Version+")" {
+		t.Fatalf("unexpected producer %q", p)
+	}
+
+	// the producer is replaced when the filter is written again
+	if p := rewrite(t, loaded).Producer(); p != "DCSO/bloom "+Version {
+		t.Fatalf("unexpected producer %q", p)
+	}
+	filter.SetMetadata("source", "feed")
+	if p := rewrite(t, filter).Producer(); p != "DCSO/bloom "+Version {
+		t.Fatalf("unexpected producer %q", p)
+	}
+	if _, ok := filter.Metadata(MetadataKeyProducer); ok {
+		t.Fatal("Write modified the metadata of the filter")
+	}
+}
+
+func TestJoinedProducers(t *testing.T) {
+	a, _ := GenerateExampleFilter(1000, 0.01, 100)
+	b, _ := GenerateExampleFilter(1000, 0.01, 100)
+	if err := a.Join(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Metadata(MetadataKeyJoinedProducers); ok {
+		t.Fatal("joined producers recorded for filters without producers")
+	}
+
+	b = rewrite(t, b, WithProducer("app 1.0"))
+	if err := a.JoinEstimate(b); err != nil {
+		t.Fatal(err)
+	}
+	c := rewrite(t, a, WithProducer("app 2.0"))
+	if err := c.Join(rewrite(t, b, WithProducer("app 1.0"))); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{UnknownProducer, "app 1.0 (DCSO/bloom " + Version + ")", "app 2.0 (DCSO/bloom " + Version + ")"}
+	if p := rewrite(t, c).Stats().JoinedProducers; !reflect.DeepEqual(p, expected) {
+		t.Fatalf("unexpected joined producers %q", p)
+	}
+}
@@ -0,0 +1,84 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ValueIterator provides the values to rebuild a filter from, e.g. a
+// bufio.Scanner or a pipeline.Scanner reading one value per line.
+type ValueIterator interface {
+	// Scan advances to the next value, returning false at the end of the
+	// values or on an error.
+	Scan() bool
+	// Bytes returns the current value, which is only valid until the next
+	// call to Scan.
+	Bytes() []byte
+	// Err returns the error that ended the iteration, if any.
+	Err() error
+}
+
+// RebuildWithValues returns a new filter with the dimensions, settings,
+// metadata (except for the counts, tombstones, sample fraction and input
+// offset) and Data of the receiver, configured by the given options and
+// containing the given values, which should be the authoritative list of
+// values of the receiver. Unlike a copy, the rebuilt filter no longer
+// contains bits of values that were removed from the list, does not carry
+// deleted values as tombstones and has an exact count of added values.
+func (s *BloomFilter) RebuildWithValues(values ValueIterator, opts ...Option) (*BloomFilter, error) {
+	r := s.emptyCopy()
+	r.DeleteMetadata(MetadataKeySampleFraction)
+	r.DeleteMetadata(MetadataKeyInputOffset)
+	for _, opt := range opts {
+		opt(r)
+	}
+	for values.Scan() {
+		r.Add(values.Bytes())
+	}
+	if err := values.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ErrValueNotInFilter is returned by Rebuild if a value does not match the
+// filter that is rebuilt.
+var ErrValueNotInFilter = errors.New("value does not match the original filter")
+
+// Rebuild returns a new filter with the given capacity (n) and FP probability
+// (p), and otherwise the settings, metadata and Data of the receiver like
+// RebuildWithValues, containing the given values. This compacts a filter that
+// was over-provisioned into one of the right size for the values it actually
+// holds, with the desired FP probability. As a sanity check that the values
+// are those of the receiver, so that the new filter matches none that the
+// receiver did not, an error wrapping ErrValueNotInFilter is returned for the
+// first value that does not match the receiver. Values rejected by the value
+// length limit are skipped.
+func (s *BloomFilter) Rebuild(values ValueIterator, newN uint64, newP float64) (*BloomFilter, error) {
+	r, err := New(newN, newP)
+	if err != nil {
+		return nil, err
+	}
+	s.copySettings(r)
+	r.DeleteMetadata(MetadataKeySampleFraction)
+	r.DeleteMetadata(MetadataKeyInputOffset)
+	for values.Scan() {
+		value := values.Bytes()
+		ok, err := s.TryCheck(value)
+		if err != nil {
+			// rejected by the same limit in the new filter
+			continue
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", ErrValueNotInFilter, value)
+		}
+		r.Add(value)
+	}
+	if err := values.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
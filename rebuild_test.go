@@ -0,0 +1,127 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRebuildWithValues(t *testing.T) {
+	filter := mustNew(10000, 0.001)
+	var authoritative []string
+	for i := 0; i < 1000; i++ {
+		value := fmt.Sprintf("value-%d", i)
+		filter.Add([]byte(value))
+		// every other value was removed from the list since
+		if i%2 == 0 {
+			authoritative = append(authoritative, value)
+		}
+	}
+	filter.Data = []byte("data")
+	filter.SetMetadata("source", "feed")
+	filter.SetMetadata(MetadataKeyCount, "estimated")
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.Join(authoritative, "\n")))
+	rebuilt, err := filter.RebuildWithValues(scanner, WithMaxValueLength(64, RejectValues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.NumBits() != filter.NumBits() || rebuilt.NumHashFuncs() != filter.NumHashFuncs() {
+		t.Fatal("rebuilt filter has different dimensions")
+	}
+	if rebuilt.N != 500 {
+		t.Fatalf("unexpected count %d", rebuilt.N)
+	}
+	if string(rebuilt.Data) != "data" {
+		t.Fatal("Data not copied")
+	}
+	if v, _ := rebuilt.Metadata("source"); v != "feed" {
+		t.Fatal("metadata not copied")
+	}
+	if _, ok := rebuilt.Metadata(MetadataKeyCount); ok {
+		t.Fatal("count marker copied")
+	}
+	if v, _ := rebuilt.Metadata(MetadataKeyMaxValueLength); v != "64" {
+		t.Fatal("options not applied")
+	}
+	removed := 0
+	for i := 0; i < 1000; i++ {
+		value := []byte(fmt.Sprintf("value-%d", i))
+		if i%2 == 0 && !rebuilt.Check(value) {
+			t.Fatalf("value %q missing from the rebuilt filter", value)
+		}
+		if i%2 == 1 && !rebuilt.Check(value) {
+			removed++
+		}
+	}
+	if removed < 490 {
+		t.Fatalf("only %d of 500 removed values no longer match", removed)
+	}
+}
+
+type failingIterator struct{}
+
+func (failingIterator) Scan() bool    { return false }
+func (failingIterator) Bytes() []byte { return nil }
+func (failingIterator) Err() error    { return errors.New("read error") }
+
+func TestRebuildWithValuesError(t *testing.T) {
+	if _, err := mustNew(100, 0.01).RebuildWithValues(failingIterator{}); err == nil {
+		t.Fatal("expected the error of the iterator")
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	// over-provisioned by a factor of 100
+	filter := mustNew(100000, 0.001)
+	var values []string
+	for i := 0; i < 1000; i++ {
+		value := fmt.Sprintf("value-%d", i)
+		filter.Add([]byte(value))
+		values = append(values, value)
+	}
+	filter.Data = []byte("data")
+	filter.SetMetadata("source", "feed")
+
+	scanner := bufio.NewScanner(strings.NewReader(strings.Join(values, "\n")))
+	compacted, err := filter.Rebuild(scanner, 2000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted.MaxNumElements() != 2000 || compacted.FalsePositiveProb() != 0.001 {
+		t.Fatalf("unexpected dimensions n=%d, p=%g", compacted.MaxNumElements(), compacted.FalsePositiveProb())
+	}
+	if compacted.NumBits() > filter.NumBits()/40 {
+		t.Fatalf("compacted filter has %d bits, original %d", compacted.NumBits(), filter.NumBits())
+	}
+	if compacted.N != 1000 {
+		t.Fatalf("unexpected count %d", compacted.N)
+	}
+	if v, _ := compacted.Metadata("source"); v != "feed" || string(compacted.Data) != "data" {
+		t.Fatal("metadata or Data not copied")
+	}
+	for _, value := range values {
+		if !compacted.Check([]byte(value)) {
+			t.Fatalf("value %q missing from the compacted filter", value)
+		}
+	}
+	if fp := compacted.EstimatedFalsePositiveProb(); fp > 0.002 {
+		t.Fatalf("unexpected estimated FP probability %g", fp)
+	}
+
+	scanner = bufio.NewScanner(strings.NewReader("value-1\nother"))
+	if _, err := filter.Rebuild(scanner, 2000, 0.001); !errors.Is(err, ErrValueNotInFilter) || !strings.Contains(err.Error(), `"other"`) {
+		t.Fatalf("expected ErrValueNotInFilter, got %v", err)
+	}
+	if _, err := filter.Rebuild(failingIterator{}, 2000, 0.001); err == nil {
+		t.Fatal("expected the error of the iterator")
+	}
+	if _, err := filter.Rebuild(failingIterator{}, 0, 0.001); err == nil {
+		t.Fatal("expected an error for an invalid capacity")
+	}
+}
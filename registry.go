@@ -0,0 +1,332 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// registryFileExt is the extension of the files written by PersistAll.
+const registryFileExt = ".bloom"
+
+// ErrQuotaExceeded is returned by Registry.Create if the filter would exceed
+// the quota of its tenant.
+var ErrQuotaExceeded = errors.New("tenant quota exceeded")
+
+// ErrFilterExists is returned by Registry.Create if the tenant already has a
+// filter with the given name.
+var ErrFilterExists = errors.New("filter already exists")
+
+// ErrFilterNotFound is returned by the methods of a Registry if the tenant
+// has no filter with the given name.
+var ErrFilterNotFound = errors.New("filter not found")
+
+// TenantQuota limits the filters of a tenant in a Registry. Zero values mean
+// no limit.
+type TenantQuota struct {
+	// MaxFilters is the maximum number of filters of the tenant.
+	MaxFilters int
+	// MaxBits is the maximum total number of bits of the filters of the
+	// tenant.
+	MaxBits uint64
+}
+
+// registryEntry is a filter of a Registry with the lock protecting it.
+type registryEntry struct {
+	mu     sync.RWMutex
+	filter *BloomFilter
+}
+
+// Registry holds the named filters of several tenants in one process,
+// limiting the filters of each tenant by a quota. It is safe for concurrent
+// use: filters are created, deleted, added to and checked concurrently.
+type Registry struct {
+	mu           sync.RWMutex
+	defaultQuota TenantQuota
+	quotas       map[string]TenantQuota
+	tenants      map[string]map[string]*registryEntry
+}
+
+// NewRegistry returns an empty registry. The filters of each tenant are
+// limited by its quota in quotas, or by the default quota for tenants without
+// one.
+func NewRegistry(defaultQuota TenantQuota, quotas map[string]TenantQuota) *Registry {
+	r := &Registry{
+		defaultQuota: defaultQuota,
+		quotas:       make(map[string]TenantQuota),
+		tenants:      make(map[string]map[string]*registryEntry),
+	}
+	for tenant, quota := range quotas {
+		r.quotas[tenant] = quota
+	}
+	return r
+}
+
+// Quota returns the quota of the tenant.
+func (r *Registry) Quota(tenant string) TenantQuota {
+	if quota, ok := r.quotas[tenant]; ok {
+		return quota
+	}
+	return r.defaultQuota
+}
+
+// Create creates an empty filter with the given capacity (n) and false
+// positive probability (p) for the tenant, like New. It fails with
+// ErrFilterExists if the tenant already has a filter with the name, and with
+// ErrQuotaExceeded if the filter would exceed the quota of the tenant, which is
+// checked with the dimensions New uses with the options before the bit array
+// is allocated.
+func (r *Registry) Create(tenant, name string, n uint64, p float64, opts ...Option) (*BloomFilter, error) {
+	if tenant == "" || name == "" {
+		return nil, errors.New("tenant and filter name must not be empty")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	filters := r.tenants[tenant]
+	if _, ok := filters[name]; ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrFilterExists, tenant, name)
+	}
+	quota := r.Quota(tenant)
+	if quota.MaxFilters > 0 && len(filters) >= quota.MaxFilters {
+		return nil, fmt.Errorf("%w: tenant %s has %d filters (maximum: %d)", ErrQuotaExceeded, tenant, len(filters), quota.MaxFilters)
+	}
+	filter, storage, err := planNew(n, p, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if quota.MaxBits > 0 {
+		bits := filter.NumBits()
+		if used := tenantBits(filters); used+bits > quota.MaxBits || used+bits < used {
+			return nil, fmt.Errorf("%w: tenant %s would have %d bits (maximum: %d)", ErrQuotaExceeded, tenant, used+bits, quota.MaxBits)
+		}
+	}
+	filter.v = allocWords(storage, filter.M)
+	if filters == nil {
+		filters = make(map[string]*registryEntry)
+		r.tenants[tenant] = filters
+	}
+	filters[name] = &registryEntry{filter: filter}
+	return filter, nil
+}
+
+// tenantBits returns the total number of bits of the filters.
+func tenantBits(filters map[string]*registryEntry) uint64 {
+	var bits uint64
+	for _, entry := range filters {
+		bits += entry.filter.NumBits()
+	}
+	return bits
+}
+
+// entry returns the entry of the named filter of the tenant.
+func (r *Registry) entry(tenant, name string) (*registryEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.tenants[tenant][name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrFilterNotFound, tenant, name)
+	}
+	return entry, nil
+}
+
+// Get returns the named filter of the tenant. The filter must not be modified
+// directly while it is used concurrently; use Add and Check instead.
+func (r *Registry) Get(tenant, name string) (*BloomFilter, error) {
+	entry, err := r.entry(tenant, name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.filter, nil
+}
+
+// Add adds a value to the named filter of the tenant.
+func (r *Registry) Add(tenant, name string, value []byte) error {
+	entry, err := r.entry(tenant, name)
+	if err != nil {
+		return err
+	}
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	return entry.filter.TryAdd(value)
+}
+
+// Check checks a value against the named filter of the tenant.
+func (r *Registry) Check(tenant, name string, value []byte) (bool, error) {
+	entry, err := r.entry(tenant, name)
+	if err != nil {
+		return false, err
+	}
+	entry.mu.RLock()
+	defer entry.mu.RUnlock()
+	return entry.filter.TryCheck(value)
+}
+
+// Delete removes the named filter of the tenant.
+func (r *Registry) Delete(tenant, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	filters := r.tenants[tenant]
+	if _, ok := filters[name]; !ok {
+		return fmt.Errorf("%w: %s/%s", ErrFilterNotFound, tenant, name)
+	}
+	delete(filters, name)
+	if len(filters) == 0 {
+		delete(r.tenants, tenant)
+	}
+	return nil
+}
+
+// List returns the sorted names of the filters of the tenant.
+func (r *Registry) List(tenant string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tenants[tenant]))
+	for name := range r.tenants[tenant] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tenants returns the sorted names of the tenants that have filters.
+func (r *Registry) Tenants() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tenants := make([]string, 0, len(r.tenants))
+	for tenant := range r.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// PersistAll writes all filters to dir, one file per filter in a directory
+// per tenant, and removes the files of filters that no longer exist. Tenant
+// and filter names are escaped so that any name yields a valid file name
+// within dir.
+func (r *Registry) PersistAll(dir string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	written := make(map[string]bool)
+	for tenant, filters := range r.tenants {
+		tenantDir := filepath.Join(dir, escapeRegistryName(tenant))
+		if err := os.MkdirAll(tenantDir, 0755); err != nil {
+			return err
+		}
+		for name, entry := range filters {
+			path := filepath.Join(tenantDir, escapeRegistryName(name)+registryFileExt)
+			entry.mu.RLock()
+			err := WriteFilter(entry.filter, path, false)
+			entry.mu.RUnlock()
+			if err != nil {
+				return err
+			}
+			written[path] = true
+		}
+	}
+	stale, err := filepath.Glob(filepath.Join(dir, "*", "*"+registryFileExt))
+	if err != nil {
+		return err
+	}
+	for _, path := range stale {
+		if !written[path] {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadAll replaces the filters of the registry with those written to dir by
+// PersistAll. Quotas are not enforced when loading, so that no filters are
+// lost if a quota was lowered, but tenants exceeding their quota cannot create
+// further filters.
+func (r *Registry) LoadAll(dir string) error {
+	tenants := make(map[string]map[string]*registryEntry)
+	tenantDirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, tenantDir := range tenantDirs {
+		if !tenantDir.IsDir() {
+			continue
+		}
+		tenant, err := unescapeRegistryName(tenantDir.Name())
+		if err != nil {
+			return err
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dir, tenantDir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if file.IsDir() || !strings.HasSuffix(file.Name(), registryFileExt) {
+				continue
+			}
+			name, err := unescapeRegistryName(strings.TrimSuffix(file.Name(), registryFileExt))
+			if err != nil {
+				return err
+			}
+			filter, err := LoadFilter(filepath.Join(dir, tenantDir.Name(), file.Name()), false)
+			if err != nil {
+				return err
+			}
+			if tenants[tenant] == nil {
+				tenants[tenant] = make(map[string]*registryEntry)
+			}
+			tenants[tenant][name] = &registryEntry{filter: filter}
+		}
+	}
+	r.mu.Lock()
+	r.tenants = tenants
+	r.mu.Unlock()
+	return nil
+}
+
+// escapeRegistryName escapes a tenant or filter name for use as a file name:
+// ASCII letters, digits, '-' and '_' are kept, all other bytes are written as
+// '%' followed by two hexadecimal digits. In particular, the names cannot
+// contain path separators or be '.' or '..'.
+func escapeRegistryName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeRegistryName reverses escapeRegistryName.
+func unescapeRegistryName(escaped string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		if i+2 >= len(escaped) {
+			return "", fmt.Errorf("invalid escaped name %q", escaped)
+		}
+		c, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escaped name %q", escaped)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+	return b.String(), nil
+}
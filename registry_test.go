@@ -0,0 +1,201 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestRegistryQuota(t *testing.T) {
+	bits, err := optimalNumBits(1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(TenantQuota{MaxFilters: 2}, map[string]TenantQuota{
+		"small": {MaxBits: bits + bits/2},
+	})
+	for _, name := range []string{"a", "b"} {
+		if _, err := r.Create("default", name, 1000, 0.01); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := r.Create("default", "c", 1000, 0.01); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if _, err := r.Create("default", "a", 1000, 0.01); !errors.Is(err, ErrFilterExists) {
+		t.Fatalf("expected exists error, got %v", err)
+	}
+	// the bit quota counts the filters of the tenant only
+	if _, err := r.Create("small", "a", 1000, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Create("small", "b", 1000, 0.01); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	// deleting frees the quota
+	if err := r.Delete("small", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Create("small", "b", 1000, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Delete("small", "a"); !errors.Is(err, ErrFilterNotFound) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+	if _, err := r.Create("", "a", 1000, 0.01); err == nil {
+		t.Fatal("expected an error for an empty tenant")
+	}
+	if names := r.List("default"); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Fatalf("unexpected filters %v", names)
+	}
+	if tenants := r.Tenants(); !reflect.DeepEqual(tenants, []string{"default", "small"}) {
+		t.Fatalf("unexpected tenants %v", tenants)
+	}
+}
+
+func TestRegistryQuotaHashFuncs(t *testing.T) {
+	// capping the number of hash functions takes more bits than the optimal
+	// number, which the quota accounts for
+	bits, err := optimalNumBits(1000, 1e-12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry(TenantQuota{MaxBits: bits}, nil)
+	if _, err := r.Create("default", "capped", 1000, 1e-12, WithMaxHashFuncs(10, false)); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error, got %v", err)
+	}
+	if _, err := r.Create("default", "default-cap", 1000, 1e-12); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected quota error with the default cap, got %v", err)
+	}
+	plan, err := PlanFilterWithMaxHashFuncs(1000, 1e-12, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := r.Create("default", "uncapped", 1000, 1e-12, WithMaxHashFuncs(0, false))
+	if err != nil || filter.NumBits() != plan.Bits {
+		t.Fatalf("unexpected filter (%v)", err)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry(TenantQuota{MaxFilters: 5}, nil)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("f%d", i)
+			if _, err := r.Create("t", name, 100, 0.01); err != nil {
+				if !errors.Is(err, ErrQuotaExceeded) {
+					t.Error(err)
+				}
+				return
+			}
+			mu.Lock()
+			created++
+			mu.Unlock()
+			for j := 0; j < 100; j++ {
+				value := []byte(fmt.Sprintf("%s-%d", name, j))
+				if err := r.Add("t", name, value); err != nil {
+					t.Error(err)
+					return
+				}
+				if ok, err := r.Check("t", name, value); err != nil || !ok {
+					t.Errorf("%s: value not found (%v)", value, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created != 5 || len(r.List("t")) != 5 {
+		t.Fatalf("expected 5 filters, created %d", created)
+	}
+}
+
+func TestRegistryPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := NewRegistry(TenantQuota{}, nil)
+	names := map[string][]string{
+		"acme":     {"ips", "../escape", "a.b"},
+		"../other": {".", "..", "x/y", "100%"},
+	}
+	for tenant, filters := range names {
+		for _, name := range filters {
+			if _, err := r.Create(tenant, name, 100, 0.01); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Add(tenant, name, []byte(tenant+name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := r.PersistAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	// nothing is written outside of the directory
+	if _, err := os.Stat(filepath.Join(dir, "..", "other")); !os.IsNotExist(err) {
+		t.Fatalf("file written outside of the directory: %v", err)
+	}
+
+	loaded := NewRegistry(TenantQuota{}, nil)
+	if err := loaded.LoadAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	for tenant, filters := range names {
+		for _, name := range filters {
+			if ok, err := loaded.Check(tenant, name, []byte(tenant+name)); err != nil || !ok {
+				t.Fatalf("%s/%s: value not found (%v)", tenant, name, err)
+			}
+		}
+	}
+
+	// deleted filters are removed on the next persist
+	if err := r.Delete("acme", "ips"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PersistAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.LoadAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if names := loaded.List("acme"); !reflect.DeepEqual(names, []string{"../escape", "a.b"}) {
+		t.Fatalf("unexpected filters %v", names)
+	}
+}
+
+func TestRegistryNameEscaping(t *testing.T) {
+	for _, name := range []string{"", "plain_Name-1", ".", "..", "a/b", `a\b`, "%41", "ünï"} {
+		escaped := escapeRegistryName(name)
+		for _, c := range escaped {
+			if c == '.' || c == '/' || c == '\\' {
+				t.Fatalf("%q: unsafe escaped name %q", name, escaped)
+			}
+		}
+		unescaped, err := unescapeRegistryName(escaped)
+		if err != nil || unescaped != name {
+			t.Fatalf("%q: round trip gave %q (%v)", name, unescaped, err)
+		}
+	}
+	for _, escaped := range []string{"%", "%4", "%zz", "%4g"} {
+		if _, err := unescapeRegistryName(escaped); err == nil {
+			t.Fatalf("%q: expected an error", escaped)
+		}
+	}
+}
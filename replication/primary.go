@@ -0,0 +1,260 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+// Package replication keeps a warm standby copy of a Bloom filter in sync
+// with a primary over HTTP. The primary logs the fingerprints of the values
+// added to its filter with consecutive sequence numbers and serves a snapshot
+// of the filter and a stream of the fingerprints added since a given sequence
+// number. The standby loads a snapshot, then applies the streamed fingerprints
+// with AddFingerprint. If it falls further behind than the primary retains
+// fingerprints, or if its filter does not match the digest the primary sends
+// once it has caught up, it loads a new snapshot.
+//
+// The stream consists of JSON objects separated by newlines, each holding the
+// sequence number ("seq") and either the fingerprint of a value ("fingerprint",
+// an array of the indexes of its bits) or the digest of the filter at that
+// sequence number ("digest", see Digest).
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/DCSO/bloom"
+)
+
+const (
+	// SnapshotPath is the path of the snapshot of the filter served by a
+	// Primary. The sequence number of the snapshot is given in the
+	// SequenceHeader of the response.
+	SnapshotPath = "/snapshot"
+	// StreamPath is the path of the stream of fingerprints served by a
+	// Primary, starting after the sequence number given in the parameter
+	// "since".
+	StreamPath = "/stream"
+	// SequenceHeader is the response header holding the sequence number of a
+	// snapshot.
+	SequenceHeader = "Bloom-Sequence"
+)
+
+// DefaultRetention is the default number of fingerprints a Primary retains
+// for standbys that reconnect.
+const DefaultRetention = 1 << 16
+
+// message is an entry of the stream.
+type message struct {
+	Seq         uint64   `json:"seq"`
+	Fingerprint []uint64 `json:"fingerprint,omitempty"`
+	Digest      string   `json:"digest,omitempty"`
+}
+
+// Digest returns the hex-encoded SHA-256 digest of the bit array of the
+// filter, as little-endian 64-bit words.
+func Digest(filter *bloom.BloomFilter) string {
+	h := sha256.New()
+	w := bufio.NewWriter(h)
+	var b [8]byte
+	filter.VisitWords(func(_ uint64, word uint64) bool {
+		binary.LittleEndian.PutUint64(b[:], word)
+		w.Write(b[:])
+		return true
+	})
+	w.Flush()
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Primary wraps the filter that is replicated. Values must only be added
+// through the Primary, which is safe for concurrent use. It serves the
+// snapshot and the stream as an http.Handler at SnapshotPath and StreamPath,
+// and the block hashes and words used by SyncFromURL at BlocksPath and
+// WordsPath.
+type Primary struct {
+	filter *bloom.BloomFilter
+	mux    *http.ServeMux
+
+	mu sync.Mutex
+	// seq is the sequence number of the last fingerprint, log holds the
+	// last fingerprints up to seq
+	seq    uint64
+	log    [][]uint64
+	retain int
+	// changed is closed and replaced whenever a fingerprint is logged
+	changed chan struct{}
+	done    chan struct{}
+	closed  bool
+}
+
+// NewPrimary returns a Primary for the filter that retains the given number
+// of fingerprints (DefaultRetention if not positive) for standbys that
+// reconnect. The values contained in the filter at this point are replicated
+// by the snapshot.
+func NewPrimary(filter *bloom.BloomFilter, retain int) *Primary {
+	if retain <= 0 {
+		retain = DefaultRetention
+	}
+	p := &Primary{
+		filter:  filter,
+		mux:     http.NewServeMux(),
+		retain:  retain,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	p.mux.HandleFunc(SnapshotPath, p.serveSnapshot)
+	p.mux.HandleFunc(StreamPath, p.serveStream)
+	p.mux.HandleFunc(BlocksPath, p.serveBlocks)
+	p.mux.HandleFunc(WordsPath, p.serveWords)
+	return p
+}
+
+// Add adds a value to the filter and logs its fingerprint for the standbys,
+// unless the value is rejected due to its length or all of its bits are
+// already set, in which case adding it has no effect on the filter.
+func (p *Primary) Add(value []byte) {
+	if p.filter.Rejects(value) {
+		return
+	}
+	// the fingerprint only depends on the dimensions of the filter, the
+	// bits are checked under the lock
+	fingerprint := make([]uint64, p.filter.NumHashFuncs())
+	p.filter.Fingerprint(value, fingerprint)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.filter.CheckFingerprint(fingerprint) {
+		return
+	}
+	p.filter.AddFingerprint(fingerprint)
+	p.seq++
+	if len(p.log) == p.retain {
+		// the dropped entries are freed when append reallocates
+		p.log = p.log[1:]
+	}
+	p.log = append(p.log, fingerprint)
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// Check checks a value against the filter.
+func (p *Primary) Check(value []byte) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.filter.Check(value)
+}
+
+// Sequence returns the sequence number of the last logged fingerprint.
+func (p *Primary) Sequence() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.seq
+}
+
+// Close ends all streams served by the Primary.
+func (p *Primary) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.done)
+	}
+}
+
+// ServeHTTP serves the snapshot, the stream, the block hashes and the words.
+func (p *Primary) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+func (p *Primary) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	// the filter is serialized while locked, but sent without blocking adds
+	var buf bytes.Buffer
+	p.mu.Lock()
+	seq := p.seq
+	err := p.filter.Write(&buf)
+	p.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(SequenceHeader, strconv.FormatUint(seq, 10))
+	w.Write(buf.Bytes())
+}
+
+// entriesSince returns the logged fingerprints after the given sequence
+// number and the channel that is closed when more are logged. ok is false if
+// they are no longer retained.
+func (p *Primary) entriesSince(since uint64) (entries [][]uint64, changed chan struct{}, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	first := p.seq - uint64(len(p.log)) + 1
+	if since > p.seq || since+1 < first {
+		return nil, nil, false
+	}
+	return append([][]uint64(nil), p.log[since+1-first:]...), p.changed, true
+}
+
+func (p *Primary) serveStream(w http.ResponseWriter, r *http.Request) {
+	since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid sequence number", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := p.entriesSince(since); !ok {
+		http.Error(w, "sequence number no longer retained, load a snapshot", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	sentDigest := false
+	for {
+		entries, changed, ok := p.entriesSince(since)
+		if !ok {
+			// the standby fell behind while the stream was blocked
+			return
+		}
+		for _, fingerprint := range entries {
+			since++
+			if err := enc.Encode(message{Seq: since, Fingerprint: fingerprint}); err != nil {
+				return
+			}
+		}
+		if !sentDigest {
+			if digest, ok := p.digestAt(since); ok {
+				if err := enc.Encode(message{Seq: since, Digest: digest}); err != nil {
+					return
+				}
+				sentDigest = true
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		select {
+		case <-changed:
+		case <-p.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// digestAt returns the digest of the filter if its sequence number is still
+// the given one.
+func (p *Primary) digestAt(seq uint64) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.seq != seq {
+		return "", false
+	}
+	return Digest(p.filter), true
+}
@@ -0,0 +1,269 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package replication
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+func newFilter(t *testing.T) *bloom.BloomFilter {
+	filter, err := bloom.New(10000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filter
+}
+
+func addValues(p *Primary, from, to int) {
+	for i := from; i < to; i++ {
+		p.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+}
+
+// waitFor waits until the standby applied the given sequence number and
+// verified its filter.
+func waitFor(t *testing.T, s *Standby, seq uint64) {
+	deadline := time.Now().Add(10 * time.Second)
+	for s.Sequence() != seq || !s.Verified() {
+		if time.Now().After(deadline) {
+			t.Fatalf("standby at sequence number %d (verified: %v), expected %d", s.Sequence(), s.Verified(), seq)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func checkValues(t *testing.T, s *Standby, from, to int) {
+	for i := from; i < to; i++ {
+		if value := []byte(fmt.Sprintf("value-%d", i)); !s.Check(value) {
+			t.Fatalf("value %q missing from the standby", value)
+		}
+	}
+}
+
+func startStandby(url string) (*Standby, func()) {
+	standby := NewStandby(url, nil)
+	standby.MinBackoff = time.Millisecond
+	standby.MaxBackoff = 10 * time.Millisecond
+	return standby, run(standby)
+}
+
+// run runs the standby until the returned function is called.
+func run(standby *Standby) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		standby.Run(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 100)
+	server := httptest.NewServer(primary)
+	defer server.Close()
+	defer primary.Close()
+
+	addValues(primary, 0, 50)
+	standby, stop := startStandby(server.URL)
+	defer stop()
+	waitFor(t, standby, primary.Sequence())
+	checkValues(t, standby, 0, 50)
+	if standby.Snapshots() != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", standby.Snapshots())
+	}
+
+	// additions are streamed
+	addValues(primary, 50, 80)
+	waitFor(t, standby, primary.Sequence())
+	checkValues(t, standby, 0, 80)
+
+	// the standby catches up after reconnecting
+	server.CloseClientConnections()
+	addValues(primary, 80, 120)
+	waitFor(t, standby, primary.Sequence())
+	checkValues(t, standby, 0, 120)
+	if standby.Snapshots() != 1 {
+		t.Fatalf("unexpected snapshot after catching up, got %d", standby.Snapshots())
+	}
+	if Digest(primary.filter) != Digest(standby.filter) {
+		t.Fatal("filters differ")
+	}
+}
+
+func TestPrimaryConcurrentAdd(t *testing.T) {
+	filter := newFilter(t)
+	filter.SetMaxValueLength(16, bloom.RejectValues)
+	primary := NewPrimary(filter, 1000)
+	defer primary.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				primary.Add([]byte(fmt.Sprintf("value-%d-%d", g, i)))
+				primary.Add([]byte(fmt.Sprintf("rejected-value-%d-%d", g, i)))
+				primary.Check([]byte(fmt.Sprintf("value-%d-%d", g, i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	for g := 0; g < 8; g++ {
+		for i := 0; i < 200; i++ {
+			if !primary.Check([]byte(fmt.Sprintf("value-%d-%d", g, i))) {
+				t.Fatalf("value %d-%d missing", g, i)
+			}
+		}
+	}
+	if seq := primary.Sequence(); seq == 0 || seq > 1600 || filter.NumElements() != seq {
+		t.Fatalf("unexpected sequence number %d for %d elements", seq, filter.NumElements())
+	}
+}
+
+func TestReplicationFallingBehind(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 10)
+	server := httptest.NewServer(primary)
+	defer server.Close()
+	defer primary.Close()
+
+	addValues(primary, 0, 10)
+	standby, stop := startStandby(server.URL)
+	waitFor(t, standby, primary.Sequence())
+	stop()
+
+	// more values than retained are added while the standby is down
+	addValues(primary, 10, 100)
+	stop = run(standby)
+	defer stop()
+	waitFor(t, standby, primary.Sequence())
+	checkValues(t, standby, 0, 100)
+	if standby.Snapshots() != 2 {
+		t.Fatalf("expected a second snapshot, got %d", standby.Snapshots())
+	}
+}
+
+func TestReplicationDigestMismatch(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 100)
+	server := httptest.NewServer(primary)
+	defer server.Close()
+	defer primary.Close()
+
+	addValues(primary, 0, 10)
+	standby, stop := startStandby(server.URL)
+	waitFor(t, standby, primary.Sequence())
+	stop()
+
+	// the copy of the standby diverges, which the digest sent after
+	// catching up reveals
+	standby.filter.Add([]byte("divergent"))
+	addValues(primary, 10, 20)
+	stop = run(standby)
+	defer stop()
+	waitFor(t, standby, primary.Sequence())
+	if standby.Snapshots() != 2 {
+		t.Fatalf("expected a second snapshot, got %d", standby.Snapshots())
+	}
+	if Digest(primary.filter) != Digest(standby.filter) {
+		t.Fatal("filters differ")
+	}
+}
+
+// recordingLogger records the messages and arguments of events.
+type recordingLogger struct {
+	mu     sync.Mutex
+	events map[string][]interface{}
+}
+
+func (l *recordingLogger) record(msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[msg] = args
+}
+
+func (l *recordingLogger) event(msg string) ([]interface{}, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	args, ok := l.events[msg]
+	return args, ok
+}
+
+func (l *recordingLogger) Debug(msg string, args ...interface{}) { l.record(msg, args) }
+func (l *recordingLogger) Info(msg string, args ...interface{})  { l.record(msg, args) }
+func (l *recordingLogger) Warn(msg string, args ...interface{})  { l.record(msg, args) }
+
+func TestReplicationLogging(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 100)
+	defer primary.Close()
+	// the first snapshot fails
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == SnapshotPath && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		primary.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	addValues(primary, 0, 10)
+	logger := &recordingLogger{events: make(map[string][]interface{})}
+	standby := NewStandby(server.URL, nil)
+	standby.MinBackoff = time.Millisecond
+	standby.Logger = logger
+	stop := run(standby)
+	defer stop()
+	waitFor(t, standby, primary.Sequence())
+
+	if _, ok := logger.event("loading snapshot failed"); !ok {
+		t.Fatal("failed snapshot not logged")
+	}
+	args, ok := logger.event("loaded snapshot")
+	if !ok {
+		t.Fatal("snapshot not logged")
+	}
+	if fmt.Sprint(args[2:4]) != fmt.Sprint([]interface{}{bloom.LogKeyDigest, Digest(primary.filter)}) {
+		t.Fatalf("unexpected arguments %v", args)
+	}
+	if _, ok := logger.event("loaded filter"); !ok {
+		t.Fatal("loading the filter not logged")
+	}
+}
+
+func TestReplicationNilLogger(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 100)
+	defer primary.Close()
+	// the first snapshot fails, which is logged
+	var failed int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == SnapshotPath && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		primary.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	addValues(primary, 0, 10)
+	standby := NewStandby(server.URL, nil)
+	standby.MinBackoff = time.Millisecond
+	standby.Logger = nil
+	stop := run(standby)
+	defer stop()
+	waitFor(t, standby, primary.Sequence())
+	checkValues(t, standby, 0, 10)
+}
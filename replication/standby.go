@@ -0,0 +1,274 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// DefaultMinBackoff and DefaultMaxBackoff are the default bounds of the delay
+// before a Standby reconnects after an error, which doubles with each
+// consecutive error.
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// errResync is returned when the standby has to load a new snapshot.
+var errResync = errors.New("standby out of sync with primary")
+
+// Standby maintains a copy of the filter of a Primary. It is safe for
+// concurrent use.
+type Standby struct {
+	url    string
+	client *http.Client
+	// MinBackoff and MaxBackoff bound the delay before reconnecting, see
+	// DefaultMinBackoff. They must not be changed while Run is running.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Logger receives the events of loading snapshots and resynchronizing
+	// (bloom.NopLogger by default or if nil). It must not be changed while
+	// Run is running.
+	Logger bloom.Logger
+
+	mu        sync.RWMutex
+	filter    *bloom.BloomFilter
+	seq       uint64
+	resync    bool
+	snapshots int
+	verified  bool
+}
+
+// NewStandby returns a Standby replicating the Primary served at the given
+// base URL using the given client (http.DefaultClient if nil), which must not
+// time out the long-lived stream.
+func NewStandby(url string, client *http.Client) *Standby {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Standby{
+		url:        url,
+		client:     client,
+		MinBackoff: DefaultMinBackoff,
+		MaxBackoff: DefaultMaxBackoff,
+		Logger:     bloom.NopLogger{},
+	}
+}
+
+// Run replicates the filter of the primary until the context is done, which
+// is the error it returns. Connection errors are retried with backoff.
+func (s *Standby) Run(ctx context.Context) error {
+	backoff := s.MinBackoff
+	for {
+		progressed, _ := s.sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if progressed {
+			backoff = s.MinBackoff
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+		if backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// sync loads a snapshot if needed and applies the stream until an error
+// occurs. It returns whether the filter was updated.
+func (s *Standby) sync(ctx context.Context) (bool, error) {
+	progressed := false
+	s.mu.RLock()
+	needSnapshot := s.filter == nil || s.resync
+	seq := s.seq
+	s.mu.RUnlock()
+	if needSnapshot {
+		if err := s.loadSnapshot(ctx); err != nil {
+			if ctx.Err() == nil {
+				s.logger().Warn("loading snapshot failed", "url", s.url+SnapshotPath, bloom.LogKeyError, err)
+			}
+			return false, err
+		}
+		progressed = true
+		s.mu.RLock()
+		seq = s.seq
+		s.mu.RUnlock()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.url+StreamPath+"?since="+strconv.FormatUint(seq, 10), nil)
+	if err != nil {
+		return progressed, err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return progressed, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		s.logger().Info("stream no longer available, loading a new snapshot")
+		s.setResync()
+		return progressed, errResync
+	}
+	if resp.StatusCode != http.StatusOK {
+		return progressed, fmt.Errorf("unexpected status %s of stream", resp.Status)
+	}
+	s.mu.Lock()
+	s.verified = false
+	s.mu.Unlock()
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return progressed, err
+		}
+		if err := s.apply(msg); err != nil {
+			s.logger().Warn("applying stream failed, loading a new snapshot", bloom.LogKeyError, err)
+			s.setResync()
+			return progressed, err
+		}
+		progressed = true
+	}
+	if err := scanner.Err(); err != nil {
+		return progressed, err
+	}
+	return progressed, errors.New("stream ended")
+}
+
+// logger returns the Logger, or a bloom.NopLogger if it is nil.
+func (s *Standby) logger() bloom.Logger {
+	if s.Logger == nil {
+		return bloom.NopLogger{}
+	}
+	return s.Logger
+}
+
+func (s *Standby) loadSnapshot(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, s.url+SnapshotPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s of snapshot", resp.Status)
+	}
+	seq, err := strconv.ParseUint(resp.Header.Get(SequenceHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid sequence number of snapshot: %w", err)
+	}
+	start := time.Now()
+	logger := s.logger()
+	filter, err := bloom.LoadFromReader(resp.Body, false, bloom.WithLogger(logger))
+	if err != nil {
+		return err
+	}
+	if _, ok := logger.(bloom.NopLogger); !ok {
+		// the digest is only computed for a logger
+		logger.Info("loaded snapshot", "sequence", seq, bloom.LogKeyDigest, Digest(filter), bloom.LogKeyDuration, time.Since(start))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = filter
+	s.seq = seq
+	s.resync = false
+	s.snapshots++
+	return nil
+}
+
+// apply applies a message of the stream to the filter.
+func (s *Standby) apply(msg message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if msg.Digest != "" {
+		if msg.Seq != s.seq {
+			return fmt.Errorf("%w: digest for sequence number %d at %d", errResync, msg.Seq, s.seq)
+		}
+		if Digest(s.filter) != msg.Digest {
+			return fmt.Errorf("%w: digest mismatch at sequence number %d", errResync, s.seq)
+		}
+		s.verified = true
+		return nil
+	}
+	if msg.Seq != s.seq+1 {
+		return fmt.Errorf("%w: sequence number %d after %d", errResync, msg.Seq, s.seq)
+	}
+	if uint64(len(msg.Fingerprint)) != s.filter.NumHashFuncs() {
+		return fmt.Errorf("%w: fingerprint of %d indexes", errResync, len(msg.Fingerprint))
+	}
+	for _, index := range msg.Fingerprint {
+		if index >= s.filter.NumBits() {
+			return fmt.Errorf("%w: index %d out of range", errResync, index)
+		}
+	}
+	s.filter.AddFingerprint(msg.Fingerprint)
+	s.seq = msg.Seq
+	return nil
+}
+
+func (s *Standby) setResync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resync = true
+}
+
+// Check checks a value against the replicated filter. It returns false until
+// the first snapshot was loaded.
+func (s *Standby) Check(value []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter != nil && s.filter.Check(value)
+}
+
+// Sequence returns the sequence number up to which the fingerprints of the
+// primary were applied.
+func (s *Standby) Sequence() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.seq
+}
+
+// Verified returns true if the filter matched the digest of the primary after
+// catching up on the current connection.
+func (s *Standby) Verified() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.verified
+}
+
+// Snapshots returns the number of snapshots loaded.
+func (s *Standby) Snapshots() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.snapshots
+}
+
+// WriteFilter writes the replicated filter, e.g. to persist it, while no
+// fingerprints are applied.
+func (s *Standby) WriteFilter(path string, gzip bool, opts ...bloom.WriteOption) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.filter == nil {
+		return errors.New("no snapshot loaded yet")
+	}
+	return bloom.WriteFilter(s.filter, path, gzip, opts...)
+}
@@ -0,0 +1,315 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package replication
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+const (
+	// BlocksPath is the path of the block hashes of the filter served by a
+	// Primary (see BlockList), for blocks of the number of words given in the
+	// parameter "size" (DefaultBlockWords if absent).
+	BlocksPath = "/blocks"
+	// WordsPath is the path of the bit array of the filter served by a
+	// Primary as little-endian 64-bit words, supporting range requests.
+	WordsPath = "/words"
+)
+
+// DefaultBlockWords is the default number of words of the blocks compared by
+// SyncFromURL.
+const DefaultBlockWords = 1024
+
+// maxBlockWords bounds the block size requested from a Primary.
+const maxBlockWords = 1 << 20
+
+// ErrDimensionMismatch is returned by SyncFromURL if the remote filter does
+// not have the same dimensions as the local one.
+var ErrDimensionMismatch = errors.New("remote filter has different dimensions")
+
+// BlockList is served at BlocksPath: the dimensions of the filter and the
+// hashes of its consecutive blocks of words, the last of which may be
+// shorter (see blockHash).
+type BlockList struct {
+	Capacity   uint64   `json:"capacity"`
+	FPP        float64  `json:"fpp"`
+	HashFuncs  uint64   `json:"hash_funcs"`
+	Bits       uint64   `json:"bits"`
+	BlockWords uint64   `json:"block_words"`
+	Hashes     []string `json:"hashes"`
+}
+
+// blockHashes returns the dimensions of the filter and the hashes of its
+// blocks. The hashes are the first 16 bytes of the SHA-256 digest of the
+// little-endian words of a block, hex-encoded.
+func blockHashes(filter *bloom.BloomFilter, blockWords uint64) BlockList {
+	list := BlockList{
+		Capacity:   filter.MaxNumElements(),
+		FPP:        filter.FalsePositiveProb(),
+		HashFuncs:  filter.NumHashFuncs(),
+		Bits:       filter.NumBits(),
+		BlockWords: blockWords,
+	}
+	buf := make([]byte, 0, 8*blockWords)
+	var b [8]byte
+	filter.VisitWords(func(i uint64, word uint64) bool {
+		binary.LittleEndian.PutUint64(b[:], word)
+		buf = append(buf, b[:]...)
+		if (i+1)%blockWords == 0 || i+1 == filter.NumWords() {
+			sum := sha256.Sum256(buf)
+			list.Hashes = append(list.Hashes, hex.EncodeToString(sum[:16]))
+			buf = buf[:0]
+		}
+		return true
+	})
+	return list
+}
+
+func (p *Primary) serveBlocks(w http.ResponseWriter, r *http.Request) {
+	blockWords := uint64(DefaultBlockWords)
+	if size := r.URL.Query().Get("size"); size != "" {
+		var err error
+		blockWords, err = strconv.ParseUint(size, 10, 64)
+		if err != nil || blockWords == 0 || blockWords > maxBlockWords {
+			http.Error(w, "invalid block size", http.StatusBadRequest)
+			return
+		}
+	}
+	p.mu.Lock()
+	list := blockHashes(p.filter, blockWords)
+	p.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// wordReader reads the bit array of the filter of a Primary as little-endian
+// words, locking the Primary for each read.
+type wordReader struct {
+	p      *Primary
+	offset int64
+	size   int64
+}
+
+func (r *wordReader) Read(b []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	r.p.mu.Lock()
+	defer r.p.mu.Unlock()
+	n := 0
+	var word [8]byte
+	for n < len(b) && r.offset < r.size {
+		binary.LittleEndian.PutUint64(word[:], r.p.filter.WordAt(uint64(r.offset/8)))
+		c := copy(b[n:], word[r.offset%8:])
+		n += c
+		r.offset += int64(c)
+	}
+	return n, nil
+}
+
+func (r *wordReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (p *Primary) serveWords(w http.ResponseWriter, r *http.Request) {
+	// the size of the bit array does not change
+	size := int64(8 * p.filter.NumWords())
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", time.Time{}, &wordReader{p: p, size: size})
+}
+
+type syncOptions struct {
+	blockWords uint64
+	replace    bool
+	client     *http.Client
+	retry      bloom.RetryPolicy
+}
+
+// SyncOption configures SyncFromURL.
+type SyncOption func(*syncOptions)
+
+// WithBlockWords sets the number of words of the compared blocks
+// (DefaultBlockWords by default). Smaller blocks transfer fewer unchanged
+// words but more hashes.
+func WithBlockWords(n uint64) SyncOption {
+	return func(o *syncOptions) {
+		o.blockWords = n
+	}
+}
+
+// ReplaceBlocks replaces the differing blocks of the local filter with those
+// of the remote one instead of merging them, which also clears the bits that
+// are only set locally.
+func ReplaceBlocks() SyncOption {
+	return func(o *syncOptions) {
+		o.replace = true
+	}
+}
+
+// WithSyncClient sets the HTTP client used by SyncFromURL
+// (http.DefaultClient by default).
+func WithSyncClient(client *http.Client) SyncOption {
+	return func(o *syncOptions) {
+		o.client = client
+	}
+}
+
+// WithSyncRetry sets the policy for retrying the requests of SyncFromURL that
+// fail transiently (a single attempt by default). Each request for block
+// hashes or words is retried on its own.
+func WithSyncRetry(policy bloom.RetryPolicy) SyncOption {
+	return func(o *syncOptions) {
+		o.retry = policy
+	}
+}
+
+// SyncFromURL updates the local filter from the filter of the Primary served
+// at the given base URL, transferring only the blocks of words whose hashes
+// differ: the bits of the remote blocks are merged into the local ones (see
+// ReplaceBlocks). The filters must have the same dimensions. It returns the
+// number of local words that changed. If any did, the element count of the
+// local filter is replaced by an estimate. The local filter must not be used
+// concurrently, and the remote one may change while it is transferred, in
+// which case the result reflects neither state exactly.
+func SyncFromURL(ctx context.Context, url string, local *bloom.BloomFilter, opts ...SyncOption) (uint64, error) {
+	o := syncOptions{blockWords: DefaultBlockWords, client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.blockWords == 0 || o.blockWords > maxBlockWords {
+		return 0, fmt.Errorf("invalid block size %d", o.blockWords)
+	}
+	remote, err := fetchBlocks(ctx, o, url)
+	if err != nil {
+		return 0, err
+	}
+	if remote.Capacity != local.MaxNumElements() || remote.FPP != local.FalsePositiveProb() ||
+		remote.HashFuncs != local.NumHashFuncs() || remote.Bits != local.NumBits() {
+		return 0, fmt.Errorf("%w: n=%d, p=%g, k=%d, m=%d", ErrDimensionMismatch,
+			remote.Capacity, remote.FPP, remote.HashFuncs, remote.Bits)
+	}
+	hashes := blockHashes(local, o.blockWords).Hashes
+	if len(remote.Hashes) != len(hashes) {
+		return 0, fmt.Errorf("unexpected number of blocks %d (expected %d)", len(remote.Hashes), len(hashes))
+	}
+	var changed uint64
+	// runs of adjacent differing blocks are fetched with one request each
+	for first := 0; first < len(hashes); first++ {
+		if remote.Hashes[first] == hashes[first] {
+			continue
+		}
+		last := first
+		for last+1 < len(hashes) && remote.Hashes[last+1] != hashes[last+1] {
+			last++
+		}
+		from := uint64(first) * o.blockWords
+		to := uint64(last+1) * o.blockWords
+		if to > local.NumWords() {
+			to = local.NumWords()
+		}
+		words, err := fetchWords(ctx, o, url, from, to)
+		if err != nil {
+			return changed, err
+		}
+		changed += local.MergeWords(from, words, o.replace)
+		first = last
+	}
+	if changed > 0 {
+		local.SetNumElements(local.EstimatedNumElements())
+		local.SetMetadata(bloom.MetadataKeyCount, "estimated")
+	}
+	return changed, nil
+}
+
+func fetchBlocks(ctx context.Context, o syncOptions, url string) (BlockList, error) {
+	var list BlockList
+	err := o.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		list, err = fetchBlocksOnce(ctx, o, url)
+		return err
+	})
+	return list, err
+}
+
+func fetchBlocksOnce(ctx context.Context, o syncOptions, url string) (BlockList, error) {
+	var list BlockList
+	req, err := http.NewRequest(http.MethodGet, url+BlocksPath+"?size="+strconv.FormatUint(o.blockWords, 10), nil)
+	if err != nil {
+		return list, err
+	}
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return list, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return list, fmt.Errorf("%w of block hashes", &bloom.HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status})
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return list, fmt.Errorf("invalid block hashes: %w", err)
+	}
+	if list.BlockWords != o.blockWords {
+		return list, fmt.Errorf("unexpected block size %d (expected %d)", list.BlockWords, o.blockWords)
+	}
+	return list, nil
+}
+
+// fetchWords fetches the remote words with indexes from from (inclusive) to
+// to (exclusive).
+func fetchWords(ctx context.Context, o syncOptions, url string, from, to uint64) ([]uint64, error) {
+	var words []uint64
+	err := o.retry.Do(ctx, func(ctx context.Context) error {
+		var err error
+		words, err = fetchWordsOnce(ctx, o, url, from, to)
+		return err
+	})
+	return words, err
+}
+
+func fetchWordsOnce(ctx context.Context, o syncOptions, url string, from, to uint64) ([]uint64, error) {
+	req, err := http.NewRequest(http.MethodGet, url+WordsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", 8*from, 8*to-1))
+	resp, err := o.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("%w of words %d-%d", &bloom.HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}, from, to-1)
+	}
+	b := make([]byte, 8*(to-from))
+	if _, err := io.ReadFull(resp.Body, b); err != nil {
+		return nil, fmt.Errorf("reading words %d-%d: %w", from, to-1, err)
+	}
+	words := make([]uint64, to-from)
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	return words, nil
+}
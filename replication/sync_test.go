@@ -0,0 +1,176 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package replication
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DCSO/bloom"
+)
+
+// countingWriter counts the bytes of the responses of a handler.
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(b []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(b)))
+	return w.ResponseWriter.Write(b)
+}
+
+func copyFilter(t *testing.T, filter *bloom.BloomFilter) *bloom.BloomFilter {
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := bloom.LoadFromReader(&buf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return copied
+}
+
+func TestSyncFromURL(t *testing.T) {
+	filter, err := bloom.New(1000000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary := NewPrimary(filter, 100)
+	defer primary.Close()
+	var transferred int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary.ServeHTTP(countingWriter{w, &transferred}, r)
+	}))
+	defer server.Close()
+
+	addValues(primary, 0, 1000)
+	local := copyFilter(t, filter)
+	addValues(primary, 1000, 1003)
+
+	changed, err := SyncFromURL(context.Background(), server.URL, local, WithBlockWords(256), WithSyncClient(server.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == 0 || changed > 3*filter.NumHashFuncs() {
+		t.Fatalf("unexpected number of changed words %d", changed)
+	}
+	if Digest(local) != Digest(filter) {
+		t.Fatal("synchronized filter differs")
+	}
+	if size := int64(8 * filter.NumWords()); transferred > size/10 {
+		t.Fatalf("transferred %d bytes for a filter of %d bytes", transferred, size)
+	}
+	if !local.CountIsEstimate() {
+		t.Fatal("expected an estimated count")
+	}
+	for i := 0; i < 1003; i++ {
+		if !local.Check([]byte(fmt.Sprintf("value-%d", i))) {
+			t.Fatalf("value %d missing", i)
+		}
+	}
+
+	// nothing is transferred apart from the hashes once in sync
+	transferred = 0
+	if changed, err := SyncFromURL(context.Background(), server.URL, local, WithBlockWords(256), WithSyncClient(server.Client())); changed != 0 || err != nil {
+		t.Fatalf("expected no changes, got %d, %v", changed, err)
+	}
+	if hashes := int64(len(blockHashes(filter, 256).Hashes)); transferred > 40*hashes+200 {
+		t.Fatalf("transferred %d bytes for %d block hashes", transferred, hashes)
+	}
+
+	// merging keeps local values, replacing drops them
+	local.Add([]byte("local"))
+	if changed, err := SyncFromURL(context.Background(), server.URL, local, WithSyncClient(server.Client())); changed != 0 || err != nil {
+		t.Fatalf("expected no changes, got %d, %v", changed, err)
+	}
+	if !local.Check([]byte("local")) {
+		t.Fatal("local value dropped by merge")
+	}
+	if _, err := SyncFromURL(context.Background(), server.URL, local, ReplaceBlocks(), WithSyncClient(server.Client())); err != nil {
+		t.Fatal(err)
+	}
+	if Digest(local) != Digest(filter) {
+		t.Fatal("replaced filter differs")
+	}
+}
+
+func TestSyncFromURLErrors(t *testing.T) {
+	primary := NewPrimary(newFilter(t), 100)
+	defer primary.Close()
+	server := httptest.NewServer(primary)
+	defer server.Close()
+
+	other, err := bloom.New(20000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SyncFromURL(context.Background(), server.URL, other); !errors.Is(err, ErrDimensionMismatch) {
+		t.Fatalf("expected ErrDimensionMismatch, got %v", err)
+	}
+	if _, err := SyncFromURL(context.Background(), server.URL, newFilter(t), WithBlockWords(0)); err == nil {
+		t.Fatal("expected an error for an invalid block size")
+	}
+	resp, err := http.Get(server.URL + BlocksPath + "?size=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+}
+
+func TestSyncFromURLRetry(t *testing.T) {
+	filter := newFilter(t)
+	primary := NewPrimary(filter, 100)
+	defer primary.Close()
+	// the first two requests fail
+	var failures, retries int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&failures, 1) <= 2 {
+			http.Error(w, "failing", http.StatusServiceUnavailable)
+			return
+		}
+		primary.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	addValues(primary, 0, 10)
+	local := copyFilter(t, filter)
+	addValues(primary, 10, 20)
+
+	policy := bloom.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		OnRetry:        func(int, error, time.Duration) { atomic.AddInt64(&retries, 1) },
+	}
+	if _, err := SyncFromURL(context.Background(), server.URL, local, WithSyncClient(server.Client()), WithSyncRetry(policy)); err != nil {
+		t.Fatal(err)
+	}
+	if Digest(local) != Digest(filter) || retries != 2 {
+		t.Fatalf("unexpected result after %d retries", retries)
+	}
+
+	// without retries, the first failure is returned
+	failures = 0
+	var status *bloom.HTTPStatusError
+	if _, err := SyncFromURL(context.Background(), server.URL, local, WithSyncClient(server.Client())); !errors.As(err, &status) || status.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a status error, got %v", err)
+	}
+
+	// giving up
+	failures = -10
+	var retryErr *bloom.RetryError
+	if _, err := SyncFromURL(context.Background(), server.URL, local, WithSyncClient(server.Client()), WithSyncRetry(policy)); !errors.As(err, &retryErr) || retryErr.Attempts != 3 {
+		t.Fatalf("expected to give up after 3 attempts, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrDigestMismatch is returned if a filter does not match its expected
+// SHA-256 digest.
+var ErrDigestMismatch = errors.New("SHA256 digest mismatch")
+
+// resumableBufferSize is the size of the buffer a ResumableLoader reads into.
+const resumableBufferSize = 64 << 10
+
+// ResumableLoader loads a filter from its uncompressed binary representation
+// delivered by a series of readers, e.g. the responses to HTTP range requests
+// after a connection was interrupted. Each reader must continue at the offset
+// returned by Offset. The bit array is decoded into the target filter as it
+// arrives, so only the header, a partial word and the sections following the
+// bit array are buffered.
+type ResumableLoader struct {
+	target *BloomFilter
+	lo     loadOptions
+	digest hash.Hash
+	want   string
+
+	offset     int64
+	header     []byte
+	word       []byte
+	wordsStart int64
+	wordsEnd   int64
+	trailer    []byte
+	done       bool
+	err        error
+}
+
+// NewResumableLoader returns a loader of the filter read into target, which
+// is only usable once Feed has reported that the load is done.
+func NewResumableLoader(target *BloomFilter, opts ...LoadOption) *ResumableLoader {
+	return &ResumableLoader{
+		target: target,
+		lo:     newLoadOptions(opts),
+		header: make([]byte, 0, FormatHeaderSize),
+		word:   make([]byte, 0, FormatWordSize),
+	}
+}
+
+// VerifySHA256 makes the loader verify the complete representation against
+// the given hex-encoded SHA-256 digest when done, failing with an error
+// wrapping ErrDigestMismatch otherwise. It must be called before Feed.
+func (l *ResumableLoader) VerifySHA256(digest string) {
+	l.digest = sha256.New()
+	l.want = digest
+}
+
+// Offset returns the number of bytes consumed so far, i.e. the offset at
+// which the next reader passed to Feed must start.
+func (l *ResumableLoader) Offset() int64 {
+	return l.offset
+}
+
+// Err returns the error that made the load fail permanently, i.e. an invalid
+// or corrupt representation, or nil if the load may be continued.
+func (l *ResumableLoader) Err() error {
+	return l.err
+}
+
+// Feed consumes r until it is exhausted or fails, returning the number of
+// bytes consumed and whether the filter is complete. As the Data section
+// extends to the end of the representation, the load is done when r returns
+// io.EOF after the bit array. Errors of r are returned as they are and the
+// load can be continued with another reader starting at Offset, while errors
+// of the representation are permanent (see Err). If r ends before the bit
+// array is complete, io.ErrUnexpectedEOF is returned.
+func (l *ResumableLoader) Feed(r io.Reader) (consumed int64, done bool, err error) {
+	if l.err != nil || l.done {
+		return 0, l.done, l.err
+	}
+	buf := make([]byte, resumableBufferSize)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			if err := l.consume(buf[:n]); err != nil {
+				l.err = err
+				return consumed, false, err
+			}
+			consumed += int64(n)
+		}
+		if rerr == io.EOF {
+			if l.header == nil && l.offset >= l.wordsEnd {
+				if err := l.finish(); err != nil {
+					l.err = err
+					return consumed, false, err
+				}
+				return consumed, true, nil
+			}
+			return consumed, false, io.ErrUnexpectedEOF
+		}
+		if rerr != nil {
+			return consumed, false, rerr
+		}
+	}
+}
+
+// consume parses the next bytes of the representation.
+func (l *ResumableLoader) consume(p []byte) error {
+	if l.digest != nil {
+		l.digest.Write(p)
+	}
+	for len(p) > 0 {
+		if l.header != nil {
+			n := copy(l.header[len(l.header):cap(l.header)], p)
+			l.header = l.header[:len(l.header)+n]
+			l.offset += int64(n)
+			p = p[n:]
+			if len(l.header) >= FormatCapacityOffset {
+				if err := checkVersion(l.header); err != nil {
+					return err
+				}
+			}
+			if len(l.header) == FormatHeaderSize && cap(l.header) == FormatHeaderSize {
+				// extend the header by the comment region, if any
+				size, err := commentSize(l.header)
+				if err != nil {
+					return err
+				}
+				l.header = append(make([]byte, 0, FormatHeaderSize+size), l.header...)
+			}
+			if len(l.header) == cap(l.header) {
+				if err := l.target.parseHeader(l.header); err != nil {
+					return err
+				}
+				l.trailer = append(l.trailer[:0], l.header[:FormatHeaderSize]...)
+				l.header = nil
+				l.wordsStart = l.offset
+				l.wordsEnd = l.offset + FormatWordSize*int64(l.target.M)
+			}
+			continue
+		}
+		if l.offset < l.wordsEnd {
+			n := l.consumeWords(p)
+			l.offset += int64(n)
+			p = p[n:]
+			continue
+		}
+		if limit := l.trailerLimit(); limit > 0 && int64(len(l.trailer)+len(p)) > limit {
+			return fmt.Errorf("%w (more than %d bytes)", ErrDataTooLarge, l.lo.maxDataSize)
+		}
+		l.trailer = append(l.trailer, p...)
+		l.offset += int64(len(p))
+		p = nil
+	}
+	return nil
+}
+
+// consumeWords decodes the bytes of the bit array at the beginning of p and
+// returns their number.
+func (l *ResumableLoader) consumeWords(p []byte) int {
+	if remaining := l.wordsEnd - l.offset; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	index := (l.offset - l.wordsStart) / FormatWordSize
+	n := 0
+	if len(l.word) > 0 {
+		c := copy(l.word[len(l.word):cap(l.word)], p)
+		l.word = l.word[:len(l.word)+c]
+		n += c
+		if len(l.word) < FormatWordSize {
+			return n
+		}
+		decodeWords(l.target.v[index:index+1], l.word)
+		l.word = l.word[:0]
+		index++
+	}
+	whole := (len(p) - n) / FormatWordSize
+	decodeWords(l.target.v[index:index+int64(whole)], p[n:])
+	n += FormatWordSize * whole
+	l.word = append(l.word, p[n:]...)
+	return len(p)
+}
+
+// trailerLimit returns the maximum size of the header and the sections
+// following the bit array, or 0 if it is not limited.
+func (l *ResumableLoader) trailerLimit() int64 {
+	if l.lo.maxDataSize <= 0 {
+		return 0
+	}
+	return FormatHeaderSize + FormatLengthSize + maxMetadataSize + l.lo.maxDataSize
+}
+
+// finish reads the sections following the bit array and verifies the digest.
+func (l *ResumableLoader) finish() error {
+	header, sections := l.trailer[:FormatHeaderSize], l.trailer[FormatHeaderSize:]
+	defer l.target.invalidate()
+	if err := l.target.readSections(bytes.NewReader(sections), header, l.lo); err != nil {
+		return err
+	}
+	if l.digest != nil && !strings.EqualFold(hex.EncodeToString(l.digest.Sum(nil)), l.want) {
+		return fmt.Errorf("loaded filter is corrupt (%w)", ErrDigestMismatch)
+	}
+	l.trailer = nil
+	l.done = true
+	return nil
+}
@@ -0,0 +1,167 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errConnectionReset = errors.New("connection reset")
+
+// flakyReader returns data in reads of random size and fails after limit
+// bytes.
+type flakyReader struct {
+	data  []byte
+	limit int
+	rng   *rand.Rand
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if r.limit == 0 {
+		return 0, errConnectionReset
+	}
+	n := 1 + r.rng.Intn(100)
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(r.data) {
+		n = len(r.data)
+	}
+	if n > r.limit {
+		n = r.limit
+	}
+	n = copy(p, r.data[:n])
+	r.data, r.limit = r.data[n:], r.limit-n
+	return n, nil
+}
+
+func serializedFilterWithMetadata(t *testing.T) []byte {
+	filter, _ := GenerateExampleFilter(1000, 0.01, 100)
+	filter.SetMetadata("source", "feed")
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResumableLoader(t *testing.T) {
+	serialized := serializedFilterWithMetadata(t)
+	sum := sha256.Sum256(serialized)
+	for seed := int64(0); seed < 20; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		var filter BloomFilter
+		loader := NewResumableLoader(&filter)
+		loader.VerifySHA256(hex.EncodeToString(sum[:]))
+		for feeds := 0; ; feeds++ {
+			if feeds > len(serialized) {
+				t.Fatalf("seed %d: no progress", seed)
+			}
+			offset := loader.Offset()
+			r := &flakyReader{data: serialized[offset:], limit: rng.Intn(len(serialized) / 4), rng: rng}
+			consumed, done, err := loader.Feed(r)
+			if loader.Offset() != offset+consumed {
+				t.Fatalf("seed %d: consumed %d bytes at offset %d, now at %d", seed, consumed, offset, loader.Offset())
+			}
+			if done {
+				break
+			}
+			if err != errConnectionReset && err != io.ErrUnexpectedEOF {
+				t.Fatalf("seed %d: unexpected error %v", seed, err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := filter.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), serialized) {
+			t.Fatalf("seed %d: reassembled filter differs", seed)
+		}
+		if _, done, err := loader.Feed(strings.NewReader("more")); !done || err != nil {
+			t.Fatalf("seed %d: expected a completed load, got %v, %v", seed, done, err)
+		}
+	}
+}
+
+func TestResumableLoaderErrors(t *testing.T) {
+	serialized := serializedFilterWithMetadata(t)
+
+	var filter BloomFilter
+	loader := NewResumableLoader(&filter)
+	loader.VerifySHA256(strings.Repeat("00", sha256.Size))
+	if _, done, err := loader.Feed(bytes.NewReader(serialized)); done || !errors.Is(err, ErrDigestMismatch) || loader.Err() != err {
+		t.Fatalf("expected a digest mismatch, got %v, %v", done, err)
+	}
+
+	loader = NewResumableLoader(&filter)
+	if _, _, err := loader.Feed(bytes.NewReader(serialized[:FormatHeaderSize+10])); err != io.ErrUnexpectedEOF || loader.Err() != nil {
+		t.Fatalf("expected a resumable unexpected EOF, got %v", err)
+	}
+
+	loader = NewResumableLoader(&filter)
+	if _, _, err := loader.Feed(strings.NewReader("not a filter")); err == nil || loader.Err() == nil {
+		t.Fatalf("expected a permanent error, got %v", err)
+	}
+
+	loader = NewResumableLoader(&filter, MaxDataSize(2))
+	if _, _, err := loader.Feed(bytes.NewReader(serialized)); !errors.Is(err, ErrDataTooLarge) {
+		t.Fatalf("expected ErrDataTooLarge, got %v", err)
+	}
+}
+
+func TestLoadManifestResume(t *testing.T) {
+	serialized := serializedFilterWithMetadata(t)
+	sum := sha256.Sum256(serialized)
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Range") == "" {
+			// interrupt the first download half-way
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", "1000000")
+			w.Write(serialized[:len(serialized)/2])
+			return
+		}
+		http.ServeContent(w, r, "remote.bloom", time.Time{}, bytes.NewReader(serialized))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestManifest(t, dir, Manifest{Filters: []ManifestEntry{
+		{Name: "remote", Source: server.URL + "/remote.bloom", SHA256: hex.EncodeToString(sum[:])},
+	}})
+	filters, err := LoadManifest(path, WithHTTPClient(server.Client()), StrictManifest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+	var buf bytes.Buffer
+	if err := filters[0].Filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), serialized) {
+		t.Fatal("resumed filter differs")
+	}
+}
@@ -0,0 +1,163 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Defaults of RetryPolicy for fields left zero.
+const (
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff     = 10 * time.Second
+)
+
+// HTTPStatusError is returned for a response with an unexpected status code.
+type HTTPStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// statusError returns the error for an unexpected response.
+func statusError(resp *http.Response) error {
+	return &HTTPStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+}
+
+// RetryError is returned by RetryPolicy.Do if the last of the attempts failed.
+type RetryError struct {
+	// Attempts is the number of attempts made.
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+	// Interrupted is the error of the context if it was done while waiting
+	// for the next attempt.
+	Interrupted error
+}
+
+func (e *RetryError) Error() string {
+	if e.Interrupted != nil {
+		return fmt.Sprintf("giving up after %d attempts (%s): %s", e.Attempts, e.Interrupted, e.Err)
+	}
+	return fmt.Sprintf("giving up after %d attempts: %s", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// RetryPolicy retries remote loads that fail transiently: with transport
+// errors such as connection resets, with responses that end early, with
+// responses whose status code is in one of the retried status classes, and
+// attempts that time out. The zero value makes a single attempt.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt, which doubles
+	// with each further attempt up to MaxBackoff (by default
+	// DefaultRetryInitialBackoff and DefaultRetryMaxBackoff).
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Jitter is the fraction of each delay, from 0 to 1, that is randomly
+	// subtracted from it, so that clients do not retry in lockstep.
+	Jitter float64
+	// AttemptTimeout, if positive, limits the duration of each attempt,
+	// including reading the response.
+	AttemptTimeout time.Duration
+	// RetryStatusClasses are the classes of status codes that are retried,
+	// e.g. 5 for 5xx (by default only 5xx).
+	RetryStatusClasses []int
+	// OnRetry, if set, is called before waiting for each retry with the
+	// number of the failed attempt, starting at 1, its error and the delay,
+	// e.g. to count retries.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// Do calls attempt until it succeeds, it fails with an error that is not
+// transient, the attempts are exhausted or ctx is done. Each attempt is
+// passed a context limited by AttemptTimeout, which must cover the complete
+// exchange, including reading the response. If the last attempt fails, a
+// *RetryError wrapping its error is returned, unless the policy makes a single
+// attempt, in which case the error is returned as is.
+func (p RetryPolicy) Do(ctx context.Context, attempt func(ctx context.Context) error) error {
+	backoff := p.InitialBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryInitialBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = DefaultRetryMaxBackoff
+	}
+	for n := 1; ; n++ {
+		err := p.attempt(ctx, attempt)
+		if err == nil {
+			return nil
+		}
+		if p.MaxAttempts <= 1 {
+			return err
+		}
+		if n >= p.MaxAttempts || ctx.Err() != nil || !p.retryable(err) {
+			return &RetryError{Attempts: n, Err: err}
+		}
+		delay := backoff
+		if p.Jitter > 0 {
+			delay -= time.Duration(p.Jitter * rand.Float64() * float64(delay))
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(n, err, delay)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &RetryError{Attempts: n, Err: err, Interrupted: ctx.Err()}
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// attempt makes a single attempt, limited by AttemptTimeout.
+func (p RetryPolicy) attempt(ctx context.Context, attempt func(ctx context.Context) error) error {
+	if p.AttemptTimeout <= 0 {
+		return attempt(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.AttemptTimeout)
+	defer cancel()
+	return attempt(ctx)
+}
+
+// retryable returns true if an attempt failed with a transient error.
+func (p RetryPolicy) retryable(err error) bool {
+	var status *HTTPStatusError
+	if errors.As(err, &status) {
+		classes := p.RetryStatusClasses
+		if classes == nil {
+			classes = []int{5}
+		}
+		for _, class := range classes {
+			if status.StatusCode/100 == class {
+				return true
+			}
+		}
+		return false
+	}
+	var urlErr *url.Error
+	var netErr net.Error
+	return errors.As(err, &urlErr) || errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
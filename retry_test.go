@@ -0,0 +1,179 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer serves data after failing the first failures requests, with
+// the given status or, if it is zero, by resetting the connection after part
+// of the data.
+func flakyServer(t *testing.T, data []byte, failures int64, status int) (*httptest.Server, *int64) {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= failures {
+			if status != 0 {
+				http.Error(w, "failing", status)
+				return
+			}
+			w.Header().Set("Content-Length", "100000")
+			w.Write(data[:len(data)/2])
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestRetryPolicy(t *testing.T) {
+	errTransient := &HTTPStatusError{StatusCode: 503, Status: "503 Service Unavailable"}
+	var retries []int
+	policy := RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Millisecond,
+		Jitter:         0.5,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			if delay > time.Duration(1<<uint(attempt-1))*time.Millisecond || delay <= 0 {
+				t.Errorf("unexpected delay %s before attempt %d", delay, attempt+1)
+			}
+			retries = append(retries, attempt)
+		},
+	}
+	attempts := 0
+	err := policy.Do(context.Background(), func(context.Context) error {
+		if attempts++; attempts < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 || len(retries) != 2 {
+		t.Fatalf("expected success after 3 attempts and 2 retries, got %v after %d, %v", err, attempts, retries)
+	}
+
+	// giving up
+	attempts, retries = 0, nil
+	err = policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return errTransient
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 4 || !errors.Is(err, errTransient) || attempts != 4 || len(retries) != 3 {
+		t.Fatalf("expected to give up after 4 attempts, got %v after %d", err, attempts)
+	}
+
+	// errors that are not transient are not retried
+	attempts = 0
+	err = policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return &HTTPStatusError{StatusCode: 404, Status: "404 Not Found"}
+	})
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || attempts != 1 {
+		t.Fatalf("expected a single attempt, got %v after %d", err, attempts)
+	}
+	policy.RetryStatusClasses = []int{4, 5}
+	attempts = 0
+	policy.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return &HTTPStatusError{StatusCode: 429, Status: "429 Too Many Requests"}
+	})
+	if attempts != 4 {
+		t.Fatalf("expected 4xx to be retried, got %d attempts", attempts)
+	}
+
+	// the zero policy makes a single attempt and returns its error as is
+	attempts = 0
+	err = RetryPolicy{}.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient || attempts != 1 {
+		t.Fatalf("unexpected result of the zero policy: %v after %d", err, attempts)
+	}
+}
+
+func TestRetryPolicyDeadlines(t *testing.T) {
+	// attempts that time out are retried
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, AttemptTimeout: 20 * time.Millisecond}
+	attempts := 0
+	err := policy.Do(context.Background(), func(ctx context.Context) error {
+		if attempts++; attempts == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected success after a timed out attempt, got %v after %d", err, attempts)
+	}
+
+	// the deadline of the context ends the backoff
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	policy = RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Minute}
+	start := time.Now()
+	err = policy.Do(ctx, func(context.Context) error {
+		return &HTTPStatusError{StatusCode: 500, Status: "500 Internal Server Error"}
+	})
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 1 || retryErr.Interrupted != context.DeadlineExceeded {
+		t.Fatalf("expected to be interrupted after 1 attempt, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Fatal("backoff not interrupted by the deadline")
+	}
+}
+
+func TestLoadManifestRetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filter := mustNew(1000, 0.001)
+	filter.Add([]byte("remote"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+
+	for _, status := range []int{http.StatusServiceUnavailable, 0} {
+		// two failures are retried
+		server, requests := flakyServer(t, buf.Bytes(), 2, status)
+		var retries int
+		policy.OnRetry = func(int, error, time.Duration) { retries++ }
+		path := writeTestManifest(t, dir, Manifest{Filters: []ManifestEntry{{Name: "remote", Source: server.URL}}})
+		filters, err := LoadManifest(path, WithHTTPClient(server.Client()), WithManifestRetry(policy), StrictManifest())
+		if err != nil {
+			t.Fatalf("status %d: %v", status, err)
+		}
+		if !filters[0].Filter.Check([]byte("remote")) || *requests != 3 || retries != 2 {
+			t.Fatalf("status %d: unexpected result after %d requests and %d retries", status, *requests, retries)
+		}
+
+		// three are not
+		server, requests = flakyServer(t, buf.Bytes(), 3, status)
+		path = writeTestManifest(t, dir, Manifest{Filters: []ManifestEntry{{Name: "remote", Source: server.URL}}})
+		filters, err = LoadManifest(path, WithHTTPClient(server.Client()), WithManifestRetry(policy))
+		var retryErr *RetryError
+		if err != nil || !errors.As(filters[0].Err, &retryErr) || retryErr.Attempts != 3 || *requests != 3 {
+			t.Fatalf("status %d: expected to give up after 3 attempts, got %v, %v", status, err, filters[0].Err)
+		}
+	}
+}
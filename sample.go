@@ -0,0 +1,111 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strconv"
+)
+
+// MetadataKeySampleFraction records the fraction of bits kept in a filter
+// sampled with SampleBits.
+const MetadataKeySampleFraction = "bloom.sample-fraction"
+
+// SampleBits returns a filter with the same dimensions and settings in which
+// each set bit of the filter is kept with the given probability, chosen
+// deterministically by the seed. The Data of the filter is not copied.
+//
+// As a value only matches if all of its k bits were kept, which happens with
+// probability fraction^k, the sample misses most values of the filter and is
+// hence unsuitable for lookups. It reveals correspondingly little about the
+// filter, but still allows estimating its overlap with other filters with
+// EstimateOverlapFromSample. The count of the sample is the estimated number
+// of elements of the filter.
+func (s *BloomFilter) SampleBits(fraction float64, seed int64) (*BloomFilter, error) {
+	if fraction <= 0 || fraction > 1 || math.IsNaN(fraction) {
+		return nil, fmt.Errorf("invalid sample fraction %g (must be in (0, 1])", fraction)
+	}
+	// sampling a sample keeps the product of the fractions
+	total, err := s.SampleFraction()
+	if err != nil {
+		return nil, err
+	}
+	sample := s.emptyCopy()
+	sample.SetData(nil)
+	rng := rand.New(rand.NewSource(seed))
+	for i, word := range s.v {
+		for word != 0 {
+			bit := uint64(1) << bits.TrailingZeros64(word)
+			if rng.Float64() < fraction {
+				sample.v[i] |= bit
+			}
+			word &^= bit
+		}
+	}
+	sample.SetNumElements(s.NumElements())
+	if _, ok := s.Metadata(MetadataKeySampleFraction); !ok {
+		sample.SetNumElements(s.EstimatedNumElements())
+	}
+	sample.SetMetadata(MetadataKeyCount, "estimated")
+	sample.SetMetadata(MetadataKeySampleFraction, strconv.FormatFloat(total*fraction, 'g', -1, 64))
+	return sample, nil
+}
+
+// SampleFraction returns the fraction of bits kept in a filter sampled with
+// SampleBits, or 1 if the filter is not a sample.
+func (s *BloomFilter) SampleFraction() (float64, error) {
+	v, ok := s.Metadata(MetadataKeySampleFraction)
+	if !ok {
+		return 1, nil
+	}
+	fraction, err := strconv.ParseFloat(v, 64)
+	if err != nil || fraction <= 0 || fraction > 1 {
+		return 0, fmt.Errorf("invalid sample fraction %q", v)
+	}
+	return fraction, nil
+}
+
+// EstimateOverlapFromSample estimates the number of elements that a filter
+// sampled with SampleBits has in common with another filter with the same
+// dimensions, correcting the numbers of set bits of the sample for the
+// sampling fraction. Like EstimatedNumElements, the estimate is derived from
+// the fractions of set bits, so it becomes inaccurate for filters filled far
+// beyond their capacity, and it is noisier the smaller the fraction.
+func EstimateOverlapFromSample(sample, other *BloomFilter) (uint64, error) {
+	if err := sample.checkDimensions(other); err != nil {
+		return 0, err
+	}
+	fraction, err := sample.SampleFraction()
+	if err != nil {
+		return 0, err
+	}
+	var sampleBits, otherBits, commonBits uint64
+	for i := range sample.v {
+		sampleBits += uint64(bits.OnesCount64(sample.v[i]))
+		otherBits += uint64(bits.OnesCount64(other.v[i]))
+		commonBits += uint64(bits.OnesCount64(sample.v[i] & other.v[i]))
+	}
+	// set bits of the original filter and of the union, corrected for the
+	// bits that were dropped
+	original := float64(sampleBits) / fraction
+	union := original + float64(otherBits) - float64(commonBits)/fraction
+	overlap := sample.elementsForSetBits(original) + sample.elementsForSetBits(float64(otherBits)) -
+		sample.elementsForSetBits(union)
+	if overlap < 0 {
+		return 0, nil
+	}
+	return uint64(math.Round(overlap)), nil
+}
+
+// elementsForSetBits returns the estimated number of elements of the filter
+// with the given number of set bits, see EstimatedNumElements.
+func (s *BloomFilter) elementsForSetBits(x float64) float64 {
+	if x >= float64(s.m) {
+		x = float64(s.m) - 1
+	}
+	return -float64(s.m) / float64(s.k) * math.Log1p(-x/float64(s.m))
+}
@@ -0,0 +1,117 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func overlappingFilters(shared, distinct int) (*BloomFilter, *BloomFilter) {
+	a := mustNew(100000, 0.001)
+	b := mustNew(100000, 0.001)
+	for i := 0; i < shared; i++ {
+		value := []byte(fmt.Sprintf("shared-%d", i))
+		a.Add(value)
+		b.Add(value)
+	}
+	for i := 0; i < distinct; i++ {
+		a.Add([]byte(fmt.Sprintf("a-%d", i)))
+		b.Add([]byte(fmt.Sprintf("b-%d", i)))
+	}
+	return a, b
+}
+
+func TestEstimateOverlapFromSample(t *testing.T) {
+	a, b := overlappingFilters(5000, 15000)
+	for _, fraction := range []float64{1, 0.5, 0.1} {
+		sample, err := a.SampleBits(fraction, 42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		overlap, err := EstimateOverlapFromSample(sample, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(float64(overlap)-5000) > 500 {
+			t.Errorf("fraction %v: overlap estimate too far from 5000: %d", fraction, overlap)
+		}
+	}
+
+	disjoint, _ := overlappingFilters(0, 20000)
+	sample, _ := disjoint.SampleBits(0.1, 1)
+	c := mustNew(100000, 0.001)
+	for i := 0; i < 20000; i++ {
+		c.Add([]byte(fmt.Sprintf("c-%d", i)))
+	}
+	overlap, err := EstimateOverlapFromSample(sample, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if overlap > 500 {
+		t.Errorf("overlap estimate of disjoint filters too large: %d", overlap)
+	}
+
+	if _, err := EstimateOverlapFromSample(sample, mustNew(1000, 0.001)); err == nil {
+		t.Fatal("expected filters with different dimensions to be rejected")
+	}
+}
+
+func TestSampleBits(t *testing.T) {
+	filter, values := GenerateExampleFilter(10000, 0.001, 5000)
+	sample, err := filter.SampleBits(0.5, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := filter.SampleBits(0.5, 7)
+	other, _ := filter.SampleBits(0.5, 8)
+	differs := false
+	for i := range sample.v {
+		if sample.v[i] != again.v[i] {
+			t.Fatal("sample not deterministic for the same seed")
+		}
+		if sample.v[i]&^filter.v[i] != 0 {
+			t.Fatal("sample has bits not set in the filter")
+		}
+		differs = differs || sample.v[i] != other.v[i]
+	}
+	if !differs {
+		t.Fatal("samples with different seeds are identical")
+	}
+	kept := float64(sample.NumSetBits()) / float64(filter.NumSetBits())
+	if kept < 0.48 || kept > 0.52 {
+		t.Fatalf("unexpected fraction of kept bits %.3f", kept)
+	}
+	if sample.DataSize() != 0 || !sample.CountIsEstimate() {
+		t.Fatal("sample should have no data and an estimated count")
+	}
+
+	// values match with a probability of fraction^k
+	matched := 0
+	for _, v := range values {
+		if sample.Check(v) {
+			matched++
+		}
+	}
+	expected := math.Pow(0.5, float64(filter.k)) * float64(len(values))
+	if float64(matched) > 2*expected+10 {
+		t.Fatalf("%d values matched in the sample, expected about %.0f", matched, expected)
+	}
+
+	// sampling a sample multiplies the fractions
+	subsample, _ := sample.SampleBits(0.5, 7)
+	if fraction, err := subsample.SampleFraction(); err != nil || fraction != 0.25 {
+		t.Fatalf("unexpected fraction %v (%v)", fraction, err)
+	}
+	if fraction, err := filter.SampleFraction(); err != nil || fraction != 1 {
+		t.Fatalf("unexpected fraction %v of a filter that is no sample (%v)", fraction, err)
+	}
+
+	for _, fraction := range []float64{0, -0.1, 1.1, math.NaN()} {
+		if _, err := filter.SampleBits(fraction, 1); err == nil {
+			t.Fatalf("expected fraction %v to be rejected", fraction)
+		}
+	}
+}
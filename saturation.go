@@ -0,0 +1,131 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "sort"
+
+// DefaultSaturationThresholds are the thresholds of a SaturationAlarm at
+// which users should be warned: half, once and twice the designed FP
+// probability.
+var DefaultSaturationThresholds = []float64{0.5, 1, 2}
+
+// SaturationCheckInterval is the number of values after which a
+// SaturationAlarm evaluates the FP probability of its filter, unless 1% of
+// the capacity of the filter is less.
+const SaturationCheckInterval = 1024
+
+// SaturationAlarm adds values to a filter and calls a function when the FP
+// probability estimated from the fraction of set bits (see
+// EstimatedFalsePositiveProb) first exceeds each of a number of thresholds,
+// given as factors of the FP probability the filter was designed for. The set
+// bits are counted once when the alarm is created and tracked as values are
+// added through the alarm, so the filter must not be modified otherwise
+// meanwhile.
+type SaturationAlarm struct {
+	filter     *BloomFilter
+	thresholds []float64
+	fn         func(threshold float64, stats FilterStats)
+	setBits    uint64
+	pending    uint64
+	interval   uint64
+}
+
+// NewSaturationAlarm returns an alarm for the filter calling fn with each
+// threshold (a factor of the designed FP probability) and the statistics of
+// the filter when the threshold is exceeded, at most once per threshold.
+func NewSaturationAlarm(filter *BloomFilter, thresholds []float64, fn func(threshold float64, stats FilterStats)) *SaturationAlarm {
+	sorted := append([]float64(nil), thresholds...)
+	sort.Float64s(sorted)
+	return &SaturationAlarm{
+		filter:     filter,
+		thresholds: sorted,
+		fn:         fn,
+		setBits:    filter.NumSetBits(),
+		interval:   checkInterval(filter.n),
+	}
+}
+
+func checkInterval(n uint64) uint64 {
+	interval := n / 100
+	if interval > SaturationCheckInterval {
+		return SaturationCheckInterval
+	}
+	if interval == 0 {
+		return 1
+	}
+	return interval
+}
+
+// Filter returns the filter of the alarm.
+func (a *SaturationAlarm) Filter() *BloomFilter {
+	return a.filter
+}
+
+// Add adds a value to the filter like Add of BloomFilter and evaluates the
+// alarm every SaturationCheckInterval values (see there).
+func (a *SaturationAlarm) Add(value []byte) {
+	a.TestAndAdd(value)
+}
+
+// TestAndAdd adds a value like Add and returns whether it may have been in
+// the filter before, like TestAndAdd of BloomFilter.
+func (a *SaturationAlarm) TestAndAdd(value []byte) bool {
+	if a.filter.rejects(value) {
+		return false
+	}
+	newBits := a.filter.add(value)
+	a.setBits += newBits
+	a.pending++
+	if a.pending >= a.interval {
+		a.Evaluate()
+	}
+	return newBits == 0
+}
+
+// TryAdd adds a value like Add, but returns an error wrapping
+// ErrValueTooLarge if the value is rejected due to its length.
+func (a *SaturationAlarm) TryAdd(value []byte) error {
+	if a.filter.rejects(value) {
+		return a.filter.valueTooLarge(value)
+	}
+	a.Add(value)
+	return nil
+}
+
+// Check checks a value against the filter.
+func (a *SaturationAlarm) Check(value []byte) bool {
+	return a.filter.Check(value)
+}
+
+// Rejects returns true if the filter rejects the value due to its length,
+// like Rejects of BloomFilter.
+func (a *SaturationAlarm) Rejects(value []byte) bool {
+	return a.filter.rejects(value)
+}
+
+// TryCheck checks a value against the filter like TryCheck of BloomFilter.
+func (a *SaturationAlarm) TryCheck(value []byte) (bool, error) {
+	return a.filter.TryCheck(value)
+}
+
+// EstimatedFalsePositiveProb returns the FP probability of the filter
+// estimated from the tracked number of set bits.
+func (a *SaturationAlarm) EstimatedFalsePositiveProb() float64 {
+	return a.filter.falsePositiveProbAt(a.setBits)
+}
+
+// Evaluate calls the function of the alarm for all thresholds exceeded since
+// the last evaluation, in ascending order.
+func (a *SaturationAlarm) Evaluate() {
+	a.pending = 0
+	if len(a.thresholds) == 0 {
+		return
+	}
+	p := a.EstimatedFalsePositiveProb()
+	for len(a.thresholds) > 0 && p > a.thresholds[0]*a.filter.p {
+		threshold := a.thresholds[0]
+		a.thresholds = a.thresholds[1:]
+		a.fn(threshold, a.filter.Stats())
+	}
+}
@@ -0,0 +1,95 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSaturationAlarm(t *testing.T) {
+	filter := mustNew(100000, 0.01)
+	var fired []float64
+	var ratios []float64
+	alarm := NewSaturationAlarm(filter, []float64{2, 0.5, 1}, func(threshold float64, stats FilterStats) {
+		if stats.Elements != filter.N {
+			t.Fatalf("unexpected stats %+v", stats)
+		}
+		fired = append(fired, threshold)
+		ratios = append(ratios, filter.EstimatedFalsePositiveProb()/(threshold*0.01))
+	})
+	for i := 0; i < 200000; i++ {
+		alarm.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	alarm.Evaluate()
+	if len(fired) != 3 || fired[0] != 0.5 || fired[1] != 1 || fired[2] != 2 {
+		t.Fatalf("expected each threshold to fire once in order, got %v", fired)
+	}
+	// the alarm fires within a check interval of crossing the threshold
+	for i, ratio := range ratios {
+		if ratio <= 1 || ratio > 1.2 {
+			t.Errorf("threshold %v fired at %.2f times the threshold", fired[i], ratio)
+		}
+	}
+	if alarm.setBits != filter.NumSetBits() {
+		t.Fatalf("tracked %d set bits, counted %d", alarm.setBits, filter.NumSetBits())
+	}
+	if alarm.EstimatedFalsePositiveProb() != filter.EstimatedFalsePositiveProb() {
+		t.Fatal("tracked FP probability differs from the counted one")
+	}
+
+	// thresholds exceeded already when the alarm is created fire at the first
+	// evaluation
+	fired = nil
+	alarm = NewSaturationAlarm(filter, DefaultSaturationThresholds, func(threshold float64, stats FilterStats) {
+		fired = append(fired, threshold)
+	})
+	alarm.Evaluate()
+	alarm.Evaluate()
+	if len(fired) != 3 {
+		t.Fatalf("expected all thresholds to fire once, got %v", fired)
+	}
+}
+
+func TestConsumeChannelSaturationAlarm(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	ch := make(chan []byte)
+	go func() {
+		for i := 0; i < 3000; i++ {
+			ch <- []byte(fmt.Sprintf("value-%d", i))
+		}
+		close(ch)
+	}()
+	var fired []float64
+	_, err := filter.ConsumeChannel(context.Background(), ch,
+		WithSaturationAlarm(DefaultSaturationThresholds, func(threshold float64, stats FilterStats) {
+			fired = append(fired, threshold)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fired) != 3 {
+		t.Fatalf("expected all thresholds to fire once, got %v", fired)
+	}
+}
+
+func TestSaturationAlarmTryAdd(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	filter.SetMaxValueLength(4, RejectValues)
+	alarm := NewSaturationAlarm(filter, []float64{1}, func(float64, FilterStats) {})
+	if err := alarm.TryAdd([]byte("toolong")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if err := alarm.TryAdd([]byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if !alarm.TestAndAdd([]byte("foo")) || alarm.TestAndAdd([]byte("bar")) {
+		t.Fatal("unexpected result of TestAndAdd")
+	}
+	if filter.N != 2 || !alarm.Check([]byte("foo")) || alarm.setBits != filter.NumSetBits() {
+		t.Fatalf("unexpected filter state after TryAdd (N=%d)", filter.N)
+	}
+}
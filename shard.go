@@ -0,0 +1,181 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MetadataKeyShard records the shard of a filter written by a ShardedFilter,
+// as "index/shards" (e.g. "3/8").
+const MetadataKeyShard = "bloom.shard"
+
+// ShardRouter assigns values to one of a fixed number of shards.
+//
+// The shard of a value is its CRC-32 checksum (IEEE polynomial, as computed by
+// zlib's crc32() or Python's zlib.crc32()) modulo the number of shards. The
+// checksum is computed over the value as given, before any truncation, and is
+// independent of the FNV-1 hash that determines the bits of a value in a
+// filter, so that the values of each shard are spread evenly over its bits.
+type ShardRouter struct {
+	shards uint32
+}
+
+// NewShardRouter returns a router for the given number of shards.
+func NewShardRouter(shards int) (*ShardRouter, error) {
+	if shards < 1 || uint64(shards) > 1<<32-1 {
+		return nil, fmt.Errorf("invalid number of shards %d", shards)
+	}
+	return &ShardRouter{shards: uint32(shards)}, nil
+}
+
+// Shards returns the number of shards.
+func (r *ShardRouter) Shards() int {
+	return int(r.shards)
+}
+
+// Shard returns the index of the shard of a value.
+func (r *ShardRouter) Shard(value []byte) int {
+	return int(crc32.ChecksumIEEE(value) % r.shards)
+}
+
+// ShardPath returns the path of a shard given a pattern containing a single
+// %d verb for the index of the shard, e.g. "out-%d.bloom".
+func ShardPath(pattern string, shard int) (string, error) {
+	if strings.Count(strings.ReplaceAll(pattern, "%%", ""), "%") != 1 || !strings.Contains(pattern, "%d") {
+		return "", fmt.Errorf("shard path pattern %q must contain a single %%d", pattern)
+	}
+	return fmt.Sprintf(pattern, shard), nil
+}
+
+// ShardedFilter distributes values across several filters, using a
+// ShardRouter to select the filter for each value. As every value is only
+// added to a single filter, the shards can be built and queried in parallel.
+type ShardedFilter struct {
+	router  *ShardRouter
+	filters []*BloomFilter
+}
+
+// NewShardedFilter returns a sharded filter with the given number of shards,
+// each being a new filter with a capacity of n/shards (rounded up), the FP
+// probability p and the given options.
+func NewShardedFilter(n uint64, p float64, shards int, opts ...Option) (*ShardedFilter, error) {
+	router, err := NewShardRouter(shards)
+	if err != nil {
+		return nil, err
+	}
+	perShard := (n + uint64(shards) - 1) / uint64(shards)
+	filters := make([]*BloomFilter, shards)
+	for i := range filters {
+		if filters[i], err = New(perShard, p, opts...); err != nil {
+			return nil, err
+		}
+		filters[i].SetMetadata(MetadataKeyShard, formatShard(i, shards))
+	}
+	return &ShardedFilter{router: router, filters: filters}, nil
+}
+
+// LoadShardedFilter loads the shards of a sharded filter from the paths given
+// by the pattern (see ShardPath). An error is returned if a shard records a
+// different index or number of shards, or if a file exists for a further
+// shard, as lookups would otherwise be routed to the wrong shards.
+func LoadShardedFilter(pattern string, shards int, gzip bool, opts ...LoadOption) (*ShardedFilter, error) {
+	router, err := NewShardRouter(shards)
+	if err != nil {
+		return nil, err
+	}
+	filters := make([]*BloomFilter, shards)
+	for i := range filters {
+		path, err := ShardPath(pattern, i)
+		if err != nil {
+			return nil, err
+		}
+		if filters[i], err = LoadFilter(path, gzip, opts...); err != nil {
+			return nil, err
+		}
+		if v, ok := filters[i].Metadata(MetadataKeyShard); ok && v != formatShard(i, shards) {
+			return nil, fmt.Errorf("%s is shard %s, not %s", path, v, formatShard(i, shards))
+		}
+	}
+	path, _ := ShardPath(pattern, shards)
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("found more than %d shards (%s exists)", shards, path)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	return &ShardedFilter{router: router, filters: filters}, nil
+}
+
+func formatShard(shard, shards int) string {
+	return strconv.Itoa(shard) + "/" + strconv.Itoa(shards)
+}
+
+// Router returns the router of the sharded filter.
+func (s *ShardedFilter) Router() *ShardRouter {
+	return s.router
+}
+
+// Filters returns the filters of the shards, in the order of their indexes.
+func (s *ShardedFilter) Filters() []*BloomFilter {
+	return s.filters
+}
+
+// Filter returns the filter of the shard of a value.
+func (s *ShardedFilter) Filter(value []byte) *BloomFilter {
+	return s.filters[s.router.Shard(value)]
+}
+
+// Add adds a value to the filter of its shard.
+func (s *ShardedFilter) Add(value []byte) {
+	s.Filter(value).Add(value)
+}
+
+// TestAndAdd adds a value to the filter of its shard like TestAndAdd of
+// BloomFilter.
+func (s *ShardedFilter) TestAndAdd(value []byte) bool {
+	return s.Filter(value).TestAndAdd(value)
+}
+
+// TryAdd adds a value to the filter of its shard like TryAdd of BloomFilter.
+func (s *ShardedFilter) TryAdd(value []byte) error {
+	return s.Filter(value).TryAdd(value)
+}
+
+// Check returns true if the given value may be in the filter of its shard,
+// false if it is definitely not in it.
+func (s *ShardedFilter) Check(value []byte) bool {
+	return s.Filter(value).Check(value)
+}
+
+// Rejects returns true if the filter of its shard rejects the value due to
+// its length, like Rejects of BloomFilter.
+func (s *ShardedFilter) Rejects(value []byte) bool {
+	return s.Filter(value).rejects(value)
+}
+
+// TryCheck checks a value against the filter of its shard like TryCheck of
+// BloomFilter.
+func (s *ShardedFilter) TryCheck(value []byte) (bool, error) {
+	return s.Filter(value).TryCheck(value)
+}
+
+// WriteShards writes the shards to the paths given by the pattern (see
+// ShardPath).
+func (s *ShardedFilter) WriteShards(pattern string, gzip bool, opts ...WriteOption) error {
+	for i, filter := range s.filters {
+		path, err := ShardPath(pattern, i)
+		if err != nil {
+			return err
+		}
+		if err := WriteFilter(filter, path, gzip, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShardRouter(t *testing.T) {
+	router, err := NewShardRouter(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the routing must remain stable, as filters are sharded by it
+	for value, shard := range map[string]int{"foo": 0x8c736521 % 8, "bar": 0x76ff8caa % 8, "": 0} {
+		if router.Shard([]byte(value)) != shard {
+			t.Fatalf("unexpected shard %d for %q", router.Shard([]byte(value)), value)
+		}
+	}
+	counts := make([]int, router.Shards())
+	for i := 0; i < 8000; i++ {
+		counts[router.Shard([]byte(fmt.Sprintf("value-%d", i)))]++
+	}
+	for shard, count := range counts {
+		if count < 800 || count > 1200 {
+			t.Fatalf("uneven distribution: %d values in shard %d", count, shard)
+		}
+	}
+	if _, err := NewShardRouter(0); err == nil {
+		t.Fatal("zero shards should fail")
+	}
+}
+
+func TestShardPath(t *testing.T) {
+	if path, err := ShardPath("out-%d.bloom", 3); err != nil || path != "out-3.bloom" {
+		t.Fatalf("unexpected path %q (%v)", path, err)
+	}
+	for _, pattern := range []string{"out.bloom", "out-%d-%d.bloom", "out-%s.bloom"} {
+		if _, err := ShardPath(pattern, 0); err == nil {
+			t.Fatalf("pattern %q should be rejected", pattern)
+		}
+	}
+}
+
+func TestShardedFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sharded, err := NewShardedFilter(10000, 0.0001, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sharded.Filters()[0].MaxNumElements() != 1250 {
+		t.Fatalf("unexpected capacity of shards %d", sharded.Filters()[0].MaxNumElements())
+	}
+	values := make([][]byte, 5000)
+	for i := range values {
+		values[i] = []byte(fmt.Sprintf("value-%d", i))
+		sharded.Add(values[i])
+	}
+	pattern := filepath.Join(dir, "out-%d.bloom")
+	if err := sharded.WriteShards(pattern, true); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadShardedFilter(pattern, 8, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total uint64
+	for i, filter := range loaded.Filters() {
+		total += filter.N
+		for _, value := range values {
+			// each value is found in its own shard only (up to false positives,
+			// which are unlikely for this p)
+			if filter.Check(value) != (loaded.Router().Shard(value) == i) {
+				t.Fatalf("value %q found in wrong shard %d", value, i)
+			}
+		}
+	}
+	if total != uint64(len(values)) {
+		t.Fatalf("expected %d values across shards, found %d", len(values), total)
+	}
+	for _, value := range values {
+		if !loaded.Check(value) {
+			t.Fatalf("value %q lost", value)
+		}
+	}
+
+	// mismatching numbers of shards are detected
+	if _, err := LoadShardedFilter(pattern, 4, true); err == nil {
+		t.Fatal("loading fewer shards should fail")
+	}
+	if _, err := LoadShardedFilter(pattern, 9, true); err == nil {
+		t.Fatal("loading more shards should fail")
+	}
+}
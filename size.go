@@ -0,0 +1,69 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// intSize is the size of int (and of pointers) in bits, 32 or 64.
+const intSize = 32 << (^uint(0) >> 63)
+
+// maxAllocBits is the binary logarithm of the maximum size in bytes of a
+// single allocation the Go runtime supports: 2^32 on 32-bit platforms and
+// 2^48 on 64-bit platforms.
+const maxAllocBits = 32 + (intSize-32)/2
+
+// maxWords is the maximum number of 64-bit words in the bit array of a filter
+// on this platform. It is a variable so that tests can simulate the limits of
+// other platforms.
+var maxWords uint64 = ((uint64(1) << maxAllocBits) - 1) / 8
+
+// ErrFilterTooLarge is returned when the bit array of a filter cannot be
+// allocated on this platform.
+var ErrFilterTooLarge = errors.New("filter is too large for this platform")
+
+// numWords returns the number of 64-bit words needed to store m bits.
+func numWords(m uint64) uint64 {
+	if m%64 == 0 {
+		return m / 64
+	}
+	return m/64 + 1
+}
+
+// checkSize returns an error if a bit array with m bits cannot be allocated
+// on this platform.
+func checkSize(m uint64) error {
+	if m == 0 {
+		return errors.New("number of bits must be positive")
+	}
+	if words := numWords(m); words > maxWords {
+		return fmt.Errorf("%w: %d bits (%d words of 64 bits) requested, but at most %d words can be allocated on a %d-bit platform; "+
+			"use a larger false positive probability or a smaller capacity, or shard the values across several filters",
+			ErrFilterTooLarge, m, words, maxWords, intSize)
+	}
+	return nil
+}
+
+// optimalNumBits returns the number of bits of a filter for n elements with
+// false positive probability p, or an error if it cannot be allocated.
+func optimalNumBits(n uint64, p float64) (uint64, error) {
+	return bitsFor(math.Abs(math.Ceil(float64(n)*math.Log(p)/math.Pow(math.Log(2.0), 2.0))), n, p)
+}
+
+// bitsFor converts the number of bits of a filter for n elements with false
+// positive probability p to an integer, or returns an error if it cannot be
+// allocated.
+func bitsFor(m float64, n uint64, p float64) (uint64, error) {
+	// compare as floats, as the conversion of out-of-range values (including
+	// NaN) to uint64 is implementation-specific
+	if !(m < float64(maxWords)*64) {
+		return 0, fmt.Errorf("%w: %.4g bits requested for n = %d and p = %g, but at most %d words of 64 bits can be allocated on a %d-bit platform; "+
+			"use a larger false positive probability or a smaller capacity, or shard the values across several filters",
+			ErrFilterTooLarge, m, n, p, maxWords, intSize)
+	}
+	return uint64(m), checkSize(uint64(m))
+}
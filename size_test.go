@@ -0,0 +1,98 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// simulate32Bit limits the size of the bit array to what 32-bit platforms
+// can allocate.
+func simulate32Bit(t *testing.T) {
+	old := maxWords
+	maxWords = ((uint64(1) << 32) - 1) / 8
+	t.Cleanup(func() {
+		maxWords = old
+	})
+}
+
+func TestNewTooLarge(t *testing.T) {
+	simulate32Bit(t)
+	// requires about 3.6e10 bits, or 5.6e8 words
+	_, err := New(2e9, 0.0001)
+	if !errors.Is(err, ErrFilterTooLarge) {
+		t.Fatalf("expected ErrFilterTooLarge, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "shard") {
+		t.Fatalf("error message is not actionable: %s", err)
+	}
+	filter, err := New(2e8, 0.0001)
+	if err != nil {
+		t.Fatalf("filter below the limit should be allowed: %s", err)
+	}
+	if filter.M > maxWords {
+		t.Fatal("filter exceeds the limit")
+	}
+}
+
+func TestNewTooLargeForAnyPlatform(t *testing.T) {
+	_, err := New(1<<62, 1e-10)
+	if !errors.Is(err, ErrFilterTooLarge) {
+		t.Fatalf("expected ErrFilterTooLarge, got %v", err)
+	}
+}
+
+func TestInitializeTooLargePanics(t *testing.T) {
+	simulate32Bit(t)
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !errors.Is(err, ErrFilterTooLarge) {
+			t.Fatalf("expected panic with ErrFilterTooLarge, got %v", r)
+		}
+	}()
+	Initialize(2e9, 0.0001)
+}
+
+func TestNewInvalidParameters(t *testing.T) {
+	for _, c := range []struct {
+		n uint64
+		p float64
+	}{{0, 0.01}, {100, 0}, {100, 1}, {100, -0.5}, {100, 2}} {
+		if _, err := New(c.n, c.p); err == nil {
+			t.Errorf("New(%d, %g) should fail", c.n, c.p)
+		}
+	}
+	filter, err := New(10000, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reference := Initialize(10000, 0.001)
+	if !checkFilters(filter, &reference, t) {
+		t.Fatal("New and Initialize yield different filters")
+	}
+}
+
+func TestReadTooLarge(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	var buf bytes.Buffer
+	filter.Write(&buf)
+	serialized := buf.Bytes()
+
+	simulate32Bit(t)
+	// m is stored at offset 32
+	binary.LittleEndian.PutUint64(serialized[32:], 1<<40)
+	_, err := LoadFromBytes(serialized, false)
+	if !errors.Is(err, ErrFilterTooLarge) {
+		t.Fatalf("expected ErrFilterTooLarge, got %v", err)
+	}
+	binary.LittleEndian.PutUint64(serialized[32:], 0)
+	if _, err = LoadFromBytes(serialized, false); err == nil {
+		t.Fatal("filter without bits should be rejected")
+	}
+}
@@ -0,0 +1,328 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The names used by a SpoolMerger in its spool directory.
+const (
+	// SpoolDoneSuffix is appended to the name of a file in the spool
+	// directory to name its marker, an empty file whose presence tells that
+	// the file is complete.
+	SpoolDoneSuffix = ".done"
+	// SpoolArchiveDir is the subdirectory the merged files are moved to.
+	SpoolArchiveDir = "archive"
+	// SpoolFailedDir is the subdirectory the files that cannot be merged are
+	// moved to.
+	SpoolFailedDir = "failed"
+)
+
+// MetadataKeySpoolMerged is the metadata key of the output of a SpoolMerger
+// storing the comma-separated digests of the files merged by the scan that
+// wrote it, which are then moved to the archive.
+const MetadataKeySpoolMerged = "bloom.spool-merged"
+
+// SpoolOption configures a SpoolMerger.
+type SpoolOption func(*spoolOptions)
+
+type spoolOptions struct {
+	logger Logger
+	clock  clock
+}
+
+// WithSpoolLogger makes a SpoolMerger report each merged and rejected file
+// (info and warn) and failed scans (warn) to the logger.
+func WithSpoolLogger(logger Logger) SpoolOption {
+	return func(o *spoolOptions) {
+		if logger == nil {
+			logger = NopLogger{}
+		}
+		o.logger = logger
+	}
+}
+
+// withSpoolClock sets the clock of a SpoolMerger.
+func withSpoolClock(c clock) SpoolOption {
+	return func(o *spoolOptions) {
+		o.clock = c
+	}
+}
+
+// SpoolMerge is the outcome of a file found in the spool directory by Scan.
+type SpoolMerge struct {
+	// Path is the path of the file in the spool directory, and Digest the
+	// hex-encoded SHA-256 digest of its content.
+	Path   string
+	Digest string
+	// Err is the reason the file was moved to the failed directory instead
+	// of being merged, e.g. because of different dimensions, or nil.
+	Err error
+	// OutputDigest is the hex-encoded SHA-256 digest of the content of the
+	// output file the file was merged into.
+	OutputDigest string
+}
+
+// SpoolMerger merges the filters arriving in a spool directory, e.g.
+// uploaded by shards, into an output filter. Each Scan looks for files whose
+// names match a pattern (see filepath.Match) and merges those that are
+// complete: files with a marker (see SpoolDoneSuffix), and files whose size
+// did not change since the previous scan, so that partial uploads are not
+// merged. Files whose dimensions differ from those of the output, or that
+// cannot be loaded, are moved to the failed subdirectory. The others are
+// joined like by JoinSaturating, the output is rewritten atomically, and
+// then the files are moved to the archive subdirectory, with a numeric suffix
+// if it has a file of the same name. Hidden files, e.g. temporary files of
+// atomic writes, and the output itself are ignored.
+//
+// The output records the digests of the files merged into it (see
+// MetadataKeySpoolMerged), so that files left in the spool directory when
+// the merger was interrupted after writing the output are archived by the
+// next scan instead of being merged, and counted, a second time. A file
+// identical to one merged by the last write is therefore archived without
+// being merged again.
+//
+// A SpoolMerger is not safe for concurrent use, and only one should use a
+// spool directory.
+type SpoolMerger struct {
+	dir     string
+	pattern string
+	output  string
+	gzip    bool
+	o       spoolOptions
+
+	// filter is the content of the output, nil until the first file is
+	// merged if there was no output
+	filter *BloomFilter
+	// sizes are the sizes of the files seen by the previous scan
+	sizes map[string]int64
+}
+
+// NewSpoolMerger returns a SpoolMerger for the files matching the pattern in
+// the spool directory dir, which it merges into the filter at the path
+// output, loading the existing output, if any. If gzip is true, the files
+// are read and the output is written compressed.
+func NewSpoolMerger(dir, pattern, output string, gzip bool, opts ...SpoolOption) (*SpoolMerger, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	o := spoolOptions{
+		logger: NopLogger{},
+		clock:  systemClock{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	m := &SpoolMerger{dir: dir, pattern: pattern, output: output, gzip: gzip, o: o, sizes: make(map[string]int64)}
+	filter, err := LoadFilter(output, gzip)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	m.filter = filter
+	return m, nil
+}
+
+// Filter returns the merged filter as last written to the output, or nil if
+// nothing was merged yet and there was no output.
+func (m *SpoolMerger) Filter() *BloomFilter {
+	return m.filter
+}
+
+// Run scans the spool directory right away and then once per interval until
+// ctx is done. Failed scans are reported to the logger and retried with the
+// next one.
+func (m *SpoolMerger) Run(ctx context.Context, interval time.Duration) {
+	for {
+		if _, err := m.Scan(); err != nil {
+			m.o.logger.Warn("spool scan failed", LogKeyPath, m.dir, LogKeyError, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.o.clock.After(interval):
+		}
+	}
+}
+
+// Scan merges the complete files in the spool directory and returns their
+// outcomes, in the order of their names. If the output cannot be written, an
+// error is returned and the files are left in place to be merged by the next
+// scan.
+func (m *SpoolMerger) Scan() ([]SpoolMerge, error) {
+	infos, err := ioutil.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		names[info.Name()] = true
+	}
+	output, _ := filepath.Abs(m.output)
+	sizes := make(map[string]int64)
+	var ready []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, SpoolDoneSuffix) {
+			continue
+		}
+		if matched, _ := filepath.Match(m.pattern, name); !matched {
+			continue
+		}
+		path := filepath.Join(m.dir, name)
+		if abs, _ := filepath.Abs(path); abs == output {
+			continue
+		}
+		if size, seen := m.sizes[name]; names[name+SpoolDoneSuffix] || (seen && size == info.Size()) {
+			ready = append(ready, name)
+		}
+		sizes[name] = info.Size()
+	}
+	m.sizes = sizes
+	sort.Strings(ready)
+
+	// files merged by the last write, which were not archived yet
+	written := make(map[string]bool)
+	if m.filter != nil {
+		if v, ok := m.filter.Metadata(MetadataKeySpoolMerged); ok && v != "" {
+			for _, digest := range strings.Split(v, ",") {
+				written[digest] = true
+			}
+		}
+	}
+
+	var merged *BloomFilter
+	var results []SpoolMerge
+	var accepted, archived []int
+	var digests []string
+	for _, name := range ready {
+		path := filepath.Join(m.dir, name)
+		result := SpoolMerge{Path: path}
+		filter, digest, err := m.load(path)
+		result.Digest = digest
+		if err == nil && written[digest] {
+			archived = append(archived, len(results))
+			results = append(results, result)
+			continue
+		}
+		if err == nil {
+			switch {
+			case merged != nil:
+				err = merged.JoinSaturating(filter)
+			case m.filter != nil:
+				merged = m.filter.clone()
+				err = merged.JoinSaturating(filter)
+			default:
+				merged = filter
+			}
+		}
+		if err != nil {
+			result.Err = err
+			m.o.logger.Warn("rejected spool file", LogKeyPath, path, LogKeyDigest, digest, LogKeyError, err)
+			if err := m.move(name, SpoolFailedDir); err != nil {
+				return results, err
+			}
+		} else {
+			accepted = append(accepted, len(results))
+			digests = append(digests, digest)
+		}
+		results = append(results, result)
+	}
+	if len(accepted) == 0 && len(archived) == 0 {
+		return results, nil
+	}
+
+	if len(accepted) > 0 {
+		merged.SetMetadata(MetadataKeySpoolMerged, strings.Join(digests, ","))
+		if err := writeFilterAtomically(merged, m.output, m.gzip); err != nil {
+			return results, err
+		}
+		m.filter = merged
+	}
+	outputDigest, _, err := FileDigest(m.output)
+	if err != nil {
+		return results, err
+	}
+	for _, i := range archived {
+		results[i].OutputDigest = outputDigest
+		m.o.logger.Info("archived spool file merged before", LogKeyPath, results[i].Path, LogKeyDigest, results[i].Digest)
+		if err := m.move(filepath.Base(results[i].Path), SpoolArchiveDir); err != nil {
+			return results, err
+		}
+	}
+	for _, i := range accepted {
+		results[i].OutputDigest = outputDigest
+		m.o.logger.Info("merged spool file", LogKeyPath, results[i].Path, LogKeyDigest, results[i].Digest)
+		if err := m.move(filepath.Base(results[i].Path), SpoolArchiveDir); err != nil {
+			return results, err
+		}
+	}
+	if len(accepted) > 0 {
+		m.o.logger.Info("wrote merged filter", LogKeyPath, m.output, LogKeyDigest, outputDigest, LogKeyCount, len(accepted))
+	}
+	return results, nil
+}
+
+// load loads the filter at path and returns it with the digest of the file.
+func (m *SpoolMerger) load(path string) (*BloomFilter, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	filter, err := LoadFromReader(bytes.NewReader(data), m.gzip)
+	return filter, digest, err
+}
+
+// move moves the file with the given name and its marker, if any, from the
+// spool directory to the given subdirectory, appending a numeric suffix to
+// the name if the subdirectory has a file or marker of that name.
+func (m *SpoolMerger) move(name, subdir string) error {
+	dir := filepath.Join(m.dir, subdir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	target := name
+	for i := 1; ; i++ {
+		taken, err := exists(filepath.Join(dir, target))
+		if err == nil && !taken {
+			taken, err = exists(filepath.Join(dir, target+SpoolDoneSuffix))
+		}
+		if err != nil {
+			return err
+		}
+		if !taken {
+			break
+		}
+		target = fmt.Sprintf("%s.%d", name, i)
+	}
+	if err := os.Rename(filepath.Join(m.dir, name), filepath.Join(dir, target)); err != nil {
+		return err
+	}
+	marker := name + SpoolDoneSuffix
+	if err := os.Rename(filepath.Join(m.dir, marker), filepath.Join(dir, target+SpoolDoneSuffix)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// exists returns true if there is a file at path.
+func exists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,308 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// spoolFilter returns the binary representation of a filter with the given
+// values.
+func spoolFilter(t *testing.T, n uint64, values ...string) []byte {
+	filter := mustNew(n, 0.01)
+	for _, value := range values {
+		filter.Add([]byte(value))
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeSpoolFile(t *testing.T, dir, name string, data []byte) {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// scanSpool scans the spool directory and returns the base names of the
+// merged and the rejected files.
+func scanSpool(t *testing.T, m *SpoolMerger) (merged []string, rejected []string) {
+	results, err := m.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			rejected = append(rejected, filepath.Base(result.Path))
+		} else {
+			merged = append(merged, filepath.Base(result.Path))
+		}
+	}
+	return merged, rejected
+}
+
+func TestSpoolMerger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "merged.bloom")
+	m, err := NewSpoolMerger(dir, "*.bloom", output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a file with a marker is merged right away, others once their size is
+	// unchanged in the next scan
+	a := spoolFilter(t, 1000, "a")
+	b := spoolFilter(t, 1000, "b")
+	writeSpoolFile(t, dir, "a.bloom", a)
+	writeSpoolFile(t, dir, "b.bloom", b[:len(b)/2])
+	writeSpoolFile(t, dir, "c.bloom", spoolFilter(t, 1000, "c"))
+	writeSpoolFile(t, dir, "c.bloom"+SpoolDoneSuffix, nil)
+	writeSpoolFile(t, dir, "ignored.txt", nil)
+	results, err := m.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || filepath.Base(results[0].Path) != "c.bloom" || results[0].Err != nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if digest, _, _ := FileDigest(output); results[0].OutputDigest != digest {
+		t.Fatalf("unexpected output digest %s", results[0].OutputDigest)
+	}
+	sum := sha256.Sum256(spoolFilter(t, 1000, "c"))
+	if results[0].Digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected digest %s", results[0].Digest)
+	}
+
+	// the partial upload grows, and a file with other dimensions arrives
+	writeSpoolFile(t, dir, "b.bloom", b)
+	writeSpoolFile(t, dir, "d.bloom", spoolFilter(t, 2000, "d"))
+	writeSpoolFile(t, dir, "d.bloom"+SpoolDoneSuffix, nil)
+	merged, rejected := scanSpool(t, m)
+	if len(merged) != 1 || merged[0] != "a.bloom" || len(rejected) != 1 || rejected[0] != "d.bloom" {
+		t.Fatalf("unexpected merged %v and rejected %v files", merged, rejected)
+	}
+	if merged, rejected = scanSpool(t, m); len(merged) != 1 || merged[0] != "b.bloom" || len(rejected) != 0 {
+		t.Fatalf("unexpected merged %v and rejected %v files", merged, rejected)
+	}
+	if merged, rejected = scanSpool(t, m); len(merged) != 0 || len(rejected) != 0 {
+		t.Fatalf("unexpected merged %v and rejected %v files", merged, rejected)
+	}
+
+	filter, err := LoadFilter(output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"a", "b", "c"} {
+		if !filter.Check([]byte(value)) {
+			t.Errorf("%s is not in the merged filter", value)
+		}
+	}
+	if filter.Check([]byte("d")) || filter.N != 3 {
+		t.Fatalf("unexpected merged filter with %d elements", filter.N)
+	}
+	for _, path := range []string{
+		filepath.Join(SpoolArchiveDir, "a.bloom"),
+		filepath.Join(SpoolArchiveDir, "b.bloom"),
+		filepath.Join(SpoolArchiveDir, "c.bloom"),
+		filepath.Join(SpoolArchiveDir, "c.bloom"+SpoolDoneSuffix),
+		filepath.Join(SpoolFailedDir, "d.bloom"),
+		filepath.Join(SpoolFailedDir, "d.bloom"+SpoolDoneSuffix),
+		"ignored.txt",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s: %s", path, err)
+		}
+	}
+
+	// a new merger continues with the existing output
+	m, err = NewSpoolMerger(dir, "*.bloom", output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSpoolFile(t, dir, "e.bloom", spoolFilter(t, 1000, "e"))
+	writeSpoolFile(t, dir, "e.bloom"+SpoolDoneSuffix, nil)
+	if merged, _ := scanSpool(t, m); len(merged) != 1 || !m.Filter().Check([]byte("a")) || !m.Filter().Check([]byte("e")) {
+		t.Fatalf("unexpected merged files %v", merged)
+	}
+
+	if _, err := NewSpoolMerger(dir, "[", output, false); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
+
+func TestSpoolMergerRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "merged.bloom")
+	clock := newManualClock()
+	m, err := NewSpoolMerger(dir, "*.bloom", output, true, withSpoolClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx, time.Minute)
+		close(done)
+	}()
+
+	// the first scan finds nothing, the second sees the file for the first
+	// time, and the third merges it
+	<-clock.started
+	filter := mustNew(1000, 0.01)
+	filter.Add([]byte("foo"))
+	if err := WriteFilter(filter, filepath.Join(dir, "a.bloom"), true); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := os.Stat(output); !os.IsNotExist(err) {
+			t.Fatalf("scan %d: the output should not exist yet", i+1)
+		}
+		clock.advance(time.Minute)
+		<-clock.started
+	}
+	merged, err := LoadFilter(output, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Check([]byte("foo")) {
+		t.Fatal("the file was not merged")
+	}
+	cancel()
+	<-done
+}
+
+func TestSpoolMergerInterrupted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "merged.bloom")
+	m, err := NewSpoolMerger(dir, "*.bloom", output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := spoolFilter(t, 1000, "a")
+	writeSpoolFile(t, dir, "a.bloom", a)
+	writeSpoolFile(t, dir, "a.bloom"+SpoolDoneSuffix, nil)
+	if merged, _ := scanSpool(t, m); len(merged) != 1 {
+		t.Fatalf("unexpected merged files %v", merged)
+	}
+
+	// the merger was interrupted after writing the output, before archiving
+	// the file, which the next merger archives without merging it again
+	writeSpoolFile(t, dir, "a.bloom", a)
+	writeSpoolFile(t, dir, "a.bloom"+SpoolDoneSuffix, nil)
+	m, err = NewSpoolMerger(dir, "*.bloom", output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := m.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err != nil || m.Filter().NumElements() != 1 {
+		t.Fatalf("unexpected results %+v with %d elements", results, m.Filter().NumElements())
+	}
+	if filter, err := LoadFilter(output, false); err != nil || filter.NumElements() != 1 {
+		t.Fatalf("unexpected output (%v)", err)
+	}
+
+	// a new file of an archived name is merged and archived under another
+	// name, keeping the archived one
+	writeSpoolFile(t, dir, "a.bloom", spoolFilter(t, 1000, "b"))
+	writeSpoolFile(t, dir, "a.bloom"+SpoolDoneSuffix, nil)
+	if merged, _ := scanSpool(t, m); len(merged) != 1 || m.Filter().NumElements() != 2 {
+		t.Fatalf("unexpected merged files %v", merged)
+	}
+	for _, name := range []string{"a.bloom", "a.bloom.1", "a.bloom.2", "a.bloom.2" + SpoolDoneSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, SpoolArchiveDir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(dir, SpoolArchiveDir, "a.bloom")); err != nil || !bytes.Equal(data, a) {
+		t.Fatalf("the archived file was replaced (%v)", err)
+	}
+}
+
+func TestSpoolMergerWriteFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	output := filepath.Join(dir, "merged.bloom")
+	m, err := NewSpoolMerger(dir, "*.bloom", output, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSpoolFile(t, dir, "a.bloom", spoolFilter(t, 1000, "a"))
+	writeSpoolFile(t, dir, "a.bloom"+SpoolDoneSuffix, nil)
+	if merged, _ := scanSpool(t, m); len(merged) != 1 {
+		t.Fatalf("unexpected merged files %v", merged)
+	}
+	before, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the disk fills up while the output is written
+	defer func(w func(*os.File) io.Writer) { tempFileWriter = w }(tempFileWriter)
+	tempFileWriter = func(f *os.File) io.Writer {
+		return &failingWriter{size: 10}
+	}
+	writeSpoolFile(t, dir, "b.bloom", spoolFilter(t, 1000, "b"))
+	writeSpoolFile(t, dir, "b.bloom"+SpoolDoneSuffix, nil)
+	if _, err := m.Scan(); err != errDiskFull {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if after, err := ioutil.ReadFile(output); err != nil || !bytes.Equal(after, before) {
+		t.Fatalf("the output was replaced (%v)", err)
+	}
+	for _, name := range []string{"b.bloom", "b.bloom" + SpoolDoneSuffix} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, SpoolArchiveDir, "b.bloom")); !os.IsNotExist(err) {
+		t.Errorf("the input was archived (%v)", err)
+	}
+	if infos, err := ioutil.ReadDir(dir); err != nil {
+		t.Fatal(err)
+	} else {
+		for _, info := range infos {
+			if strings.HasPrefix(info.Name(), ".") {
+				t.Errorf("temporary file %s left behind", info.Name())
+			}
+		}
+	}
+
+	// the input is merged once the disk has space again
+	tempFileWriter = func(f *os.File) io.Writer {
+		return f
+	}
+	if merged, _ := scanSpool(t, m); len(merged) != 1 || m.Filter().NumElements() != 2 {
+		t.Fatalf("unexpected merged files %v", merged)
+	}
+}
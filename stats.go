@@ -0,0 +1,53 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+// FilterStats summarizes the dimensions and state of a Bloom filter.
+type FilterStats struct {
+	// Capacity is the desired maximum number of elements.
+	Capacity uint64
+	// Elements is the number of elements in the filter.
+	Elements uint64
+	// ElementsEstimated is true if Elements is an estimate.
+	ElementsEstimated bool
+	// FalsePositiveProb is the desired false positive probability.
+	FalsePositiveProb float64
+	// Bits is the number of bits in the filter.
+	Bits uint64
+	// HashFuncs is the number of hash functions.
+	HashFuncs uint64
+	// RemainingCapacity is the number of distinct elements that can be added
+	// before the estimated FP probability exceeds FalsePositiveProb, see
+	// RemainingCapacityAt.
+	RemainingCapacity uint64
+	// DataSize is the size in bytes of the attached data.
+	DataSize uint64
+	// Producer is the producer that wrote the filter, see Producer.
+	Producer string
+	// JoinedProducers are the producers of the filters joined into the
+	// filter, see JoinedProducers.
+	JoinedProducers []string
+	// Warnings describe unexpected dimensions of the filter, see Warnings.
+	Warnings []string
+}
+
+// Stats returns a summary of the Bloom filter.
+func (s *BloomFilter) Stats() FilterStats {
+	// the remaining capacity of a filter without a valid p, e.g. a zero
+	// BloomFilter, is 0
+	remaining, _ := s.RemainingCapacityAt(s.p)
+	return FilterStats{
+		Capacity:          s.n,
+		Elements:          s.NumElements(),
+		ElementsEstimated: s.CountIsEstimate(),
+		FalsePositiveProb: s.p,
+		Bits:              s.m,
+		HashFuncs:         s.k,
+		RemainingCapacity: remaining,
+		DataSize:          s.DataSize(),
+		Producer:          s.Producer(),
+		JoinedProducers:   s.JoinedProducers(),
+		Warnings:          s.Warnings(),
+	}
+}
@@ -0,0 +1,158 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"sync"
+	"time"
+)
+
+// TimedStats is a snapshot of the Stats of a filter recorded by a
+// StatsHistory.
+type TimedStats struct {
+	FilterStats
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// SetBits is the number of set bits.
+	SetBits uint64
+	// EstimatedFalsePositiveProb is the FP probability estimated from the
+	// number of set bits, see EstimatedFalsePositiveProb.
+	EstimatedFalsePositiveProb float64
+	// Adds is the number of elements added since the previous snapshot, or 0
+	// for the first one or if the count decreased, e.g. by a Reset.
+	Adds uint64
+	// Changes is the number of changes since the previous snapshot, see
+	// Changes.
+	Changes uint64
+}
+
+// StatsHistoryOption configures a StatsHistory.
+type StatsHistoryOption func(*statsHistoryOptions)
+
+type statsHistoryOptions struct {
+	clock clock
+}
+
+// withStatsHistoryClock sets the clock of a StatsHistory.
+func withStatsHistoryClock(c clock) StatsHistoryOption {
+	return func(o *statsHistoryOptions) {
+		o.clock = c
+	}
+}
+
+// StatsHistory keeps the most recent snapshots of the Stats of a filter,
+// taken on its own goroutine once per interval, in memory, e.g. to inspect
+// a gradual saturation of a long-running filter without relying on external
+// monitoring. Checks are not counted, as Check does not modify the filter.
+//
+// All goroutines modifying the filter must hold the lock given to
+// EnableStatsHistory meanwhile. The StatsHistory holds it while it takes a
+// snapshot, which counts the set bits of the filter.
+type StatsHistory struct {
+	filter   *BloomFilter
+	lock     sync.Locker
+	interval time.Duration
+	o        statsHistoryOptions
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// state of the goroutine: whether a snapshot was taken, and the number of
+	// elements and changes at the last one
+	recorded     bool
+	lastElements uint64
+	lastChanges  uint64
+
+	mu        sync.Mutex
+	snapshots []TimedStats
+	next      int
+	full      bool
+}
+
+// EnableStatsHistory starts a StatsHistory taking a snapshot of the filter
+// right away and then once per interval, keeping the latest capacity
+// snapshots. It must be stopped with Close.
+func EnableStatsHistory(filter *BloomFilter, lock sync.Locker, interval time.Duration, capacity int, opts ...StatsHistoryOption) *StatsHistory {
+	o := statsHistoryOptions{clock: systemClock{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	h := &StatsHistory{
+		filter:    filter,
+		lock:      lock,
+		interval:  interval,
+		o:         o,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		snapshots: make([]TimedStats, capacity),
+	}
+	h.record()
+	go h.run()
+	return h
+}
+
+func (h *StatsHistory) run() {
+	defer close(h.done)
+	timer := h.o.clock.After(h.interval)
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-timer:
+			h.record()
+			timer = h.o.clock.After(h.interval)
+		}
+	}
+}
+
+// record takes a snapshot, replacing the oldest one if the ring is full.
+func (h *StatsHistory) record() {
+	h.lock.Lock()
+	snapshot := TimedStats{
+		FilterStats:                h.filter.Stats(),
+		Time:                       h.o.clock.Now(),
+		SetBits:                    h.filter.NumSetBits(),
+		EstimatedFalsePositiveProb: h.filter.EstimatedFalsePositiveProb(),
+	}
+	changes := h.filter.Changes()
+	h.lock.Unlock()
+
+	if h.recorded {
+		if snapshot.Elements > h.lastElements {
+			snapshot.Adds = snapshot.Elements - h.lastElements
+		}
+		snapshot.Changes = changes - h.lastChanges
+	}
+	h.recorded, h.lastElements, h.lastChanges = true, snapshot.Elements, changes
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.snapshots[h.next] = snapshot
+	h.next = (h.next + 1) % len(h.snapshots)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Snapshots returns the snapshots kept, oldest first.
+func (h *StatsHistory) Snapshots() []TimedStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]TimedStats(nil), h.snapshots[:h.next]...)
+	}
+	return append(append([]TimedStats(nil), h.snapshots[h.next:]...), h.snapshots[:h.next]...)
+}
+
+// Close stops taking snapshots. The snapshots taken remain available.
+func (h *StatsHistory) Close() {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+	})
+	<-h.done
+}
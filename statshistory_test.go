@@ -0,0 +1,51 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	var lock sync.Mutex
+	clock := newManualClock()
+	history := EnableStatsHistory(filter, &lock, time.Minute, 3, withStatsHistoryClock(clock))
+	defer history.Close()
+	<-clock.started
+
+	for i := 0; i < 4; i++ {
+		lock.Lock()
+		for j := 0; j < 10*(i+1); j++ {
+			filter.Add([]byte(fmt.Sprintf("value-%d-%d", i, j)))
+		}
+		lock.Unlock()
+		clock.advance(time.Minute)
+		<-clock.started
+	}
+
+	// the first two snapshots were replaced
+	snapshots := history.Snapshots()
+	if len(snapshots) != 3 {
+		t.Fatalf("expected 3 snapshots, got %d", len(snapshots))
+	}
+	for i, snapshot := range snapshots {
+		adds := uint64(10 * (i + 2))
+		if snapshot.Adds != adds || snapshot.Changes != adds {
+			t.Errorf("snapshot %d: expected %d adds, got %d (%d changes)", i, adds, snapshot.Adds, snapshot.Changes)
+		}
+		if i > 0 && snapshot.Time.Sub(snapshots[i-1].Time) != time.Minute {
+			t.Errorf("snapshot %d: unexpected time %v", i, snapshot.Time)
+		}
+		if i > 0 && (snapshot.SetBits <= snapshots[i-1].SetBits || snapshot.EstimatedFalsePositiveProb <= snapshots[i-1].EstimatedFalsePositiveProb) {
+			t.Errorf("snapshot %d: expected more set bits than before", i)
+		}
+	}
+	if last := snapshots[2]; last.Elements != 100 || last.SetBits != filter.NumSetBits() {
+		t.Fatalf("unexpected last snapshot %+v", last)
+	}
+}
@@ -0,0 +1,164 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/DCSO/bloom/internal/bitset"
+)
+
+// CacheLineSize is the size in bytes of the cache lines to which a
+// StripedWriter aligns with WithStripePadding.
+const CacheLineSize = 64
+
+// mutexSize is the size in bytes of a sync.Mutex.
+const mutexSize = 8
+
+// StripedOption configures a StripedWriter.
+type StripedOption func(*stripedOptions)
+
+type stripedOptions struct {
+	padded bool
+	atomic bool
+}
+
+// WithStripePadding aligns the word ranges of the stripes to cache lines, so
+// that no cache line of the bit array is written under two locks, and gives
+// each lock a cache line of its own, so that goroutines taking different locks
+// do not contend for the same cache line (false sharing). The bit arrays of
+// large filters start at a cache line, as they are allocated page-aligned.
+func WithStripePadding() StripedOption {
+	return func(o *stripedOptions) {
+		o.padded = true
+	}
+}
+
+// WithAtomicStripes makes a StripedWriter set each bit with an atomic
+// compare-and-swap of its word instead of taking the lock of the stripe,
+// which avoids blocking but retries under contention for the same word.
+func WithAtomicStripes() StripedOption {
+	return func(o *stripedOptions) {
+		o.atomic = true
+	}
+}
+
+// StripedWriter adds values to a filter from many goroutines at once. The
+// bit array is divided into stripes of adjacent words, each guarded by its
+// own lock, and each bit of a value is set under the lock of the stripe
+// holding it, so that concurrent adds only contend if they set bits in the
+// same stripe. The filter ends up with the same bits set as if the values
+// were added sequentially with Add.
+//
+// The values added are counted separately and added to the element count of
+// the filter by Flush. As with Add, a value is counted if it sets a bit that
+// was not set before. A value added by several goroutines at the same time may
+// be counted more than once, if each of them sets some of its bits, so the
+// count may exceed the one of sequential adds by the number of such
+// concurrent duplicates. Before Flush, the filter must not be used other than
+// through the writer; in particular, Check must not be called concurrently.
+type StripedWriter struct {
+	// accessed atomically, first word for 64-bit alignment
+	added uint64
+
+	filter      *BloomFilter
+	atomic      bool
+	stripeWords uint64
+	// locks holds the lock of stripe i at index i*stride, with padding
+	// between them if stride > 1
+	locks  []sync.Mutex
+	stride uint64
+
+	// countMu guards the exact counter of the filter, if any
+	countMu      sync.Mutex
+	fingerprints sync.Pool
+}
+
+// NewStripedWriter returns a StripedWriter adding values to the filter with
+// the given number of stripes, which is reduced if the filter has fewer words
+// (or cache lines, with WithStripePadding).
+func NewStripedWriter(f *BloomFilter, stripes int, opts ...StripedOption) *StripedWriter {
+	var o stripedOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if stripes < 1 {
+		stripes = 1
+	}
+	w := &StripedWriter{filter: f, atomic: o.atomic, stride: 1}
+	w.fingerprints.New = func() interface{} {
+		fingerprint := make([]uint64, f.k)
+		return &fingerprint
+	}
+	w.stripeWords = (f.M + uint64(stripes) - 1) / uint64(stripes)
+	if o.padded {
+		const lineWords = CacheLineSize / FormatWordSize
+		w.stripeWords = (w.stripeWords + lineWords - 1) / lineWords * lineWords
+		w.stride = CacheLineSize / mutexSize
+	}
+	if w.stripeWords == 0 {
+		w.stripeWords = 1
+	}
+	if !o.atomic {
+		n := (f.M + w.stripeWords - 1) / w.stripeWords
+		w.locks = make([]sync.Mutex, n*w.stride)
+	}
+	return w
+}
+
+// Stripes returns the number of stripes.
+func (w *StripedWriter) Stripes() int {
+	return int((w.filter.M + w.stripeWords - 1) / w.stripeWords)
+}
+
+// Add adds a value to the filter like Add of the filter. It may be called
+// from multiple goroutines at once.
+func (w *StripedWriter) Add(value []byte) {
+	f := w.filter
+	if f.rejects(value) {
+		return
+	}
+	if f.exactCounter != nil {
+		w.countMu.Lock()
+		f.exactCounter.add(f.truncate(value))
+		w.countMu.Unlock()
+	}
+	fingerprint := w.fingerprints.Get().(*[]uint64)
+	f.Fingerprint(value, *fingerprint)
+	newBits := false
+	for _, bit := range *fingerprint {
+		if w.set(bit) {
+			newBits = true
+		}
+	}
+	w.fingerprints.Put(fingerprint)
+	if newBits {
+		atomic.AddUint64(&w.added, 1)
+	}
+}
+
+// set sets a bit and returns whether it was not set before.
+func (w *StripedWriter) set(bit uint64) bool {
+	if w.atomic {
+		return bitset.SetAtomic(w.filter.v, bit)
+	}
+	word, _ := bitset.Index(bit)
+	lock := &w.locks[word/w.stripeWords*w.stride]
+	lock.Lock()
+	set := bitset.Set(w.filter.v, bit)
+	lock.Unlock()
+	return set
+}
+
+// Flush adds the number of values counted since the last Flush to the
+// element count of the filter. It must not be called concurrently with Add.
+func (w *StripedWriter) Flush() {
+	added := atomic.SwapUint64(&w.added, 0)
+	if added > 0 {
+		w.filter.invalidate()
+		w.filter.dropExactCount()
+		w.filter.SetNumElements(w.filter.NumElements() + added)
+	}
+}
@@ -0,0 +1,106 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStripedWriter(t *testing.T) {
+	const n = 20000
+	sequential := mustNew(n, 0.001)
+	for i := 0; i < n; i++ {
+		sequential.Add([]byte(fmt.Sprintf("value-%d", i)))
+	}
+
+	for _, test := range []struct {
+		name    string
+		stripes int
+		opts    []StripedOption
+	}{
+		{"single", 1, nil},
+		{"striped", 64, nil},
+		{"padded", 64, []StripedOption{WithStripePadding()}},
+		{"atomic", 64, []StripedOption{WithAtomicStripes()}},
+		{"excess", 1 << 20, nil},
+	} {
+		filter := mustNew(n, 0.001)
+		w := NewStripedWriter(filter, test.stripes, test.opts...)
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < n; i += 8 {
+					w.Add([]byte(fmt.Sprintf("value-%d", i)))
+				}
+			}(g)
+		}
+		wg.Wait()
+		if filter.NumElements() != 0 {
+			t.Fatalf("%s: count updated before Flush", test.name)
+		}
+		w.Flush()
+		if !reflect.DeepEqual(filter.v, sequential.v) {
+			t.Fatalf("%s: bits differ from sequential adds", test.name)
+		}
+		// the values are distinct, so the counts only differ by values
+		// whose bits were all set by others, which depends on the order
+		if diff := int64(filter.NumElements()) - int64(sequential.NumElements()); diff < -10 || diff > 10 {
+			t.Fatalf("%s: count %d too far from %d", test.name, filter.NumElements(), sequential.NumElements())
+		}
+	}
+}
+
+func TestStripedWriterStripes(t *testing.T) {
+	filter := mustNew(100000, 0.01)
+	if stripes := NewStripedWriter(filter, 16).Stripes(); stripes != 16 {
+		t.Fatalf("unexpected number of stripes %d", stripes)
+	}
+	// each padded stripe covers whole cache lines
+	w := NewStripedWriter(filter, 1000, WithStripePadding())
+	if w.stripeWords%(CacheLineSize/FormatWordSize) != 0 || w.Stripes() > 1000 {
+		t.Fatalf("unexpected stripes of %d words", w.stripeWords)
+	}
+	if len(w.locks) != w.Stripes()*CacheLineSize/mutexSize {
+		t.Fatalf("unexpected number of padded locks %d", len(w.locks))
+	}
+	if stripes := NewStripedWriter(filter, 1<<20).Stripes(); stripes != int(filter.M) {
+		t.Fatalf("expected a stripe per word, got %d", stripes)
+	}
+}
+
+func TestStripedWriterExactCount(t *testing.T) {
+	filter, err := New(1000, 0.001, WithExactCounting(DefaultExactCountingMemory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewStripedWriter(filter, 4)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				w.Add([]byte(fmt.Sprintf("value-%d", i)))
+			}
+		}()
+	}
+	wg.Wait()
+	w.Flush()
+	count, err := filter.FinishExactCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Distinct != 100 || count.Duplicates != 300 {
+		t.Fatalf("unexpected exact count %+v", count)
+	}
+	// concurrent duplicates may be counted more than once
+	if filter.NumElements() < 100 || filter.NumElements() > 400 {
+		t.Fatalf("unexpected count %d", filter.NumElements())
+	}
+}
@@ -0,0 +1,261 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MetadataKeyTagPrefix prefixes the metadata keys storing the serialized tag
+// filters of a TaggedFilter with its main filter, followed by the tag.
+const MetadataKeyTagPrefix = "bloom.tag."
+
+// DefaultTagRatio is the capacity of a tag filter relative to that of the main
+// filter, used when a value is added with a tag that has no filter yet (at
+// most the capacity that can still be stored in the metadata).
+const DefaultTagRatio = 0.1
+
+// TaggedFilter records which sources (tags), e.g. feeds, contributed the
+// values of a Bloom filter. Each value is added to the main filter and to a
+// smaller filter of its tag, sized by the number of values expected from that
+// source, which takes much less memory than a full-size filter per source
+// when most values come from a few of them.
+//
+// Whether a value matches is decided by the main filter alone, with its
+// false positive probability. The tags of a matching value are attributed by
+// the tag filters, each of which can only err in one direction: a tag a value
+// was added with is always reported, but a tag it was not added with is
+// reported with about the false positive probability of that tag filter at its
+// current fill. With many tags or overfull tag filters, a value is therefore
+// likely to be attributed to some source that never supplied it. A false
+// positive of the main filter is reported with the tags whose filters happen
+// to match, usually none.
+type TaggedFilter struct {
+	main *BloomFilter
+	tags map[string]*BloomFilter
+}
+
+// NewTaggedFilter returns a TaggedFilter for the main filter, without tags.
+func NewTaggedFilter(main *BloomFilter) *TaggedFilter {
+	return &TaggedFilter{main: main, tags: make(map[string]*BloomFilter)}
+}
+
+// TaggedOf returns a TaggedFilter for a filter loaded from the output of
+// TaggedFilter.Write, taking the tag filters from its metadata.
+func TaggedOf(main *BloomFilter) (*TaggedFilter, error) {
+	t := NewTaggedFilter(main)
+	for _, key := range main.MetadataKeys() {
+		if !strings.HasPrefix(key, MetadataKeyTagPrefix) {
+			continue
+		}
+		v, _ := main.Metadata(key)
+		tag := strings.TrimPrefix(key, MetadataKeyTagPrefix)
+		filter, err := LoadFromBytes([]byte(v), false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter of tag %q: %w", tag, err)
+		}
+		t.tags[tag] = filter
+	}
+	for tag := range t.tags {
+		main.DeleteMetadata(MetadataKeyTagPrefix + tag)
+	}
+	return t, nil
+}
+
+// HasTags returns true if the filter was written with tags by
+// TaggedFilter.Write.
+func HasTags(filter *BloomFilter) bool {
+	for _, key := range filter.MetadataKeys() {
+		if strings.HasPrefix(key, MetadataKeyTagPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadTaggedFilter loads a TaggedFilter from a file, see TaggedOf.
+func LoadTaggedFilter(path string, gzip bool, opts ...LoadOption) (*TaggedFilter, error) {
+	main, err := LoadFilter(path, gzip, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return TaggedOf(main)
+}
+
+// WriteTaggedFilter writes a TaggedFilter to a file like WriteFilter.
+func WriteTaggedFilter(filter *TaggedFilter, path string, gzip bool, opts ...WriteOption) error {
+	tagOpts, err := filter.encodeTags()
+	if err != nil {
+		return err
+	}
+	return WriteFilter(filter.main, path, gzip, append(opts, tagOpts...)...)
+}
+
+// Write writes the main filter like Write of BloomFilter, with each tag
+// filter stored in its metadata under MetadataKeyTagPrefix followed by the
+// tag (limited to the maximum size of the metadata section in total).
+// Loading the output with LoadFilter yields the main filter only.
+func (t *TaggedFilter) Write(w io.Writer, opts ...WriteOption) error {
+	tagOpts, err := t.encodeTags()
+	if err != nil {
+		return err
+	}
+	return t.main.Write(w, append(opts, tagOpts...)...)
+}
+
+func (t *TaggedFilter) encodeTags() ([]WriteOption, error) {
+	var opts []WriteOption
+	size := 0
+	for _, tag := range t.Tags() {
+		var buf bytes.Buffer
+		if err := t.tags[tag].Write(&buf); err != nil {
+			return nil, err
+		}
+		size += buf.Len()
+		if size > maxMetadataSize {
+			return nil, fmt.Errorf("%w: tag filters are too large to be stored (%d bytes and more)", ErrMetadataTooLarge, size)
+		}
+		opts = append(opts, withMetadata(MetadataKeyTagPrefix+tag, buf.String()))
+	}
+	return opts, nil
+}
+
+// Main returns the main filter.
+func (t *TaggedFilter) Main() *BloomFilter {
+	return t.main
+}
+
+// Tags returns the tags with a filter, in sorted order.
+func (t *TaggedFilter) Tags() []string {
+	tags := make([]string, 0, len(t.tags))
+	for tag := range t.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Tag returns the filter of a tag, or nil if it has none.
+func (t *TaggedFilter) Tag(tag string) *BloomFilter {
+	return t.tags[tag]
+}
+
+// tagsSize returns the size in bytes the filters of the tags other than the
+// given one take in the metadata.
+func (t *TaggedFilter) tagsSize(except string) int64 {
+	var size int64
+	for tag, filter := range t.tags {
+		if tag != except {
+			size += FormatHeaderSize + int64(filter.M)*FormatWordSize
+		}
+	}
+	return size
+}
+
+// AddTag creates an empty filter for a tag with the given capacity (n) and FP
+// probability (p), replacing any filter the tag had. The tag filter limits
+// the length of values like the main filter. As the tag filters are stored in
+// the metadata of the main filter, an error wrapping ErrMetadataTooLarge is
+// returned if it would not fit besides those of the other tags.
+func (t *TaggedFilter) AddTag(tag string, n uint64, p float64) (*BloomFilter, error) {
+	if tag == "" {
+		return nil, errors.New("tag must not be empty")
+	}
+	plan, err := PlanFilter(n, p)
+	if err != nil {
+		return nil, err
+	}
+	free := maxEmbeddedSize - t.tagsSize(tag)
+	if size := int64(plan.FileSize()); size > free {
+		return nil, fmt.Errorf("%w: a filter of tag %q with capacity %d takes %d bytes, at most %d fit (capacity %d)",
+			ErrMetadataTooLarge, tag, n, size, free, embeddedCapacity(free, p))
+	}
+	filter, err := New(n, p)
+	if err != nil {
+		return nil, err
+	}
+	if t.main.maxValueLength > 0 {
+		filter.SetMaxValueLength(t.main.maxValueLength, t.main.valueLengthPolicy)
+	}
+	t.tags[tag] = filter
+	return filter, nil
+}
+
+// EnsureTag returns the filter of a tag, which is created if the tag has none
+// with a capacity of DefaultTagRatio times that of the main filter, but at
+// most the capacity that fits into the metadata besides the other tags, and
+// its FP probability.
+func (t *TaggedFilter) EnsureTag(tag string) (*BloomFilter, error) {
+	if filter, ok := t.tags[tag]; ok {
+		return filter, nil
+	}
+	n := uint64(float64(t.main.n) * DefaultTagRatio)
+	if max := embeddedCapacity(maxEmbeddedSize-t.tagsSize(tag), t.main.p); n > max {
+		n = max
+	}
+	if n == 0 {
+		n = 1
+	}
+	return t.AddTag(tag, n, t.main.p)
+}
+
+// Add adds a value to the main filter without attributing it to a tag.
+func (t *TaggedFilter) Add(value []byte) {
+	t.main.Add(value)
+}
+
+// AddTagged adds a value to the main filter and to the filter of the tag,
+// which is created by EnsureTag if needed.
+func (t *TaggedFilter) AddTagged(value []byte, tag string) error {
+	filter, err := t.EnsureTag(tag)
+	if err != nil {
+		return err
+	}
+	t.main.Add(value)
+	filter.Add(value)
+	return nil
+}
+
+// NumElements returns the number of values added to the main filter.
+func (t *TaggedFilter) NumElements() uint64 {
+	return t.main.NumElements()
+}
+
+// Check returns true if the value may be in the main filter.
+func (t *TaggedFilter) Check(value []byte) bool {
+	return t.main.Check(value)
+}
+
+// Rejects returns true if the main filter rejects the value due to its
+// length, like Rejects of BloomFilter.
+func (t *TaggedFilter) Rejects(value []byte) bool {
+	return t.main.rejects(value)
+}
+
+// TryCheck checks a value like Check, but returns an error wrapping
+// ErrValueTooLarge if the main filter rejects the value due to its length.
+func (t *TaggedFilter) TryCheck(value []byte) (bool, error) {
+	return t.main.TryCheck(value)
+}
+
+// CheckTagged returns the tags the value was probably added with, in sorted
+// order, if the main filter matches it, and nil otherwise. See TaggedFilter
+// for the false positives of the attribution.
+func (t *TaggedFilter) CheckTagged(value []byte) []string {
+	if !t.main.Check(value) {
+		return nil
+	}
+	var tags []string
+	for _, tag := range t.Tags() {
+		if t.tags[tag].Check(value) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,204 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// feedValue returns the i-th value of a feed.
+func feedValue(feed string, i int) []byte {
+	return []byte(fmt.Sprintf("%s-%d", feed, i))
+}
+
+func TestTaggedFilterOverlappingFeeds(t *testing.T) {
+	filter := NewTaggedFilter(mustNew(20000, 0.001))
+	if _, err := filter.AddTag("big", 15000, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := filter.AddTag("small", 1000, 0.01); err != nil {
+		t.Fatal(err)
+	}
+	// the small feed repeats the first 500 values of the big one
+	for i := 0; i < 10000; i++ {
+		if err := filter.AddTagged(feedValue("big", i), "big"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		value := feedValue("small", i)
+		if i < 500 {
+			value = feedValue("big", i)
+		}
+		if err := filter.AddTagged(value, "small"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a third feed is tagged with the default size
+	filter.AddTagged([]byte("other"), "other")
+	filter.Add([]byte("untagged"))
+
+	if tags := filter.Tags(); !reflect.DeepEqual(tags, []string{"big", "other", "small"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+	if n := filter.Tag("other").MaxNumElements(); n != 2000 {
+		t.Fatalf("expected a default capacity of 2000, got %d", n)
+	}
+	misattributed := 0
+	for i := 0; i < 10000; i++ {
+		tags := filter.CheckTagged(feedValue("big", i))
+		if len(tags) == 0 || tags[0] != "big" {
+			t.Fatalf("value %d of big not attributed to it: %v", i, tags)
+		}
+		shared := i < 500
+		if containsTag(tags, "small") != shared {
+			if shared {
+				t.Fatalf("shared value %d not attributed to small: %v", i, tags)
+			}
+			misattributed++
+		}
+	}
+	// the tag filters never miss their values, but report other values with
+	// about their FP probability
+	if rate := float64(misattributed) / 9500; rate > 0.03 {
+		t.Errorf("values of big attributed to small at a rate of %g", rate)
+	}
+	for i := 500; i < 1000; i++ {
+		if tags := filter.CheckTagged(feedValue("small", i)); !containsTag(tags, "small") {
+			t.Fatalf("value %d of small not attributed to it: %v", i, tags)
+		}
+	}
+	if tags := filter.CheckTagged([]byte("untagged")); !filter.Check([]byte("untagged")) || len(tags) > 1 {
+		t.Fatalf("unexpected tags %v of an untagged value", tags)
+	}
+	for i := 0; i < 1000; i++ {
+		value := feedValue("never added", i)
+		if tags := filter.CheckTagged(value); !filter.Check(value) && tags != nil {
+			t.Fatalf("tags %v reported for a value the main filter does not match", tags)
+		}
+	}
+	// the shared values are counted once
+	if filter.NumElements() != 10502 {
+		t.Fatalf("expected 10502 elements, got %d", filter.NumElements())
+	}
+}
+
+func containsTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTaggedFilterWrite(t *testing.T) {
+	filter := NewTaggedFilter(mustNew(1000, 0.01))
+	filter.AddTagged([]byte("foo"), "feed-a")
+	filter.AddTagged([]byte("bar"), "feed-b")
+	filter.AddTagged([]byte("baz"), "feed-a")
+	filter.AddTagged([]byte("baz"), "feed-b")
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	main, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !HasTags(main) || !main.Check([]byte("foo")) {
+		t.Fatal("tags or values missing from the main filter")
+	}
+	loaded, err := TaggedOf(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasTags(loaded.Main()) {
+		t.Fatal("tag metadata left in the main filter")
+	}
+	for value, expected := range map[string][]string{"foo": {"feed-a"}, "bar": {"feed-b"}, "baz": {"feed-a", "feed-b"}} {
+		if tags := loaded.CheckTagged([]byte(value)); !reflect.DeepEqual(tags, expected) {
+			t.Errorf("%s: expected tags %v, got %v", value, expected, tags)
+		}
+	}
+	if HasTags(mustNew(10, 0.01)) {
+		t.Fatal("tags reported for a plain filter")
+	}
+}
+
+func TestTaggedFilterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagged")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tagged.bloom")
+	filter := NewTaggedFilter(mustNew(1000, 0.01))
+	filter.AddTagged([]byte("foo"), "feed")
+	if err := WriteTaggedFilter(filter, path, true); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTaggedFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags := loaded.CheckTagged([]byte("foo")); !reflect.DeepEqual(tags, []string{"feed"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestTaggedFilterValueLimit(t *testing.T) {
+	main := mustNew(1000, 0.01)
+	main.SetMaxValueLength(4, TruncateValues)
+	filter := NewTaggedFilter(main)
+	filter.AddTagged([]byte("foobar"), "feed")
+	if tags := filter.CheckTagged([]byte("foobaz")); !reflect.DeepEqual(tags, []string{"feed"}) {
+		t.Fatalf("the tag filter does not truncate like the main filter: %v", tags)
+	}
+	if _, err := filter.AddTag("", 10, 0.01); err == nil {
+		t.Fatal("expected an error for an empty tag")
+	}
+	if _, err := filter.AddTag("invalid", 10, 2); err == nil {
+		t.Fatal("expected an error for an invalid FP probability")
+	}
+}
+
+func TestTaggedOfInvalid(t *testing.T) {
+	main := mustNew(10, 0.01)
+	main.SetMetadata(MetadataKeyTagPrefix+"broken", "not a filter")
+	if _, err := TaggedOf(main); err == nil {
+		t.Fatal("expected an error for an invalid tag filter")
+	}
+}
+
+func TestTaggedFilterTooLarge(t *testing.T) {
+	main := mustNew(1000, 0.001)
+	tagged := NewTaggedFilter(main)
+	if _, err := tagged.AddTag("huge", 100000000, 0.001); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+
+	// the default capacity is capped to what can be stored, in total
+	main.n = 100000000
+	first, err := tagged.EnsureTag("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := first.MaxNumElements(); n == 0 || n >= 10000000 {
+		t.Fatalf("unexpected default tag capacity %d", n)
+	}
+	if _, err := tagged.AddTag("second", 1000000, 0.001); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge for a second tag, got %v", err)
+	}
+	if _, err := tagged.encodeTags(); err != nil {
+		t.Fatal(err)
+	}
+}
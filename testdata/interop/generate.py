@@ -0,0 +1,145 @@
+#!/usr/bin/env python3
+"""Generates the interoperability fixtures of this directory.
+
+The filters are written by a reference implementation of the file format and
+of hash scheme 1 (fnv1-64) as documented by `bloom format --markdown`, which
+deliberately shares no code with the Go package, like the implementations in
+other languages. For each fixture, the .values file lists the answer of the
+reference implementation for each value: "+value" if it may be in the filter
+and "-value" if it is not, including its false positives, so that any reader
+must give exactly the same answers. fixtures.json describes the fixtures.
+
+The fixtures are thus not written by the implementations of the format in
+other languages: this repository neither vendors nor pins any of them, and
+the fixtures are to be reproducible from this file alone. They check the
+reader against the documented format, not against a particular library. A
+fixture written by such a library is to be added to fixtures.json with the
+"generator" naming the library and its version.
+
+The hash scheme is written as zero in the flags field, which readers take
+for scheme 1 (fnv1-64) just like an explicit 1, and recorded as 1 in
+fixtures.json.
+
+Run it from this directory (the output is deterministic):
+
+    python3 generate.py
+"""
+
+import gzip
+import json
+import math
+import struct
+
+# the generator recorded for the fixtures written by this file
+GENERATOR = "generate.py (reference implementation)"
+
+MASK = 2**64 - 1
+FNV_OFFSET = 14695981039346656037
+FNV_PRIME = 1099511628211
+MOD = 2**64 - 59
+G = 2**64 - 1469
+
+
+def fnv1_64(data):
+    h = FNV_OFFSET
+    for b in data:
+        h = (h * FNV_PRIME) & MASK
+        h ^= b
+    return h
+
+
+def probes(value, k, m):
+    h = fnv1_64(value) % MOD
+    for _ in range(k):
+        # the product wraps around at 64 bits before the modulo
+        h = ((h * G) & MASK) % MOD
+        yield h % m
+
+
+class Filter:
+    def __init__(self, n, p):
+        self.n, self.p = n, p
+        self.m = math.ceil(n * -math.log(p) / math.log(2) ** 2)
+        self.k = math.ceil(math.log(2) * self.m / n)
+        self.words = [0] * ((self.m + 63) // 64)
+        self.count = 0
+
+    def add(self, value):
+        for i in probes(value, self.k, self.m):
+            self.words[i // 64] |= 1 << (i % 64)
+        self.count += 1
+
+    def check(self, value):
+        return all(self.words[i // 64] >> (i % 64) & 1 for i in probes(value, self.k, self.m))
+
+    def serialize(self, version, comment="", metadata=None, data=b"", hash_scheme=0):
+        region = b""
+        if comment:
+            region = comment.encode() + b"\n"
+            region += b"\0" * (-len(region) % 8)
+        flags = version | (len(region) // 8) << 8 | hash_scheme << 24
+        if region:
+            flags |= 1 << 18  # required flag of the comment region
+        out = struct.pack("<QQdQQQ", flags, self.n, self.p, self.k, self.m, self.count)
+        out += region
+        out += b"".join(struct.pack("<Q", w) for w in self.words)
+        if version == 2:
+            entries = b""
+            for key in sorted(metadata or {}):
+                for s in (key.encode(), metadata[key].encode()):
+                    entries += struct.pack("<Q", len(s)) + s
+            out += struct.pack("<Q", len(entries)) + entries
+        return out + data
+
+
+FIXTURES = [
+    dict(file="v1.bloom", n=100, p=0.01, version=1,
+         values=["interop-%d" % i for i in range(50)]),
+    dict(file="v1-data.bloom.gz", n=1000, p=0.001, version=1, gzip=True,
+         data=b"attached by generate.py",
+         values=["bücher.example", "xn--bcher-kva.example", "with space", "tab\tseparated",
+                 "x" * 1000] + ["10.0.%d.%d" % (i // 256, i % 256) for i in range(500)]),
+    dict(file="v2.bloom", n=500, p=0.0001, version=2,
+         comment="interop fixture written by generate.py",
+         metadata={"source": "generate.py", "feed": "interop"},
+         values=["https://example.com/%d" % i for i in range(200)]),
+    dict(file="hash-scheme-2.bloom", n=100, p=0.01, version=1, hash_scheme=2,
+         values=["interop-%d" % i for i in range(10)]),
+]
+
+
+def main():
+    manifest = []
+    for fixture in FIXTURES:
+        f = Filter(fixture["n"], fixture["p"])
+        for value in fixture["values"]:
+            f.add(value.encode())
+        out = f.serialize(fixture["version"], fixture.get("comment", ""), fixture.get("metadata"),
+                          fixture.get("data", b""), fixture.get("hash_scheme", 0))
+        if fixture.get("gzip"):
+            out = gzip.compress(out, mtime=0)
+        with open(fixture["file"], "wb") as w:
+            w.write(out)
+
+        name = fixture["file"].split(".")[0] + ".values"
+        lines = ["+" + value for value in fixture["values"]]
+        for i in range(1000):
+            value = "probe-%d" % i
+            lines.append(("+" if f.check(value.encode()) else "-") + value)
+        with open(name, "w", encoding="utf-8") as w:
+            w.write("# answers of generate.py for %s\n" % fixture["file"])
+            w.write("\n".join(lines) + "\n")
+
+        entry = dict(file=fixture["file"], generator=GENERATOR, gzip=fixture.get("gzip", False), values=name,
+                     version=fixture["version"], capacity=f.n, fpp=f.p, hash_funcs=f.k, bits=f.m,
+                     elements=f.count, hash_scheme=fixture.get("hash_scheme") or 1,
+                     comment=fixture.get("comment", ""), metadata=fixture.get("metadata", {}),
+                     data=fixture.get("data", b"").decode())
+        manifest.append(entry)
+    with open("fixtures.json", "w") as w:
+        json.dump(manifest, w, indent=2, sort_keys=True)
+        w.write("\n")
+
+
+if __name__ == "__main__":
+    main()
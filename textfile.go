@@ -0,0 +1,160 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bufio"
+	"bytes"
+	gz "compress/gzip"
+	"io"
+	"math"
+	"os"
+)
+
+// AutoSizeHeadroom is the factor by which NewFilterFromTextFile exceeds the
+// number of values in a file when determining the capacity automatically.
+const AutoSizeHeadroom = 1.25
+
+// gzipMagic are the first bytes of gzip-compressed data.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// openText returns a reader for a text file, decompressing gzip-compressed
+// files automatically.
+func openText(path string) (io.Reader, func() error, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, file.Close, nil
+	}
+	gzipReader, err := gz.NewReader(buffered)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return gzipReader, func() error {
+		gzipReader.Close()
+		return file.Close()
+	}, nil
+}
+
+// stripCR removes a trailing carriage return from a line.
+func stripCR(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// skipEmpty skips empty lines.
+func skipEmpty(line []byte) []byte {
+	if len(line) == 0 {
+		return nil
+	}
+	return line
+}
+
+// WithReadProgress configures a function that NewFilterFromTextFile calls
+// while reading the file with the number of (decompressed) bytes read so far,
+// in total over both reads if the capacity is determined automatically.
+func WithReadProgress(fn func(read int64)) Option {
+	return func(s *BloomFilter) {
+		s.readProgress = fn
+	}
+}
+
+// progressReader reports the bytes read from the wrapped reader.
+type progressReader struct {
+	io.Reader
+	report func(n int)
+}
+
+func (r progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.report(n)
+	}
+	return n, err
+}
+
+// readTextFile writes the lines of a text file to w, reporting the bytes read
+// to report if it is not nil.
+func readTextFile(path string, w io.WriteCloser, report func(n int)) error {
+	r, closeFile, err := openText(path)
+	if err != nil {
+		return err
+	}
+	defer closeFile()
+	if report != nil {
+		r = progressReader{r, report}
+	}
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// lineCounter counts the lines written to it.
+type lineCounter struct {
+	lines uint64
+}
+
+func (c *lineCounter) add(line []byte) []byte {
+	c.lines++
+	return nil
+}
+
+// NewFilterFromTextFile returns a new Bloom filter with the given capacity (n)
+// and FP probability (p), configured by the given options, containing the
+// lines of a text file, which may be gzip-compressed. Trailing carriage
+// returns are removed from the lines and empty lines are skipped, before the
+// normalizers configured with WithLineNormalizers are applied. The number of
+// values that were new to the filter (i.e. distinct values, up to false
+// positives) is returned as well.
+//
+// If n is zero, the file is read twice: first to count the values, then to
+// add them to a filter with a capacity of AutoSizeHeadroom times that count.
+func NewFilterFromTextFile(path string, n uint64, p float64, opts ...Option) (*BloomFilter, uint64, error) {
+	var filter *BloomFilter
+	var err error
+	var read int64
+	reporter := func(s *BloomFilter) func(n int) {
+		if s.readProgress == nil {
+			return nil
+		}
+		return func(n int) {
+			read += int64(n)
+			s.readProgress(read)
+		}
+	}
+	if n == 0 {
+		// the options are applied to a filter to obtain the normalizers
+		var counting BloomFilter
+		for _, opt := range opts {
+			opt(&counting)
+		}
+		counter := &lineCounter{}
+		w := counting.textFileWriter(counter.add)
+		if err = readTextFile(path, w, reporter(&counting)); err != nil {
+			return nil, 0, err
+		}
+		n = uint64(math.Ceil(float64(counter.lines) * AutoSizeHeadroom))
+		if n == 0 {
+			n = 1
+		}
+		if counting.exactCounter != nil {
+			counting.exactCounter.removeRuns()
+		}
+	}
+	if filter, err = New(n, p, opts...); err != nil {
+		return nil, 0, err
+	}
+	if err = readTextFile(path, filter.textFileWriter(), reporter(filter)); err != nil {
+		return nil, 0, err
+	}
+	return filter, filter.NumElements(), nil
+}
@@ -0,0 +1,195 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	gz "compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTextFile(t *testing.T, dir, name, content string, compress bool) string {
+	path := filepath.Join(dir, name)
+	data := []byte(content)
+	if compress {
+		var buf bytes.Buffer
+		w := gz.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		data = buf.Bytes()
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewFilterFromTextFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "foo\r\nbar\n\n\r\nbaz\nfoo\nqux"
+	for _, compress := range []bool{false, true} {
+		path := writeTextFile(t, dir, "values.txt", content, compress)
+		filter, added, err := NewFilterFromTextFile(path, 1000, 0.0001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 4 {
+			t.Fatalf("expected 4 values to be added, not %d", added)
+		}
+		for _, v := range []string{"foo", "bar", "baz", "qux"} {
+			if !filter.Check([]byte(v)) {
+				t.Fatalf("value %q not found (gzip: %v)", v, compress)
+			}
+		}
+		for _, v := range []string{"", "\r", "foo\r"} {
+			if filter.Check([]byte(v)) {
+				t.Fatalf("value %q should not have been added (gzip: %v)", v, compress)
+			}
+		}
+	}
+
+	if _, _, err := NewFilterFromTextFile(filepath.Join(dir, "missing.txt"), 1000, 0.01); err == nil {
+		t.Fatal("missing file should fail")
+	}
+}
+
+func TestNewFilterFromTextFileNormalizers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeTextFile(t, dir, "values.txt", "Foo\nFOO\n# comment\nbar\n", false)
+	lower := func(value []byte) []byte { return bytes.ToLower(value) }
+	skipComments := func(value []byte) []byte {
+		if bytes.HasPrefix(value, []byte("#")) {
+			return nil
+		}
+		return value
+	}
+	filter, added, err := NewFilterFromTextFile(path, 0, 0.0001, WithLineNormalizers(skipComments, lower))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 || !filter.Check([]byte("foo")) || filter.Check([]byte("Foo")) {
+		t.Fatal("normalizers not applied")
+	}
+	// the comment is not counted when sizing the filter
+	if filter.MaxNumElements() != 4 {
+		t.Fatalf("unexpected capacity %d", filter.MaxNumElements())
+	}
+}
+
+func TestNewFilterFromTextFileNormalizersCRLF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the normalizer sees neither CRs nor empty lines
+	var seen []string
+	reverse := func(value []byte) []byte {
+		seen = append(seen, string(value))
+		reversed := make([]byte, len(value))
+		for i, b := range value {
+			reversed[len(value)-1-i] = b
+		}
+		return reversed
+	}
+	lf := writeTextFile(t, dir, "lf.txt", "foo\nbar\n\nbaz\n", false)
+	crlf := writeTextFile(t, dir, "crlf.txt", "foo\r\nbar\r\n\r\nbaz\r\n", false)
+	var filters []*BloomFilter
+	for _, path := range []string{lf, crlf} {
+		seen = nil
+		filter, added, err := NewFilterFromTextFile(path, 1000, 0.0001, WithLineNormalizers(reverse))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if added != 3 || !filter.Check([]byte("oof")) || strings.Join(seen, ",") != "foo,bar,baz" {
+			t.Fatalf("%s: added %d values, normalized %q", filepath.Base(path), added, seen)
+		}
+		filters = append(filters, filter)
+	}
+	if !bytes.Equal(filters[0].GetData(), filters[1].GetData()) {
+		t.Fatal("LF and CRLF files give different filters")
+	}
+}
+
+func TestNewFilterFromTextFileAutoSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var content strings.Builder
+	for i := uint64(0); i < 1000; i++ {
+		fmt.Fprintf(&content, "value-%d\n\n", i)
+	}
+	path := writeTextFile(t, dir, "values.txt.gz", content.String(), true)
+	filter, added, err := NewFilterFromTextFile(path, 0, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.MaxNumElements() != 1250 {
+		t.Fatalf("expected a capacity of 1250 with headroom, not %d", filter.MaxNumElements())
+	}
+	if added < 995 || added > 1000 {
+		t.Fatalf("unexpected number of added values %d", added)
+	}
+
+	empty := writeTextFile(t, dir, "empty.txt", "", false)
+	filter, added, err = NewFilterFromTextFile(empty, 0, 0.001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 0 || filter.MaxNumElements() != 1 {
+		t.Fatal("empty file should yield an empty filter of minimal capacity")
+	}
+}
+
+func TestNewFilterFromTextFileReadProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := strings.Repeat("foo\nbar\n", 10000)
+	path := writeTextFile(t, dir, "values.txt.gz", content, true)
+	for _, c := range []struct {
+		n        uint64
+		expected int64
+	}{
+		{1000, int64(len(content))},
+		// both reads are counted
+		{0, 2 * int64(len(content))},
+	} {
+		var last int64
+		_, _, err := NewFilterFromTextFile(path, c.n, 0.01, WithReadProgress(func(read int64) {
+			if read <= last {
+				t.Fatalf("progress went from %d to %d bytes", last, read)
+			}
+			last = read
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last != c.expected {
+			t.Fatalf("n %d: expected %d bytes read, got %d", c.n, c.expected, last)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// MetadataKeyTombstones stores the serialized tombstone filter of a
+// TombstoneFilter with its main filter.
+const MetadataKeyTombstones = "bloom.tombstones"
+
+// DefaultTombstoneRatio is the capacity of the tombstone filter relative to
+// that of the main filter, used when loading a filter without tombstones.
+const DefaultTombstoneRatio = 0.1
+
+// TombstoneFilter suppresses values deleted from a Bloom filter by recording
+// them in a second, usually smaller, filter of tombstones. A value is
+// reported as contained if the main filter matches it and the tombstone
+// filter does not.
+//
+// Note that a false positive of the tombstone filter makes Check report a
+// value as not contained although it was added and never deleted. Unlike a
+// plain Bloom filter, a TombstoneFilter can therefore yield false negatives,
+// with about the false positive probability of the tombstone filter at its
+// current fill, for every value. The tombstone filter should be sized for
+// the expected number of deletions, and Compact should be used to rebuild the
+// main filter and clear the tombstones before it fills up.
+type TombstoneFilter struct {
+	main       *BloomFilter
+	tombstones *BloomFilter
+}
+
+// TombstoneStats summarizes both sides of a TombstoneFilter.
+type TombstoneStats struct {
+	Main       FilterStats
+	Tombstones FilterStats
+	// MainFill and TombstoneFill are the fractions of set bits.
+	MainFill      float64
+	TombstoneFill float64
+}
+
+// NewTombstoneFilter returns a TombstoneFilter for the main filter, with an
+// empty tombstone filter of the given capacity (n) and FP probability (p).
+// As the tombstones are stored in the metadata of the main filter, an error
+// wrapping ErrMetadataTooLarge is returned if they would not fit.
+func NewTombstoneFilter(main *BloomFilter, n uint64, p float64) (*TombstoneFilter, error) {
+	plan, err := PlanFilter(n, p)
+	if err != nil {
+		return nil, err
+	}
+	if size := plan.FileSize(); size > maxEmbeddedSize {
+		return nil, fmt.Errorf("%w: a tombstone filter with capacity %d takes %d bytes, at most %d fit (capacity %d)",
+			ErrMetadataTooLarge, n, size, maxEmbeddedSize, embeddedCapacity(maxEmbeddedSize, p))
+	}
+	tombstones, err := New(n, p)
+	if err != nil {
+		return nil, err
+	}
+	return &TombstoneFilter{main: main, tombstones: tombstones}, nil
+}
+
+// DefaultTombstoneCapacity returns the capacity of the tombstone filter with
+// FP probability p of a filter without tombstones: DefaultTombstoneRatio
+// times the capacity of the filter, but at most the capacity that can be
+// stored in its metadata.
+func DefaultTombstoneCapacity(main *BloomFilter, p float64) uint64 {
+	n := uint64(float64(main.n) * DefaultTombstoneRatio)
+	if max := embeddedCapacity(maxEmbeddedSize, p); n > max {
+		n = max
+	}
+	if n == 0 {
+		n = 1
+	}
+	return n
+}
+
+// TombstonesOf returns a TombstoneFilter for a filter loaded from the output
+// of TombstoneFilter.Write, taking the tombstones from its metadata. If the
+// filter has no tombstones, an empty tombstone filter is created with the
+// FP probability of the filter and its DefaultTombstoneCapacity.
+func TombstonesOf(main *BloomFilter) (*TombstoneFilter, error) {
+	v, ok := main.Metadata(MetadataKeyTombstones)
+	if !ok {
+		return NewTombstoneFilter(main, DefaultTombstoneCapacity(main, main.p), main.p)
+	}
+	tombstones, err := LoadFromBytes([]byte(v), false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tombstone filter: %w", err)
+	}
+	main.DeleteMetadata(MetadataKeyTombstones)
+	return &TombstoneFilter{main: main, tombstones: tombstones}, nil
+}
+
+// LoadTombstoneFilter loads a TombstoneFilter from a file, see TombstonesOf.
+func LoadTombstoneFilter(path string, gzip bool, opts ...LoadOption) (*TombstoneFilter, error) {
+	main, err := LoadFilter(path, gzip, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return TombstonesOf(main)
+}
+
+// WriteTombstoneFilter writes a TombstoneFilter to a file like WriteFilter.
+func WriteTombstoneFilter(filter *TombstoneFilter, path string, gzip bool, opts ...WriteOption) error {
+	encoded, err := filter.encodeTombstones()
+	if err != nil {
+		return err
+	}
+	return WriteFilter(filter.main, path, gzip, append(opts, withMetadata(MetadataKeyTombstones, encoded))...)
+}
+
+// Write writes the main filter like Write of BloomFilter, with the tombstone
+// filter stored in its metadata (limited to the maximum size of the metadata
+// section). Loading the output with LoadFilter yields the main filter only,
+// which ignores the deletions.
+func (t *TombstoneFilter) Write(w io.Writer, opts ...WriteOption) error {
+	encoded, err := t.encodeTombstones()
+	if err != nil {
+		return err
+	}
+	return t.main.Write(w, append(opts, withMetadata(MetadataKeyTombstones, encoded))...)
+}
+
+func (t *TombstoneFilter) encodeTombstones() (string, error) {
+	var buf bytes.Buffer
+	if err := t.tombstones.Write(&buf); err != nil {
+		return "", err
+	}
+	if buf.Len() > maxMetadataSize {
+		return "", fmt.Errorf("%w: tombstone filter is too large to be stored (%d bytes)", ErrMetadataTooLarge, buf.Len())
+	}
+	return buf.String(), nil
+}
+
+// Main returns the main filter.
+func (t *TombstoneFilter) Main() *BloomFilter {
+	return t.main
+}
+
+// Tombstones returns the filter of deleted values.
+func (t *TombstoneFilter) Tombstones() *BloomFilter {
+	return t.tombstones
+}
+
+// Add adds a value to the main filter. Values that were deleted remain
+// deleted until the next Compact.
+func (t *TombstoneFilter) Add(value []byte) {
+	t.main.Add(value)
+}
+
+// NumElements returns the number of values added to the main filter, which
+// includes deleted values.
+func (t *TombstoneFilter) NumElements() uint64 {
+	return t.main.NumElements()
+}
+
+// Delete suppresses a value. Values that are not in the main filter are
+// ignored, so that they do not fill the tombstone filter.
+func (t *TombstoneFilter) Delete(value []byte) {
+	if t.main.Check(value) {
+		t.tombstones.Add(value)
+	}
+}
+
+// Check returns true if the value may be in the main filter and has not been
+// deleted. See TombstoneFilter for the false negatives caused by deletions.
+func (t *TombstoneFilter) Check(value []byte) bool {
+	return t.main.Check(value) && !t.tombstones.Check(value)
+}
+
+// Rejects returns true if the main filter rejects the value due to its
+// length, like Rejects of BloomFilter.
+func (t *TombstoneFilter) Rejects(value []byte) bool {
+	return t.main.rejects(value)
+}
+
+// TryCheck checks a value like Check, but returns an error wrapping
+// ErrValueTooLarge if the main filter rejects the value due to its length.
+func (t *TombstoneFilter) TryCheck(value []byte) (bool, error) {
+	ok, err := t.main.TryCheck(value)
+	if err != nil || !ok {
+		return false, err
+	}
+	return !t.tombstones.Check(value), nil
+}
+
+// Stats returns a summary of both filters.
+func (t *TombstoneFilter) Stats() TombstoneStats {
+	return TombstoneStats{
+		Main:          t.main.Stats(),
+		Tombstones:    t.tombstones.Stats(),
+		MainFill:      float64(t.main.NumSetBits()) / float64(t.main.m),
+		TombstoneFill: float64(t.tombstones.NumSetBits()) / float64(t.tombstones.m),
+	}
+}
+
+// Compact replaces the main filter by an empty filter with the same
+// dimensions, settings and Data, to which rebuild adds the values from an
+// authoritative source, and clears the tombstones. If rebuild returns an
+// error, the filter is left unchanged.
+func (t *TombstoneFilter) Compact(rebuild func(add func([]byte)) error) error {
+	main := t.main.emptyCopy()
+	if err := rebuild(main.Add); err != nil {
+		return err
+	}
+	t.main = main
+	t.tombstones.Reset()
+	return nil
+}
@@ -0,0 +1,154 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTombstoneFilter(t *testing.T) {
+	main, values := GenerateExampleFilter(1000, 0.0001, 100)
+	filter, err := NewTombstoneFilter(main, 100, 0.0001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range values[:10] {
+		filter.Delete(value)
+	}
+	filter.Delete([]byte("not in the filter"))
+	if filter.Tombstones().N != 10 {
+		t.Fatalf("expected 10 tombstones, found %d", filter.Tombstones().N)
+	}
+	for i, value := range values {
+		if filter.Check(value) != (i >= 10) {
+			t.Fatalf("unexpected result for value %d", i)
+		}
+	}
+	// re-adding does not undo the deletion
+	filter.Add(values[0])
+	if filter.Check(values[0]) {
+		t.Fatal("deleted value found after adding it again")
+	}
+	stats := filter.Stats()
+	if stats.Main.Elements != 100 || stats.Tombstones.Elements != 10 || stats.MainFill <= 0 || stats.TombstoneFill <= 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// round trip through the combined serialization
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plain.Check(values[0]) {
+		t.Fatal("the main filter should not be modified by deletions")
+	}
+	loaded, err := TombstonesOf(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Main().Metadata(MetadataKeyTombstones); ok {
+		t.Fatal("tombstones should be removed from the metadata of the main filter")
+	}
+	for i, value := range values {
+		if loaded.Check(value) != (i >= 10) {
+			t.Fatalf("unexpected result for value %d after loading", i)
+		}
+	}
+}
+
+func TestTombstoneFilterCompact(t *testing.T) {
+	main, values := GenerateExampleFilter(1000, 0.0001, 100)
+	main.SetMetadata("source", "test")
+	filter, _ := TombstonesOf(main)
+	if filter.Tombstones().MaxNumElements() != 100 {
+		t.Fatalf("unexpected default tombstone capacity %d", filter.Tombstones().MaxNumElements())
+	}
+	for _, value := range values[:50] {
+		filter.Delete(value)
+	}
+
+	if err := filter.Compact(func(add func([]byte)) error {
+		add(values[0])
+		return errors.New("source unavailable")
+	}); err == nil {
+		t.Fatal("failed rebuild should return the error")
+	}
+	if filter.Main() != main || filter.Check(values[0]) || !filter.Check(values[50]) {
+		t.Fatal("failed rebuild should leave the filter unchanged")
+	}
+
+	// the authoritative source still contains the first of the deleted values
+	if err := filter.Compact(func(add func([]byte)) error {
+		add(values[0])
+		for _, value := range values[50:] {
+			add(value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Tombstones().N != 0 || filter.Tombstones().NumSetBits() != 0 {
+		t.Fatal("tombstones not cleared")
+	}
+	if filter.Main().N != 51 || !filter.Check(values[0]) || filter.Check(values[1]) || !filter.Check(values[99]) {
+		t.Fatal("unexpected main filter after rebuilding")
+	}
+	if v, _ := filter.Main().Metadata("source"); v != "test" {
+		t.Fatal("metadata not kept")
+	}
+}
+
+func TestTombstoneFilterFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloomtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main, values := GenerateExampleFilter(1000, 0.0001, 10)
+	filter, _ := NewTombstoneFilter(main, 10, 0.001)
+	filter.Delete(values[3])
+	path := filepath.Join(dir, "test.bloom.gz")
+	if err := WriteTombstoneFilter(filter, path, true); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadTombstoneFilter(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Check(values[3]) || !loaded.Check(values[4]) || loaded.Tombstones().FalsePositiveProb() != 0.001 {
+		t.Fatal("unexpected filter after loading")
+	}
+}
+
+func TestTombstoneFilterTooLarge(t *testing.T) {
+	main := mustNew(1000, 0.001)
+	if _, err := NewTombstoneFilter(main, 100000000, 0.001); !errors.Is(err, ErrMetadataTooLarge) {
+		t.Fatalf("expected ErrMetadataTooLarge, got %v", err)
+	}
+
+	// the default capacity is capped to what can be stored
+	main.n = 1000000000
+	filter, err := TombstonesOf(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := filter.Tombstones().MaxNumElements()
+	if n == 0 || n >= 100000000 {
+		t.Fatalf("unexpected default tombstone capacity %d", n)
+	}
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+}
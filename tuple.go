@@ -0,0 +1,41 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import "encoding/binary"
+
+// EncodeTuple returns the canonical encoding of a composite key consisting of
+// the given parts, which is the value to add to or check against filters
+// keyed on tuples (e.g. a customer ID and an indicator). Each part is encoded
+// as its length in bytes, an unsigned 64-bit little-endian integer (like the
+// length prefixes of the file format), followed by its bytes. Unlike joining
+// the parts with a delimiter, this encoding is collision-free: different
+// tuples, e.g. ("ab", "c") and ("a", "bc"), always have different encodings,
+// whatever bytes the parts contain.
+func EncodeTuple(parts ...[]byte) []byte {
+	size := 0
+	for _, part := range parts {
+		size += FormatLengthSize + len(part)
+	}
+	encoded := make([]byte, 0, size)
+	var length [FormatLengthSize]byte
+	for _, part := range parts {
+		binary.LittleEndian.PutUint64(length[:], uint64(len(part)))
+		encoded = append(encoded, length[:]...)
+		encoded = append(encoded, part...)
+	}
+	return encoded
+}
+
+// AddTuple adds the composite key consisting of the given parts to the
+// filter, encoded with EncodeTuple.
+func (s *BloomFilter) AddTuple(parts ...[]byte) {
+	s.Add(EncodeTuple(parts...))
+}
+
+// CheckTuple returns true if the composite key consisting of the given parts,
+// encoded with EncodeTuple, is contained in the filter.
+func (s *BloomFilter) CheckTuple(parts ...[]byte) bool {
+	return s.Check(EncodeTuple(parts...))
+}
@@ -0,0 +1,52 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeTuple(t *testing.T) {
+	ab := EncodeTuple([]byte("ab"), []byte("c"))
+	a := EncodeTuple([]byte("a"), []byte("bc"))
+	if bytes.Equal(ab, a) {
+		t.Fatal("different tuples have the same encoding")
+	}
+	expected := []byte{2, 0, 0, 0, 0, 0, 0, 0, 'a', 'b', 1, 0, 0, 0, 0, 0, 0, 0, 'c'}
+	if !bytes.Equal(ab, expected) {
+		t.Fatalf("unexpected encoding %v", ab)
+	}
+	// distinct tuples, including empty ones and parts, all differ
+	tuples := [][][]byte{
+		{},
+		{[]byte("")},
+		{[]byte(""), []byte("")},
+		{[]byte("abc")},
+		{[]byte("ab"), []byte("c")},
+		{[]byte("a"), []byte("b"), []byte("c")},
+		{[]byte("a\x01"), []byte("")},
+	}
+	for i := range tuples {
+		for j := range tuples[:i] {
+			if bytes.Equal(EncodeTuple(tuples[i]...), EncodeTuple(tuples[j]...)) {
+				t.Fatalf("tuples %q and %q have the same encoding", tuples[i], tuples[j])
+			}
+		}
+	}
+}
+
+func TestAddCheckTuple(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.AddTuple([]byte("customer-1"), []byte("example.com"))
+	if !filter.CheckTuple([]byte("customer-1"), []byte("example.com")) {
+		t.Fatal("added tuple not contained")
+	}
+	if !filter.Check(EncodeTuple([]byte("customer-1"), []byte("example.com"))) {
+		t.Fatal("encoded tuple not contained")
+	}
+	if filter.CheckTuple([]byte("customer-1example.com")) || filter.CheckTuple([]byte("customer-"), []byte("1example.com")) {
+		t.Fatal("different tuple contained")
+	}
+}
@@ -0,0 +1,106 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"crypto/sha256"
+	"hash"
+	"hash/fnv"
+)
+
+// ValueHasher hashes a value that is written to it in pieces, so that large
+// values can be added to or checked against a filter without holding them in
+// memory contiguously. Calling Add or Check after writing the pieces of a
+// value is equivalent to calling Add or Check of the filter with the
+// concatenation of the pieces.
+//
+// The value length limit, the exclusion set and exact counting of the filter
+// are taken into account as configured when the hasher was created or last
+// reset. A ValueHasher is not safe for concurrent use.
+type ValueHasher struct {
+	filter      *BloomFilter
+	fnv         hash.Hash64
+	length      uint64
+	exact       hash.Hash
+	exclusion   hash.Hash
+	fingerprint []uint64
+}
+
+// NewValueHasher returns a new ValueHasher for the filter.
+func (s *BloomFilter) NewValueHasher() *ValueHasher {
+	h := &ValueHasher{
+		filter:      s,
+		fnv:         fnv.New64(),
+		fingerprint: make([]uint64, s.k),
+	}
+	h.Reset()
+	return h
+}
+
+// Reset discards the value written so far, so that the hasher can be reused
+// for another value.
+func (h *ValueHasher) Reset() {
+	h.fnv.Reset()
+	h.length = 0
+	h.exact = nil
+	if h.filter.exactCounter != nil {
+		h.exact = fnv.New128a()
+	}
+	h.exclusion = nil
+	if len(h.filter.exclusions) > 0 {
+		h.exclusion = sha256.New()
+	}
+}
+
+// Write adds a piece of the value. It never returns an error.
+func (h *ValueHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	if h.filter.maxValueLength > 0 && h.filter.valueLengthPolicy == TruncateValues {
+		if h.length >= h.filter.maxValueLength {
+			p = nil
+		} else if remaining := h.filter.maxValueLength - h.length; uint64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+	}
+	h.length += uint64(n)
+	h.fnv.Write(p)
+	if h.exact != nil {
+		h.exact.Write(p)
+	}
+	if h.exclusion != nil {
+		h.exclusion.Write(p)
+	}
+	return n, nil
+}
+
+// rejected returns true if the value must be rejected due to its length.
+func (h *ValueHasher) rejected() bool {
+	f := h.filter
+	return f.maxValueLength > 0 && f.valueLengthPolicy == RejectValues && h.length > f.maxValueLength
+}
+
+// Add adds the value written so far to the filter.
+func (h *ValueHasher) Add() {
+	if h.rejected() {
+		return
+	}
+	if h.filter.exactCounter != nil && h.exact != nil {
+		h.filter.exactCounter.addHash(h.exact)
+	}
+	h.filter.probeIndexes(h.fnv.Sum64()%m, h.fingerprint)
+	h.filter.AddFingerprint(h.fingerprint)
+}
+
+// Check returns true if the value written so far may be in the filter, false
+// if it is definitely not in it.
+func (h *ValueHasher) Check() bool {
+	if h.rejected() {
+		return false
+	}
+	h.filter.probeIndexes(h.fnv.Sum64()%m, h.fingerprint)
+	if !h.filter.CheckFingerprint(h.fingerprint) {
+		return false
+	}
+	return h.exclusion == nil || !h.filter.excludedHash(h.exclusion)
+}
@@ -0,0 +1,115 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// writeChunked writes the value to the hasher in random pieces.
+func writeChunked(h *ValueHasher, value []byte, rng *rand.Rand) {
+	for len(value) > 0 {
+		n := rng.Intn(len(value) + 1)
+		h.Write(value[:n])
+		value = value[n:]
+	}
+}
+
+func TestValueHasherAdd(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	contiguous := mustNew(10000, 0.001)
+	chunked := mustNew(10000, 0.001)
+	h := chunked.NewValueHasher()
+	for i := 0; i < 1000; i++ {
+		value := GenerateTestValue(uint64(rng.Intn(1000)))
+		contiguous.Add(value)
+		h.Reset()
+		writeChunked(h, value, rng)
+		h.Add()
+	}
+	if !reflect.DeepEqual(contiguous.v, chunked.v) || contiguous.N != chunked.N {
+		t.Fatal("chunked and contiguous values yield different filters")
+	}
+}
+
+func TestValueHasherCheck(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	filter, values := GenerateExampleFilter(10000, 0.01, 1000)
+	h := filter.NewValueHasher()
+	for i := 0; i < 2000; i++ {
+		value := values[i%len(values)]
+		if i >= len(values) {
+			value = GenerateTestValue(uint64(rng.Intn(200)))
+		}
+		h.Reset()
+		writeChunked(h, value, rng)
+		if h.Check() != filter.Check(value) {
+			t.Fatalf("chunked and contiguous checks of %q disagree", value)
+		}
+	}
+}
+
+func TestValueHasherLimitsAndExclusions(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	for _, policy := range []ValueLengthPolicy{RejectValues, TruncateValues} {
+		contiguous := mustNew(1000, 0.001)
+		contiguous.SetMaxValueLength(10, policy)
+		chunked := mustNew(1000, 0.001)
+		chunked.SetMaxValueLength(10, policy)
+		h := chunked.NewValueHasher()
+		for i := 0; i < 100; i++ {
+			value := GenerateTestValue(uint64(rng.Intn(20)))
+			contiguous.Add(value)
+			h.Reset()
+			writeChunked(h, value, rng)
+			h.Add()
+			if h.Check() != contiguous.Check(value) {
+				t.Fatalf("%s: chunked and contiguous checks disagree", policy)
+			}
+		}
+		if !reflect.DeepEqual(contiguous.v, chunked.v) || contiguous.N != chunked.N {
+			t.Fatalf("%s: chunked and contiguous values yield different filters", policy)
+		}
+	}
+
+	filter, values := GenerateExampleFilter(1000, 0.001, 10)
+	var buf bytes.Buffer
+	if err := filter.WriteWithExclusions(&buf, values[:1]); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := loaded.NewValueHasher()
+	for i, value := range values {
+		h.Reset()
+		writeChunked(h, value, rng)
+		if h.Check() != (i > 0) {
+			t.Fatalf("unexpected result for value %d with exclusions", i)
+		}
+	}
+}
+
+func TestValueHasherExactCount(t *testing.T) {
+	filter, _ := New(1000, 0.01, WithExactCounting(0))
+	h := filter.NewValueHasher()
+	for _, v := range []string{"foo", "bar", "foo"} {
+		h.Reset()
+		h.Write([]byte(v[:1]))
+		h.Write([]byte(v[1:]))
+		h.Add()
+	}
+	filter.Add([]byte("bar"))
+	count, err := filter.FinishExactCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != (ExactCount{Distinct: 2, Duplicates: 2}) {
+		t.Fatalf("unexpected count %+v", count)
+	}
+}
@@ -0,0 +1,162 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Metadata keys for the value length limit.
+const (
+	// MetadataKeyMaxValueLength is the maximum value length in bytes.
+	MetadataKeyMaxValueLength = "bloom.max-value-length"
+	// MetadataKeyValueLengthPolicy is the ValueLengthPolicy applied to
+	// longer values ("truncate" or "reject").
+	MetadataKeyValueLengthPolicy = "bloom.value-length-policy"
+)
+
+// ErrValueTooLarge is returned by TryAdd and TryCheck for values exceeding the
+// maximum value length of a filter with the RejectValues policy.
+var ErrValueTooLarge = errors.New("value exceeds maximum length")
+
+// ValueLengthPolicy determines how values exceeding the maximum value length
+// of a filter are handled.
+type ValueLengthPolicy int
+
+const (
+	// RejectValues makes Add ignore values exceeding the maximum length and
+	// Check report them as not contained. TryAdd and TryCheck return
+	// ErrValueTooLarge for them.
+	RejectValues ValueLengthPolicy = iota
+	// TruncateValues makes Add and Check use only the first bytes of values
+	// exceeding the maximum length, up to the maximum length. As both sides
+	// truncate in the same way, values sharing a prefix of the maximum length
+	// are indistinguishable.
+	TruncateValues
+)
+
+func (p ValueLengthPolicy) String() string {
+	switch p {
+	case RejectValues:
+		return "reject"
+	case TruncateValues:
+		return "truncate"
+	}
+	return fmt.Sprintf("ValueLengthPolicy(%d)", int(p))
+}
+
+// ParseValueLengthPolicy parses the string representation of a policy.
+func ParseValueLengthPolicy(s string) (ValueLengthPolicy, error) {
+	switch s {
+	case "reject":
+		return RejectValues, nil
+	case "truncate":
+		return TruncateValues, nil
+	}
+	return RejectValues, fmt.Errorf("invalid value length policy %q (must be 'reject' or 'truncate')", s)
+}
+
+// Option configures a Bloom filter on construction.
+type Option func(*BloomFilter)
+
+// WithMaxValueLength limits the length of values that are added to or checked
+// against the filter; see SetMaxValueLength.
+func WithMaxValueLength(maxLength uint64, policy ValueLengthPolicy) Option {
+	return func(s *BloomFilter) {
+		s.SetMaxValueLength(maxLength, policy)
+	}
+}
+
+// SetMaxValueLength limits the length in bytes of values that are added to or
+// checked against the filter, applying the given policy to longer values. The
+// limit is stored in the metadata, so that filters loaded from a file apply the
+// same policy. A maximum length of zero removes the limit.
+func (s *BloomFilter) SetMaxValueLength(maxLength uint64, policy ValueLengthPolicy) {
+	s.maxValueLength = maxLength
+	s.valueLengthPolicy = policy
+	s.invalidate()
+	if maxLength == 0 {
+		s.DeleteMetadata(MetadataKeyMaxValueLength)
+		s.DeleteMetadata(MetadataKeyValueLengthPolicy)
+		return
+	}
+	s.SetMetadata(MetadataKeyMaxValueLength, strconv.FormatUint(maxLength, 10))
+	s.SetMetadata(MetadataKeyValueLengthPolicy, policy.String())
+}
+
+// MaxValueLength returns the maximum value length in bytes (zero if there is
+// no limit) and the policy applied to longer values.
+func (s *BloomFilter) MaxValueLength() (uint64, ValueLengthPolicy) {
+	return s.maxValueLength, s.valueLengthPolicy
+}
+
+// loadValueLengthLimit restores the value length limit from the metadata.
+func (s *BloomFilter) loadValueLengthLimit() error {
+	s.maxValueLength = 0
+	s.valueLengthPolicy = RejectValues
+	v, ok := s.Metadata(MetadataKeyMaxValueLength)
+	if !ok {
+		return nil
+	}
+	maxLength, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid maximum value length in metadata: %s", err)
+	}
+	policy, err := ParseValueLengthPolicy(s.meta[MetadataKeyValueLengthPolicy])
+	if err != nil {
+		return err
+	}
+	s.maxValueLength = maxLength
+	s.valueLengthPolicy = policy
+	return nil
+}
+
+// rejects returns true if the value must be rejected due to its length.
+func (s *BloomFilter) rejects(value []byte) bool {
+	return s.maxValueLength > 0 && s.valueLengthPolicy == RejectValues &&
+		uint64(len(value)) > s.maxValueLength
+}
+
+// truncate returns the value truncated to the maximum value length if the
+// TruncateValues policy applies.
+func (s *BloomFilter) truncate(value []byte) []byte {
+	if s.maxValueLength > 0 && s.valueLengthPolicy == TruncateValues &&
+		uint64(len(value)) > s.maxValueLength {
+		return value[:s.maxValueLength]
+	}
+	return value
+}
+
+// Rejects returns true if the value is rejected due to its length, i.e. if
+// TryAdd and TryCheck would return an error wrapping ErrValueTooLarge. Unlike
+// them, it does not hash the value.
+func (s *BloomFilter) Rejects(value []byte) bool {
+	return s.rejects(value)
+}
+
+// TryAdd adds a value to the Bloom filter like Add, but returns an error
+// wrapping ErrValueTooLarge if the value is rejected due to its length.
+func (s *BloomFilter) TryAdd(value []byte) error {
+	if s.rejects(value) {
+		return s.valueTooLarge(value)
+	}
+	s.Add(value)
+	return nil
+}
+
+// TryCheck checks a value like Check, but returns an error wrapping
+// ErrValueTooLarge if the value is rejected due to its length.
+func (s *BloomFilter) TryCheck(value []byte) (bool, error) {
+	if s.rejects(value) {
+		return false, s.valueTooLarge(value)
+	}
+	return s.Check(value), nil
+}
+
+// valueTooLarge returns the error for a value rejected due to its length.
+func (s *BloomFilter) valueTooLarge(value []byte) error {
+	return fmt.Errorf("%w (%d > %d bytes)", ErrValueTooLarge, len(value), s.maxValueLength)
+}
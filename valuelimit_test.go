@@ -0,0 +1,122 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMaxValueLengthReject(t *testing.T) {
+	filter, err := New(1000, 0.001, WithMaxValueLength(4, RejectValues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter.Add([]byte("abcd"))
+	filter.Add([]byte("abcdefgh"))
+	if filter.N != 1 {
+		t.Fatalf("long value should not be added (N = %d)", filter.N)
+	}
+	if !filter.Check([]byte("abcd")) {
+		t.Fatal("value within the limit should be found")
+	}
+	if filter.Check([]byte("abcdefgh")) {
+		t.Fatal("long value should be reported as not contained")
+	}
+	if err := filter.TryAdd([]byte("abcde")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge from TryAdd, got %v", err)
+	}
+	if _, err := filter.TryCheck([]byte("abcde")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge from TryCheck, got %v", err)
+	}
+	if ok, err := filter.TryCheck([]byte("abcd")); err != nil || !ok {
+		t.Fatalf("unexpected TryCheck result: %v, %v", ok, err)
+	}
+	if !filter.Rejects([]byte("abcde")) || filter.Rejects([]byte("abcd")) {
+		t.Fatal("Rejects should only reject the long value")
+	}
+	if filter.CheckAny([][]byte{[]byte("abcdefgh")}) {
+		t.Fatal("CheckAny should not match rejected values")
+	}
+}
+
+func TestMaxValueLengthTruncate(t *testing.T) {
+	filter, err := New(1000, 0.001, WithMaxValueLength(4, TruncateValues))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := filter.TryAdd([]byte("abcdefgh")); err != nil || filter.Rejects([]byte("abcdefgh")) {
+		t.Fatalf("truncated values should not be rejected: %v", err)
+	}
+	for _, v := range []string{"abcd", "abcdefgh", "abcdxyz"} {
+		if !filter.Check([]byte(v)) {
+			t.Errorf("value %q sharing the truncated prefix should be found", v)
+		}
+	}
+	if filter.Check([]byte("abc")) {
+		t.Error("shorter prefix should not be found")
+	}
+
+	// adding the truncated value directly yields the same filter
+	other, _ := New(1000, 0.001, WithMaxValueLength(4, TruncateValues))
+	other.Add([]byte("abcd"))
+	if !reflect.DeepEqual(filter.v, other.v) {
+		t.Error("Add should truncate long values consistently with Check")
+	}
+}
+
+func TestMaxValueLengthRoundTrip(t *testing.T) {
+	filter, _ := New(1000, 0.001, WithMaxValueLength(8, TruncateValues))
+	filter.Add([]byte("0123456789"))
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadFromBytes(buf.Bytes(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxLength, policy := loaded.MaxValueLength()
+	if maxLength != 8 || policy != TruncateValues {
+		t.Fatalf("limit does not round-trip: %d, %s", maxLength, policy)
+	}
+	if !loaded.Check([]byte("01234567xx")) {
+		t.Fatal("loaded filter should apply the stored truncation")
+	}
+
+	loaded.SetMaxValueLength(0, RejectValues)
+	if _, ok := loaded.Metadata(MetadataKeyMaxValueLength); ok {
+		t.Fatal("removing the limit should remove its metadata")
+	}
+}
+
+func TestMaxValueLengthJoin(t *testing.T) {
+	a, _ := New(1000, 0.001, WithMaxValueLength(8, RejectValues))
+	b, _ := New(1000, 0.001, WithMaxValueLength(8, TruncateValues))
+	if err := a.Join(b); err == nil {
+		t.Fatal("joining filters with different value length policies should fail")
+	}
+	c, _ := New(1000, 0.001)
+	if err := a.Join(c); err == nil {
+		t.Fatal("joining filters with and without a limit should fail")
+	}
+	d, _ := New(1000, 0.001, WithMaxValueLength(8, RejectValues))
+	if err := a.Join(d); err != nil {
+		t.Fatalf("joining filters with the same limit should succeed: %s", err)
+	}
+}
+
+func TestParseValueLengthPolicy(t *testing.T) {
+	for _, p := range []ValueLengthPolicy{RejectValues, TruncateValues} {
+		parsed, err := ParseValueLengthPolicy(p.String())
+		if err != nil || parsed != p {
+			t.Errorf("policy %s does not round-trip: %s, %v", p, parsed, err)
+		}
+	}
+	if _, err := ParseValueLengthPolicy("drop"); err == nil {
+		t.Error("invalid policy should be rejected")
+	}
+}
@@ -0,0 +1,185 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultVerifyQueueSize is the number of sampled values a VerifiedFilter
+// queues for verification before dropping further ones.
+const DefaultVerifyQueueSize = 1024
+
+// VerifyFunc looks up a value in an authoritative source, e.g. a database,
+// and returns whether it is contained in it.
+type VerifyFunc func(value []byte) (bool, error)
+
+// VerifiedStats counts the positive results of Check sampled by a
+// VerifiedFilter and the outcomes of their verification.
+type VerifiedStats struct {
+	// Sampled is the number of positive results selected for verification.
+	Sampled uint64
+	// Dropped is the number of sampled values dropped as the queue was full.
+	Dropped uint64
+	// Confirmed is the number of verified values contained in the source.
+	Confirmed uint64
+	// Refuted is the number of verified values not contained in the source,
+	// i.e. false positives of the filter.
+	Refuted uint64
+	// Errors is the number of values that could not be verified.
+	Errors uint64
+}
+
+// RefutedFraction returns the fraction of the verified positive results that
+// were refuted, which estimates the fraction of positive results of the filter
+// that are false positives. It is zero if no result was verified yet.
+func (s VerifiedStats) RefutedFraction() float64 {
+	if s.Confirmed+s.Refuted == 0 {
+		return 0
+	}
+	return float64(s.Refuted) / float64(s.Confirmed+s.Refuted)
+}
+
+// VerifyOption configures a VerifiedFilter.
+type VerifyOption func(*verifyOptions)
+
+type verifyOptions struct {
+	queueSize int
+}
+
+// WithVerifyQueueSize sets the number of sampled values queued for
+// verification (DefaultVerifyQueueSize by default). Values sampled while the
+// queue is full are dropped and counted in VerifiedStats.Dropped.
+func WithVerifyQueueSize(n int) VerifyOption {
+	return func(o *verifyOptions) {
+		o.queueSize = n
+	}
+}
+
+// VerifiedFilter checks a sample of the positive results of Check against an
+// authoritative source, to measure the rate of false positives in production.
+// The sampled values are verified by a separate goroutine, so that Check only
+// copies and enqueues them and returns the same result as the underlying
+// filter. Sampling is systematic: for a rate of 0.01, every 100th positive
+// result is verified. A VerifiedFilter is safe for concurrent use, as long as
+// the underlying filter is not modified concurrently (see the package
+// documentation). Close must be called to stop the verifying goroutine.
+type VerifiedFilter struct {
+	// accessed atomically, first words for 64-bit alignment
+	positives uint64
+	stats     VerifiedStats
+
+	filter *BloomFilter
+	verify VerifyFunc
+	rate   float64
+
+	// mu guards closed and sending to queue, so that Close does not close
+	// the queue while Check sends to it
+	mu     sync.RWMutex
+	closed bool
+	queue  chan []byte
+	done   chan struct{}
+}
+
+// NewVerifiedFilter returns a VerifiedFilter for the given filter that
+// verifies the given fraction of the positive results of Check (between 0 and
+// 1) using verify.
+func NewVerifiedFilter(filter *BloomFilter, verify VerifyFunc, rate float64, opts ...VerifyOption) *VerifiedFilter {
+	o := verifyOptions{queueSize: DefaultVerifyQueueSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	v := &VerifiedFilter{
+		filter: filter,
+		verify: verify,
+		rate:   rate,
+		queue:  make(chan []byte, o.queueSize),
+		done:   make(chan struct{}),
+	}
+	go v.run()
+	return v
+}
+
+// Filter returns the underlying filter.
+func (v *VerifiedFilter) Filter() *BloomFilter {
+	return v.filter
+}
+
+// Check returns the same result as Check of the underlying filter. Positive
+// results are sampled for verification.
+func (v *VerifiedFilter) Check(value []byte) bool {
+	if !v.filter.Check(value) {
+		return false
+	}
+	if v.sample() {
+		atomic.AddUint64(&v.stats.Sampled, 1)
+		v.enqueue(append([]byte(nil), value...))
+	}
+	return true
+}
+
+// sample counts a positive result and returns true if it is selected for
+// verification, i.e. if the expected number of sampled results reaches the
+// next integer with it.
+func (v *VerifiedFilter) sample() bool {
+	if v.rate <= 0 {
+		return false
+	}
+	n := atomic.AddUint64(&v.positives, 1)
+	return uint64(float64(n)*v.rate) != uint64(float64(n-1)*v.rate)
+}
+
+// enqueue queues a value for verification, or drops it if the queue is full
+// or the filter is closed.
+func (v *VerifiedFilter) enqueue(value []byte) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if !v.closed {
+		select {
+		case v.queue <- value:
+			return
+		default:
+		}
+	}
+	atomic.AddUint64(&v.stats.Dropped, 1)
+}
+
+// run verifies the queued values until the queue is closed.
+func (v *VerifiedFilter) run() {
+	defer close(v.done)
+	for value := range v.queue {
+		found, err := v.verify(value)
+		switch {
+		case err != nil:
+			atomic.AddUint64(&v.stats.Errors, 1)
+		case found:
+			atomic.AddUint64(&v.stats.Confirmed, 1)
+		default:
+			atomic.AddUint64(&v.stats.Refuted, 1)
+		}
+	}
+}
+
+// Stats returns the counts of sampled and verified results so far.
+func (v *VerifiedFilter) Stats() VerifiedStats {
+	return VerifiedStats{
+		Sampled:   atomic.LoadUint64(&v.stats.Sampled),
+		Dropped:   atomic.LoadUint64(&v.stats.Dropped),
+		Confirmed: atomic.LoadUint64(&v.stats.Confirmed),
+		Refuted:   atomic.LoadUint64(&v.stats.Refuted),
+		Errors:    atomic.LoadUint64(&v.stats.Errors),
+	}
+}
+
+// Close stops sampling and waits until the queued values are verified.
+func (v *VerifiedFilter) Close() {
+	v.mu.Lock()
+	if !v.closed {
+		v.closed = true
+		close(v.queue)
+	}
+	v.mu.Unlock()
+	<-v.done
+}
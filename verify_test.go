@@ -0,0 +1,132 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeSource is an authoritative source containing some of the values of a
+// filter. Its lookups can be blocked to fill the queue.
+type fakeSource struct {
+	mu      sync.Mutex
+	values  map[string]bool
+	checked []string
+	block   chan struct{}
+}
+
+func (f *fakeSource) verify(value []byte) (bool, error) {
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checked = append(f.checked, string(value))
+	if string(value) == "error" {
+		return false, errors.New("lookup failed")
+	}
+	return f.values[string(value)], nil
+}
+
+func TestVerifiedFilter(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	source := &fakeSource{values: make(map[string]bool)}
+	for i := 0; i < 100; i++ {
+		value := fmt.Sprintf("value-%d", i)
+		filter.Add([]byte(value))
+		// the odd values are missing from the source, as if they were false
+		// positives
+		if i%2 == 0 {
+			source.values[value] = true
+		}
+	}
+	filter.Add([]byte("error"))
+
+	v := NewVerifiedFilter(filter, source.verify, 0.25)
+	for i := 0; i < 100; i++ {
+		if !v.Check([]byte(fmt.Sprintf("value-%d", i))) {
+			t.Fatalf("value-%d not found", i)
+		}
+		if v.Check([]byte(fmt.Sprintf("missing-%d", i))) {
+			t.Fatalf("missing-%d found", i)
+		}
+	}
+	for i := 0; i < 4; i++ {
+		v.Check([]byte("error"))
+	}
+	v.Close()
+
+	// every 4th positive result is verified: value-3, value-7, ..., value-99
+	// and the last of the errors
+	stats := v.Stats()
+	expected := VerifiedStats{Sampled: 26, Confirmed: 0, Refuted: 25, Errors: 1}
+	if stats != expected {
+		t.Fatalf("unexpected stats %+v, expected %+v", stats, expected)
+	}
+	if len(source.checked) != 26 || source.checked[0] != "value-3" {
+		t.Fatalf("unexpected values verified: %v", source.checked)
+	}
+	if stats.RefutedFraction() != 1 {
+		t.Fatalf("unexpected refuted fraction %g", stats.RefutedFraction())
+	}
+
+	// with a rate of 1, each positive result is verified
+	source.checked = nil
+	v = NewVerifiedFilter(filter, source.verify, 1)
+	for i := 0; i < 10; i++ {
+		v.Check([]byte(fmt.Sprintf("value-%d", i)))
+	}
+	v.Close()
+	if stats := v.Stats(); stats.Confirmed != 5 || stats.Refuted != 5 || stats.RefutedFraction() != 0.5 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// sampling stops once closed
+	v.Check([]byte("value-0"))
+	if stats := v.Stats(); stats.Dropped != 1 || len(source.checked) != 10 {
+		t.Fatalf("unexpected stats after Close: %+v", stats)
+	}
+}
+
+func TestVerifiedFilterQueueFull(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.Add([]byte("value"))
+	source := &fakeSource{values: map[string]bool{"value": true}, block: make(chan struct{})}
+	v := NewVerifiedFilter(filter, source.verify, 1, WithVerifyQueueSize(2))
+
+	// the first value is taken from the queue by the blocked verifier, which
+	// may not have happened yet, so two or three values fit
+	for i := 0; i < 10; i++ {
+		if !v.Check([]byte("value")) {
+			t.Fatal("value not found")
+		}
+	}
+	stats := v.Stats()
+	if stats.Sampled != 10 || stats.Dropped < 7 || stats.Dropped > 8 {
+		t.Fatalf("unexpected stats with a full queue: %+v", stats)
+	}
+	close(source.block)
+	v.Close()
+	stats = v.Stats()
+	if stats.Confirmed+stats.Dropped != 10 || stats.Refuted != 0 {
+		t.Fatalf("unexpected stats after the queue was drained: %+v", stats)
+	}
+}
+
+func TestVerifiedFilterDisabled(t *testing.T) {
+	filter := mustNew(1000, 0.0001)
+	filter.Add([]byte("value"))
+	source := &fakeSource{}
+	v := NewVerifiedFilter(filter, source.verify, 0)
+	if !v.Check([]byte("value")) || v.Check([]byte("other")) {
+		t.Fatal("unexpected results")
+	}
+	v.Close()
+	if stats := v.Stats(); stats != (VerifiedStats{}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
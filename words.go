@@ -0,0 +1,56 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+// NumWords returns the number of 64-bit words of the bit array of the filter,
+// i.e. ceil(NumBits()/64).
+func (s *BloomFilter) NumWords() uint64 {
+	return s.M
+}
+
+// VisitWords calls fn with the index and value of each 64-bit word of the bit
+// array of the filter, in order, without copying it, until fn returns false.
+// Bit i of the filter is bit i%64 (counting from the least significant bit)
+// of word i/64. The results are undefined if the filter is modified
+// concurrently.
+func (s *BloomFilter) VisitWords(fn func(index uint64, word uint64) bool) {
+	for i, word := range s.v {
+		if !fn(uint64(i), word) {
+			return
+		}
+	}
+}
+
+// WordAt returns the 64-bit word of the bit array at the given index, see
+// VisitWords. It panics if the index is not less than NumWords.
+func (s *BloomFilter) WordAt(i uint64) uint64 {
+	return s.v[i]
+}
+
+// MergeWords sets the bits of the given words in the words of the bit array
+// starting at the given index, e.g. words fetched from a filter with the same
+// dimensions, and returns the number of words that changed. If replace is
+// true, the words are replaced instead, which may also clear bits. The element
+// count is left unaltered (see EstimatedNumElements). It panics if the words
+// extend beyond NumWords.
+func (s *BloomFilter) MergeWords(index uint64, words []uint64, replace bool) uint64 {
+	dst := s.v[index : index+uint64(len(words))]
+	var changed uint64
+	for i, word := range dst {
+		merged := words[i]
+		if !replace {
+			merged |= word
+		}
+		if merged != word {
+			dst[i] = merged
+			changed++
+		}
+	}
+	if changed > 0 {
+		s.invalidate()
+		s.dropExactCount()
+		s.changes++
+	}
+	return changed
+}
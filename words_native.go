@@ -0,0 +1,67 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build !purego
+// +build !purego
+
+package bloom
+
+import (
+	"io"
+	"unsafe"
+)
+
+// hostBigEndian is true if the host stores words in big-endian byte order.
+var hostBigEndian = func() bool {
+	word := uint16(1)
+	return *(*byte)(unsafe.Pointer(&word)) == 0
+}()
+
+// wordBytes returns the memory of at most wordChunkSize words as bytes, in
+// the byte order of the host.
+func wordBytes(words []uint64) []byte {
+	if len(words) == 0 {
+		return nil
+	}
+	size := FormatWordSize * len(words)
+	return (*[FormatWordSize * wordChunkSize]byte)(unsafe.Pointer(&words[0]))[:size:size]
+}
+
+// readWords reads the little-endian words of a bit array from r directly into
+// their memory, swapping their bytes afterwards on big-endian hosts.
+func readWords(r io.Reader, words []uint64) error {
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordChunkSize {
+			chunk = chunk[:wordChunkSize]
+		}
+		if _, err := io.ReadFull(r, wordBytes(chunk)); err != nil {
+			return err
+		}
+		if hostBigEndian {
+			swapWords(chunk)
+		}
+		words = words[len(chunk):]
+	}
+	return nil
+}
+
+// writeWords writes the words of a bit array to w in little-endian byte
+// order, directly from their memory on little-endian hosts. The words are not
+// modified, so that they can be checked concurrently.
+func writeWords(w io.Writer, words []uint64) error {
+	if hostBigEndian {
+		return writeWordsPortable(w, words)
+	}
+	for len(words) > 0 {
+		chunk := words
+		if len(chunk) > wordChunkSize {
+			chunk = chunk[:wordChunkSize]
+		}
+		if _, err := w.Write(wordBytes(chunk)); err != nil {
+			return err
+		}
+		words = words[len(chunk):]
+	}
+	return nil
+}
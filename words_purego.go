@@ -0,0 +1,20 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+//go:build purego
+// +build purego
+
+package bloom
+
+import "io"
+
+// readWords reads the little-endian words of a bit array from r.
+func readWords(r io.Reader, words []uint64) error {
+	return readWordsPortable(r, words)
+}
+
+// writeWords writes the words of a bit array to w in little-endian byte
+// order.
+func writeWords(w io.Writer, words []uint64) error {
+	return writeWordsPortable(w, words)
+}
@@ -0,0 +1,96 @@
+// DCSO go bloom filter
+// Copyright (c) 2017, DCSO GmbH
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestVisitWords(t *testing.T) {
+	filter, _ := GenerateExampleFilter(10000, 0.001, 1000)
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	serialized := buf.Bytes()[FormatHeaderSize:]
+
+	// reconstruct the serialized bit array
+	bits := make([]byte, 0, filter.NumWords()*FormatWordSize)
+	word := make([]byte, FormatWordSize)
+	next := uint64(0)
+	filter.VisitWords(func(index uint64, w uint64) bool {
+		if index != next {
+			t.Fatalf("unexpected index %d, expected %d", index, next)
+		}
+		next++
+		binary.LittleEndian.PutUint64(word, w)
+		bits = append(bits, word...)
+		if w != filter.WordAt(index) {
+			t.Fatalf("WordAt(%d) differs from the visited word", index)
+		}
+		return true
+	})
+	if next != filter.NumWords() || filter.NumWords() != (filter.NumBits()+63)/64 {
+		t.Fatalf("visited %d of %d words", next, filter.NumWords())
+	}
+	if !bytes.Equal(bits, serialized[:len(bits)]) {
+		t.Fatal("reconstructed bit array differs from the serialized one")
+	}
+
+	// the iteration stops when fn returns false
+	visited := 0
+	filter.VisitWords(func(index uint64, w uint64) bool {
+		visited++
+		return index < 9
+	})
+	if visited != 10 {
+		t.Fatalf("expected the iteration to stop after 10 words, visited %d", visited)
+	}
+}
+
+func TestWordLayout32(t *testing.T) {
+	filter, values := GenerateExampleFilter(10000, 0.001, 1000)
+	var buf bytes.Buffer
+	if err := filter.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the bit array read as 32-bit words, as on targets without 64-bit
+	// arithmetic, holds the same bits at the same indexes
+	serialized := buf.Bytes()[FormatHeaderSize : FormatHeaderSize+filter.NumWords()*FormatWordSize]
+	bit := func(i uint64) bool {
+		return binary.LittleEndian.Uint32(serialized[i/32*4:])&(1<<(i%32)) != 0
+	}
+	for i := uint64(0); i < filter.NumBits(); i++ {
+		if bit(i) != (filter.WordAt(i/64)&(1<<(i%64)) != 0) {
+			t.Fatalf("bit %d differs between 32-bit and 64-bit words", i)
+		}
+	}
+	fingerprint := make([]uint64, filter.NumHashFuncs())
+	for _, v := range values {
+		filter.Fingerprint(v, fingerprint)
+		for _, i := range fingerprint {
+			if !bit(i) {
+				t.Fatalf("bit %d of value %q not set in 32-bit words", i, v)
+			}
+		}
+	}
+}
+
+func TestMergeWords(t *testing.T) {
+	filter := mustNew(1000, 0.01)
+	if changed := filter.MergeWords(1, []uint64{0x5, 0, 0x1}, false); changed != 2 {
+		t.Fatalf("expected 2 changed words, got %d", changed)
+	}
+	if changed := filter.MergeWords(1, []uint64{0x3}, false); changed != 1 || filter.WordAt(1) != 0x7 {
+		t.Fatalf("unexpected merge result %d, %#x", changed, filter.WordAt(1))
+	}
+	if changed := filter.MergeWords(1, []uint64{0x7, 0x8}, true); changed != 1 || filter.WordAt(2) != 0x8 {
+		t.Fatalf("unexpected replace result %d, %#x", changed, filter.WordAt(2))
+	}
+	if changed := filter.MergeWords(3, []uint64{0}, true); changed != 1 || filter.NumSetBits() != 4 {
+		t.Fatalf("unexpected replace result %d, %d bits set", changed, filter.NumSetBits())
+	}
+}